@@ -6,28 +6,76 @@ package main
 // Import the "fmt" package, which provides functions for formatted I/O
 // (like printing to the console).
 import (
+	"fmt"
 	"log"
 	"os"
 
 	"github.com/urfave/cli/v2"
 
 	"github.com/nightconcept/almandine-go/internal/cli/add"
+	"github.com/nightconcept/almandine-go/internal/cli/cache"
+	"github.com/nightconcept/almandine-go/internal/cli/info"
 	"github.com/nightconcept/almandine-go/internal/cli/initcmd"
 	"github.com/nightconcept/almandine-go/internal/cli/install" // Changed from update to install
 	"github.com/nightconcept/almandine-go/internal/cli/list"
+	"github.com/nightconcept/almandine-go/internal/cli/lock"
+	"github.com/nightconcept/almandine-go/internal/cli/outdated"
+	"github.com/nightconcept/almandine-go/internal/cli/output"
+	"github.com/nightconcept/almandine-go/internal/cli/pin"
 	"github.com/nightconcept/almandine-go/internal/cli/remove"
+	"github.com/nightconcept/almandine-go/internal/cli/rename"
+	"github.com/nightconcept/almandine-go/internal/cli/run"
 	"github.com/nightconcept/almandine-go/internal/cli/self"
+	"github.com/nightconcept/almandine-go/internal/cli/update"
+	"github.com/nightconcept/almandine-go/internal/cli/verify"
+	"github.com/nightconcept/almandine-go/internal/cli/versioncmd"
+	"github.com/nightconcept/almandine-go/internal/cli/why"
+	"github.com/nightconcept/almandine-go/internal/core/config"
+	"github.com/nightconcept/almandine-go/internal/core/githost"
 )
 
-// version is the application version, set at build time.
-var version = "dev" // Default to "dev" if not set by ldflags
+// Build details, set at build time, e.g.
+// go build -ldflags "-X main.version=v1.2.3 -X main.commit=$(git rev-parse --short HEAD) -X main.date=$(date -u +%Y-%m-%dT%H:%M:%SZ)" ./cmd/almd
+var (
+	version = "dev" // Default to "dev" if not set by ldflags
+	commit  = ""
+	date    = ""
+)
 
-// The main function, where the program execution begins.
-func main() {
-	app := &cli.App{
+// newApp builds the almd application with every command registered.
+func newApp() *cli.App {
+	return &cli.App{
 		Name:    "almd",
 		Usage:   "A simple project manager for single-file dependencies",
 		Version: version,
+		Flags: []cli.Flag{
+			&cli.StringFlag{
+				Name:    "cwd",
+				Aliases: []string{"C"},
+				Usage:   "Run as if almd was started in `DIR`; project.toml, almd-lock.toml and dependency paths resolve there",
+			},
+			output.ColorFlag(),
+		},
+		Before: func(c *cli.Context) error {
+			if err := output.SetColorMode(c.String("color")); err != nil {
+				return cli.Exit(fmt.Sprintf("Error: %v.", err), 1)
+			}
+			// Every command resolves the project relative to the working directory, so --cwd
+			// only has to move it.
+			if dir := c.String("cwd"); dir != "" {
+				if err := os.Chdir(dir); err != nil {
+					return cli.Exit(fmt.Sprintf("Error: Cannot use --cwd '%s': %v", dir, err), 1)
+				}
+			}
+			// A self-hosted GitHub host configured in project.toml applies to every command.
+			if proj, err := config.LoadProjectToml("."); err == nil {
+				githost.Configure(proj.GithubHostSettings())
+			}
+			if err := githost.Validate(); err != nil {
+				return cli.Exit(fmt.Sprintf("Error: %v. Check %s or github_host in %s.", err, githost.HostEnv, config.ProjectTomlName), 1)
+			}
+			return nil
+		},
 		Action: func(c *cli.Context) error {
 			// Default action if no command is specified
 			_ = cli.ShowAppHelp(c)
@@ -37,13 +85,29 @@ func main() {
 			initcmd.GetInitCommand(),
 			add.AddCommand,
 			remove.RemoveCommand(),
+			remove.PruneCommand(),
+			rename.NewRenameCommand(),
 			install.NewInstallCommand(), // Changed from update.NewUpdateCommand()
+			update.NewUpdateCommand(),
+			lock.NewLockCommand(),
 			list.ListCmd,
+			list.TreeCmd,
+			info.NewInfoCommand(),
+			why.NewWhyCommand(),
+			pin.NewPinCommand(),
+			outdated.NewOutdatedCommand(),
+			verify.NewVerifyCommand(),
+			run.NewRunCommand(),
+			cache.NewCacheCommand(),
 			self.NewSelfCommand(),
+			versioncmd.NewVersionCommand(versioncmd.BuildInfo{Version: version, Commit: commit, Date: date}),
 		},
 	}
+}
 
-	if err := app.Run(os.Args); err != nil {
+// The main function, where the program execution begins.
+func main() {
+	if err := newApp().Run(os.Args); err != nil {
 		log.Fatal(err)
 	}
 }
@@ -0,0 +1,169 @@
+package main
+
+import (
+	"bytes"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/urfave/cli/v2"
+
+	"github.com/nightconcept/almandine-go/internal/cli/output"
+	"github.com/nightconcept/almandine-go/internal/core/ghauth"
+	"github.com/nightconcept/almandine-go/internal/core/githost"
+)
+
+func TestApp_Cwd(t *testing.T) {
+	originalWd, err := os.Getwd()
+	require.NoError(t, err)
+	t.Cleanup(func() { require.NoError(t, os.Chdir(originalWd)) })
+
+	projectDir := filepath.Join(t.TempDir(), "packages", "game")
+	require.NoError(t, os.MkdirAll(filepath.Join(projectDir, "libs"), 0755))
+	require.NoError(t, os.WriteFile(filepath.Join(projectDir, "project.toml"), []byte(`
+[package]
+name = "game"
+version = "0.1.0"
+
+[dependencies]
+one = { source = "github:user/repo/one.lua@main", path = "libs/one.lua" }
+two = { source = "github:user/repo/two.lua@main", path = "libs/two.lua" }
+`), 0644))
+	require.NoError(t, os.WriteFile(filepath.Join(projectDir, "libs", "one.lua"), []byte("-- one"), 0644))
+	require.NoError(t, os.WriteFile(filepath.Join(projectDir, "libs", "two.lua"), []byte("-- two"), 0644))
+
+	run := func(args ...string) (string, error) {
+		// Each run starts from a directory that is not the project.
+		require.NoError(t, os.Chdir(originalWd))
+		var stdout bytes.Buffer
+		app := newApp()
+		app.Writer, app.ErrWriter = &stdout, io.Discard
+		app.ExitErrHandler = func(*cli.Context, error) {}
+		err := app.Run(append([]string{"almd"}, args...))
+		return stdout.String(), err
+	}
+
+	out, err := run("--cwd", projectDir, "list", "--json")
+	require.NoError(t, err)
+	assert.Contains(t, out, `"one"`)
+	assert.Contains(t, out, `"two"`)
+
+	_, err = run("-C", projectDir, "remove", "one")
+	require.NoError(t, err)
+	assert.NoFileExists(t, filepath.Join(projectDir, "libs", "one.lua"), "dependency paths resolve against --cwd")
+	assert.FileExists(t, filepath.Join(projectDir, "libs", "two.lua"))
+
+	_, err = run("--cwd", filepath.Join(projectDir, "missing"), "list")
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "Cannot use --cwd")
+}
+
+func TestApp_Color(t *testing.T) {
+	originalWd, err := os.Getwd()
+	require.NoError(t, err)
+	t.Cleanup(func() { require.NoError(t, os.Chdir(originalWd)) })
+	t.Cleanup(func() { _ = output.SetColorMode("auto") })
+	t.Setenv("NO_COLOR", "1")
+
+	projectDir := t.TempDir()
+	require.NoError(t, os.WriteFile(filepath.Join(projectDir, "project.toml"), []byte(`
+[package]
+name = "game"
+version = "0.1.0"
+
+[dependencies]
+one = { source = "github:user/repo/one.lua@main", path = "libs/one.lua" }
+`), 0644))
+
+	run := func(args ...string) (string, error) {
+		require.NoError(t, os.Chdir(originalWd))
+		var stdout bytes.Buffer
+		app := newApp()
+		app.Writer, app.ErrWriter = &stdout, io.Discard
+		app.ExitErrHandler = func(*cli.Context, error) {}
+		err := app.Run(append([]string{"almd", "-C", projectDir}, args...))
+		return stdout.String(), err
+	}
+
+	out, err := run("--color", "always", "list")
+	require.NoError(t, err)
+	assert.Contains(t, out, "\x1b[", "--color=always wins over NO_COLOR")
+
+	out, err = run("--color", "never", "list")
+	require.NoError(t, err)
+	assert.Contains(t, out, "one")
+	assert.NotContains(t, out, "\x1b[")
+
+	_, err = run("--color", "sometimes", "list")
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "invalid --color 'sometimes'")
+}
+
+func TestApp_ProjectGithubHostGetsNoToken(t *testing.T) {
+	originalWd, err := os.Getwd()
+	require.NoError(t, err)
+	t.Cleanup(func() { require.NoError(t, os.Chdir(originalWd)) })
+	t.Cleanup(func() { githost.Configure("", "") })
+	t.Setenv(ghauth.TokenEnv, "secret-token")
+	t.Setenv(ghauth.NoCredentialHelperEnv, "1")
+	t.Setenv(githost.HostEnv, "")
+	t.Setenv(githost.APIURLEnv, "")
+
+	var mu sync.Mutex
+	var authHeaders []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		authHeaders = append(authHeaders, r.Header.Get("Authorization"))
+		mu.Unlock()
+		if strings.HasPrefix(r.URL.Path, "/api/v3/repos/owner/repo/commits") {
+			_, _ = w.Write([]byte(`[{"sha": "0123456789abcdef0123456789abcdef01234567"}]`))
+			return
+		}
+		_, _ = w.Write([]byte("return {}"))
+	}))
+	t.Cleanup(server.Close)
+
+	run := func() {
+		t.Helper()
+		projectDir := t.TempDir()
+		require.NoError(t, os.WriteFile(filepath.Join(projectDir, "project.toml"), []byte(`
+[package]
+name = "game"
+version = "0.1.0"
+
+[almd]
+github_host = "`+server.URL+`"
+`), 0644))
+		require.NoError(t, os.Chdir(originalWd))
+		app := newApp()
+		app.Writer, app.ErrWriter = io.Discard, io.Discard
+		app.ExitErrHandler = func(*cli.Context, error) {}
+		require.NoError(t, app.Run([]string{"almd", "--cwd", projectDir, "add", "github:owner/repo/lib.lua@main"}))
+	}
+
+	run()
+	mu.Lock()
+	require.NotEmpty(t, authHeaders, "github: sources resolve against the host in project.toml")
+	for _, header := range authHeaders {
+		assert.Empty(t, header, "a host set only in project.toml must not receive the token")
+	}
+	authHeaders = nil
+	mu.Unlock()
+
+	// The same host chosen in the environment is trusted with the token.
+	t.Setenv(githost.HostEnv, server.URL)
+	run()
+	mu.Lock()
+	defer mu.Unlock()
+	require.NotEmpty(t, authHeaders)
+	for _, header := range authHeaders {
+		assert.Equal(t, "Bearer secret-token", header)
+	}
+}
@@ -5,19 +5,26 @@
 package add
 
 import (
+	"bytes"
 	"fmt"
 	"io"
 	"os"
 	"path/filepath"
+	"regexp"
+	"sort"
 	"strings"
 	"time"
 
 	"github.com/fatih/color"
+	"github.com/nightconcept/almandine-go/internal/cli/output"
+	"github.com/nightconcept/almandine-go/internal/cli/prompt"
 	"github.com/nightconcept/almandine-go/internal/core/config"
 	"github.com/nightconcept/almandine-go/internal/core/downloader"
+	"github.com/nightconcept/almandine-go/internal/core/fsutil"
 	"github.com/nightconcept/almandine-go/internal/core/hasher"
 	"github.com/nightconcept/almandine-go/internal/core/lockfile"
 	"github.com/nightconcept/almandine-go/internal/core/project"
+	"github.com/nightconcept/almandine-go/internal/core/registry"
 	"github.com/nightconcept/almandine-go/internal/core/source"
 	"github.com/urfave/cli/v2"
 )
@@ -27,305 +34,1015 @@ func getFileNameWithoutExtension(fileName string) string {
 	return strings.TrimSuffix(fileName, filepath.Ext(fileName))
 }
 
-// Helper function to get file extension
-func getFileExtension(fileName string) string {
-	return filepath.Ext(fileName)
-}
+// defaultNamePattern matches the LDoc-style "@module <name>" declaration that many
+// single-file Lua libraries carry in their header comment.
+const defaultNamePattern = `@module\s+([A-Za-z0-9_.\-]+)`
 
-// AddCommand defines the structure for the "add" command.
-var AddCommand = &cli.Command{
-	Name:      "add",
-	Usage:     "Downloads a dependency and adds it to the project",
-	ArgsUsage: "<source_url>",
-	Flags: []cli.Flag{
-		&cli.StringFlag{
-			Name:    "directory",
-			Aliases: []string{"d"},
-			Usage:   "Specify the target directory for the dependency",
-			Value:   "src/lib/",
-		},
-		&cli.StringFlag{
-			Name:    "name",
-			Aliases: []string{"n"},
-			Usage:   "Specify the name for the dependency (defaults to filename from URL)",
-		},
-		&cli.BoolFlag{
-			Name:  "verbose",
-			Usage: "Enable verbose output",
-		},
-	},
-	Action: func(cCtx *cli.Context) (err error) { // MODIFIED: Named return error
-		startTime := time.Now()
-		sourceURLInput := ""
-		if cCtx.NArg() > 0 {
-			sourceURLInput = cCtx.Args().Get(0) // .First() is equivalent but .Get(0) is more explicit
-		} else {
-			err = cli.Exit("Error: <source_url> argument is required.", 1) // MODIFIED
-			return
+// nameFromContentMaxLines bounds how far into a downloaded file --name-from-content looks.
+const nameFromContentMaxLines = 20
+
+// nameFromContent searches the first nameFromContentMaxLines lines of content for pattern.
+// The first capture group is used as the name if the pattern has one, otherwise the whole match.
+// It returns an empty string if nothing matches.
+func nameFromContent(content []byte, pattern *regexp.Regexp) string {
+	lines := strings.SplitN(string(content), "\n", nameFromContentMaxLines+1)
+	if len(lines) > nameFromContentMaxLines {
+		lines = lines[:nameFromContentMaxLines]
+	}
+	for _, line := range lines {
+		match := pattern.FindStringSubmatch(line)
+		if match == nil {
+			continue
+		}
+		name := match[0]
+		if len(match) > 1 {
+			name = match[1]
 		}
+		if name = strings.TrimSpace(name); name != "" {
+			return name
+		}
+	}
+	return ""
+}
+
+// defaultPreviewLines is how many lines of the downloaded file --interactive shows by default.
+const defaultPreviewLines = 20
 
-		targetDir := cCtx.String("directory")
-		customName := cCtx.String("name")
-		verbose := cCtx.Bool("verbose")
+// writePreview writes the first maxLines lines of content to w (all of it if maxLines <= 0),
+// followed by a note of how many lines were left out.
+func writePreview(w io.Writer, content []byte, maxLines int) {
+	text := strings.TrimSuffix(string(content), "\n")
+	lines := strings.Split(text, "\n")
+	shown := lines
+	if maxLines > 0 && len(lines) > maxLines {
+		shown = lines[:maxLines]
+	}
+	for _, line := range shown {
+		_, _ = fmt.Fprintf(w, "  | %s\n", line)
+	}
+	if hidden := len(lines) - len(shown); hidden > 0 {
+		_, _ = fmt.Fprintf(w, "  | ... (%d more lines)\n", hidden)
+	}
+}
 
-		// Silence default verbose output, will be replaced by pnpm style
-		_ = verbose // Keep verbose for potential future use or more detailed debugging
+// isBareName reports whether arg is a plain name rather than a URL or source shorthand,
+// making it a candidate for registry lookup.
+func isBareName(arg string) bool {
+	return !strings.ContainsAny(arg, ":/")
+}
 
-		// Task 2.2: Parse the source URL
-		var parsedInfo *source.ParsedSourceInfo
-		parsedInfo, err = source.ParseSourceURL(sourceURLInput) // Assign to named return 'err'
-		if err != nil {
-			err = cli.Exit(fmt.Sprintf("Error parsing source URL '%s': %v", sourceURLInput, err), 1) // MODIFIED
-			return
-		}
+// restoreFromLockfile re-creates the project.toml entry for name from its almd-lock.toml entry,
+// for dependencies removed from the manifest by mistake. The source the user originally
+// requested is preferred; otherwise it is derived from the locked raw URL, which pins the
+// resolved commit. The entry goes back into the group the lockfile recorded. Nothing is
+// downloaded.
+func restoreFromLockfile(stdout io.Writer, projectRoot string, proj *project.Project, name string) error {
+	if _, _, exists := proj.LookupDependency(name); exists {
+		return cli.Exit(fmt.Sprintf("Error: Dependency '%s' is already in %s.", name, config.ProjectTomlName), 1)
+	}
+	lf, err := lockfile.Load(projectRoot)
+	if err != nil {
+		return cli.Exit(fmt.Sprintf("Error loading %s: %v", lockfile.LockfileName, err), 1)
+	}
+	entry, ok := lf.Package[name]
+	if !ok {
+		return cli.Exit(fmt.Sprintf("Error: Dependency '%s' not found in %s.", name, lockfile.LockfileName), 1)
+	}
 
-		if verbose {
-			fmt.Printf("Parsed Source Info:\n")
-			fmt.Printf("  Raw Download URL: %s\n", parsedInfo.RawURL)
-			fmt.Printf("  Canonical URL for Manifest: %s\n", parsedInfo.CanonicalURL)
-			fmt.Printf("  Extracted Ref (commit/branch/tag): %s\n", parsedInfo.Ref)
-			fmt.Printf("  Suggested Filename from URL: %s\n", parsedInfo.SuggestedFilename)
+	canonicalSource := entry.Source
+	for _, candidate := range []string{entry.Requested, entry.Source} {
+		if candidate == "" || isBareName(candidate) {
+			continue
+		}
+		if parsed, parseErr := source.ParseSourceURL(candidate); parseErr == nil {
+			canonicalSource = parsed.CanonicalURL
+			break
 		}
+	}
 
-		// Task 2.3: Download the file using the RawURL
-		if verbose {
-			fmt.Printf("Downloading from %s...\n", parsedInfo.RawURL)
+	proj.SetDependency(name, entry.Group, project.Dependency{Source: canonicalSource, Path: entry.Path})
+	if err := config.WriteProjectToml(projectRoot, proj); err != nil {
+		return cli.Exit(fmt.Sprintf("Error writing %s: %v", config.ProjectTomlName, err), 1)
+	}
+
+	_, _ = fmt.Fprintf(stdout, "Restored %s to %s (source %s, path %s).\n", name, config.ProjectTomlName, canonicalSource, entry.Path)
+	if _, statErr := os.Stat(filepath.Join(projectRoot, project.NativePath(entry.Path))); os.IsNotExist(statErr) {
+		_, _ = fmt.Fprintf(stdout, "%s is missing; run 'almd install %s' to download it.\n", entry.Path, name)
+	}
+	return nil
+}
+
+// findSameSource returns the name of a dependency other than exclude whose source resolves to
+// canonicalURL. Manifest sources are re-parsed so that older or hand-written URL forms compare
+// by their canonical form. Names are checked in sorted order so the result is deterministic.
+func findSameSource(proj *project.Project, exclude, canonicalURL string) (string, bool) {
+	deps := proj.AllDependencies()
+	names := make([]string, 0, len(deps))
+	for name := range deps {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		if name == exclude {
+			continue
 		}
-		var fileContent []byte
-		fileContent, err = downloader.DownloadFile(parsedInfo.RawURL) // Assign to named return 'err'
-		if err != nil {
-			err = cli.Exit(fmt.Sprintf("Error downloading file from '%s': %v", parsedInfo.RawURL, err), 1) // MODIFIED
-			return
+		existing := deps[name].Source
+		if existing == canonicalURL {
+			return name, true
 		}
-		if verbose {
-			fmt.Printf("Downloaded %d bytes successfully.\n", len(fileContent))
+		if parsed, parseErr := source.ParseSourceURL(existing); parseErr == nil && parsed.CanonicalURL == canonicalURL {
+			return name, true
 		}
+	}
+	return "", false
+}
 
-		// Task 2.4: Determine target path and save file
-		var dependencyNameInManifest string
-		var fileNameOnDisk string
-
-		suggestedBaseName := getFileNameWithoutExtension(parsedInfo.SuggestedFilename)
-		suggestedExtension := getFileExtension(parsedInfo.SuggestedFilename)
+// findSamePath returns the name of a dependency other than exclude that is saved at path.
+// Names are checked in sorted order so the result is deterministic.
+func findSamePath(proj *project.Project, exclude, path string) (string, bool) {
+	deps := proj.AllDependencies()
+	names := make([]string, 0, len(deps))
+	for name := range deps {
+		names = append(names, name)
+	}
+	sort.Strings(names)
 
-		if customName != "" {
-			dependencyNameInManifest = customName
-			fileNameOnDisk = customName + suggestedExtension // Ensure extension is preserved
-		} else {
-			if suggestedBaseName == "" || suggestedBaseName == "." || suggestedBaseName == "/" {
-				err = cli.Exit(fmt.Sprintf("Error: Could not infer a valid base filename from URL's suggested filename: '%s'. Use -n to specify a name.", parsedInfo.SuggestedFilename), 1) // MODIFIED
-				return
-			}
-			dependencyNameInManifest = suggestedBaseName
-			fileNameOnDisk = parsedInfo.SuggestedFilename
+	for _, name := range names {
+		if name != exclude && filepath.ToSlash(filepath.Clean(deps[name].Path)) == path {
+			return name, true
 		}
+	}
+	return "", false
+}
 
-		if fileNameOnDisk == "" || fileNameOnDisk == "." || fileNameOnDisk == "/" {
-			err = cli.Exit("Error: Could not determine a valid final filename for saving. Inferred name was empty or invalid.", 1) // MODIFIED
-			return
+// findIdenticalContent looks through the existing lockfile for a dependency other than
+// exclude whose content matches content. Entries locked by content hash are compared by hash;
+// commit-locked entries are compared by hashing their file on disk. Matches are checked in
+// name order so the result is deterministic.
+func findIdenticalContent(projectRoot, exclude string, content []byte) (string, lockfile.PackageEntry, bool) {
+	lf, err := lockfile.Load(projectRoot)
+	if err != nil || len(lf.Package) == 0 {
+		return "", lockfile.PackageEntry{}, false
+	}
+	names := make([]string, 0, len(lf.Package))
+	for name := range lf.Package {
+		if name != exclude {
+			names = append(names, name)
 		}
+	}
+	sort.Strings(names)
 
-		if verbose {
-			fmt.Printf("Effective filename for saving: %s\n", fileNameOnDisk)
-			fmt.Printf("Dependency name in manifest/lockfile: %s\n", dependencyNameInManifest)
+	for _, name := range names {
+		entry := lf.Package[name]
+		// A content-hash entry is compared under its own algorithm, whatever the default is.
+		if _, ok := hasher.Algorithm(entry.Hash); ok {
+			if match, matchErr := hasher.Matches(content, entry.Hash); matchErr == nil && match {
+				return name, entry, true
+			}
+			continue
+		}
+		existing, readErr := os.ReadFile(filepath.Join(projectRoot, project.NativePath(entry.Path)))
+		if readErr != nil {
+			continue
+		}
+		if bytes.Equal(existing, content) {
+			return name, entry, true
 		}
+	}
+	return "", lockfile.PackageEntry{}, false
+}
 
-		// Construct the full path relative to the current directory (project root)
-		projectRoot := "."
-		fullPath := filepath.Join(projectRoot, targetDir, fileNameOnDisk)
-		relativeDestPath := filepath.ToSlash(filepath.Join(targetDir, fileNameOnDisk))
+// dependencyName picks the manifest key for a source: --name, else the name declared in content
+// when --name-from-content is set, else the filename without its extension. An inferred name is
+// made into a valid manifest key; --name was validated up front.
+func dependencyName(stderr io.Writer, opts addOptions, parsedInfo *source.ParsedSourceInfo, content []byte) (string, error) {
+	if opts.customName != "" {
+		return opts.customName, nil
+	}
+	suggestedBaseName := getFileNameWithoutExtension(parsedInfo.SuggestedFilename)
+	if suggestedBaseName == "" || suggestedBaseName == "." || suggestedBaseName == "/" {
+		return "", cli.Exit(fmt.Sprintf("Error: Could not infer a valid base filename from URL's suggested filename: '%s'. Use -n to specify a name.", parsedInfo.SuggestedFilename), 1)
+	}
+	name := suggestedBaseName
 
-		if verbose {
-			fmt.Printf("Resolved full path for saving: %s\n", fullPath)
-			fmt.Printf("Relative destination path for manifest: %s\n", relativeDestPath)
+	// The declared name only replaces the manifest key; the file keeps its upstream name.
+	if opts.namePattern != nil {
+		if declaredName := nameFromContent(content, opts.namePattern); declaredName != "" {
+			name = declaredName
+		} else if opts.verbose {
+			_, _ = fmt.Fprintf(stderr, "No name declaration matching '%s' found; using filename-based name.\n", opts.namePattern.String())
 		}
+	}
 
-		// Create the target directory if it doesn't exist
-		dirToCreate := filepath.Dir(fullPath)
-		if verbose {
-			fmt.Printf("Ensuring directory exists: %s\n", dirToCreate)
-		}
-		// Use a temporary variable for MkdirAll's error to not shadow the named return 'err'
-		if mkdirErr := os.MkdirAll(dirToCreate, 0755); mkdirErr != nil {
-			err = cli.Exit(fmt.Sprintf("Error creating directory '%s': %v", dirToCreate, mkdirErr), 1) // MODIFIED
-			return
+	if project.ValidateDependencyName(name) != nil {
+		normalized := project.NormalizeDependencyName(name)
+		if nameErr := project.ValidateDependencyName(normalized); nameErr != nil {
+			return "", cli.Exit(fmt.Sprintf("Error: Could not infer a valid dependency name from '%s': %v. Use -n to specify a name.", name, nameErr), 1)
 		}
+		_, _ = fmt.Fprintf(stderr, "Note: '%s' is not a valid dependency name; using '%s'. Use -n to choose another.\n", name, normalized)
+		name = normalized
+	}
+	return name, nil
+}
 
-		// Save the downloaded content to the file
-		// This is a critical point: if this succeeds but subsequent steps fail, we should try to clean up this file.
-		if verbose {
-			fmt.Printf("Saving file to %s...\n", fullPath)
+// checkConflicts refuses to add name if its path already belongs to another dependency, or,
+// unless --force is set, if name is already declared or the same canonical source is declared
+// under another name.
+func checkConflicts(cCtx *cli.Context, proj *project.Project, name, canonicalURL, relativeDestPath string) error {
+	// Re-adding a name replaces its entry, which should never happen by accident.
+	if existing, _, exists := proj.LookupDependency(name); exists {
+		if !cCtx.Bool("force") {
+			return cli.Exit(fmt.Sprintf("Error: '%s' is already in %s with source %s. Use --force to replace it, or -n to add this under another name.", name, config.ProjectTomlName, existing.Source), 1)
 		}
-		// Use a temporary variable for WriteFile's error
-		if writeErr := os.WriteFile(fullPath, fileContent, 0644); writeErr != nil {
-			// No file to clean up yet, as it wasn't written.
-			err = cli.Exit(fmt.Sprintf("Error writing file '%s': %v", fullPath, writeErr), 1) // MODIFIED
-			return
+		_, _ = fmt.Fprintf(cCtx.App.ErrWriter, "Note: Replacing '%s' in %s (source was %s).\n", name, config.ProjectTomlName, existing.Source)
+	}
+
+	// The same file reached through a different URL form would otherwise get a second manifest key.
+	if existingName, found := findSameSource(proj, name, canonicalURL); found {
+		if !cCtx.Bool("force") {
+			return cli.Exit(fmt.Sprintf("Error: %s is already in %s as '%s'. Use --force to add a second reference as '%s'.", canonicalURL, config.ProjectTomlName, existingName, name), 1)
 		}
-		// File has been written. From this point on, if an error occurs, we must attempt to clean it up.
-		fileWritten := true
-		defer func() {
-			// 'err' here refers to the named return parameter of the Action func.
-			if err != nil && fileWritten { // If an error occurred (i.e., Action is returning an error) and file was written
-				if verbose {
-					fmt.Printf("Attempting to clean up downloaded file '%s' due to error: %v\n", fullPath, err)
+		_, _ = fmt.Fprintf(cCtx.App.ErrWriter, "Warning: %s is already in %s as '%s'; adding a second reference as '%s'.\n", canonicalURL, config.ProjectTomlName, existingName, name)
+	}
+
+	// Since --name no longer renames the file, two dependencies could otherwise share one path.
+	if ownerName, taken := findSamePath(proj, name, relativeDestPath); taken {
+		return cli.Exit(fmt.Sprintf("Error: %s is already the path of '%s' in %s. Use --filename to save '%s' under another name.", relativeDestPath, ownerName, config.ProjectTomlName, name), 1)
+	}
+	return nil
+}
+
+// AddCommand defines the structure for the "add" command.
+var AddCommand = newAddCommand(time.Now)
+
+// newAddCommand creates the "add" command, which reads the time from now. Tests pass a frozen
+// clock.
+func newAddCommand(now func() time.Time) *cli.Command {
+	return &cli.Command{
+		Name:      "add",
+		Usage:     "Downloads a dependency and adds it to the project",
+		ArgsUsage: "<source_url|registry_name>...",
+		Flags: []cli.Flag{
+			&cli.StringFlag{
+				Name:    "directory",
+				Aliases: []string{"d"},
+				Usage:   "Specify the target directory for the dependency (overrides [almd] default_dependency_dir)",
+				Value:   "src/lib/",
+			},
+			&cli.StringFlag{
+				Name:    "name",
+				Aliases: []string{"n"},
+				Usage:   "Specify the name for the dependency in project.toml (defaults to filename from URL)",
+			},
+			&cli.StringFlag{
+				Name:  "filename",
+				Usage: "Save the file under this name (defaults to the filename from the URL)",
+			},
+			&cli.BoolFlag{
+				Name:  "name-from-content",
+				Usage: "Derive the dependency name from a declaration in the file's leading lines (ignored if --name is set)",
+			},
+			&cli.StringFlag{
+				Name:  "name-pattern",
+				Usage: "Regular expression used by --name-from-content; the first capture group is the name",
+				Value: defaultNamePattern,
+			},
+			&cli.StringFlag{
+				Name:  "registry",
+				Usage: "Registry file or URL used to resolve a bare dependency name (overrides [almd] registry)",
+			},
+			&cli.BoolFlag{
+				Name:    "interactive",
+				Aliases: []string{"i"},
+				Usage:   "Preview the downloaded file and its hash, and confirm before saving",
+			},
+			&cli.IntFlag{
+				Name:  "preview-lines",
+				Usage: "Number of lines shown by --interactive (0 shows the whole file)",
+				Value: defaultPreviewLines,
+			},
+			&cli.BoolFlag{
+				Name:    "yes",
+				Aliases: []string{"y"},
+				Usage:   "Accept the --interactive preview without prompting (required when stdin is not a terminal)",
+			},
+			&cli.BoolFlag{
+				Name:  "dedupe",
+				Usage: "Do not add the file if identical content is already installed under another name",
+			},
+			&cli.StringFlag{
+				Name:  "max-size",
+				Usage: "Largest file to download, e.g. 200MB (0 disables the limit)",
+				Value: "50MB",
+			},
+			&cli.StringFlag{
+				Name:  "checksum-url",
+				Usage: "URL of a published SHA256 checksum file to verify the download against",
+			},
+			&cli.BoolFlag{
+				Name:  "force",
+				Usage: "Replace a dependency of the same name in project.toml, or add a source already there under another name",
+			},
+			&cli.StringFlag{
+				Name:  "from",
+				Usage: "Add every source listed in `FILE`, one 'source [name [dir]]' per line ('#' starts a comment)",
+			},
+			&cli.BoolFlag{
+				Name:  "from-lockfile",
+				Usage: "Restore a dependency removed from project.toml using its almd-lock.toml entry; the argument is its name",
+			},
+			&cli.BoolFlag{
+				Name:  "dev",
+				Usage: "Add to [dev-dependencies], which 'almd install --production' skips",
+			},
+			&cli.BoolFlag{
+				Name:  "pin",
+				Usage: "Record the resolved commit SHA in project.toml instead of the branch, tag or range given",
+			},
+			&cli.BoolFlag{
+				Name:  "no-download",
+				Usage: "Record the dependency in project.toml and almd-lock.toml without fetching it; 'almd install' fills in the hash",
+			},
+			&cli.BoolFlag{
+				Name:  "recursive",
+				Usage: "Add every file below a GitHub repository directory (github:owner/repo/dir@ref), each as its own dependency",
+			},
+			&cli.IntFlag{
+				Name:  "max-depth",
+				Usage: "With --recursive, skip files nested more than this many directories below the one given",
+				Value: defaultMaxDepth,
+			},
+			&cli.IntFlag{
+				Name:  "max-files",
+				Usage: "With --recursive, refuse directories with more files than this",
+				Value: defaultMaxFiles,
+			},
+			&cli.BoolFlag{
+				Name:  "print-path",
+				Usage: "Print only the saved file's project-relative path to stdout, for use in scripts",
+			},
+			&cli.BoolFlag{
+				Name:  "verbose",
+				Usage: "Enable verbose output",
+			},
+			output.QuietFlag(),
+		},
+		Action: func(cCtx *cli.Context) (err error) { // MODIFIED: Named return error
+			// The pnpm-style summary goes to stdout; verbose tracing and warnings go to stderr.
+			stderr := cCtx.App.ErrWriter
+			if err = output.CheckQuiet(cCtx); err != nil {
+				return
+			}
+			if cCtx.Bool("no-download") {
+				for _, flag := range contentFlags {
+					if cCtx.Bool(flag) {
+						err = cli.Exit(fmt.Sprintf("Error: --%s needs the downloaded file and cannot be combined with --no-download.", flag), 1)
+						return
+					}
+				}
+			}
+			sources := cCtx.Args().Slice()
+			fromFile := cCtx.String("from")
+			if fromFile != "" && (len(sources) > 0 || cCtx.Bool("from-lockfile")) {
+				err = cli.Exit("Error: --from cannot be combined with source arguments or --from-lockfile.", 1)
+				return
+			}
+			var listed []sourceListEntry
+			if fromFile != "" {
+				var readErr error
+				if listed, readErr = readSourceList(fromFile); readErr != nil {
+					err = cli.Exit(fmt.Sprintf("Error: Failed to read --from file: %v", readErr), 1)
+					return
+				}
+				if len(listed) == 0 {
+					err = cli.Exit(fmt.Sprintf("Error: %s lists no sources.", fromFile), 1)
+					return
+				}
+			}
+			if len(sources) == 0 && fromFile == "" {
+				err = cli.Exit("Error: <source_url> argument is required.", 1) // MODIFIED
+				return
+			}
+
+			targetDir := cCtx.String("directory")
+			customName := cCtx.String("name")
+			if customName != "" && (len(sources) > 1 || fromFile != "") {
+				err = cli.Exit("Error: --name cannot be used when adding more than one source.", 1)
+				return
+			}
+			if customName != "" {
+				if nameErr := project.ValidateDependencyName(customName); nameErr != nil {
+					err = cli.Exit(fmt.Sprintf("Error: Invalid --name: %v.", nameErr), 1)
+					return
+				}
+			}
+			customFilename := cCtx.String("filename")
+			if customFilename != "" && (len(sources) > 1 || fromFile != "") {
+				err = cli.Exit("Error: --filename cannot be used when adding more than one source.", 1)
+				return
+			}
+			if customFilename != "" && (customFilename == "." || customFilename == ".." || strings.ContainsAny(customFilename, `/\`)) {
+				err = cli.Exit(fmt.Sprintf("Error: --filename '%s' must be a plain file name; use --directory to choose where it is saved.", customFilename), 1)
+				return
+			}
+			if cCtx.Bool("recursive") {
+				if len(sources) != 1 || fromFile != "" || cCtx.Bool("from-lockfile") {
+					err = cli.Exit("Error: --recursive takes exactly one directory source.", 1)
+					return
 				}
-				cleanupErr := os.Remove(fullPath)
-				if cleanupErr != nil {
-					var errWriter io.Writer = os.Stderr
-					if cCtx.App != nil && cCtx.App.ErrWriter != nil {
-						errWriter = cCtx.App.ErrWriter
+				for _, flag := range []string{"filename", "name-from-content"} {
+					if cCtx.IsSet(flag) {
+						err = cli.Exit(fmt.Sprintf("Error: --%s cannot be used with --recursive; each file is named after its path in the directory.", flag), 1)
+						return
 					}
-					_, _ = fmt.Fprintf(errWriter, "Warning: Failed to clean up downloaded file '%s' during error handling: %v\n", fullPath, cleanupErr)
-				} else {
-					if verbose {
-						fmt.Printf("Successfully cleaned up downloaded file '%s'.\n", fullPath)
+				}
+				if cCtx.Int("max-depth") < 1 || cCtx.Int("max-files") < 1 {
+					err = cli.Exit("Error: --max-depth and --max-files must be at least 1.", 1)
+					return
+				}
+			} else {
+				for _, flag := range []string{"max-depth", "max-files"} {
+					if cCtx.IsSet(flag) {
+						err = cli.Exit(fmt.Sprintf("Error: --%s is only meaningful together with --recursive.", flag), 1)
+						return
 					}
 				}
 			}
-		}()
+			verbose := cCtx.Bool("verbose")
+			ghConfig := source.DefaultConfig()
+			downloads := &downloader.Client{Progress: output.DownloadProgress(cCtx)}
+			if verbose {
+				ghConfig.Verbose, downloads.Verbose = stderr, stderr
+			}
 
-		// Task 2.5: Calculate hash of the downloaded content
-		var fileHashSHA256 string
-		var hashErr error
-		fileHashSHA256, hashErr = hasher.CalculateSHA256(fileContent)
-		if hashErr != nil {
-			// Assign to named return 'err'
-			err = cli.Exit(fmt.Sprintf("Error calculating SHA256 hash: %v. File '%s' was saved but is now being cleaned up.", hashErr, fullPath), 1) // MODIFIED
-			return
-		}
-		if verbose {
-			fmt.Printf("SHA256 hash of downloaded file: %s\n", fileHashSHA256)
-		}
+			maxSize, sizeErr := downloader.ParseSize(cCtx.String("max-size"))
+			if sizeErr != nil {
+				err = cli.Exit(fmt.Sprintf("Error: Invalid --max-size: %v", sizeErr), 1)
+				return
+			}
 
-		// Task 2.7: Update project.toml
-		if verbose {
-			fmt.Println("Updating project.toml...")
-		}
-		// projectTomlPath variable is no longer needed as LoadProjectToml and WriteProjectToml
-		// now correctly use projectRoot to construct the path internally.
-		var proj *project.Project // MODIFIED: Use pointer type
-		var loadTomlErr error
-		// Pass projectRoot to LoadProjectToml, not the full path to the file
-		proj, loadTomlErr = config.LoadProjectToml(projectRoot)
-		if loadTomlErr != nil {
-			if os.IsNotExist(loadTomlErr) {
-				// Construct the expected full path for a more accurate error message if needed,
-				// though LoadProjectToml itself will return the error from os.ReadFile(filepath.Join(projectRoot, config.ProjectTomlName))
-				expectedProjectTomlPath := filepath.Join(projectRoot, config.ProjectTomlName)
-				detailedError := fmt.Errorf("project.toml not found at '%s' (no such file or directory): %w", expectedProjectTomlPath, loadTomlErr)
-				err = cli.Exit(fmt.Sprintf("Error: %s. File '%s' was saved but is now being cleaned up.", detailedError, fullPath), 1)
+			interactive := cCtx.Bool("interactive")
+			if interactive && !cCtx.Bool("yes") && !prompt.IsTerminal(cCtx.App.Reader) {
+				err = cli.Exit("Error: --interactive needs a terminal on stdin to confirm; pass --yes to accept without prompting.", 1)
 				return
-			} else {
-				err = cli.Exit(fmt.Sprintf("Error loading %s: %v. File '%s' was saved but is now being cleaned up.", config.ProjectTomlName, loadTomlErr, fullPath), 1)
+			}
+
+			var namePattern *regexp.Regexp
+			if cCtx.Bool("name-from-content") && customName == "" {
+				var compileErr error
+				namePattern, compileErr = regexp.Compile(cCtx.String("name-pattern"))
+				if compileErr != nil {
+					err = cli.Exit(fmt.Sprintf("Error: Invalid --name-pattern '%s': %v", cCtx.String("name-pattern"), compileErr), 1)
+					return
+				}
+			}
+
+			// Silence default verbose output, will be replaced by pnpm style
+			_ = verbose // Keep verbose for potential future use or more detailed debugging
+
+			// Load project.toml before any network access so that a missing manifest fails fast,
+			// registry names can be expanded, and project-level source policy ([almd] allowed_hosts)
+			// is enforced before downloading.
+			projectRoot := "."
+			release, lockErr := lockfile.Acquire(projectRoot)
+			if lockErr != nil {
+				err = cli.Exit(fmt.Sprintf("Error: %v", lockErr), 1)
+				return
+			}
+			defer release()
+			var proj *project.Project // MODIFIED: Use pointer type
+			var loadTomlErr error
+			proj, loadTomlErr = config.LoadProjectToml(projectRoot)
+			if loadTomlErr != nil {
+				if os.IsNotExist(loadTomlErr) {
+					expectedProjectTomlPath := filepath.Join(projectRoot, config.ProjectTomlName)
+					detailedError := fmt.Errorf("project.toml not found at '%s' (no such file or directory): %w", expectedProjectTomlPath, loadTomlErr)
+					err = cli.Exit(fmt.Sprintf("Error: %s. Run 'almd init' first.", detailedError), 1)
+					return
+				}
+				err = cli.Exit(fmt.Sprintf("Error loading %s: %v", config.ProjectTomlName, loadTomlErr), 1)
 				return
 			}
-		}
 
-		// Ensure dependencies map is initialized
-		if proj.Dependencies == nil {
-			proj.Dependencies = make(map[string]project.Dependency)
-		}
+			// An explicit --directory wins over the project's default, which wins over the flag default.
+			if dir := proj.DefaultDependencyDir(); dir != "" && !cCtx.IsSet("directory") {
+				targetDir = dir
+			}
+
+			opts := addOptions{
+				projectRoot: projectRoot,
+				proj:        proj,
+				targetDir:   targetDir,
+				customName:  customName,
+				filename:    customFilename,
+				namePattern: namePattern,
+				maxSize:     maxSize,
+				interactive: interactive,
+				verbose:     verbose,
+				noDownload:  cCtx.Bool("no-download"),
+				downloads:   downloads,
+				github:      source.NewClient(ghConfig),
+				now:         now,
+			}
+			if fromFile != "" {
+				return addSourceList(cCtx, opts, fromFile, listed)
+			}
+			if cCtx.Bool("recursive") {
+				return addDirectory(cCtx, opts, sources[0])
+			}
+			if len(sources) == 1 {
+				return addSource(cCtx, opts, sources[0])
+			}
+
+			// Each source is added on its own, so those added before a failure stay added.
+			var failed []string
+			for _, sourceURLInput := range sources {
+				if addErr := addSource(cCtx, opts, sourceURLInput); addErr != nil {
+					_, _ = fmt.Fprintln(stderr, addErr.Error())
+					failed = append(failed, sourceURLInput)
+				}
+			}
+			if len(failed) > 0 {
+				err = cli.Exit(fmt.Sprintf("Error: Failed to add %d of %d sources: %s", len(failed), len(sources), strings.Join(failed, ", ")), 1)
+				return
+			}
+			return nil
+		},
+	}
+}
+
+// addOptions carries the settings shared by every source given to a single 'almd add'.
+type addOptions struct {
+	projectRoot string
+	proj        *project.Project
+	targetDir   string
+	customName  string
+	filename    string // --filename; empty keeps the filename from the URL
+	namePattern *regexp.Regexp
+	maxSize     int64
+	interactive bool
+	verbose     bool
+	noDownload  bool   // --no-download: record the source without fetching it
+	blobSHA     string // git blob SHA the download must match, for files listed by --recursive
+
+	downloads *downloader.Client // Fetches files and checksums, reporting under --verbose
+	github    *source.Client     // Serves every GitHub API call
+	now       func() time.Time   // Times the add for the closing summary
+}
 
-		// For project.toml, use the canonical source identifier
-		proj.Dependencies[dependencyNameInManifest] = project.Dependency{
-			Source: parsedInfo.CanonicalURL,
-			Path:   relativeDestPath,
+// addSource downloads one source and records it in project.toml and almd-lock.toml. If a later
+// step fails, the file it saved is removed again.
+func addSource(cCtx *cli.Context, opts addOptions, sourceURLInput string) (err error) {
+	startTime := opts.now()
+	// --print-path is machine output, so it is printed even under --quiet.
+	stdout, pathOut, stderr := output.Stdout(cCtx), cCtx.App.Writer, cCtx.App.ErrWriter
+	projectRoot, proj := opts.projectRoot, opts.proj
+	targetDir := opts.targetDir
+	maxSize, interactive, verbose := opts.maxSize, opts.interactive, opts.verbose
+
+	if cCtx.Bool("from-lockfile") {
+		return restoreFromLockfile(stdout, projectRoot, proj, sourceURLInput)
+	}
+
+	// The destination is checked before anything is downloaded, so a --directory (or default
+	// directory, or --from entry) that escapes the project never gets a file written through it.
+	if dirErr := fsutil.CheckWithinRoot(projectRoot, targetDir); dirErr != nil {
+		err = cli.Exit(fmt.Sprintf("Error: Invalid target directory: %v. Nothing was written.", dirErr), 1)
+		return
+	}
+
+	// Expand a bare name through the registry given by --registry or [almd] registry.
+	sourceToParse := sourceURLInput
+	registryLocation := proj.RegistryLocation()
+	if cCtx.IsSet("registry") {
+		registryLocation = cCtx.String("registry")
+	}
+	if registryLocation != "" && isBareName(sourceURLInput) {
+		cacheDir, cacheErr := registry.DefaultCacheDir()
+		if cacheErr != nil {
+			err = cli.Exit(fmt.Sprintf("Error: %v", cacheErr), 1)
+			return
+		}
+		reg, regErr := registry.Load(registryLocation, cacheDir)
+		if regErr != nil {
+			err = cli.Exit(fmt.Sprintf("Error loading registry '%s': %v", registryLocation, regErr), 1)
+			return
 		}
+		if registered, ok := reg.Lookup(sourceURLInput); ok {
+			if verbose {
+				_, _ = fmt.Fprintf(stderr, "Resolved '%s' via registry to %s\n", sourceURLInput, registered)
+			}
+			sourceToParse = registered
+		}
+	}
+
+	// Task 2.2: Parse the source URL
+	var parsedInfo *source.ParsedSourceInfo
+	parsedInfo, err = source.ParseSourceURL(sourceToParse) // Assign to named return 'err'
+	if err != nil {
+		err = cli.Exit(fmt.Sprintf("Error parsing source URL '%s': %v", sourceURLInput, err), 1) // MODIFIED
+		return
+	}
 
-		// Use a temporary variable for WriteProjectToml's error
-		// Pass projectRoot to WriteProjectToml, not the full path to the file
-		if writeTomlErr := config.WriteProjectToml(projectRoot, proj); writeTomlErr != nil { // proj is already a pointer
-			err = cli.Exit(fmt.Sprintf("Error writing %s: %v. File '%s' was saved but is now being cleaned up. %s may be in an inconsistent state.", config.ProjectTomlName, writeTomlErr, fullPath, config.ProjectTomlName), 1)
+	if verbose {
+		_, _ = fmt.Fprintf(stderr, "Parsed Source Info:\n")
+		_, _ = fmt.Fprintf(stderr, "  Raw Download URL: %s\n", parsedInfo.RawURL)
+		_, _ = fmt.Fprintf(stderr, "  Canonical URL for Manifest: %s\n", parsedInfo.CanonicalURL)
+		_, _ = fmt.Fprintf(stderr, "  Extracted Ref (commit/branch/tag): %s\n", parsedInfo.Ref)
+		_, _ = fmt.Fprintf(stderr, "  Suggested Filename from URL: %s\n", parsedInfo.SuggestedFilename)
+	}
+
+	policyHooks := []source.Hook{source.AllowedHostsHook(proj.AllowedHosts())}
+	if hookErr := source.ApplyHooks(parsedInfo, policyHooks...); hookErr != nil {
+		err = cli.Exit(fmt.Sprintf("Error: Source '%s' rejected by policy: %v", sourceURLInput, hookErr), 1)
+		return
+	}
+	// The checksum file is fetched from wherever --checksum-url points, so it is held to the same policy.
+	checksumURL := cCtx.String("checksum-url")
+	checksumFetchURL := checksumURL
+	if checksumURL != "" {
+		var hookErr error
+		if checksumFetchURL, hookErr = source.CheckURL(checksumURL, policyHooks...); hookErr != nil {
+			err = cli.Exit(fmt.Sprintf("Error: Checksum URL '%s' rejected by policy: %v", checksumURL, hookErr), 1)
 			return
 		}
+	}
+
+	if opts.noDownload {
+		return registerSource(cCtx, opts, sourceURLInput, parsedInfo)
+	}
 
+	// A version range stays in project.toml; the file comes from the highest matching tag.
+	if parsedInfo.VersionRange != "" {
+		resolved, resolveErr := opts.github.ResolveVersionRange(parsedInfo)
+		if resolveErr != nil {
+			err = cli.Exit(fmt.Sprintf("Error resolving version range '%s' for '%s': %v", parsedInfo.VersionRange, sourceURLInput, resolveErr), 1)
+			return
+		}
 		if verbose {
-			fmt.Printf("Successfully updated %s for dependency '%s'.\n", config.ProjectTomlName, dependencyNameInManifest)
+			_, _ = fmt.Fprintf(stderr, "Version range '%s' matches tag %s\n", parsedInfo.VersionRange, resolved.Ref)
 		}
+		parsedInfo = resolved
+	}
+
+	// Task 2.3: Download the file using the RawURL
+	if verbose {
+		_, _ = fmt.Fprintf(stderr, "Downloading from %s...\n", parsedInfo.RawURL)
+	}
+	var fileContent []byte
+	var validators downloader.Validators
+	fileContent, validators, err = opts.downloads.DownloadFileIfModified(parsedInfo.RawURL, maxSize, downloader.Validators{}) // Assign to named return 'err'
+	if err != nil {
+		err = cli.Exit(fmt.Sprintf("Error downloading file from '%s': %v", parsedInfo.RawURL, err), 1) // MODIFIED
+		return
+	}
+	if verbose {
+		_, _ = fmt.Fprintf(stderr, "Downloaded %d bytes successfully.\n", len(fileContent))
+	}
 
-		// Task 2.8: Implement Lockfile Update
+	// A published checksum is verified before anything is written and becomes the lockfile hash.
+	var publishedHash string
+	if checksumURL != "" {
+		var checksumErr error
+		publishedHash, checksumErr = opts.downloads.FetchChecksum(checksumFetchURL, parsedInfo.SuggestedFilename)
+		if checksumErr != nil {
+			err = cli.Exit(fmt.Sprintf("Error fetching checksum from '%s': %v", checksumURL, checksumErr), 1)
+			return
+		}
+		actualHash, hashErr := hasher.Calculate(fileContent, hasher.AlgorithmFor(publishedHash))
+		if hashErr != nil {
+			err = cli.Exit(fmt.Sprintf("Error calculating content hash: %v", hashErr), 1)
+			return
+		}
+		if actualHash != publishedHash {
+			err = cli.Exit(fmt.Sprintf("Error: Checksum mismatch for '%s': %s publishes %s, but the download hashes to %s. Nothing was written.", parsedInfo.RawURL, checksumURL, publishedHash, actualHash), 1)
+			return
+		}
 		if verbose {
-			fmt.Println("Updating almd-lock.toml...")
+			_, _ = fmt.Fprintf(stderr, "Verified download against published checksum %s.\n", publishedHash)
+		}
+	}
+
+	// A file listed by --recursive must be the blob the listing named.
+	if opts.blobSHA != "" {
+		if got := hasher.GitBlobSHA(fileContent); got != opts.blobSHA {
+			err = cli.Exit(fmt.Sprintf("Error: Download from '%s' is git blob %s, but the directory listing named %s; the directory may have changed while it was being added. Nothing was written.", parsedInfo.RawURL, got, opts.blobSHA), 1)
+			return
 		}
+	}
 
-		var lf *lockfile.Lockfile // MODIFIED: Use pointer type and correct package
-		var loadLockErr error
-		lf, loadLockErr = lockfile.Load(projectRoot) // Load or initialize if not found
-		if loadLockErr != nil {
-			err = cli.Exit(fmt.Sprintf("Error loading/initializing %s: %v. File '%s' saved and %s updated, but lockfile operation failed. %s and %s may be inconsistent. Downloaded file '%s' is being cleaned up.", lockfile.LockfileName, loadLockErr, fullPath, config.ProjectTomlName, config.ProjectTomlName, lockfile.LockfileName, fullPath), 1)
+	// Task 2.4: Determine target path and save file
+	// The manifest key and the saved filename are chosen independently: --name never renames
+	// the file, and --filename never renames the dependency.
+	fileNameOnDisk := parsedInfo.SuggestedFilename
+	if opts.filename != "" {
+		fileNameOnDisk = opts.filename
+	}
+	var dependencyNameInManifest string
+	if dependencyNameInManifest, err = dependencyName(stderr, opts, parsedInfo, fileContent); err != nil {
+		return
+	}
+
+	if fileNameOnDisk == "" || fileNameOnDisk == "." || fileNameOnDisk == "/" {
+		err = cli.Exit("Error: Could not determine a valid final filename for saving. Inferred name was empty or invalid.", 1) // MODIFIED
+		return
+	}
+
+	if verbose {
+		_, _ = fmt.Fprintf(stderr, "Effective filename for saving: %s\n", fileNameOnDisk)
+		_, _ = fmt.Fprintf(stderr, "Dependency name in manifest/lockfile: %s\n", dependencyNameInManifest)
+	}
+
+	// Construct the full path relative to the current directory (project root)
+	fullPath := filepath.Join(projectRoot, targetDir, fileNameOnDisk)
+	relativeDestPath := filepath.ToSlash(filepath.Join(targetDir, fileNameOnDisk))
+
+	if verbose {
+		_, _ = fmt.Fprintf(stderr, "Resolved full path for saving: %s\n", fullPath)
+		_, _ = fmt.Fprintf(stderr, "Relative destination path for manifest: %s\n", relativeDestPath)
+	}
+
+	if interactive {
+		previewHash, previewHashErr := hasher.Calculate(fileContent, hasher.DefaultAlgorithm)
+		if previewHashErr != nil {
+			err = cli.Exit(fmt.Sprintf("Error calculating content hash: %v", previewHashErr), 1)
 			return
 		}
+		_, _ = fmt.Fprintf(stderr, "Preview of %s (%d bytes, %s):\n", parsedInfo.RawURL, len(fileContent), previewHash)
+		writePreview(stderr, fileContent, cCtx.Int("preview-lines"))
+		if !cCtx.Bool("yes") && !prompt.Confirm(cCtx.App.Reader, stderr, fmt.Sprintf("Save this as %s?", relativeDestPath)) {
+			_, _ = fmt.Fprintln(stderr, "Add cancelled; nothing was written.")
+			return nil
+		}
+	}
 
-		// Determine integrity hash: commit:<commit_hash> or sha256:<hash>
-		var integrityHash string
-		isLikelyCommitSHA := func(ref string) bool {
-			if len(ref) != 40 { // Standard Git SHA-1 length
-				return false
+	// The same bytes may already be vendored under another name, e.g. a snippet copied into two places.
+	duplicateName, duplicateEntry, hasDuplicate := findIdenticalContent(projectRoot, dependencyNameInManifest, fileContent)
+	if hasDuplicate && cCtx.Bool("dedupe") {
+		_, _ = fmt.Fprintf(stderr, "Identical content is already installed as %s at %s; nothing was added.\n", duplicateName, duplicateEntry.Path)
+		return nil
+	}
+
+	if err = checkConflicts(cCtx, proj, dependencyNameInManifest, parsedInfo.CanonicalURL, relativeDestPath); err != nil {
+		return
+	}
+
+	// Task 2.5: Calculate hash of the downloaded content. A re-added dependency keeps the
+	// algorithm of its locked hash so the two stay comparable.
+	existingLock, existingLockErr := lockfile.Load(projectRoot)
+	hashAlgo := hasher.DefaultAlgorithm
+	if existingLockErr == nil {
+		if lockedHash, ok := existingLock.Package[dependencyNameInManifest].ExpectedContentHash(); ok {
+			hashAlgo = hasher.AlgorithmFor(lockedHash)
+		}
+	}
+	var fileContentHash string
+	var hashErr error
+	fileContentHash, hashErr = hasher.Calculate(fileContent, hashAlgo)
+	if hashErr != nil {
+		// Assign to named return 'err'
+		err = cli.Exit(fmt.Sprintf("Error calculating content hash: %v", hashErr), 1)
+		return
+	}
+	if verbose {
+		_, _ = fmt.Fprintf(stderr, "Content hash of downloaded file: %s\n", fileContentHash)
+	}
+
+	// Determine integrity hash: commit:<commit_hash> or <algorithm>:<hash>
+	var integrityHash string
+	lockedRawURL := parsedInfo.RawURL
+	if publishedHash != "" {
+		integrityHash = publishedHash
+	} else if parsedInfo.Provider == "github" && parsedInfo.Owner != "" && parsedInfo.Repo != "" && parsedInfo.PathInRepo != "" && parsedInfo.Ref != "" && !strings.HasPrefix(parsedInfo.Ref, "error:") {
+		if source.IsImmutableRef(parsedInfo.Provider, parsedInfo.Ref) {
+			if verbose {
+				_, _ = fmt.Fprintf(stderr, "Using provided ref '%s' as commit SHA for lockfile hash.\n", parsedInfo.Ref)
 			}
-			for _, r := range ref {
-				if (r < '0' || r > '9') && (r < 'a' || r > 'f') && (r < 'A' || r > 'F') {
-					return false
+			commitSHA := parsedInfo.Ref
+			// An abbreviated SHA could come to name another commit as the repository grows.
+			if source.IsAbbreviatedCommitSHA(parsedInfo.Provider, commitSHA) {
+				if fullSHA, expandErr := opts.github.ExpandCommitSHA(parsedInfo.Owner, parsedInfo.Repo, commitSHA); expandErr != nil {
+					_, _ = fmt.Fprintf(stderr, "Warning: Could not expand short commit SHA '%s' for '%s': %v. Locking it as written.\n", commitSHA, dependencyNameInManifest, expandErr)
+				} else {
+					lockedRawURL = parsedInfo.RawURLAt(fullSHA)
+					commitSHA = fullSHA
 				}
 			}
-			return true
-		}
-
-		if parsedInfo.Provider == "github" && parsedInfo.Owner != "" && parsedInfo.Repo != "" && parsedInfo.PathInRepo != "" && parsedInfo.Ref != "" && !strings.HasPrefix(parsedInfo.Ref, "error:") {
-			if isLikelyCommitSHA(parsedInfo.Ref) {
+			integrityHash = fmt.Sprintf("commit:%s", commitSHA)
+		} else {
+			// Ref is likely a branch or tag, try to get the specific commit SHA
+			if verbose {
+				_, _ = fmt.Fprintf(stderr, "Attempting to resolve ref '%s' to a specific commit SHA for path '%s' in repo '%s/%s'...\n", parsedInfo.Ref, parsedInfo.PathInRepo, parsedInfo.Owner, parsedInfo.Repo)
+			}
+			var commitSHA string
+			var getCommitErr error
+			commitSHA, getCommitErr = opts.github.GetLatestCommitSHAForFile(parsedInfo.Owner, parsedInfo.Repo, parsedInfo.PathInRepo, parsedInfo.Ref)
+			if getCommitErr != nil {
 				if verbose {
-					fmt.Printf("Using provided ref '%s' as commit SHA for lockfile hash.\\n", parsedInfo.Ref)
+					_, _ = fmt.Fprintf(stderr, "Warning: Failed to get specific commit SHA for '%s@%s': %v. Falling back to content hash for lockfile.\n", parsedInfo.PathInRepo, parsedInfo.Ref, getCommitErr)
 				}
-				integrityHash = fmt.Sprintf("commit:%s", parsedInfo.Ref)
+				integrityHash = fileContentHash
 			} else {
-				// Ref is likely a branch or tag, try to get the specific commit SHA
 				if verbose {
-					fmt.Printf("Attempting to resolve ref '%s' to a specific commit SHA for path '%s' in repo '%s/%s'...\\n", parsedInfo.Ref, parsedInfo.PathInRepo, parsedInfo.Owner, parsedInfo.Repo)
-				}
-				var commitSHA string
-				var getCommitErr error
-				commitSHA, getCommitErr = source.GetLatestCommitSHAForFile(parsedInfo.Owner, parsedInfo.Repo, parsedInfo.PathInRepo, parsedInfo.Ref)
-				if getCommitErr != nil {
-					if verbose {
-						fmt.Printf("Warning: Failed to get specific commit SHA for '%s@%s': %v. Falling back to SHA256 content hash for lockfile.\\n", parsedInfo.PathInRepo, parsedInfo.Ref, getCommitErr)
-					}
-					integrityHash = fileHashSHA256
-				} else {
-					if verbose {
-						fmt.Printf("Successfully resolved ref '%s' to commit SHA '%s'.\\n", parsedInfo.Ref, commitSHA)
-					}
-					integrityHash = fmt.Sprintf("commit:%s", commitSHA)
+					_, _ = fmt.Fprintf(stderr, "Successfully resolved ref '%s' to commit SHA '%s'.\n", parsedInfo.Ref, commitSHA)
 				}
+				integrityHash = fmt.Sprintf("commit:%s", commitSHA)
 			}
-		} else {
-			if verbose && parsedInfo.Provider == "github" {
-				fmt.Printf("Insufficient information or invalid ref ('%s') to fetch specific commit SHA for GitHub source. Falling back to SHA256 content hash for lockfile.\\n", parsedInfo.Ref)
-			} else if verbose {
-				fmt.Printf("Source is not GitHub or ref is missing. Falling back to SHA256 content hash for lockfile.\\n")
-			}
-			integrityHash = fileHashSHA256 // Fallback to SHA256
 		}
+	} else {
+		if verbose && parsedInfo.Provider == "github" {
+			_, _ = fmt.Fprintf(stderr, "Insufficient information or invalid ref ('%s') to fetch specific commit SHA for GitHub source. Falling back to content hash for lockfile.\n", parsedInfo.Ref)
+		} else if verbose {
+			_, _ = fmt.Fprintf(stderr, "Source is not GitHub or ref is missing. Falling back to content hash for lockfile.\n")
+		}
+		integrityHash = fileContentHash // Fallback to the content hash
+	}
 
-		// For lockfile, use the exact raw download URL and calculated integrity hash
-		lf.AddOrUpdatePackage(dependencyNameInManifest, parsedInfo.RawURL, relativeDestPath, integrityHash)
+	// --pin makes project.toml itself reproducible by replacing the ref with the commit it
+	// resolved to; without it the manifest keeps the ref as given and installs follow it.
+	manifestSource := parsedInfo.CanonicalURL
+	if cCtx.Bool("pin") {
+		if parsedInfo.Provider != "github" {
+			err = cli.Exit(fmt.Sprintf("Error: --pin needs a GitHub source; '%s' has no commits to pin to. Nothing was written.", sourceURLInput), 1)
+			return
+		}
+		pinnedSHA := strings.TrimPrefix(integrityHash, "commit:")
+		if !strings.HasPrefix(integrityHash, "commit:") {
+			// The lock holds a content hash (a published checksum, or a failed lookup), so the
+			// commit is resolved on its own.
+			var pinErr error
+			if pinnedSHA, pinErr = opts.github.GetLatestCommitSHAForFile(parsedInfo.Owner, parsedInfo.Repo, parsedInfo.PathInRepo, parsedInfo.Ref); pinErr != nil {
+				err = cli.Exit(fmt.Sprintf("Error: --pin could not resolve '%s' to a commit: %v. Nothing was written.", parsedInfo.Ref, pinErr), 1)
+				return
+			}
+		}
+		manifestSource = fmt.Sprintf("github:%s/%s/%s@%s", parsedInfo.Owner, parsedInfo.Repo, parsedInfo.PathInRepo, pinnedSHA)
+		lockedRawURL = parsedInfo.RawURLAt(pinnedSHA)
+		if verbose {
+			_, _ = fmt.Fprintf(stderr, "Pinning %s in %s as %s\n", dependencyNameInManifest, config.ProjectTomlName, manifestSource)
+		}
+	}
 
-		// Use a temporary variable for lockfile.Save's error
-		if saveLockErr := lockfile.Save(projectRoot, lf); saveLockErr != nil {
-			// Assign to named return 'err'
-			err = cli.Exit(fmt.Sprintf("Error saving %s: %v. File '%s' saved and %s updated, but saving %s failed. %s and %s may be inconsistent. Downloaded file '%s' is being cleaned up.", lockfile.LockfileName, saveLockErr, fullPath, config.ProjectTomlName, lockfile.LockfileName, config.ProjectTomlName, lockfile.LockfileName, fullPath), 1) // MODIFIED
+	// Re-adding a dependency at the commit it is already locked to must reproduce the locked bytes.
+	if existingLockErr == nil {
+		if checkErr := existingLock.Package[dependencyNameInManifest].CheckContent(integrityHash, fileContentHash); checkErr != nil {
+			err = cli.Exit(fmt.Sprintf("Error: Refusing to add '%s' from '%s': %v. Nothing was written.", dependencyNameInManifest, parsedInfo.RawURL, checkErr), 1)
 			return
 		}
+	}
+
+	// Create the target directory if it doesn't exist
+	dirToCreate := filepath.Dir(fullPath)
+	if verbose {
+		_, _ = fmt.Fprintf(stderr, "Ensuring directory exists: %s\n", dirToCreate)
+	}
+	// Use a temporary variable for MkdirAll's error to not shadow the named return 'err'
+	if mkdirErr := os.MkdirAll(dirToCreate, 0755); mkdirErr != nil {
+		err = cli.Exit(fmt.Sprintf("Error creating directory '%s': %v", dirToCreate, mkdirErr), 1) // MODIFIED
+		return
+	}
 
+	// Save the downloaded content to the file. A forced re-add may overwrite the dependency's
+	// current file, so its content is kept to put back if a later step fails.
+	previousContent, readPreviousErr := os.ReadFile(fullPath)
+	hadPreviousFile := readPreviousErr == nil
+	if verbose {
+		_, _ = fmt.Fprintf(stderr, "Saving file to %s...\n", fullPath)
+	}
+	// Use a temporary variable for WriteFile's error
+	if writeErr := fsutil.WriteFileAtomic(fullPath, fileContent, 0644); writeErr != nil {
+		// No file to clean up yet, as it wasn't written.
+		err = cli.Exit(fmt.Sprintf("Error writing file '%s': %v", fullPath, writeErr), 1) // MODIFIED
+		return
+	}
+	// File has been written. From this point on, if an error occurs, the previous file is
+	// restored, or the new one removed if there was none.
+	fileWritten := true
+	defer func() {
+		// 'err' here refers to the named return parameter of the Action func.
+		if err == nil || !fileWritten {
+			return
+		}
+		if hadPreviousFile {
+			if restoreErr := fsutil.WriteFileAtomic(fullPath, previousContent, 0644); restoreErr != nil {
+				_, _ = fmt.Fprintf(stderr, "Warning: Failed to restore the previous '%s' during error handling: %v\n", fullPath, restoreErr)
+			} else {
+				_, _ = fmt.Fprintf(stderr, "Note: Restored the previous '%s'.\n", fullPath)
+			}
+			return
+		}
 		if verbose {
-			fmt.Printf("Successfully updated %s for dependency '%s'.\n", lockfile.LockfileName, dependencyNameInManifest)
+			_, _ = fmt.Fprintf(stderr, "Attempting to clean up downloaded file '%s' due to error: %v\n", fullPath, err)
+		}
+		cleanupErr := os.Remove(fullPath)
+		if cleanupErr != nil {
+			_, _ = fmt.Fprintf(stderr, "Warning: Failed to clean up downloaded file '%s' during error handling: %v\n", fullPath, cleanupErr)
+		} else {
+			if verbose {
+				_, _ = fmt.Fprintf(stderr, "Successfully cleaned up downloaded file '%s'.\n", fullPath)
+			}
+		}
+	}()
+
+	// Task 2.7: Update project.toml
+	if verbose {
+		_, _ = fmt.Fprintln(stderr, "Updating project.toml...")
+	}
+	// --dev puts the dependency in [dev-dependencies]; re-adding a name moves it between tables.
+	group := ""
+	if cCtx.Bool("dev") {
+		group = project.GroupDev
+	}
+	// If a later step fails, the entry replaced by --force is put back, in memory (later sources
+	// of this add share proj) and in project.toml, so the dependency is left as it was.
+	previousDep, previousGroup, hadPreviousDep := proj.LookupDependency(dependencyNameInManifest)
+	previousToml, readTomlErr := os.ReadFile(filepath.Join(projectRoot, config.ProjectTomlName))
+	tomlWritten := false
+	defer func() {
+		if err == nil || !hadPreviousDep {
+			return
 		}
+		proj.SetDependency(dependencyNameInManifest, previousGroup, previousDep)
+		if tomlWritten && readTomlErr == nil {
+			if restoreErr := fsutil.WriteFileAtomic(filepath.Join(projectRoot, config.ProjectTomlName), previousToml, 0644); restoreErr != nil {
+				_, _ = fmt.Fprintf(stderr, "Warning: Failed to restore the previous '%s' entry in %s during error handling: %v\n", dependencyNameInManifest, config.ProjectTomlName, restoreErr)
+				return
+			}
+		}
+		_, _ = fmt.Fprintf(stderr, "Note: Kept the previous '%s' entry (source %s).\n", dependencyNameInManifest, previousDep.Source)
+	}()
+	// For project.toml, use the canonical source identifier
+	proj.SetDependency(dependencyNameInManifest, group, project.Dependency{
+		Source:      manifestSource,
+		Path:        relativeDestPath,
+		ChecksumURL: checksumURL,
+	})
+
+	// Use a temporary variable for WriteProjectToml's error
+	// Pass projectRoot to WriteProjectToml, not the full path to the file
+	if writeTomlErr := config.WriteProjectToml(projectRoot, proj); writeTomlErr != nil { // proj is already a pointer
+		err = cli.Exit(fmt.Sprintf("Error writing %s: %v. File '%s' was saved but is now being cleaned up. %s may be in an inconsistent state.", config.ProjectTomlName, writeTomlErr, fullPath, config.ProjectTomlName), 1)
+		return
+	}
+	tomlWritten = true
+
+	if verbose {
+		_, _ = fmt.Fprintf(stderr, "Successfully updated %s for dependency '%s'.\n", config.ProjectTomlName, dependencyNameInManifest)
+	}
+
+	// Task 2.8: Implement Lockfile Update
+	if verbose {
+		_, _ = fmt.Fprintln(stderr, "Updating almd-lock.toml...")
+	}
 
+	var lf *lockfile.Lockfile // MODIFIED: Use pointer type and correct package
+	var loadLockErr error
+	lf, loadLockErr = lockfile.Load(projectRoot) // Load or initialize if not found
+	if loadLockErr != nil {
+		err = cli.Exit(fmt.Sprintf("Error loading/initializing %s: %v. File '%s' saved and %s updated, but lockfile operation failed. %s and %s may be inconsistent. Downloaded file '%s' is being cleaned up.", lockfile.LockfileName, loadLockErr, fullPath, config.ProjectTomlName, config.ProjectTomlName, lockfile.LockfileName, fullPath), 1)
+		return
+	}
+
+	// For lockfile, use the exact raw download URL and calculated integrity hash
+	lf.AddOrUpdatePackage(dependencyNameInManifest, lockedRawURL, relativeDestPath, integrityHash)
+	lf.SetContentHash(dependencyNameInManifest, fileContentHash)
+	lf.SetRequested(dependencyNameInManifest, sourceURLInput)
+	lf.SetValidators(dependencyNameInManifest, validators.ETag, validators.LastModified)
+	lf.SetGroup(dependencyNameInManifest, group)
+	if opts.blobSHA != "" {
+		lf.SetBlob(dependencyNameInManifest, opts.blobSHA)
+	}
+	// A pinned range is gone from project.toml, so there is no range for the tag to satisfy.
+	if parsedInfo.VersionRange != "" && !cCtx.Bool("pin") {
+		lf.SetTag(dependencyNameInManifest, parsedInfo.Ref)
+	}
+
+	// Use a temporary variable for lockfile.Save's error
+	if saveLockErr := lockfile.Save(projectRoot, lf); saveLockErr != nil {
+		// Assign to named return 'err'
+		err = cli.Exit(fmt.Sprintf("Error saving %s: %v. File '%s' saved and %s updated, but saving %s failed. %s and %s may be inconsistent. Downloaded file '%s' is being cleaned up.", lockfile.LockfileName, saveLockErr, fullPath, config.ProjectTomlName, lockfile.LockfileName, config.ProjectTomlName, lockfile.LockfileName, fullPath), 1) // MODIFIED
+		return
+	}
+
+	if verbose {
+		_, _ = fmt.Fprintf(stderr, "Successfully updated %s for dependency '%s'.\n", lockfile.LockfileName, dependencyNameInManifest)
+	}
+
+	// --print-path replaces the pnpm-style output with just the path, for shell capture.
+	if cCtx.Bool("print-path") {
+		_, _ = fmt.Fprintln(pathOut, relativeDestPath)
+	} else {
 		// pnpm-style output
-		_, _ = color.New(color.FgWhite).Println("Packages: +1")
-		_, _ = color.New(color.FgGreen).Println("++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++") // Simple progress bar
-		fmt.Println("Progress: resolved 1, downloaded 1, added 1, done")
-		fmt.Println()
-		_, _ = color.New(color.FgWhite, color.Bold).Println("dependencies:")
+		_, _ = output.NewColor(color.FgWhite).Fprintln(stdout, "Packages: +1")
+		_, _ = output.NewColor(color.FgGreen).Fprintln(stdout, "++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++") // Simple progress bar
+		_, _ = fmt.Fprintln(stdout, "Progress: resolved 1, downloaded 1, added 1, done")
+		_, _ = fmt.Fprintln(stdout)
+		heading := "dependencies:"
+		if group == project.GroupDev {
+			heading = "dev-dependencies:"
+		}
+		_, _ = output.NewColor(color.FgWhite, color.Bold).Fprintln(stdout, heading)
 		dependencyVersionStr := parsedInfo.Ref
 		if dependencyVersionStr == "" || strings.HasPrefix(dependencyVersionStr, "error:") {
 			// Fallback if ref is not available or an error
@@ -336,11 +1053,15 @@ var AddCommand = &cli.Command{
 				dependencyVersionStr = "latest" // Or some other placeholder
 			}
 		}
-		_, _ = color.New(color.FgGreen).Printf("+ %s %s\n", dependencyNameInManifest, dependencyVersionStr)
-		fmt.Println()
-		duration := time.Since(startTime)
-		fmt.Printf("Done in %.1fs\n", duration.Seconds())
+		_, _ = output.NewColor(color.FgGreen).Fprintf(stdout, "+ %s %s\n", dependencyNameInManifest, dependencyVersionStr)
+		_, _ = fmt.Fprintln(stdout)
+		duration := opts.now().Sub(startTime)
+		_, _ = fmt.Fprintf(stdout, "Done in %.1fs\n", duration.Seconds())
+	}
+
+	if hasDuplicate {
+		_, _ = fmt.Fprintf(stderr, "note: identical content already installed as %s at %s\n", duplicateName, duplicateEntry.Path)
+	}
 
-		return nil // err is nil, so defer func() will not trigger cleanup
-	},
+	return nil // err is nil, so defer func() will not trigger cleanup
 }
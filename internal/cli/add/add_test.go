@@ -5,16 +5,22 @@
 package add
 
 import (
+	"bytes"
 	"fmt"
+	"io"
 	"net/http"
 	"net/http/httptest"
 	"os"
 	"path/filepath"
 	"strings"
+	"sync/atomic"
 	"testing"
+	"time"
 
 	"github.com/BurntSushi/toml"
 	"github.com/nightconcept/almandine-go/internal/core/config"
+	"github.com/nightconcept/almandine-go/internal/core/downloader"
+	"github.com/nightconcept/almandine-go/internal/core/hasher"
 	"github.com/nightconcept/almandine-go/internal/core/lockfile"
 	"github.com/nightconcept/almandine-go/internal/core/project"
 	"github.com/nightconcept/almandine-go/internal/core/source"
@@ -48,6 +54,26 @@ func setupAddTestEnvironment(t *testing.T, initialProjectTomlContent string) (te
 // addCmdArgs should be the arguments for the 'add' command itself (e.g., URL, flags).
 func runAddCommand(t *testing.T, workDir string, addCmdArgs ...string) error {
 	t.Helper()
+	return runAddCommandWithInput(t, workDir, os.Stdin, addCmdArgs...)
+}
+
+// runAddCommandWithInput is runAddCommand with stdin replaced by the given reader, for
+// driving the --interactive confirmation prompt.
+func runAddCommandWithInput(t *testing.T, workDir string, stdin io.Reader, addCmdArgs ...string) error {
+	t.Helper()
+	return runAddCommandWithIO(t, workDir, stdin, os.Stderr, os.Stderr, addCmdArgs...)
+}
+
+// runAddCommandWithIO is runAddCommandWithInput with the app's stdout and stderr sent to the
+// given writers, so tests can inspect notes and warnings.
+func runAddCommandWithIO(t *testing.T, workDir string, stdin io.Reader, stdout, stderr io.Writer, addCmdArgs ...string) error {
+	t.Helper()
+	return runCommandWithIO(t, AddCommand, workDir, stdin, stdout, stderr, addCmdArgs...)
+}
+
+// runCommandWithIO runs cmd, an 'add' command, like runAddCommandWithIO.
+func runCommandWithIO(t *testing.T, cmd *cli.Command, workDir string, stdin io.Reader, stdout, stderr io.Writer, addCmdArgs ...string) error {
+	t.Helper()
 
 	originalWd, err := os.Getwd()
 	require.NoError(t, err, "Failed to get current working directory")
@@ -60,11 +86,12 @@ func runAddCommand(t *testing.T, workDir string, addCmdArgs ...string) error {
 	app := &cli.App{
 		Name: "almd-test-add",
 		Commands: []*cli.Command{
-			AddCommand,
+			cmd,
 		},
 		// Suppress help printer during tests unless specifically testing help output
-		Writer:    os.Stderr, // Default, or io.Discard for cleaner test logs
-		ErrWriter: os.Stderr, // Default, or io.Discard
+		Reader:    stdin,
+		Writer:    stdout,
+		ErrWriter: stderr,
 		ExitErrHandler: func(context *cli.Context, err error) {
 			// Do nothing by default, let the test assertions handle errors from app.Run()
 			// This prevents os.Exit(1) from urfave/cli from stopping the test run
@@ -120,13 +147,13 @@ func readProjectToml(t *testing.T, tomlPath string) project.Project {
 	return projCfg
 }
 
-// readAlmdLockToml reads and unmarshals an almd-lock.toml file into a project.LockFile struct.
-func readAlmdLockToml(t *testing.T, lockPath string) project.LockFile {
+// readAlmdLockToml reads and unmarshals an almd-lock.toml file into a lockfile.Lockfile struct.
+func readAlmdLockToml(t *testing.T, lockPath string) lockfile.Lockfile {
 	t.Helper()
 	bytes, err := os.ReadFile(lockPath)
 	require.NoError(t, err, "Failed to read almd-lock.toml: %s", lockPath)
 
-	var lockCfg project.LockFile
+	var lockCfg lockfile.Lockfile
 	err = toml.Unmarshal(bytes, &lockCfg)
 	require.NoError(t, err, "Failed to unmarshal almd-lock.toml: %s", lockPath)
 	return lockCfg
@@ -180,10 +207,8 @@ version = "0.1.0"
 	// --- Assertions ---
 
 	// 1. Verify downloaded file content and path
-	// The filename should be the explicit name + extension from source URL path,
-	// based on the observed behavior of the `add` command.
-	extractedSourceFileExtension := filepath.Ext(mockFileURLPath)           // .lua
-	expectedFileNameOnDisk := dependencyName + extractedSourceFileExtension // mylib.lua
+	// --name only sets the manifest key; the file keeps the name from the source URL.
+	expectedFileNameOnDisk := filepath.Base(mockFileURLPath) // mylib_script.lua
 
 	downloadedFilePath := filepath.Join(tempDir, dependencyDir, expectedFileNameOnDisk)
 	require.FileExists(t, downloadedFilePath, "Downloaded file does not exist at expected path: %s", downloadedFilePath)
@@ -211,7 +236,7 @@ version = "0.1.0"
 	require.FileExists(t, lockFilePath, "almd-lock.toml was not created")
 	lockCfg := readAlmdLockToml(t, lockFilePath)
 
-	assert.Equal(t, "1", lockCfg.APIVersion, "API version in almd-lock.toml mismatch")
+	assert.Equal(t, "1", lockCfg.ApiVersion, "API version in almd-lock.toml mismatch")
 	require.NotNil(t, lockCfg.Package, "Packages map in almd-lock.toml is nil")
 	lockPkgEntry, ok := lockCfg.Package[dependencyName]
 	require.True(t, ok, "Package entry not found in almd-lock.toml for: %s", dependencyName)
@@ -222,6 +247,10 @@ version = "0.1.0"
 	// Hash should now reflect the commit SHA from the mocked API call.
 	expectedHash := "commit:" + mockCommitSHA
 	assert.Equal(t, expectedHash, lockPkgEntry.Hash, "Package hash mismatch in almd-lock.toml")
+	// The commit pins the source; the content hash pins the bytes written.
+	expectedContentHash, err := hasher.CalculateSHA256([]byte(mockContent))
+	require.NoError(t, err)
+	assert.Equal(t, expectedContentHash, lockPkgEntry.ContentHash, "Package content hash mismatch in almd-lock.toml")
 }
 
 func TestAddCommand_Success_InferredName_DefaultDir(t *testing.T) {
@@ -300,7 +329,7 @@ version = "0.1.0"
 	require.FileExists(t, lockFilePath, "almd-lock.toml was not created")
 	lockCfg := readAlmdLockToml(t, lockFilePath)
 
-	assert.Equal(t, "1", lockCfg.APIVersion, "API version in almd-lock.toml mismatch")
+	assert.Equal(t, "1", lockCfg.ApiVersion, "API version in almd-lock.toml mismatch")
 	require.NotNil(t, lockCfg.Package, "Packages map in almd-lock.toml is nil")
 	lockPkgEntry, ok := lockCfg.Package[inferredDepName]
 	require.True(t, ok, "Package entry not found in almd-lock.toml for inferred name: %s", inferredDepName)
@@ -311,6 +340,7 @@ version = "0.1.0"
 	// Hash should now reflect the commit SHA from the mocked API call.
 	expectedHash := "commit:" + mockCommitSHA_Inferred
 	assert.Equal(t, expectedHash, lockPkgEntry.Hash, "Package hash mismatch in almd-lock.toml")
+	assert.Equal(t, dependencyURL, lockPkgEntry.Requested, "Requested source should record the argument given to add")
 }
 
 func TestAddCommand_GithubURLWithCommitHash(t *testing.T) {
@@ -387,7 +417,7 @@ version = "0.1.0"
 	require.NoError(t, err, "almd add command failed for GitHub URL with commit hash")
 
 	// --- Assertions ---
-	expectedFileNameOnDisk := dependencyName + ".lua" // mylibcommit.lua
+	expectedFileNameOnDisk := "mylib.lua" // --name does not rename the file
 	downloadedFilePath := filepath.Join(tempDir, dependencyDir, expectedFileNameOnDisk)
 
 	// 1. Verify downloaded file
@@ -633,3 +663,1446 @@ version = "0.1.0"
 	_, err = os.ReadFile(lockFilePath)
 	require.Error(t, err, "Attempting to read %s (which is a dir) as a file should fail", lockfile.LockfileName)
 }
+
+func TestAddCommand_AllowedHostsPolicy_AllowedHostPasses(t *testing.T) {
+	initialTomlContent := `
+[package]
+name = "test-policy-project"
+version = "0.1.0"
+
+[almd]
+allowed_hosts = ["127.0.0.1"]
+`
+	tempDir := setupAddTestEnvironment(t, initialTomlContent)
+
+	mockContent := "return {}"
+	mockFileURLPath := "/owner/repo/main/allowed.lua"
+	mockServer := startMockServer(t, map[string]struct {
+		Body string
+		Code int
+	}{
+		mockFileURLPath: {Body: mockContent, Code: http.StatusOK},
+	})
+
+	err := runAddCommand(t, tempDir, mockServer.URL+mockFileURLPath)
+	require.NoError(t, err, "add from an allowed host should succeed")
+
+	assert.FileExists(t, filepath.Join(tempDir, "src", "lib", "allowed.lua"))
+	projCfg := readProjectToml(t, filepath.Join(tempDir, config.ProjectTomlName))
+	assert.Contains(t, projCfg.Dependencies, "allowed")
+	require.NotNil(t, projCfg.Almd, "[almd] table should survive the rewrite of project.toml")
+	assert.Equal(t, []string{"127.0.0.1"}, projCfg.Almd.AllowedHosts)
+}
+
+func TestAddCommand_AllowedHostsPolicy_DisallowedHostBlocked(t *testing.T) {
+	initialTomlContent := `
+[package]
+name = "test-policy-project"
+version = "0.1.0"
+
+[almd]
+allowed_hosts = ["mirror.example.com"]
+`
+	tempDir := setupAddTestEnvironment(t, initialTomlContent)
+
+	var requestCount int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&requestCount, 1)
+		_, _ = w.Write([]byte("return {}"))
+	}))
+	t.Cleanup(server.Close)
+
+	err := runAddCommand(t, tempDir, server.URL+"/owner/repo/main/blocked.lua")
+	require.Error(t, err, "add from a host outside allowed_hosts should fail")
+	assert.Contains(t, err.Error(), "rejected by policy")
+	assert.Equal(t, int32(0), atomic.LoadInt32(&requestCount), "no network call should be made for a blocked source")
+
+	_, statErr := os.Stat(filepath.Join(tempDir, "src", "lib", "blocked.lua"))
+	assert.True(t, os.IsNotExist(statErr), "blocked dependency must not be written")
+	projCfg := readProjectToml(t, filepath.Join(tempDir, config.ProjectTomlName))
+	assert.Empty(t, projCfg.Dependencies)
+}
+
+func TestAddCommand_NameFromContent_DeclaredName(t *testing.T) {
+	initialTomlContent := `
+[package]
+name = "test-name-from-content"
+version = "0.1.0"
+`
+	tempDir := setupAddTestEnvironment(t, initialTomlContent)
+
+	mockContent := "--- JSON encoding and decoding.\n-- @module json\nlocal json = {}\nreturn json\n"
+	mockFileURLPath := "/rxi/json.lua/master/json_impl.lua"
+	mockServer := startMockServer(t, map[string]struct {
+		Body string
+		Code int
+	}{
+		mockFileURLPath: {Body: mockContent, Code: http.StatusOK},
+	})
+
+	err := runAddCommand(t, tempDir, "--name-from-content", mockServer.URL+mockFileURLPath)
+	require.NoError(t, err, "almd add --name-from-content failed")
+
+	// The manifest key comes from the declaration; the file keeps its upstream name.
+	assert.FileExists(t, filepath.Join(tempDir, "src", "lib", "json_impl.lua"))
+	projCfg := readProjectToml(t, filepath.Join(tempDir, config.ProjectTomlName))
+	require.Contains(t, projCfg.Dependencies, "json")
+	assert.NotContains(t, projCfg.Dependencies, "json_impl")
+	assert.Equal(t, "src/lib/json_impl.lua", projCfg.Dependencies["json"].Path)
+
+	lockCfg := readAlmdLockToml(t, filepath.Join(tempDir, lockfile.LockfileName))
+	assert.Contains(t, lockCfg.Package, "json")
+}
+
+func TestAddCommand_NameFromContent_NoDeclarationFallsBack(t *testing.T) {
+	initialTomlContent := `
+[package]
+name = "test-name-from-content"
+version = "0.1.0"
+`
+	tempDir := setupAddTestEnvironment(t, initialTomlContent)
+
+	mockContent := "-- A library without a module declaration\nlocal lib = {}\nreturn lib\n"
+	mockFileURLPath := "/owner/repo/main/plainlib.lua"
+	mockServer := startMockServer(t, map[string]struct {
+		Body string
+		Code int
+	}{
+		mockFileURLPath: {Body: mockContent, Code: http.StatusOK},
+	})
+
+	err := runAddCommand(t, tempDir, "--name-from-content", mockServer.URL+mockFileURLPath)
+	require.NoError(t, err, "almd add --name-from-content failed")
+
+	projCfg := readProjectToml(t, filepath.Join(tempDir, config.ProjectTomlName))
+	assert.Contains(t, projCfg.Dependencies, "plainlib", "should fall back to the filename-based name")
+}
+
+func TestAddCommand_NameFromContent_ExplicitNameWins(t *testing.T) {
+	initialTomlContent := `
+[package]
+name = "test-name-from-content"
+version = "0.1.0"
+`
+	tempDir := setupAddTestEnvironment(t, initialTomlContent)
+
+	mockContent := "-- @module json\nreturn {}\n"
+	mockFileURLPath := "/owner/repo/main/json_impl.lua"
+	mockServer := startMockServer(t, map[string]struct {
+		Body string
+		Code int
+	}{
+		mockFileURLPath: {Body: mockContent, Code: http.StatusOK},
+	})
+
+	err := runAddCommand(t, tempDir, "--name-from-content", "-n", "myjson", mockServer.URL+mockFileURLPath)
+	require.NoError(t, err)
+
+	projCfg := readProjectToml(t, filepath.Join(tempDir, config.ProjectTomlName))
+	assert.Contains(t, projCfg.Dependencies, "myjson")
+	assert.NotContains(t, projCfg.Dependencies, "json")
+}
+
+func TestAddCommand_NameFromContent_CustomPattern(t *testing.T) {
+	initialTomlContent := `
+[package]
+name = "test-name-from-content"
+version = "0.1.0"
+`
+	tempDir := setupAddTestEnvironment(t, initialTomlContent)
+
+	mockContent := "-- name: inspect\nreturn {}\n"
+	mockFileURLPath := "/owner/repo/main/inspect_impl.lua"
+	mockServer := startMockServer(t, map[string]struct {
+		Body string
+		Code int
+	}{
+		mockFileURLPath: {Body: mockContent, Code: http.StatusOK},
+	})
+
+	err := runAddCommand(t, tempDir, "--name-from-content", "--name-pattern", `^--\s*name:\s*(\S+)`, mockServer.URL+mockFileURLPath)
+	require.NoError(t, err)
+
+	projCfg := readProjectToml(t, filepath.Join(tempDir, config.ProjectTomlName))
+	assert.Contains(t, projCfg.Dependencies, "inspect")
+}
+
+func TestAddCommand_Interactive(t *testing.T) {
+	initialTomlContent := `
+[package]
+name = "test-interactive"
+version = "0.1.0"
+`
+	mockContent := "-- line 1\n-- line 2\nreturn {}\n"
+	mockFileURLPath := "/owner/repo/main/reviewed.lua"
+	mockServer := startMockServer(t, map[string]struct {
+		Body string
+		Code int
+	}{
+		mockFileURLPath: {Body: mockContent, Code: http.StatusOK},
+	})
+
+	t.Run("declined writes nothing", func(t *testing.T) {
+		tempDir := setupAddTestEnvironment(t, initialTomlContent)
+
+		err := runAddCommandWithInput(t, tempDir, strings.NewReader("n\n"), "-i", mockServer.URL+mockFileURLPath)
+		require.NoError(t, err)
+
+		_, statErr := os.Stat(filepath.Join(tempDir, "src", "lib", "reviewed.lua"))
+		assert.True(t, os.IsNotExist(statErr), "declined dependency must not be written")
+		_, statErr = os.Stat(filepath.Join(tempDir, lockfile.LockfileName))
+		assert.True(t, os.IsNotExist(statErr), "lockfile must not be created when declined")
+		projCfg := readProjectToml(t, filepath.Join(tempDir, config.ProjectTomlName))
+		assert.Empty(t, projCfg.Dependencies)
+	})
+
+	t.Run("accepted saves the file", func(t *testing.T) {
+		tempDir := setupAddTestEnvironment(t, initialTomlContent)
+
+		err := runAddCommandWithInput(t, tempDir, strings.NewReader("y\n"), "--interactive", mockServer.URL+mockFileURLPath)
+		require.NoError(t, err)
+
+		assert.FileExists(t, filepath.Join(tempDir, "src", "lib", "reviewed.lua"))
+		projCfg := readProjectToml(t, filepath.Join(tempDir, config.ProjectTomlName))
+		assert.Contains(t, projCfg.Dependencies, "reviewed")
+	})
+
+	t.Run("non-terminal stdin requires --yes", func(t *testing.T) {
+		tempDir := setupAddTestEnvironment(t, initialTomlContent)
+
+		r, w, pipeErr := os.Pipe()
+		require.NoError(t, pipeErr)
+		_, _ = w.WriteString("y\n")
+		_ = w.Close()
+		defer func() { _ = r.Close() }()
+
+		err := runAddCommandWithInput(t, tempDir, r, "-i", mockServer.URL+mockFileURLPath)
+		require.Error(t, err)
+		assert.Contains(t, err.Error(), "--yes")
+		_, statErr := os.Stat(filepath.Join(tempDir, "src", "lib", "reviewed.lua"))
+		assert.True(t, os.IsNotExist(statErr))
+
+		err = runAddCommandWithInput(t, tempDir, r, "-i", "--yes", mockServer.URL+mockFileURLPath)
+		require.NoError(t, err)
+		assert.FileExists(t, filepath.Join(tempDir, "src", "lib", "reviewed.lua"))
+	})
+}
+
+func TestWritePreview(t *testing.T) {
+	var out strings.Builder
+	writePreview(&out, []byte("a\nb\nc\nd\n"), 2)
+	assert.Equal(t, "  | a\n  | b\n  | ... (2 more lines)\n", out.String())
+
+	out.Reset()
+	writePreview(&out, []byte("a\nb\n"), 0)
+	assert.Equal(t, "  | a\n  | b\n", out.String())
+}
+
+func TestAddCommand_Registry(t *testing.T) {
+	mockContent := "return 'json'"
+	mockFileURLPath := "/rxi/json.lua/master/json.lua"
+	mockServer := startMockServer(t, map[string]struct {
+		Body string
+		Code int
+	}{
+		mockFileURLPath: {Body: mockContent, Code: http.StatusOK},
+	})
+	// Remote registries are cached under the user cache directory.
+	t.Setenv("XDG_CACHE_HOME", t.TempDir())
+
+	initialTomlContent := `
+[package]
+name = "test-registry"
+version = "0.1.0"
+
+[almd]
+registry = "registry.toml"
+`
+	registryContent := fmt.Sprintf("json = %q\n", mockServer.URL+mockFileURLPath)
+
+	t.Run("registered name resolves", func(t *testing.T) {
+		tempDir := setupAddTestEnvironment(t, initialTomlContent)
+		require.NoError(t, os.WriteFile(filepath.Join(tempDir, "registry.toml"), []byte(registryContent), 0644))
+
+		err := runAddCommand(t, tempDir, "json")
+		require.NoError(t, err)
+
+		assert.FileExists(t, filepath.Join(tempDir, "src", "lib", "json.lua"))
+		projCfg := readProjectToml(t, filepath.Join(tempDir, config.ProjectTomlName))
+		require.Contains(t, projCfg.Dependencies, "json")
+		assert.Equal(t, "github:rxi/json.lua/json.lua@master", projCfg.Dependencies["json"].Source)
+		lockCfg := readAlmdLockToml(t, filepath.Join(tempDir, lockfile.LockfileName))
+		assert.Equal(t, "json", lockCfg.Package["json"].Requested, "the lockfile should record the name the user typed")
+	})
+
+	t.Run("unregistered name falls back to URL parsing", func(t *testing.T) {
+		tempDir := setupAddTestEnvironment(t, initialTomlContent)
+		require.NoError(t, os.WriteFile(filepath.Join(tempDir, "registry.toml"), []byte(registryContent), 0644))
+
+		err := runAddCommand(t, tempDir, "yaml")
+		require.Error(t, err)
+		assert.Contains(t, err.Error(), "Error parsing source URL 'yaml'")
+	})
+
+	t.Run("remote registry via flag", func(t *testing.T) {
+		registryServer := startMockServer(t, map[string]struct {
+			Body string
+			Code int
+		}{
+			"/registry.toml": {Body: registryContent, Code: http.StatusOK},
+		})
+		tempDir := setupAddTestEnvironment(t, `
+[package]
+name = "test-registry-flag"
+version = "0.1.0"
+`)
+
+		err := runAddCommand(t, tempDir, "--registry", registryServer.URL+"/registry.toml", "json")
+		require.NoError(t, err)
+		assert.FileExists(t, filepath.Join(tempDir, "src", "lib", "json.lua"))
+	})
+}
+
+func TestAddCommand_IdenticalContentNotice(t *testing.T) {
+	initialTomlContent := `
+[package]
+name = "test-dedupe"
+version = "0.1.0"
+`
+	snippet := "local function clamp(x, lo, hi) return math.max(lo, math.min(hi, x)) end\nreturn clamp\n"
+	mockServer := startMockServer(t, map[string]struct {
+		Body string
+		Code int
+	}{
+		"/owner/repo/main/clamp.lua":       {Body: snippet, Code: http.StatusOK},
+		"/other/utils/main/clamp_copy.lua": {Body: snippet, Code: http.StatusOK},
+	})
+
+	tempDir := setupAddTestEnvironment(t, initialTomlContent)
+	require.NoError(t, runAddCommand(t, tempDir, mockServer.URL+"/owner/repo/main/clamp.lua"))
+
+	t.Run("second add prints a note", func(t *testing.T) {
+		var stderr bytes.Buffer
+		err := runAddCommandWithIO(t, tempDir, nil, io.Discard, &stderr, mockServer.URL+"/other/utils/main/clamp_copy.lua")
+		require.NoError(t, err)
+		assert.Contains(t, stderr.String(), "note: identical content already installed as clamp at src/lib/clamp.lua")
+		assert.FileExists(t, filepath.Join(tempDir, "src", "lib", "clamp_copy.lua"))
+	})
+
+	t.Run("--dedupe skips the duplicate", func(t *testing.T) {
+		var stderr bytes.Buffer
+		err := runAddCommandWithIO(t, tempDir, nil, io.Discard, &stderr, "--dedupe", "-n", "clamp_again", mockServer.URL+"/other/utils/main/clamp_copy.lua")
+		require.NoError(t, err)
+		assert.Contains(t, stderr.String(), "Identical content is already installed as clamp")
+		_, statErr := os.Stat(filepath.Join(tempDir, "src", "lib", "clamp_again.lua"))
+		assert.True(t, os.IsNotExist(statErr))
+		projCfg := readProjectToml(t, filepath.Join(tempDir, config.ProjectTomlName))
+		assert.NotContains(t, projCfg.Dependencies, "clamp_again")
+	})
+}
+
+func TestAddCommand_SameSourceDifferentURLForms(t *testing.T) {
+	initialTomlContent := `
+[package]
+name = "test-same-source"
+version = "0.1.0"
+`
+	mockServer := startMockServer(t, map[string]struct {
+		Body string
+		Code int
+	}{
+		"/owner/repo/main/lib.lua": {Body: "return {}\n", Code: http.StatusOK},
+	})
+	// The github: shorthand resolves its download URL against the API base URL in test mode.
+	originalBaseURL := source.GithubAPIBaseURL
+	source.GithubAPIBaseURL = mockServer.URL
+	t.Cleanup(func() { source.GithubAPIBaseURL = originalBaseURL })
+
+	tempDir := setupAddTestEnvironment(t, initialTomlContent)
+	require.NoError(t, runAddCommand(t, tempDir, mockServer.URL+"/owner/repo/main/lib.lua"))
+
+	t.Run("second form under another name is rejected", func(t *testing.T) {
+		err := runAddCommand(t, tempDir, "-n", "lib_again", "github:owner/repo/lib.lua@main")
+		require.Error(t, err)
+		assert.Contains(t, err.Error(), "already in project.toml as 'lib'")
+		assert.Contains(t, err.Error(), "--force")
+		projCfg := readProjectToml(t, filepath.Join(tempDir, config.ProjectTomlName))
+		assert.NotContains(t, projCfg.Dependencies, "lib_again")
+	})
+
+	t.Run("same name needs --force to update the existing entry", func(t *testing.T) {
+		err := runAddCommand(t, tempDir, "github:owner/repo/lib.lua@main")
+		require.Error(t, err)
+		assert.Contains(t, err.Error(), "'lib' is already in project.toml with source")
+
+		require.NoError(t, runAddCommand(t, tempDir, "--force", "github:owner/repo/lib.lua@main"))
+		projCfg := readProjectToml(t, filepath.Join(tempDir, config.ProjectTomlName))
+		assert.Len(t, projCfg.Dependencies, 1)
+	})
+
+	t.Run("--force adds a second reference with a warning", func(t *testing.T) {
+		var stderr bytes.Buffer
+		err := runAddCommandWithIO(t, tempDir, nil, io.Discard, &stderr, "--force", "-n", "lib_again", "--filename", "lib_again.lua", "github:owner/repo/lib.lua@main")
+		require.NoError(t, err)
+		assert.Contains(t, stderr.String(), "Warning: github:owner/repo/lib.lua@main is already in project.toml as 'lib'")
+		projCfg := readProjectToml(t, filepath.Join(tempDir, config.ProjectTomlName))
+		assert.Contains(t, projCfg.Dependencies, "lib")
+		assert.Contains(t, projCfg.Dependencies, "lib_again")
+	})
+}
+
+func TestAddCommand_MaxSize(t *testing.T) {
+	initialTomlContent := `
+[package]
+name = "test-max-size"
+version = "0.1.0"
+`
+	mockServer := startMockServer(t, map[string]struct {
+		Body string
+		Code int
+	}{
+		"/owner/repo/main/big.lua": {Body: strings.Repeat("-- padding\n", 100), Code: http.StatusOK},
+	})
+	tempDir := setupAddTestEnvironment(t, initialTomlContent)
+
+	err := runAddCommand(t, tempDir, "--max-size", "512B", mockServer.URL+"/owner/repo/main/big.lua")
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "file exceeds max size of 512 bytes")
+	assert.NoFileExists(t, filepath.Join(tempDir, "src", "lib", "big.lua"))
+
+	err = runAddCommand(t, tempDir, "--max-size", "lots", mockServer.URL+"/owner/repo/main/big.lua")
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "Invalid --max-size")
+
+	require.NoError(t, runAddCommand(t, tempDir, "--max-size", "2KB", mockServer.URL+"/owner/repo/main/big.lua"))
+	assert.FileExists(t, filepath.Join(tempDir, "src", "lib", "big.lua"))
+}
+
+func TestAddCommand_ChecksumURL(t *testing.T) {
+	initialTomlContent := `
+[package]
+name = "test-checksum"
+version = "0.1.0"
+`
+	content := "return { version = '1.0' }\n"
+	contentHash, err := hasher.CalculateSHA256([]byte(content))
+	require.NoError(t, err)
+	publishedHex := strings.TrimPrefix(contentHash, "sha256:")
+
+	mockServer := startMockServer(t, map[string]struct {
+		Body string
+		Code int
+	}{
+		"/owner/repo/main/lib.lua":        {Body: content, Code: http.StatusOK},
+		"/owner/repo/main/lib.lua.sha256": {Body: publishedHex + "  lib.lua\n", Code: http.StatusOK},
+		"/owner/repo/main/bad.sha256":     {Body: strings.Repeat("0", 64) + "  lib.lua\n", Code: http.StatusOK},
+	})
+
+	t.Run("matching checksum is recorded", func(t *testing.T) {
+		tempDir := setupAddTestEnvironment(t, initialTomlContent)
+		checksumURL := mockServer.URL + "/owner/repo/main/lib.lua.sha256"
+
+		require.NoError(t, runAddCommand(t, tempDir, "--checksum-url", checksumURL, mockServer.URL+"/owner/repo/main/lib.lua"))
+
+		projCfg := readProjectToml(t, filepath.Join(tempDir, config.ProjectTomlName))
+		assert.Equal(t, checksumURL, projCfg.Dependencies["lib"].ChecksumURL)
+		lockCfg := readAlmdLockToml(t, filepath.Join(tempDir, lockfile.LockfileName))
+		assert.Equal(t, contentHash, lockCfg.Package["lib"].Hash, "the published hash is the integrity value")
+	})
+
+	t.Run("mismatching checksum aborts without writing", func(t *testing.T) {
+		tempDir := setupAddTestEnvironment(t, initialTomlContent)
+
+		err := runAddCommand(t, tempDir, "--checksum-url", mockServer.URL+"/owner/repo/main/bad.sha256", mockServer.URL+"/owner/repo/main/lib.lua")
+		require.Error(t, err)
+		assert.Contains(t, err.Error(), "Checksum mismatch")
+		assert.NoFileExists(t, filepath.Join(tempDir, "src", "lib", "lib.lua"))
+		assert.NoFileExists(t, filepath.Join(tempDir, lockfile.LockfileName))
+		projCfg := readProjectToml(t, filepath.Join(tempDir, config.ProjectTomlName))
+		assert.Empty(t, projCfg.Dependencies)
+	})
+
+	t.Run("checksum URL outside allowed_hosts is rejected", func(t *testing.T) {
+		tempDir := setupAddTestEnvironment(t, initialTomlContent+"\n[almd]\nallowed_hosts = [\"127.0.0.1\"]\n")
+		checksumURL := strings.Replace(mockServer.URL, "127.0.0.1", "localhost", 1) + "/owner/repo/main/lib.lua.sha256"
+
+		err := runAddCommand(t, tempDir, "--checksum-url", checksumURL, mockServer.URL+"/owner/repo/main/lib.lua")
+		require.Error(t, err)
+		assert.Contains(t, err.Error(), "Checksum URL '"+checksumURL+"' rejected by policy")
+		assert.NoFileExists(t, filepath.Join(tempDir, "src", "lib", "lib.lua"))
+		assert.NoFileExists(t, filepath.Join(tempDir, lockfile.LockfileName))
+		projCfg := readProjectToml(t, filepath.Join(tempDir, config.ProjectTomlName))
+		assert.Empty(t, projCfg.Dependencies)
+	})
+}
+
+func TestAddCommand_LockedCommitContentMismatch(t *testing.T) {
+	initialTomlContent := `
+[package]
+name = "test-content-mismatch"
+version = "0.1.0"
+`
+	commitSHA := "abababababababababababababababababababab"
+	mockServer := startMockServer(t, map[string]struct {
+		Body string
+		Code int
+	}{
+		fmt.Sprintf("/owner/repo/%s/lib.lua", commitSHA): {Body: "return 'rewritten'", Code: http.StatusOK},
+	})
+	lockedHash, err := hasher.CalculateSHA256([]byte("return 'original'"))
+	require.NoError(t, err)
+	lockContent := fmt.Sprintf(`api_version = "1"
+
+[package.lib]
+source = "%s/owner/repo/%s/lib.lua"
+path = "src/lib/lib.lua"
+hash = "commit:%s"
+content_hash = "%s"
+`, mockServer.URL, commitSHA, commitSHA, lockedHash)
+
+	tempDir := setupAddTestEnvironment(t, initialTomlContent)
+	require.NoError(t, os.WriteFile(filepath.Join(tempDir, lockfile.LockfileName), []byte(lockContent), 0644))
+
+	err = runAddCommand(t, tempDir, fmt.Sprintf("%s/owner/repo/%s/lib.lua", mockServer.URL, commitSHA))
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "Refusing to add 'lib'")
+	assert.NoFileExists(t, filepath.Join(tempDir, "src", "lib", "lib.lua"))
+	currentLock, err := os.ReadFile(filepath.Join(tempDir, lockfile.LockfileName))
+	require.NoError(t, err)
+	assert.Equal(t, lockContent, string(currentLock))
+	projCfg := readProjectToml(t, filepath.Join(tempDir, config.ProjectTomlName))
+	assert.Empty(t, projCfg.Dependencies)
+}
+
+func TestAddCommand_PlainHTTPSource(t *testing.T) {
+	// Without the test-mode bypass, the mock server's URL is an ordinary web server.
+	source.SetTestModeBypassHostValidation(false)
+	t.Cleanup(func() { source.SetTestModeBypassHostValidation(true) })
+
+	content := "return 'served'"
+	mockServer := startMockServer(t, map[string]struct {
+		Body string
+		Code int
+	}{
+		"/libs/foo.lua": {Body: content, Code: http.StatusOK},
+	})
+	tempDir := setupAddTestEnvironment(t, "[package]\nname = \"test-http\"\nversion = \"0.1.0\"\n")
+	sourceURL := mockServer.URL + "/libs/foo.lua"
+
+	require.NoError(t, runAddCommand(t, tempDir, sourceURL))
+
+	projCfg := readProjectToml(t, filepath.Join(tempDir, config.ProjectTomlName))
+	assert.Equal(t, sourceURL, projCfg.Dependencies["foo"].Source, "the URL itself is the canonical source")
+	written, err := os.ReadFile(filepath.Join(tempDir, "src", "lib", "foo.lua"))
+	require.NoError(t, err)
+	assert.Equal(t, content, string(written))
+
+	contentHash, err := hasher.CalculateSHA256([]byte(content))
+	require.NoError(t, err)
+	lockCfg := readAlmdLockToml(t, filepath.Join(tempDir, lockfile.LockfileName))
+	assert.Equal(t, sourceURL, lockCfg.Package["foo"].Source)
+	assert.Equal(t, contentHash, lockCfg.Package["foo"].Hash, "without commits the content hash is the lock")
+}
+
+func TestAddCommand_DependencyNameValidation(t *testing.T) {
+	source.SetTestModeBypassHostValidation(false)
+	t.Cleanup(func() { source.SetTestModeBypassHostValidation(true) })
+
+	mockServer := startMockServer(t, map[string]struct {
+		Body string
+		Code int
+	}{
+		"/libs/my lib (v2).lua": {Body: "return 'spaced'", Code: http.StatusOK},
+		"/libs/@@@.lua":         {Body: "return 'symbols'", Code: http.StatusOK},
+	})
+	initialTomlContent := "[package]\nname = \"test-names\"\nversion = \"0.1.0\"\n"
+
+	t.Run("inferred name is normalized", func(t *testing.T) {
+		tempDir := setupAddTestEnvironment(t, initialTomlContent)
+
+		var stderr bytes.Buffer
+		require.NoError(t, runAddCommandWithIO(t, tempDir, nil, io.Discard, &stderr, mockServer.URL+"/libs/my%20lib%20(v2).lua"))
+		assert.Contains(t, stderr.String(), "Note: 'my lib (v2)' is not a valid dependency name; using 'my-lib-v2'.")
+
+		projCfg := readProjectToml(t, filepath.Join(tempDir, config.ProjectTomlName))
+		require.Contains(t, projCfg.Dependencies, "my-lib-v2")
+		assert.Equal(t, "src/lib/my lib (v2).lua", projCfg.Dependencies["my-lib-v2"].Path, "only the name is normalized, not the file")
+		assert.Contains(t, readAlmdLockToml(t, filepath.Join(tempDir, lockfile.LockfileName)).Package, "my-lib-v2")
+	})
+
+	t.Run("name that cannot be normalized asks for -n", func(t *testing.T) {
+		tempDir := setupAddTestEnvironment(t, initialTomlContent)
+
+		err := runAddCommand(t, tempDir, mockServer.URL+"/libs/@@@.lua")
+		require.Error(t, err)
+		assert.Contains(t, err.Error(), "Could not infer a valid dependency name from '@@@'")
+		assert.Contains(t, err.Error(), "Use -n to specify a name")
+		assert.NoFileExists(t, filepath.Join(tempDir, "src", "lib", "@@@.lua"))
+	})
+
+	t.Run("invalid --name is rejected before downloading", func(t *testing.T) {
+		tempDir := setupAddTestEnvironment(t, initialTomlContent)
+
+		err := runAddCommand(t, tempDir, "-n", "libs/json", mockServer.URL+"/libs/@@@.lua")
+		require.Error(t, err)
+		assert.Contains(t, err.Error(), "Invalid --name: dependency name 'libs/json' may contain only letters, digits")
+	})
+}
+
+func TestAddCommand_DefaultDependencyDir(t *testing.T) {
+	initialTomlContent := `
+[package]
+name = "test-default-dir"
+version = "0.1.0"
+
+[almd]
+default_dependency_dir = "deps"
+`
+	mockServer := startMockServer(t, map[string]struct {
+		Body string
+		Code int
+	}{
+		"/owner/repo/main/lib.lua": {Body: "return {}", Code: http.StatusOK},
+	})
+	sourceURL := mockServer.URL + "/owner/repo/main/lib.lua"
+
+	t.Run("project default is used without -d", func(t *testing.T) {
+		tempDir := setupAddTestEnvironment(t, initialTomlContent)
+		require.NoError(t, runAddCommand(t, tempDir, sourceURL))
+
+		assert.FileExists(t, filepath.Join(tempDir, "deps", "lib.lua"))
+		projCfg := readProjectToml(t, filepath.Join(tempDir, config.ProjectTomlName))
+		assert.Equal(t, "deps/lib.lua", projCfg.Dependencies["lib"].Path)
+	})
+
+	t.Run("explicit -d overrides the project default", func(t *testing.T) {
+		tempDir := setupAddTestEnvironment(t, initialTomlContent)
+		require.NoError(t, runAddCommand(t, tempDir, "-d", "vendor", sourceURL))
+
+		assert.FileExists(t, filepath.Join(tempDir, "vendor", "lib.lua"))
+		assert.NoDirExists(t, filepath.Join(tempDir, "deps"))
+	})
+}
+
+func TestAddCommand_PrintPath(t *testing.T) {
+	initialTomlContent := `
+[package]
+name = "test-print-path"
+version = "0.1.0"
+`
+	mockServer := startMockServer(t, map[string]struct {
+		Body string
+		Code int
+	}{
+		"/owner/repo/main/path.lua": {Body: "return {}\n", Code: http.StatusOK},
+	})
+	tempDir := setupAddTestEnvironment(t, initialTomlContent)
+
+	var stdout bytes.Buffer
+	err := runAddCommandWithIO(t, tempDir, nil, &stdout, io.Discard, "--print-path", "-d", "vendor", mockServer.URL+"/owner/repo/main/path.lua")
+	require.NoError(t, err)
+	assert.Equal(t, "vendor/path.lua\n", stdout.String())
+	assert.FileExists(t, filepath.Join(tempDir, "vendor", "path.lua"))
+}
+
+func TestAddCommand_FrozenClock(t *testing.T) {
+	// Each read of the clock advances it by 1.5s, so start and finish are 1.5s apart
+	// regardless of how long the command really takes.
+	start := time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+	reads := 0
+	cmd := newAddCommand(func() time.Time {
+		reads++
+		return start.Add(time.Duration(reads-1) * 1500 * time.Millisecond)
+	})
+
+	initialTomlContent := `
+[package]
+name = "test-frozen-clock"
+version = "0.1.0"
+`
+	mockServer := startMockServer(t, map[string]struct {
+		Body string
+		Code int
+	}{
+		"/owner/repo/main/clock.lua": {Body: "return {}\n", Code: http.StatusOK},
+	})
+	tempDir := setupAddTestEnvironment(t, initialTomlContent)
+
+	var stdout bytes.Buffer
+	err := runCommandWithIO(t, cmd, tempDir, nil, &stdout, io.Discard, mockServer.URL+"/owner/repo/main/clock.lua")
+	require.NoError(t, err)
+	assert.Equal(t, 2, reads, "the clock is read once at the start and once at the end")
+	assert.Contains(t, stdout.String(), "Done in 1.5s\n")
+}
+
+func TestAddCommand_FromLockfile(t *testing.T) {
+	initialTomlContent := `
+[package]
+name = "test-from-lockfile"
+version = "0.1.0"
+`
+	lockContent := `
+api_version = "1"
+
+[package.json]
+source = "https://raw.githubusercontent.com/rxi/json.lua/dbf4b2dd2eb7c23be2773c89eb059dadd6436f94/json.lua"
+path = "libs/json.lua"
+hash = "commit:dbf4b2dd2eb7c23be2773c89eb059dadd6436f94"
+
+[package.inspect]
+source = "https://raw.githubusercontent.com/kikito/inspect.lua/0123456789abcdef0123456789abcdef01234567/inspect.lua"
+path = "libs/inspect.lua"
+hash = "commit:0123456789abcdef0123456789abcdef01234567"
+requested = "github:kikito/inspect.lua/inspect.lua@master"
+`
+
+	t.Run("derives the source from the locked URL", func(t *testing.T) {
+		tempDir := setupAddTestEnvironment(t, initialTomlContent)
+		require.NoError(t, os.WriteFile(filepath.Join(tempDir, lockfile.LockfileName), []byte(lockContent), 0644))
+
+		var stdout bytes.Buffer
+		require.NoError(t, runAddCommandWithIO(t, tempDir, nil, &stdout, io.Discard, "--from-lockfile", "json"))
+
+		projCfg := readProjectToml(t, filepath.Join(tempDir, config.ProjectTomlName))
+		require.Contains(t, projCfg.Dependencies, "json")
+		assert.Equal(t, "github:rxi/json.lua/json.lua@dbf4b2dd2eb7c23be2773c89eb059dadd6436f94", projCfg.Dependencies["json"].Source)
+		assert.Equal(t, "libs/json.lua", projCfg.Dependencies["json"].Path)
+		assert.Contains(t, stdout.String(), "Restored json to project.toml")
+		assert.Contains(t, stdout.String(), "run 'almd install json'")
+	})
+
+	t.Run("prefers the originally requested source", func(t *testing.T) {
+		tempDir := setupAddTestEnvironment(t, initialTomlContent)
+		require.NoError(t, os.WriteFile(filepath.Join(tempDir, lockfile.LockfileName), []byte(lockContent), 0644))
+
+		require.NoError(t, runAddCommand(t, tempDir, "--from-lockfile", "inspect"))
+
+		projCfg := readProjectToml(t, filepath.Join(tempDir, config.ProjectTomlName))
+		assert.Equal(t, "github:kikito/inspect.lua/inspect.lua@master", projCfg.Dependencies["inspect"].Source)
+	})
+
+	t.Run("unknown or already present names fail", func(t *testing.T) {
+		tempDir := setupAddTestEnvironment(t, initialTomlContent)
+		require.NoError(t, os.WriteFile(filepath.Join(tempDir, lockfile.LockfileName), []byte(lockContent), 0644))
+
+		err := runAddCommand(t, tempDir, "--from-lockfile", "yaml")
+		require.Error(t, err)
+		assert.Contains(t, err.Error(), "Dependency 'yaml' not found in almd-lock.toml")
+
+		require.NoError(t, runAddCommand(t, tempDir, "--from-lockfile", "json"))
+		err = runAddCommand(t, tempDir, "--from-lockfile", "json")
+		require.Error(t, err)
+		assert.Contains(t, err.Error(), "already in project.toml")
+	})
+}
+
+func TestAddCommand_MultipleSources(t *testing.T) {
+	// broken.lua answers 500; fail on the first attempt instead of retrying it.
+	downloader.MaxRetries = 0
+	defer func() { downloader.MaxRetries = 3 }()
+	initialTomlContent := `
+[package]
+name = "test-multiple"
+version = "0.1.0"
+`
+	mockServer := startMockServer(t, map[string]struct {
+		Body string
+		Code int
+	}{
+		"/owner/repo/main/first.lua":  {Body: "return 'first'\n", Code: http.StatusOK},
+		"/owner/repo/main/third.lua":  {Body: "return 'third'\n", Code: http.StatusOK},
+		"/owner/repo/main/broken.lua": {Body: "boom", Code: http.StatusInternalServerError},
+	})
+
+	t.Run("all succeed into a shared directory", func(t *testing.T) {
+		tempDir := setupAddTestEnvironment(t, initialTomlContent)
+
+		err := runAddCommand(t, tempDir, "-d", "vendor", mockServer.URL+"/owner/repo/main/first.lua", mockServer.URL+"/owner/repo/main/third.lua")
+		require.NoError(t, err)
+
+		projCfg := readProjectToml(t, filepath.Join(tempDir, config.ProjectTomlName))
+		assert.Equal(t, "vendor/first.lua", projCfg.Dependencies["first"].Path)
+		assert.Equal(t, "vendor/third.lua", projCfg.Dependencies["third"].Path)
+		lockCfg := readAlmdLockToml(t, filepath.Join(tempDir, lockfile.LockfileName))
+		assert.Contains(t, lockCfg.Package, "first")
+		assert.Contains(t, lockCfg.Package, "third")
+	})
+
+	t.Run("partial failure keeps the others", func(t *testing.T) {
+		tempDir := setupAddTestEnvironment(t, initialTomlContent)
+		brokenURL := mockServer.URL + "/owner/repo/main/broken.lua"
+
+		var stderr bytes.Buffer
+		err := runAddCommandWithIO(t, tempDir, nil, io.Discard, &stderr, mockServer.URL+"/owner/repo/main/first.lua", brokenURL, mockServer.URL+"/owner/repo/main/third.lua")
+		require.Error(t, err)
+		assert.Contains(t, err.Error(), "Failed to add 1 of 3 sources: "+brokenURL)
+		assert.Contains(t, stderr.String(), "Error downloading file from '"+brokenURL+"'")
+
+		projCfg := readProjectToml(t, filepath.Join(tempDir, config.ProjectTomlName))
+		assert.Contains(t, projCfg.Dependencies, "first")
+		assert.Contains(t, projCfg.Dependencies, "third", "sources after the failure are still added")
+		assert.NotContains(t, projCfg.Dependencies, "broken")
+		assert.FileExists(t, filepath.Join(tempDir, "src", "lib", "first.lua"))
+	})
+
+	t.Run("name flag is rejected", func(t *testing.T) {
+		tempDir := setupAddTestEnvironment(t, initialTomlContent)
+
+		err := runAddCommand(t, tempDir, "-n", "lib", mockServer.URL+"/owner/repo/main/first.lua", mockServer.URL+"/owner/repo/main/third.lua")
+		require.Error(t, err)
+		assert.Contains(t, err.Error(), "--name cannot be used when adding more than one source")
+		assert.NoFileExists(t, filepath.Join(tempDir, "src", "lib", "first.lua"))
+	})
+}
+
+func TestAddCommand_From(t *testing.T) {
+	downloader.MaxRetries = 0
+	defer func() { downloader.MaxRetries = 3 }()
+	initialTomlContent := `
+[package]
+name = "test-from"
+version = "0.1.0"
+`
+	mockServer := startMockServer(t, map[string]struct {
+		Body string
+		Code int
+	}{
+		"/owner/repo/main/first.lua":  {Body: "return 'first'\n", Code: http.StatusOK},
+		"/owner/repo/main/third.lua":  {Body: "return 'third'\n", Code: http.StatusOK},
+		"/owner/repo/main/broken.lua": {Body: "boom", Code: http.StatusInternalServerError},
+	})
+	writeList := func(t *testing.T, dir, content string) string {
+		listPath := filepath.Join(dir, "deps.txt")
+		require.NoError(t, os.WriteFile(listPath, []byte(content), 0644))
+		return listPath
+	}
+
+	t.Run("adds each line with its own name and directory", func(t *testing.T) {
+		tempDir := setupAddTestEnvironment(t, initialTomlContent)
+		listPath := writeList(t, tempDir, "# project deps\n\n"+
+			mockServer.URL+"/owner/repo/main/first.lua one vendor  # renamed\n"+
+			mockServer.URL+"/owner/repo/main/third.lua - libs\n")
+
+		var stdout bytes.Buffer
+		require.NoError(t, runAddCommandWithIO(t, tempDir, nil, &stdout, io.Discard, "--from", listPath))
+		assert.Contains(t, stdout.String(), "Added 2 of 2 sources from "+listPath+"; 0 failed.")
+
+		projCfg := readProjectToml(t, filepath.Join(tempDir, config.ProjectTomlName))
+		assert.Equal(t, "vendor/first.lua", projCfg.Dependencies["one"].Path)
+		assert.Equal(t, "libs/third.lua", projCfg.Dependencies["third"].Path, "'-' keeps the default name")
+	})
+
+	t.Run("failures are summarized without rolling back", func(t *testing.T) {
+		tempDir := setupAddTestEnvironment(t, initialTomlContent)
+		brokenURL := mockServer.URL + "/owner/repo/main/broken.lua"
+		listPath := writeList(t, tempDir, brokenURL+"\n"+mockServer.URL+"/owner/repo/main/first.lua\n")
+
+		var stdout, stderr bytes.Buffer
+		err := runAddCommandWithIO(t, tempDir, nil, &stdout, &stderr, "--from", listPath)
+		require.Error(t, err)
+		assert.Contains(t, err.Error(), "Failed to add 1 of 2 sources from "+listPath+": "+brokenURL)
+		assert.Contains(t, stderr.String(), listPath+":1: Error downloading file")
+		assert.Contains(t, stdout.String(), "Added 1 of 2 sources from "+listPath+"; 1 failed.")
+		assert.Contains(t, readProjectToml(t, filepath.Join(tempDir, config.ProjectTomlName)).Dependencies, "first")
+	})
+
+	t.Run("malformed line adds nothing", func(t *testing.T) {
+		tempDir := setupAddTestEnvironment(t, initialTomlContent)
+		listPath := writeList(t, tempDir, mockServer.URL+"/owner/repo/main/first.lua\n"+mockServer.URL+"/owner/repo/main/third.lua a b c\n")
+
+		err := runAddCommand(t, tempDir, "--from", listPath)
+		require.Error(t, err)
+		assert.Contains(t, err.Error(), listPath+":2: expected 'source [name [dir]]', got 4 fields")
+		assert.NoFileExists(t, filepath.Join(tempDir, "src", "lib", "first.lua"))
+	})
+
+	t.Run("cannot be combined with source arguments", func(t *testing.T) {
+		tempDir := setupAddTestEnvironment(t, initialTomlContent)
+		listPath := writeList(t, tempDir, mockServer.URL+"/owner/repo/main/first.lua\n")
+
+		err := runAddCommand(t, tempDir, "--from", listPath, mockServer.URL+"/owner/repo/main/third.lua")
+		require.Error(t, err)
+		assert.Contains(t, err.Error(), "--from cannot be combined with source arguments")
+	})
+}
+
+func TestAddCommand_PreservesProjectTomlComments(t *testing.T) {
+	initialTomlContent := `# Game manifest
+[package]
+name = "test-comments"
+version = "0.1.0"
+
+# Scripts we run in CI
+[scripts]
+# Start the game
+run = "love ." # needs LÖVE 11
+test = "busted"
+`
+	tempDir := setupAddTestEnvironment(t, initialTomlContent)
+	mockServer := startMockServer(t, map[string]struct {
+		Body string
+		Code int
+	}{
+		"/owner/repo/main/lib.lua": {Body: "return {}", Code: http.StatusOK},
+	})
+
+	require.NoError(t, runAddCommand(t, tempDir, mockServer.URL+"/owner/repo/main/lib.lua"))
+
+	written, err := os.ReadFile(filepath.Join(tempDir, config.ProjectTomlName))
+	require.NoError(t, err)
+	assert.True(t, strings.HasPrefix(string(written), initialTomlContent), "existing content and comments must survive, got:\n%s", written)
+	projCfg := readProjectToml(t, filepath.Join(tempDir, config.ProjectTomlName))
+	assert.Equal(t, "src/lib/lib.lua", projCfg.Dependencies["lib"].Path)
+	assert.Equal(t, "love .", projCfg.Scripts["run"])
+}
+
+func TestAddCommand_Dev(t *testing.T) {
+	initialTomlContent := `
+[package]
+name = "test-dev"
+version = "0.1.0"
+`
+	tempDir := setupAddTestEnvironment(t, initialTomlContent)
+	mockServer := startMockServer(t, map[string]struct {
+		Body string
+		Code int
+	}{
+		"/owner/repo/main/lib.lua":    {Body: "return 'lib'", Code: http.StatusOK},
+		"/owner/repo/main/helper.lua": {Body: "return 'helper'", Code: http.StatusOK},
+	})
+
+	require.NoError(t, runAddCommand(t, tempDir, mockServer.URL+"/owner/repo/main/lib.lua"))
+	require.NoError(t, runAddCommand(t, tempDir, "--dev", mockServer.URL+"/owner/repo/main/helper.lua"))
+
+	projCfg := readProjectToml(t, filepath.Join(tempDir, config.ProjectTomlName))
+	assert.Contains(t, projCfg.Dependencies, "lib")
+	assert.NotContains(t, projCfg.Dependencies, "helper")
+	assert.Equal(t, "src/lib/helper.lua", projCfg.DevDependencies["helper"].Path)
+
+	lf, err := lockfile.Load(tempDir)
+	require.NoError(t, err)
+	assert.Equal(t, "dev", lf.Package["helper"].Group)
+	assert.Empty(t, lf.Package["lib"].Group)
+
+	// Re-adding without --dev moves the dependency back to [dependencies].
+	require.NoError(t, runAddCommand(t, tempDir, "--force", mockServer.URL+"/owner/repo/main/helper.lua"))
+	projCfg = readProjectToml(t, filepath.Join(tempDir, config.ProjectTomlName))
+	assert.Contains(t, projCfg.Dependencies, "helper")
+	assert.Empty(t, projCfg.DevDependencies)
+	lf, err = lockfile.Load(tempDir)
+	require.NoError(t, err)
+	assert.Empty(t, lf.Package["helper"].Group)
+}
+
+func TestAddCommand_Filename(t *testing.T) {
+	initialTomlContent := `
+[package]
+name = "test-filename"
+version = "0.1.0"
+`
+	mockServer := startMockServer(t, map[string]struct {
+		Body string
+		Code int
+	}{
+		"/owner/repo/main/json.lua": {Body: "return {}", Code: http.StatusOK},
+		"/other/repo/main/json.lua": {Body: "return { other = true }", Code: http.StatusOK},
+	})
+	sourceURL := mockServer.URL + "/owner/repo/main/json.lua"
+
+	tests := []struct {
+		name         string
+		args         []string
+		wantName     string
+		wantFilename string
+	}{
+		{name: "name only", args: []string{"-n", "dkjson"}, wantName: "dkjson", wantFilename: "json.lua"},
+		{name: "filename only", args: []string{"--filename", "dkjson.lua"}, wantName: "json", wantFilename: "dkjson.lua"},
+		{name: "name and filename", args: []string{"-n", "jsonlib", "--filename", "vendor_json.lua"}, wantName: "jsonlib", wantFilename: "vendor_json.lua"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tempDir := setupAddTestEnvironment(t, initialTomlContent)
+			require.NoError(t, runAddCommand(t, tempDir, append(tt.args, sourceURL)...))
+
+			wantPath := "src/lib/" + tt.wantFilename
+			assert.FileExists(t, filepath.Join(tempDir, "src", "lib", tt.wantFilename))
+			projCfg := readProjectToml(t, filepath.Join(tempDir, config.ProjectTomlName))
+			require.Contains(t, projCfg.Dependencies, tt.wantName)
+			assert.Equal(t, wantPath, projCfg.Dependencies[tt.wantName].Path)
+			lockCfg := readAlmdLockToml(t, filepath.Join(tempDir, lockfile.LockfileName))
+			require.Contains(t, lockCfg.Package, tt.wantName)
+			assert.Equal(t, wantPath, lockCfg.Package[tt.wantName].Path)
+		})
+	}
+
+	t.Run("path of another dependency is rejected", func(t *testing.T) {
+		tempDir := setupAddTestEnvironment(t, initialTomlContent)
+		require.NoError(t, runAddCommand(t, tempDir, sourceURL))
+		err := runAddCommand(t, tempDir, "-n", "json2", mockServer.URL+"/other/repo/main/json.lua")
+		require.Error(t, err)
+		assert.Contains(t, err.Error(), "already the path of 'json'")
+		assert.Contains(t, err.Error(), "--filename")
+	})
+
+	t.Run("filename with a directory is rejected", func(t *testing.T) {
+		tempDir := setupAddTestEnvironment(t, initialTomlContent)
+		err := runAddCommand(t, tempDir, "--filename", "sub/json.lua", sourceURL)
+		require.Error(t, err)
+		assert.Contains(t, err.Error(), "must be a plain file name")
+	})
+}
+
+func TestAddCommand_Quiet(t *testing.T) {
+	initialTomlContent := `
+[package]
+name = "test-quiet"
+version = "0.1.0"
+`
+	mockServer := startMockServer(t, map[string]struct {
+		Body string
+		Code int
+	}{
+		"/owner/repo/main/lib.lua": {Body: "return {}", Code: http.StatusOK},
+	})
+	sourceURL := mockServer.URL + "/owner/repo/main/lib.lua"
+
+	t.Run("suppresses the summary", func(t *testing.T) {
+		tempDir := setupAddTestEnvironment(t, initialTomlContent)
+		var stdout, stderr bytes.Buffer
+		require.NoError(t, runAddCommandWithIO(t, tempDir, nil, &stdout, &stderr, "-q", sourceURL))
+		assert.Empty(t, stdout.String())
+		assert.FileExists(t, filepath.Join(tempDir, "src", "lib", "lib.lua"))
+	})
+
+	t.Run("keeps --print-path output", func(t *testing.T) {
+		tempDir := setupAddTestEnvironment(t, initialTomlContent)
+		var stdout bytes.Buffer
+		require.NoError(t, runAddCommandWithIO(t, tempDir, nil, &stdout, io.Discard, "--quiet", "--print-path", sourceURL))
+		assert.Equal(t, "src/lib/lib.lua\n", stdout.String())
+	})
+
+	t.Run("errors still reach stderr", func(t *testing.T) {
+		tempDir := setupAddTestEnvironment(t, initialTomlContent)
+		var stdout bytes.Buffer
+		err := runAddCommandWithIO(t, tempDir, nil, &stdout, io.Discard, "-q", mockServer.URL+"/owner/repo/main/missing.lua")
+		require.Error(t, err)
+		assert.Equal(t, 1, err.(cli.ExitCoder).ExitCode())
+		assert.Empty(t, stdout.String())
+	})
+
+	t.Run("conflicts with --verbose", func(t *testing.T) {
+		tempDir := setupAddTestEnvironment(t, initialTomlContent)
+		err := runAddCommand(t, tempDir, "--quiet", "--verbose", sourceURL)
+		require.Error(t, err)
+		assert.Contains(t, err.Error(), "--quiet and --verbose cannot be used together")
+	})
+}
+
+func TestAddCommand_ExpandsShortCommitSHA(t *testing.T) {
+	initialTomlContent := `
+[package]
+name = "test-short-sha"
+version = "0.1.0"
+`
+	shortSHA, fullSHA := "abc1234", "abc1234def5678901234567890abcdef12345678"
+	mockServer := startMockServer(t, map[string]struct {
+		Body string
+		Code int
+	}{
+		"/owner/repo/" + shortSHA + "/lib.lua":  {Body: "return {}", Code: http.StatusOK},
+		"/repos/owner/repo/commits/" + shortSHA: {Body: fmt.Sprintf(`{"sha": "%s"}`, fullSHA), Code: http.StatusOK},
+		"/owner/repo/def5678/other.lua":         {Body: "return {}", Code: http.StatusOK},
+	})
+	originalBaseURL := source.GithubAPIBaseURL
+	source.GithubAPIBaseURL = mockServer.URL
+	t.Cleanup(func() { source.GithubAPIBaseURL = originalBaseURL })
+
+	tempDir := setupAddTestEnvironment(t, initialTomlContent)
+	require.NoError(t, runAddCommand(t, tempDir, mockServer.URL+"/owner/repo/"+shortSHA+"/lib.lua"))
+
+	projCfg := readProjectToml(t, filepath.Join(tempDir, config.ProjectTomlName))
+	assert.Equal(t, "github:owner/repo/lib.lua@"+shortSHA, projCfg.Dependencies["lib"].Source, "project.toml keeps the ref as written")
+	lockCfg := readAlmdLockToml(t, filepath.Join(tempDir, lockfile.LockfileName))
+	assert.Equal(t, "commit:"+fullSHA, lockCfg.Package["lib"].Hash)
+	assert.Equal(t, mockServer.URL+"/owner/repo/"+fullSHA+"/lib.lua", lockCfg.Package["lib"].Source)
+
+	t.Run("repository named like the short SHA", func(t *testing.T) {
+		repoServer := startMockServer(t, map[string]struct {
+			Body string
+			Code int
+		}{
+			"/owner/" + shortSHA + "/" + shortSHA + "/lib.lua":  {Body: "return {}", Code: http.StatusOK},
+			"/repos/owner/" + shortSHA + "/commits/" + shortSHA: {Body: fmt.Sprintf(`{"sha": "%s"}`, fullSHA), Code: http.StatusOK},
+		})
+		source.GithubAPIBaseURL = repoServer.URL
+		defer func() { source.GithubAPIBaseURL = mockServer.URL }()
+		repoDir := setupAddTestEnvironment(t, initialTomlContent)
+
+		require.NoError(t, runAddCommand(t, repoDir, repoServer.URL+"/owner/"+shortSHA+"/"+shortSHA+"/lib.lua"))
+		lockCfg := readAlmdLockToml(t, filepath.Join(repoDir, lockfile.LockfileName))
+		assert.Equal(t, repoServer.URL+"/owner/"+shortSHA+"/"+fullSHA+"/lib.lua", lockCfg.Package["lib"].Source, "the repository segment is not mistaken for the ref")
+	})
+
+	t.Run("failed expansion warns and locks the short SHA", func(t *testing.T) {
+		var stderr bytes.Buffer
+		require.NoError(t, runAddCommandWithIO(t, tempDir, nil, io.Discard, &stderr, mockServer.URL+"/owner/repo/def5678/other.lua"))
+		assert.Contains(t, stderr.String(), "Warning: Could not expand short commit SHA 'def5678' for 'other'")
+		lockCfg := readAlmdLockToml(t, filepath.Join(tempDir, lockfile.LockfileName))
+		assert.Equal(t, "commit:def5678", lockCfg.Package["other"].Hash)
+	})
+}
+
+func TestAddCommand_Pin(t *testing.T) {
+	initialTomlContent := `
+[package]
+name = "test-pin"
+version = "0.1.0"
+`
+	commitSHA := "0123456789abcdef0123456789abcdef01234567"
+	mockServer := startMockServer(t, map[string]struct {
+		Body string
+		Code int
+	}{
+		"/owner/repo/main/lib.lua":                                   {Body: "return {}", Code: http.StatusOK},
+		"/repos/owner/repo/commits?path=lib.lua&sha=main&per_page=1": {Body: fmt.Sprintf(`[{"sha": "%s"}]`, commitSHA), Code: http.StatusOK},
+		"/owner/main/main/lib.lua":                                   {Body: "return {}", Code: http.StatusOK},
+		"/repos/owner/main/commits?path=lib.lua&sha=main&per_page=1": {Body: fmt.Sprintf(`[{"sha": "%s"}]`, commitSHA), Code: http.StatusOK},
+	})
+	originalBaseURL := source.GithubAPIBaseURL
+	source.GithubAPIBaseURL = mockServer.URL
+	t.Cleanup(func() { source.GithubAPIBaseURL = originalBaseURL })
+
+	t.Run("without --pin the manifest keeps the branch", func(t *testing.T) {
+		tempDir := setupAddTestEnvironment(t, initialTomlContent)
+		require.NoError(t, runAddCommand(t, tempDir, "github:owner/repo/lib.lua@main"))
+
+		projCfg := readProjectToml(t, filepath.Join(tempDir, config.ProjectTomlName))
+		assert.Equal(t, "github:owner/repo/lib.lua@main", projCfg.Dependencies["lib"].Source)
+		lockCfg := readAlmdLockToml(t, filepath.Join(tempDir, lockfile.LockfileName))
+		assert.Equal(t, "commit:"+commitSHA, lockCfg.Package["lib"].Hash)
+	})
+
+	t.Run("--pin records the resolved commit", func(t *testing.T) {
+		tempDir := setupAddTestEnvironment(t, initialTomlContent)
+		require.NoError(t, runAddCommand(t, tempDir, "--pin", "github:owner/repo/lib.lua@main"))
+
+		projCfg := readProjectToml(t, filepath.Join(tempDir, config.ProjectTomlName))
+		assert.Equal(t, "github:owner/repo/lib.lua@"+commitSHA, projCfg.Dependencies["lib"].Source)
+		lockCfg := readAlmdLockToml(t, filepath.Join(tempDir, lockfile.LockfileName))
+		assert.Equal(t, "commit:"+commitSHA, lockCfg.Package["lib"].Hash)
+		assert.Equal(t, mockServer.URL+"/owner/repo/"+commitSHA+"/lib.lua", lockCfg.Package["lib"].Source)
+		assert.Equal(t, "github:owner/repo/lib.lua@main", lockCfg.Package["lib"].Requested, "the ref as given is still traceable")
+	})
+
+	t.Run("--pin in a repository named like the branch", func(t *testing.T) {
+		tempDir := setupAddTestEnvironment(t, initialTomlContent)
+		require.NoError(t, runAddCommand(t, tempDir, "--pin", "github:owner/main/lib.lua@main"))
+
+		lockCfg := readAlmdLockToml(t, filepath.Join(tempDir, lockfile.LockfileName))
+		assert.Equal(t, mockServer.URL+"/owner/main/"+commitSHA+"/lib.lua", lockCfg.Package["lib"].Source, "the repository segment is not mistaken for the ref")
+	})
+
+	t.Run("--pin rejects sources without commits", func(t *testing.T) {
+		// Without the test-mode bypass, the mock server's URL is an ordinary web server.
+		source.SetTestModeBypassHostValidation(false)
+		t.Cleanup(func() { source.SetTestModeBypassHostValidation(true) })
+
+		tempDir := setupAddTestEnvironment(t, initialTomlContent)
+		err := runAddCommand(t, tempDir, "--pin", mockServer.URL+"/owner/repo/main/lib.lua")
+		require.Error(t, err)
+		assert.Contains(t, err.Error(), "--pin needs a GitHub source")
+		assert.NoFileExists(t, filepath.Join(tempDir, lockfile.LockfileName))
+	})
+}
+
+func TestAddCommand_NoDownload(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		t.Errorf("--no-download must not fetch anything, got a request for %s", r.URL)
+		http.NotFound(w, r)
+	}))
+	t.Cleanup(server.Close)
+	initialTomlContent := "[package]\nname = \"test-no-download\"\nversion = \"0.1.0\"\n"
+	sourceURL := server.URL + "/owner/repo/main/lib/foo.lua"
+
+	t.Run("records the dependency without a file or hash", func(t *testing.T) {
+		tempDir := setupAddTestEnvironment(t, initialTomlContent)
+
+		var stdout, stderr bytes.Buffer
+		require.NoError(t, runAddCommandWithIO(t, tempDir, nil, &stdout, &stderr, "--no-download", "-d", "vendor", sourceURL))
+		assert.Equal(t, "+ foo github:owner/repo/lib/foo.lua@main (not downloaded)\n", stdout.String())
+		assert.Contains(t, stderr.String(), "Note: 'foo' was not downloaded.")
+		assert.NoFileExists(t, filepath.Join(tempDir, "vendor", "foo.lua"))
+
+		projCfg := readProjectToml(t, filepath.Join(tempDir, config.ProjectTomlName))
+		require.Contains(t, projCfg.Dependencies, "foo")
+		assert.Equal(t, "github:owner/repo/lib/foo.lua@main", projCfg.Dependencies["foo"].Source)
+		assert.Equal(t, "vendor/foo.lua", projCfg.Dependencies["foo"].Path)
+
+		lockCfg := readAlmdLockToml(t, filepath.Join(tempDir, lockfile.LockfileName))
+		require.Contains(t, lockCfg.Package, "foo")
+		assert.Equal(t, lockfile.PackageEntry{
+			Source:    sourceURL,
+			Path:      "vendor/foo.lua",
+			Requested: sourceURL,
+		}, lockCfg.Package["foo"])
+	})
+
+	t.Run("print-path prints the path the file is expected at", func(t *testing.T) {
+		tempDir := setupAddTestEnvironment(t, initialTomlContent)
+
+		var stdout bytes.Buffer
+		require.NoError(t, runAddCommandWithIO(t, tempDir, nil, &stdout, io.Discard, "--no-download", "--print-path", "--filename", "bar.lua", sourceURL))
+		assert.Equal(t, "src/lib/bar.lua\n", stdout.String())
+	})
+
+	for _, flag := range []string{"--interactive", "--dedupe", "--pin", "--name-from-content"} {
+		t.Run("rejects "+flag, func(t *testing.T) {
+			tempDir := setupAddTestEnvironment(t, initialTomlContent)
+
+			err := runAddCommand(t, tempDir, "--no-download", flag, sourceURL)
+			require.Error(t, err)
+			assert.Contains(t, err.Error(), flag+" needs the downloaded file and cannot be combined with --no-download")
+			assert.NoFileExists(t, filepath.Join(tempDir, lockfile.LockfileName))
+		})
+	}
+}
+
+func TestAddCommand_RejectsDirectoryOutsideProject(t *testing.T) {
+	var requests atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests.Add(1)
+		_, _ = w.Write([]byte("return {}"))
+	}))
+	t.Cleanup(server.Close)
+	initialTomlContent := "[package]\nname = \"test-escape\"\nversion = \"0.1.0\"\n"
+	sourceURL := server.URL + "/owner/repo/main/lib/foo.lua"
+
+	t.Run("path traversal", func(t *testing.T) {
+		parent := t.TempDir()
+		tempDir := filepath.Join(parent, "project")
+		require.NoError(t, os.Mkdir(tempDir, 0755))
+		require.NoError(t, os.WriteFile(filepath.Join(tempDir, config.ProjectTomlName), []byte(initialTomlContent), 0644))
+
+		for _, args := range [][]string{
+			{"-d", "../outside", sourceURL},
+			{"-d", "vendor/../../outside", sourceURL},
+			{"--no-download", "-d", "../outside", sourceURL},
+		} {
+			err := runAddCommand(t, tempDir, args...)
+			require.Error(t, err, args)
+			assert.Contains(t, err.Error(), "Invalid target directory")
+			assert.Contains(t, err.Error(), "leads outside the project root")
+		}
+		assert.NoDirExists(t, filepath.Join(parent, "outside"))
+		assert.NoFileExists(t, filepath.Join(tempDir, lockfile.LockfileName))
+		content, readErr := os.ReadFile(filepath.Join(tempDir, config.ProjectTomlName))
+		require.NoError(t, readErr)
+		assert.Equal(t, initialTomlContent, string(content))
+		assert.Zero(t, requests.Load(), "nothing should be downloaded for a rejected directory")
+	})
+
+	t.Run("symlinked directory", func(t *testing.T) {
+		tempDir := setupAddTestEnvironment(t, initialTomlContent)
+		outside := t.TempDir()
+		require.NoError(t, os.Symlink(outside, filepath.Join(tempDir, "vendor")))
+
+		err := runAddCommand(t, tempDir, "-d", "vendor/libs", sourceURL)
+		require.Error(t, err)
+		assert.Contains(t, err.Error(), "through a symlink")
+		entries, readErr := os.ReadDir(outside)
+		require.NoError(t, readErr)
+		assert.Empty(t, entries)
+	})
+
+	t.Run("symlink inside the project is allowed", func(t *testing.T) {
+		tempDir := setupAddTestEnvironment(t, initialTomlContent)
+		require.NoError(t, os.Mkdir(filepath.Join(tempDir, "lib"), 0755))
+		require.NoError(t, os.Symlink(filepath.Join(tempDir, "lib"), filepath.Join(tempDir, "vendor")))
+
+		require.NoError(t, runAddCommand(t, tempDir, "-d", "vendor", sourceURL))
+		assert.FileExists(t, filepath.Join(tempDir, "lib", "foo.lua"))
+	})
+}
+
+func TestAddCommand_Recursive(t *testing.T) {
+	initialTomlContent := "[package]\nname = \"test-recursive\"\nversion = \"0.1.0\"\n"
+	files := map[string]string{
+		"lib/foo/init.lua":         "return require('foo.util.str')\n",
+		"lib/foo/util/str.lua":     "return {}\n",
+		"lib/foo/a/b/deep/x.lua":   "return 'deep'\n",
+		"lib/foobar/unrelated.lua": "return 'no'\n",
+	}
+	// newServer serves the tree listing, raw files and commit lookups for files. A file listed
+	// in wrongBlobs is given a blob SHA in the listing that its content does not have.
+	newServer := func(t *testing.T, wrongBlobs ...string) *httptest.Server {
+		pathResps := map[string]struct {
+			Body string
+			Code int
+		}{}
+		var tree []string
+		for filePath, content := range files {
+			blob := hasher.GitBlobSHA([]byte(content))
+			for _, wrong := range wrongBlobs {
+				if wrong == filePath {
+					blob = hasher.GitBlobSHA([]byte("something else"))
+				}
+			}
+			tree = append(tree, fmt.Sprintf(`{"path": %q, "mode": "100644", "type": "blob", "sha": %q}`, filePath, blob))
+			pathResps["/owner/repo/main/"+filePath] = struct {
+				Body string
+				Code int
+			}{Body: content, Code: http.StatusOK}
+			pathResps[fmt.Sprintf("/repos/owner/repo/commits?path=%s&sha=main&per_page=1", filePath)] = struct {
+				Body string
+				Code int
+			}{Body: `[{"sha": "1111111111111111111111111111111111111111"}]`, Code: http.StatusOK}
+		}
+		pathResps["/repos/owner/repo/git/trees/main?recursive=1"] = struct {
+			Body string
+			Code int
+		}{Body: `{"truncated": false, "tree": [` + strings.Join(tree, ",") + `]}`, Code: http.StatusOK}
+		server := startMockServer(t, pathResps)
+		originalGHAPIBaseURL := source.GithubAPIBaseURL
+		source.GithubAPIBaseURL = server.URL
+		t.Cleanup(func() { source.GithubAPIBaseURL = originalGHAPIBaseURL })
+		return server
+	}
+
+	t.Run("adds each file with its blob SHA", func(t *testing.T) {
+		newServer(t)
+		tempDir := setupAddTestEnvironment(t, initialTomlContent)
+
+		var stdout, stderr bytes.Buffer
+		require.NoError(t, runAddCommandWithIO(t, tempDir, nil, &stdout, &stderr, "--recursive", "--max-depth", "2", "-d", "vendor", "github:owner/repo/lib/foo@main"))
+		assert.Contains(t, stdout.String(), "Added 2 of 2 files from github:owner/repo/lib/foo@main; 0 failed.")
+		assert.Contains(t, stderr.String(), "Note: Skipping 1 file(s) nested more than 2 directories deep under lib/foo (--max-depth).")
+
+		for name, want := range map[string]struct{ source, path, file string }{
+			"foo-init":     {"github:owner/repo/lib/foo/init.lua@main", "vendor/foo/init.lua", "lib/foo/init.lua"},
+			"foo-util-str": {"github:owner/repo/lib/foo/util/str.lua@main", "vendor/foo/util/str.lua", "lib/foo/util/str.lua"},
+		} {
+			content, err := os.ReadFile(filepath.Join(tempDir, filepath.FromSlash(want.path)))
+			require.NoError(t, err, name)
+			assert.Equal(t, files[want.file], string(content))
+
+			projCfg := readProjectToml(t, filepath.Join(tempDir, config.ProjectTomlName))
+			require.Contains(t, projCfg.Dependencies, name)
+			assert.Equal(t, want.source, projCfg.Dependencies[name].Source)
+			assert.Equal(t, want.path, projCfg.Dependencies[name].Path)
+
+			lf, err := lockfile.Load(tempDir)
+			require.NoError(t, err)
+			assert.Equal(t, hasher.GitBlobSHA([]byte(files[want.file])), lf.Package[name].Blob, name)
+			assert.Equal(t, "commit:1111111111111111111111111111111111111111", lf.Package[name].Hash)
+		}
+		projCfg := readProjectToml(t, filepath.Join(tempDir, config.ProjectTomlName))
+		assert.Len(t, projCfg.Dependencies, 2, "files deeper than --max-depth and outside the directory are not added")
+	})
+
+	t.Run("too many files", func(t *testing.T) {
+		newServer(t)
+		tempDir := setupAddTestEnvironment(t, initialTomlContent)
+
+		err := runAddCommand(t, tempDir, "--recursive", "--max-files", "2", "github:owner/repo/lib/foo@main")
+		require.Error(t, err)
+		assert.Contains(t, err.Error(), "'lib/foo' has 3 files, more than --max-files 2")
+		assert.NoFileExists(t, filepath.Join(tempDir, lockfile.LockfileName))
+		assert.NoDirExists(t, filepath.Join(tempDir, "src"))
+	})
+
+	t.Run("download not matching the listed blob", func(t *testing.T) {
+		newServer(t, "lib/foo/util/str.lua")
+		tempDir := setupAddTestEnvironment(t, initialTomlContent)
+
+		err := runAddCommand(t, tempDir, "--recursive", "-n", "lib", "github:owner/repo/lib/foo@main")
+		require.Error(t, err)
+		assert.Contains(t, err.Error(), "Failed to add 1 of 3 files")
+		assert.NoFileExists(t, filepath.Join(tempDir, "src", "lib", "foo", "util", "str.lua"))
+
+		projCfg := readProjectToml(t, filepath.Join(tempDir, config.ProjectTomlName))
+		assert.Contains(t, projCfg.Dependencies, "lib-init", "--name sets the prefix of each file's name")
+		assert.NotContains(t, projCfg.Dependencies, "lib-util-str")
+	})
+
+	t.Run("flag validation", func(t *testing.T) {
+		tempDir := setupAddTestEnvironment(t, initialTomlContent)
+		for args, want := range map[string][]string{
+			"--max-files is only meaningful": {"--max-files", "3", "github:owner/repo/lib/foo/init.lua@main"},
+			"exactly one directory source":   {"--recursive", "github:owner/repo/lib/foo@main", "github:owner/repo/lib/bar@main"},
+			"--filename cannot be used":      {"--recursive", "--filename", "x.lua", "github:owner/repo/lib/foo@main"},
+			"must be at least 1":             {"--recursive", "--max-depth", "0", "github:owner/repo/lib/foo@main"},
+		} {
+			err := runAddCommand(t, tempDir, want...)
+			require.Error(t, err, args)
+			assert.Contains(t, err.Error(), args)
+		}
+	})
+}
+
+func TestAddCommand_ExistingName(t *testing.T) {
+	mockServer := startMockServer(t, map[string]struct {
+		Body string
+		Code int
+	}{
+		"/owner/repo/develop/mocklib.lua": {Body: "return 'new'\n", Code: http.StatusOK},
+	})
+	initialTomlContent := `
+[package]
+name = "test-existing-name"
+version = "0.1.0"
+
+[dependencies.mocklib]
+source = "github:owner/repo/mocklib.lua@main"
+path = "src/lib/mocklib.lua"
+`
+	newSource := mockServer.URL + "/owner/repo/develop/mocklib.lua"
+
+	t.Run("refused without --force", func(t *testing.T) {
+		tempDir := setupAddTestEnvironment(t, initialTomlContent)
+		libPath := filepath.Join(tempDir, "src", "lib", "mocklib.lua")
+		require.NoError(t, os.MkdirAll(filepath.Dir(libPath), 0755))
+		require.NoError(t, os.WriteFile(libPath, []byte("return 'old'\n"), 0644))
+
+		err := runAddCommand(t, tempDir, newSource)
+		require.Error(t, err)
+		assert.Contains(t, err.Error(), "'mocklib' is already in project.toml with source github:owner/repo/mocklib.lua@main. Use --force to replace it")
+
+		content, readErr := os.ReadFile(libPath)
+		require.NoError(t, readErr)
+		assert.Equal(t, "return 'old'\n", string(content))
+		projCfg := readProjectToml(t, filepath.Join(tempDir, config.ProjectTomlName))
+		assert.Equal(t, "github:owner/repo/mocklib.lua@main", projCfg.Dependencies["mocklib"].Source)
+	})
+
+	t.Run("--force replaces the entry", func(t *testing.T) {
+		tempDir := setupAddTestEnvironment(t, initialTomlContent)
+
+		var stderr bytes.Buffer
+		require.NoError(t, runAddCommandWithIO(t, tempDir, nil, io.Discard, &stderr, "--force", newSource))
+		assert.Contains(t, stderr.String(), "Note: Replacing 'mocklib' in project.toml (source was github:owner/repo/mocklib.lua@main).")
+		projCfg := readProjectToml(t, filepath.Join(tempDir, config.ProjectTomlName))
+		assert.Equal(t, "github:owner/repo/mocklib.lua@develop", projCfg.Dependencies["mocklib"].Source)
+	})
+
+	t.Run("failed --force leaves the previous file and entry", func(t *testing.T) {
+		tempDir := setupAddTestEnvironment(t, initialTomlContent)
+		libPath := filepath.Join(tempDir, "src", "lib", "mocklib.lua")
+		require.NoError(t, os.MkdirAll(filepath.Dir(libPath), 0755))
+		require.NoError(t, os.WriteFile(libPath, []byte("return 'old'\n"), 0644))
+		// A directory in place of the lockfile makes the last step fail.
+		require.NoError(t, os.Mkdir(filepath.Join(tempDir, lockfile.LockfileName), 0755))
+
+		var stderr bytes.Buffer
+		err := runAddCommandWithIO(t, tempDir, nil, io.Discard, &stderr, "--force", newSource)
+		require.Error(t, err)
+		assert.Contains(t, stderr.String(), "Note: Restored the previous")
+		assert.Contains(t, stderr.String(), "Note: Kept the previous 'mocklib' entry (source github:owner/repo/mocklib.lua@main).")
+
+		content, readErr := os.ReadFile(libPath)
+		require.NoError(t, readErr)
+		assert.Equal(t, "return 'old'\n", string(content))
+		projCfg := readProjectToml(t, filepath.Join(tempDir, config.ProjectTomlName))
+		assert.Equal(t, "github:owner/repo/mocklib.lua@main", projCfg.Dependencies["mocklib"].Source)
+		assert.Equal(t, "src/lib/mocklib.lua", projCfg.Dependencies["mocklib"].Path)
+	})
+}
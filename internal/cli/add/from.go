@@ -0,0 +1,98 @@
+package add
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/urfave/cli/v2"
+
+	"github.com/nightconcept/almandine-go/internal/cli/output"
+	"github.com/nightconcept/almandine-go/internal/core/project"
+)
+
+// sourceListEntry is one line of a file given to --from: a source, optionally followed by the
+// dependency name and the directory to save it in.
+type sourceListEntry struct {
+	source string
+	name   string // empty uses the usual default
+	dir    string // empty uses --directory or [almd] default_dependency_dir
+	line   int
+}
+
+// readSourceList parses the file given to --from. Each line is 'source [name [dir]]', separated
+// by whitespace; a name of "-" keeps the default so that a directory can be given on its own.
+// Blank lines and '#' comments, whole-line or after whitespace, are skipped. Any malformed line
+// fails the whole file, so nothing is added from a list that was only partly understood.
+func readSourceList(path string) ([]sourceListEntry, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = file.Close() }()
+
+	var entries []sourceListEntry
+	scanner := bufio.NewScanner(file)
+	for lineNum := 1; scanner.Scan(); lineNum++ {
+		line := scanner.Text()
+		if strings.HasPrefix(strings.TrimSpace(line), "#") {
+			continue
+		}
+		// A '#' inside a URL is a fragment; only one after whitespace starts a comment.
+		if i := strings.Index(line, " #"); i >= 0 {
+			line = line[:i]
+		}
+		if i := strings.Index(line, "\t#"); i >= 0 {
+			line = line[:i]
+		}
+		fields := strings.Fields(line)
+		if len(fields) == 0 {
+			continue
+		}
+		if len(fields) > 3 {
+			return nil, fmt.Errorf("%s:%d: expected 'source [name [dir]]', got %d fields", path, lineNum, len(fields))
+		}
+		entry := sourceListEntry{source: fields[0], line: lineNum}
+		if len(fields) > 1 && fields[1] != "-" {
+			if err := project.ValidateDependencyName(fields[1]); err != nil {
+				return nil, fmt.Errorf("%s:%d: %w", path, lineNum, err)
+			}
+			entry.name = fields[1]
+		}
+		if len(fields) > 2 {
+			entry.dir = fields[2]
+		}
+		entries = append(entries, entry)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return entries, nil
+}
+
+// addSourceList adds every entry read from the --from file in order. Like multiple source
+// arguments, each one is added on its own, so those added before a failure stay added; a
+// summary of both counts is printed at the end.
+func addSourceList(cCtx *cli.Context, opts addOptions, fromFile string, entries []sourceListEntry) error {
+	stdout, stderr := output.Stdout(cCtx), cCtx.App.ErrWriter
+	var failed []string
+	for _, entry := range entries {
+		entryOpts := opts
+		if entry.name != "" {
+			entryOpts.customName = entry.name
+		}
+		if entry.dir != "" {
+			entryOpts.targetDir = entry.dir
+		}
+		if addErr := addSource(cCtx, entryOpts, entry.source); addErr != nil {
+			_, _ = fmt.Fprintf(stderr, "%s:%d: %s\n", fromFile, entry.line, addErr.Error())
+			failed = append(failed, entry.source)
+		}
+	}
+	_, _ = fmt.Fprintf(stdout, "Added %d of %d sources from %s; %d failed.\n", len(entries)-len(failed), len(entries), fromFile, len(failed))
+	if len(failed) > 0 {
+		return cli.Exit(fmt.Sprintf("Error: Failed to add %d of %d sources from %s: %s", len(failed), len(entries), fromFile, strings.Join(failed, ", ")), 1)
+	}
+	return nil
+}
@@ -0,0 +1,75 @@
+package add
+
+import (
+	"fmt"
+	"path/filepath"
+
+	"github.com/urfave/cli/v2"
+
+	"github.com/nightconcept/almandine-go/internal/cli/output"
+	"github.com/nightconcept/almandine-go/internal/core/config"
+	"github.com/nightconcept/almandine-go/internal/core/lockfile"
+	"github.com/nightconcept/almandine-go/internal/core/project"
+	"github.com/nightconcept/almandine-go/internal/core/source"
+)
+
+// contentFlags need the downloaded file, or the network, and so cannot be used with --no-download.
+var contentFlags = []string{"interactive", "dedupe", "pin", "name-from-content"}
+
+// registerSource records a parsed source in project.toml and almd-lock.toml without downloading
+// it (--no-download). Refs and version ranges are left unresolved and the lockfile entry has no
+// hash, so the next 'almd install' downloads and locks the file, or with --only-missing-lock locks
+// a copy supplied by hand. Until then 'almd verify' reports the entry as not installed.
+func registerSource(cCtx *cli.Context, opts addOptions, sourceURLInput string, parsedInfo *source.ParsedSourceInfo) error {
+	stdout, pathOut, stderr := output.Stdout(cCtx), cCtx.App.Writer, cCtx.App.ErrWriter
+	projectRoot, proj := opts.projectRoot, opts.proj
+
+	fileNameOnDisk := parsedInfo.SuggestedFilename
+	if opts.filename != "" {
+		fileNameOnDisk = opts.filename
+	}
+	if fileNameOnDisk == "" || fileNameOnDisk == "." || fileNameOnDisk == "/" {
+		return cli.Exit("Error: Could not determine a valid final filename for saving. Inferred name was empty or invalid.", 1)
+	}
+	name, err := dependencyName(stderr, opts, parsedInfo, nil)
+	if err != nil {
+		return err
+	}
+	relativeDestPath := filepath.ToSlash(filepath.Join(opts.targetDir, fileNameOnDisk))
+	if err := checkConflicts(cCtx, proj, name, parsedInfo.CanonicalURL, relativeDestPath); err != nil {
+		return err
+	}
+
+	lf, err := lockfile.Load(projectRoot)
+	if err != nil {
+		return cli.Exit(fmt.Sprintf("Error loading %s: %v. Nothing was written.", lockfile.LockfileName, err), 1)
+	}
+
+	group := ""
+	if cCtx.Bool("dev") {
+		group = project.GroupDev
+	}
+	proj.SetDependency(name, group, project.Dependency{
+		Source:      parsedInfo.CanonicalURL,
+		Path:        relativeDestPath,
+		ChecksumURL: cCtx.String("checksum-url"),
+	})
+	if err := config.WriteProjectToml(projectRoot, proj); err != nil {
+		return cli.Exit(fmt.Sprintf("Error writing %s: %v. Nothing was written.", config.ProjectTomlName, err), 1)
+	}
+
+	lf.AddOrUpdatePackage(name, parsedInfo.RawURL, relativeDestPath, "")
+	lf.SetRequested(name, sourceURLInput)
+	lf.SetGroup(name, group)
+	if err := lockfile.Save(projectRoot, lf); err != nil {
+		return cli.Exit(fmt.Sprintf("Error saving %s: %v. %s was updated, so run 'almd install' to lock '%s'.", lockfile.LockfileName, err, config.ProjectTomlName, name), 1)
+	}
+
+	if cCtx.Bool("print-path") {
+		_, _ = fmt.Fprintln(pathOut, relativeDestPath)
+	} else {
+		_, _ = fmt.Fprintf(stdout, "+ %s %s (not downloaded)\n", name, parsedInfo.CanonicalURL)
+	}
+	_, _ = fmt.Fprintf(stderr, "Note: '%s' was not downloaded. Run 'almd install' to fetch and lock it, or place the file at %s and run 'almd install --only-missing-lock'.\n", name, relativeDestPath)
+	return nil
+}
@@ -0,0 +1,119 @@
+package add
+
+import (
+	"fmt"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"github.com/nightconcept/almandine-go/internal/cli/output"
+	"github.com/nightconcept/almandine-go/internal/core/project"
+	"github.com/nightconcept/almandine-go/internal/core/source"
+	"github.com/urfave/cli/v2"
+)
+
+// Defaults for --max-depth and --max-files, which bound how much --recursive fetches.
+const (
+	defaultMaxDepth = 5
+	defaultMaxFiles = 50
+)
+
+// directoryFile is one file of a directory added with --recursive.
+type directoryFile struct {
+	name    string // dependency name
+	source  string // github: source of the file alone
+	dir     string // target directory, below the one given by --directory
+	blobSHA string
+}
+
+// addDirectory adds every file below a GitHub repository directory (--recursive). The files are
+// listed with the git trees API and each becomes a dependency of its own, named after the
+// directory and its path within it, and saved under <directory>/<dir name>/ with its relative
+// path kept. Installs, updates and verify then treat them like any other file. Each download
+// is checked against the blob SHA in the listing, which almd-lock.toml records. Like --from,
+// each file is added on its own, so those added before a failure stay added.
+func addDirectory(cCtx *cli.Context, opts addOptions, sourceURLInput string) error {
+	stdout, stderr := output.Stdout(cCtx), cCtx.App.ErrWriter
+	maxDepth, maxFiles := cCtx.Int("max-depth"), cCtx.Int("max-files")
+
+	parsedInfo, err := source.ParseSourceURL(sourceURLInput)
+	if err != nil {
+		return cli.Exit(fmt.Sprintf("Error parsing source URL '%s': %v", sourceURLInput, err), 1)
+	}
+	if parsedInfo.Provider != "github" {
+		return cli.Exit(fmt.Sprintf("Error: --recursive needs a GitHub directory such as github:owner/repo/dir@ref; '%s' is not one.", sourceURLInput), 1)
+	}
+	if hookErr := source.ApplyHooks(parsedInfo, source.AllowedHostsHook(opts.proj.AllowedHosts())); hookErr != nil {
+		return cli.Exit(fmt.Sprintf("Error: Source '%s' rejected by policy: %v", sourceURLInput, hookErr), 1)
+	}
+
+	// Each file keeps the ref as given, so a branch or version range is followed file by file;
+	// the listing itself needs a concrete ref.
+	sourceRef, listRef := parsedInfo.Ref, parsedInfo.Ref
+	if parsedInfo.VersionRange != "" {
+		resolved, resolveErr := opts.github.ResolveVersionRange(parsedInfo)
+		if resolveErr != nil {
+			return cli.Exit(fmt.Sprintf("Error resolving version range '%s' for '%s': %v", parsedInfo.VersionRange, sourceURLInput, resolveErr), 1)
+		}
+		sourceRef, listRef = parsedInfo.VersionRange, resolved.Ref
+	}
+	dir := strings.Trim(parsedInfo.PathInRepo, "/")
+	listed, err := opts.github.ListFiles(parsedInfo.Owner, parsedInfo.Repo, listRef, dir)
+	if err != nil {
+		return cli.Exit(fmt.Sprintf("Error listing files under '%s': %v", sourceURLInput, err), 1)
+	}
+
+	baseName := opts.customName
+	if baseName == "" {
+		baseName = project.NormalizeDependencyName(path.Base(dir))
+		if nameErr := project.ValidateDependencyName(baseName); nameErr != nil {
+			return cli.Exit(fmt.Sprintf("Error: Could not infer a valid dependency name from '%s': %v. Use -n to specify a name.", dir, nameErr), 1)
+		}
+	}
+
+	var files []directoryFile
+	tooDeep := 0
+	seen := make(map[string]string)
+	for _, entry := range listed {
+		rel := strings.TrimPrefix(entry.Path, dir+"/")
+		if strings.Count(rel, "/") >= maxDepth {
+			tooDeep++
+			continue
+		}
+		name := project.NormalizeDependencyName(baseName + "-" + getFileNameWithoutExtension(rel))
+		if other, clash := seen[name]; clash {
+			return cli.Exit(fmt.Sprintf("Error: %s and %s would both be named '%s'. Nothing was added.", other, entry.Path, name), 1)
+		}
+		seen[name] = entry.Path
+		files = append(files, directoryFile{
+			name:    name,
+			source:  fmt.Sprintf("github:%s/%s/%s@%s", parsedInfo.Owner, parsedInfo.Repo, entry.Path, sourceRef),
+			dir:     filepath.Join(opts.targetDir, path.Base(dir), filepath.FromSlash(path.Dir(rel))),
+			blobSHA: entry.SHA,
+		})
+	}
+	if tooDeep > 0 {
+		_, _ = fmt.Fprintf(stderr, "Note: Skipping %d file(s) nested more than %d directories deep under %s (--max-depth).\n", tooDeep, maxDepth, dir)
+	}
+	if len(files) == 0 {
+		return cli.Exit(fmt.Sprintf("Error: No files under '%s' are within --max-depth %d. Nothing was added.", dir, maxDepth), 1)
+	}
+	if len(files) > maxFiles {
+		return cli.Exit(fmt.Sprintf("Error: '%s' has %d files, more than --max-files %d. Raise --max-files or add a smaller directory. Nothing was added.", dir, len(files), maxFiles), 1)
+	}
+
+	var failed []string
+	for _, file := range files {
+		fileOpts := opts
+		fileOpts.customName, fileOpts.targetDir, fileOpts.blobSHA = file.name, file.dir, file.blobSHA
+		if addErr := addSource(cCtx, fileOpts, file.source); addErr != nil {
+			_, _ = fmt.Fprintln(stderr, addErr.Error())
+			failed = append(failed, file.source)
+		}
+	}
+	_, _ = fmt.Fprintf(stdout, "Added %d of %d files from %s; %d failed.\n", len(files)-len(failed), len(files), sourceURLInput, len(failed))
+	if len(failed) > 0 {
+		return cli.Exit(fmt.Sprintf("Error: Failed to add %d of %d files from %s: %s", len(failed), len(files), sourceURLInput, strings.Join(failed, ", ")), 1)
+	}
+	return nil
+}
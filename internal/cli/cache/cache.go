@@ -0,0 +1,57 @@
+// Title: Almandine CLI Cache Command
+// Purpose: Implements the 'cache' command and its 'clear' subcommand, which deletes the
+// machine-wide content cache and the cached copies of remote registries.
+package cache
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/urfave/cli/v2"
+
+	"github.com/nightconcept/almandine-go/internal/core/contentcache"
+	"github.com/nightconcept/almandine-go/internal/core/registry"
+)
+
+// NewCacheCommand creates the 'cache' command.
+func NewCacheCommand() *cli.Command {
+	return &cli.Command{
+		Name:  "cache",
+		Usage: "Manages almd's on-disk caches",
+		Subcommands: []*cli.Command{
+			{
+				Name:  "clear",
+				Usage: "Deletes the content cache and cached remote registries",
+				Action: func(c *cli.Context) error {
+					stdout := c.App.Writer
+
+					contentDir, err := contentcache.DefaultDir()
+					if err != nil {
+						return cli.Exit(fmt.Sprintf("Error: %v", err), 1)
+					}
+					registryDir, err := registry.DefaultCacheDir()
+					if err != nil {
+						return cli.Exit(fmt.Sprintf("Error: %v", err), 1)
+					}
+
+					cleared := 0
+					for _, dir := range []string{contentDir, registryDir} {
+						if _, err := os.Stat(dir); os.IsNotExist(err) {
+							continue
+						}
+						// Removing a directory only needs write access to it, so read-only blobs go too.
+						if err := os.RemoveAll(dir); err != nil {
+							return cli.Exit(fmt.Sprintf("Error: Failed to clear %s: %v", dir, err), 1)
+						}
+						cleared++
+						_, _ = fmt.Fprintf(stdout, "Cleared %s\n", dir)
+					}
+					if cleared == 0 {
+						_, _ = fmt.Fprintln(stdout, "Cache is already empty.")
+					}
+					return nil
+				},
+			},
+		},
+	}
+}
@@ -0,0 +1,48 @@
+package cache
+
+import (
+	"bytes"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/urfave/cli/v2"
+
+	"github.com/nightconcept/almandine-go/internal/core/contentcache"
+)
+
+// runCacheCommand runs 'cache' with args and returns its stdout and error.
+func runCacheCommand(t *testing.T, args ...string) (string, error) {
+	t.Helper()
+	var stdout, stderr bytes.Buffer
+	app := &cli.App{
+		Name:           "almd-test-cache",
+		Commands:       []*cli.Command{NewCacheCommand()},
+		Writer:         &stdout,
+		ErrWriter:      &stderr,
+		ExitErrHandler: func(context *cli.Context, err error) {},
+	}
+	err := app.Run(append([]string{"almd-test-cache", "cache"}, args...))
+	return stdout.String(), err
+}
+
+func TestCacheClear(t *testing.T) {
+	contentDir := filepath.Join(t.TempDir(), "content")
+	t.Setenv(contentcache.DirEnv, contentDir)
+	t.Setenv("XDG_CACHE_HOME", t.TempDir()) // Keeps the registry cache out of the real home directory
+
+	store := contentcache.Store{Dir: contentDir}
+	hash, _, err := store.Put([]byte("return {}"))
+	require.NoError(t, err)
+	require.NoError(t, store.Record(contentcache.CommitKey("github", "owner", "repo", "abc1234", "lib.lua"), hash))
+
+	stdout, err := runCacheCommand(t, "clear")
+	require.NoError(t, err)
+	assert.Contains(t, stdout, "Cleared "+contentDir)
+	assert.NoDirExists(t, contentDir, "read-only blobs are removed too")
+
+	stdout, err = runCacheCommand(t, "clear")
+	require.NoError(t, err)
+	assert.Equal(t, "Cache is already empty.\n", stdout)
+}
@@ -0,0 +1,112 @@
+// Title: Almandine CLI Info Command
+// Purpose: Implements the 'info' command, which shows how almd parses a dependency's source and
+// its upstream metadata (repository description and the file's latest commit) without
+// downloading the file or writing anything.
+package info
+
+import (
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/urfave/cli/v2"
+
+	"github.com/nightconcept/almandine-go/internal/core/config"
+	"github.com/nightconcept/almandine-go/internal/core/source"
+)
+
+// firstLine returns the summary line of a commit message.
+func firstLine(message string) string {
+	line, _, _ := strings.Cut(message, "\n")
+	return strings.TrimSpace(line)
+}
+
+// NewInfoCommand creates the 'info' command.
+func NewInfoCommand() *cli.Command {
+	return &cli.Command{
+		Name:      "info",
+		Usage:     "Shows how a dependency or source URL is parsed, and its upstream details, without downloading it",
+		ArgsUsage: "<dependency_name|source_url>",
+		Action: func(c *cli.Context) error {
+			// Metadata goes to stdout; warnings about unavailable details go to stderr.
+			stdout, stderr := c.App.Writer, c.App.ErrWriter
+			if c.NArg() != 1 {
+				return cli.Exit("Error: Exactly one dependency name or source URL is required.", 1)
+			}
+			arg := c.Args().First()
+
+			// A name declared in project.toml takes precedence over parsing arg as a URL.
+			name, sourceURL := "", arg
+			proj, err := config.LoadProjectToml(".")
+			if err != nil && !os.IsNotExist(err) {
+				return cli.Exit(fmt.Sprintf("Error loading %s: %v", config.ProjectTomlName, err), 1)
+			}
+			if proj != nil {
+				if dep, _, ok := proj.LookupDependency(arg); ok {
+					name, sourceURL = arg, dep.Source
+				}
+			}
+
+			parsedInfo, err := source.ParseSourceURL(sourceURL)
+			if err != nil {
+				if name == "" && !strings.ContainsAny(arg, ":/") {
+					return cli.Exit(fmt.Sprintf("Error: Dependency '%s' not found in %s and is not a source URL.", arg, config.ProjectTomlName), 1)
+				}
+				return cli.Exit(fmt.Sprintf("Error parsing source URL '%s': %v", sourceURL, err), 1)
+			}
+			// A version range is shown as the tag it currently resolves to.
+			versionRange := parsedInfo.VersionRange
+			if versionRange != "" {
+				if parsedInfo, err = source.ResolveVersionRange(parsedInfo); err != nil {
+					return cli.Exit(fmt.Sprintf("Error resolving version range in '%s': %v", sourceURL, err), 1)
+				}
+			}
+
+			if name != "" {
+				_, _ = fmt.Fprintf(stdout, "%s\n", name)
+			}
+			_, _ = fmt.Fprintf(stdout, "  source:      %s\n", parsedInfo.CanonicalURL)
+			_, _ = fmt.Fprintf(stdout, "  download:    %s\n", parsedInfo.RawURL)
+			_, _ = fmt.Fprintf(stdout, "  provider:    %s\n", parsedInfo.Provider)
+			_, _ = fmt.Fprintf(stdout, "  filename:    %s\n", parsedInfo.SuggestedFilename)
+			if versionRange != "" {
+				_, _ = fmt.Fprintf(stdout, "  range:       %s (tag %s)\n", versionRange, parsedInfo.Ref)
+			}
+
+			if parsedInfo.Provider != "github" {
+				_, _ = fmt.Fprintf(stdout, "  path:        %s\n", parsedInfo.PathInRepo)
+				_, _ = fmt.Fprintln(stderr, "Note: Upstream metadata is only available for GitHub sources.")
+				return nil
+			}
+
+			client := source.DefaultClient()
+			_, _ = fmt.Fprintf(stdout, "  repository:  %s/%s\n", parsedInfo.Owner, parsedInfo.Repo)
+			if repo, repoErr := client.GetRepository(parsedInfo.Owner, parsedInfo.Repo); repoErr != nil {
+				_, _ = fmt.Fprintf(stderr, "Warning: Could not fetch repository details: %v\n", repoErr)
+			} else {
+				if repo.Description != "" {
+					_, _ = fmt.Fprintf(stdout, "  description: %s\n", repo.Description)
+				}
+				if repo.DefaultBranch != "" {
+					_, _ = fmt.Fprintf(stdout, "  default:     %s\n", repo.DefaultBranch)
+				}
+			}
+
+			_, _ = fmt.Fprintf(stdout, "  file:        %s@%s\n", parsedInfo.PathInRepo, parsedInfo.Ref)
+			if commit, commitErr := client.GetLatestCommitForFile(parsedInfo.Owner, parsedInfo.Repo, parsedInfo.PathInRepo, parsedInfo.Ref); commitErr != nil {
+				_, _ = fmt.Fprintf(stderr, "Warning: Could not fetch the file's latest commit: %v\n", commitErr)
+			} else {
+				// A branch or tag is what add would lock by this commit.
+				if !source.IsImmutableRef(parsedInfo.Provider, parsedInfo.Ref) {
+					_, _ = fmt.Fprintf(stdout, "  commit:      %s\n", commit.SHA)
+				}
+				sha := commit.SHA
+				if len(sha) > 7 {
+					sha = sha[:7]
+				}
+				_, _ = fmt.Fprintf(stdout, "  last commit: %s %s %s\n", sha, commit.Commit.Committer.Date.Format("2006-01-02"), firstLine(commit.Commit.Message))
+			}
+			return nil
+		},
+	}
+}
@@ -0,0 +1,137 @@
+package info
+
+import (
+	"bytes"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/urfave/cli/v2"
+
+	"github.com/nightconcept/almandine-go/internal/core/config"
+	"github.com/nightconcept/almandine-go/internal/core/source"
+)
+
+const infoProjectToml = `
+[package]
+name = "test-info"
+version = "0.1.0"
+
+[dependencies.json]
+source = "github:rxi/json.lua/json.lua@master"
+path = "src/lib/json.lua"
+`
+
+// startMockGitHubAPI serves the repository and commits endpoints for rxi/json.lua and points
+// the default source client at it. repoStatus controls the repository endpoint's response.
+func startMockGitHubAPI(t *testing.T, repoStatus int) {
+	t.Helper()
+	mux := http.NewServeMux()
+	mux.HandleFunc("/repos/rxi/json.lua", func(w http.ResponseWriter, r *http.Request) {
+		if repoStatus != http.StatusOK {
+			http.Error(w, `{"message": "Server Error"}`, repoStatus)
+			return
+		}
+		_, _ = fmt.Fprint(w, `{"full_name": "rxi/json.lua", "description": "A lightweight JSON library for Lua", "default_branch": "master"}`)
+	})
+	mux.HandleFunc("/repos/rxi/json.lua/commits", func(w http.ResponseWriter, r *http.Request) {
+		_, _ = fmt.Fprint(w, `[{"sha": "dbf4b2dd2eb7c23be2773c89eb059dadd6436f94", "commit": {"message": "Fix unicode escapes\n\nLonger body", "committer": {"date": "2024-03-01T12:00:00Z"}}}]`)
+	})
+	server := httptest.NewServer(mux)
+	t.Cleanup(server.Close)
+
+	original := source.GithubAPIBaseURL
+	source.GithubAPIBaseURL = server.URL
+	t.Cleanup(func() { source.GithubAPIBaseURL = original })
+}
+
+// runInfoCommand runs 'info' in workDir and returns its stdout, stderr and error.
+func runInfoCommand(t *testing.T, workDir string, args ...string) (string, string, error) {
+	t.Helper()
+
+	originalWd, err := os.Getwd()
+	require.NoError(t, err)
+	require.NoError(t, os.Chdir(workDir))
+	defer func() { require.NoError(t, os.Chdir(originalWd)) }()
+
+	var stdout, stderr bytes.Buffer
+	app := &cli.App{
+		Name:           "almd-test-info",
+		Commands:       []*cli.Command{NewInfoCommand()},
+		Writer:         &stdout,
+		ErrWriter:      &stderr,
+		ExitErrHandler: func(context *cli.Context, err error) {},
+	}
+	err = app.Run(append([]string{"almd-test-info", "info"}, args...))
+	return stdout.String(), stderr.String(), err
+}
+
+func TestInfoCommand_InstalledDependency(t *testing.T) {
+	startMockGitHubAPI(t, http.StatusOK)
+	tempDir := t.TempDir()
+	require.NoError(t, os.WriteFile(filepath.Join(tempDir, config.ProjectTomlName), []byte(infoProjectToml), 0644))
+
+	stdout, stderr, err := runInfoCommand(t, tempDir, "json")
+	require.NoError(t, err)
+	assert.Empty(t, stderr)
+	assert.Contains(t, stdout, "json\n")
+	assert.Contains(t, stdout, "repository:  rxi/json.lua")
+	assert.Contains(t, stdout, "description: A lightweight JSON library for Lua")
+	assert.Contains(t, stdout, "last commit: dbf4b2d 2024-03-01 Fix unicode escapes\n")
+}
+
+func TestInfoCommand_SourceURLDegradesGracefully(t *testing.T) {
+	startMockGitHubAPI(t, http.StatusInternalServerError)
+	tempDir := t.TempDir()
+
+	stdout, stderr, err := runInfoCommand(t, tempDir, "github:rxi/json.lua/json.lua@master")
+	require.NoError(t, err, "a failing API call should not fail the command")
+	assert.Contains(t, stderr, "Warning: Could not fetch repository details")
+	assert.NotContains(t, stdout, "description:")
+	assert.Contains(t, stdout, "last commit: dbf4b2d")
+}
+
+func TestInfoCommand_UnknownName(t *testing.T) {
+	tempDir := t.TempDir()
+	require.NoError(t, os.WriteFile(filepath.Join(tempDir, config.ProjectTomlName), []byte(infoProjectToml), 0644))
+
+	_, _, err := runInfoCommand(t, tempDir, "yaml")
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "Dependency 'yaml' not found")
+}
+
+func TestInfoCommand_ShowsParsedSource(t *testing.T) {
+	startMockGitHubAPI(t, http.StatusOK)
+	tempDir := t.TempDir()
+
+	stdout, _, err := runInfoCommand(t, tempDir, "https://github.com/rxi/json.lua/blob/master/json.lua")
+	require.NoError(t, err)
+	assert.Contains(t, stdout, "  source:      github:rxi/json.lua/json.lua@master\n")
+	assert.Contains(t, stdout, "  download:    https://raw.githubusercontent.com/rxi/json.lua/master/json.lua\n")
+	assert.Contains(t, stdout, "  provider:    github\n")
+	assert.Contains(t, stdout, "  filename:    json.lua\n")
+	assert.Contains(t, stdout, "  file:        json.lua@master\n")
+	assert.Contains(t, stdout, "  commit:      dbf4b2dd2eb7c23be2773c89eb059dadd6436f94\n", "a branch is shown with the commit it resolves to")
+
+	stdout, _, err = runInfoCommand(t, tempDir, "github:rxi/json.lua/json.lua@dbf4b2dd2eb7c23be2773c89eb059dadd6436f94")
+	require.NoError(t, err)
+	assert.NotContains(t, stdout, "  commit:", "a commit ref needs no resolving")
+
+	t.Run("plain http source", func(t *testing.T) {
+		stdout, stderr, err := runInfoCommand(t, tempDir, "https://example.com/libs/foo.lua")
+		require.NoError(t, err)
+		assert.Contains(t, stdout, "  provider:    http\n")
+		assert.Contains(t, stdout, "  path:        libs/foo.lua\n")
+		assert.Contains(t, stdout, "  filename:    foo.lua\n")
+		assert.Contains(t, stderr, "only available for GitHub sources")
+	})
+
+	entries, err := os.ReadDir(tempDir)
+	require.NoError(t, err)
+	assert.Empty(t, entries, "info writes nothing")
+}
@@ -4,10 +4,13 @@ import (
 	"bufio"
 	"fmt"
 	"os"
+	"path/filepath"
 	"strings"
 
 	"github.com/nightconcept/almandine-go/internal/core/config"
+	"github.com/nightconcept/almandine-go/internal/core/lockfile"
 	"github.com/nightconcept/almandine-go/internal/core/project"
+	"github.com/nightconcept/almandine-go/internal/core/updatecache"
 	"github.com/urfave/cli/v2"
 )
 
@@ -32,39 +35,165 @@ func promptWithDefault(reader *bufio.Reader, promptText string, defaultValue str
 	return input, nil
 }
 
+// scaffoldLibDir is the dependency directory 'almd add' installs into by default.
+const scaffoldLibDir = "src/lib"
+
+// gitignoreEntries are the patterns for files almd may leave in a project, such as the
+// temporary files staged by atomic writes, the process lock held while a command runs and the
+// update cache read by 'almd list'.
+var gitignoreEntries = []string{".almd-*.tmp", lockfile.ProcessLockName, updatecache.FileName}
+
+// scaffold creates the default dependency directory under root and appends any missing
+// gitignoreEntries to root/.gitignore, creating it if needed. Existing files are never
+// overwritten, so running it again changes nothing.
+func scaffold(root string) error {
+	libDir := filepath.Join(root, filepath.FromSlash(scaffoldLibDir))
+	if _, err := os.Stat(libDir); os.IsNotExist(err) {
+		if err := os.MkdirAll(libDir, 0755); err != nil {
+			return fmt.Errorf("failed to create %s: %w", scaffoldLibDir, err)
+		}
+		fmt.Printf("Created %s/\n", scaffoldLibDir)
+	}
+
+	gitignorePath := filepath.Join(root, ".gitignore")
+	existing, err := os.ReadFile(gitignorePath)
+	if err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("failed to read .gitignore: %w", err)
+	}
+	present := make(map[string]bool)
+	for _, line := range strings.Split(string(existing), "\n") {
+		present[strings.TrimSpace(line)] = true
+	}
+	var missing []string
+	for _, entry := range gitignoreEntries {
+		if !present[entry] {
+			missing = append(missing, entry)
+		}
+	}
+	if len(missing) == 0 {
+		return nil
+	}
+
+	var addition strings.Builder
+	if len(existing) > 0 && !strings.HasSuffix(string(existing), "\n") {
+		addition.WriteString("\n")
+	}
+	if !present["# almd"] {
+		addition.WriteString("# almd\n")
+	}
+	for _, entry := range missing {
+		addition.WriteString(entry + "\n")
+	}
+	f, err := os.OpenFile(gitignorePath, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return fmt.Errorf("failed to open .gitignore: %w", err)
+	}
+	if _, err := f.WriteString(addition.String()); err != nil {
+		_ = f.Close()
+		return fmt.Errorf("failed to update .gitignore: %w", err)
+	}
+	if err := f.Close(); err != nil {
+		return fmt.Errorf("failed to update .gitignore: %w", err)
+	}
+	fmt.Printf("Added %d entr(ies) to .gitignore\n", len(missing))
+	return nil
+}
+
+// findGitDir walks up from dir looking for a .git entry, which is a directory in an ordinary
+// checkout and a file in worktrees and submodules. It returns the directory containing it.
+func findGitDir(dir string) (string, bool) {
+	for {
+		if _, err := os.Stat(filepath.Join(dir, ".git")); err == nil {
+			return dir, true
+		}
+		parent := filepath.Dir(dir)
+		if parent == dir {
+			return "", false
+		}
+		dir = parent
+	}
+}
+
 // GetInitCommand returns the definition for the "init" command.
 func GetInitCommand() *cli.Command {
 	return &cli.Command{
 		Name:  "init",
 		Usage: "Initialize a new Almandine project (creates project.toml)",
+		Flags: []cli.Flag{
+			&cli.BoolFlag{
+				Name:  "require-git",
+				Usage: "Fail unless the current directory is inside a git repository",
+			},
+			&cli.BoolFlag{
+				Name:  "scaffold",
+				Usage: "Also create src/lib/ and add almd's temporary files to .gitignore",
+			},
+			&cli.StringFlag{
+				Name:  "name",
+				Usage: "Package name (skips its prompt)",
+			},
+			&cli.StringFlag{
+				Name:  "version",
+				Usage: "Package version (skips its prompt)",
+			},
+			&cli.StringFlag{
+				Name:  "license",
+				Usage: "Package license (skips its prompt)",
+			},
+			&cli.StringFlag{
+				Name:  "description",
+				Usage: "Package description (skips its prompt)",
+			},
+			&cli.BoolFlag{
+				Name:    "yes",
+				Aliases: []string{"y"},
+				Usage:   "Do not prompt; use the flags given and defaults for everything else",
+			},
+		},
 		Action: func(c *cli.Context) error {
+			if c.Bool("require-git") {
+				wd, err := os.Getwd()
+				if err != nil {
+					return cli.Exit(fmt.Sprintf("Error determining the current directory: %v", err), 1)
+				}
+				if _, ok := findGitDir(wd); !ok {
+					return cli.Exit("Error: Not inside a git repository (--require-git). Run 'git init' first.", 1)
+				}
+			}
+
 			fmt.Println("Starting project initialization...")
 
 			reader := bufio.NewReader(os.Stdin)
 
-			var packageName, version, license, description string
-			var err error
+			// A field given as a flag is not prompted for; --yes takes the default for the rest.
+			nonInteractive := c.Bool("yes")
+			field := func(flagName, promptText, defaultValue string) (string, error) {
+				if c.IsSet(flagName) {
+					return c.String(flagName), nil
+				}
+				if nonInteractive {
+					return defaultValue, nil
+				}
+				return promptWithDefault(reader, promptText, defaultValue)
+			}
 
-			// Prompt for package name
-			packageName, err = promptWithDefault(reader, "Package name", "my-almandine-project")
+			packageName, err := field("name", "Package name", "my-almandine-project")
 			if err != nil {
 				return cli.Exit(err.Error(), 1)
 			}
 
-			// Prompt for version
-			version, err = promptWithDefault(reader, "Version", "0.1.0")
+			version, err := field("version", "Version", "0.1.0")
 			if err != nil {
 				return cli.Exit(err.Error(), 1)
 			}
 
-			// Prompt for license
-			license, err = promptWithDefault(reader, "License", "MIT")
+			license, err := field("license", "License", "MIT")
 			if err != nil {
 				return cli.Exit(err.Error(), 1)
 			}
 
-			// Prompt for description (optional, default is empty)
-			description, err = promptWithDefault(reader, "Description (optional)", "")
+			// Description is optional; its default is empty.
+			description, err := field("description", "Description (optional)", "")
 			if err != nil {
 				return cli.Exit(err.Error(), 1)
 			}
@@ -78,9 +207,11 @@ func GetInitCommand() *cli.Command {
 
 			// --- Task 1.3: Implement Interactive Prompts for Scripts ---
 			scripts := make(map[string]string)
-			fmt.Println("\nEnter scripts (leave script name empty to finish):")
+			if !nonInteractive {
+				fmt.Println("\nEnter scripts (leave script name empty to finish):")
+			}
 
-			for {
+			for !nonInteractive {
 				scriptName, errLFSN := promptWithDefault(reader, "Script name", "") // Renamed err to avoid conflict
 				if errLFSN != nil {
 					return cli.Exit(fmt.Sprintf("Error reading script name: %v", errLFSN), 1)
@@ -105,9 +236,11 @@ func GetInitCommand() *cli.Command {
 
 			// --- Task 1.4: Implement Interactive Prompts for Dependencies (Placeholders) ---
 			dependencies := make(map[string]string)
-			fmt.Println("\nEnter dependencies (leave dependency name empty to finish):")
+			if !nonInteractive {
+				fmt.Println("\nEnter dependencies (leave dependency name empty to finish):")
+			}
 
-			for {
+			for !nonInteractive {
 				depName, errLFDN := promptWithDefault(reader, "Dependency name", "") // Renamed err
 				if errLFDN != nil {
 					return cli.Exit(fmt.Sprintf("Error reading dependency name: %v", errLFDN), 1)
@@ -163,6 +296,12 @@ func GetInitCommand() *cli.Command {
 			}
 
 			fmt.Println("\nSuccessfully initialized project and wrote project.toml.")
+
+			if c.Bool("scaffold") {
+				if err := scaffold("."); err != nil {
+					return cli.Exit(fmt.Sprintf("Error scaffolding project: %v", err), 1)
+				}
+			}
 			return nil
 		},
 	}
@@ -207,3 +207,135 @@ func TestInitCommand_DefaultsAndEmpty(t *testing.T) {
 	// Verify Dependencies (should be empty or nil)
 	assert.Nil(t, generatedConfig.Dependencies, "Dependencies should be nil/omitted") // Or assert.Empty(...) if preferred
 }
+
+func TestInitCommand_NonInteractive(t *testing.T) {
+	tempDir := t.TempDir()
+	originalWd, err := os.Getwd()
+	require.NoError(t, err)
+	require.NoError(t, os.Chdir(tempDir))
+	defer func() { _ = os.Chdir(originalWd) }()
+
+	// No input at all: --yes must not read stdin.
+	oldStdin := os.Stdin
+	rStdin, _, err := simulateInput(nil)
+	require.NoError(t, err)
+	os.Stdin = rStdin
+	defer func() { os.Stdin = oldStdin; _ = rStdin.Close() }()
+
+	oldStdout := os.Stdout
+	rStdout, wStdout, _, err := captureOutput()
+	require.NoError(t, err)
+	os.Stdout = wStdout
+	defer func() { os.Stdout = oldStdout; _ = wStdout.Close(); _ = rStdout.Close() }()
+
+	app := &cli.App{Name: "almandine-test", Commands: []*cli.Command{GetInitCommand()}}
+	require.NoError(t, app.Run([]string{"almandine-test", "init", "--yes", "--name", "myproj", "--license", "Apache-2.0"}))
+
+	tomlBytes, err := os.ReadFile(filepath.Join(tempDir, "project.toml"))
+	require.NoError(t, err)
+	var generatedConfig project.Project
+	require.NoError(t, toml.Unmarshal(tomlBytes, &generatedConfig))
+
+	assert.Equal(t, "myproj", generatedConfig.Package.Name)
+	assert.Equal(t, "0.1.0", generatedConfig.Package.Version, "unspecified fields take their defaults")
+	assert.Equal(t, "Apache-2.0", generatedConfig.Package.License)
+	assert.Equal(t, "", generatedConfig.Package.Description)
+	assert.Equal(t, map[string]string{"run": "lua src/main.lua"}, generatedConfig.Scripts)
+	assert.Nil(t, generatedConfig.Dependencies)
+}
+
+func TestInitCommand_Scaffold(t *testing.T) {
+	tempDir := t.TempDir()
+	originalWd, err := os.Getwd()
+	require.NoError(t, err)
+	require.NoError(t, os.Chdir(tempDir))
+	defer func() { _ = os.Chdir(originalWd) }()
+
+	// An existing .gitignore without a trailing newline is appended to, not replaced.
+	require.NoError(t, os.WriteFile(".gitignore", []byte("build/"), 0644))
+
+	runInit := func() {
+		t.Helper()
+		oldStdin := os.Stdin
+		rStdin, _, err := simulateInput([]string{"scaffolded", "", "", "", "", ""})
+		require.NoError(t, err)
+		os.Stdin = rStdin
+		defer func() { os.Stdin = oldStdin; _ = rStdin.Close() }()
+
+		oldStdout := os.Stdout
+		rStdout, wStdout, _, err := captureOutput()
+		require.NoError(t, err)
+		os.Stdout = wStdout
+		defer func() { os.Stdout = oldStdout; _ = wStdout.Close(); _ = rStdout.Close() }()
+
+		app := &cli.App{Name: "almandine-test", Commands: []*cli.Command{GetInitCommand()}}
+		require.NoError(t, app.Run([]string{"almandine-test", "init", "--scaffold"}))
+	}
+
+	runInit()
+	info, err := os.Stat(filepath.Join(tempDir, "src", "lib"))
+	require.NoError(t, err)
+	assert.True(t, info.IsDir())
+	require.NoError(t, os.WriteFile(filepath.Join(tempDir, "src", "lib", "keep.lua"), []byte("return {}"), 0644))
+
+	gitignore, err := os.ReadFile(filepath.Join(tempDir, ".gitignore"))
+	require.NoError(t, err)
+	assert.Equal(t, "build/\n# almd\n.almd-*.tmp\n.almd-lock.lock\n.almd-updates.json\n", string(gitignore))
+
+	runInit()
+	again, err := os.ReadFile(filepath.Join(tempDir, ".gitignore"))
+	require.NoError(t, err)
+	assert.Equal(t, string(gitignore), string(again), "re-running must not duplicate .gitignore entries")
+	assert.FileExists(t, filepath.Join(tempDir, "src", "lib", "keep.lua"), "existing files are left alone")
+}
+
+func TestInitCommand_RequireGit(t *testing.T) {
+	runInit := func(t *testing.T, dir string) error {
+		t.Helper()
+		originalWd, err := os.Getwd()
+		require.NoError(t, err)
+		require.NoError(t, os.Chdir(dir))
+		defer func() { _ = os.Chdir(originalWd) }()
+
+		oldStdin := os.Stdin
+		rStdin, _, err := simulateInput([]string{"git-checked", "", "", "", "", ""})
+		require.NoError(t, err)
+		os.Stdin = rStdin
+		defer func() { os.Stdin = oldStdin; _ = rStdin.Close() }()
+
+		oldStdout := os.Stdout
+		rStdout, wStdout, _, err := captureOutput()
+		require.NoError(t, err)
+		os.Stdout = wStdout
+		defer func() { os.Stdout = oldStdout; _ = wStdout.Close(); _ = rStdout.Close() }()
+
+		app := &cli.App{
+			Name:           "almandine-test",
+			Commands:       []*cli.Command{GetInitCommand()},
+			ExitErrHandler: func(context *cli.Context, err error) {},
+		}
+		return app.Run([]string{"almandine-test", "init", "--require-git"})
+	}
+
+	t.Run("inside a repository", func(t *testing.T) {
+		repoDir := t.TempDir()
+		require.NoError(t, os.Mkdir(filepath.Join(repoDir, ".git"), 0755))
+		projectDir := filepath.Join(repoDir, "game")
+		require.NoError(t, os.Mkdir(projectDir, 0755))
+
+		require.NoError(t, runInit(t, projectDir), "a .git directory in a parent counts")
+		assert.FileExists(t, filepath.Join(projectDir, "project.toml"))
+	})
+
+	t.Run("outside a repository", func(t *testing.T) {
+		dir := t.TempDir()
+		if gitDir, found := findGitDir(dir); found {
+			t.Skipf("temporary directory is inside the git repository at %s", gitDir)
+		}
+
+		err := runInit(t, dir)
+		require.Error(t, err)
+		assert.Contains(t, err.Error(), "Run 'git init' first")
+		assert.NoFileExists(t, filepath.Join(dir, "project.toml"))
+	})
+}
@@ -0,0 +1,13 @@
+package install
+
+import (
+	"time"
+
+	"github.com/urfave/cli/v2"
+)
+
+// NewInstallCommandOn creates the "install" command as it runs on goos/goarch, for matching
+// os/arch filters.
+func NewInstallCommandOn(goos, goarch string) *cli.Command {
+	return newInstallCommand(host{os: goos, arch: goarch, now: time.Now})
+}
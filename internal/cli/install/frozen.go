@@ -0,0 +1,224 @@
+package install
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/urfave/cli/v2"
+
+	"github.com/nightconcept/almandine-go/internal/core/contentcache"
+	"github.com/nightconcept/almandine-go/internal/core/downloader"
+	"github.com/nightconcept/almandine-go/internal/core/fsutil"
+	"github.com/nightconcept/almandine-go/internal/core/hasher"
+	"github.com/nightconcept/almandine-go/internal/core/lockfile"
+	"github.com/nightconcept/almandine-go/internal/core/project"
+	"github.com/nightconcept/almandine-go/internal/core/source"
+)
+
+// frozenOptions carries the install settings that still apply with --frozen-lockfile or --offline.
+type frozenOptions struct {
+	force    bool
+	failFast bool
+	verbose  bool
+	link     bool
+	useCache bool
+	offline  bool // Take every file from the content cache; never touch the network
+	store    contentcache.Store
+	maxSize  int64
+
+	downloads *downloader.Client
+
+	policyHooks []source.Hook // Project source policy, applied to every locked source URL
+}
+
+// frozenLockProblem reports why dep cannot be installed from entry without changing the
+// lockfile, or "" if it can.
+func frozenLockProblem(dep project.Dependency, entry lockfile.PackageEntry) string {
+	if entry.Source == "" {
+		return "its lockfile entry has no source URL"
+	}
+	if project.NormalizePath(dep.Path) != project.NormalizePath(entry.Path) {
+		return fmt.Sprintf("project.toml installs it at %s but almd-lock.toml at %s", dep.Path, entry.Path)
+	}
+	declared, err := source.ParseSourceURL(dep.Source)
+	if err != nil {
+		return fmt.Sprintf("its source '%s' cannot be parsed: %v", dep.Source, err)
+	}
+	locked, err := source.ParseSourceURL(entry.Source)
+	if err != nil || declared.Provider != "github" || locked.Provider != "github" {
+		return ""
+	}
+	if declared.Owner != locked.Owner || declared.Repo != locked.Repo || declared.PathInRepo != locked.PathInRepo {
+		return fmt.Sprintf("project.toml points at %s/%s/%s but almd-lock.toml at %s/%s/%s", declared.Owner, declared.Repo, declared.PathInRepo, locked.Owner, locked.Repo, locked.PathInRepo)
+	}
+	// A short SHA in project.toml is locked as the full SHA it expands to.
+	if source.IsImmutableRef(declared.Provider, declared.Ref) && !strings.HasPrefix(locked.Ref, declared.Ref) {
+		return fmt.Sprintf("project.toml pins commit %s but almd-lock.toml has %s", shortSHA(declared.Ref), shortSHA(locked.Ref))
+	}
+	return ""
+}
+
+// installFrozen installs the named dependencies exactly as almd-lock.toml records them. No
+// ref is resolved and the lockfile is never written: every dependency must already be locked
+// consistently with project.toml, and each download must match its locked hash. With
+// opts.offline, files come from the content cache only and nothing is downloaded.
+func installFrozen(names []string, deps map[string]project.Dependency, lf *lockfile.Lockfile, opts frozenOptions, report, summaryOut, stderr io.Writer) error {
+	var problems []string
+	for _, name := range names {
+		entry, ok := lf.Package[name]
+		if !ok {
+			problems = append(problems, fmt.Sprintf("'%s' is not in %s", name, lockfile.LockfileName))
+			continue
+		}
+		if problem := frozenLockProblem(deps[name], entry); problem != "" {
+			problems = append(problems, fmt.Sprintf("'%s': %s", name, problem))
+		}
+	}
+	if len(problems) > 0 {
+		for _, problem := range problems {
+			_, _ = fmt.Fprintf(stderr, "Error: %s\n", problem)
+		}
+		if opts.offline {
+			return cli.Exit(fmt.Sprintf("Error: %s is out of date with project.toml, which cannot be fixed offline. Run 'almd install' with network access first.", lockfile.LockfileName), 1)
+		}
+		return cli.Exit(fmt.Sprintf("Error: %s is out of date with project.toml and --frozen-lockfile forbids updating it. Run 'almd install' without --frozen-lockfile and commit the result.", lockfile.LockfileName), 1)
+	}
+
+	// The locked URLs are downloaded as they are, so they must pass the same source policy as
+	// project.toml before any of them is fetched.
+	downloadURLs := make(map[string]string, len(names))
+	for _, name := range names {
+		entry := lf.Package[name]
+		downloadURL, err := source.CheckURL(entry.Source, opts.policyHooks...)
+		if err != nil {
+			return cli.Exit(fmt.Sprintf("Error: Locked source for dependency '%s' (%s) rejected by policy: %v", name, entry.Source, err), 1)
+		}
+		downloadURLs[name] = downloadURL
+	}
+
+	var summary installSummary
+	for _, name := range names {
+		if opts.failFast && summary.Failed > 0 {
+			break
+		}
+		entry := lf.Package[name]
+		expected, hasExpected := entry.ExpectedContentHash()
+		nativePath := project.NativePath(entry.Path)
+
+		if !opts.force {
+			if content, err := os.ReadFile(nativePath); err == nil {
+				if !hasExpected {
+					summary.Unchanged++
+					continue
+				}
+				if match, err := hasher.Matches(content, expected); err == nil && match {
+					summary.Unchanged++
+					continue
+				}
+				_, _ = fmt.Fprintf(stderr, "Note: %s does not match the locked hash for '%s'; restoring the locked content.\n", entry.Path, name)
+			} else if !errors.Is(err, os.ErrNotExist) {
+				_, _ = fmt.Fprintf(stderr, "Warning: Could not read %s for '%s': %v. Reinstalling.\n", entry.Path, name, err)
+			}
+		}
+
+		// An entry from 'almd add --no-download' has no hash to hold a download to yet; a file
+		// supplied by hand was accepted above.
+		if entry.Hash == "" {
+			_, _ = fmt.Fprintf(stderr, "Error: '%s' has no locked hash yet (added with --no-download). Run 'almd install' to download and lock it.\n", name)
+			summary.Failed++
+			continue
+		}
+
+		// A locked commit URL always serves the same bytes, so its content may come from the cache.
+		key := ""
+		if locked, err := source.ParseSourceURL(entry.Source); err == nil && strings.HasPrefix(entry.Hash, "commit:") {
+			key = cacheKey(locked.Provider, locked.Owner, locked.Repo, locked.Ref, locked.PathInRepo)
+		}
+		var content []byte
+		fromCache := false
+		if opts.useCache {
+			// A locked sha256 content hash names the blob too, which covers sources without a commit.
+			hash, ok := "", false
+			if key != "" {
+				hash, ok = opts.store.Lookup(key)
+			}
+			if !ok && hasExpected {
+				hash, ok = expected, true
+			}
+			if ok {
+				if blobPath, err := opts.store.Load(hash); err == nil {
+					if cached, err := os.ReadFile(blobPath); err == nil {
+						content, fromCache = cached, true
+					}
+				}
+			}
+		}
+		if !fromCache && opts.offline {
+			_, _ = fmt.Fprintf(stderr, "Error: '%s' (%s) is not in the content cache, so it cannot be installed offline.\n", name, entry.Source)
+			summary.Failed++
+			continue
+		}
+		if !fromCache {
+			if opts.verbose {
+				_, _ = fmt.Fprintf(stderr, "  Installing '%s' from locked source %s\n", name, entry.Source)
+			}
+			downloaded, err := opts.downloads.DownloadFileWithLimit(downloadURLs[name], opts.maxSize)
+			if err != nil {
+				_, _ = fmt.Fprintf(stderr, "Error: Failed to download dependency '%s' from '%s': %v\n", name, entry.Source, err)
+				summary.Failed++
+				continue
+			}
+			content = downloaded
+		}
+
+		contentHash, err := hasher.Calculate(content, hasher.AlgorithmFor(expected))
+		if err != nil {
+			_, _ = fmt.Fprintf(stderr, "Error: Failed to calculate content hash for dependency '%s': %v\n", name, err)
+			summary.Failed++
+			continue
+		}
+		if hasExpected && contentHash != expected {
+			_, _ = fmt.Fprintf(stderr, "Error: Content of '%s' from %s hashes to %s, but %s locks %s. Not installing it.\n", name, entry.Source, contentHash, lockfile.LockfileName, expected)
+			summary.Failed++
+			continue
+		}
+
+		if err := os.MkdirAll(filepath.Dir(nativePath), os.ModePerm); err != nil {
+			_, _ = fmt.Fprintf(stderr, "Error: Failed to create directory '%s' for dependency '%s': %v\n", filepath.Dir(nativePath), name, err)
+			summary.Failed++
+			continue
+		}
+		if opts.link {
+			if err := linkFromCache(opts.store, content, nativePath, key, stderr, opts.verbose); err != nil {
+				_, _ = fmt.Fprintf(stderr, "Error: Failed to link file '%s' for dependency '%s' from the content cache: %v\n", entry.Path, name, err)
+				summary.Failed++
+				continue
+			}
+		} else if err := fsutil.WriteFileAtomic(nativePath, content, 0644); err != nil {
+			_, _ = fmt.Fprintf(stderr, "Error: Failed to write file '%s' for dependency '%s': %v\n", entry.Path, name, err)
+			summary.Failed++
+			continue
+		} else if opts.useCache && !fromCache && key != "" {
+			storeInCache(opts.store, content, key, stderr, opts.verbose)
+		}
+		summary.Reinstalled++
+	}
+
+	_, _ = fmt.Fprintln(summaryOut, summary)
+	if summary.Failed > 0 {
+		if opts.offline {
+			return cli.Exit(fmt.Sprintf("Error: %d dependenc(ies) could not be installed offline. Run 'almd install' with network access to cache them.", summary.Failed), 1)
+		}
+		return cli.Exit(fmt.Sprintf("Error: %d dependenc(ies) could not be installed from %s.", summary.Failed, lockfile.LockfileName), 1)
+	}
+	if summary.Reinstalled == 0 {
+		_, _ = fmt.Fprintln(report, "All targeted dependencies already match almd-lock.toml.")
+	} else {
+		_, _ = fmt.Fprintf(report, "Installed %d dependenc(ies) from almd-lock.toml.\n", summary.Reinstalled)
+	}
+	return nil
+}
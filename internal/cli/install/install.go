@@ -3,25 +3,66 @@ package install
 import (
 	"errors"
 	"fmt"
+	"io"
+	"net/http"
 	"os"
+	"path"
 	"path/filepath"
-	"regexp"
+	"runtime"
+	"sort"
 	"strings"
+	"time"
 
 	"github.com/urfave/cli/v2"
 
+	"github.com/nightconcept/almandine-go/internal/cli/output"
+	"github.com/nightconcept/almandine-go/internal/cli/prompt"
 	"github.com/nightconcept/almandine-go/internal/core/config"
+	"github.com/nightconcept/almandine-go/internal/core/contentcache"
 	"github.com/nightconcept/almandine-go/internal/core/downloader"
+	"github.com/nightconcept/almandine-go/internal/core/fsutil"
 	"github.com/nightconcept/almandine-go/internal/core/hasher"
 	"github.com/nightconcept/almandine-go/internal/core/lockfile"
+	"github.com/nightconcept/almandine-go/internal/core/project"
+	"github.com/nightconcept/almandine-go/internal/core/requires"
 	"github.com/nightconcept/almandine-go/internal/core/source"
+	"github.com/nightconcept/almandine-go/internal/core/updatecache"
 )
 
-var isCommitSHARegex = regexp.MustCompile(`^[0-9a-f]{7,40}$`) // Common Git SHA lengths
+// defaultMaxCommitJump is the number of upstream commits a branch-pinned dependency may move
+// in a single install before the user is asked to confirm the update.
+const defaultMaxCommitJump = 50
+
+// host is what install learns about the machine it runs on: the platform matched against a
+// dependency's os/arch filters and the clock. Tests build the command with a host of their own
+// instead of changing package state.
+type host struct {
+	os, arch string
+	now      func() time.Time
+}
+
+// installSummary tallies the outcome of every targeted dependency for the closing summary line.
+type installSummary struct {
+	Added       int
+	Updated     int
+	Reinstalled int
+	Unchanged   int
+	Failed      int
+}
+
+// String formats the summary as the single line printed at the end of an install.
+func (s installSummary) String() string {
+	return fmt.Sprintf("Summary: %d added, %d updated, %d reinstalled, %d unchanged, %d failed.", s.Added, s.Updated, s.Reinstalled, s.Unchanged, s.Failed)
+}
 
 // NewInstallCommand creates a new cli.Command for the "install" command.
 func NewInstallCommand() *cli.Command {
-	return &cli.Command{
+	return newInstallCommand(host{os: runtime.GOOS, arch: runtime.GOARCH, now: time.Now})
+}
+
+// newInstallCommand creates the "install" command for h.
+func newInstallCommand(h host) *cli.Command {
+	cmd := &cli.Command{
 		Name:      "install",
 		Usage:     "Installs or updates project dependencies based on project.toml",
 		ArgsUsage: "[dependency_names...]",
@@ -35,27 +76,223 @@ func NewInstallCommand() *cli.Command {
 				Name:  "verbose",
 				Usage: "Enable verbose output",
 			},
+			&cli.BoolFlag{
+				Name:    "yes",
+				Aliases: []string{"y"},
+				Usage:   "Do not prompt before moving a branch-pinned dependency across many upstream commits",
+			},
+			&cli.IntFlag{
+				Name:  "max-commit-jump",
+				Usage: fmt.Sprintf("Prompt before a branch-pinned update spans more than this many commits (0 disables; default %d or [almd] max_commit_jump)", defaultMaxCommitJump),
+			},
+			&cli.StringFlag{
+				Name:  "max-size",
+				Usage: "Largest file to download, e.g. 200MB (0 disables the limit)",
+				Value: "50MB",
+			},
+			&cli.BoolFlag{
+				Name:  "fail-fast",
+				Usage: "Stop at the first dependency that fails to download, verify or write",
+			},
+			&cli.BoolFlag{
+				Name:  "keep-going",
+				Usage: "Continue past failed dependencies and report them at the end (default)",
+			},
+			&cli.BoolFlag{
+				Name:  "summary-only",
+				Usage: "Print only the final summary line to stdout (errors still go to stderr)",
+			},
+			output.QuietFlag(),
+			&cli.BoolFlag{
+				Name:  "only-missing-lock",
+				Usage: "Lock files that are present but unlocked by hashing them in place; download only missing files",
+			},
+			&cli.BoolFlag{
+				Name:  "frozen-lockfile",
+				Usage: "Install exactly what almd-lock.toml records, without resolving refs; fail instead of changing the lockfile (for CI)",
+			},
+			&cli.StringFlag{
+				Name:  "source",
+				Usage: "Install the named dependency from this source instead, for this run only (e.g. a fork)",
+			},
+			&cli.StringSliceFlag{
+				Name:  "ref",
+				Usage: "Install the named dependency at another branch, tag or commit for this run only; project.toml is not changed (NAME=REF, repeatable)",
+			},
+			&cli.BoolFlag{
+				Name:  "save",
+				Usage: "With --source, also record the override source in project.toml",
+			},
+			&cli.BoolFlag{
+				Name:  "link",
+				Usage: "Share files through the global content cache ($ALMD_CACHE_DIR), hardlinking them into the project (read-only) where possible",
+			},
+			&cli.BoolFlag{
+				Name:  "no-cache",
+				Usage: "Always download commit-pinned files instead of reusing them from the content cache",
+			},
+			&cli.StringSliceFlag{
+				Name:  "remap",
+				Usage: "When a GitHub file 404s under path prefix OLD, retry it under NEW and update project.toml (OLD=NEW, repeatable)",
+			},
+			&cli.StringFlag{
+				Name:  "generate-requires",
+				Usage: "Write a file requiring every production dependency (overrides [almd] generate_requires)",
+			},
+			&cli.BoolFlag{
+				Name:    "production",
+				Aliases: []string{"no-dev"},
+				Usage:   "Skip [dev-dependencies]",
+			},
+			&cli.BoolFlag{
+				Name:  "offline",
+				Usage: "Install what almd-lock.toml records using only the content cache; no network access, fail for anything not cached",
+			},
+			&cli.StringSliceFlag{
+				Name:  "only",
+				Usage: "Install only these dependencies, like naming them as arguments (comma-separated, repeatable)",
+			},
+			&cli.StringSliceFlag{
+				Name:  "exclude",
+				Usage: "Install every dependency except these (comma-separated, repeatable)",
+			},
+			&cli.BoolFlag{
+				Name:  "check",
+				Usage: "Only report dependencies that would be installed or updated, exiting 1 if there are any; nothing is downloaded or written",
+			},
 		},
 		Action: func(c *cli.Context) error {
-			verbose := c.Bool("verbose")
+			// Primary results go to stdout; warnings, errors and verbose tracing go to stderr
+			// so that tooling capturing stdout is not polluted by diagnostics.
+			if err := output.CheckQuiet(c); err != nil {
+				return err
+			}
+			stdout, stderr := output.Stdout(c), c.App.ErrWriter
+			// --summary-only replaces the usual stdout result line, and the verbose trace, with a
+			// single line of counts.
+			summaryOnly := c.Bool("summary-only")
+			report, summaryOut := stdout, io.Discard
+			if summaryOnly {
+				report, summaryOut = io.Discard, stdout
+			}
+			var summary installSummary
+			verbose := c.Bool("verbose") && !summaryOnly
+			// A single GitHub client serves every API call made during this install, and a single
+			// downloader every download.
+			ghConfig := source.DefaultConfig()
+			downloads := &downloader.Client{Progress: output.DownloadProgress(c)}
+			if verbose {
+				ghConfig.Verbose, downloads.Verbose = stderr, stderr
+			}
+			ghClient := source.NewClient(ghConfig)
+			if c.Bool("fail-fast") && c.Bool("keep-going") {
+				return cli.Exit("Error: --fail-fast and --keep-going cannot be used together.", 1)
+			}
+			failFast := c.Bool("fail-fast")
+			onlyMissingLock := c.Bool("only-missing-lock")
+			remaps, err := parseRemaps(c.StringSlice("remap"))
+			if err != nil {
+				return cli.Exit(fmt.Sprintf("Error: Invalid --remap: %v", err), 1)
+			}
+			remappedSources := make(map[string]string) // dependency name -> source under its new path
+			link := c.Bool("link")
+			useCache := !c.Bool("no-cache")
+			if link && !useCache {
+				return cli.Exit("Error: --link and --no-cache cannot be used together.", 1)
+			}
+			// --offline installs the locked state from the content cache alone, so it cannot be
+			// combined with anything that resolves or downloads a source.
+			offline := c.Bool("offline")
+			if offline {
+				for _, flag := range []string{"no-cache", "only-missing-lock", "source", "save", "remap", "ref"} {
+					if c.IsSet(flag) {
+						return cli.Exit(fmt.Sprintf("Error: --offline and --%s cannot be used together.", flag), 1)
+					}
+				}
+			}
+			var store contentcache.Store
+			if cacheDir, err := contentcache.DefaultDir(); err == nil {
+				store = contentcache.Store{Dir: cacheDir}
+			} else if link || offline {
+				return cli.Exit(fmt.Sprintf("Error: %v", err), 1)
+			} else {
+				// The cache only saves downloads, so install without it.
+				useCache = false
+				if verbose {
+					_, _ = fmt.Fprintf(stderr, "Content cache disabled: %v\n", err)
+				}
+			}
+			if onlyMissingLock && c.Bool("force") {
+				return cli.Exit("Error: --only-missing-lock and --force cannot be used together.", 1)
+			}
+			maxSize, err := downloader.ParseSize(c.String("max-size"))
+			if err != nil {
+				return cli.Exit(fmt.Sprintf("Error: Invalid --max-size: %v", err), 1)
+			}
 			force := c.Bool("force") // Keep force for later use
+			frozen := c.Bool("frozen-lockfile")
+			// --check resolves refs and compares them with the lockfile like a normal install, then
+			// stops: it is a read-only gate for hooks and CI.
+			check := c.Bool("check")
+			if check {
+				for _, flag := range []string{"force", "only-missing-lock", "frozen-lockfile", "offline", "source", "save", "remap", "link", "generate-requires"} {
+					if c.IsSet(flag) {
+						return cli.Exit(fmt.Sprintf("Error: --check and --%s cannot be used together.", flag), 1)
+					}
+				}
+			}
+			if frozen {
+				for _, flag := range []string{"only-missing-lock", "source", "remap", "ref"} {
+					if c.IsSet(flag) {
+						return cli.Exit(fmt.Sprintf("Error: --frozen-lockfile and --%s cannot be used together; --%s changes almd-lock.toml.", flag, flag), 1)
+					}
+				}
+			}
 
 			if verbose {
-				_, _ = fmt.Fprintln(os.Stdout, "Executing 'install' command...")
+				_, _ = fmt.Fprintln(stderr, "Executing 'install' command...")
 				if force {
-					_, _ = fmt.Fprintln(os.Stdout, "Force install/update enabled.")
+					_, _ = fmt.Fprintln(stderr, "Force install/update enabled.")
 				}
 			}
 
-			dependencyNames := c.Args().Slice()
+			// --only is the positional names spelled as a flag; --exclude selects the complement.
+			dependencyNames := append(c.Args().Slice(), splitNames(c.StringSlice("only"))...)
+			excludedNames := splitNames(c.StringSlice("exclude"))
+			if len(excludedNames) > 0 && c.IsSet("only") {
+				return cli.Exit("Error: --only and --exclude cannot be used together.", 1)
+			}
+			if len(excludedNames) > 0 && len(dependencyNames) > 0 {
+				return cli.Exit("Error: --exclude cannot be combined with dependency names; name what to install or what to skip, not both.", 1)
+			}
+			overrideSource := c.String("source")
+			if overrideSource != "" && len(dependencyNames) != 1 {
+				return cli.Exit("Error: --source applies to exactly one dependency, e.g. 'almd install <name> --source <source>'.", 1)
+			}
+			refOverrides, err := parseRefOverrides(c.StringSlice("ref"))
+			if err != nil {
+				return cli.Exit(fmt.Sprintf("Error: Invalid --ref: %v", err), 1)
+			}
+			if overrideSource != "" && len(refOverrides) > 0 {
+				return cli.Exit("Error: --source and --ref cannot be used together; put the ref in the --source value.", 1)
+			}
+			if c.Bool("save") && overrideSource == "" {
+				return cli.Exit("Error: --save is only meaningful together with --source.", 1)
+			}
 			if verbose {
 				if len(dependencyNames) > 0 {
-					_, _ = fmt.Fprintf(os.Stdout, "Targeted dependencies for install/update: %v\n", dependencyNames)
+					_, _ = fmt.Fprintf(stderr, "Targeted dependencies for install/update: %v\n", dependencyNames)
 				} else {
-					_, _ = fmt.Fprintln(os.Stdout, "Targeting all dependencies for install/update.")
+					_, _ = fmt.Fprintln(stderr, "Targeting all dependencies for install/update.")
 				}
 			}
 
+			release, lockErr := lockfile.Acquire(".")
+			if lockErr != nil {
+				return cli.Exit(fmt.Sprintf("Error: %v", lockErr), 1)
+			}
+			defer release()
+
 			// Load project.toml
 			projCfg, err := config.LoadProjectToml(".")
 			if err != nil {
@@ -65,7 +302,7 @@ func NewInstallCommand() *cli.Command {
 				return cli.Exit(fmt.Sprintf("Error loading project.toml: %v", err), 1)
 			}
 			if verbose {
-				_, _ = fmt.Fprintf(os.Stdout, "Successfully loaded project.toml (Package: %s)\n", projCfg.Package.Name)
+				_, _ = fmt.Fprintf(stderr, "Successfully loaded project.toml (Package: %s)\n", projCfg.PackageName())
 			}
 
 			// Load almd-lock.toml
@@ -74,7 +311,7 @@ func NewInstallCommand() *cli.Command {
 				return cli.Exit(fmt.Sprintf("Error loading almd-lock.toml: %v", err), 1)
 			}
 			if verbose {
-				_, _ = fmt.Fprintln(os.Stdout, "Successfully loaded or initialized almd-lock.toml.")
+				_, _ = fmt.Fprintln(stderr, "Successfully loaded or initialized almd-lock.toml.")
 			}
 			if lf.Package == nil {
 				lf.Package = make(map[string]lockfile.PackageEntry)
@@ -85,143 +322,373 @@ func NewInstallCommand() *cli.Command {
 
 			// --- Task 6.3: Dependency Iteration and Configuration Retrieval ---
 			type dependencyToProcess struct {
-				Name   string
-				Source string
-				Path   string
+				Name        string
+				Source      string
+				Path        string
+				ChecksumURL string
 			}
 			var dependenciesToProcessList []dependencyToProcess
 
+			// [dev-dependencies] are installed alongside [dependencies] unless --production is given.
+			production := c.Bool("production")
+			declaredDeps := projCfg.AllDependencies()
+			if production {
+				declaredDeps = projCfg.Dependencies
+			}
+			groupOf := func(name string) string {
+				_, group, _ := projCfg.LookupDependency(name)
+				return group
+			}
+
+			excluded := make(map[string]bool, len(excludedNames))
+			for _, name := range excludedNames {
+				if _, _, ok := projCfg.LookupDependency(name); !ok {
+					_, _ = fmt.Fprintf(stderr, "Warning: Dependency '%s' given to --exclude not found in project.toml.\n", name)
+				}
+				excluded[name] = true
+			}
+
 			if len(dependencyNames) == 0 { // Install/update all dependencies defined in project.toml
-				if len(projCfg.Dependencies) == 0 {
-					_, _ = fmt.Fprintln(os.Stdout, "No dependencies found in project.toml to install/update.")
+				if len(declaredDeps) == 0 {
+					_, _ = fmt.Fprintln(report, "No dependencies found in project.toml to install/update.")
+					_, _ = fmt.Fprintln(summaryOut, summary)
 					return nil
 				}
 				if verbose {
-					_, _ = fmt.Fprintf(os.Stdout, "Processing all %d dependencies from project.toml...\n", len(projCfg.Dependencies))
+					_, _ = fmt.Fprintf(stderr, "Processing all %d dependencies from project.toml...\n", len(declaredDeps))
+				}
+				// Work in name order so output, and where --fail-fast stops, is the same on every run.
+				allNames := make([]string, 0, len(declaredDeps))
+				for name := range declaredDeps {
+					allNames = append(allNames, name)
 				}
-				for name, depDetails := range projCfg.Dependencies {
+				sort.Strings(allNames)
+				for _, name := range allNames {
+					depDetails := declaredDeps[name]
+					if excluded[name] {
+						if verbose {
+							_, _ = fmt.Fprintf(stderr, "  Skipping: %s (--exclude)\n", name)
+						}
+						continue
+					}
+					if !depDetails.SupportsPlatform(h.os, h.arch) {
+						if verbose {
+							_, _ = fmt.Fprintf(stderr, "  Skipping: %s (not needed on %s/%s)\n", name, h.os, h.arch)
+						}
+						continue
+					}
 					dependenciesToProcessList = append(dependenciesToProcessList, dependencyToProcess{
-						Name:   name,
-						Source: depDetails.Source,
-						Path:   depDetails.Path,
+						Name:        name,
+						Source:      depDetails.Source,
+						Path:        depDetails.Path,
+						ChecksumURL: depDetails.ChecksumURL,
 					})
 					if verbose {
-						_, _ = fmt.Fprintf(os.Stdout, "  Targeting: %s (Source: %s, Path: %s)\n", name, depDetails.Source, depDetails.Path)
+						_, _ = fmt.Fprintf(stderr, "  Targeting: %s (Source: %s, Path: %s)\n", name, depDetails.Source, depDetails.Path)
 					}
 				}
+				if len(dependenciesToProcessList) == 0 && len(excluded) > 0 {
+					_, _ = fmt.Fprintln(report, "No dependencies left to install/update after --exclude.")
+					_, _ = fmt.Fprintln(summaryOut, summary)
+					return nil
+				}
 			} else { // Install/update specific dependencies
 				if verbose {
-					_, _ = fmt.Fprintf(os.Stdout, "Processing %d specified dependencies...\n", len(dependencyNames))
+					_, _ = fmt.Fprintf(stderr, "Processing %d specified dependencies...\n", len(dependencyNames))
 				}
 				for _, name := range dependencyNames {
-					depDetails, ok := projCfg.Dependencies[name]
+					depDetails, group, ok := projCfg.LookupDependency(name)
 					if !ok {
-						_, _ = fmt.Fprintf(os.Stderr, "Warning: Dependency '%s' specified for install/update not found in project.toml. Skipping.\n", name)
+						_, _ = fmt.Fprintf(stderr, "Warning: Dependency '%s' specified for install/update not found in project.toml. Skipping.\n", name)
+						continue
+					}
+					if production && group == project.GroupDev {
+						_, _ = fmt.Fprintf(stderr, "Note: Skipping '%s'; it is a dev dependency and --production is set.\n", name)
+						continue
+					}
+					if !depDetails.SupportsPlatform(h.os, h.arch) {
+						_, _ = fmt.Fprintf(stderr, "Note: Skipping '%s'; it is not needed on %s/%s.\n", name, h.os, h.arch)
 						continue
 					}
 					dependenciesToProcessList = append(dependenciesToProcessList, dependencyToProcess{
-						Name:   name,
-						Source: depDetails.Source,
-						Path:   depDetails.Path,
+						Name:        name,
+						Source:      depDetails.Source,
+						Path:        depDetails.Path,
+						ChecksumURL: depDetails.ChecksumURL,
 					})
 					if verbose {
-						_, _ = fmt.Fprintf(os.Stdout, "  Targeting: %s (Source: %s, Path: %s)\n", name, depDetails.Source, depDetails.Path)
+						_, _ = fmt.Fprintf(stderr, "  Targeting: %s (Source: %s, Path: %s)\n", name, depDetails.Source, depDetails.Path)
 					}
 				}
 				if len(dependenciesToProcessList) == 0 {
-					_, _ = fmt.Fprintln(os.Stdout, "No specified dependencies were found in project.toml to install/update.")
+					_, _ = fmt.Fprintln(report, "No specified dependencies were found in project.toml to install/update.")
+					_, _ = fmt.Fprintln(summaryOut, summary)
 					return nil
 				}
 			}
 
+			// A --source override swaps where the single targeted dependency comes from. It must
+			// provide the same file, which is written to the dependency's existing path.
+			if overrideSource != "" {
+				dep := &dependenciesToProcessList[0]
+				overrideInfo, err := source.ParseSourceURL(overrideSource)
+				if err != nil {
+					return cli.Exit(fmt.Sprintf("Error: Invalid --source '%s': %v", overrideSource, err), 1)
+				}
+				if currentInfo, err := source.ParseSourceURL(dep.Source); err == nil && currentInfo.SuggestedFilename != overrideInfo.SuggestedFilename {
+					return cli.Exit(fmt.Sprintf("Error: --source '%s' provides '%s', but '%s' installs '%s' at %s.", overrideSource, overrideInfo.SuggestedFilename, dep.Name, currentInfo.SuggestedFilename, dep.Path), 1)
+				}
+				if verbose {
+					_, _ = fmt.Fprintf(stderr, "Overriding source for '%s': %s -> %s\n", dep.Name, dep.Source, overrideInfo.CanonicalURL)
+				}
+				dep.Source = overrideInfo.CanonicalURL
+				dep.ChecksumURL = "" // The published checksum belongs to the original source.
+				force = true
+			}
+
+			// A --ref override swaps the ref of a targeted GitHub dependency for this run. The new
+			// commit is locked as usual, but project.toml keeps its source, so the next plain
+			// install goes back to the declared ref.
+			overrideNames := make([]string, 0, len(refOverrides))
+			for name := range refOverrides {
+				overrideNames = append(overrideNames, name)
+			}
+			sort.Strings(overrideNames)
+			for _, name := range overrideNames {
+				ref := refOverrides[name]
+				if _, _, declared := projCfg.LookupDependency(name); !declared {
+					_, _ = fmt.Fprintf(stderr, "Warning: --ref names '%s', which is not in project.toml. Ignoring it.\n", name)
+					continue
+				}
+				var dep *dependencyToProcess
+				for i := range dependenciesToProcessList {
+					if dependenciesToProcessList[i].Name == name {
+						dep = &dependenciesToProcessList[i]
+					}
+				}
+				if dep == nil {
+					_, _ = fmt.Fprintf(stderr, "Warning: --ref names '%s', which is not being installed in this run. Ignoring it.\n", name)
+					continue
+				}
+				info, err := source.ParseSourceURL(dep.Source)
+				if err != nil || info.Provider != "github" {
+					_, _ = fmt.Fprintf(stderr, "Warning: --ref for '%s' needs a GitHub source, but it comes from %s. Ignoring it.\n", name, dep.Source)
+					continue
+				}
+				if verbose {
+					_, _ = fmt.Fprintf(stderr, "Overriding ref for '%s': %s -> %s\n", name, dep.Source, ref)
+				}
+				dep.Source = fmt.Sprintf("github:%s/%s/%s@%s", info.Owner, info.Repo, info.PathInRepo, ref)
+				dep.ChecksumURL = "" // The published checksum belongs to the declared ref.
+			}
+
 			if verbose {
-				_, _ = fmt.Fprintf(os.Stdout, "Total dependencies to process: %d\n", len(dependenciesToProcessList))
+				_, _ = fmt.Fprintf(stderr, "Total dependencies to process: %d\n", len(dependenciesToProcessList))
+			}
+
+			policyHooks := []source.Hook{source.AllowedHostsHook(projCfg.AllowedHosts())}
+			if frozen || offline {
+				names := make([]string, 0, len(dependenciesToProcessList))
+				for _, dep := range dependenciesToProcessList {
+					names = append(names, dep.Name)
+				}
+				opts := frozenOptions{force: force, failFast: failFast, verbose: verbose, link: link, useCache: useCache, offline: offline, store: store, maxSize: maxSize, downloads: downloads, policyHooks: policyHooks}
+				return installFrozen(names, projCfg.AllDependencies(), lf, opts, report, summaryOut, stderr)
 			}
 
 			// --- Task 6.4: Target Version Resolution and Lockfile State Retrieval ---
 			type dependencyInstallState struct {
 				Name              string
-				ProjectTomlSource string // Original source string from project.toml
-				ProjectTomlPath   string // Path from project.toml
-				TargetRawURL      string // Resolved raw URL for download
-				TargetCommitHash  string // Resolved definitive commit hash (or tag/branch if not resolvable to commit)
-				LockedRawURL      string // Raw URL from almd-lock.toml
-				LockedCommitHash  string // Hash from almd-lock.toml (could be commit:<sha> or sha256:<hash>)
+				ProjectTomlSource string                // Original source string from project.toml
+				ProjectTomlPath   string                // Path from project.toml, normalized to forward slashes
+				TargetRawURL      string                // Resolved raw URL for download
+				TargetCommitHash  string                // Resolved definitive commit hash (or tag/branch if not resolvable to commit)
+				SourceRef         string                // Ref as written in project.toml (branch, tag or commit), or the tag a version range resolved to
+				ResolvedTag       string                // Tag picked for a version range in project.toml, if any
+				LockedRawURL      string                // Raw URL from almd-lock.toml
+				LockedPath        string                // Path from almd-lock.toml, normalized to forward slashes
+				LockedCommitHash  string                // Hash from almd-lock.toml (could be commit:<sha> or sha256:<hash>)
+				LockedValidators  downloader.Validators // HTTP cache validators from almd-lock.toml
 				Provider          string
 				Owner             string
 				Repo              string
 				PathInRepo        string
-				NeedsAction       bool   // Flag to indicate if this dependency needs to be installed/updated
-				ActionReason      string // Reason why an action is needed
+				ChecksumURL       string                   // Published checksum file; its hash is the lockfile integrity value
+				Comparison        *source.CommitComparison // Locked commit compared with TargetCommitHash, if fetched
+				NeedsAction       bool                     // Flag to indicate if this dependency needs to be installed/updated
+				LockFromDisk      bool                     // Lock the file already on disk instead of downloading it (--only-missing-lock)
+				Prefetched        []byte                   // Content already downloaded to check for changes, if any
+				Validators        downloader.Validators    // HTTP cache validators sent with Prefetched
+				ActionReason      string                   // Reason why an action is needed
 			}
 			var installStates []dependencyInstallState
 
 			if verbose && len(dependenciesToProcessList) > 0 {
-				_, _ = fmt.Fprintln(os.Stdout, "\nResolving target versions and current lock states...")
+				_, _ = fmt.Fprintln(stderr, "\nResolving target versions and current lock states...")
 			}
 
+			// Parse every source and enforce project-level source policy ([almd] allowed_hosts)
+			// up front, so a rejected source fails the install before any network call is made.
+			parsedSources := make(map[string]*source.ParsedSourceInfo, len(dependenciesToProcessList))
+			for i, depToProcess := range dependenciesToProcessList {
+				parsedSourceInfo, err := source.ParseSourceURL(depToProcess.Source)
+				if err != nil {
+					_, _ = fmt.Fprintf(stderr, "Warning: Could not parse source URL for dependency '%s' (%s): %v. Skipping.\n", depToProcess.Name, depToProcess.Source, err)
+					summary.Failed++
+					continue
+				}
+				if err := source.ApplyHooks(parsedSourceInfo, policyHooks...); err != nil {
+					return cli.Exit(fmt.Sprintf("Error: Source for dependency '%s' (%s) rejected by policy: %v", depToProcess.Name, depToProcess.Source, err), 1)
+				}
+				if depToProcess.ChecksumURL != "" {
+					checksumURL, err := source.CheckURL(depToProcess.ChecksumURL, policyHooks...)
+					if err != nil {
+						return cli.Exit(fmt.Sprintf("Error: Checksum URL for dependency '%s' (%s) rejected by policy: %v", depToProcess.Name, depToProcess.ChecksumURL, err), 1)
+					}
+					dependenciesToProcessList[i].ChecksumURL = checksumURL
+				}
+				parsedSources[depToProcess.Name] = parsedSourceInfo
+			}
+
+			// The newest commit of every ref resolved below is remembered, so that 'almd list' can
+			// hint at updates without asking the API itself.
+			updates, loadErr := updatecache.Load(".")
+			if loadErr != nil {
+				updates = updatecache.New()
+			}
+			recordedUpdates := false
+			resolvedAt := h.now()
 			for _, depToProcess := range dependenciesToProcessList {
+				parsedSourceInfo, ok := parsedSources[depToProcess.Name]
+				if !ok {
+					continue
+				}
 				if verbose {
-					_, _ = fmt.Fprintf(os.Stdout, "Processing dependency: %s (Source: %s)\n", depToProcess.Name, depToProcess.Source)
+					_, _ = fmt.Fprintf(stderr, "Processing dependency: %s (Source: %s)\n", depToProcess.Name, depToProcess.Source)
 				}
 
-				parsedSourceInfo, err := source.ParseSourceURL(depToProcess.Source)
-				if err != nil {
-					_, _ = fmt.Fprintf(os.Stderr, "Warning: Could not parse source URL for dependency '%s' (%s): %v. Skipping.\n", depToProcess.Name, depToProcess.Source, err)
-					continue
+				// A version range is narrowed to the highest matching tag first; the tag is then
+				// resolved to a commit like any other ref.
+				resolvedTag := ""
+				if parsedSourceInfo.VersionRange != "" {
+					resolved, err := ghClient.ResolveVersionRange(parsedSourceInfo)
+					if err != nil {
+						_, _ = fmt.Fprintf(stderr, "Error: Could not resolve version range '%s' for '%s': %v\n", parsedSourceInfo.VersionRange, depToProcess.Name, err)
+						summary.Failed++
+						continue
+					}
+					if verbose {
+						_, _ = fmt.Fprintf(stderr, "  Version range '%s' for '%s' matches tag %s\n", parsedSourceInfo.VersionRange, depToProcess.Name, resolved.Ref)
+					}
+					parsedSourceInfo, resolvedTag = resolved, resolved.Ref
 				}
 
 				var resolvedCommitHash = parsedSourceInfo.Ref // Default to the ref from parsing
 				var finalTargetRawURL = parsedSourceInfo.RawURL
 
-				if parsedSourceInfo.Provider == "github" && !isCommitSHARegex.MatchString(parsedSourceInfo.Ref) {
+				if parsedSourceInfo.Provider == "github" && !source.IsImmutableRef(parsedSourceInfo.Provider, parsedSourceInfo.Ref) {
 					if verbose {
-						_, _ = fmt.Fprintf(os.Stdout, "  Ref '%s' for '%s' is not a full commit SHA. Attempting to resolve latest commit for path '%s'...\n", parsedSourceInfo.Ref, depToProcess.Name, parsedSourceInfo.PathInRepo)
+						_, _ = fmt.Fprintf(stderr, "  Ref '%s' for '%s' is not a full commit SHA. Attempting to resolve latest commit for path '%s'...\n", parsedSourceInfo.Ref, depToProcess.Name, parsedSourceInfo.PathInRepo)
 					}
-					latestSHA, err := source.GetLatestCommitSHAForFile(parsedSourceInfo.Owner, parsedSourceInfo.Repo, parsedSourceInfo.PathInRepo, parsedSourceInfo.Ref)
+					latestSHA, err := ghClient.GetLatestCommitSHAForFile(parsedSourceInfo.Owner, parsedSourceInfo.Repo, parsedSourceInfo.PathInRepo, parsedSourceInfo.Ref)
 					if err != nil {
-						_, _ = fmt.Fprintf(os.Stderr, "  Warning: Could not resolve ref '%s' to a specific commit for '%s': %v. Proceeding with ref as is.\n", parsedSourceInfo.Ref, depToProcess.Name, err)
+						_, _ = fmt.Fprintf(stderr, "  Warning: Could not resolve ref '%s' to a specific commit for '%s': %v. Proceeding with ref as is.\n", parsedSourceInfo.Ref, depToProcess.Name, err)
 					} else {
 						if verbose {
-							_, _ = fmt.Fprintf(os.Stdout, "  Resolved ref '%s' to commit SHA: %s for '%s'\n", parsedSourceInfo.Ref, latestSHA, depToProcess.Name)
+							_, _ = fmt.Fprintf(stderr, "  Resolved ref '%s' to commit SHA: %s for '%s'\n", parsedSourceInfo.Ref, latestSHA, depToProcess.Name)
 						}
 						resolvedCommitHash = latestSHA
-						finalTargetRawURL = strings.Replace(parsedSourceInfo.RawURL, "/"+parsedSourceInfo.Ref+"/", "/"+latestSHA+"/", 1)
+						finalTargetRawURL = parsedSourceInfo.RawURLAt(latestSHA)
+						updates.Record(depToProcess.Name, depToProcess.Source, latestSHA, resolvedAt)
+						recordedUpdates = true
+					}
+				} else if source.IsAbbreviatedCommitSHA(parsedSourceInfo.Provider, parsedSourceInfo.Ref) {
+					// A short SHA is expanded so the lockfile never records an ambiguous commit. One
+					// the lockfile already expanded is reused without asking the API again.
+					fullSHA := strings.TrimPrefix(lf.Package[depToProcess.Name].Hash, "commit:")
+					if len(fullSHA) != 40 || !strings.HasPrefix(fullSHA, parsedSourceInfo.Ref) {
+						var expandErr error
+						if fullSHA, expandErr = ghClient.ExpandCommitSHA(parsedSourceInfo.Owner, parsedSourceInfo.Repo, parsedSourceInfo.Ref); expandErr != nil {
+							_, _ = fmt.Fprintf(stderr, "  Warning: Could not expand short commit SHA '%s' for '%s': %v. Proceeding with it as is.\n", parsedSourceInfo.Ref, depToProcess.Name, expandErr)
+							fullSHA = ""
+						}
+					}
+					if fullSHA != "" {
+						if verbose {
+							_, _ = fmt.Fprintf(stderr, "  Expanded short commit SHA '%s' to %s for '%s'\n", parsedSourceInfo.Ref, fullSHA, depToProcess.Name)
+						}
+						resolvedCommitHash = fullSHA
+						finalTargetRawURL = parsedSourceInfo.RawURLAt(fullSHA)
 					}
 				} else if verbose && parsedSourceInfo.Provider == "github" {
-					_, _ = fmt.Fprintf(os.Stdout, "  Ref '%s' for '%s' appears to be a commit SHA. Using it directly.\n", parsedSourceInfo.Ref, depToProcess.Name)
+					_, _ = fmt.Fprintf(stderr, "  Ref '%s' for '%s' appears to be a commit SHA. Using it directly.\n", parsedSourceInfo.Ref, depToProcess.Name)
 				}
 
 				currentState := dependencyInstallState{
 					Name:              depToProcess.Name,
 					ProjectTomlSource: depToProcess.Source,
-					ProjectTomlPath:   depToProcess.Path,
+					ProjectTomlPath:   project.NormalizePath(depToProcess.Path),
 					TargetRawURL:      finalTargetRawURL,
 					TargetCommitHash:  resolvedCommitHash,
+					SourceRef:         parsedSourceInfo.Ref,
+					ResolvedTag:       resolvedTag,
 					Provider:          parsedSourceInfo.Provider,
 					Owner:             parsedSourceInfo.Owner,
 					Repo:              parsedSourceInfo.Repo,
 					PathInRepo:        parsedSourceInfo.PathInRepo,
+					ChecksumURL:       depToProcess.ChecksumURL,
 				}
 
 				if lockDetails, ok := lf.Package[depToProcess.Name]; ok {
 					currentState.LockedRawURL = lockDetails.Source
+					currentState.LockedPath = project.NormalizePath(lockDetails.Path)
 					currentState.LockedCommitHash = lockDetails.Hash
+					currentState.LockedValidators = downloader.Validators{ETag: lockDetails.ETag, LastModified: lockDetails.LastModified}
 					if verbose {
-						_, _ = fmt.Fprintf(os.Stdout, "  Found in lockfile: Name: %s, Locked Source: %s, Locked Hash: %s\n", depToProcess.Name, lockDetails.Source, lockDetails.Hash)
+						_, _ = fmt.Fprintf(stderr, "  Found in lockfile: Name: %s, Locked Source: %s, Locked Hash: %s\n", depToProcess.Name, lockDetails.Source, lockDetails.Hash)
 					}
 				} else {
 					if verbose {
-						_, _ = fmt.Fprintf(os.Stdout, "  Dependency '%s' not found in lockfile.\n", depToProcess.Name)
+						_, _ = fmt.Fprintf(stderr, "  Dependency '%s' not found in lockfile.\n", depToProcess.Name)
+					}
+				}
+
+				// A locked commit that the branch has moved past should be one of its ancestors. If it
+				// is not, the pin was force-pushed away or taken from another branch, which is worth
+				// flagging before it is silently replaced.
+				lockedSHA := strings.TrimPrefix(currentState.LockedCommitHash, "commit:")
+				if currentState.Provider == "github" && !source.IsImmutableRef(currentState.Provider, currentState.SourceRef) &&
+					strings.HasPrefix(currentState.LockedCommitHash, "commit:") && source.IsImmutableRef(currentState.Provider, resolvedCommitHash) &&
+					lockedSHA != resolvedCommitHash {
+					comparison, err := ghClient.CompareCommits(currentState.Owner, currentState.Repo, lockedSHA, resolvedCommitHash)
+					var apiErr *source.APIError
+					switch {
+					case errors.As(err, &apiErr) && apiErr.StatusCode == http.StatusNotFound:
+						_, _ = fmt.Fprintf(stderr, "Warning: Locked commit %s for '%s' no longer exists in %s/%s; it may have been force-pushed away.\n", shortSHA(lockedSHA), depToProcess.Name, currentState.Owner, currentState.Repo)
+					case err != nil:
+						if verbose {
+							_, _ = fmt.Fprintf(stderr, "  Could not check that %s is on '%s' for '%s': %v\n", shortSHA(lockedSHA), currentState.SourceRef, depToProcess.Name, err)
+						}
+					case comparison.Status != "ahead" && comparison.Status != "identical":
+						_, _ = fmt.Fprintf(stderr, "Warning: Locked commit %s for '%s' is not reachable from branch '%s' (%s); it may have been force-pushed away or taken from another branch.\n", shortSHA(lockedSHA), depToProcess.Name, currentState.SourceRef, comparison.Status)
+						currentState.Comparison = comparison
+					default:
+						currentState.Comparison = comparison
 					}
 				}
 				installStates = append(installStates, currentState)
 			}
+			// --check changes nothing on disk, not even the update cache.
+			if recordedUpdates && !check {
+				if err := updatecache.Save(".", updates); err != nil && verbose {
+					_, _ = fmt.Fprintf(stderr, "  Could not save %s: %v\n", updatecache.FileName, err)
+				}
+			}
 
 			if verbose && len(installStates) > 0 {
-				_, _ = fmt.Fprintln(os.Stdout, "\nFinished resolving versions. States to compare:")
+				_, _ = fmt.Fprintln(stderr, "\nFinished resolving versions. States to compare:")
 				for _, s := range installStates {
-					_, _ = fmt.Fprintf(os.Stdout, "  - Name: %s, TargetCommit: %s, TargetURL: %s, LockedHash: %s, LockedURL: %s\n", s.Name, s.TargetCommitHash, s.TargetRawURL, s.LockedCommitHash, s.LockedRawURL)
+					_, _ = fmt.Fprintf(stderr, "  - Name: %s, TargetCommit: %s, TargetURL: %s, LockedHash: %s, LockedURL: %s\n", s.Name, s.TargetCommitHash, s.TargetRawURL, s.LockedCommitHash, s.LockedRawURL)
 				}
 			}
 
@@ -229,19 +696,41 @@ func NewInstallCommand() *cli.Command {
 			var dependenciesThatNeedAction []dependencyInstallState
 
 			if verbose && len(installStates) > 0 {
-				_, _ = fmt.Fprintln(os.Stdout, "\nDetermining which dependencies need install/update...")
+				_, _ = fmt.Fprintln(stderr, "\nDetermining which dependencies need install/update...")
 			}
 
+			lockMetadataChanged := false
 			for i, state := range installStates {
 				reason := ""
 				needsAction := false
 
+				// A path edited in project.toml wins over the one in almd-lock.toml: the installed
+				// file is moved there and the lockfile entry follows it.
+				if state.LockedPath != "" && state.LockedPath != state.ProjectTomlPath {
+					if check {
+						needsAction = true
+						reason = fmt.Sprintf("project.toml installs it at %s but almd-lock.toml at %s.", state.ProjectTomlPath, state.LockedPath)
+					} else {
+						moved, err := moveInstalledFile(state.LockedPath, state.ProjectTomlPath)
+						switch {
+						case err != nil:
+							_, _ = fmt.Fprintf(stderr, "Warning: Could not move %s to %s for '%s': %v\n", state.LockedPath, state.ProjectTomlPath, state.Name, err)
+						case moved:
+							_, _ = fmt.Fprintf(stderr, "Note: Moved '%s' from %s to %s to match project.toml.\n", state.Name, state.LockedPath, state.ProjectTomlPath)
+						default:
+							_, _ = fmt.Fprintf(stderr, "Note: '%s' is now installed at %s to match project.toml; almd-lock.toml had %s.\n", state.Name, state.ProjectTomlPath, state.LockedPath)
+						}
+						lf.SetPath(state.Name, state.ProjectTomlPath)
+						lockMetadataChanged = true
+					}
+				}
+
 				// 1. --force flag
 				if force {
 					needsAction = true
 					reason = "Install/Update forced by user (--force)."
 					if verbose {
-						_, _ = fmt.Fprintf(os.Stdout, "  - %s: Needs install/update (forced).\n", state.Name)
+						_, _ = fmt.Fprintf(stderr, "  - %s: Needs install/update (forced).\n", state.Name)
 					}
 				}
 
@@ -250,20 +739,20 @@ func NewInstallCommand() *cli.Command {
 					needsAction = true
 					reason = "Dependency present in project.toml but not in almd-lock.toml."
 					if verbose {
-						_, _ = fmt.Fprintf(os.Stdout, "  - %s: Needs install/update (not in lockfile).\n", state.Name)
+						_, _ = fmt.Fprintf(stderr, "  - %s: Needs install/update (not in lockfile).\n", state.Name)
 					}
 				}
 
 				// 3. Local file at path is missing
 				if !needsAction {
-					if _, err := os.Stat(state.ProjectTomlPath); errors.Is(err, os.ErrNotExist) {
+					if _, err := os.Stat(project.NativePath(state.ProjectTomlPath)); errors.Is(err, os.ErrNotExist) {
 						needsAction = true
 						reason = fmt.Sprintf("Local file missing at path: %s.", state.ProjectTomlPath)
 						if verbose {
-							_, _ = fmt.Fprintf(os.Stdout, "  - %s: Needs install/update (file missing at %s).\n", state.Name, state.ProjectTomlPath)
+							_, _ = fmt.Fprintf(stderr, "  - %s: Needs install/update (file missing at %s).\n", state.Name, state.ProjectTomlPath)
 						}
 					} else if err != nil {
-						_, _ = fmt.Fprintf(os.Stderr, "Warning: Could not stat file for dependency '%s' at '%s': %v. Assuming install/update check is needed.\n", state.Name, state.ProjectTomlPath, err)
+						_, _ = fmt.Fprintf(stderr, "Warning: Could not stat file for dependency '%s' at '%s': %v. Assuming install/update check is needed.\n", state.Name, state.ProjectTomlPath, err)
 						needsAction = true
 						reason = fmt.Sprintf("Error checking local file status at %s: %v.", state.ProjectTomlPath, err)
 					}
@@ -280,13 +769,61 @@ func NewInstallCommand() *cli.Command {
 						needsAction = true
 						reason = fmt.Sprintf("Target commit hash (%s) differs from locked commit hash (%s).", state.TargetCommitHash, lockedSHA)
 						if verbose {
-							_, _ = fmt.Fprintf(os.Stdout, "  - %s: Needs install/update (target commit %s != locked commit %s).\n", state.Name, state.TargetCommitHash, lockedSHA)
+							_, _ = fmt.Fprintf(stderr, "  - %s: Needs install/update (target commit %s != locked commit %s).\n", state.Name, state.TargetCommitHash, lockedSHA)
 						}
-					} else if lockedSHA == "" && strings.HasPrefix(state.LockedCommitHash, "sha256:") && isCommitSHARegex.MatchString(state.TargetCommitHash) {
+					} else if lockedSHA == "" && state.ChecksumURL == "" && isContentHash(state.LockedCommitHash) && source.IsImmutableRef(state.Provider, state.TargetCommitHash) {
 						needsAction = true
 						reason = fmt.Sprintf("Target is now a specific commit (%s), but lockfile has a content hash (%s).", state.TargetCommitHash, state.LockedCommitHash)
 						if verbose {
-							_, _ = fmt.Fprintf(os.Stdout, "  - %s: Needs install/update (target is specific commit %s, lockfile has content hash %s).\n", state.Name, state.TargetCommitHash, state.LockedCommitHash)
+							_, _ = fmt.Fprintf(stderr, "  - %s: Needs install/update (target is specific commit %s, lockfile has content hash %s).\n", state.Name, state.TargetCommitHash, state.LockedCommitHash)
+						}
+					}
+				}
+
+				// A plain HTTP source or a release asset has no commit to compare, so its current
+				// content is fetched and compared with the locked hash instead. The request is
+				// conditional on the locked validators, so an unchanged file is confirmed without
+				// being downloaded.
+				// --check downloads nothing, so there a locked HTTP source counts as in sync.
+				if !needsAction && state.Provider != "github" && !check {
+					since := state.LockedValidators
+					if state.LockedRawURL != state.TargetRawURL {
+						since = downloader.Validators{}
+					}
+					content, validators, err := downloads.DownloadFileIfModified(state.TargetRawURL, maxSize, since)
+					switch {
+					case errors.Is(err, downloader.ErrNotModified):
+						if verbose {
+							_, _ = fmt.Fprintf(stderr, "  - %s: %s reports the file unchanged (304 Not Modified).\n", state.Name, state.TargetRawURL)
+						}
+					case err != nil:
+						needsAction = true
+						reason = fmt.Sprintf("Could not download %s to check for changes: %v.", state.TargetRawURL, err)
+					default:
+						if contentHash, err := hasher.Calculate(content, hasher.AlgorithmFor(state.LockedCommitHash)); err == nil && contentHash != state.LockedCommitHash {
+							needsAction = true
+							reason = fmt.Sprintf("Content at %s changed (%s -> %s).", state.TargetRawURL, state.LockedCommitHash, contentHash)
+							installStates[i].Prefetched = content
+							installStates[i].Validators = validators
+						} else if validators != state.LockedValidators && state.LockedRawURL == state.TargetRawURL {
+							// Same content: remember the validators so the next check can be conditional.
+							lf.SetValidators(state.Name, validators.ETag, validators.LastModified)
+							lockMetadataChanged = true
+						}
+					}
+					if needsAction && verbose {
+						_, _ = fmt.Fprintf(stderr, "  - %s: Needs install/update (%s)\n", state.Name, reason)
+					}
+				}
+
+				// --only-missing-lock leaves files that are already on disk alone: an unlocked one is
+				// locked from its current content and a locked one is kept as is.
+				if onlyMissingLock {
+					if fi, err := os.Stat(project.NativePath(state.ProjectTomlPath)); err == nil && fi.Mode().IsRegular() {
+						needsAction = state.LockedCommitHash == ""
+						if needsAction {
+							installStates[i].LockFromDisk = true
+							reason = "Present on disk but not in almd-lock.toml; locking the existing file (--only-missing-lock)."
 						}
 					}
 				}
@@ -295,101 +832,592 @@ func NewInstallCommand() *cli.Command {
 					installStates[i].NeedsAction = true
 					installStates[i].ActionReason = reason
 					dependenciesThatNeedAction = append(dependenciesThatNeedAction, installStates[i])
-				} else if verbose {
-					_, _ = fmt.Fprintf(os.Stdout, "  - %s: Already up-to-date.\n", state.Name)
+				} else {
+					summary.Unchanged++
+					if verbose {
+						_, _ = fmt.Fprintf(stderr, "  - %s: Already up-to-date.\n", state.Name)
+					}
+					// A newer tag may point at the locked commit; record it without reinstalling.
+					if state.ResolvedTag != "" && lf.Package[state.Name].Tag != state.ResolvedTag {
+						lf.SetTag(state.Name, state.ResolvedTag)
+						lockMetadataChanged = true
+					}
+					// The dependency may have moved between [dependencies] and [dev-dependencies].
+					if group := groupOf(state.Name); lf.Package[state.Name].Group != group {
+						lf.SetGroup(state.Name, group)
+						lockMetadataChanged = true
+					}
+				}
+			}
+
+			if check {
+				// One line per dependency that is out of sync, so CI logs stay short.
+				for _, dep := range dependenciesThatNeedAction {
+					_, _ = fmt.Fprintf(stdout, "%s: %s\n", dep.Name, dep.ActionReason)
+				}
+				if len(dependenciesThatNeedAction) > 0 || summary.Failed > 0 {
+					return cli.Exit(fmt.Sprintf("Error: %d dependenc(ies) need install/update and %d could not be checked; run 'almd install'.", len(dependenciesThatNeedAction), summary.Failed), 1)
 				}
+				_, _ = fmt.Fprintf(stdout, "All %d targeted dependencies match %s.\n", len(installStates), lockfile.LockfileName)
+				return nil
 			}
 
 			if len(dependenciesThatNeedAction) == 0 {
-				_, _ = fmt.Fprintln(os.Stdout, "All targeted dependencies are already up-to-date.")
+				_, _ = fmt.Fprintln(report, "All targeted dependencies are already up-to-date.")
+				if lockMetadataChanged || lf.Migrated() {
+					if err := lockfile.Save(".", lf); err != nil {
+						return cli.Exit(fmt.Sprintf("Error: Failed to save updated almd-lock.toml: %v", err), 1)
+					}
+				}
+				_, _ = fmt.Fprintln(summaryOut, summary)
 				return nil
 			}
 
 			if verbose {
-				_, _ = fmt.Fprintf(os.Stdout, "\nDependencies to be installed/updated (%d):\n", len(dependenciesThatNeedAction))
+				_, _ = fmt.Fprintf(stderr, "\nDependencies to be installed/updated (%d):\n", len(dependenciesThatNeedAction))
 				for _, dep := range dependenciesThatNeedAction {
-					_, _ = fmt.Fprintf(os.Stdout, "  - %s (Reason: %s)\n", dep.Name, dep.ActionReason)
+					_, _ = fmt.Fprintf(stderr, "  - %s (Reason: %s)\n", dep.Name, dep.ActionReason)
+				}
+			}
+
+			// Guard against silently pulling in a large batch of upstream changes: when a branch pin
+			// would move further than the configured number of commits, ask before applying it.
+			maxCommitJump := defaultMaxCommitJump
+			if projCfg.Almd != nil && projCfg.Almd.MaxCommitJump != nil {
+				maxCommitJump = *projCfg.Almd.MaxCommitJump
+			}
+			if c.IsSet("max-commit-jump") {
+				maxCommitJump = c.Int("max-commit-jump")
+			}
+			if maxCommitJump > 0 && !c.Bool("yes") {
+				var confirmed []dependencyInstallState
+				for _, dep := range dependenciesThatNeedAction {
+					lockedSHA := strings.TrimPrefix(dep.LockedCommitHash, "commit:")
+					if dep.Provider != "github" || source.IsImmutableRef(dep.Provider, dep.SourceRef) ||
+						!strings.HasPrefix(dep.LockedCommitHash, "commit:") || !source.IsImmutableRef(dep.Provider, dep.TargetCommitHash) ||
+						lockedSHA == dep.TargetCommitHash {
+						confirmed = append(confirmed, dep)
+						continue
+					}
+					comparison := dep.Comparison
+					var err error
+					if comparison == nil {
+						comparison, err = ghClient.CompareCommits(dep.Owner, dep.Repo, lockedSHA, dep.TargetCommitHash)
+					}
+					if err != nil {
+						if verbose {
+							_, _ = fmt.Fprintf(stderr, "  Could not compare %s..%s for '%s': %v. Proceeding without confirmation.\n", lockedSHA, dep.TargetCommitHash, dep.Name, err)
+						}
+						confirmed = append(confirmed, dep)
+						continue
+					}
+					if comparison.AheadBy <= maxCommitJump {
+						confirmed = append(confirmed, dep)
+						continue
+					}
+					question := fmt.Sprintf("Updating '%s' (%s) moves %d commits ahead (%s -> %s). Continue?", dep.Name, dep.SourceRef, comparison.AheadBy, shortSHA(lockedSHA), shortSHA(dep.TargetCommitHash))
+					if prompt.Confirm(c.App.Reader, stderr, question) {
+						confirmed = append(confirmed, dep)
+						continue
+					}
+					_, _ = fmt.Fprintf(stderr, "Skipping update of '%s'; it remains at %s. Re-run with --yes to accept.\n", dep.Name, shortSHA(lockedSHA))
+					summary.Unchanged++
+				}
+				dependenciesThatNeedAction = confirmed
+				if len(dependenciesThatNeedAction) == 0 {
+					_, _ = fmt.Fprintln(report, "No dependencies were installed/updated.")
+					_, _ = fmt.Fprintln(summaryOut, summary)
+					return nil
 				}
 			}
 
 			// --- Task 6.6: Perform Install/Update (If Required) ---
 			if verbose && len(dependenciesThatNeedAction) > 0 {
-				_, _ = fmt.Fprintln(os.Stdout, "\nPerforming install/update for identified dependencies...")
+				_, _ = fmt.Fprintln(stderr, "\nPerforming install/update for identified dependencies...")
 			}
 
-			var successfulActions int
-			for _, dep := range dependenciesThatNeedAction {
-				if verbose {
-					_, _ = fmt.Fprintf(os.Stdout, "  Installing/Updating '%s' from %s\n", dep.Name, dep.TargetRawURL)
+			var successfulActions, notAttempted int
+			failuresBeforePerform := summary.Failed
+			for i, dep := range dependenciesThatNeedAction {
+				// Entries installed before the failure are still saved to the lockfile below.
+				if failFast && summary.Failed > failuresBeforePerform {
+					notAttempted = len(dependenciesThatNeedAction) - i
+					break
+				}
+				if dep.LockFromDisk {
+					integrityHash, err := hashFileOnDisk(downloads, dep.ProjectTomlPath, dep.ChecksumURL, dep.PathInRepo)
+					if err != nil {
+						_, _ = fmt.Fprintf(stderr, "Error: Could not lock existing file for dependency '%s': %v\n", dep.Name, err)
+						summary.Failed++
+						continue
+					}
+					summary.Added++
+					lf.AddOrUpdatePackage(dep.Name, dep.TargetRawURL, dep.ProjectTomlPath, integrityHash)
+					lf.SetTag(dep.Name, dep.ResolvedTag)
+					lf.SetGroup(dep.Name, groupOf(dep.Name))
+					if verbose {
+						_, _ = fmt.Fprintf(stderr, "  Locked existing file %s for '%s' with hash %s (not downloaded).\n", dep.ProjectTomlPath, dep.Name, integrityHash)
+					}
+					successfulActions++
+					continue
+				}
+				// Content already cached for a commit-pinned file is reused without a download. Load
+				// checks the cached bytes still hash to the recorded value before they are trusted.
+				var fileContent []byte
+				var err error
+				fromCache := false
+				if key := cacheKey(dep.Provider, dep.Owner, dep.Repo, dep.TargetCommitHash, dep.PathInRepo); useCache && key != "" {
+					if hash, ok := store.Lookup(key); ok {
+						if blobPath, loadErr := store.Load(hash); loadErr == nil {
+							if content, readErr := os.ReadFile(blobPath); readErr == nil {
+								fileContent, fromCache = content, true
+								if verbose {
+									_, _ = fmt.Fprintf(stderr, "  Using cached content for '%s' (%s)\n", dep.Name, hash)
+								}
+							}
+						} else if verbose {
+							_, _ = fmt.Fprintf(stderr, "  Ignoring cached content for '%s': %v\n", dep.Name, loadErr)
+						}
+					}
+				}
+				// Validators for the written file: those of the download, or the locked ones while
+				// the source URL is unchanged.
+				var validators downloader.Validators
+				if dep.LockedRawURL == dep.TargetRawURL {
+					validators = dep.LockedValidators
+				}
+				if dep.Prefetched != nil {
+					fileContent, validators = dep.Prefetched, dep.Validators
+				} else if !fromCache {
+					if verbose {
+						_, _ = fmt.Fprintf(stderr, "  Installing/Updating '%s' from %s\n", dep.Name, dep.TargetRawURL)
+					}
+					// The file on disk can stand in for the download if it is still the locked
+					// content and the server confirms it has not changed.
+					var onDisk []byte
+					if !validators.IsZero() {
+						if expected, ok := lf.Package[dep.Name].ExpectedContentHash(); ok {
+							if content, readErr := os.ReadFile(project.NativePath(dep.ProjectTomlPath)); readErr == nil {
+								if match, hashErr := hasher.Matches(content, expected); hashErr == nil && match {
+									onDisk = content
+								}
+							}
+						}
+					}
+					if onDisk == nil {
+						fileContent, validators, err = downloads.DownloadFileIfModified(dep.TargetRawURL, maxSize, downloader.Validators{})
+					} else if fileContent, validators, err = downloads.DownloadFileIfModified(dep.TargetRawURL, maxSize, validators); errors.Is(err, downloader.ErrNotModified) {
+						fileContent, err = onDisk, nil
+						if verbose {
+							_, _ = fmt.Fprintf(stderr, "    %s reports the file unchanged (304 Not Modified); reusing %s\n", dep.TargetRawURL, dep.ProjectTomlPath)
+						}
+					}
+				}
+				var statusErr *downloader.StatusError
+				if errors.As(err, &statusErr) && statusErr.StatusCode == http.StatusNotFound && dep.Provider == "github" {
+					// The file may have moved upstream; try each matching --remap prefix in turn.
+					if newSource := remapSource(dep.Owner, dep.Repo, dep.PathInRepo, dep.SourceRef, remaps); newSource != "" {
+						if info, parseErr := source.ParseSourceURL(newSource); parseErr == nil {
+							rawURL, commitHash := info.RawURL, info.Ref
+							if !source.IsImmutableRef(info.Provider, info.Ref) {
+								if sha, resolveErr := ghClient.GetLatestCommitSHAForFile(info.Owner, info.Repo, info.PathInRepo, info.Ref); resolveErr == nil {
+									rawURL = info.RawURLAt(sha)
+									commitHash = sha
+								}
+							}
+							if content, retryErr := downloads.DownloadFileWithLimit(rawURL, maxSize); retryErr == nil {
+								_, _ = fmt.Fprintf(stderr, "Note: '%s' was not found at %s upstream; installed it from %s instead and updated its source in project.toml.\n", dep.Name, dep.PathInRepo, info.PathInRepo)
+								fileContent, err, validators = content, nil, downloader.Validators{}
+								dep.TargetRawURL, dep.TargetCommitHash, dep.PathInRepo = rawURL, commitHash, info.PathInRepo
+								remappedSources[dep.Name] = newSource
+							} else if verbose {
+								_, _ = fmt.Fprintf(stderr, "    Remapped path %s for '%s' also failed: %v\n", info.PathInRepo, dep.Name, retryErr)
+							}
+						}
+					}
 				}
-
-				fileContent, err := downloader.DownloadFile(dep.TargetRawURL)
 				if err != nil {
-					_, _ = fmt.Fprintf(os.Stderr, "Error: Failed to download dependency '%s' from '%s': %v\n", dep.Name, dep.TargetRawURL, err)
+					_, _ = fmt.Fprintf(stderr, "Error: Failed to download dependency '%s' from '%s': %v\n", dep.Name, dep.TargetRawURL, err)
+					summary.Failed++
 					continue
 				}
-				if verbose {
-					_, _ = fmt.Fprintf(os.Stdout, "    Successfully downloaded %s (%d bytes)\n", dep.Name, len(fileContent))
+				if verbose && !fromCache {
+					_, _ = fmt.Fprintf(stderr, "    Successfully downloaded %s (%d bytes)\n", dep.Name, len(fileContent))
+				}
+
+				// A locked content hash keeps its algorithm, so old lockfiles stay comparable
+				// when the default changes.
+				lockedContentHash, _ := lf.Package[dep.Name].ExpectedContentHash()
+				contentHash, err := hasher.Calculate(fileContent, hasher.AlgorithmFor(lockedContentHash))
+				if err != nil {
+					_, _ = fmt.Fprintf(stderr, "Error: Failed to calculate content hash for dependency '%s': %v\n", dep.Name, err)
+					summary.Failed++
+					continue
 				}
 
 				var integrityHash string
-				if dep.Provider == "github" && isCommitSHARegex.MatchString(dep.TargetCommitHash) {
+				if dep.ChecksumURL != "" {
+					publishedHash, err := downloads.FetchChecksum(dep.ChecksumURL, path.Base(dep.PathInRepo))
+					if err != nil {
+						_, _ = fmt.Fprintf(stderr, "Error: Failed to fetch checksum for dependency '%s' from '%s': %v\n", dep.Name, dep.ChecksumURL, err)
+						summary.Failed++
+						continue
+					}
+					if downloadHash, _ := hasher.Calculate(fileContent, hasher.AlgorithmFor(publishedHash)); downloadHash != publishedHash {
+						_, _ = fmt.Fprintf(stderr, "Error: Checksum mismatch for dependency '%s': %s publishes %s, but the download hashes to %s. Skipping.\n", dep.Name, dep.ChecksumURL, publishedHash, downloadHash)
+						summary.Failed++
+						continue
+					}
+					integrityHash, contentHash = publishedHash, publishedHash
+					if verbose {
+						_, _ = fmt.Fprintf(stderr, "    Verified against published checksum: %s\n", integrityHash)
+					}
+				} else if dep.Provider == "github" && source.IsImmutableRef(dep.Provider, dep.TargetCommitHash) {
 					integrityHash = "commit:" + dep.TargetCommitHash
 					if verbose {
-						_, _ = fmt.Fprintf(os.Stdout, "    Using commit hash for integrity: %s\n", integrityHash)
+						_, _ = fmt.Fprintf(stderr, "    Using commit hash for integrity: %s\n", integrityHash)
 					}
 				} else {
-					contentHash, err := hasher.CalculateSHA256(fileContent)
-					if err != nil {
-						_, _ = fmt.Fprintf(os.Stderr, "Error: Failed to calculate SHA256 hash for dependency '%s': %v\n", dep.Name, err)
-						continue
-					}
 					integrityHash = contentHash
 					if verbose {
-						_, _ = fmt.Fprintf(os.Stdout, "    Calculated content hash for integrity: %s\n", integrityHash)
+						_, _ = fmt.Fprintf(stderr, "    Calculated content hash for integrity: %s\n", integrityHash)
 					}
 				}
 
-				targetDir := filepath.Dir(dep.ProjectTomlPath)
+				// Re-downloading a locked commit must reproduce the locked bytes.
+				if err := lf.Package[dep.Name].CheckContent(integrityHash, contentHash); err != nil {
+					_, _ = fmt.Fprintf(stderr, "Error: Refusing to install dependency '%s' from '%s': %v. Nothing was written.\n", dep.Name, dep.TargetRawURL, err)
+					summary.Failed++
+					continue
+				}
+
+				// Stored paths use forward slashes; convert before touching the filesystem.
+				nativePath := project.NativePath(dep.ProjectTomlPath)
+				targetDir := filepath.Dir(nativePath)
 				if err := os.MkdirAll(targetDir, os.ModePerm); err != nil {
-					_, _ = fmt.Fprintf(os.Stderr, "Error: Failed to create directory '%s' for dependency '%s': %v\n", targetDir, dep.Name, err)
+					_, _ = fmt.Fprintf(stderr, "Error: Failed to create directory '%s' for dependency '%s': %v\n", targetDir, dep.Name, err)
+					summary.Failed++
 					continue
 				}
-				if err := os.WriteFile(dep.ProjectTomlPath, fileContent, 0644); err != nil {
-					_, _ = fmt.Fprintf(os.Stderr, "Error: Failed to write file '%s' for dependency '%s': %v\n", dep.ProjectTomlPath, dep.Name, err)
+				if link {
+					if err := linkFromCache(store, fileContent, nativePath, cacheKey(dep.Provider, dep.Owner, dep.Repo, dep.TargetCommitHash, dep.PathInRepo), stderr, verbose); err != nil {
+						_, _ = fmt.Fprintf(stderr, "Error: Failed to link file '%s' for dependency '%s' from the content cache: %v\n", dep.ProjectTomlPath, dep.Name, err)
+						summary.Failed++
+						continue
+					}
+				} else if err := fsutil.WriteFileAtomic(nativePath, fileContent, 0644); err != nil {
+					_, _ = fmt.Fprintf(stderr, "Error: Failed to write file '%s' for dependency '%s': %v\n", dep.ProjectTomlPath, dep.Name, err)
+					summary.Failed++
 					continue
+				} else if key := cacheKey(dep.Provider, dep.Owner, dep.Repo, dep.TargetCommitHash, dep.PathInRepo); useCache && !fromCache && key != "" {
+					storeInCache(store, fileContent, key, stderr, verbose)
 				}
 				if verbose {
-					_, _ = fmt.Fprintf(os.Stdout, "    Successfully saved %s to %s\n", dep.Name, dep.ProjectTomlPath)
+					_, _ = fmt.Fprintf(stderr, "    Successfully saved %s to %s\n", dep.Name, dep.ProjectTomlPath)
 				}
 
-				lf.Package[dep.Name] = lockfile.PackageEntry{
-					Source: dep.TargetRawURL,
-					Path:   dep.ProjectTomlPath,
-					Hash:   integrityHash,
+				// An unchanged integrity hash means the same version was written again (forced or missing file).
+				switch dep.LockedCommitHash {
+				case "":
+					summary.Added++
+				case integrityHash:
+					summary.Reinstalled++
+				default:
+					summary.Updated++
 				}
+				hadBlob := lf.Package[dep.Name].Blob != ""
+				lf.AddOrUpdatePackage(dep.Name, dep.TargetRawURL, dep.ProjectTomlPath, integrityHash)
+				lf.SetContentHash(dep.Name, contentHash)
+				lf.SetValidators(dep.Name, validators.ETag, validators.LastModified)
+				// Files added with --recursive keep a blob SHA, which the new content determines.
+				if hadBlob {
+					lf.SetBlob(dep.Name, hasher.GitBlobSHA(fileContent))
+				}
+				lf.SetTag(dep.Name, dep.ResolvedTag)
+				lf.SetGroup(dep.Name, groupOf(dep.Name))
 				if verbose {
-					_, _ = fmt.Fprintf(os.Stdout, "    Updated lockfile entry for %s: Path=%s, Hash=%s, SourceURL=%s\n", dep.Name, dep.ProjectTomlPath, integrityHash, dep.TargetRawURL)
+					_, _ = fmt.Fprintf(stderr, "    Updated lockfile entry for %s: Path=%s, Hash=%s, SourceURL=%s\n", dep.Name, dep.ProjectTomlPath, integrityHash, dep.TargetRawURL)
 				}
 				successfulActions++
 			}
 
+			if notAttempted > 0 {
+				_, _ = fmt.Fprintf(stderr, "Stopped at the first failure (--fail-fast); %d dependenc(ies) were not attempted.\n", notAttempted)
+			}
+
 			if successfulActions > 0 {
 				lf.ApiVersion = lockfile.APIVersion
 				if err := lockfile.Save(".", lf); err != nil {
 					return cli.Exit(fmt.Sprintf("Error: Failed to save updated almd-lock.toml: %v", err), 1)
 				}
 				if verbose {
-					_, _ = fmt.Fprintf(os.Stdout, "\nSuccessfully saved almd-lock.toml with %d action(s).\n", successfulActions)
+					_, _ = fmt.Fprintf(stderr, "\nSuccessfully saved almd-lock.toml with %d action(s).\n", successfulActions)
+				}
+				_, _ = fmt.Fprintf(report, "Successfully installed/updated %d dependenc(ies).\n", successfulActions)
+				if len(remappedSources) > 0 {
+					for name, newSource := range remappedSources {
+						dep, group, _ := projCfg.LookupDependency(name)
+						dep.Source = newSource
+						projCfg.SetDependency(name, group, dep)
+					}
+					// A one-off --source override is not written to project.toml unless --save is given.
+					if overrideSource == "" || c.Bool("save") {
+						if err := config.WriteProjectToml(".", projCfg); err != nil {
+							return cli.Exit(fmt.Sprintf("Error: Failed to record remapped sources in project.toml: %v", err), 1)
+						}
+					}
+				}
+				if overrideSource != "" {
+					name := dependenciesToProcessList[0].Name
+					if c.Bool("save") {
+						dep, group, _ := projCfg.LookupDependency(name)
+						dep.Source = dependenciesToProcessList[0].Source
+						dep.ChecksumURL = ""
+						projCfg.SetDependency(name, group, dep)
+						if err := config.WriteProjectToml(".", projCfg); err != nil {
+							return cli.Exit(fmt.Sprintf("Error: Installed '%s' but failed to save its source to project.toml: %v", name, err), 1)
+						}
+					} else {
+						_, _ = fmt.Fprintf(stderr, "Note: '%s' was installed from %s; project.toml still points at %s. Use --save to keep the new source.\n", name, dependenciesToProcessList[0].Source, projCfg.AllDependencies()[name].Source)
+					}
+				}
+				_, _ = fmt.Fprintln(summaryOut, summary)
+				if notAttempted > 0 {
+					return cli.Exit("Error: Install stopped at the first failure (--fail-fast).", 1)
 				}
-				_, _ = fmt.Fprintf(os.Stdout, "Successfully installed/updated %d dependenc(ies).\n", successfulActions)
 			} else {
 				if len(dependenciesThatNeedAction) > 0 {
-					_, _ = fmt.Fprintln(os.Stderr, "No dependencies were successfully installed/updated due to errors.")
+					_, _ = fmt.Fprintln(summaryOut, summary)
+					_, _ = fmt.Fprintln(stderr, "No dependencies were successfully installed/updated due to errors.")
 					return cli.Exit("Install/Update process completed with errors for all targeted dependencies.", 1)
 				}
 			}
 			return nil
 		},
 	}
+
+	// Regenerate the requires file after every successful install, including runs where
+	// nothing needed downloading, so that it always matches [dependencies] in project.toml.
+	// --check writes nothing, so it leaves the file alone.
+	install := cmd.Action
+	cmd.Action = func(c *cli.Context) error {
+		if err := install(c); err != nil {
+			return err
+		}
+		if c.Bool("check") {
+			return nil
+		}
+		return generateRequires(c)
+	}
+	return cmd
+}
+
+// generateRequires writes the requires file named by --generate-requires or, failing that,
+// by [almd] generate_requires. It does nothing when neither is set. The file lists the
+// production dependencies only, including those skipped on this platform, so that it is the
+// same on every machine; dev-dependencies are left out.
+func generateRequires(c *cli.Context) error {
+	projCfg, err := config.LoadProjectToml(".")
+	if err != nil {
+		return cli.Exit(fmt.Sprintf("Error loading project.toml: %v", err), 1)
+	}
+	outputPath, templatePath := projCfg.RequiresSettings()
+	if c.IsSet("generate-requires") {
+		outputPath = c.String("generate-requires")
+	}
+	if outputPath == "" {
+		return nil
+	}
+	if err := requires.Generate(".", outputPath, templatePath, projCfg.Dependencies); err != nil {
+		return cli.Exit(fmt.Sprintf("Error: Failed to generate %s: %v", outputPath, err), 1)
+	}
+	if c.Bool("verbose") {
+		_, _ = fmt.Fprintf(c.App.ErrWriter, "Generated %s with %d dependenc(ies).\n", outputPath, len(projCfg.Dependencies))
+	}
+	return nil
+}
+
+// cacheKey returns the content cache key for a commit-pinned file, or "" when the file is not
+// pinned to a commit and so may change under the same name.
+func cacheKey(provider, owner, repo, ref, pathInRepo string) string {
+	if !source.IsImmutableRef(provider, ref) {
+		return ""
+	}
+	return contentcache.CommitKey(provider, owner, repo, ref, pathInRepo)
+}
+
+// storeInCache adds downloaded content to the content cache under key. Failures only cost a
+// download next time, so they are reported in verbose mode and otherwise ignored.
+func storeInCache(store contentcache.Store, content []byte, key string, stderr io.Writer, verbose bool) {
+	hash, _, err := store.Put(content)
+	if err == nil {
+		err = store.Record(key, hash)
+	}
+	if err != nil && verbose {
+		_, _ = fmt.Fprintf(stderr, "    Could not add %s to the content cache: %v\n", key, err)
+	}
+}
+
+// linkFromCache stores content in the content cache and links the cached blob to target,
+// recording key in the cache index unless it is empty. The file at target is re-hashed
+// afterwards to confirm it holds exactly content.
+func linkFromCache(store contentcache.Store, content []byte, target, key string, stderr io.Writer, verbose bool) error {
+	hash, blobPath, err := store.Put(content)
+	if err != nil {
+		return err
+	}
+	if key != "" {
+		if err := store.Record(key, hash); err != nil && verbose {
+			_, _ = fmt.Fprintf(stderr, "    Could not index %s in the content cache: %v\n", key, err)
+		}
+	}
+	linked, err := contentcache.Link(blobPath, target)
+	if err != nil {
+		return err
+	}
+	written, err := os.ReadFile(target)
+	if err != nil {
+		return err
+	}
+	if writtenHash, err := hasher.CalculateSHA256(written); err != nil || writtenHash != hash {
+		return fmt.Errorf("%s does not match cached content %s after linking", target, hash)
+	}
+	if verbose {
+		if linked {
+			_, _ = fmt.Fprintf(stderr, "    Hardlinked %s to cached %s\n", target, blobPath)
+		} else {
+			_, _ = fmt.Fprintf(stderr, "    Copied cached %s to %s (hardlinks unavailable)\n", blobPath, target)
+		}
+	}
+	return nil
+}
+
+// pathRemap maps an upstream path prefix to the prefix it moved to (--remap OLD=NEW).
+type pathRemap struct {
+	Old, New string
+}
+
+// parseRemaps parses --remap values of the form OLD=NEW. Surrounding slashes are ignored.
+func parseRemaps(values []string) ([]pathRemap, error) {
+	remaps := make([]pathRemap, 0, len(values))
+	for _, value := range values {
+		oldPrefix, newPrefix, ok := strings.Cut(value, "=")
+		oldPrefix, newPrefix = strings.Trim(oldPrefix, "/"), strings.Trim(newPrefix, "/")
+		if !ok || oldPrefix == "" || newPrefix == "" {
+			return nil, fmt.Errorf("'%s' must have the form OLD=NEW, e.g. src=lua/src", value)
+		}
+		remaps = append(remaps, pathRemap{Old: oldPrefix, New: newPrefix})
+	}
+	return remaps, nil
+}
+
+// splitNames returns the dependency names in the comma-separated values of --only or
+// --exclude, trimmed, in order and without blanks or repeats.
+func splitNames(values []string) []string {
+	var names []string
+	seen := make(map[string]bool)
+	for _, value := range values {
+		for _, name := range strings.Split(value, ",") {
+			name = strings.TrimSpace(name)
+			if name == "" || seen[name] {
+				continue
+			}
+			seen[name] = true
+			names = append(names, name)
+		}
+	}
+	return names
+}
+
+// parseRefOverrides parses the values of --ref into a map from dependency name to ref.
+func parseRefOverrides(values []string) (map[string]string, error) {
+	overrides := make(map[string]string, len(values))
+	for _, value := range values {
+		name, ref, ok := strings.Cut(value, "=")
+		name, ref = strings.TrimSpace(name), strings.TrimSpace(ref)
+		if !ok || name == "" || ref == "" {
+			return nil, fmt.Errorf("'%s' must have the form NAME=REF, e.g. json=develop", value)
+		}
+		if previous, seen := overrides[name]; seen && previous != ref {
+			return nil, fmt.Errorf("'%s' is given two refs, %s and %s", name, previous, ref)
+		}
+		overrides[name] = ref
+	}
+	return overrides, nil
+}
+
+// remapSource returns the canonical GitHub source for pathInRepo moved under the first remap
+// whose prefix matches it on a path boundary, or "" if none does.
+func remapSource(owner, repo, pathInRepo, ref string, remaps []pathRemap) string {
+	for _, r := range remaps {
+		if rest, ok := strings.CutPrefix(pathInRepo, r.Old+"/"); ok {
+			return fmt.Sprintf("github:%s/%s/%s/%s@%s", owner, repo, r.New, rest, ref)
+		}
+	}
+	return ""
+}
+
+// moveInstalledFile moves the dependency file at oldPath to newPath and removes any directories
+// the move left empty. It reports whether a file was moved: nothing is moved when oldPath is
+// gone or newPath already exists, and the file at newPath is then installed or checked as usual.
+func moveInstalledFile(oldPath, newPath string) (bool, error) {
+	oldNative, newNative := project.NativePath(oldPath), project.NativePath(newPath)
+	if fi, err := os.Stat(oldNative); err != nil || !fi.Mode().IsRegular() {
+		return false, nil
+	}
+	if _, err := os.Lstat(newNative); !errors.Is(err, os.ErrNotExist) {
+		return false, nil
+	}
+	if err := os.MkdirAll(filepath.Dir(newNative), 0755); err != nil {
+		return false, err
+	}
+	if err := os.Rename(oldNative, newNative); err != nil {
+		return false, err
+	}
+	// os.Remove fails on a directory that still has entries, which ends the walk.
+	for dir := filepath.Dir(oldNative); dir != "." && dir != filepath.Dir(dir); dir = filepath.Dir(dir) {
+		if os.Remove(dir) != nil {
+			break
+		}
+	}
+	return true, nil
+}
+
+// hashFileOnDisk returns the integrity hash of a dependency file that is already on disk,
+// using hasher.DefaultAlgorithm. When the dependency publishes a checksum, the file must
+// match it to be accepted and the published hash, fetched with downloads, is returned.
+func hashFileOnDisk(downloads *downloader.Client, depPath, checksumURL, pathInRepo string) (string, error) {
+	content, err := os.ReadFile(project.NativePath(depPath))
+	if err != nil {
+		return "", err
+	}
+	if checksumURL == "" {
+		return hasher.Calculate(content, hasher.DefaultAlgorithm)
+	}
+	publishedHash, err := downloads.FetchChecksum(checksumURL, path.Base(pathInRepo))
+	if err != nil {
+		return "", fmt.Errorf("failed to fetch checksum from '%s': %w", checksumURL, err)
+	}
+	contentHash, err := hasher.Calculate(content, hasher.AlgorithmFor(publishedHash))
+	if err != nil {
+		return "", err
+	}
+	if contentHash != publishedHash {
+		return "", fmt.Errorf("%s hashes to %s, but %s publishes %s", depPath, contentHash, checksumURL, publishedHash)
+	}
+	return contentHash, nil
+}
+
+// isContentHash reports whether a lockfile hash pins content rather than a commit.
+func isContentHash(hash string) bool {
+	_, ok := hasher.Algorithm(hash)
+	return ok
+}
+
+// shortSHA abbreviates a commit SHA to seven characters for display.
+func shortSHA(sha string) string {
+	if len(sha) > 7 {
+		return sha[:7]
+	}
+	return sha
 }
@@ -3,16 +3,25 @@
 package install_test
 
 import (
+	"bytes"
 	"fmt"
+	"io"
 	"net/http"
 	"net/http/httptest"
 	"os"
 	"path/filepath"
+	"strings"
+	"sync/atomic"
 	"testing"
+	"time"
 
 	"github.com/BurntSushi/toml"
 	installcmd "github.com/nightconcept/almandine-go/internal/cli/install" // Import the package being tested
+	"github.com/nightconcept/almandine-go/internal/cli/remove"
 	"github.com/nightconcept/almandine-go/internal/core/config"
+	"github.com/nightconcept/almandine-go/internal/core/contentcache"
+	"github.com/nightconcept/almandine-go/internal/core/downloader"
+	"github.com/nightconcept/almandine-go/internal/core/hasher"
 	"github.com/nightconcept/almandine-go/internal/core/lockfile"
 	"github.com/nightconcept/almandine-go/internal/core/project"
 	"github.com/nightconcept/almandine-go/internal/core/source"
@@ -61,6 +70,8 @@ func startMockHTTPServer(t *testing.T, pathResponses map[string]struct {
 func setupInstallTestEnvironment(t *testing.T, initialProjectTomlContent string, initialLockfileContent string, mockDepFiles map[string]string) (tempDir string) {
 	t.Helper()
 	tempDir = t.TempDir()
+	// Every test gets its own content cache, so fixtures reusing a commit SHA cannot collide.
+	t.Setenv(contentcache.DirEnv, t.TempDir())
 
 	if initialProjectTomlContent != "" {
 		projectTomlPath := filepath.Join(tempDir, config.ProjectTomlName)
@@ -88,6 +99,20 @@ func setupInstallTestEnvironment(t *testing.T, initialProjectTomlContent string,
 // runInstallCommand executes the 'install' command within a specific working directory.
 func runInstallCommand(t *testing.T, workDir string, installCmdArgs ...string) error {
 	t.Helper()
+	return runInstallCommandWithIO(t, workDir, nil, os.Stderr, os.Stderr, installCmdArgs...)
+}
+
+// runInstallCommandWithIO executes the 'install' command like runInstallCommand, but reads
+// prompt answers from stdin (os.Stdin when nil) and sends the app's stdout and stderr streams
+// to the given writers so tests can inspect them separately.
+func runInstallCommandWithIO(t *testing.T, workDir string, stdin io.Reader, stdout, stderr io.Writer, installCmdArgs ...string) error {
+	t.Helper()
+	return runCommandWithIO(t, installcmd.NewInstallCommand(), workDir, stdin, stdout, stderr, installCmdArgs...)
+}
+
+// runCommandWithIO runs cmd, an 'install' command, like runInstallCommandWithIO.
+func runCommandWithIO(t *testing.T, cmd *cli.Command, workDir string, stdin io.Reader, stdout, stderr io.Writer, installCmdArgs ...string) error {
+	t.Helper()
 
 	originalWd, err := os.Getwd()
 	require.NoError(t, err, "Failed to get current working directory")
@@ -100,10 +125,11 @@ func runInstallCommand(t *testing.T, workDir string, installCmdArgs ...string) e
 	app := &cli.App{
 		Name: "almd-test-install",
 		Commands: []*cli.Command{
-			installcmd.NewInstallCommand(),
+			cmd,
 		},
-		Writer:    os.Stderr,
-		ErrWriter: os.Stderr,
+		Reader:    stdin,
+		Writer:    stdout,
+		ErrWriter: stderr,
 		ExitErrHandler: func(context *cli.Context, err error) {
 			// Do nothing, let test assertions handle errors
 		},
@@ -230,6 +256,9 @@ hash = "commit:commit1_sha_abcdef1234567890"
 	assert.Equal(t, expectedLockSourceURL, depALockEntry.Source, "depA lockfile source URL mismatch")
 	assert.Equal(t, depAPath, depALockEntry.Path, "depA lockfile path mismatch")
 	assert.Equal(t, "commit:"+commit2SHA, depALockEntry.Hash, "depA lockfile hash mismatch")
+	expectedContentHash, err := hasher.CalculateSHA256([]byte(depANewContent))
+	require.NoError(t, err)
+	assert.Equal(t, expectedContentHash, depALockEntry.ContentHash, "depA lockfile content hash mismatch")
 
 	// 3. Verify project.toml remains unchanged (install doesn't modify project.toml sources)
 	projTomlPath := filepath.Join(tempDir, config.ProjectTomlName)
@@ -289,8 +318,6 @@ hash = "commit:%s"
 		depBPath: depBOriginalContent,
 	}
 
-	tempDir := setupInstallTestEnvironment(t, initialProjectToml, initialLockfile, mockFiles)
-
 	// Mock server setup
 	githubAPIPathForDepA := fmt.Sprintf("/repos/testowner/testrepo/commits?path=%s&sha=main&per_page=1", depAPath)
 	githubAPIResponseForDepA := fmt.Sprintf(`[{"sha": "%s"}]`, depACommit2HexSHA)
@@ -315,45 +342,57 @@ hash = "commit:%s"
 	source.GithubAPIBaseURL = mockServer.URL
 	defer func() { source.GithubAPIBaseURL = originalGHAPIBaseURL }()
 
-	// --- Run Command for depA only ---
-	err := runInstallCommand(t, tempDir, depAName)
-	require.NoError(t, err, "almd install %s command failed", depAName)
-
-	// --- Assertions for depA ---
-	depAFilePath := filepath.Join(tempDir, depAPath)
-	updatedContentBytesA, readErrA := os.ReadFile(depAFilePath)
-	require.NoError(t, readErrA, "Failed to read updated depA file: %s", depAFilePath)
-	assert.Equal(t, depANewContent, string(updatedContentBytesA), "depA file content mismatch after specific install")
-
-	lockFilePath := filepath.Join(tempDir, lockfile.LockfileName)
-	updatedLockCfg := readAlmdLockToml(t, lockFilePath)
-	require.NotNil(t, updatedLockCfg.Package, "Packages map in almd-lock.toml is nil")
-
-	depALockEntry, okA := updatedLockCfg.Package[depAName]
-	require.True(t, okA, "depA entry not found in almd-lock.toml after specific install")
-	expectedLockSourceURLA := mockServer.URL + rawDownloadPathDepA
-	assert.Equal(t, expectedLockSourceURLA, depALockEntry.Source, "depA lockfile source URL mismatch")
-	assert.Equal(t, "commit:"+depACommit2HexSHA, depALockEntry.Hash, "depA lockfile hash mismatch")
-
-	// --- Assertions for depB (should be unchanged) ---
-	depBFilePath := filepath.Join(tempDir, depBPath)
-	contentBytesB, readErrB := os.ReadFile(depBFilePath)
-	require.NoError(t, readErrB, "Failed to read depB file: %s", depBFilePath)
-	assert.Equal(t, depBOriginalContent, string(contentBytesB), "depB file content should not have changed")
-
-	depBLockEntry, okB := updatedLockCfg.Package[depBName]
-	require.True(t, okB, "depB entry not found in almd-lock.toml")
-	expectedLockSourceURLBOriginal := fmt.Sprintf("https://raw.githubusercontent.com/anotherowner/anotherrepo/%s/%s", depBCommit1HexSHA, depBPath) // Original URL
-	assert.Equal(t, expectedLockSourceURLBOriginal, depBLockEntry.Source, "depB lockfile source URL should be unchanged")
-	assert.Equal(t, "commit:"+depBCommit1HexSHA, depBLockEntry.Hash, "depB lockfile hash should be unchanged")
-
-	// Verify project.toml remains unchanged
-	projTomlPath := filepath.Join(tempDir, config.ProjectTomlName)
-	currentProjCfg := readProjectToml(t, projTomlPath)
-	depAProjEntry := currentProjCfg.Dependencies[depAName]
-	assert.Equal(t, fmt.Sprintf("github:testowner/testrepo/%s@main", depAPath), depAProjEntry.Source)
-	depBProjEntry := currentProjCfg.Dependencies[depBName]
-	assert.Equal(t, fmt.Sprintf("github:anotherowner/anotherrepo/%s@main", depBPath), depBProjEntry.Source)
+	// Naming depA, naming it with --only and excluding depB all select depA alone.
+	for _, args := range [][]string{
+		{depAName},
+		{"--only", depAName},
+		{"--only", " " + depAName + ",,"},
+		{"--exclude", depBName},
+	} {
+		t.Run(strings.Join(args, " "), func(t *testing.T) {
+			tempDir := setupInstallTestEnvironment(t, initialProjectToml, initialLockfile, mockFiles)
+
+			// --- Run Command for depA only ---
+			err := runInstallCommand(t, tempDir, args...)
+			require.NoError(t, err, "almd install %v command failed", args)
+
+			// --- Assertions for depA ---
+			depAFilePath := filepath.Join(tempDir, depAPath)
+			updatedContentBytesA, readErrA := os.ReadFile(depAFilePath)
+			require.NoError(t, readErrA, "Failed to read updated depA file: %s", depAFilePath)
+			assert.Equal(t, depANewContent, string(updatedContentBytesA), "depA file content mismatch after specific install")
+
+			lockFilePath := filepath.Join(tempDir, lockfile.LockfileName)
+			updatedLockCfg := readAlmdLockToml(t, lockFilePath)
+			require.NotNil(t, updatedLockCfg.Package, "Packages map in almd-lock.toml is nil")
+
+			depALockEntry, okA := updatedLockCfg.Package[depAName]
+			require.True(t, okA, "depA entry not found in almd-lock.toml after specific install")
+			expectedLockSourceURLA := mockServer.URL + rawDownloadPathDepA
+			assert.Equal(t, expectedLockSourceURLA, depALockEntry.Source, "depA lockfile source URL mismatch")
+			assert.Equal(t, "commit:"+depACommit2HexSHA, depALockEntry.Hash, "depA lockfile hash mismatch")
+
+			// --- Assertions for depB (should be unchanged) ---
+			depBFilePath := filepath.Join(tempDir, depBPath)
+			contentBytesB, readErrB := os.ReadFile(depBFilePath)
+			require.NoError(t, readErrB, "Failed to read depB file: %s", depBFilePath)
+			assert.Equal(t, depBOriginalContent, string(contentBytesB), "depB file content should not have changed")
+
+			depBLockEntry, okB := updatedLockCfg.Package[depBName]
+			require.True(t, okB, "depB entry not found in almd-lock.toml")
+			expectedLockSourceURLBOriginal := fmt.Sprintf("https://raw.githubusercontent.com/anotherowner/anotherrepo/%s/%s", depBCommit1HexSHA, depBPath) // Original URL
+			assert.Equal(t, expectedLockSourceURLBOriginal, depBLockEntry.Source, "depB lockfile source URL should be unchanged")
+			assert.Equal(t, "commit:"+depBCommit1HexSHA, depBLockEntry.Hash, "depB lockfile hash should be unchanged")
+
+			// Verify project.toml remains unchanged
+			projTomlPath := filepath.Join(tempDir, config.ProjectTomlName)
+			currentProjCfg := readProjectToml(t, projTomlPath)
+			depAProjEntry := currentProjCfg.Dependencies[depAName]
+			assert.Equal(t, fmt.Sprintf("github:testowner/testrepo/%s@main", depAPath), depAProjEntry.Source)
+			depBProjEntry := currentProjCfg.Dependencies[depBName]
+			assert.Equal(t, fmt.Sprintf("github:anotherowner/anotherrepo/%s@main", depBPath), depBProjEntry.Source)
+		})
+	}
 }
 
 // Task 7.2.3: Test `almd install` - All dependencies up-to-date
@@ -777,6 +816,9 @@ api_version = "1"
 
 // Task 7.2.8: Test `almd install` - Error during download
 func TestInstallCommand_ErrorDuringDownload(t *testing.T) {
+	// The download answers 500; fail on the first attempt instead of retrying it.
+	downloader.MaxRetries = 0
+	defer func() { downloader.MaxRetries = 3 }()
 	depName := "depWithError"
 	depPath := "libs/depWithError.lua"
 	depOriginalContent := "local depWithError_v1 = true"
@@ -961,3 +1003,2018 @@ func TestInstallCommand_ProjectTomlNotFound(t *testing.T) {
 	assert.Contains(t, err.Error(), config.ProjectTomlName, "Error message should mention project.toml")
 	assert.Contains(t, err.Error(), "not found in the current directory", "Error message should indicate file not found in current directory")
 }
+
+func TestInstallCommand_AllowedHostsPolicy(t *testing.T) {
+	depPath := "libs/policydep.lua"
+	commitSHA := "0123456789abcdef0123456789abcdef01234567"
+	projectTomlTemplate := `
+[package]
+name = "test-install-policy"
+version = "0.1.0"
+
+[almd]
+allowed_hosts = [%s]
+
+[dependencies.policydep]
+source = "github:testowner/testrepo/policydep.lua@%s"
+path = "%s"
+`
+
+	t.Run("allowed host installs", func(t *testing.T) {
+		rawPath := fmt.Sprintf("/testowner/testrepo/%s/policydep.lua", commitSHA)
+		mockServer := startMockHTTPServer(t, map[string]struct {
+			Body string
+			Code int
+		}{
+			rawPath: {Body: "return 'ok'", Code: http.StatusOK},
+		})
+		originalGHAPIBaseURL := source.GithubAPIBaseURL
+		source.GithubAPIBaseURL = mockServer.URL
+		defer func() { source.GithubAPIBaseURL = originalGHAPIBaseURL }()
+
+		tempDir := setupInstallTestEnvironment(t, fmt.Sprintf(projectTomlTemplate, `"127.0.0.1"`, commitSHA, depPath), "", nil)
+
+		err := runInstallCommand(t, tempDir)
+		require.NoError(t, err)
+		content, readErr := os.ReadFile(filepath.Join(tempDir, depPath))
+		require.NoError(t, readErr)
+		assert.Equal(t, "return 'ok'", string(content))
+	})
+
+	t.Run("disallowed host is blocked before any request", func(t *testing.T) {
+		var requestCount int32
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			atomic.AddInt32(&requestCount, 1)
+			_, _ = w.Write([]byte("return 'nope'"))
+		}))
+		t.Cleanup(server.Close)
+		originalGHAPIBaseURL := source.GithubAPIBaseURL
+		source.GithubAPIBaseURL = server.URL
+		defer func() { source.GithubAPIBaseURL = originalGHAPIBaseURL }()
+
+		tempDir := setupInstallTestEnvironment(t, fmt.Sprintf(projectTomlTemplate, `"mirror.example.com"`, "main", depPath), "", nil)
+
+		err := runInstallCommand(t, tempDir)
+		require.Error(t, err)
+		assert.Contains(t, err.Error(), "rejected by policy")
+		assert.Equal(t, int32(0), atomic.LoadInt32(&requestCount), "no network call should be made for a blocked source")
+		_, statErr := os.Stat(filepath.Join(tempDir, depPath))
+		assert.True(t, os.IsNotExist(statErr))
+		_, statErr = os.Stat(filepath.Join(tempDir, lockfile.LockfileName))
+		assert.True(t, os.IsNotExist(statErr), "lockfile must not be written when policy rejects a source")
+	})
+
+	t.Run("disallowed locked source is blocked with --frozen-lockfile", func(t *testing.T) {
+		var requestCount int32
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			atomic.AddInt32(&requestCount, 1)
+			_, _ = w.Write([]byte("return 'nope'"))
+		}))
+		t.Cleanup(server.Close)
+		originalGHAPIBaseURL := source.GithubAPIBaseURL
+		source.GithubAPIBaseURL = server.URL
+		defer func() { source.GithubAPIBaseURL = originalGHAPIBaseURL }()
+
+		lockToml := fmt.Sprintf(`api_version = "1"
+
+[package.policydep]
+source = "%s/testowner/testrepo/%s/policydep.lua"
+path = "%s"
+hash = "commit:%s"
+`, server.URL, commitSHA, depPath, commitSHA)
+		tempDir := setupInstallTestEnvironment(t, fmt.Sprintf(projectTomlTemplate, `"mirror.example.com"`, commitSHA, depPath), lockToml, nil)
+
+		err := runInstallCommand(t, tempDir, "--frozen-lockfile", "--no-cache")
+		require.Error(t, err)
+		assert.Contains(t, err.Error(), "rejected by policy")
+		assert.Equal(t, int32(0), atomic.LoadInt32(&requestCount), "no network call should be made for a blocked locked source")
+		_, statErr := os.Stat(filepath.Join(tempDir, depPath))
+		assert.True(t, os.IsNotExist(statErr))
+	})
+
+	t.Run("checksum URL outside allowed_hosts is blocked before any request", func(t *testing.T) {
+		var requestCount int32
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			atomic.AddInt32(&requestCount, 1)
+			_, _ = w.Write([]byte("return 'ok'"))
+		}))
+		t.Cleanup(server.Close)
+		originalGHAPIBaseURL := source.GithubAPIBaseURL
+		source.GithubAPIBaseURL = server.URL
+		defer func() { source.GithubAPIBaseURL = originalGHAPIBaseURL }()
+
+		checksumURL := strings.Replace(server.URL, "127.0.0.1", "localhost", 1) + "/policydep.lua.sha256"
+		projectToml := fmt.Sprintf(projectTomlTemplate, `"127.0.0.1"`, commitSHA, depPath) + fmt.Sprintf("checksum_url = %q\n", checksumURL)
+		tempDir := setupInstallTestEnvironment(t, projectToml, "", nil)
+
+		err := runInstallCommand(t, tempDir)
+		require.Error(t, err)
+		assert.Contains(t, err.Error(), "Checksum URL for dependency 'policydep'")
+		assert.Contains(t, err.Error(), "rejected by policy")
+		assert.Equal(t, int32(0), atomic.LoadInt32(&requestCount), "no network call should be made for a blocked checksum URL")
+		_, statErr := os.Stat(filepath.Join(tempDir, depPath))
+		assert.True(t, os.IsNotExist(statErr))
+	})
+}
+
+func TestInstallCommand_OutputStreams(t *testing.T) {
+	depPath := "libs/streamdep.lua"
+	commitSHA := "89abcdef0123456789abcdef0123456789abcdef"
+	projectToml := fmt.Sprintf(`
+[package]
+name = "test-install-streams"
+version = "0.1.0"
+
+[dependencies.streamdep]
+source = "github:testowner/testrepo/streamdep.lua@%s"
+path = "%s"
+`, commitSHA, depPath)
+
+	rawPath := fmt.Sprintf("/testowner/testrepo/%s/streamdep.lua", commitSHA)
+	mockServer := startMockHTTPServer(t, map[string]struct {
+		Body string
+		Code int
+	}{
+		rawPath: {Body: "return 'streams'", Code: http.StatusOK},
+	})
+	originalGHAPIBaseURL := source.GithubAPIBaseURL
+	source.GithubAPIBaseURL = mockServer.URL
+	defer func() { source.GithubAPIBaseURL = originalGHAPIBaseURL }()
+
+	tempDir := setupInstallTestEnvironment(t, projectToml, "", nil)
+
+	var stdout, stderr bytes.Buffer
+	err := runInstallCommandWithIO(t, tempDir, nil, &stdout, &stderr, "--verbose", "streamdep", "ghost")
+	require.NoError(t, err)
+
+	assert.Equal(t, "Successfully installed/updated 1 dependenc(ies).\n", stdout.String(), "stdout should carry only the final result")
+	assert.Contains(t, stderr.String(), "Warning: Dependency 'ghost' specified for install/update not found in project.toml")
+	assert.Contains(t, stderr.String(), "Executing 'install' command...", "verbose tracing belongs on stderr")
+	assert.NotContains(t, stderr.String(), "Successfully installed/updated 1")
+
+	// A second run finds nothing to do; that result is still primary output.
+	stdout.Reset()
+	stderr.Reset()
+	err = runInstallCommandWithIO(t, tempDir, nil, &stdout, &stderr)
+	require.NoError(t, err)
+	assert.Equal(t, "All targeted dependencies are already up-to-date.\n", stdout.String())
+	assert.Empty(t, stderr.String())
+}
+
+func TestInstallCommand_SummaryOnly(t *testing.T) {
+	commitSHA := "0123456789abcdef0123456789abcdef01234567"
+	projectToml := fmt.Sprintf(`
+[package]
+name = "test-install-summary"
+version = "0.1.0"
+
+[dependencies.first]
+source = "github:testowner/testrepo/first.lua@%[1]s"
+path = "libs/first.lua"
+
+[dependencies.second]
+source = "github:testowner/testrepo/second.lua@%[1]s"
+path = "libs/second.lua"
+
+[dependencies.broken]
+source = "github:testowner/testrepo/broken.lua@%[1]s"
+path = "libs/broken.lua"
+`, commitSHA)
+
+	mockServer := startMockHTTPServer(t, map[string]struct {
+		Body string
+		Code int
+	}{
+		fmt.Sprintf("/testowner/testrepo/%s/first.lua", commitSHA):  {Body: "return 'first'", Code: http.StatusOK},
+		fmt.Sprintf("/testowner/testrepo/%s/second.lua", commitSHA): {Body: "return 'second'", Code: http.StatusOK},
+		fmt.Sprintf("/testowner/testrepo/%s/broken.lua", commitSHA): {Body: "gone", Code: http.StatusNotFound},
+	})
+	originalGHAPIBaseURL := source.GithubAPIBaseURL
+	source.GithubAPIBaseURL = mockServer.URL
+	defer func() { source.GithubAPIBaseURL = originalGHAPIBaseURL }()
+
+	tempDir := setupInstallTestEnvironment(t, projectToml, "", nil)
+
+	var stdout, stderr bytes.Buffer
+	err := runInstallCommandWithIO(t, tempDir, nil, &stdout, &stderr, "--summary-only", "--verbose")
+	require.NoError(t, err)
+	assert.Equal(t, "Summary: 2 added, 0 updated, 0 reinstalled, 0 unchanged, 1 failed.\n", stdout.String())
+	assert.Contains(t, stderr.String(), "Error: Failed to download dependency 'broken'", "errors still go to stderr")
+	assert.NotContains(t, stderr.String(), "Executing 'install' command...", "--summary-only suppresses verbose tracing")
+
+	stdout.Reset()
+	stderr.Reset()
+	err = runInstallCommandWithIO(t, tempDir, nil, &stdout, &stderr, "--summary-only", "--force", "first", "second")
+	require.NoError(t, err)
+	assert.Equal(t, "Summary: 0 added, 0 updated, 2 reinstalled, 0 unchanged, 0 failed.\n", stdout.String())
+}
+
+func TestInstallCommand_Quiet(t *testing.T) {
+	commitSHA := "0123456789abcdef0123456789abcdef01234567"
+	projectToml := fmt.Sprintf(`
+[package]
+name = "test-install-quiet"
+version = "0.1.0"
+
+[dependencies.first]
+source = "github:testowner/testrepo/first.lua@%[1]s"
+path = "libs/first.lua"
+
+[dependencies.broken]
+source = "github:testowner/testrepo/broken.lua@%[1]s"
+path = "libs/broken.lua"
+`, commitSHA)
+
+	mockServer := startMockHTTPServer(t, map[string]struct {
+		Body string
+		Code int
+	}{
+		fmt.Sprintf("/testowner/testrepo/%s/first.lua", commitSHA):  {Body: "return 'first'", Code: http.StatusOK},
+		fmt.Sprintf("/testowner/testrepo/%s/broken.lua", commitSHA): {Body: "gone", Code: http.StatusNotFound},
+	})
+	originalGHAPIBaseURL := source.GithubAPIBaseURL
+	source.GithubAPIBaseURL = mockServer.URL
+	defer func() { source.GithubAPIBaseURL = originalGHAPIBaseURL }()
+
+	tempDir := setupInstallTestEnvironment(t, projectToml, "", nil)
+
+	var stdout, stderr bytes.Buffer
+	_ = runInstallCommandWithIO(t, tempDir, nil, &stdout, &stderr, "-q")
+	assert.Empty(t, stdout.String())
+	assert.Contains(t, stderr.String(), "Error: Failed to download dependency 'broken'", "errors still go to stderr")
+	assert.FileExists(t, filepath.Join(tempDir, "libs", "first.lua"))
+
+	err := runInstallCommandWithIO(t, tempDir, nil, &stdout, &stderr, "--quiet", "--verbose")
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "--quiet and --verbose cannot be used together")
+}
+
+func TestInstallCommand_OnlyMissingLock(t *testing.T) {
+	commitSHA := "6666666666666666666666666666666666666666"
+	projectToml := fmt.Sprintf(`
+[package]
+name = "test-install-only-missing-lock"
+version = "0.1.0"
+
+[dependencies.present]
+source = "github:testowner/testrepo/present.lua@%[1]s"
+path = "libs/present.lua"
+
+[dependencies.missing]
+source = "github:testowner/testrepo/missing.lua@%[1]s"
+path = "libs/missing.lua"
+`, commitSHA)
+
+	// Only missing.lua is served: downloading present.lua would fail the install.
+	var requests atomic.Int32
+	mockServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests.Add(1)
+		if r.URL.Path == fmt.Sprintf("/testowner/testrepo/%s/missing.lua", commitSHA) {
+			_, _ = w.Write([]byte("return 'missing'"))
+			return
+		}
+		http.NotFound(w, r)
+	}))
+	defer mockServer.Close()
+	originalGHAPIBaseURL := source.GithubAPIBaseURL
+	source.GithubAPIBaseURL = mockServer.URL
+	defer func() { source.GithubAPIBaseURL = originalGHAPIBaseURL }()
+
+	presentContent := "-- added by hand\nreturn {}\n"
+	tempDir := setupInstallTestEnvironment(t, projectToml, "", map[string]string{"libs/present.lua": presentContent})
+
+	err := runInstallCommand(t, tempDir, "--only-missing-lock")
+	require.NoError(t, err)
+	assert.Equal(t, int32(1), requests.Load(), "only the missing file is downloaded")
+
+	expectedHash, err := hasher.CalculateSHA256([]byte(presentContent))
+	require.NoError(t, err)
+	lockCfg := readAlmdLockToml(t, filepath.Join(tempDir, lockfile.LockfileName))
+	require.Contains(t, lockCfg.Package, "present")
+	assert.Equal(t, expectedHash, lockCfg.Package["present"].Hash, "the present file is locked by its content hash")
+	assert.Equal(t, "libs/present.lua", lockCfg.Package["present"].Path)
+	require.Contains(t, lockCfg.Package, "missing")
+	assert.Equal(t, "commit:"+commitSHA, lockCfg.Package["missing"].Hash)
+
+	onDisk, err := os.ReadFile(filepath.Join(tempDir, "libs", "present.lua"))
+	require.NoError(t, err)
+	assert.Equal(t, presentContent, string(onDisk), "the present file is left untouched")
+
+	err = runInstallCommand(t, tempDir, "--only-missing-lock", "--force")
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "cannot be used together")
+}
+
+func TestInstallCommand_SourceOverride(t *testing.T) {
+	upstreamSHA := "7777777777777777777777777777777777777777"
+	forkSHA := "8888888888888888888888888888888888888888"
+	upstreamSource := fmt.Sprintf("github:upstream/repo/lib.lua@%s", upstreamSHA)
+	forkSource := fmt.Sprintf("github:myfork/repo/lib.lua@%s", forkSHA)
+	projectToml := fmt.Sprintf(`
+[package]
+name = "test-install-source-override"
+version = "0.1.0"
+
+[dependencies.lib]
+source = "%s"
+path = "libs/lib.lua"
+`, upstreamSource)
+	lockToml := fmt.Sprintf(`
+api_version = "1"
+
+[package.lib]
+source = "https://raw.githubusercontent.com/upstream/repo/%[1]s/lib.lua"
+path = "libs/lib.lua"
+hash = "commit:%[1]s"
+`, upstreamSHA)
+
+	mockServer := startMockHTTPServer(t, map[string]struct {
+		Body string
+		Code int
+	}{
+		fmt.Sprintf("/upstream/repo/%s/lib.lua", upstreamSHA): {Body: "return 'upstream'", Code: http.StatusOK},
+		fmt.Sprintf("/myfork/repo/%s/lib.lua", forkSHA):       {Body: "return 'fork'", Code: http.StatusOK},
+	})
+	originalGHAPIBaseURL := source.GithubAPIBaseURL
+	source.GithubAPIBaseURL = mockServer.URL
+	defer func() { source.GithubAPIBaseURL = originalGHAPIBaseURL }()
+
+	t.Run("one-off override leaves project.toml alone", func(t *testing.T) {
+		tempDir := setupInstallTestEnvironment(t, projectToml, lockToml, map[string]string{"libs/lib.lua": "return 'upstream'"})
+
+		var stderr bytes.Buffer
+		err := runInstallCommandWithIO(t, tempDir, nil, io.Discard, &stderr, "--source", forkSource, "lib")
+		require.NoError(t, err)
+
+		content, err := os.ReadFile(filepath.Join(tempDir, "libs", "lib.lua"))
+		require.NoError(t, err)
+		assert.Equal(t, "return 'fork'", string(content))
+
+		projCfg := readProjectToml(t, filepath.Join(tempDir, config.ProjectTomlName))
+		assert.Equal(t, upstreamSource, projCfg.Dependencies["lib"].Source)
+		assert.Contains(t, stderr.String(), "Use --save to keep the new source")
+
+		lockCfg := readAlmdLockToml(t, filepath.Join(tempDir, lockfile.LockfileName))
+		assert.Equal(t, "commit:"+forkSHA, lockCfg.Package["lib"].Hash)
+	})
+
+	t.Run("save records the override", func(t *testing.T) {
+		tempDir := setupInstallTestEnvironment(t, projectToml, lockToml, map[string]string{"libs/lib.lua": "return 'upstream'"})
+
+		err := runInstallCommand(t, tempDir, "--source", forkSource, "--save", "lib")
+		require.NoError(t, err)
+
+		projCfg := readProjectToml(t, filepath.Join(tempDir, config.ProjectTomlName))
+		assert.Equal(t, forkSource, projCfg.Dependencies["lib"].Source)
+		assert.Equal(t, "libs/lib.lua", projCfg.Dependencies["lib"].Path)
+	})
+
+	t.Run("override must provide the same file", func(t *testing.T) {
+		tempDir := setupInstallTestEnvironment(t, projectToml, lockToml, nil)
+
+		err := runInstallCommand(t, tempDir, "--source", "github:myfork/repo/other.lua@main", "lib")
+		require.Error(t, err)
+		assert.Contains(t, err.Error(), "provides 'other.lua'")
+	})
+
+	t.Run("override needs exactly one dependency", func(t *testing.T) {
+		tempDir := setupInstallTestEnvironment(t, projectToml, lockToml, nil)
+
+		err := runInstallCommand(t, tempDir, "--source", forkSource)
+		require.Error(t, err)
+		assert.Contains(t, err.Error(), "exactly one dependency")
+	})
+}
+
+func TestInstallCommand_RemapMovedPath(t *testing.T) {
+	commitSHA := "9999999999999999999999999999999999999999"
+	projectToml := fmt.Sprintf(`
+[package]
+name = "test-install-remap"
+version = "0.1.0"
+
+[dependencies.mod]
+source = "github:testowner/testrepo/src/mod.lua@%s"
+path = "libs/mod.lua"
+`, commitSHA)
+
+	// Upstream moved src/ to lua/src/; the old path now 404s.
+	mockServer := startMockHTTPServer(t, map[string]struct {
+		Body string
+		Code int
+	}{
+		fmt.Sprintf("/testowner/testrepo/%s/src/mod.lua", commitSHA):     {Body: "Not Found", Code: http.StatusNotFound},
+		fmt.Sprintf("/testowner/testrepo/%s/lua/src/mod.lua", commitSHA): {Body: "return 'moved'", Code: http.StatusOK},
+	})
+	originalGHAPIBaseURL := source.GithubAPIBaseURL
+	source.GithubAPIBaseURL = mockServer.URL
+	defer func() { source.GithubAPIBaseURL = originalGHAPIBaseURL }()
+
+	t.Run("without remap the install fails", func(t *testing.T) {
+		tempDir := setupInstallTestEnvironment(t, projectToml, "", nil)
+		err := runInstallCommandWithIO(t, tempDir, nil, io.Discard, io.Discard)
+		require.Error(t, err)
+	})
+
+	t.Run("remapped path is installed and recorded", func(t *testing.T) {
+		tempDir := setupInstallTestEnvironment(t, projectToml, "", nil)
+
+		var stderr bytes.Buffer
+		err := runInstallCommandWithIO(t, tempDir, nil, io.Discard, &stderr, "--remap", "src=lua/src")
+		require.NoError(t, err)
+		assert.Contains(t, stderr.String(), "Note: 'mod' was not found at src/mod.lua upstream; installed it from lua/src/mod.lua instead")
+
+		content, err := os.ReadFile(filepath.Join(tempDir, "libs", "mod.lua"))
+		require.NoError(t, err)
+		assert.Equal(t, "return 'moved'", string(content))
+
+		projCfg := readProjectToml(t, filepath.Join(tempDir, config.ProjectTomlName))
+		assert.Equal(t, fmt.Sprintf("github:testowner/testrepo/lua/src/mod.lua@%s", commitSHA), projCfg.Dependencies["mod"].Source)
+		assert.Equal(t, "libs/mod.lua", projCfg.Dependencies["mod"].Path, "the local path is unchanged")
+
+		lockCfg := readAlmdLockToml(t, filepath.Join(tempDir, lockfile.LockfileName))
+		assert.Equal(t, fmt.Sprintf("%s/testowner/testrepo/%s/lua/src/mod.lua", mockServer.URL, commitSHA), lockCfg.Package["mod"].Source)
+		assert.Equal(t, "commit:"+commitSHA, lockCfg.Package["mod"].Hash)
+	})
+
+	t.Run("malformed remap", func(t *testing.T) {
+		tempDir := setupInstallTestEnvironment(t, projectToml, "", nil)
+		err := runInstallCommand(t, tempDir, "--remap", "src")
+		require.Error(t, err)
+		assert.Contains(t, err.Error(), "must have the form OLD=NEW")
+	})
+}
+
+func TestInstallCommand_RemapInRepoNamedLikeBranch(t *testing.T) {
+	oldSHA := "1111111111111111111111111111111111111111"
+	newSHA := "2222222222222222222222222222222222222222"
+	projectToml := `
+[package]
+name = "test-install-remap-branch"
+version = "0.1.0"
+
+[dependencies.mod]
+source = "github:testowner/main/src/mod.lua@main"
+path = "libs/mod.lua"
+`
+	mockServer := startMockHTTPServer(t, map[string]struct {
+		Body string
+		Code int
+	}{
+		"/repos/testowner/main/commits?path=src/mod.lua&sha=main&per_page=1":     {Body: fmt.Sprintf(`[{"sha": "%s"}]`, oldSHA), Code: http.StatusOK},
+		"/repos/testowner/main/commits?path=lua/src/mod.lua&sha=main&per_page=1": {Body: fmt.Sprintf(`[{"sha": "%s"}]`, newSHA), Code: http.StatusOK},
+		"/testowner/main/" + oldSHA + "/src/mod.lua":                             {Body: "Not Found", Code: http.StatusNotFound},
+		"/testowner/main/" + newSHA + "/lua/src/mod.lua":                         {Body: "return 'moved'", Code: http.StatusOK},
+	})
+	originalGHAPIBaseURL := source.GithubAPIBaseURL
+	source.GithubAPIBaseURL = mockServer.URL
+	defer func() { source.GithubAPIBaseURL = originalGHAPIBaseURL }()
+
+	tempDir := setupInstallTestEnvironment(t, projectToml, "", nil)
+	require.NoError(t, runInstallCommandWithIO(t, tempDir, nil, io.Discard, io.Discard, "--remap", "src=lua/src"))
+
+	lockCfg := readAlmdLockToml(t, filepath.Join(tempDir, lockfile.LockfileName))
+	assert.Equal(t, mockServer.URL+"/testowner/main/"+newSHA+"/lua/src/mod.lua", lockCfg.Package["mod"].Source, "the repository segment is not mistaken for the ref")
+	assert.Equal(t, "commit:"+newSHA, lockCfg.Package["mod"].Hash)
+}
+
+func TestInstallCommand_LinkFromContentCache(t *testing.T) {
+	commitSHA := "abababababababababababababababababababab"
+	projectToml := fmt.Sprintf(`
+[package]
+name = "test-install-link"
+version = "0.1.0"
+
+[dependencies.shared]
+source = "github:testowner/testrepo/shared.lua@%s"
+path = "libs/shared.lua"
+`, commitSHA)
+
+	var downloads atomic.Int32
+	mockServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == fmt.Sprintf("/testowner/testrepo/%s/shared.lua", commitSHA) {
+			downloads.Add(1)
+			_, _ = w.Write([]byte("return 'shared'"))
+			return
+		}
+		http.NotFound(w, r)
+	}))
+	defer mockServer.Close()
+	originalGHAPIBaseURL := source.GithubAPIBaseURL
+	source.GithubAPIBaseURL = mockServer.URL
+	defer func() { source.GithubAPIBaseURL = originalGHAPIBaseURL }()
+
+	firstProject := setupInstallTestEnvironment(t, projectToml, "", nil)
+	secondProject := setupInstallTestEnvironment(t, projectToml, "", nil)
+	t.Setenv(contentcache.DirEnv, t.TempDir()) // Shared by both projects
+	require.NoError(t, runInstallCommand(t, firstProject, "--link"))
+	require.NoError(t, runInstallCommand(t, secondProject, "--link"))
+
+	assert.Equal(t, int32(1), downloads.Load(), "the second project is served from the cache")
+
+	firstInfo, err := os.Stat(filepath.Join(firstProject, "libs", "shared.lua"))
+	require.NoError(t, err)
+	secondInfo, err := os.Stat(filepath.Join(secondProject, "libs", "shared.lua"))
+	require.NoError(t, err)
+	assert.True(t, os.SameFile(firstInfo, secondInfo), "both projects link the same cached blob")
+
+	lockCfg := readAlmdLockToml(t, filepath.Join(secondProject, lockfile.LockfileName))
+	assert.Equal(t, "commit:"+commitSHA, lockCfg.Package["shared"].Hash)
+}
+
+func TestInstallCommand_CommitContentCache(t *testing.T) {
+	commitSHA := "cdcdcdcdcdcdcdcdcdcdcdcdcdcdcdcdcdcdcdcd"
+	projectToml := fmt.Sprintf(`
+[package]
+name = "test-install-cache"
+version = "0.1.0"
+
+[dependencies.cached]
+source = "github:testowner/testrepo/cached.lua@%s"
+path = "libs/cached.lua"
+`, commitSHA)
+
+	var downloads atomic.Int32
+	mockServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == fmt.Sprintf("/testowner/testrepo/%s/cached.lua", commitSHA) {
+			downloads.Add(1)
+			_, _ = w.Write([]byte("return 'cached'"))
+			return
+		}
+		http.NotFound(w, r)
+	}))
+	defer mockServer.Close()
+	originalGHAPIBaseURL := source.GithubAPIBaseURL
+	source.GithubAPIBaseURL = mockServer.URL
+	defer func() { source.GithubAPIBaseURL = originalGHAPIBaseURL }()
+
+	projects := make([]string, 4)
+	for i := range projects {
+		projects[i] = setupInstallTestEnvironment(t, projectToml, "", nil)
+	}
+	cacheDir := t.TempDir()
+	t.Setenv(contentcache.DirEnv, cacheDir)
+
+	require.NoError(t, runInstallCommand(t, projects[0]))
+	require.NoError(t, runInstallCommand(t, projects[1]))
+	assert.Equal(t, int32(1), downloads.Load(), "the same commit is served from the cache by default")
+	content, err := os.ReadFile(filepath.Join(projects[1], "libs", "cached.lua"))
+	require.NoError(t, err)
+	assert.Equal(t, "return 'cached'", string(content))
+
+	require.NoError(t, runInstallCommand(t, projects[2], "--no-cache"))
+	assert.Equal(t, int32(2), downloads.Load(), "--no-cache always downloads")
+
+	// Cached content that no longer matches its hash is not trusted.
+	hash, err := hasher.CalculateSHA256([]byte("return 'cached'"))
+	require.NoError(t, err)
+	hexHash := strings.TrimPrefix(hash, "sha256:")
+	blobPath := filepath.Join(cacheDir, "sha256", hexHash[:2], hexHash)
+	require.NoError(t, os.Chmod(blobPath, 0644))
+	require.NoError(t, os.WriteFile(blobPath, []byte("return 'tampered'"), 0644))
+	require.NoError(t, runInstallCommand(t, projects[3]))
+	assert.Equal(t, int32(3), downloads.Load(), "a corrupt cache entry is downloaded again")
+	content, err = os.ReadFile(filepath.Join(projects[3], "libs", "cached.lua"))
+	require.NoError(t, err)
+	assert.Equal(t, "return 'cached'", string(content))
+}
+
+func TestInstallCommand_ExpandsShortCommitSHA(t *testing.T) {
+	shortSHA, fullSHA := "abc1234", "abc1234def5678901234567890abcdef12345678"
+	projectToml := fmt.Sprintf(`
+[package]
+name = "test-install-short-sha"
+version = "0.1.0"
+
+[dependencies.lib]
+source = "github:testowner/testrepo/lib.lua@%s"
+path = "libs/lib.lua"
+`, shortSHA)
+
+	var expansions atomic.Int32
+	mockServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/repos/testowner/testrepo/commits/" + shortSHA:
+			expansions.Add(1)
+			_, _ = fmt.Fprintf(w, `{"sha": "%s"}`, fullSHA)
+		case "/testowner/testrepo/" + fullSHA + "/lib.lua":
+			_, _ = w.Write([]byte("return 'lib'"))
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer mockServer.Close()
+	originalGHAPIBaseURL := source.GithubAPIBaseURL
+	source.GithubAPIBaseURL = mockServer.URL
+	defer func() { source.GithubAPIBaseURL = originalGHAPIBaseURL }()
+
+	tempDir := setupInstallTestEnvironment(t, projectToml, "", nil)
+	require.NoError(t, runInstallCommand(t, tempDir))
+
+	lockCfg := readAlmdLockToml(t, filepath.Join(tempDir, lockfile.LockfileName))
+	assert.Equal(t, "commit:"+fullSHA, lockCfg.Package["lib"].Hash)
+	assert.Equal(t, mockServer.URL+"/testowner/testrepo/"+fullSHA+"/lib.lua", lockCfg.Package["lib"].Source)
+	assert.Equal(t, int32(1), expansions.Load())
+
+	// The expanded SHA in the lockfile is reused, and the frozen check accepts the short form.
+	require.NoError(t, runInstallCommand(t, tempDir))
+	assert.Equal(t, int32(1), expansions.Load(), "an already expanded SHA is not looked up again")
+	require.NoError(t, runInstallCommand(t, tempDir, "--frozen-lockfile"))
+
+	t.Run("failed expansion warns and keeps the short SHA", func(t *testing.T) {
+		otherToml := strings.Replace(projectToml, shortSHA, "def5678", 1)
+		otherDir := setupInstallTestEnvironment(t, otherToml, "", nil)
+		var stderr bytes.Buffer
+		_ = runInstallCommandWithIO(t, otherDir, nil, io.Discard, &stderr)
+		assert.Contains(t, stderr.String(), "Warning: Could not expand short commit SHA 'def5678' for 'lib'")
+	})
+}
+
+func TestInstallCommand_ExpandsShortCommitSHAInRepoNamedLikeIt(t *testing.T) {
+	shortSHA, fullSHA := "abc1234", "abc1234def5678901234567890abcdef12345678"
+	projectToml := fmt.Sprintf(`
+[package]
+name = "test-install-short-sha-repo"
+version = "0.1.0"
+
+[dependencies.lib]
+source = "github:testowner/%[1]s/lib.lua@%[1]s"
+path = "libs/lib.lua"
+`, shortSHA)
+
+	mockServer := startMockHTTPServer(t, map[string]struct {
+		Body string
+		Code int
+	}{
+		"/repos/testowner/" + shortSHA + "/commits/" + shortSHA: {Body: fmt.Sprintf(`{"sha": "%s"}`, fullSHA), Code: http.StatusOK},
+		"/testowner/" + shortSHA + "/" + fullSHA + "/lib.lua":   {Body: "return 'lib'", Code: http.StatusOK},
+	})
+	originalGHAPIBaseURL := source.GithubAPIBaseURL
+	source.GithubAPIBaseURL = mockServer.URL
+	defer func() { source.GithubAPIBaseURL = originalGHAPIBaseURL }()
+
+	tempDir := setupInstallTestEnvironment(t, projectToml, "", nil)
+	require.NoError(t, runInstallCommand(t, tempDir))
+
+	lockCfg := readAlmdLockToml(t, filepath.Join(tempDir, lockfile.LockfileName))
+	assert.Equal(t, mockServer.URL+"/testowner/"+shortSHA+"/"+fullSHA+"/lib.lua", lockCfg.Package["lib"].Source, "the repository segment is not mistaken for the ref")
+}
+
+func TestInstallCommand_ProcessLock(t *testing.T) {
+	projectToml := `
+[package]
+name = "test-install-process-lock"
+version = "0.1.0"
+`
+	tempDir := setupInstallTestEnvironment(t, projectToml, "", nil)
+	originalTimeout := lockfile.ProcessLockTimeout
+	lockfile.ProcessLockTimeout = 100 * time.Millisecond
+	defer func() { lockfile.ProcessLockTimeout = originalTimeout }()
+
+	release, err := lockfile.Acquire(tempDir)
+	require.NoError(t, err)
+	err = runInstallCommand(t, tempDir)
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "another almd process is running in this project")
+	release()
+
+	require.NoError(t, runInstallCommand(t, tempDir))
+	assert.NoFileExists(t, filepath.Join(tempDir, lockfile.ProcessLockName), "the lock is released when install returns")
+
+	// The lock is also released when the command fails.
+	require.NoError(t, os.Remove(filepath.Join(tempDir, config.ProjectTomlName)))
+	require.Error(t, runInstallCommand(t, tempDir))
+	assert.NoFileExists(t, filepath.Join(tempDir, lockfile.ProcessLockName))
+}
+
+func TestInstallCommand_FrozenLockfile(t *testing.T) {
+	lockedSHA := "efefefefefefefefefefefefefefefefefefefef"
+	lockedContent := "return 'locked'"
+	contentHash, err := hasher.CalculateSHA256([]byte(lockedContent))
+	require.NoError(t, err)
+	projectToml := `
+[package]
+name = "test-install-frozen"
+version = "0.1.0"
+
+[dependencies.frozen]
+source = "github:testowner/testrepo/frozen.lua@main"
+path = "libs/frozen.lua"
+`
+
+	var apiCalls atomic.Int32
+	served := lockedContent
+	mockServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if strings.HasPrefix(r.URL.Path, "/repos/") {
+			apiCalls.Add(1)
+			http.NotFound(w, r)
+			return
+		}
+		if r.URL.Path == fmt.Sprintf("/testowner/testrepo/%s/frozen.lua", lockedSHA) {
+			_, _ = w.Write([]byte(served))
+			return
+		}
+		http.NotFound(w, r)
+	}))
+	defer mockServer.Close()
+	originalGHAPIBaseURL := source.GithubAPIBaseURL
+	source.GithubAPIBaseURL = mockServer.URL
+	defer func() { source.GithubAPIBaseURL = originalGHAPIBaseURL }()
+
+	lockToml := fmt.Sprintf(`api_version = "1"
+
+[package.frozen]
+source = "%s/testowner/testrepo/%s/frozen.lua"
+path = "libs/frozen.lua"
+hash = "commit:%s"
+content_hash = "%s"
+`, mockServer.URL, lockedSHA, lockedSHA, contentHash)
+
+	t.Run("installs the locked content without resolving refs", func(t *testing.T) {
+		tempDir := setupInstallTestEnvironment(t, projectToml, lockToml, nil)
+
+		require.NoError(t, runInstallCommand(t, tempDir, "--frozen-lockfile", "--no-cache"))
+		content, err := os.ReadFile(filepath.Join(tempDir, "libs", "frozen.lua"))
+		require.NoError(t, err)
+		assert.Equal(t, lockedContent, string(content))
+		assert.Equal(t, int32(0), apiCalls.Load(), "no ref is resolved with --frozen-lockfile")
+
+		lockAfter, err := os.ReadFile(filepath.Join(tempDir, lockfile.LockfileName))
+		require.NoError(t, err)
+		assert.Equal(t, lockToml, string(lockAfter), "the lockfile is never rewritten")
+	})
+
+	t.Run("dependency missing from the lockfile fails", func(t *testing.T) {
+		tempDir := setupInstallTestEnvironment(t, projectToml, "", nil)
+
+		var stderr bytes.Buffer
+		err := runInstallCommandWithIO(t, tempDir, nil, io.Discard, &stderr, "--frozen-lockfile")
+		require.Error(t, err)
+		assert.Equal(t, 1, err.(cli.ExitCoder).ExitCode())
+		assert.Contains(t, err.Error(), "--frozen-lockfile forbids updating it")
+		assert.Contains(t, stderr.String(), "'frozen' is not in almd-lock.toml")
+		assert.NoFileExists(t, filepath.Join(tempDir, "libs", "frozen.lua"))
+		assert.NoFileExists(t, filepath.Join(tempDir, lockfile.LockfileName))
+	})
+
+	t.Run("content that does not match the lock is not installed", func(t *testing.T) {
+		tempDir := setupInstallTestEnvironment(t, projectToml, lockToml, nil)
+		served = "return 'changed'"
+		defer func() { served = lockedContent }()
+
+		err := runInstallCommand(t, tempDir, "--frozen-lockfile", "--no-cache")
+		require.Error(t, err)
+		assert.Equal(t, 1, err.(cli.ExitCoder).ExitCode())
+		assert.NoFileExists(t, filepath.Join(tempDir, "libs", "frozen.lua"))
+	})
+
+	t.Run("flags that change the lockfile are rejected", func(t *testing.T) {
+		tempDir := setupInstallTestEnvironment(t, projectToml, lockToml, nil)
+
+		err := runInstallCommand(t, tempDir, "--frozen-lockfile", "--only-missing-lock")
+		require.Error(t, err)
+		assert.Contains(t, err.Error(), "--frozen-lockfile and --only-missing-lock cannot be used together")
+	})
+}
+
+func TestInstallCommand_Offline(t *testing.T) {
+	commitSHA := "abababababababababababababababababababab"
+	projectToml := `
+[package]
+name = "test-install-offline"
+version = "0.1.0"
+
+[dependencies.cached]
+source = "github:testowner/testrepo/cached.lua@main"
+path = "libs/cached.lua"
+`
+	var requests atomic.Int32
+	mockServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests.Add(1)
+		switch r.URL.Path {
+		case "/repos/testowner/testrepo/commits":
+			_, _ = fmt.Fprintf(w, `[{"sha": "%s"}]`, commitSHA)
+		case fmt.Sprintf("/testowner/testrepo/%s/cached.lua", commitSHA):
+			_, _ = w.Write([]byte("return 'cached'"))
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer mockServer.Close()
+	originalGHAPIBaseURL := source.GithubAPIBaseURL
+	source.GithubAPIBaseURL = mockServer.URL
+	defer func() { source.GithubAPIBaseURL = originalGHAPIBaseURL }()
+
+	tempDir := setupInstallTestEnvironment(t, projectToml, "", nil)
+	require.NoError(t, runInstallCommand(t, tempDir))
+	lockBefore, err := os.ReadFile(filepath.Join(tempDir, lockfile.LockfileName))
+	require.NoError(t, err)
+
+	t.Run("restores cached files without network access", func(t *testing.T) {
+		require.NoError(t, os.RemoveAll(filepath.Join(tempDir, "libs")))
+		requests.Store(0)
+
+		require.NoError(t, runInstallCommand(t, tempDir, "--offline"))
+		content, err := os.ReadFile(filepath.Join(tempDir, "libs", "cached.lua"))
+		require.NoError(t, err)
+		assert.Equal(t, "return 'cached'", string(content))
+		assert.Equal(t, int32(0), requests.Load(), "--offline must not make any request")
+
+		lockAfter, err := os.ReadFile(filepath.Join(tempDir, lockfile.LockfileName))
+		require.NoError(t, err)
+		assert.Equal(t, string(lockBefore), string(lockAfter))
+	})
+
+	t.Run("fails for files that are not cached", func(t *testing.T) {
+		uncachedSHA := "cdcdcdcdcdcdcdcdcdcdcdcdcdcdcdcdcdcdcdcd"
+		projectWithUncached := projectToml + `
+[dependencies.uncached]
+source = "github:testowner/testrepo/uncached.lua@main"
+path = "libs/uncached.lua"
+`
+		lockWithUncached := string(lockBefore) + fmt.Sprintf(`
+[package.uncached]
+source = "%s/testowner/testrepo/%s/uncached.lua"
+path = "libs/uncached.lua"
+hash = "commit:%s"
+`, mockServer.URL, uncachedSHA, uncachedSHA)
+		require.NoError(t, os.WriteFile(filepath.Join(tempDir, config.ProjectTomlName), []byte(projectWithUncached), 0644))
+		require.NoError(t, os.WriteFile(filepath.Join(tempDir, lockfile.LockfileName), []byte(lockWithUncached), 0644))
+		requests.Store(0)
+
+		var stderr bytes.Buffer
+		err := runInstallCommandWithIO(t, tempDir, nil, io.Discard, &stderr, "--offline")
+		require.Error(t, err)
+		assert.Contains(t, err.Error(), "1 dependenc(ies) could not be installed offline")
+		assert.Contains(t, stderr.String(), "'uncached'")
+		assert.Contains(t, stderr.String(), "is not in the content cache")
+		assert.Equal(t, int32(0), requests.Load(), "--offline must not make any request")
+	})
+
+	t.Run("rejects flags that need the network", func(t *testing.T) {
+		err := runInstallCommand(t, tempDir, "--offline", "--no-cache")
+		require.Error(t, err)
+		assert.Contains(t, err.Error(), "--offline and --no-cache cannot be used together")
+	})
+}
+
+func TestInstallCommand_LockedCommitContentMismatch(t *testing.T) {
+	commitSHA := "bcbcbcbcbcbcbcbcbcbcbcbcbcbcbcbcbcbcbcbc"
+	projectToml := fmt.Sprintf(`
+[package]
+name = "test-install-mismatch"
+version = "0.1.0"
+
+[dependencies.pinned]
+source = "github:testowner/testrepo/pinned.lua@%s"
+path = "libs/pinned.lua"
+`, commitSHA)
+	lockedHash, err := hasher.CalculateSHA256([]byte("return 'original'"))
+	require.NoError(t, err)
+	lockToml := fmt.Sprintf(`api_version = "1"
+
+[package.pinned]
+source = "https://raw.githubusercontent.com/testowner/testrepo/%s/pinned.lua"
+path = "libs/pinned.lua"
+hash = "commit:%s"
+content_hash = "%s"
+`, commitSHA, commitSHA, lockedHash)
+
+	mockServer := startMockHTTPServer(t, map[string]struct {
+		Body string
+		Code int
+	}{
+		fmt.Sprintf("/testowner/testrepo/%s/pinned.lua", commitSHA): {Body: "return 'rewritten'", Code: http.StatusOK},
+	})
+	originalGHAPIBaseURL := source.GithubAPIBaseURL
+	source.GithubAPIBaseURL = mockServer.URL
+	defer func() { source.GithubAPIBaseURL = originalGHAPIBaseURL }()
+
+	tempDir := setupInstallTestEnvironment(t, projectToml, lockToml, nil)
+
+	var stderr bytes.Buffer
+	err = runInstallCommandWithIO(t, tempDir, nil, io.Discard, &stderr, "--no-cache")
+	require.Error(t, err)
+	assert.Contains(t, stderr.String(), "Refusing to install dependency 'pinned'")
+	assert.NoFileExists(t, filepath.Join(tempDir, "libs", "pinned.lua"))
+	currentLock, err := os.ReadFile(filepath.Join(tempDir, lockfile.LockfileName))
+	require.NoError(t, err)
+	assert.Equal(t, lockToml, string(currentLock))
+}
+
+func TestInstallCommand_PlainHTTPSource(t *testing.T) {
+	// Without the test-mode bypass, the mock server's URL is an ordinary web server.
+	source.SetTestModeBypassHostValidation(false)
+	t.Cleanup(func() { source.SetTestModeBypassHostValidation(true) })
+
+	served := "return 'v1'"
+	var downloads atomic.Int32
+	mockServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/libs/foo.lua" {
+			http.NotFound(w, r)
+			return
+		}
+		downloads.Add(1)
+		_, _ = w.Write([]byte(served))
+	}))
+	defer mockServer.Close()
+
+	sourceURL := mockServer.URL + "/libs/foo.lua"
+	v1Hash, err := hasher.CalculateSHA256([]byte("return 'v1'"))
+	require.NoError(t, err)
+	projectToml := fmt.Sprintf(`
+[package]
+name = "test-install-http"
+version = "0.1.0"
+
+[dependencies.foo]
+source = "%s"
+path = "libs/foo.lua"
+`, sourceURL)
+	lockToml := fmt.Sprintf(`api_version = "1"
+
+[package.foo]
+source = "%s"
+path = "libs/foo.lua"
+hash = "%s"
+`, sourceURL, v1Hash)
+	tempDir := setupInstallTestEnvironment(t, projectToml, lockToml, map[string]string{"libs/foo.lua": "return 'v1'"})
+
+	var stdout bytes.Buffer
+	require.NoError(t, runInstallCommandWithIO(t, tempDir, nil, &stdout, io.Discard))
+	assert.Equal(t, int32(1), downloads.Load(), "the content is fetched to check for changes")
+	assert.Contains(t, stdout.String(), "already up-to-date")
+
+	served = "return 'v2'"
+	require.NoError(t, runInstallCommand(t, tempDir))
+	content, err := os.ReadFile(filepath.Join(tempDir, "libs", "foo.lua"))
+	require.NoError(t, err)
+	assert.Equal(t, "return 'v2'", string(content))
+	assert.Equal(t, int32(2), downloads.Load(), "changed content is downloaded only once")
+
+	v2Hash, err := hasher.CalculateSHA256([]byte("return 'v2'"))
+	require.NoError(t, err)
+	lockCfg := readAlmdLockToml(t, filepath.Join(tempDir, lockfile.LockfileName))
+	assert.Equal(t, v2Hash, lockCfg.Package["foo"].Hash)
+	assert.Equal(t, sourceURL, lockCfg.Package["foo"].Source)
+}
+
+func TestInstallCommand_KeepsLockedHashAlgorithm(t *testing.T) {
+	source.SetTestModeBypassHostValidation(false)
+	t.Cleanup(func() { source.SetTestModeBypassHostValidation(true) })
+
+	served := "return 'v1'"
+	mockServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte(served))
+	}))
+	defer mockServer.Close()
+
+	sourceURL := mockServer.URL + "/libs/foo.lua"
+	v1Hash, err := hasher.Calculate([]byte("return 'v1'"), hasher.SHA512)
+	require.NoError(t, err)
+	projectToml := fmt.Sprintf(`
+[package]
+name = "test-install-sha512"
+version = "0.1.0"
+
+[dependencies.foo]
+source = "%s"
+path = "libs/foo.lua"
+`, sourceURL)
+	lockToml := fmt.Sprintf(`api_version = "1"
+
+[package.foo]
+source = "%s"
+path = "libs/foo.lua"
+hash = "%s"
+`, sourceURL, v1Hash)
+	tempDir := setupInstallTestEnvironment(t, projectToml, lockToml, map[string]string{"libs/foo.lua": "return 'v1'"})
+
+	// A sha512 lock entry still matches although the default algorithm is sha256.
+	var stdout bytes.Buffer
+	require.NoError(t, runInstallCommandWithIO(t, tempDir, nil, &stdout, io.Discard))
+	assert.Contains(t, stdout.String(), "already up-to-date")
+
+	served = "return 'v2'"
+	require.NoError(t, runInstallCommand(t, tempDir))
+	v2Hash, err := hasher.Calculate([]byte("return 'v2'"), hasher.SHA512)
+	require.NoError(t, err)
+	lockCfg := readAlmdLockToml(t, filepath.Join(tempDir, lockfile.LockfileName))
+	assert.Equal(t, v2Hash, lockCfg.Package["foo"].Hash, "the updated entry keeps its locked algorithm")
+}
+
+func TestInstallCommand_ConditionalRequests(t *testing.T) {
+	source.SetTestModeBypassHostValidation(false)
+	t.Cleanup(func() { source.SetTestModeBypassHostValidation(true) })
+
+	const etag = `"v1"`
+	var fullDownloads, notModified atomic.Int32
+	mockServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("If-None-Match") == etag {
+			notModified.Add(1)
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+		fullDownloads.Add(1)
+		w.Header().Set("ETag", etag)
+		_, _ = w.Write([]byte("return 'v1'"))
+	}))
+	defer mockServer.Close()
+
+	sourceURL := mockServer.URL + "/libs/foo.lua"
+	v1Hash, err := hasher.CalculateSHA256([]byte("return 'v1'"))
+	require.NoError(t, err)
+	projectToml := fmt.Sprintf(`
+[package]
+name = "test-install-etag"
+version = "0.1.0"
+
+[dependencies.foo]
+source = "%s"
+path = "libs/foo.lua"
+`, sourceURL)
+	// A lockfile written before validators were recorded.
+	lockToml := fmt.Sprintf(`api_version = "1"
+
+[package.foo]
+source = "%s"
+path = "libs/foo.lua"
+hash = "%s"
+`, sourceURL, v1Hash)
+	tempDir := setupInstallTestEnvironment(t, projectToml, lockToml, map[string]string{"libs/foo.lua": "return 'v1'"})
+
+	require.NoError(t, runInstallCommand(t, tempDir))
+	assert.Equal(t, int32(1), fullDownloads.Load())
+	lf, err := lockfile.Load(tempDir)
+	require.NoError(t, err)
+	assert.Equal(t, etag, lf.Package["foo"].ETag, "the ETag of an unchanged file is recorded for next time")
+
+	require.NoError(t, runInstallCommand(t, tempDir))
+	assert.Equal(t, int32(1), fullDownloads.Load(), "a 304 answer avoids the download")
+	assert.Equal(t, int32(1), notModified.Load())
+
+	require.NoError(t, runInstallCommand(t, tempDir, "--force"))
+	assert.Equal(t, int32(1), fullDownloads.Load(), "a forced install reuses the unchanged file on disk")
+	assert.Equal(t, int32(2), notModified.Load())
+	content, err := os.ReadFile(filepath.Join(tempDir, "libs", "foo.lua"))
+	require.NoError(t, err)
+	assert.Equal(t, "return 'v1'", string(content))
+	lf, err = lockfile.Load(tempDir)
+	require.NoError(t, err)
+	assert.Equal(t, etag, lf.Package["foo"].ETag)
+	assert.Equal(t, v1Hash, lf.Package["foo"].Hash)
+}
+
+func TestInstallCommand_VersionRange(t *testing.T) {
+	depPath := "libs/lib.lua"
+	commitSHA := "0123456789abcdef0123456789abcdef01234567"
+	projectToml := fmt.Sprintf(`
+[package]
+name = "test-version-range"
+version = "0.1.0"
+
+[dependencies.lib]
+source = "github:owner/repo/src/lib.lua@^1.2.0"
+path = "%s"
+`, depPath)
+	tempDir := setupInstallTestEnvironment(t, projectToml, "api_version = \"1\"\n", nil)
+
+	rawPath := fmt.Sprintf("/owner/repo/%s/src/lib.lua", commitSHA)
+	mockServer := startMockHTTPServer(t, map[string]struct {
+		Body string
+		Code int
+	}{
+		"/repos/owner/repo/tags": {Body: `[{"name":"v2.0.0"},{"name":"v1.4.1"},{"name":"v1.3.0"},{"name":"v1.1.0"}]`, Code: http.StatusOK},
+		"/repos/owner/repo/commits?path=src/lib.lua&sha=v1.4.1&per_page=1": {Body: fmt.Sprintf(`[{"sha": "%s"}]`, commitSHA), Code: http.StatusOK},
+		rawPath: {Body: "return 'v1.4.1'", Code: http.StatusOK},
+	})
+
+	originalGHAPIBaseURL := source.GithubAPIBaseURL
+	source.GithubAPIBaseURL = mockServer.URL
+	defer func() { source.GithubAPIBaseURL = originalGHAPIBaseURL }()
+
+	require.NoError(t, runInstallCommand(t, tempDir))
+
+	content, err := os.ReadFile(filepath.Join(tempDir, depPath))
+	require.NoError(t, err)
+	assert.Equal(t, "return 'v1.4.1'", string(content))
+
+	lockCfg := readAlmdLockToml(t, filepath.Join(tempDir, lockfile.LockfileName))
+	entry, ok := lockCfg.Package["lib"]
+	require.True(t, ok, "lib entry not found in almd-lock.toml")
+	assert.Equal(t, "v1.4.1", entry.Tag, "lockfile should record the resolved tag")
+	assert.Equal(t, "commit:"+commitSHA, entry.Hash)
+	assert.Equal(t, mockServer.URL+rawPath, entry.Source)
+
+	projectBytes, err := os.ReadFile(filepath.Join(tempDir, config.ProjectTomlName))
+	require.NoError(t, err)
+	assert.Contains(t, string(projectBytes), "@^1.2.0", "project.toml should keep the range")
+}
+
+func TestInstallCommand_Check(t *testing.T) {
+	lockedSHA := "1111111111111111111111111111111111111111"
+	newSHA := "2222222222222222222222222222222222222222"
+	projectToml := `
+[package]
+name = "test-check"
+version = "0.1.0"
+
+[dependencies.a]
+source = "github:owner/repo/a.lua@main"
+path = "libs/a.lua"
+
+[dependencies.b]
+source = "github:owner/repo/b.lua@main"
+path = "libs/b.lua"
+`
+	lockToml := fmt.Sprintf(`
+api_version = "1"
+
+[package.a]
+source = "https://raw.githubusercontent.com/owner/repo/%[1]s/a.lua"
+path = "libs/a.lua"
+hash = "commit:%[1]s"
+
+[package.b]
+source = "https://raw.githubusercontent.com/owner/repo/%[1]s/b.lua"
+path = "libs/b.lua"
+hash = "commit:%[1]s"
+`, lockedSHA)
+
+	newServer := func(t *testing.T, bSHA string) *httptest.Server {
+		server := startMockHTTPServer(t, map[string]struct {
+			Body string
+			Code int
+		}{
+			"/repos/owner/repo/commits?path=a.lua&sha=main&per_page=1": {Body: fmt.Sprintf(`[{"sha": "%s"}]`, lockedSHA), Code: http.StatusOK},
+			"/repos/owner/repo/commits?path=b.lua&sha=main&per_page=1": {Body: fmt.Sprintf(`[{"sha": "%s"}]`, bSHA), Code: http.StatusOK},
+		})
+		originalGHAPIBaseURL := source.GithubAPIBaseURL
+		source.GithubAPIBaseURL = server.URL
+		t.Cleanup(func() { source.GithubAPIBaseURL = originalGHAPIBaseURL })
+		return server
+	}
+
+	t.Run("in sync", func(t *testing.T) {
+		tempDir := setupInstallTestEnvironment(t, projectToml, lockToml, map[string]string{"libs/a.lua": "a", "libs/b.lua": "b"})
+		newServer(t, lockedSHA)
+
+		var stdout, stderr bytes.Buffer
+		require.NoError(t, runInstallCommandWithIO(t, tempDir, nil, &stdout, &stderr, "--check"))
+		assert.Equal(t, "All 2 targeted dependencies match almd-lock.toml.\n", stdout.String())
+	})
+
+	t.Run("drift", func(t *testing.T) {
+		tempDir := setupInstallTestEnvironment(t, projectToml, lockToml, map[string]string{"libs/b.lua": "b"})
+		newServer(t, newSHA)
+
+		var stdout, stderr bytes.Buffer
+		err := runInstallCommandWithIO(t, tempDir, nil, &stdout, &stderr, "--check")
+		require.Error(t, err)
+		assert.Contains(t, err.Error(), "2 dependenc(ies) need install/update")
+
+		lines := strings.Split(strings.TrimSpace(stdout.String()), "\n")
+		require.Len(t, lines, 2)
+		assert.Equal(t, "a: Local file missing at path: libs/a.lua.", lines[0])
+		assert.True(t, strings.HasPrefix(lines[1], "b: Target commit hash ("+newSHA+")"), lines[1])
+
+		// Nothing is downloaded or written.
+		_, statErr := os.Stat(filepath.Join(tempDir, "libs", "a.lua"))
+		assert.True(t, os.IsNotExist(statErr), "--check must not install missing files")
+		lockBytes, err := os.ReadFile(filepath.Join(tempDir, lockfile.LockfileName))
+		require.NoError(t, err)
+		assert.Equal(t, lockToml, string(lockBytes))
+	})
+
+	t.Run("rejects write flags", func(t *testing.T) {
+		tempDir := setupInstallTestEnvironment(t, projectToml, lockToml, nil)
+		err := runInstallCommand(t, tempDir, "--check", "--force")
+		require.Error(t, err)
+		assert.Contains(t, err.Error(), "--check and --force cannot be used together")
+	})
+}
+
+func TestInstallCommand_DevDependencies(t *testing.T) {
+	commitSHA := "3333333333333333333333333333333333333333"
+	projectToml := `
+[package]
+name = "test-dev-deps"
+version = "0.1.0"
+
+[dependencies.app]
+source = "github:owner/repo/app.lua@main"
+path = "libs/app.lua"
+
+[dev-dependencies.helper]
+source = "github:owner/repo/helper.lua@main"
+path = "libs/helper.lua"
+`
+	pathResps := map[string]struct {
+		Body string
+		Code int
+	}{}
+	for _, file := range []string{"app.lua", "helper.lua"} {
+		pathResps[fmt.Sprintf("/repos/owner/repo/commits?path=%s&sha=main&per_page=1", file)] = struct {
+			Body string
+			Code int
+		}{Body: fmt.Sprintf(`[{"sha": "%s"}]`, commitSHA), Code: http.StatusOK}
+		pathResps[fmt.Sprintf("/owner/repo/%s/%s", commitSHA, file)] = struct {
+			Body string
+			Code int
+		}{Body: "return '" + file + "'", Code: http.StatusOK}
+	}
+	mockServer := startMockHTTPServer(t, pathResps)
+	originalGHAPIBaseURL := source.GithubAPIBaseURL
+	source.GithubAPIBaseURL = mockServer.URL
+	defer func() { source.GithubAPIBaseURL = originalGHAPIBaseURL }()
+
+	t.Run("installs both groups by default", func(t *testing.T) {
+		tempDir := setupInstallTestEnvironment(t, projectToml, "", nil)
+		require.NoError(t, runInstallCommand(t, tempDir))
+		assert.FileExists(t, filepath.Join(tempDir, "libs", "app.lua"))
+		assert.FileExists(t, filepath.Join(tempDir, "libs", "helper.lua"))
+
+		lf, err := lockfile.Load(tempDir)
+		require.NoError(t, err)
+		assert.Empty(t, lf.Package["app"].Group)
+		assert.Equal(t, "dev", lf.Package["helper"].Group)
+	})
+
+	t.Run("--production skips dev dependencies", func(t *testing.T) {
+		tempDir := setupInstallTestEnvironment(t, projectToml, "", nil)
+		require.NoError(t, runInstallCommand(t, tempDir, "--production"))
+		assert.FileExists(t, filepath.Join(tempDir, "libs", "app.lua"))
+		assert.NoFileExists(t, filepath.Join(tempDir, "libs", "helper.lua"))
+
+		var stderr bytes.Buffer
+		require.NoError(t, runInstallCommandWithIO(t, tempDir, nil, io.Discard, &stderr, "--no-dev", "helper"))
+		assert.Contains(t, stderr.String(), "Skipping 'helper'; it is a dev dependency")
+		assert.NoFileExists(t, filepath.Join(tempDir, "libs", "helper.lua"))
+	})
+}
+
+func TestInstallCommand_PlatformConstraints(t *testing.T) {
+	onWindows := installcmd.NewInstallCommandOn("windows", "amd64")
+
+	commitSHA := "abcdefabcdefabcdefabcdefabcdefabcdefabcd"
+	projectToml := fmt.Sprintf(`
+[package]
+name = "test-install-platform"
+version = "0.1.0"
+
+[dependencies.everywhere]
+source = "github:testowner/testrepo/everywhere.lua@%[1]s"
+path = "libs/everywhere.lua"
+
+[dependencies.unixonly]
+source = "github:testowner/testrepo/unixonly.lua@%[1]s"
+path = "libs/unixonly.lua"
+os = ["linux", "darwin"]
+`, commitSHA)
+
+	mockServer := startMockHTTPServer(t, map[string]struct {
+		Body string
+		Code int
+	}{
+		fmt.Sprintf("/testowner/testrepo/%s/everywhere.lua", commitSHA): {Body: "return 'everywhere'", Code: http.StatusOK},
+		fmt.Sprintf("/testowner/testrepo/%s/unixonly.lua", commitSHA):   {Body: "return 'unix'", Code: http.StatusOK},
+	})
+	originalGHAPIBaseURL := source.GithubAPIBaseURL
+	source.GithubAPIBaseURL = mockServer.URL
+	defer func() { source.GithubAPIBaseURL = originalGHAPIBaseURL }()
+
+	tempDir := setupInstallTestEnvironment(t, projectToml, "", nil)
+
+	require.NoError(t, runCommandWithIO(t, onWindows, tempDir, nil, os.Stderr, os.Stderr))
+	assert.FileExists(t, filepath.Join(tempDir, "libs", "everywhere.lua"))
+	assert.NoFileExists(t, filepath.Join(tempDir, "libs", "unixonly.lua"))
+	lockCfg := readAlmdLockToml(t, filepath.Join(tempDir, lockfile.LockfileName))
+	assert.Contains(t, lockCfg.Package, "everywhere")
+	assert.NotContains(t, lockCfg.Package, "unixonly")
+
+	t.Run("naming a constrained dependency explains the skip", func(t *testing.T) {
+		var stdout, stderr bytes.Buffer
+		require.NoError(t, runCommandWithIO(t, onWindows, tempDir, nil, &stdout, &stderr, "unixonly"))
+		assert.Contains(t, stderr.String(), "Note: Skipping 'unixonly'; it is not needed on windows/amd64.")
+		assert.NoFileExists(t, filepath.Join(tempDir, "libs", "unixonly.lua"))
+	})
+
+	t.Run("matching platform installs it", func(t *testing.T) {
+		require.NoError(t, runCommandWithIO(t, installcmd.NewInstallCommandOn("linux", "amd64"), tempDir, nil, os.Stderr, os.Stderr))
+		assert.FileExists(t, filepath.Join(tempDir, "libs", "unixonly.lua"))
+	})
+}
+
+func TestInstallCommand_ChecksumURL(t *testing.T) {
+	commitSHA := "fedcba9876543210fedcba9876543210fedcba98"
+	content := "return 'checked'"
+	contentHash, err := hasher.CalculateSHA256([]byte(content))
+	require.NoError(t, err)
+
+	mockServer := startMockHTTPServer(t, map[string]struct {
+		Body string
+		Code int
+	}{
+		fmt.Sprintf("/testowner/testrepo/%s/checked.lua", commitSHA): {Body: content, Code: http.StatusOK},
+		"/checksums/good.sha256": {Body: strings.TrimPrefix(contentHash, "sha256:") + "  checked.lua\n", Code: http.StatusOK},
+		"/checksums/bad.sha256":  {Body: strings.Repeat("f", 64) + "  checked.lua\n", Code: http.StatusOK},
+	})
+	originalGHAPIBaseURL := source.GithubAPIBaseURL
+	source.GithubAPIBaseURL = mockServer.URL
+	defer func() { source.GithubAPIBaseURL = originalGHAPIBaseURL }()
+
+	projectTomlFor := func(checksumFile string) string {
+		return fmt.Sprintf(`
+[package]
+name = "test-install-checksum"
+version = "0.1.0"
+
+[dependencies.checked]
+source = "github:testowner/testrepo/checked.lua@%s"
+path = "libs/checked.lua"
+checksum_url = "%s/checksums/%s"
+`, commitSHA, mockServer.URL, checksumFile)
+	}
+
+	t.Run("matching checksum", func(t *testing.T) {
+		tempDir := setupInstallTestEnvironment(t, projectTomlFor("good.sha256"), "", nil)
+
+		require.NoError(t, runInstallCommand(t, tempDir))
+		assert.FileExists(t, filepath.Join(tempDir, "libs", "checked.lua"))
+		lockCfg := readAlmdLockToml(t, filepath.Join(tempDir, lockfile.LockfileName))
+		assert.Equal(t, contentHash, lockCfg.Package["checked"].Hash)
+
+		// The published hash does not count as out of date against the commit pin.
+		var stdout bytes.Buffer
+		require.NoError(t, runInstallCommandWithIO(t, tempDir, nil, &stdout, io.Discard))
+		assert.Equal(t, "All targeted dependencies are already up-to-date.\n", stdout.String())
+	})
+
+	t.Run("mismatching checksum", func(t *testing.T) {
+		tempDir := setupInstallTestEnvironment(t, projectTomlFor("bad.sha256"), "", nil)
+
+		var stderr bytes.Buffer
+		err := runInstallCommandWithIO(t, tempDir, nil, io.Discard, &stderr)
+		require.Error(t, err)
+		assert.Contains(t, stderr.String(), "Error: Checksum mismatch for dependency 'checked'")
+		assert.NoFileExists(t, filepath.Join(tempDir, "libs", "checked.lua"))
+	})
+}
+
+func TestInstallCommand_LockedCommitNotOnBranch(t *testing.T) {
+	depPath := "libs/pinned.lua"
+	lockedSHA := "3333333333333333333333333333333333333333"
+	latestSHA := "4444444444444444444444444444444444444444"
+	projectToml := fmt.Sprintf(`
+[package]
+name = "test-install-ancestry"
+version = "0.1.0"
+
+[dependencies.pinned]
+source = "github:testowner/testrepo/pinned.lua@main"
+path = "%s"
+`, depPath)
+	lockToml := fmt.Sprintf(`
+api_version = "1"
+
+[package.pinned]
+source = "https://raw.githubusercontent.com/testowner/testrepo/%[1]s/pinned.lua"
+path = "%[2]s"
+hash = "commit:%[1]s"
+`, lockedSHA, depPath)
+
+	comparePath := fmt.Sprintf("/repos/testowner/testrepo/compare/%s...%s", lockedSHA, latestSHA)
+	tests := []struct {
+		name        string
+		compareBody string
+		compareCode int
+		wantWarning string
+	}{
+		{
+			name:        "diverged commit warns",
+			compareBody: `{"status": "diverged", "ahead_by": 2, "behind_by": 1}`,
+			compareCode: http.StatusOK,
+			wantWarning: "Warning: Locked commit 3333333 for 'pinned' is not reachable from branch 'main' (diverged)",
+		},
+		{
+			name:        "missing commit warns",
+			compareBody: `{"message": "Not Found"}`,
+			compareCode: http.StatusNotFound,
+			wantWarning: "Warning: Locked commit 3333333 for 'pinned' no longer exists in testowner/testrepo",
+		},
+		{
+			name:        "ancestor does not warn",
+			compareBody: `{"status": "ahead", "ahead_by": 2, "behind_by": 0}`,
+			compareCode: http.StatusOK,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mockServer := startMockHTTPServer(t, map[string]struct {
+				Body string
+				Code int
+			}{
+				"/repos/testowner/testrepo/commits?path=pinned.lua&sha=main&per_page=1": {Body: fmt.Sprintf(`[{"sha": "%s"}]`, latestSHA), Code: http.StatusOK},
+				comparePath: {Body: tt.compareBody, Code: tt.compareCode},
+				fmt.Sprintf("/testowner/testrepo/%s/pinned.lua", latestSHA): {Body: "return 'tip'", Code: http.StatusOK},
+			})
+			originalGHAPIBaseURL := source.GithubAPIBaseURL
+			source.GithubAPIBaseURL = mockServer.URL
+			defer func() { source.GithubAPIBaseURL = originalGHAPIBaseURL }()
+
+			tempDir := setupInstallTestEnvironment(t, projectToml, lockToml, map[string]string{depPath: "return 'old'"})
+
+			var stderr bytes.Buffer
+			require.NoError(t, runInstallCommandWithIO(t, tempDir, nil, io.Discard, &stderr))
+			if tt.wantWarning != "" {
+				assert.Contains(t, stderr.String(), tt.wantWarning)
+			} else {
+				assert.NotContains(t, stderr.String(), "Warning")
+			}
+		})
+	}
+}
+
+func TestInstallCommand_FailurePolicy(t *testing.T) {
+	commitSHA := "5555555555555555555555555555555555555555"
+	var projectToml strings.Builder
+	projectToml.WriteString("[package]\nname = \"test-install-fail-policy\"\nversion = \"0.1.0\"\n")
+	for _, name := range []string{"alpha", "bravo", "charlie", "delta"} {
+		fmt.Fprintf(&projectToml, "\n[dependencies.%[1]s]\nsource = \"github:testowner/testrepo/%[1]s.lua@%[2]s\"\npath = \"libs/%[1]s.lua\"\n", name, commitSHA)
+	}
+
+	// bravo and charlie fail to download; alpha and delta succeed.
+	mockServer := startMockHTTPServer(t, map[string]struct {
+		Body string
+		Code int
+	}{
+		fmt.Sprintf("/testowner/testrepo/%s/alpha.lua", commitSHA):   {Body: "return 'alpha'", Code: http.StatusOK},
+		fmt.Sprintf("/testowner/testrepo/%s/bravo.lua", commitSHA):   {Body: "gone", Code: http.StatusNotFound},
+		fmt.Sprintf("/testowner/testrepo/%s/charlie.lua", commitSHA): {Body: "gone", Code: http.StatusNotFound},
+		fmt.Sprintf("/testowner/testrepo/%s/delta.lua", commitSHA):   {Body: "return 'delta'", Code: http.StatusOK},
+	})
+	originalGHAPIBaseURL := source.GithubAPIBaseURL
+	source.GithubAPIBaseURL = mockServer.URL
+	defer func() { source.GithubAPIBaseURL = originalGHAPIBaseURL }()
+
+	t.Run("fail-fast stops at the first failure", func(t *testing.T) {
+		tempDir := setupInstallTestEnvironment(t, projectToml.String(), "", nil)
+
+		var stderr bytes.Buffer
+		err := runInstallCommandWithIO(t, tempDir, nil, io.Discard, &stderr, "--fail-fast")
+		require.Error(t, err)
+		assert.Contains(t, err.Error(), "stopped at the first failure")
+		assert.Contains(t, stderr.String(), "Error: Failed to download dependency 'bravo'")
+		assert.NotContains(t, stderr.String(), "'charlie'", "charlie must not be attempted")
+		assert.Contains(t, stderr.String(), "2 dependenc(ies) were not attempted")
+
+		lockCfg := readAlmdLockToml(t, filepath.Join(tempDir, lockfile.LockfileName))
+		assert.Contains(t, lockCfg.Package, "alpha", "entries installed before the failure are saved")
+		assert.NotContains(t, lockCfg.Package, "delta")
+	})
+
+	for _, args := range [][]string{nil, {"--keep-going"}} {
+		t.Run(fmt.Sprintf("keep-going %v", args), func(t *testing.T) {
+			tempDir := setupInstallTestEnvironment(t, projectToml.String(), "", nil)
+
+			var stdout, stderr bytes.Buffer
+			err := runInstallCommandWithIO(t, tempDir, nil, &stdout, &stderr, append(args, "--summary-only")...)
+			require.NoError(t, err)
+			assert.Contains(t, stderr.String(), "'bravo'")
+			assert.Contains(t, stderr.String(), "'charlie'")
+			assert.Equal(t, "Summary: 2 added, 0 updated, 0 reinstalled, 0 unchanged, 2 failed.\n", stdout.String())
+
+			lockCfg := readAlmdLockToml(t, filepath.Join(tempDir, lockfile.LockfileName))
+			assert.Contains(t, lockCfg.Package, "alpha")
+			assert.Contains(t, lockCfg.Package, "delta")
+		})
+	}
+
+	t.Run("both flags conflict", func(t *testing.T) {
+		tempDir := setupInstallTestEnvironment(t, projectToml.String(), "", nil)
+		err := runInstallCommand(t, tempDir, "--fail-fast", "--keep-going")
+		require.Error(t, err)
+		assert.Contains(t, err.Error(), "cannot be used together")
+	})
+}
+
+func TestInstallCommand_LargeBranchMovePrompts(t *testing.T) {
+	depPath := "libs/movingdep.lua"
+	lockedSHA := "1111111111111111111111111111111111111111"
+	latestSHA := "2222222222222222222222222222222222222222"
+	projectToml := fmt.Sprintf(`
+[package]
+name = "test-install-commit-jump"
+version = "0.1.0"
+
+[dependencies.movingdep]
+source = "github:testowner/testrepo/movingdep.lua@main"
+path = "%s"
+`, depPath)
+	lockToml := fmt.Sprintf(`
+api_version = "1"
+
+[package.movingdep]
+source = "https://raw.githubusercontent.com/testowner/testrepo/%s/movingdep.lua"
+path = "%s"
+hash = "commit:%s"
+`, lockedSHA, depPath, lockedSHA)
+
+	mockServer := startMockHTTPServer(t, map[string]struct {
+		Body string
+		Code int
+	}{
+		"/repos/testowner/testrepo/commits?path=movingdep.lua&sha=main&per_page=1":     {Body: fmt.Sprintf(`[{"sha": "%s"}]`, latestSHA), Code: http.StatusOK},
+		fmt.Sprintf("/repos/testowner/testrepo/compare/%s...%s", lockedSHA, latestSHA): {Body: `{"status": "ahead", "ahead_by": 120, "behind_by": 0}`, Code: http.StatusOK},
+		fmt.Sprintf("/testowner/testrepo/%s/movingdep.lua", latestSHA):                 {Body: "return 'new'", Code: http.StatusOK},
+	})
+	originalGHAPIBaseURL := source.GithubAPIBaseURL
+	source.GithubAPIBaseURL = mockServer.URL
+	defer func() { source.GithubAPIBaseURL = originalGHAPIBaseURL }()
+
+	tests := []struct {
+		name          string
+		stdin         string
+		args          []string
+		expectUpdated bool
+		expectPrompt  bool
+	}{
+		{name: "declined prompt skips update", stdin: "n\n", expectUpdated: false, expectPrompt: true},
+		{name: "accepted prompt applies update", stdin: "y\n", expectUpdated: true, expectPrompt: true},
+		{name: "yes flag skips prompt", args: []string{"--yes"}, expectUpdated: true, expectPrompt: false},
+		{name: "higher threshold skips prompt", args: []string{"--max-commit-jump", "200"}, expectUpdated: true, expectPrompt: false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tempDir := setupInstallTestEnvironment(t, projectToml, lockToml, map[string]string{depPath: "return 'old'"})
+
+			var stdout, stderr bytes.Buffer
+			err := runInstallCommandWithIO(t, tempDir, strings.NewReader(tt.stdin), &stdout, &stderr, tt.args...)
+			require.NoError(t, err)
+
+			if tt.expectPrompt {
+				assert.Contains(t, stderr.String(), "moves 120 commits ahead (1111111 -> 2222222)")
+			} else {
+				assert.NotContains(t, stderr.String(), "commits ahead")
+			}
+
+			content, readErr := os.ReadFile(filepath.Join(tempDir, depPath))
+			require.NoError(t, readErr)
+			lockCfg := readAlmdLockToml(t, filepath.Join(tempDir, lockfile.LockfileName))
+			if tt.expectUpdated {
+				assert.Equal(t, "return 'new'", string(content))
+				assert.Equal(t, "commit:"+latestSHA, lockCfg.Package["movingdep"].Hash)
+			} else {
+				assert.Equal(t, "return 'old'", string(content))
+				assert.Equal(t, "commit:"+lockedSHA, lockCfg.Package["movingdep"].Hash)
+				assert.Contains(t, stderr.String(), "Skipping update of 'movingdep'")
+			}
+		})
+	}
+}
+
+func TestInstallCommand_GenerateRequires(t *testing.T) {
+	commitSHA := "abcabcabcabcabcabcabcabcabcabcabcabcabca"
+	projectToml := fmt.Sprintf(`
+[package]
+name = "test-install-requires"
+version = "0.1.0"
+
+[almd]
+generate_requires = "src/deps.lua"
+
+[dependencies.json]
+source = "github:testowner/testrepo/json.lua@%[1]s"
+path = "src/lib/json.lua"
+
+[dependencies.inspect]
+source = "github:testowner/testrepo/inspect.lua@%[1]s"
+path = "src/lib/inspect.lua"
+
+[dev-dependencies.busted]
+source = "github:testowner/testrepo/busted.lua@%[1]s"
+path = "spec/lib/busted.lua"
+`, commitSHA)
+
+	mockServer := startMockHTTPServer(t, map[string]struct {
+		Body string
+		Code int
+	}{
+		fmt.Sprintf("/testowner/testrepo/%s/json.lua", commitSHA):    {Body: "return 'json'", Code: http.StatusOK},
+		fmt.Sprintf("/testowner/testrepo/%s/inspect.lua", commitSHA): {Body: "return 'inspect'", Code: http.StatusOK},
+		fmt.Sprintf("/testowner/testrepo/%s/busted.lua", commitSHA):  {Body: "return 'busted'", Code: http.StatusOK},
+	})
+	originalGHAPIBaseURL := source.GithubAPIBaseURL
+	source.GithubAPIBaseURL = mockServer.URL
+	defer func() { source.GithubAPIBaseURL = originalGHAPIBaseURL }()
+
+	tempDir := setupInstallTestEnvironment(t, projectToml, "", nil)
+	requiresPath := filepath.Join(tempDir, "src", "deps.lua")
+
+	require.NoError(t, runInstallCommand(t, tempDir))
+	generated, err := os.ReadFile(requiresPath)
+	require.NoError(t, err, "install should generate the requires file configured in [almd]")
+	assert.Contains(t, string(generated), `deps["inspect"] = require("src.lib.inspect")`)
+	assert.Contains(t, string(generated), `deps["json"] = require("src.lib.json")`)
+	assert.FileExists(t, filepath.Join(tempDir, "spec", "lib", "busted.lua"), "the dev dependency is installed")
+	assert.NotContains(t, string(generated), "busted", "dev dependencies are not part of the requires file")
+
+	// Removing a dependency regenerates the file without it.
+	originalWd, err := os.Getwd()
+	require.NoError(t, err)
+	require.NoError(t, os.Chdir(tempDir))
+	removeApp := &cli.App{
+		Commands:       []*cli.Command{remove.RemoveCommand()},
+		Writer:         io.Discard,
+		ErrWriter:      io.Discard,
+		ExitErrHandler: func(context *cli.Context, err error) {},
+	}
+	err = removeApp.Run([]string{"almd-test", "remove", "inspect"})
+	require.NoError(t, os.Chdir(originalWd))
+	require.NoError(t, err)
+
+	generated, err = os.ReadFile(requiresPath)
+	require.NoError(t, err)
+	assert.NotContains(t, string(generated), "inspect")
+	assert.Contains(t, string(generated), `deps["json"] = require("src.lib.json")`)
+}
+
+func TestInstallCommand_CheckDoesNotGenerateRequires(t *testing.T) {
+	commitSHA := "abcabcabcabcabcabcabcabcabcabcabcabcabca"
+	projectToml := fmt.Sprintf(`
+[package]
+name = "test-install-requires-check"
+version = "0.1.0"
+
+[almd]
+generate_requires = "src/deps.lua"
+
+[dependencies.json]
+source = "github:testowner/testrepo/json.lua@%[1]s"
+path = "src/lib/json.lua"
+`, commitSHA)
+	lockToml := fmt.Sprintf(`
+api_version = "1"
+
+[package.json]
+source = "https://raw.githubusercontent.com/testowner/testrepo/%[1]s/json.lua"
+path = "src/lib/json.lua"
+hash = "commit:%[1]s"
+`, commitSHA)
+
+	tempDir := setupInstallTestEnvironment(t, projectToml, lockToml, map[string]string{"src/lib/json.lua": "return 'json'"})
+
+	require.NoError(t, runInstallCommandWithIO(t, tempDir, nil, io.Discard, io.Discard, "--check"))
+	_, err := os.Stat(filepath.Join(tempDir, "src", "deps.lua"))
+	assert.True(t, os.IsNotExist(err), "install --check should not write the requires file")
+}
+
+func TestInstallCommand_GenerateRequiresFlag(t *testing.T) {
+	projectToml := `
+[package]
+name = "test-install-requires-flag"
+version = "0.1.0"
+`
+	tempDir := setupInstallTestEnvironment(t, projectToml, "", nil)
+
+	require.NoError(t, runInstallCommand(t, tempDir, "--generate-requires", "deps.lua"))
+	generated, err := os.ReadFile(filepath.Join(tempDir, "deps.lua"))
+	require.NoError(t, err, "--generate-requires should write the file even with no dependencies")
+	assert.Equal(t, "-- Code generated by almd. DO NOT EDIT.\nlocal deps = {}\nreturn deps\n", string(generated))
+}
+
+func TestInstallCommand_NestedAndBackslashPaths(t *testing.T) {
+	commitSHA := "fedcbafedcbafedcbafedcbafedcbafedcbafedc"
+	// The second path is written Windows-style, as it might be by hand on Windows.
+	projectToml := fmt.Sprintf(`
+[package]
+name = "test-install-paths"
+version = "0.1.0"
+
+[dependencies.deep]
+source = "github:testowner/testrepo/deep.lua@%[1]s"
+path = "libs/a/b/c/deep.lua"
+
+[dependencies.win]
+source = "github:testowner/testrepo/win.lua@%[1]s"
+path = 'libs\win\win.lua'
+`, commitSHA)
+
+	mockServer := startMockHTTPServer(t, map[string]struct {
+		Body string
+		Code int
+	}{
+		fmt.Sprintf("/testowner/testrepo/%s/deep.lua", commitSHA): {Body: "return 'deep'", Code: http.StatusOK},
+		fmt.Sprintf("/testowner/testrepo/%s/win.lua", commitSHA):  {Body: "return 'win'", Code: http.StatusOK},
+	})
+	originalGHAPIBaseURL := source.GithubAPIBaseURL
+	source.GithubAPIBaseURL = mockServer.URL
+	defer func() { source.GithubAPIBaseURL = originalGHAPIBaseURL }()
+
+	tempDir := setupInstallTestEnvironment(t, projectToml, "", nil)
+	require.NoError(t, runInstallCommand(t, tempDir))
+
+	assert.FileExists(t, filepath.Join(tempDir, "libs", "a", "b", "c", "deep.lua"))
+	assert.FileExists(t, filepath.Join(tempDir, "libs", "win", "win.lua"))
+
+	lockCfg := readAlmdLockToml(t, filepath.Join(tempDir, lockfile.LockfileName))
+	assert.Equal(t, "libs/a/b/c/deep.lua", lockCfg.Package["deep"].Path)
+	assert.Equal(t, "libs/win/win.lua", lockCfg.Package["win"].Path, "the lockfile must store forward slashes")
+}
+
+func TestInstallCommand_ReconcilesManifestPath(t *testing.T) {
+	lockedSHA := "1111111111111111111111111111111111111111"
+	projectToml := `
+[package]
+name = "test-path-mismatch"
+version = "0.1.0"
+
+[dependencies.a]
+source = "github:owner/repo/a.lua@main"
+path = "vendor/a.lua"
+`
+	lockToml := fmt.Sprintf(`
+api_version = "1"
+
+[package.a]
+source = "https://raw.githubusercontent.com/owner/repo/%[1]s/a.lua"
+path = "libs/old/a.lua"
+hash = "commit:%[1]s"
+`, lockedSHA)
+
+	newServer := func(t *testing.T) {
+		server := startMockHTTPServer(t, map[string]struct {
+			Body string
+			Code int
+		}{
+			"/repos/owner/repo/commits?path=a.lua&sha=main&per_page=1": {Body: fmt.Sprintf(`[{"sha": "%s"}]`, lockedSHA), Code: http.StatusOK},
+			fmt.Sprintf("/owner/repo/%s/a.lua", lockedSHA):             {Body: "-- downloaded", Code: http.StatusOK},
+		})
+		originalGHAPIBaseURL := source.GithubAPIBaseURL
+		source.GithubAPIBaseURL = server.URL
+		t.Cleanup(func() { source.GithubAPIBaseURL = originalGHAPIBaseURL })
+	}
+
+	t.Run("moves the installed file to the project.toml path", func(t *testing.T) {
+		tempDir := setupInstallTestEnvironment(t, projectToml, lockToml, map[string]string{"libs/old/a.lua": "-- installed"})
+		newServer(t)
+
+		var stdout, stderr bytes.Buffer
+		require.NoError(t, runInstallCommandWithIO(t, tempDir, nil, &stdout, &stderr))
+		assert.Contains(t, stderr.String(), "Note: Moved 'a' from libs/old/a.lua to vendor/a.lua to match project.toml.")
+
+		content, err := os.ReadFile(filepath.Join(tempDir, "vendor", "a.lua"))
+		require.NoError(t, err)
+		assert.Equal(t, "-- installed", string(content), "an up-to-date file is moved, not downloaded again")
+		_, statErr := os.Stat(filepath.Join(tempDir, "libs"))
+		assert.True(t, os.IsNotExist(statErr), "directories the move left empty are removed")
+		assert.Equal(t, "vendor/a.lua", readAlmdLockToml(t, filepath.Join(tempDir, lockfile.LockfileName)).Package["a"].Path)
+	})
+
+	t.Run("installs at the project.toml path when the old file is gone", func(t *testing.T) {
+		tempDir := setupInstallTestEnvironment(t, projectToml, lockToml, nil)
+		newServer(t)
+
+		require.NoError(t, runInstallCommand(t, tempDir))
+		content, err := os.ReadFile(filepath.Join(tempDir, "vendor", "a.lua"))
+		require.NoError(t, err)
+		assert.Equal(t, "-- downloaded", string(content))
+		assert.Equal(t, "vendor/a.lua", readAlmdLockToml(t, filepath.Join(tempDir, lockfile.LockfileName)).Package["a"].Path)
+	})
+
+	t.Run("check reports the mismatch without moving anything", func(t *testing.T) {
+		tempDir := setupInstallTestEnvironment(t, projectToml, lockToml, map[string]string{"libs/old/a.lua": "-- installed"})
+		newServer(t)
+
+		var stdout, stderr bytes.Buffer
+		require.Error(t, runInstallCommandWithIO(t, tempDir, nil, &stdout, &stderr, "--check"))
+		assert.Equal(t, "a: project.toml installs it at vendor/a.lua but almd-lock.toml at libs/old/a.lua.\n", stdout.String())
+		assert.FileExists(t, filepath.Join(tempDir, "libs", "old", "a.lua"))
+	})
+}
+
+func TestInstallCommand_RefOverride(t *testing.T) {
+	mainSHA := "1111111111111111111111111111111111111111"
+	developSHA := "2222222222222222222222222222222222222222"
+	projectToml := `
+[package]
+name = "test-ref-override"
+version = "0.1.0"
+
+[dependencies.a]
+source = "github:owner/repo/a.lua@main"
+path = "libs/a.lua"
+
+[dependencies.b]
+source = "https://example.com/b.lua"
+path = "libs/b.lua"
+`
+	lockToml := fmt.Sprintf(`
+api_version = "1"
+
+[package.a]
+source = "https://raw.githubusercontent.com/owner/repo/%[1]s/a.lua"
+path = "libs/a.lua"
+hash = "commit:%[1]s"
+`, mainSHA)
+
+	server := startMockHTTPServer(t, map[string]struct {
+		Body string
+		Code int
+	}{
+		"/repos/owner/repo/commits?path=a.lua&sha=develop&per_page=1": {Body: fmt.Sprintf(`[{"sha": "%s"}]`, developSHA), Code: http.StatusOK},
+		fmt.Sprintf("/owner/repo/%s/a.lua", developSHA):               {Body: "-- develop", Code: http.StatusOK},
+	})
+	originalGHAPIBaseURL := source.GithubAPIBaseURL
+	source.GithubAPIBaseURL = server.URL
+	t.Cleanup(func() { source.GithubAPIBaseURL = originalGHAPIBaseURL })
+
+	t.Run("installs the override ref without changing project.toml", func(t *testing.T) {
+		tempDir := setupInstallTestEnvironment(t, projectToml, lockToml, map[string]string{"libs/a.lua": "-- main"})
+
+		var stdout, stderr bytes.Buffer
+		require.NoError(t, runInstallCommandWithIO(t, tempDir, nil, &stdout, &stderr, "--ref", "a=develop", "--ref", "ghost=main", "a"))
+		assert.Contains(t, stderr.String(), "Warning: --ref names 'ghost', which is not in project.toml. Ignoring it.")
+
+		content, err := os.ReadFile(filepath.Join(tempDir, "libs", "a.lua"))
+		require.NoError(t, err)
+		assert.Equal(t, "-- develop", string(content))
+		lockCfg := readAlmdLockToml(t, filepath.Join(tempDir, lockfile.LockfileName))
+		assert.Equal(t, "commit:"+developSHA, lockCfg.Package["a"].Hash)
+		assert.Equal(t, "github:owner/repo/a.lua@main", readProjectToml(t, filepath.Join(tempDir, config.ProjectTomlName)).Dependencies["a"].Source)
+	})
+
+	t.Run("non-GitHub and untargeted dependencies are ignored", func(t *testing.T) {
+		tempDir := setupInstallTestEnvironment(t, projectToml, lockToml, nil)
+
+		// --check keeps both runs offline; each reports its dependency as missing.
+		var stderr bytes.Buffer
+		err := runInstallCommandWithIO(t, tempDir, nil, io.Discard, &stderr, "--ref", "b=develop", "--check", "a")
+		require.Error(t, err)
+		assert.Contains(t, stderr.String(), "--ref names 'b', which is not being installed in this run")
+
+		stderr.Reset()
+		err = runInstallCommandWithIO(t, tempDir, nil, io.Discard, &stderr, "--ref", "b=develop", "--check", "b")
+		require.Error(t, err)
+		assert.Contains(t, stderr.String(), "--ref for 'b' needs a GitHub source, but it comes from https://example.com/b.lua")
+	})
+
+	t.Run("malformed override", func(t *testing.T) {
+		tempDir := setupInstallTestEnvironment(t, projectToml, lockToml, nil)
+
+		err := runInstallCommand(t, tempDir, "--ref", "a")
+		require.Error(t, err)
+		assert.Contains(t, err.Error(), "must have the form NAME=REF")
+
+		err = runInstallCommand(t, tempDir, "--ref", "a=main", "--source", "github:fork/repo/a.lua@main", "a")
+		require.Error(t, err)
+		assert.Contains(t, err.Error(), "--source and --ref cannot be used together")
+	})
+}
+
+func TestInstallCommand_FillsUnhashedEntry(t *testing.T) {
+	commitSHA := "2222222222222222222222222222222222222222"
+	projectToml := `
+[package]
+name = "test-no-download"
+version = "0.1.0"
+
+[dependencies.a]
+source = "github:owner/repo/a.lua@main"
+path = "vendor/a.lua"
+`
+	// As written by 'almd add --no-download': the entry exists but has no hash yet.
+	lockToml := `
+api_version = "1"
+
+[package.a]
+source = "https://raw.githubusercontent.com/owner/repo/main/a.lua"
+path = "vendor/a.lua"
+hash = ""
+`
+	newServer := func(t *testing.T) {
+		server := startMockHTTPServer(t, map[string]struct {
+			Body string
+			Code int
+		}{
+			"/repos/owner/repo/commits?path=a.lua&sha=main&per_page=1": {Body: fmt.Sprintf(`[{"sha": "%s"}]`, commitSHA), Code: http.StatusOK},
+			fmt.Sprintf("/owner/repo/%s/a.lua", commitSHA):             {Body: "-- downloaded", Code: http.StatusOK},
+		})
+		originalGHAPIBaseURL := source.GithubAPIBaseURL
+		source.GithubAPIBaseURL = server.URL
+		t.Cleanup(func() { source.GithubAPIBaseURL = originalGHAPIBaseURL })
+	}
+
+	t.Run("install downloads and locks it", func(t *testing.T) {
+		tempDir := setupInstallTestEnvironment(t, projectToml, lockToml, nil)
+		newServer(t)
+
+		require.NoError(t, runInstallCommand(t, tempDir))
+		content, err := os.ReadFile(filepath.Join(tempDir, "vendor", "a.lua"))
+		require.NoError(t, err)
+		assert.Equal(t, "-- downloaded", string(content))
+		assert.Equal(t, "commit:"+commitSHA, readAlmdLockToml(t, filepath.Join(tempDir, lockfile.LockfileName)).Package["a"].Hash)
+	})
+
+	t.Run("frozen lockfile refuses to download it unverified", func(t *testing.T) {
+		tempDir := setupInstallTestEnvironment(t, projectToml, lockToml, nil)
+		newServer(t)
+
+		var stderr bytes.Buffer
+		err := runInstallCommandWithIO(t, tempDir, nil, io.Discard, &stderr, "--frozen-lockfile")
+		require.Error(t, err)
+		assert.Contains(t, stderr.String(), "Error: 'a' has no locked hash yet (added with --no-download).")
+		assert.NoFileExists(t, filepath.Join(tempDir, "vendor", "a.lua"))
+	})
+
+	t.Run("only-missing-lock locks a file supplied by hand", func(t *testing.T) {
+		tempDir := setupInstallTestEnvironment(t, projectToml, lockToml, map[string]string{"vendor/a.lua": "-- supplied"})
+		newServer(t)
+
+		require.NoError(t, runInstallCommand(t, tempDir, "--only-missing-lock"))
+		content, err := os.ReadFile(filepath.Join(tempDir, "vendor", "a.lua"))
+		require.NoError(t, err)
+		assert.Equal(t, "-- supplied", string(content))
+		assert.NotEmpty(t, readAlmdLockToml(t, filepath.Join(tempDir, lockfile.LockfileName)).Package["a"].Hash)
+	})
+}
+
+func TestInstallCommand_OnlyAndExcludeValidation(t *testing.T) {
+	projectToml := `
+[package]
+name = "test-only-exclude"
+version = "0.1.0"
+
+[dependencies.lib]
+source = "github:owner/repo/lib.lua@main"
+path = "libs/lib.lua"
+`
+	tempDir := setupInstallTestEnvironment(t, projectToml, "api_version = \"1\"\n", nil)
+
+	for _, args := range [][]string{
+		{"--only", "lib", "--exclude", "other"},
+		{"--exclude", "other", "lib"},
+	} {
+		err := runInstallCommand(t, tempDir, args...)
+		require.Error(t, err, args)
+		assert.Contains(t, err.Error(), "--exclude")
+	}
+
+	t.Run("unknown names warn", func(t *testing.T) {
+		var stdout, stderr bytes.Buffer
+		require.NoError(t, runInstallCommandWithIO(t, tempDir, nil, &stdout, &stderr, "--only", "missing"))
+		assert.Contains(t, stderr.String(), "Warning: Dependency 'missing' specified for install/update not found in project.toml.")
+		assert.Contains(t, stdout.String(), "No specified dependencies were found")
+
+		stdout.Reset()
+		stderr.Reset()
+		require.NoError(t, runInstallCommandWithIO(t, tempDir, nil, &stdout, &stderr, "--exclude", "lib,missing"))
+		assert.Contains(t, stderr.String(), "Warning: Dependency 'missing' given to --exclude not found in project.toml.")
+		assert.NotContains(t, stderr.String(), "'lib' given to --exclude")
+		assert.Contains(t, stdout.String(), "No dependencies left to install/update after --exclude.")
+		assert.NoFileExists(t, filepath.Join(tempDir, "libs", "lib.lua"))
+	})
+}
+
+func TestInstallCommand_RefreshesBlobSHA(t *testing.T) {
+	oldSHA := "1111111111111111111111111111111111111111"
+	newSHA := "2222222222222222222222222222222222222222"
+	newContent := "return 'v2'\n"
+	projectToml := `
+[package]
+name = "test-blob"
+version = "0.1.0"
+
+[dependencies.foo-init]
+source = "github:owner/repo/lib/foo/init.lua@main"
+path = "vendor/foo/init.lua"
+`
+	lockToml := fmt.Sprintf(`
+api_version = "1"
+
+[package.foo-init]
+source = "https://raw.githubusercontent.com/owner/repo/%s/lib/foo/init.lua"
+path = "vendor/foo/init.lua"
+hash = "commit:%s"
+blob = "%s"
+`, oldSHA, oldSHA, hasher.GitBlobSHA([]byte("return 'v1'\n")))
+	tempDir := setupInstallTestEnvironment(t, projectToml, lockToml, map[string]string{"vendor/foo/init.lua": "return 'v1'\n"})
+
+	mockServer := startMockHTTPServer(t, map[string]struct {
+		Body string
+		Code int
+	}{
+		"/repos/owner/repo/commits?path=lib/foo/init.lua&sha=main&per_page=1": {Body: fmt.Sprintf(`[{"sha": "%s"}]`, newSHA), Code: http.StatusOK},
+		"/owner/repo/" + newSHA + "/lib/foo/init.lua":                         {Body: newContent, Code: http.StatusOK},
+	})
+	originalGHAPIBaseURL := source.GithubAPIBaseURL
+	source.GithubAPIBaseURL = mockServer.URL
+	defer func() { source.GithubAPIBaseURL = originalGHAPIBaseURL }()
+
+	require.NoError(t, runInstallCommand(t, tempDir))
+
+	lf, err := lockfile.Load(tempDir)
+	require.NoError(t, err)
+	assert.Equal(t, "commit:"+newSHA, lf.Package["foo-init"].Hash)
+	assert.Equal(t, hasher.GitBlobSHA([]byte(newContent)), lf.Package["foo-init"].Blob)
+}
@@ -1,17 +1,37 @@
 package list
 
 import (
+	"encoding/json"
 	"fmt"
+	"io"
 	"os"
+	"path/filepath"
+	"runtime"
+	"sort"
+	"strings"
+	"time"
 
 	"github.com/fatih/color"
 	"github.com/urfave/cli/v2"
 
+	"github.com/nightconcept/almandine-go/internal/cli/output"
 	"github.com/nightconcept/almandine-go/internal/core/config"
+	"github.com/nightconcept/almandine-go/internal/core/hasher"
 	"github.com/nightconcept/almandine-go/internal/core/lockfile"
+	"github.com/nightconcept/almandine-go/internal/core/project"
+	"github.com/nightconcept/almandine-go/internal/core/source"
+	"github.com/nightconcept/almandine-go/internal/core/updatecache"
 	// Assuming project root for project.toml and almd-lock.toml
 )
 
+// host is what list learns about the machine it runs on: the platform matched against a
+// dependency's os/arch filters and the clock. Tests build the command with a host of their own
+// instead of changing package state.
+type host struct {
+	os, arch string
+	now      func() time.Time
+}
+
 // dependencyDisplayInfo holds all information needed for displaying a dependency.
 type dependencyDisplayInfo struct {
 	Name           string
@@ -21,133 +41,414 @@ type dependencyDisplayInfo struct {
 	LockedHash     string
 	FileExists     bool
 	IsLocked       bool   // Indicates if an entry exists in the lockfile
+	PlatformSkip   bool   // Indicates the dependency's os/arch filters exclude this platform
+	Dev            bool   // Declared in [dev-dependencies]
+	Group          string // Heading the dependency is listed under with --group-by
 	FileStatusInfo string // Additional info like "missing", "not locked"
+	ContentStatus  string // One of the fileStatus* values; only filled in for --status
+	// UpdateAvailable is set when the update cache holds a fresh, newer commit than the locked one.
+	UpdateAvailable bool
 }
 
-// ListCmd defines the structure for the 'list' command.
-var ListCmd = &cli.Command{
-	Name:    "list",
-	Aliases: []string{"ls"},
-	Usage:   "Displays project dependencies and their status.",
-	Action: func(c *cli.Context) error {
-		projectTomlPath := "project.toml" // This is relative to CWD, LoadProjectToml expects root
-
-		proj, err := config.LoadProjectToml(".")
-		if err != nil {
-			if os.IsNotExist(err) {
-				// Return an error that the test can catch, consistent with other error exits.
-				// The test TestListCommand_ProjectTomlNotFound expects an error.
-				return cli.Exit(fmt.Sprintf("Error: %s not found. No project configuration loaded.", projectTomlPath), 1)
-			}
-			// For other errors during loading
-			return cli.Exit(fmt.Sprintf("Error loading %s: %v", projectTomlPath, err), 1)
+// File states shown by 'list --status', comparing the file on disk with almd-lock.toml.
+const (
+	fileStatusOK         = "ok"
+	fileStatusModified   = "modified"
+	fileStatusMissing    = "missing"
+	fileStatusNotLocked  = "not locked"
+	fileStatusUnverified = "unverified" // locked by commit only, with no content hash to compare
+	fileStatusUnreadable = "unreadable"
+)
+
+// contentStatus compares the file at p with the content hash entry locks, if any.
+func contentStatus(p string, entry lockfile.PackageEntry, locked bool) string {
+	content, err := os.ReadFile(project.NativePath(p))
+	switch {
+	case os.IsNotExist(err):
+		return fileStatusMissing
+	case err != nil:
+		return fileStatusUnreadable
+	case !locked:
+		return fileStatusNotLocked
+	}
+	expected, ok := entry.ExpectedContentHash()
+	if !ok {
+		return fileStatusUnverified
+	}
+	if match, err := hasher.Matches(content, expected); err == nil && match {
+		return fileStatusOK
+	}
+	return fileStatusModified
+}
+
+// Dependency states reported by 'list --json'.
+const (
+	statusLocked      = "locked"
+	statusNotLocked   = "not-locked"
+	statusFileMissing = "file-missing"
+)
+
+// dependencyJSON is one element of the array printed by 'list --json'.
+type dependencyJSON struct {
+	Name       string `json:"name"`
+	Source     string `json:"source"`
+	Path       string `json:"path"`
+	LockedHash string `json:"locked_hash,omitempty"`
+	Status     string `json:"status"`
+	Group      string `json:"group,omitempty"`            // "dev" for [dev-dependencies], as in almd-lock.toml
+	FileStatus string `json:"file_status,omitempty"`      // Only with --status
+	Update     bool   `json:"update_available,omitempty"` // From the update cache; false without a fresh entry
+}
+
+// writeJSON prints deps as a JSON array sorted by name. A dependency without a lockfile entry
+// is not-locked; a locked one whose file is absent is file-missing.
+func writeJSON(w io.Writer, deps []dependencyDisplayInfo) error {
+	out := make([]dependencyJSON, 0, len(deps))
+	for _, dep := range deps {
+		status := statusLocked
+		switch {
+		case !dep.IsLocked:
+			status = statusNotLocked
+		case !dep.FileExists:
+			status = statusFileMissing
+		}
+		group := ""
+		if dep.Dev {
+			group = project.GroupDev
 		}
+		out = append(out, dependencyJSON{
+			Name:       dep.Name,
+			Source:     dep.ProjectSource,
+			Path:       dep.ProjectPath,
+			LockedHash: dep.LockedHash,
+			Status:     status,
+			Group:      group,
+			FileStatus: dep.ContentStatus,
+			Update:     dep.UpdateAvailable,
+		})
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].Name < out[j].Name })
+
+	data, err := json.MarshalIndent(out, "", "  ")
+	if err != nil {
+		return cli.Exit(fmt.Sprintf("Error encoding dependencies: %v", err), 1)
+	}
+	_, _ = fmt.Fprintln(w, string(data))
+	return nil
+}
+
+// loadProject loads project.toml from the current directory for display.
+func loadProject() (*project.Project, error) {
+	projectTomlPath := "project.toml" // This is relative to CWD, LoadProjectToml expects root
+
+	proj, err := config.LoadProjectToml(".")
+	if err != nil {
+		if os.IsNotExist(err) {
+			// Return an error that the test can catch, consistent with other error exits.
+			// The test TestListCommand_ProjectTomlNotFound expects an error.
+			return nil, cli.Exit(fmt.Sprintf("Error: %s not found. No project configuration loaded.", projectTomlPath), 1)
+		}
+		// For other errors during loading
+		return nil, cli.Exit(fmt.Sprintf("Error loading %s: %v", projectTomlPath, err), 1)
+	}
+	return proj, nil
+}
 
-		lf, err := lockfile.Load(".")
-		if err != nil {
-			// lockfile.Load handles "not found" by returning a new lf and no error.
-			// Any error here is likely a more serious issue.
-			return cli.Exit(fmt.Sprintf("Error loading %s: %v", lockfile.LockfileName, err), 1)
+// loadLockfile loads almd-lock.toml from the current directory, or an empty lockfile if there
+// is none.
+func loadLockfile() (*lockfile.Lockfile, error) {
+	lf, err := lockfile.Load(".")
+	if err != nil {
+		// lockfile.Load handles "not found" by returning a new lf and no error.
+		// Any error here is likely a more serious issue.
+		return nil, cli.Exit(fmt.Sprintf("Error loading %s: %v", lockfile.LockfileName, err), 1)
+	}
+	// Ensure lf is not nil, though lockfile.Load should guarantee this if err is nil.
+	if lf == nil {
+		lf = lockfile.New()
+	}
+	return lf, nil
+}
+
+// collectDependencies gathers the display state of every dependency in proj, in no particular
+// order. groupBy ("repo", "dir" or "") selects what Group is filled with. When checkContent is
+// set, each file is also hashed against almd-lock.toml to fill ContentStatus. Problems checking
+// a file are reported to stderr. PlatformSkip is set for dependencies not needed on goos/goarch.
+func collectDependencies(proj *project.Project, lf *lockfile.Lockfile, groupBy string, checkContent bool, goos, goarch string, stderr io.Writer) []dependencyDisplayInfo {
+	var displayDeps []dependencyDisplayInfo
+
+	for name, depDetails := range proj.AllDependencies() {
+		_, depGroup, _ := proj.LookupDependency(name)
+		info := dependencyDisplayInfo{
+			Name:          name,
+			ProjectSource: depDetails.Source,
+			ProjectPath:   depDetails.Path,
+			PlatformSkip:  !depDetails.SupportsPlatform(goos, goarch),
+			Dev:           depGroup == project.GroupDev,
 		}
-		// Ensure lf is not nil, though lockfile.Load should guarantee this if err is nil.
-		if lf == nil {
-			lf = lockfile.New()
+		switch groupBy {
+		case "repo":
+			info.Group = "(unknown repository)"
+			if parsed, err := source.ParseSourceURL(depDetails.Source); err == nil && parsed.Owner != "" && parsed.Repo != "" {
+				info.Group = parsed.Owner + "/" + parsed.Repo
+			}
+		case "dir":
+			info.Group = filepath.ToSlash(filepath.Dir(depDetails.Path))
 		}
 
-		var displayDeps []dependencyDisplayInfo
+		// Check lockfile
+		lockEntry, ok := lf.Package[name]
+		if ok {
+			info.IsLocked = true
+			info.LockedSource = lockEntry.Source
+			info.LockedHash = lockEntry.Hash
+		} else {
+			info.IsLocked = false
+			info.FileStatusInfo = "not locked"
+		}
 
-		// Display project information
-		// Get current working directory for display, or use a placeholder if error
-		wd, err := os.Getwd()
-		if err != nil {
-			wd = "." // Default to current directory symbol if error
+		// Check file existence
+		// project.toml paths are relative to the project root.
+		// The CWD for `almd` execution is assumed to be the project root.
+		if _, err := os.Stat(depDetails.Path); err == nil {
+			info.FileExists = true
+		} else if os.IsNotExist(err) {
+			info.FileExists = false
+			if info.FileStatusInfo != "" {
+				info.FileStatusInfo += ", missing"
+			} else {
+				info.FileStatusInfo = "missing"
+			}
+		} else {
+			// Other error (e.g., permission denied)
+			info.FileExists = false
+			if info.FileStatusInfo != "" {
+				info.FileStatusInfo += ", error checking file"
+			} else {
+				info.FileStatusInfo = "error checking file"
+			}
+			_, _ = fmt.Fprintf(stderr, "Warning: could not check status of %s: %v\n", depDetails.Path, err)
 		}
+		if checkContent {
+			info.ContentStatus = contentStatus(depDetails.Path, lockEntry, info.IsLocked)
+		}
+		displayDeps = append(displayDeps, info)
+	}
+	return displayDeps
+}
 
-		// Updated Color definitions (Task 10.1, User Feedback)
-		projectNameColor := color.New(color.FgMagenta, color.Bold, color.Underline).SprintFunc()
-		projectVersionColor := color.New(color.FgMagenta).SprintFunc() // Version not specified for bold/underline
-		projectPathColor := color.New(color.FgHiBlack, color.Bold, color.Underline).SprintFunc()
-		dependenciesHeaderColor := color.New(color.FgCyan, color.Bold).SprintFunc()
-		// PRD Colors for dependency line: Name (White), Hash (Yellow), Path (DimGray)
-		depNameColor := color.New(color.FgWhite).SprintFunc()
-		depHashColor := color.New(color.FgYellow).SprintFunc()
-		depPathColor := color.New(color.FgHiBlack).SprintFunc()
-		// Standard color for "@"
-		atStr := "@"
-
-		fmt.Printf("%s%s%s %s\n", projectNameColor(proj.Package.Name), atStr, projectVersionColor(proj.Package.Version), projectPathColor(wd))
-		fmt.Println() // Empty line
-
-		if len(proj.Dependencies) == 0 {
-			// Handle Task 8.5: No dependencies found
-			fmt.Println(dependenciesHeaderColor("dependencies:")) // Still print the header
-			// Task 8.5: If project.toml has no [dependencies] table or it's empty,
-			// print an appropriate message.
-			fmt.Println("No dependencies found in project.toml.")
-			return nil
+// markUpdates flags the dependencies whose locked commit the update cache, refreshed by
+// 'almd install' and 'almd outdated', says is no longer the newest. Only entries resolved within
+// updatecache.MaxAge of now count; a missing, stale or unreadable cache marks nothing.
+func markUpdates(deps []dependencyDisplayInfo, now time.Time) {
+	updates, err := updatecache.Load(".")
+	if err != nil {
+		return
+	}
+	for i, dep := range deps {
+		if sha, ok := strings.CutPrefix(dep.LockedHash, "commit:"); ok {
+			deps[i].UpdateAvailable = updates.UpdateAvailable(dep.Name, dep.ProjectSource, sha, now)
 		}
+	}
+}
 
-		fmt.Println(dependenciesHeaderColor("dependencies:"))
-		for name, depDetails := range proj.Dependencies {
-			info := dependencyDisplayInfo{
-				Name:          name,
-				ProjectSource: depDetails.Source,
-				ProjectPath:   depDetails.Path,
+// ListCmd defines the structure for the 'list' command.
+var ListCmd = newListCommand(host{os: runtime.GOOS, arch: runtime.GOARCH, now: time.Now})
+
+// newListCommand creates the 'list' command for h.
+func newListCommand(h host) *cli.Command {
+	return &cli.Command{
+		Name:    "list",
+		Aliases: []string{"ls"},
+		Usage:   "Displays project dependencies and their status.",
+		Flags: []cli.Flag{
+			&cli.BoolFlag{
+				Name:  "paths",
+				Usage: "Print only each dependency's path, one per line and sorted by name",
+			},
+			&cli.StringFlag{
+				Name:  "group-by",
+				Usage: "Group dependencies under their upstream repository (repo) or on-disk directory (dir)",
+			},
+			&cli.BoolFlag{
+				Name:  "json",
+				Usage: "Print the dependencies as a JSON array of objects, sorted by name",
+			},
+			&cli.BoolFlag{
+				Name:  "status",
+				Usage: "Add a column comparing each file with almd-lock.toml: ok, modified, missing or not locked",
+			},
+		},
+		Action: func(c *cli.Context) error {
+			// The listing itself goes to stdout; warnings go to stderr.
+			stdout, stderr := c.App.Writer, c.App.ErrWriter
+			proj, err := loadProject()
+			if err != nil {
+				return err
 			}
 
-			// Check lockfile
-			if lockEntry, ok := lf.Package[name]; ok {
-				info.IsLocked = true
-				info.LockedSource = lockEntry.Source
-				info.LockedHash = lockEntry.Hash
-			} else {
-				info.IsLocked = false
-				info.FileStatusInfo = "not locked"
-			}
-
-			// Check file existence
-			// project.toml paths are relative to the project root.
-			// The CWD for `almd` execution is assumed to be the project root.
-			if _, err := os.Stat(depDetails.Path); err == nil {
-				info.FileExists = true
-			} else if os.IsNotExist(err) {
-				info.FileExists = false
-				if info.FileStatusInfo != "" {
-					info.FileStatusInfo += ", missing"
-				} else {
-					info.FileStatusInfo = "missing"
+			groupBy := c.String("group-by")
+			if groupBy != "" && groupBy != "repo" && groupBy != "dir" {
+				return cli.Exit(fmt.Sprintf("Error: --group-by must be 'repo' or 'dir', got '%s'.", groupBy), 1)
+			}
+
+			if c.Bool("json") && (c.Bool("paths") || groupBy != "") {
+				return cli.Exit("Error: --json cannot be combined with --paths or --group-by.", 1)
+			}
+			if c.Bool("paths") && c.Bool("status") {
+				return cli.Exit("Error: --paths cannot be combined with --status.", 1)
+			}
+
+			// --paths is meant for shell pipelines, so it prints nothing but the paths.
+			if c.Bool("paths") {
+				deps := proj.AllDependencies()
+				names := make([]string, 0, len(deps))
+				for name := range deps {
+					names = append(names, name)
 				}
-			} else {
-				// Other error (e.g., permission denied)
-				info.FileExists = false
-				if info.FileStatusInfo != "" {
-					info.FileStatusInfo += ", error checking file"
-				} else {
-					info.FileStatusInfo = "error checking file"
+				sort.Strings(names)
+				for _, name := range names {
+					_, _ = fmt.Fprintln(stdout, deps[name].Path)
 				}
-				fmt.Fprintf(os.Stderr, "Warning: could not check status of %s: %v\n", depDetails.Path, err)
+				return nil
 			}
-			displayDeps = append(displayDeps, info)
-		}
 
-		// Default Output Formatting (Task 8.4)
-		// TODO: Add handling for --long, --json, --porcelain flags later based on PRD.
-		// For now, implementing only the default format.
+			lf, err := loadLockfile()
+			if err != nil {
+				return err
+			}
+
+			displayDeps := collectDependencies(proj, lf, groupBy, c.Bool("status"), h.os, h.arch, stderr)
+			markUpdates(displayDeps, h.now())
 
-		// The earlier check for len(proj.Dependencies) == 0 handles the "no dependencies" case.
-		// If we reach here, displayDeps should have items if proj.Dependencies had items.
-		for _, dep := range displayDeps {
-			lockedHash := "not locked"
-			if dep.IsLocked && dep.LockedHash != "" {
-				lockedHash = dep.LockedHash
-			} else if dep.IsLocked && dep.LockedHash == "" {
-				lockedHash = "locked (no hash)"
+			// --json is meant for other tools, so stdout holds the JSON array and nothing else.
+			if c.Bool("json") {
+				return writeJSON(stdout, displayDeps)
 			}
 
-			// PRD format: Name Hash Path
-			// Apply PRD colors: Dependency Name (White), Hash (Yellow), Path (DimGray)
-			fmt.Printf("%s %s %s\n", depNameColor(dep.Name), depHashColor(lockedHash), depPathColor(dep.ProjectPath))
-		}
-		return nil
-	},
+			// Display project information
+			// Get current working directory for display, or use a placeholder if error
+			wd, err := os.Getwd()
+			if err != nil {
+				wd = "." // Default to current directory symbol if error
+			}
+
+			// Updated Color definitions (Task 10.1, User Feedback)
+			projectNameColor := output.NewColor(color.FgMagenta, color.Bold, color.Underline).SprintFunc()
+			projectVersionColor := output.NewColor(color.FgMagenta).SprintFunc() // Version not specified for bold/underline
+			projectPathColor := output.NewColor(color.FgHiBlack, color.Bold, color.Underline).SprintFunc()
+			dependenciesHeaderColor := output.NewColor(color.FgCyan, color.Bold).SprintFunc()
+			// PRD Colors for dependency line: Name (White), Hash (Yellow), Path (DimGray)
+			depNameColor := output.NewColor(color.FgWhite).SprintFunc()
+			depHashColor := output.NewColor(color.FgYellow).SprintFunc()
+			depPathColor := output.NewColor(color.FgHiBlack).SprintFunc()
+			// --status column: green when the file matches, red when it does not.
+			statusColors := map[string]func(a ...interface{}) string{
+				fileStatusOK:       output.NewColor(color.FgGreen).SprintFunc(),
+				fileStatusModified: output.NewColor(color.FgRed).SprintFunc(),
+				fileStatusMissing:  output.NewColor(color.FgRed).SprintFunc(),
+			}
+			updateColor := output.NewColor(color.FgYellow).SprintFunc()
+			// Standard color for "@"
+			atStr := "@"
+
+			// [package] is optional; without a name the project is shown under its directory name.
+			projectName, projectVersion := proj.PackageName(), proj.PackageVersion()
+			if projectName == "" {
+				projectName = filepath.Base(wd)
+			}
+			if projectVersion == "" {
+				_, _ = fmt.Fprintf(stdout, "%s %s\n", projectNameColor(projectName), projectPathColor(wd))
+			} else {
+				_, _ = fmt.Fprintf(stdout, "%s%s%s %s\n", projectNameColor(projectName), atStr, projectVersionColor(projectVersion), projectPathColor(wd))
+			}
+			_, _ = fmt.Fprintln(stdout) // Empty line
+
+			if len(proj.Dependencies) == 0 && len(proj.DevDependencies) == 0 {
+				// Handle Task 8.5: No dependencies found
+				_, _ = fmt.Fprintln(stdout, dependenciesHeaderColor("dependencies:")) // Still print the header
+				// Task 8.5: If project.toml has no [dependencies] table or it's empty,
+				// print an appropriate message.
+				_, _ = fmt.Fprintln(stdout, "No dependencies found in project.toml.")
+				return nil
+			}
+
+			// Default Output Formatting (Task 8.4)
+			// TODO: Add handling for --long and --porcelain flags later based on PRD.
+
+			// The earlier check for len(proj.Dependencies) == 0 handles the "no dependencies" case.
+			// If we reach here, displayDeps should have items if proj.Dependencies had items.
+			printDep := func(dep dependencyDisplayInfo, indent string) {
+				lockedHash := "not locked"
+				if dep.IsLocked && dep.LockedHash != "" {
+					lockedHash = dep.LockedHash
+				} else if dep.IsLocked && dep.LockedHash == "" {
+					lockedHash = "locked (no hash)"
+				}
+
+				// PRD format: Name Hash Path
+				// Apply PRD colors: Dependency Name (White), Hash (Yellow), Path (DimGray)
+				// Grouped output mixes both tables, so dev dependencies are labelled on their line.
+				label := ""
+				if dep.ContentStatus != "" {
+					status := dep.ContentStatus
+					if colorize, ok := statusColors[status]; ok {
+						status = colorize(status)
+					}
+					label = " " + status
+				}
+				if dep.Dev && groupBy != "" {
+					label += " (dev)"
+				}
+				if dep.UpdateAvailable {
+					label += " " + updateColor("(update available)")
+				}
+				if dep.PlatformSkip {
+					_, _ = fmt.Fprintf(stdout, "%s%s %s %s skipped (platform)%s\n", indent, depNameColor(dep.Name), depHashColor(lockedHash), depPathColor(dep.ProjectPath), label)
+					return
+				}
+				_, _ = fmt.Fprintf(stdout, "%s%s %s %s%s\n", indent, depNameColor(dep.Name), depHashColor(lockedHash), depPathColor(dep.ProjectPath), label)
+			}
+
+			if groupBy == "" {
+				// [dependencies] first, then [dev-dependencies] under a heading of their own.
+				for i, header := range []string{"dependencies:", "dev-dependencies:"} {
+					wantDev := i == 1
+					if wantDev && len(proj.DevDependencies) == 0 || !wantDev && len(proj.Dependencies) == 0 {
+						continue
+					}
+					if wantDev && len(proj.Dependencies) > 0 {
+						_, _ = fmt.Fprintln(stdout)
+					}
+					_, _ = fmt.Fprintln(stdout, dependenciesHeaderColor(header))
+					for _, dep := range displayDeps {
+						if dep.Dev == wantDev {
+							printDep(dep, "")
+						}
+					}
+				}
+				return nil
+			}
+			_, _ = fmt.Fprintln(stdout, dependenciesHeaderColor("dependencies:"))
+
+			// Grouped output: headings sorted by name, each followed by its dependencies sorted by name.
+			sort.Slice(displayDeps, func(i, j int) bool {
+				if displayDeps[i].Group != displayDeps[j].Group {
+					return displayDeps[i].Group < displayDeps[j].Group
+				}
+				return displayDeps[i].Name < displayDeps[j].Name
+			})
+			groupCounts := make(map[string]int)
+			for _, dep := range displayDeps {
+				groupCounts[dep.Group]++
+			}
+			for i, dep := range displayDeps {
+				if i == 0 || displayDeps[i-1].Group != dep.Group {
+					_, _ = fmt.Fprintf(stdout, "%s (%d)\n", dep.Group, groupCounts[dep.Group])
+				}
+				printDep(dep, "  ")
+			}
+			return nil
+		},
+	}
 }
@@ -2,11 +2,14 @@ package list
 
 import (
 	"bytes"
+	"encoding/json"
 	"fmt"
 	"os"
 	"path/filepath"
+	"runtime"
 	"strings"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
@@ -14,6 +17,7 @@ import (
 	"github.com/urfave/cli/v2"
 
 	"github.com/nightconcept/almandine-go/internal/core/config"
+	"github.com/nightconcept/almandine-go/internal/core/updatecache"
 	// "github.com/nightconcept/almandine-go/internal/core/project" // Will be needed when other tests are implemented
 )
 
@@ -51,6 +55,12 @@ func setupListTestEnvironment(t *testing.T, projectTomlContent string, lockfileC
 // It changes the CWD to testDir for the duration of the command execution.
 func runListCommand(t *testing.T, testDir string, appArgs ...string) (string, error) {
 	t.Helper()
+	return runListCommandOn(t, ListCmd, testDir, appArgs...)
+}
+
+// runListCommandOn is runListCommand with list, a 'list' command, standing in for ListCmd.
+func runListCommandOn(t *testing.T, list *cli.Command, testDir string, appArgs ...string) (string, error) {
+	t.Helper()
 
 	originalStdout := os.Stdout
 	r, w, _ := os.Pipe()
@@ -75,7 +85,8 @@ func runListCommand(t *testing.T, testDir string, appArgs ...string) (string, er
 
 	app := &cli.App{
 		Commands: []*cli.Command{
-			ListCmd, // Assumes ListCmd is defined in the current 'list' package
+			list,
+			TreeCmd,
 		},
 		// Prevent os.Exit from being called by urfave/cli during tests
 		ExitErrHandler: func(context *cli.Context, err error) {
@@ -432,3 +443,396 @@ func getProjectDetails(t *testing.T, projectTomlPath string) (name, version stri
 	return proj.Package.Name, proj.Package.Version
 }
 */
+
+func TestListCommand_OutputStreams(t *testing.T) {
+	projectTomlContent := `
+[package]
+name = "stream-project"
+version = "1.0.0"
+
+[dependencies.gooddep]
+source = "github:owner/repo/good.lua@main"
+path = "libs/good.lua"
+
+[dependencies.baddep]
+source = "github:owner/repo/bad.lua@main"
+path = "libs/notadir.lua/bad.lua"
+`
+	// libs/notadir.lua is a regular file, so stat-ing a path beneath it fails with an
+	// error other than "not exist", which list reports as a warning.
+	tempDir := setupListTestEnvironment(t, projectTomlContent, "", map[string]string{
+		"libs/good.lua":    "-- good",
+		"libs/notadir.lua": "-- a file, not a directory",
+	})
+
+	originalWD, err := os.Getwd()
+	require.NoError(t, err)
+	require.NoError(t, os.Chdir(tempDir))
+	defer func() { require.NoError(t, os.Chdir(originalWD)) }()
+	t.Setenv("NO_COLOR", "1")
+
+	var stdout, stderr bytes.Buffer
+	app := &cli.App{
+		Commands:       []*cli.Command{ListCmd},
+		Writer:         &stdout,
+		ErrWriter:      &stderr,
+		ExitErrHandler: func(context *cli.Context, err error) {},
+	}
+	require.NoError(t, app.Run([]string{"almd", "list"}))
+
+	assert.Contains(t, stdout.String(), "stream-project@1.0.0")
+	assert.Contains(t, stdout.String(), "gooddep not locked libs/good.lua")
+	assert.Contains(t, stdout.String(), "baddep not locked libs/notadir.lua/bad.lua")
+	assert.NotContains(t, stdout.String(), "Warning")
+	assert.Contains(t, stderr.String(), "Warning: could not check status of libs/notadir.lua/bad.lua")
+	assert.NotContains(t, stderr.String(), "gooddep")
+}
+
+func TestListCommand_PlatformSkippedDependency(t *testing.T) {
+	onWindows := newListCommand(host{os: "windows", arch: "amd64", now: time.Now})
+
+	projectTomlContent := `
+[package]
+name = "platform-project"
+version = "1.0.0"
+
+[dependencies.everywhere]
+source = "github:owner/repo/everywhere.lua@main"
+path = "libs/everywhere.lua"
+
+[dependencies.unixonly]
+source = "github:owner/repo/unixonly.lua@main"
+path = "libs/unixonly.lua"
+os = ["linux", "darwin"]
+`
+	tempDir := setupListTestEnvironment(t, projectTomlContent, "", map[string]string{
+		"libs/everywhere.lua": "-- everywhere",
+	})
+
+	output, err := runListCommandOn(t, onWindows, tempDir, "list")
+	require.NoError(t, err)
+	assert.Contains(t, output, "everywhere not locked libs/everywhere.lua\n")
+	assert.Contains(t, output, "unixonly not locked libs/unixonly.lua skipped (platform)\n")
+}
+
+func TestListCommand_Paths(t *testing.T) {
+	projectTomlContent := `
+[package]
+name = "paths-project"
+version = "1.0.0"
+
+[dependencies.zeta]
+source = "github:owner/repo/zeta.lua@main"
+path = "libs/zeta.lua"
+
+[dependencies.alpha]
+source = "github:owner/repo/alpha.lua@main"
+path = "vendor/alpha.lua"
+`
+	tempDir := setupListTestEnvironment(t, projectTomlContent, "", nil)
+
+	output, err := runListCommand(t, tempDir, "list", "--paths")
+	require.NoError(t, err)
+	assert.Equal(t, "vendor/alpha.lua\nlibs/zeta.lua\n", output)
+}
+
+func TestListCommand_WithoutPackageTable(t *testing.T) {
+	projectTomlContent := `
+[dependencies.json]
+source = "github:rxi/json.lua/json.lua@master"
+path = "libs/json.lua"
+`
+	tempDir := setupListTestEnvironment(t, projectTomlContent, "", map[string]string{"libs/json.lua": "-- json"})
+
+	output, err := runListCommand(t, tempDir, "list")
+	require.NoError(t, err)
+	lines := strings.Split(output, "\n")
+	require.NotEmpty(t, lines)
+	assert.True(t, strings.HasPrefix(lines[0], filepath.Base(tempDir)+" "), "the directory name stands in for the package name, got %q", lines[0])
+	assert.NotContains(t, lines[0], "@")
+	assert.Contains(t, output, "json not locked libs/json.lua")
+}
+
+func TestListCommand_GroupBy(t *testing.T) {
+	projectTomlContent := `
+[package]
+name = "grouped-project"
+version = "1.0.0"
+
+[dependencies.json]
+source = "github:owneralpha/repoa/json.lua@main"
+path = "libs/json.lua"
+
+[dependencies.class]
+source = "github:owneralpha/repoa/class.lua@main"
+path = "vendor/class.lua"
+
+[dependencies.inspect]
+source = "github:ownerbeta/repob/inspect.lua@main"
+path = "libs/inspect.lua"
+`
+	tempDir := setupListTestEnvironment(t, projectTomlContent, "", nil)
+
+	t.Run("repo", func(t *testing.T) {
+		output, err := runListCommand(t, tempDir, "list", "--group-by", "repo")
+		require.NoError(t, err)
+		assert.Contains(t, output, "dependencies:\n"+
+			"owneralpha/repoa (2)\n"+
+			"  class not locked vendor/class.lua\n"+
+			"  json not locked libs/json.lua\n"+
+			"ownerbeta/repob (1)\n"+
+			"  inspect not locked libs/inspect.lua\n")
+	})
+
+	t.Run("dir", func(t *testing.T) {
+		output, err := runListCommand(t, tempDir, "list", "--group-by", "dir")
+		require.NoError(t, err)
+		assert.Contains(t, output, "dependencies:\n"+
+			"libs (2)\n"+
+			"  inspect not locked libs/inspect.lua\n"+
+			"  json not locked libs/json.lua\n"+
+			"vendor (1)\n"+
+			"  class not locked vendor/class.lua\n")
+	})
+
+	t.Run("invalid", func(t *testing.T) {
+		_, err := runListCommand(t, tempDir, "list", "--group-by", "owner")
+		require.Error(t, err)
+		assert.Contains(t, err.Error(), "--group-by must be 'repo' or 'dir'")
+	})
+}
+
+func TestListCommand_JSON(t *testing.T) {
+	projectTomlContent := `
+[package]
+name = "json-project"
+version = "1.0.0"
+
+[dependencies.zeta]
+source = "github:owner/repo/zeta.lua@main"
+path = "libs/zeta.lua"
+
+[dependencies.alpha]
+source = "github:owner/repo/alpha.lua@main"
+path = "libs/alpha.lua"
+
+[dependencies.mid]
+source = "github:owner/repo/mid.lua@main"
+path = "libs/mid.lua"
+`
+	lockfileContent := `
+api_version = "1"
+
+[package.alpha]
+source = "https://raw.githubusercontent.com/owner/repo/main/alpha.lua"
+path = "libs/alpha.lua"
+hash = "sha256:aaa"
+
+[package.mid]
+source = "https://raw.githubusercontent.com/owner/repo/main/mid.lua"
+path = "libs/mid.lua"
+hash = "sha256:bbb"
+`
+	tempDir := setupListTestEnvironment(t, projectTomlContent, lockfileContent, map[string]string{"libs/alpha.lua": "-- alpha"})
+
+	output, err := runListCommand(t, tempDir, "list", "--json")
+	require.NoError(t, err)
+
+	var deps []dependencyJSON
+	require.NoError(t, json.Unmarshal([]byte(output), &deps), "stdout must be nothing but JSON, got %q", output)
+	assert.Equal(t, []dependencyJSON{
+		{Name: "alpha", Source: "github:owner/repo/alpha.lua@main", Path: "libs/alpha.lua", LockedHash: "sha256:aaa", Status: statusLocked},
+		{Name: "mid", Source: "github:owner/repo/mid.lua@main", Path: "libs/mid.lua", LockedHash: "sha256:bbb", Status: statusFileMissing},
+		{Name: "zeta", Source: "github:owner/repo/zeta.lua@main", Path: "libs/zeta.lua", Status: statusNotLocked},
+	}, deps)
+
+	t.Run("no dependencies prints an empty array", func(t *testing.T) {
+		emptyDir := setupListTestEnvironment(t, "[package]\nname = \"empty\"\n", "", nil)
+		output, err := runListCommand(t, emptyDir, "list", "--json")
+		require.NoError(t, err)
+		assert.Equal(t, "[]\n", output)
+	})
+}
+
+func TestListCommand_DevDependencies(t *testing.T) {
+	projectTomlContent := `
+[package]
+name = "dev-project"
+version = "1.0.0"
+
+[dependencies.app]
+source = "github:owner/repo/app.lua@main"
+path = "libs/app.lua"
+
+[dev-dependencies.helper]
+source = "github:owner/repo/helper.lua@main"
+path = "libs/helper.lua"
+`
+	tempDir := setupListTestEnvironment(t, projectTomlContent, "", nil)
+
+	output, err := runListCommand(t, tempDir, "list")
+	require.NoError(t, err)
+	assert.Contains(t, output, "dependencies:\napp not locked libs/app.lua\n\ndev-dependencies:\nhelper not locked libs/helper.lua\n")
+
+	output, err = runListCommand(t, tempDir, "list", "--group-by", "dir")
+	require.NoError(t, err)
+	assert.Contains(t, output, "  app not locked libs/app.lua\n")
+	assert.Contains(t, output, "  helper not locked libs/helper.lua (dev)\n")
+
+	output, err = runListCommand(t, tempDir, "list", "--json")
+	require.NoError(t, err)
+	assert.Contains(t, output, `"group": "dev"`)
+	assert.Equal(t, 1, strings.Count(output, `"group"`), "only dev dependencies carry a group")
+}
+
+func TestListCommand_Status(t *testing.T) {
+	projectTomlContent := `
+[package]
+name = "status-project"
+version = "1.0.0"
+
+[dependencies.clean]
+source = "github:owner/repo/clean.lua@main"
+path = "libs/clean.lua"
+
+[dependencies.edited]
+source = "github:owner/repo/edited.lua@main"
+path = "libs/edited.lua"
+
+[dependencies.gone]
+source = "github:owner/repo/gone.lua@main"
+path = "libs/gone.lua"
+
+[dependencies.fresh]
+source = "github:owner/repo/fresh.lua@main"
+path = "libs/fresh.lua"
+
+[dependencies.pinned]
+source = "github:owner/repo/pinned.lua@main"
+path = "libs/pinned.lua"
+`
+	lockfileContent := `
+api_version = "1"
+
+[package.clean]
+source = "https://raw.githubusercontent.com/owner/repo/main/clean.lua"
+path = "libs/clean.lua"
+hash = "sha256:c5a42c550ff12e5d2a4a198ecf582c3e1a54e3599cc3b13bc8958e9d3fb5d941"
+
+[package.edited]
+source = "https://raw.githubusercontent.com/owner/repo/main/edited.lua"
+path = "libs/edited.lua"
+hash = "sha256:c5a42c550ff12e5d2a4a198ecf582c3e1a54e3599cc3b13bc8958e9d3fb5d941"
+
+[package.gone]
+source = "https://raw.githubusercontent.com/owner/repo/main/gone.lua"
+path = "libs/gone.lua"
+hash = "sha256:c5a42c550ff12e5d2a4a198ecf582c3e1a54e3599cc3b13bc8958e9d3fb5d941"
+
+[package.pinned]
+source = "https://raw.githubusercontent.com/owner/repo/abc123/pinned.lua"
+path = "libs/pinned.lua"
+hash = "commit:abc123"
+`
+	tempDir := setupListTestEnvironment(t, projectTomlContent, lockfileContent, map[string]string{
+		"libs/clean.lua":  "contentA", // sha256("contentA") is the locked hash
+		"libs/edited.lua": "contentA, edited by hand",
+		"libs/fresh.lua":  "-- fresh",
+		"libs/pinned.lua": "-- pinned",
+	})
+
+	output, err := runListCommand(t, tempDir, "list")
+	require.NoError(t, err)
+	assert.NotContains(t, output, " ok\n", "the status column is only shown with --status")
+
+	output, err = runListCommand(t, tempDir, "list", "--status")
+	require.NoError(t, err)
+	assert.Contains(t, output, "clean sha256:c5a42c550ff12e5d2a4a198ecf582c3e1a54e3599cc3b13bc8958e9d3fb5d941 libs/clean.lua ok\n")
+	assert.Contains(t, output, "edited sha256:c5a42c550ff12e5d2a4a198ecf582c3e1a54e3599cc3b13bc8958e9d3fb5d941 libs/edited.lua modified\n")
+	assert.Contains(t, output, "libs/gone.lua missing\n")
+	assert.Contains(t, output, "fresh not locked libs/fresh.lua not locked\n")
+	assert.Contains(t, output, "pinned commit:abc123 libs/pinned.lua unverified\n")
+
+	output, err = runListCommand(t, tempDir, "list", "--json", "--status")
+	require.NoError(t, err)
+	var deps []dependencyJSON
+	require.NoError(t, json.Unmarshal([]byte(output), &deps))
+	statuses := make(map[string]string, len(deps))
+	for _, dep := range deps {
+		statuses[dep.Name] = dep.FileStatus
+	}
+	assert.Equal(t, map[string]string{
+		"clean":  fileStatusOK,
+		"edited": fileStatusModified,
+		"fresh":  fileStatusNotLocked,
+		"gone":   fileStatusMissing,
+		"pinned": fileStatusUnverified,
+	}, statuses)
+
+	_, err = runListCommand(t, tempDir, "list", "--paths", "--status")
+	require.Error(t, err)
+}
+
+func TestListCommand_UpdateAvailable(t *testing.T) {
+	lockedSHA := "1111111111111111111111111111111111111111"
+	newerSHA := "2222222222222222222222222222222222222222"
+	projectTomlContent := `
+[package]
+name = "updates-project"
+version = "1.0.0"
+
+[dependencies.moved]
+source = "github:owner/repo/moved.lua@main"
+path = "libs/moved.lua"
+
+[dependencies.stale]
+source = "github:owner/repo/stale.lua@main"
+path = "libs/stale.lua"
+
+[dependencies.current]
+source = "github:owner/repo/current.lua@main"
+path = "libs/current.lua"
+`
+	lockfileContent := fmt.Sprintf(`
+api_version = "1"
+
+[package.moved]
+source = "https://raw.githubusercontent.com/owner/repo/%[1]s/moved.lua"
+path = "libs/moved.lua"
+hash = "commit:%[1]s"
+
+[package.stale]
+source = "https://raw.githubusercontent.com/owner/repo/%[1]s/stale.lua"
+path = "libs/stale.lua"
+hash = "commit:%[1]s"
+
+[package.current]
+source = "https://raw.githubusercontent.com/owner/repo/%[1]s/current.lua"
+path = "libs/current.lua"
+hash = "commit:%[1]s"
+`, lockedSHA)
+	tempDir := setupListTestEnvironment(t, projectTomlContent, lockfileContent, nil)
+
+	output, err := runListCommand(t, tempDir, "list")
+	require.NoError(t, err)
+	assert.NotContains(t, output, "update available", "without a cache no hint is shown")
+
+	now := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	frozen := newListCommand(host{os: runtime.GOOS, arch: runtime.GOARCH, now: func() time.Time { return now }})
+	updates := updatecache.New()
+	updates.Record("moved", "github:owner/repo/moved.lua@main", newerSHA, now.Add(-time.Hour))
+	updates.Record("stale", "github:owner/repo/stale.lua@main", newerSHA, now.Add(-updatecache.MaxAge-time.Hour))
+	updates.Record("current", "github:owner/repo/current.lua@main", lockedSHA, now)
+	require.NoError(t, updatecache.Save(tempDir, updates))
+
+	output, err = runListCommandOn(t, frozen, tempDir, "list")
+	require.NoError(t, err)
+	assert.Contains(t, output, "moved commit:"+lockedSHA+" libs/moved.lua (update available)\n")
+	assert.Contains(t, output, "stale commit:"+lockedSHA+" libs/stale.lua\n", "a stale cache entry is ignored")
+	assert.Contains(t, output, "current commit:"+lockedSHA+" libs/current.lua\n")
+
+	output, err = runListCommandOn(t, frozen, tempDir, "list", "--json")
+	require.NoError(t, err)
+	assert.Contains(t, output, `"update_available": true`)
+	assert.Equal(t, 1, strings.Count(output, "update_available"))
+}
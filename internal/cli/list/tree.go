@@ -0,0 +1,147 @@
+package list
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"runtime"
+	"sort"
+	"strings"
+
+	"github.com/fatih/color"
+	"github.com/urfave/cli/v2"
+
+	"github.com/nightconcept/almandine-go/internal/cli/output"
+	"github.com/nightconcept/almandine-go/internal/core/hasher"
+	"github.com/nightconcept/almandine-go/internal/core/project"
+)
+
+// treeNode is a directory in the tree printed by 'almd tree'. Only directories that contain a
+// dependency, directly or further down, are ever created.
+type treeNode struct {
+	dirs map[string]*treeNode
+	deps []dependencyDisplayInfo
+}
+
+// add places dep under the directories named by dirs, creating them as needed.
+func (n *treeNode) add(dirs []string, dep dependencyDisplayInfo) {
+	if len(dirs) == 0 {
+		n.deps = append(n.deps, dep)
+		return
+	}
+	if n.dirs == nil {
+		n.dirs = make(map[string]*treeNode)
+	}
+	child, ok := n.dirs[dirs[0]]
+	if !ok {
+		child = &treeNode{}
+		n.dirs[dirs[0]] = child
+	}
+	child.add(dirs[1:], dep)
+}
+
+// shortHash abbreviates a lockfile hash for display, keeping the algorithm prefix (e.g.
+// "sha256:") so content hashes are not mistaken for commits.
+func shortHash(hash string) string {
+	if sha, ok := strings.CutPrefix(hash, "commit:"); ok {
+		return shortSHA(sha)
+	}
+	if algo, ok := hasher.Algorithm(hash); ok {
+		return algo + ":" + shortSHA(strings.TrimPrefix(hash, algo+":"))
+	}
+	return hash
+}
+
+// shortSHA abbreviates a hex digest to seven characters.
+func shortSHA(sha string) string {
+	if len(sha) > 7 {
+		return sha[:7]
+	}
+	return sha
+}
+
+// printTree writes the children of n, directories first, each level sorted by name.
+func printTree(w io.Writer, n *treeNode, indent string) {
+	dirColor := output.NewColor(color.FgBlue, color.Bold).SprintFunc()
+	depNameColor := output.NewColor(color.FgWhite).SprintFunc()
+	depHashColor := output.NewColor(color.FgYellow).SprintFunc()
+
+	dirNames := make([]string, 0, len(n.dirs))
+	for name := range n.dirs {
+		dirNames = append(dirNames, name)
+	}
+	sort.Strings(dirNames)
+	sort.Slice(n.deps, func(i, j int) bool { return n.deps[i].ProjectPath < n.deps[j].ProjectPath })
+
+	total := len(dirNames) + len(n.deps)
+	branch := func(i int) (string, string) {
+		if i == total-1 {
+			return "└── ", "    "
+		}
+		return "├── ", "│   "
+	}
+	for i, name := range dirNames {
+		connector, childIndent := branch(i)
+		_, _ = fmt.Fprintf(w, "%s%s%s\n", indent, connector, dirColor(name+"/"))
+		printTree(w, n.dirs[name], indent+childIndent)
+	}
+	for i, dep := range n.deps {
+		connector, _ := branch(len(dirNames) + i)
+		hash := "not locked"
+		if dep.IsLocked {
+			hash = shortHash(dep.LockedHash)
+		}
+		_, _ = fmt.Fprintf(w, "%s%s%s (%s) %s\n", indent, connector, path.Base(project.NormalizePath(dep.ProjectPath)), depNameColor(dep.Name), depHashColor(hash))
+	}
+}
+
+// TreeCmd defines the structure for the 'tree' command.
+var TreeCmd = &cli.Command{
+	Name:  "tree",
+	Usage: "Displays project dependencies grouped by the directories they are installed in.",
+	Action: func(c *cli.Context) error {
+		// The tree goes to stdout; warnings go to stderr.
+		stdout, stderr := c.App.Writer, c.App.ErrWriter
+		proj, err := loadProject()
+		if err != nil {
+			return err
+		}
+		lf, err := loadLockfile()
+		if err != nil {
+			return err
+		}
+
+		// As in 'list', the directory name stands in for a missing package name.
+		rootName := proj.PackageName()
+		if rootName == "" {
+			wd, err := os.Getwd()
+			if err != nil {
+				wd = "."
+			}
+			rootName = filepath.Base(wd)
+		}
+		if version := proj.PackageVersion(); version != "" {
+			rootName += "@" + version
+		}
+		_, _ = fmt.Fprintln(stdout, output.NewColor(color.FgMagenta, color.Bold).Sprint(rootName))
+
+		if len(proj.Dependencies) == 0 && len(proj.DevDependencies) == 0 {
+			_, _ = fmt.Fprintln(stdout, "No dependencies found in project.toml.")
+			return nil
+		}
+
+		root := &treeNode{}
+		for _, dep := range collectDependencies(proj, lf, "", false, runtime.GOOS, runtime.GOARCH, stderr) {
+			dir := path.Dir(project.NormalizePath(dep.ProjectPath))
+			var dirs []string
+			if dir != "." {
+				dirs = strings.Split(strings.TrimPrefix(dir, "/"), "/")
+			}
+			root.add(dirs, dep)
+		}
+		printTree(stdout, root, "")
+		return nil
+	},
+}
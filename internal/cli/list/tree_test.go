@@ -0,0 +1,62 @@
+package list
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestTreeCommand(t *testing.T) {
+	projectTomlContent := `
+[package]
+name = "tree-project"
+version = "1.2.0"
+
+[dependencies.alpha]
+source = "github:owner/repo/alpha.lua@main"
+path = "src/lib/alpha.lua"
+
+[dependencies.nested]
+source = "github:owner/repo/nested.lua@main"
+path = "src/lib/util/nested.lua"
+
+[dependencies.vendored]
+source = "github:owner/repo/vendored.lua@main"
+path = "vendor\\vendored.lua"
+`
+	lockfileContent := `
+api_version = "1"
+
+[package.alpha]
+source = "https://raw.githubusercontent.com/owner/repo/0123456789abcdef0123456789abcdef01234567/alpha.lua"
+path = "src/lib/alpha.lua"
+hash = "commit:0123456789abcdef0123456789abcdef01234567"
+
+[package.vendored]
+source = "https://raw.githubusercontent.com/owner/repo/main/vendored.lua"
+path = "vendor/vendored.lua"
+hash = "sha256:fedcba9876543210fedcba9876543210fedcba9876543210fedcba9876543210"
+`
+	tempDir := setupListTestEnvironment(t, projectTomlContent, lockfileContent, nil)
+
+	output, err := runListCommand(t, tempDir, "tree")
+	require.NoError(t, err)
+	assert.Equal(t, `tree-project@1.2.0
+├── src/
+│   └── lib/
+│       ├── util/
+│       │   └── nested.lua (nested) not locked
+│       └── alpha.lua (alpha) 0123456
+└── vendor/
+    └── vendored.lua (vendored) sha256:fedcba9
+`, output)
+}
+
+func TestTreeCommand_NoDependencies(t *testing.T) {
+	tempDir := setupListTestEnvironment(t, "[package]\nname = \"empty\"\n", "", nil)
+
+	output, err := runListCommand(t, tempDir, "tree")
+	require.NoError(t, err)
+	assert.Equal(t, "empty\nNo dependencies found in project.toml.\n", output)
+}
@@ -0,0 +1,215 @@
+// Title: Almandine CLI Lock Command
+// Purpose: Implements the 'lock' command, which brings almd-lock.toml in line with project.toml
+// by resolving every dependency to a commit and raw URL, without downloading dependency files
+// or touching anything on disk besides the lockfile.
+package lock
+
+import (
+	"fmt"
+	"os"
+	"path"
+	"sort"
+	"strings"
+
+	"github.com/urfave/cli/v2"
+
+	"github.com/nightconcept/almandine-go/internal/core/config"
+	"github.com/nightconcept/almandine-go/internal/core/downloader"
+	"github.com/nightconcept/almandine-go/internal/core/lockfile"
+	"github.com/nightconcept/almandine-go/internal/core/project"
+	"github.com/nightconcept/almandine-go/internal/core/source"
+)
+
+// shortSHA abbreviates a commit SHA for display.
+func shortSHA(sha string) string {
+	if len(sha) > 7 {
+		return sha[:7]
+	}
+	return sha
+}
+
+// target is what almd-lock.toml should record for one dependency.
+type target struct {
+	rawURL    string
+	path      string
+	integrity string
+	tag       string // Tag a version range resolved to, if any
+}
+
+// resolve works out the lockfile entry for dep from its source alone. A GitHub dependency is
+// locked to the commit its ref resolves to, or to its published checksum; a plain HTTP file has
+// no revision, so only an existing entry for the same URL can be kept. A checksum URL must pass
+// policyHooks before it is fetched.
+func resolve(client *source.Client, name string, dep project.Dependency, parsed *source.ParsedSourceInfo, existing lockfile.PackageEntry, locked bool, policyHooks ...source.Hook) (target, error) {
+	t := target{path: project.NormalizePath(dep.Path)}
+	if parsed.Provider != "github" {
+		if locked && existing.Source == parsed.RawURL {
+			t.rawURL, t.integrity = existing.Source, existing.Hash
+			return t, nil
+		}
+		return t, fmt.Errorf("%s has no commit to lock; its content hash needs a download, so run 'almd install %s'", parsed.RawURL, name)
+	}
+
+	if parsed.VersionRange != "" {
+		resolved, err := client.ResolveVersionRange(parsed)
+		if err != nil {
+			return t, fmt.Errorf("could not resolve version range '%s': %w", parsed.VersionRange, err)
+		}
+		parsed, t.tag = resolved, resolved.Ref
+	}
+
+	sha := parsed.Ref
+	switch {
+	case source.IsAbbreviatedCommitSHA(parsed.Provider, parsed.Ref):
+		// A short SHA the lockfile already expanded is reused without asking the API again.
+		sha = strings.TrimPrefix(existing.Hash, "commit:")
+		if len(sha) != 40 || !strings.HasPrefix(sha, parsed.Ref) {
+			var err error
+			if sha, err = client.ExpandCommitSHA(parsed.Owner, parsed.Repo, parsed.Ref); err != nil {
+				return t, fmt.Errorf("could not expand short commit SHA '%s': %w", parsed.Ref, err)
+			}
+		}
+	case !source.IsImmutableRef(parsed.Provider, parsed.Ref):
+		var err error
+		if sha, err = client.GetLatestCommitSHAForFile(parsed.Owner, parsed.Repo, parsed.PathInRepo, parsed.Ref); err != nil {
+			return t, fmt.Errorf("could not resolve ref '%s': %w", parsed.Ref, err)
+		}
+	}
+	t.rawURL = parsed.RawURLAt(sha)
+	t.integrity = "commit:" + sha
+
+	// A published checksum is the lockfile hash, as it is for 'almd install'. Only the small
+	// checksum file is fetched, never the dependency itself.
+	if dep.ChecksumURL != "" {
+		checksumURL, err := source.CheckURL(dep.ChecksumURL, policyHooks...)
+		if err != nil {
+			return t, fmt.Errorf("checksum URL %s rejected by policy: %w", dep.ChecksumURL, err)
+		}
+		hash, err := downloader.FetchChecksum(checksumURL, path.Base(parsed.PathInRepo))
+		if err != nil {
+			return t, fmt.Errorf("could not fetch checksum: %w", err)
+		}
+		t.integrity = hash
+	}
+	return t, nil
+}
+
+// NewLockCommand creates the 'lock' command.
+func NewLockCommand() *cli.Command {
+	return &cli.Command{
+		Name:  "lock",
+		Usage: "Updates almd-lock.toml from project.toml without downloading or changing dependency files",
+		Description: "Resolves every dependency in project.toml to a commit SHA and raw URL and records it in\n" +
+			"   almd-lock.toml, dropping entries for dependencies no longer declared. Dependency files are\n" +
+			"   never downloaded or written, so local changes to them are kept; 'almd verify' reports any\n" +
+			"   that no longer match the new entries.",
+		Flags: []cli.Flag{
+			&cli.BoolFlag{
+				Name:  "dry-run",
+				Usage: "Report what would change without writing almd-lock.toml",
+			},
+		},
+		Action: func(c *cli.Context) error {
+			// Each changed entry is reported on stdout; warnings go to stderr.
+			stdout, stderr := c.App.Writer, c.App.ErrWriter
+			dryRun := c.Bool("dry-run")
+
+			release, err := lockfile.Acquire(".")
+			if err != nil {
+				return cli.Exit(fmt.Sprintf("Error: %v", err), 1)
+			}
+			defer release()
+
+			proj, err := config.LoadProjectToml(".")
+			if err != nil {
+				if os.IsNotExist(err) {
+					return cli.Exit(fmt.Sprintf("Error: %s not found. Run 'almd init' first.", config.ProjectTomlName), 1)
+				}
+				return cli.Exit(fmt.Sprintf("Error loading %s: %v", config.ProjectTomlName, err), 1)
+			}
+			lf, err := lockfile.Load(".")
+			if err != nil {
+				return cli.Exit(fmt.Sprintf("Error loading %s: %v", lockfile.LockfileName, err), 1)
+			}
+
+			declared := proj.AllDependencies()
+			names := make([]string, 0, len(declared))
+			for name := range declared {
+				names = append(names, name)
+			}
+			sort.Strings(names)
+
+			policyHooks := []source.Hook{source.AllowedHostsHook(proj.AllowedHosts())}
+			client := source.DefaultClient()
+			var added, updated, removed, failed int
+			for _, name := range names {
+				dep, group, _ := proj.LookupDependency(name)
+				parsed, err := source.ParseSourceURL(dep.Source)
+				if err == nil {
+					err = source.ApplyHooks(parsed, policyHooks...)
+				}
+				if err != nil {
+					_, _ = fmt.Fprintf(stderr, "Warning: Could not use source for '%s' (%s): %v. Skipping.\n", name, dep.Source, err)
+					failed++
+					continue
+				}
+
+				existing, locked := lf.Package[name]
+				t, err := resolve(client, name, dep, parsed, existing, locked, policyHooks...)
+				if err != nil {
+					_, _ = fmt.Fprintf(stderr, "Warning: Could not lock '%s': %v. Skipping.\n", name, err)
+					failed++
+					continue
+				}
+
+				switch {
+				case !locked:
+					added++
+					_, _ = fmt.Fprintf(stdout, "+ %s %s\n", name, strings.TrimPrefix(t.integrity, "commit:"))
+				case existing.Source != t.rawURL || existing.Hash != t.integrity || project.NormalizePath(existing.Path) != t.path:
+					updated++
+					_, _ = fmt.Fprintf(stdout, "~ %s %s -> %s\n", name, shortSHA(strings.TrimPrefix(existing.Hash, "commit:")), shortSHA(strings.TrimPrefix(t.integrity, "commit:")))
+				default:
+					// Unchanged; only metadata such as the group may need refreshing.
+					lf.SetTag(name, t.tag)
+					lf.SetGroup(name, group)
+					continue
+				}
+				lf.AddOrUpdatePackage(name, t.rawURL, t.path, t.integrity)
+				lf.SetTag(name, t.tag)
+				lf.SetGroup(name, group)
+			}
+
+			orphans := make([]string, 0)
+			for name := range lf.Package {
+				if _, ok := declared[name]; !ok {
+					orphans = append(orphans, name)
+				}
+			}
+			sort.Strings(orphans)
+			for _, name := range orphans {
+				removed++
+				_, _ = fmt.Fprintf(stdout, "- %s\n", name)
+				delete(lf.Package, name)
+			}
+
+			switch {
+			case added+updated+removed == 0 && !lf.Migrated():
+				_, _ = fmt.Fprintf(stdout, "%s is up to date.\n", lockfile.LockfileName)
+			case dryRun:
+				_, _ = fmt.Fprintf(stdout, "Dry run: %s was not changed (%d to add, %d to update, %d to remove).\n", lockfile.LockfileName, added, updated, removed)
+			default:
+				lf.ApiVersion = lockfile.APIVersion
+				if err := lockfile.Save(".", lf); err != nil {
+					return cli.Exit(fmt.Sprintf("Error: Failed to save %s: %v", lockfile.LockfileName, err), 1)
+				}
+				_, _ = fmt.Fprintf(stdout, "Updated %s: %d added, %d updated, %d removed. Files on disk were not changed.\n", lockfile.LockfileName, added, updated, removed)
+			}
+
+			if failed > 0 {
+				return cli.Exit(fmt.Sprintf("Error: %d dependenc(ies) could not be locked.", failed), 1)
+			}
+			return nil
+		},
+	}
+}
@@ -0,0 +1,209 @@
+package lock
+
+import (
+	"bytes"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/urfave/cli/v2"
+
+	"github.com/nightconcept/almandine-go/internal/core/config"
+	"github.com/nightconcept/almandine-go/internal/core/lockfile"
+	"github.com/nightconcept/almandine-go/internal/core/source"
+)
+
+const (
+	branchTipSHA = "1234567890abcdef1234567890abcdef12345678"
+	staleSHA     = "0000000000000000000000000000000000000000"
+	fixedSHA     = "fedcba0987654321fedcba0987654321fedcba09"
+)
+
+const lockProjectToml = `
+[package]
+name = "test-lock"
+version = "0.1.0"
+
+[dependencies.branchdep]
+source = "github:owner/repo/branch.lua@main"
+path = "libs/branch.lua"
+
+[dependencies.commitdep]
+source = "github:owner/repo/commit.lua@` + fixedSHA + `"
+path = "libs/commit.lua"
+`
+
+// startMockGitHubAPI serves the commits endpoint for owner/repo, where "main" is at
+// branchTipSHA, and points the default source client at it. Any request for file content fails
+// the test.
+func startMockGitHubAPI(t *testing.T) {
+	t.Helper()
+	mux := http.NewServeMux()
+	mux.HandleFunc("/repos/owner/repo/commits", func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "main", r.URL.Query().Get("sha"), "only branch refs should be resolved")
+		_, _ = fmt.Fprintf(w, `[{"sha": "%s"}]`, branchTipSHA)
+	})
+	mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+		t.Errorf("unexpected request %s", r.URL)
+		http.NotFound(w, r)
+	})
+	server := httptest.NewServer(mux)
+	t.Cleanup(server.Close)
+
+	original := source.GithubAPIBaseURL
+	source.GithubAPIBaseURL = server.URL
+	t.Cleanup(func() { source.GithubAPIBaseURL = original })
+}
+
+// runLockCommand runs 'lock' in workDir and returns its stdout, stderr and error.
+func runLockCommand(t *testing.T, workDir string, args ...string) (string, string, error) {
+	t.Helper()
+
+	originalWd, err := os.Getwd()
+	require.NoError(t, err)
+	require.NoError(t, os.Chdir(workDir))
+	defer func() { require.NoError(t, os.Chdir(originalWd)) }()
+
+	var stdout, stderr bytes.Buffer
+	app := &cli.App{
+		Name:           "almd-test-lock",
+		Commands:       []*cli.Command{NewLockCommand()},
+		Writer:         &stdout,
+		ErrWriter:      &stderr,
+		ExitErrHandler: func(context *cli.Context, err error) {},
+	}
+	err = app.Run(append([]string{"almd-test-lock", "lock"}, args...))
+	return stdout.String(), stderr.String(), err
+}
+
+// setupLockProject writes project.toml, a lockfile with a stale branchdep entry and an orphan,
+// and a locally edited libs/branch.lua.
+func setupLockProject(t *testing.T) string {
+	t.Helper()
+	tempDir := t.TempDir()
+	require.NoError(t, os.WriteFile(filepath.Join(tempDir, config.ProjectTomlName), []byte(lockProjectToml), 0644))
+
+	lf := lockfile.New()
+	lf.AddOrUpdatePackage("branchdep", "https://raw.githubusercontent.com/owner/repo/"+staleSHA+"/branch.lua", "libs/branch.lua", "commit:"+staleSHA)
+	lf.AddOrUpdatePackage("orphan", "https://raw.githubusercontent.com/owner/repo/"+staleSHA+"/orphan.lua", "libs/orphan.lua", "commit:"+staleSHA)
+	require.NoError(t, lockfile.Save(tempDir, lf))
+
+	require.NoError(t, os.MkdirAll(filepath.Join(tempDir, "libs"), 0755))
+	require.NoError(t, os.WriteFile(filepath.Join(tempDir, "libs", "branch.lua"), []byte("-- local edit"), 0644))
+	require.NoError(t, os.WriteFile(filepath.Join(tempDir, "libs", "orphan.lua"), []byte("-- orphan"), 0644))
+	return tempDir
+}
+
+func TestLockCommand_ResolvesWithoutTouchingFiles(t *testing.T) {
+	startMockGitHubAPI(t)
+	tempDir := setupLockProject(t)
+
+	stdout, _, err := runLockCommand(t, tempDir)
+	require.NoError(t, err)
+	assert.Contains(t, stdout, "~ branchdep 0000000 -> 1234567\n")
+	assert.Contains(t, stdout, "+ commitdep "+fixedSHA+"\n")
+	assert.Contains(t, stdout, "- orphan\n")
+	assert.Contains(t, stdout, "Updated almd-lock.toml: 1 added, 1 updated, 1 removed.")
+
+	lf, err := lockfile.Load(tempDir)
+	require.NoError(t, err)
+	require.Len(t, lf.Package, 2)
+	assert.Equal(t, lockfile.PackageEntry{
+		Source: "https://raw.githubusercontent.com/owner/repo/" + branchTipSHA + "/branch.lua",
+		Path:   "libs/branch.lua",
+		Hash:   "commit:" + branchTipSHA,
+	}, lf.Package["branchdep"])
+	assert.Equal(t, "https://raw.githubusercontent.com/owner/repo/"+fixedSHA+"/commit.lua", lf.Package["commitdep"].Source)
+	assert.Equal(t, "commit:"+fixedSHA, lf.Package["commitdep"].Hash)
+
+	// Files on disk are left exactly as they were, including the orphan's and the missing one.
+	content, err := os.ReadFile(filepath.Join(tempDir, "libs", "branch.lua"))
+	require.NoError(t, err)
+	assert.Equal(t, "-- local edit", string(content))
+	assert.FileExists(t, filepath.Join(tempDir, "libs", "orphan.lua"))
+	assert.NoFileExists(t, filepath.Join(tempDir, "libs", "commit.lua"))
+
+	// A second run finds nothing to change.
+	stdout, _, err = runLockCommand(t, tempDir)
+	require.NoError(t, err)
+	assert.Equal(t, "almd-lock.toml is up to date.\n", stdout)
+}
+
+func TestLockCommand_DryRun(t *testing.T) {
+	startMockGitHubAPI(t)
+	tempDir := setupLockProject(t)
+	before, err := os.ReadFile(filepath.Join(tempDir, lockfile.LockfileName))
+	require.NoError(t, err)
+
+	stdout, _, err := runLockCommand(t, tempDir, "--dry-run")
+	require.NoError(t, err)
+	assert.Contains(t, stdout, "~ branchdep 0000000 -> 1234567\n")
+	assert.Contains(t, stdout, "Dry run: almd-lock.toml was not changed (1 to add, 1 to update, 1 to remove).")
+
+	after, err := os.ReadFile(filepath.Join(tempDir, lockfile.LockfileName))
+	require.NoError(t, err)
+	assert.Equal(t, string(before), string(after))
+}
+
+func TestLockCommand_HTTPSourceNeedsInstall(t *testing.T) {
+	startMockGitHubAPI(t)
+	tempDir := t.TempDir()
+	projectToml := `
+[package]
+name = "test-lock"
+
+[dependencies.plain]
+source = "https://example.com/plain.lua"
+path = "libs/plain.lua"
+`
+	require.NoError(t, os.WriteFile(filepath.Join(tempDir, config.ProjectTomlName), []byte(projectToml), 0644))
+
+	_, stderr, err := runLockCommand(t, tempDir)
+	require.Error(t, err)
+	assert.Contains(t, stderr, "Could not lock 'plain'")
+	assert.Contains(t, stderr, "run 'almd install plain'")
+	assert.NoFileExists(t, filepath.Join(tempDir, lockfile.LockfileName))
+
+	// An entry already locked for the same URL is kept as it is.
+	lf := lockfile.New()
+	lf.AddOrUpdatePackage("plain", "https://example.com/plain.lua", "libs/plain.lua", "sha256:abc")
+	require.NoError(t, lockfile.Save(tempDir, lf))
+	stdout, _, err := runLockCommand(t, tempDir)
+	require.NoError(t, err)
+	assert.Equal(t, "almd-lock.toml is up to date.\n", stdout)
+}
+
+func TestLockCommand_ChecksumURLMustPassPolicy(t *testing.T) {
+	startMockGitHubAPI(t)
+	checksumServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		t.Errorf("a checksum URL outside allowed_hosts must not be fetched, got %s", r.URL)
+	}))
+	t.Cleanup(checksumServer.Close)
+
+	tempDir := t.TempDir()
+	projectToml := `
+[package]
+name = "test-lock"
+
+[almd]
+allowed_hosts = ["raw.githubusercontent.com"]
+
+[dependencies.branchdep]
+source = "github:owner/repo/branch.lua@main"
+path = "libs/branch.lua"
+checksum_url = "` + checksumServer.URL + `/SHA256SUMS"
+`
+	require.NoError(t, os.WriteFile(filepath.Join(tempDir, config.ProjectTomlName), []byte(projectToml), 0644))
+
+	_, stderr, err := runLockCommand(t, tempDir)
+	require.Error(t, err)
+	assert.Contains(t, stderr, "Could not lock 'branchdep'")
+	assert.Contains(t, stderr, "rejected by policy")
+	assert.ErrorContains(t, err, "1 dependenc(ies) could not be locked")
+	assert.NoFileExists(t, filepath.Join(tempDir, lockfile.LockfileName))
+}
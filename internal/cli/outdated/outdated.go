@@ -0,0 +1,165 @@
+// Title: Almandine CLI Outdated Command
+// Purpose: Implements the 'outdated' command, which reports dependencies whose upstream ref
+// has moved past the commit recorded in almd-lock.toml. Nothing is downloaded and neither
+// project.toml nor almd-lock.toml is written; only the update cache that 'almd list' reads is
+// refreshed with the commits found.
+package outdated
+
+import (
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/fatih/color"
+	"github.com/urfave/cli/v2"
+
+	"github.com/nightconcept/almandine-go/internal/cli/output"
+	"github.com/nightconcept/almandine-go/internal/core/config"
+	"github.com/nightconcept/almandine-go/internal/core/lockfile"
+	"github.com/nightconcept/almandine-go/internal/core/source"
+	"github.com/nightconcept/almandine-go/internal/core/updatecache"
+)
+
+// shortSHA abbreviates a commit SHA for display.
+func shortSHA(sha string) string {
+	if len(sha) > 7 {
+		return sha[:7]
+	}
+	return sha
+}
+
+// lockedCommit returns the commit entry was installed from: the SHA in a "commit:" hash, or
+// else the ref of its locked source URL when that ref is a fixed revision.
+func lockedCommit(entry lockfile.PackageEntry) string {
+	if sha, ok := strings.CutPrefix(entry.Hash, "commit:"); ok {
+		return sha
+	}
+	parsed, err := source.ParseSourceURL(entry.Source)
+	if err != nil || !source.IsImmutableRef(parsed.Provider, parsed.Ref) {
+		return ""
+	}
+	return parsed.Ref
+}
+
+// declaredNames returns names as a set.
+func declaredNames(names []string) map[string]bool {
+	set := make(map[string]bool, len(names))
+	for _, name := range names {
+		set[name] = true
+	}
+	return set
+}
+
+// NewOutdatedCommand creates the 'outdated' command.
+func NewOutdatedCommand() *cli.Command {
+	return newOutdatedCommand(time.Now)
+}
+
+// newOutdatedCommand creates the 'outdated' command, which stamps the update cache with the time
+// from now.
+func newOutdatedCommand(now func() time.Time) *cli.Command {
+	return &cli.Command{
+		Name:  "outdated",
+		Usage: "Lists dependencies with newer upstream commits than the ones locked",
+		Action: func(c *cli.Context) error {
+			// The table and summary go to stdout; notes and warnings go to stderr.
+			stdout, stderr := c.App.Writer, c.App.ErrWriter
+
+			proj, err := config.LoadProjectToml(".")
+			if err != nil {
+				if os.IsNotExist(err) {
+					return cli.Exit(fmt.Sprintf("Error: %s not found. Run 'almd init' first.", config.ProjectTomlName), 1)
+				}
+				return cli.Exit(fmt.Sprintf("Error loading %s: %v", config.ProjectTomlName, err), 1)
+			}
+			lf, err := lockfile.Load(".")
+			if err != nil {
+				return cli.Exit(fmt.Sprintf("Error loading %s: %v", lockfile.LockfileName, err), 1)
+			}
+			deps := proj.AllDependencies()
+			if len(deps) == 0 {
+				_, _ = fmt.Fprintf(stdout, "No dependencies found in %s.\n", config.ProjectTomlName)
+				return nil
+			}
+
+			names := make([]string, 0, len(deps))
+			for name := range deps {
+				names = append(names, name)
+			}
+			sort.Strings(names)
+
+			// A cache that cannot be read is simply rebuilt from this run.
+			updates, err := updatecache.Load(".")
+			if err != nil {
+				updates = updatecache.New()
+			}
+			updates.Prune(declaredNames(names))
+			resolvedAt := now()
+
+			markColor := output.NewColor(color.FgYellow).SprintFunc()
+			table := tabwriter.NewWriter(stdout, 0, 0, 2, ' ', 0)
+			_, _ = fmt.Fprintln(table, "NAME\tLOCKED\tLATEST\t")
+
+			checked, outdated := 0, 0
+			for _, name := range names {
+				dep := deps[name]
+				parsed, err := source.ParseSourceURL(dep.Source)
+				if err != nil {
+					_, _ = fmt.Fprintf(stderr, "Warning: Skipping '%s': cannot parse source '%s': %v\n", name, dep.Source, err)
+					continue
+				}
+				if parsed.Provider != "github" {
+					_, _ = fmt.Fprintf(stderr, "Note: Skipping '%s': upstream commits can only be checked for GitHub sources.\n", name)
+					continue
+				}
+				// A version range is checked against the newest tag it currently matches.
+				if parsed, err = source.ResolveVersionRange(parsed); err != nil {
+					_, _ = fmt.Fprintf(stderr, "Warning: Could not resolve version range for '%s': %v\n", name, err)
+					_, _ = fmt.Fprintf(table, "%s\t%s\t%s\t\n", name, "-", "error")
+					continue
+				}
+
+				locked := "not locked"
+				lockedSHA := ""
+				if entry, ok := lf.Package[name]; ok {
+					lockedSHA = lockedCommit(entry)
+					locked = "unknown"
+					if lockedSHA != "" {
+						locked = shortSHA(lockedSHA)
+					}
+				}
+
+				latestSHA, err := source.GetLatestCommitSHAForFile(parsed.Owner, parsed.Repo, parsed.PathInRepo, parsed.Ref)
+				if err != nil {
+					_, _ = fmt.Fprintf(stderr, "Warning: Could not resolve '%s' for '%s': %v\n", parsed.Ref, name, err)
+					_, _ = fmt.Fprintf(table, "%s\t%s\t%s\t\n", name, locked, "error")
+					continue
+				}
+				checked++
+				updates.Record(name, dep.Source, latestSHA, resolvedAt)
+
+				mark := ""
+				if lockedSHA == "" || !strings.HasPrefix(latestSHA, lockedSHA) {
+					outdated++
+					mark = markColor("update available")
+				}
+				_, _ = fmt.Fprintf(table, "%s\t%s\t%s\t%s\n", name, locked, shortSHA(latestSHA), mark)
+			}
+			_ = table.Flush()
+			if err := updatecache.Save(".", updates); err != nil {
+				_, _ = fmt.Fprintf(stderr, "Warning: %v\n", err)
+			}
+
+			// Available updates are reported, not treated as a failure.
+			if outdated == 0 {
+				_, _ = fmt.Fprintf(stdout, "All %d checked dependencies are up to date.\n", checked)
+			} else {
+				_, _ = fmt.Fprintf(stdout, "%d of %d checked dependencies have updates available. Run 'almd install' to update.\n", outdated, checked)
+			}
+			return nil
+		},
+	}
+}
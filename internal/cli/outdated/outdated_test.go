@@ -0,0 +1,180 @@
+package outdated
+
+import (
+	"bytes"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/urfave/cli/v2"
+
+	"github.com/nightconcept/almandine-go/internal/core/config"
+	"github.com/nightconcept/almandine-go/internal/core/lockfile"
+	"github.com/nightconcept/almandine-go/internal/core/source"
+	"github.com/nightconcept/almandine-go/internal/core/updatecache"
+)
+
+const (
+	oldSHA = "1111111111111111111111111111111111111111"
+	newSHA = "2222222222222222222222222222222222222222"
+)
+
+const outdatedProjectToml = `
+[package]
+name = "test-outdated"
+version = "0.1.0"
+
+[dependencies.current]
+source = "github:owner/repo/current.lua@main"
+path = "libs/current.lua"
+
+[dependencies.stale]
+source = "github:owner/repo/stale.lua@main"
+path = "libs/stale.lua"
+
+[dependencies.contenthash]
+source = "github:owner/repo/content.lua@main"
+path = "libs/content.lua"
+
+[dependencies.unlocked]
+source = "github:owner/repo/unlocked.lua@main"
+path = "libs/unlocked.lua"
+
+[dependencies.plain]
+source = "https://example.com/plain.lua"
+path = "libs/plain.lua"
+`
+
+const outdatedLockToml = `
+api_version = "1"
+
+[package.current]
+source = "https://raw.githubusercontent.com/owner/repo/` + newSHA + `/current.lua"
+path = "libs/current.lua"
+hash = "commit:` + newSHA + `"
+
+[package.stale]
+source = "https://raw.githubusercontent.com/owner/repo/` + oldSHA + `/stale.lua"
+path = "libs/stale.lua"
+hash = "commit:` + oldSHA + `"
+
+[package.contenthash]
+source = "https://raw.githubusercontent.com/owner/repo/` + oldSHA + `/content.lua"
+path = "libs/content.lua"
+hash = "sha256:0000000000000000000000000000000000000000000000000000000000000000"
+`
+
+// startMockGitHubAPI answers every commits query with newSHA and points the default source
+// client at the mock server.
+func startMockGitHubAPI(t *testing.T) {
+	t.Helper()
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "/repos/owner/repo/commits", r.URL.Path)
+		_, _ = fmt.Fprintf(w, `[{"sha": "%s"}]`, newSHA)
+	}))
+	t.Cleanup(server.Close)
+
+	original := source.GithubAPIBaseURL
+	source.GithubAPIBaseURL = server.URL
+	t.Cleanup(func() { source.GithubAPIBaseURL = original })
+}
+
+// runOutdatedCommand runs 'outdated' in workDir and returns its stdout, stderr and error.
+func runOutdatedCommand(t *testing.T, workDir string, args ...string) (string, string, error) {
+	t.Helper()
+	return runCommand(t, NewOutdatedCommand(), workDir, args...)
+}
+
+// runCommand runs cmd, an 'outdated' command, like runOutdatedCommand.
+func runCommand(t *testing.T, cmd *cli.Command, workDir string, args ...string) (string, string, error) {
+	t.Helper()
+
+	originalWd, err := os.Getwd()
+	require.NoError(t, err)
+	require.NoError(t, os.Chdir(workDir))
+	defer func() { require.NoError(t, os.Chdir(originalWd)) }()
+
+	var stdout, stderr bytes.Buffer
+	app := &cli.App{
+		Name:           "almd-test-outdated",
+		Commands:       []*cli.Command{cmd},
+		Writer:         &stdout,
+		ErrWriter:      &stderr,
+		ExitErrHandler: func(context *cli.Context, err error) {},
+	}
+	err = app.Run(append([]string{"almd-test-outdated", "outdated"}, args...))
+	return stdout.String(), stderr.String(), err
+}
+
+func TestOutdatedCommand_ReportsNewerCommits(t *testing.T) {
+	startMockGitHubAPI(t)
+	tempDir := t.TempDir()
+	require.NoError(t, os.WriteFile(filepath.Join(tempDir, config.ProjectTomlName), []byte(outdatedProjectToml), 0644))
+	require.NoError(t, os.WriteFile(filepath.Join(tempDir, lockfile.LockfileName), []byte(outdatedLockToml), 0644))
+
+	stdout, stderr, err := runOutdatedCommand(t, tempDir)
+	require.NoError(t, err, "available updates are not an error")
+
+	assert.Contains(t, stdout, "NAME")
+	assert.Regexp(t, `current\s+2222222\s+2222222\s*\n`, stdout)
+	assert.Regexp(t, `stale\s+1111111\s+2222222\s+update available`, stdout)
+	assert.Regexp(t, `contenthash\s+1111111\s+2222222\s+update available`, stdout, "the locked commit is read from the source URL")
+	assert.Regexp(t, `unlocked\s+not locked\s+2222222\s+update available`, stdout)
+	assert.NotContains(t, stdout, "plain")
+	assert.Contains(t, stdout, "3 of 4 checked dependencies have updates available")
+	assert.Contains(t, stderr, "Skipping 'plain'")
+
+	lockContent, err := os.ReadFile(filepath.Join(tempDir, lockfile.LockfileName))
+	require.NoError(t, err)
+	assert.Equal(t, outdatedLockToml, string(lockContent), "outdated must not write the lockfile")
+	assert.NoDirExists(t, filepath.Join(tempDir, "libs"), "outdated must not download anything")
+}
+
+func TestOutdatedCommand_NoColor(t *testing.T) {
+	t.Setenv("NO_COLOR", "1")
+	startMockGitHubAPI(t)
+	tempDir := t.TempDir()
+	require.NoError(t, os.WriteFile(filepath.Join(tempDir, config.ProjectTomlName), []byte(outdatedProjectToml), 0644))
+	require.NoError(t, os.WriteFile(filepath.Join(tempDir, lockfile.LockfileName), []byte(outdatedLockToml), 0644))
+
+	stdout, _, err := runOutdatedCommand(t, tempDir)
+	require.NoError(t, err)
+	assert.NotContains(t, stdout, "\x1b[", "NO_COLOR disables ANSI escapes")
+}
+
+func TestOutdatedCommand_RefreshesUpdateCache(t *testing.T) {
+	startMockGitHubAPI(t)
+	tempDir := t.TempDir()
+	require.NoError(t, os.WriteFile(filepath.Join(tempDir, config.ProjectTomlName), []byte(outdatedProjectToml), 0644))
+	require.NoError(t, os.WriteFile(filepath.Join(tempDir, lockfile.LockfileName), []byte(outdatedLockToml), 0644))
+	now := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	// An entry for a dependency no longer declared is dropped.
+	previous := updatecache.New()
+	previous.Record("removed", "github:owner/repo/removed.lua@main", oldSHA, now)
+	require.NoError(t, updatecache.Save(tempDir, previous))
+
+	_, _, err := runCommand(t, newOutdatedCommand(func() time.Time { return now }), tempDir)
+	require.NoError(t, err)
+
+	updates, err := updatecache.Load(tempDir)
+	require.NoError(t, err)
+	assert.ElementsMatch(t, []string{"current", "stale", "contenthash", "unlocked"}, keys(updates.Dependencies))
+	assert.Equal(t, newSHA, updates.Dependencies["stale"].LatestSHA)
+	assert.Equal(t, "github:owner/repo/stale.lua@main", updates.Dependencies["stale"].Source)
+	assert.True(t, updates.Dependencies["stale"].CheckedAt.Equal(now), "entries are stamped with the time of the run")
+	assert.True(t, updates.UpdateAvailable("stale", "github:owner/repo/stale.lua@main", oldSHA, now))
+}
+
+func keys(m map[string]updatecache.Entry) []string {
+	out := make([]string, 0, len(m))
+	for k := range m {
+		out = append(out, k)
+	}
+	return out
+}
@@ -0,0 +1,54 @@
+package output
+
+import (
+	"fmt"
+
+	"github.com/fatih/color"
+	"github.com/urfave/cli/v2"
+)
+
+// ColorFlag returns the global --color flag.
+func ColorFlag() cli.Flag {
+	return &cli.StringFlag{
+		Name:  "color",
+		Usage: "Colorize output: `WHEN` is auto, always or never (auto follows NO_COLOR and whether stdout is a terminal)",
+		Value: "auto",
+	}
+}
+
+// colorMode is the --color setting last applied by SetColorMode.
+var colorMode = "auto"
+
+// autoNoColor is fatih/color's own detection at startup, which "auto" restores.
+var autoNoColor = color.NoColor
+
+// SetColorMode applies a --color setting to all colored output: "always" and "never" override
+// NO_COLOR and terminal detection, and "auto" keeps them.
+func SetColorMode(mode string) error {
+	switch mode {
+	case "auto":
+		color.NoColor = autoNoColor
+	case "always":
+		color.NoColor = false
+	case "never":
+		color.NoColor = true
+	default:
+		return fmt.Errorf("invalid --color '%s': expected auto, always or never", mode)
+	}
+	colorMode = mode
+	return nil
+}
+
+// NewColor is color.New with the --color setting applied. Commands use it for every color they
+// print, since fatih/color checks NO_COLOR again for each Color it creates and so would ignore
+// --color=always on its own.
+func NewColor(value ...color.Attribute) *color.Color {
+	c := color.New(value...)
+	switch colorMode {
+	case "always":
+		c.EnableColor()
+	case "never":
+		c.DisableColor()
+	}
+	return c
+}
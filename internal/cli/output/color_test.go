@@ -0,0 +1,30 @@
+package output
+
+import (
+	"testing"
+
+	"github.com/fatih/color"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSetColorMode(t *testing.T) {
+	t.Setenv("NO_COLOR", "1")
+	t.Cleanup(func() { require.NoError(t, SetColorMode("auto")) })
+
+	require.NoError(t, SetColorMode("always"))
+	assert.False(t, color.NoColor)
+	assert.Equal(t, "\x1b[31mred\x1b[0m", NewColor(color.FgRed).Sprint("red"), "always overrides NO_COLOR")
+
+	require.NoError(t, SetColorMode("never"))
+	assert.True(t, color.NoColor)
+	assert.Equal(t, "red", NewColor(color.FgRed).Sprint("red"))
+
+	require.NoError(t, SetColorMode("auto"))
+	assert.Equal(t, autoNoColor, color.NoColor)
+	assert.Equal(t, "red", NewColor(color.FgRed).Sprint("red"), "auto keeps NO_COLOR")
+
+	err := SetColorMode("yes")
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "expected auto, always or never")
+}
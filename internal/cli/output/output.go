@@ -0,0 +1,73 @@
+// Package output holds the --quiet flag shared by the commands that print a pnpm-style
+// progress summary, the download progress indicator they draw on a terminal, and the global
+// --color setting every command's colored output follows.
+package output
+
+import (
+	"io"
+	"os"
+	"path"
+	"strings"
+
+	"github.com/urfave/cli/v2"
+
+	"github.com/nightconcept/almandine-go/internal/core/downloader"
+)
+
+// QuietFlag returns the --quiet/-q flag. Quiet commands print nothing to stdout; errors and
+// warnings still go to stderr and the exit code is unchanged.
+func QuietFlag() cli.Flag {
+	return &cli.BoolFlag{
+		Name:    "quiet",
+		Aliases: []string{"q"},
+		Usage:   "Suppress all non-error output to stdout",
+	}
+}
+
+// CheckQuiet reports an error when --quiet is combined with --verbose.
+func CheckQuiet(c *cli.Context) error {
+	if c.Bool("quiet") && c.Bool("verbose") {
+		return cli.Exit("Error: --quiet and --verbose cannot be used together.", 1)
+	}
+	return nil
+}
+
+// Stdout returns the writer for a command's non-error output: the app's writer, or
+// io.Discard under --quiet.
+func Stdout(c *cli.Context) io.Writer {
+	if c.Bool("quiet") {
+		return io.Discard
+	}
+	return c.App.Writer
+}
+
+// isTerminal reports whether w is a TTY. Writers that are not files, such as the buffers tests
+// inject, are not.
+func isTerminal(w io.Writer) bool {
+	f, ok := w.(*os.File)
+	if !ok {
+		return false
+	}
+	info, err := f.Stat()
+	if err != nil {
+		return false
+	}
+	return info.Mode()&os.ModeCharDevice != 0
+}
+
+// DownloadProgress returns a hook for downloader.Client.Progress that draws large downloads on
+// the app's writer, labelled with the file name from the URL. It returns nil under --quiet or
+// when stdout is not a terminal, so piped and scripted output never carries the indicator.
+func DownloadProgress(c *cli.Context) func(url string) downloader.ProgressFunc {
+	w := Stdout(c)
+	if !isTerminal(w) {
+		return nil
+	}
+	return func(url string) downloader.ProgressFunc {
+		label := url
+		if i := strings.IndexAny(label, "?#"); i >= 0 {
+			label = label[:i]
+		}
+		return downloader.TerminalProgress(w, path.Base(label))
+	}
+}
@@ -0,0 +1,124 @@
+// Title: Almandine CLI Pin Command
+// Purpose: Implements the 'pin' command, which rewrites every branch-pinned GitHub dependency
+// in project.toml to the commit its branch currently resolves to, making the project
+// reproducible. Tag and commit pins are left alone.
+package pin
+
+import (
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/urfave/cli/v2"
+
+	"github.com/nightconcept/almandine-go/internal/core/config"
+	"github.com/nightconcept/almandine-go/internal/core/lockfile"
+	"github.com/nightconcept/almandine-go/internal/core/source"
+)
+
+// shortSHA abbreviates a commit SHA for display.
+func shortSHA(sha string) string {
+	if len(sha) > 7 {
+		return sha[:7]
+	}
+	return sha
+}
+
+// NewPinCommand creates the 'pin' command.
+func NewPinCommand() *cli.Command {
+	return &cli.Command{
+		Name:  "pin",
+		Usage: "Rewrites branch refs in project.toml to the commits they currently point at",
+		Flags: []cli.Flag{
+			&cli.BoolFlag{
+				Name:  "dry-run",
+				Usage: "Report what would be pinned without changing project.toml",
+			},
+		},
+		Action: func(c *cli.Context) error {
+			// Each rewritten ref is reported on stdout; warnings and follow-up hints go to stderr.
+			stdout, stderr := c.App.Writer, c.App.ErrWriter
+			dryRun := c.Bool("dry-run")
+
+			proj, err := config.LoadProjectToml(".")
+			if err != nil {
+				if os.IsNotExist(err) {
+					return cli.Exit(fmt.Sprintf("Error: %s not found. Run 'almd init' first.", config.ProjectTomlName), 1)
+				}
+				return cli.Exit(fmt.Sprintf("Error loading %s: %v", config.ProjectTomlName, err), 1)
+			}
+			lf, err := lockfile.Load(".")
+			if err != nil {
+				return cli.Exit(fmt.Sprintf("Error loading %s: %v", lockfile.LockfileName, err), 1)
+			}
+
+			names := make([]string, 0, len(proj.Dependencies)+len(proj.DevDependencies))
+			for name := range proj.AllDependencies() {
+				names = append(names, name)
+			}
+			sort.Strings(names)
+
+			client := source.DefaultClient()
+			var pinned, failed int
+			var unlocked []string
+			for _, name := range names {
+				dep, group, _ := proj.LookupDependency(name)
+				parsed, err := source.ParseSourceURL(dep.Source)
+				if err != nil {
+					_, _ = fmt.Fprintf(stderr, "Warning: Could not parse source for '%s' (%s): %v. Skipping.\n", name, dep.Source, err)
+					failed++
+					continue
+				}
+				// Pinning a version range would discard it; almd-lock.toml already pins its tag.
+				if parsed.Provider != "github" || parsed.VersionRange != "" || source.IsImmutableRef(parsed.Provider, parsed.Ref) {
+					continue
+				}
+
+				isBranch, err := client.IsBranch(parsed.Owner, parsed.Repo, parsed.Ref)
+				if err != nil {
+					_, _ = fmt.Fprintf(stderr, "Warning: Could not check whether '%s' is a branch for '%s': %v. Skipping.\n", parsed.Ref, name, err)
+					failed++
+					continue
+				}
+				if !isBranch {
+					continue // A tag is already a fixed reference.
+				}
+
+				sha, err := client.GetLatestCommitSHAForFile(parsed.Owner, parsed.Repo, parsed.PathInRepo, parsed.Ref)
+				if err != nil {
+					_, _ = fmt.Fprintf(stderr, "Warning: Could not resolve '%s' for '%s': %v. Skipping.\n", parsed.Ref, name, err)
+					failed++
+					continue
+				}
+
+				dep.Source = fmt.Sprintf("github:%s/%s/%s@%s", parsed.Owner, parsed.Repo, parsed.PathInRepo, sha)
+				proj.SetDependency(name, group, dep)
+				pinned++
+				_, _ = fmt.Fprintf(stdout, "%s: %s -> commit %s\n", name, parsed.Ref, shortSHA(sha))
+				if lf.Package[name].Hash != "commit:"+sha {
+					unlocked = append(unlocked, name)
+				}
+			}
+
+			switch {
+			case pinned == 0:
+				_, _ = fmt.Fprintln(stdout, "No branch-pinned dependencies to pin.")
+			case dryRun:
+				_, _ = fmt.Fprintf(stdout, "Dry run: %s was not changed.\n", config.ProjectTomlName)
+			default:
+				if err := config.WriteProjectToml(".", proj); err != nil {
+					return cli.Exit(fmt.Sprintf("Error writing %s: %v", config.ProjectTomlName, err), 1)
+				}
+				if len(unlocked) > 0 {
+					_, _ = fmt.Fprintf(stderr, "%s does not record the pinned commit for: %s. Run 'almd install' to bring it in line.\n", lockfile.LockfileName, strings.Join(unlocked, ", "))
+				}
+			}
+
+			if failed > 0 {
+				return cli.Exit(fmt.Sprintf("Error: %d dependenc(ies) could not be pinned.", failed), 1)
+			}
+			return nil
+		},
+	}
+}
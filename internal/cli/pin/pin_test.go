@@ -0,0 +1,141 @@
+package pin
+
+import (
+	"bytes"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/urfave/cli/v2"
+
+	"github.com/nightconcept/almandine-go/internal/core/config"
+	"github.com/nightconcept/almandine-go/internal/core/lockfile"
+	"github.com/nightconcept/almandine-go/internal/core/source"
+)
+
+const (
+	branchTipSHA = "1234567890abcdef1234567890abcdef12345678"
+	fixedSHA     = "fedcba0987654321fedcba0987654321fedcba09"
+)
+
+const pinProjectToml = `
+[package]
+name = "test-pin"
+version = "0.1.0"
+
+[dependencies.branchdep]
+source = "github:owner/repo/branch.lua@main"
+path = "libs/branch.lua"
+
+[dependencies.tagdep]
+source = "github:owner/repo/tag.lua@v1.2.0"
+path = "libs/tag.lua"
+
+[dependencies.commitdep]
+source = "github:owner/repo/commit.lua@` + fixedSHA + `"
+path = "libs/commit.lua"
+`
+
+// startMockGitHubAPI serves the branches and commits endpoints for owner/repo, where only
+// "main" is a branch, and points the default source client at it.
+func startMockGitHubAPI(t *testing.T) {
+	t.Helper()
+	mux := http.NewServeMux()
+	mux.HandleFunc("/repos/owner/repo/branches/", func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/repos/owner/repo/branches/main" {
+			http.Error(w, `{"message": "Branch not found"}`, http.StatusNotFound)
+			return
+		}
+		_, _ = fmt.Fprint(w, `{"name": "main"}`)
+	})
+	mux.HandleFunc("/repos/owner/repo/commits", func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "main", r.URL.Query().Get("sha"), "only branch refs should be resolved")
+		_, _ = fmt.Fprintf(w, `[{"sha": "%s"}]`, branchTipSHA)
+	})
+	server := httptest.NewServer(mux)
+	t.Cleanup(server.Close)
+
+	original := source.GithubAPIBaseURL
+	source.GithubAPIBaseURL = server.URL
+	t.Cleanup(func() { source.GithubAPIBaseURL = original })
+}
+
+// runPinCommand runs 'pin' in workDir and returns its stdout, stderr and error.
+func runPinCommand(t *testing.T, workDir string, args ...string) (string, string, error) {
+	t.Helper()
+
+	originalWd, err := os.Getwd()
+	require.NoError(t, err)
+	require.NoError(t, os.Chdir(workDir))
+	defer func() { require.NoError(t, os.Chdir(originalWd)) }()
+
+	var stdout, stderr bytes.Buffer
+	app := &cli.App{
+		Name:           "almd-test-pin",
+		Commands:       []*cli.Command{NewPinCommand()},
+		Writer:         &stdout,
+		ErrWriter:      &stderr,
+		ExitErrHandler: func(context *cli.Context, err error) {},
+	}
+	err = app.Run(append([]string{"almd-test-pin", "pin"}, args...))
+	return stdout.String(), stderr.String(), err
+}
+
+func setupPinProject(t *testing.T) string {
+	t.Helper()
+	tempDir := t.TempDir()
+	require.NoError(t, os.WriteFile(filepath.Join(tempDir, config.ProjectTomlName), []byte(pinProjectToml), 0644))
+	return tempDir
+}
+
+func TestPinCommand_OnlyBranchesAreRewritten(t *testing.T) {
+	startMockGitHubAPI(t)
+	tempDir := setupPinProject(t)
+
+	stdout, stderr, err := runPinCommand(t, tempDir)
+	require.NoError(t, err)
+	assert.Equal(t, "branchdep: main -> commit 1234567\n", stdout)
+	assert.Contains(t, stderr, "does not record the pinned commit for: branchdep")
+
+	proj, err := config.LoadProjectToml(tempDir)
+	require.NoError(t, err)
+	assert.Equal(t, "github:owner/repo/branch.lua@"+branchTipSHA, proj.Dependencies["branchdep"].Source)
+	assert.Equal(t, "github:owner/repo/tag.lua@v1.2.0", proj.Dependencies["tagdep"].Source)
+	assert.Equal(t, "github:owner/repo/commit.lua@"+fixedSHA, proj.Dependencies["commitdep"].Source)
+
+	// A second run has nothing left to pin.
+	stdout, _, err = runPinCommand(t, tempDir)
+	require.NoError(t, err)
+	assert.Equal(t, "No branch-pinned dependencies to pin.\n", stdout)
+}
+
+func TestPinCommand_LockfileAlreadyMatches(t *testing.T) {
+	startMockGitHubAPI(t)
+	tempDir := setupPinProject(t)
+	lf := lockfile.New()
+	lf.AddOrUpdatePackage("branchdep", "https://raw.githubusercontent.com/owner/repo/"+branchTipSHA+"/branch.lua", "libs/branch.lua", "commit:"+branchTipSHA)
+	require.NoError(t, lockfile.Save(tempDir, lf))
+
+	_, stderr, err := runPinCommand(t, tempDir)
+	require.NoError(t, err)
+	assert.Empty(t, stderr)
+}
+
+func TestPinCommand_DryRun(t *testing.T) {
+	startMockGitHubAPI(t)
+	tempDir := setupPinProject(t)
+
+	stdout, _, err := runPinCommand(t, tempDir, "--dry-run")
+	require.NoError(t, err)
+	assert.Contains(t, stdout, "branchdep: main -> commit 1234567")
+	assert.Contains(t, stdout, "Dry run: project.toml was not changed.")
+
+	content, err := os.ReadFile(filepath.Join(tempDir, config.ProjectTomlName))
+	require.NoError(t, err)
+	assert.Equal(t, pinProjectToml, string(content))
+}
@@ -0,0 +1,35 @@
+// Package prompt provides small interactive helpers shared by the CLI commands.
+package prompt
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+)
+
+// Confirm writes question to w followed by a "(y/N)" hint and reads a single line from r.
+// It returns true only for an explicit "y" or "yes" answer (case-insensitive); an empty
+// answer, any other input, or a read error (such as EOF on a non-interactive stdin) is "no".
+func Confirm(r io.Reader, w io.Writer, question string) bool {
+	_, _ = fmt.Fprintf(w, "%s (y/N): ", question)
+	input, _ := bufio.NewReader(r).ReadString('\n')
+	answer := strings.TrimSpace(strings.ToLower(input))
+	return answer == "y" || answer == "yes"
+}
+
+// IsTerminal reports whether r can be used to answer a prompt interactively. An *os.File
+// qualifies only if it is a character device (a TTY); pipes and redirected files do not.
+// Readers that are not files, such as those injected by tests, are assumed to be interactive.
+func IsTerminal(r io.Reader) bool {
+	f, ok := r.(*os.File)
+	if !ok {
+		return true
+	}
+	info, err := f.Stat()
+	if err != nil {
+		return false
+	}
+	return info.Mode()&os.ModeCharDevice != 0
+}
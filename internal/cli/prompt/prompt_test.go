@@ -0,0 +1,38 @@
+package prompt
+
+import (
+	"bytes"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestConfirm(t *testing.T) {
+	cases := map[string]bool{
+		"y\n":   true,
+		"YES\n": true,
+		" y \n": true,
+		"n\n":   false,
+		"\n":    false,
+		"":      false, // EOF, e.g. non-interactive stdin
+		"sure":  false,
+	}
+	for input, want := range cases {
+		var out bytes.Buffer
+		got := Confirm(strings.NewReader(input), &out, "Proceed?")
+		assert.Equal(t, want, got, "input %q", input)
+		assert.Equal(t, "Proceed? (y/N): ", out.String())
+	}
+}
+
+func TestIsTerminal(t *testing.T) {
+	assert.True(t, IsTerminal(strings.NewReader("y\n")), "non-file readers are treated as interactive")
+
+	r, w, err := os.Pipe()
+	require.NoError(t, err)
+	defer func() { _ = r.Close(); _ = w.Close() }()
+	assert.False(t, IsTerminal(r), "a pipe is not a terminal")
+}
@@ -0,0 +1,135 @@
+package remove
+
+import (
+	"fmt"
+	"os"
+	"sort"
+
+	"github.com/urfave/cli/v2"
+
+	"github.com/nightconcept/almandine-go/internal/core/config"
+	"github.com/nightconcept/almandine-go/internal/core/lockfile"
+	"github.com/nightconcept/almandine-go/internal/core/project"
+)
+
+// orphanedEntries returns the sorted names of lockfile entries that no longer have a
+// dependency of either group declared in project.toml.
+func orphanedEntries(declared map[string]project.Dependency, lf *lockfile.Lockfile) []string {
+	var orphans []string
+	for name := range lf.Package {
+		if _, ok := declared[name]; !ok {
+			orphans = append(orphans, name)
+		}
+	}
+	sort.Strings(orphans)
+	return orphans
+}
+
+// PruneCommand defines the 'prune' command, which drops almd-lock.toml entries for
+// dependencies that were removed from project.toml by hand and, with --files, deletes the
+// files those entries installed. Only the exact paths recorded in the pruned entries are
+// deleted; other files sharing their directories are never touched.
+func PruneCommand() *cli.Command {
+	return &cli.Command{
+		Name:  "prune",
+		Usage: "Remove lockfile entries (and optionally files) for dependencies no longer in project.toml",
+		Description: "Exit codes:\n" +
+			"   0  every orphaned entry, and with --files its file, was removed cleanly\n" +
+			"   1  project.toml or almd-lock.toml could not be read or written; nothing was changed\n" +
+			"   2  almd-lock.toml was pruned but some files could not be deleted",
+		Flags: []cli.Flag{
+			&cli.BoolFlag{
+				Name:  "files",
+				Usage: "Also delete the files of pruned entries and any directories left empty",
+			},
+			&cli.BoolFlag{
+				Name:  "dry-run",
+				Usage: "Show what would be pruned without changing anything",
+			},
+		},
+		Action: func(c *cli.Context) error {
+			// What was pruned goes to stdout; warnings and notes go to stderr.
+			stdout, errWriter := c.App.Writer, c.App.ErrWriter
+			deleteFiles, dryRun := c.Bool("files"), c.Bool("dry-run")
+
+			release, lockErr := lockfile.Acquire(".")
+			if lockErr != nil {
+				return cli.Exit(fmt.Sprintf("Error: %v", lockErr), 1)
+			}
+			defer release()
+
+			proj, err := config.LoadProjectToml(".")
+			if err != nil {
+				if os.IsNotExist(err) {
+					return cli.Exit(fmt.Sprintf("Error: %s not found. Run 'almd init' first.", config.ProjectTomlName), 1)
+				}
+				return cli.Exit(fmt.Sprintf("Error: Failed to load %s: %v", config.ProjectTomlName, err), 1)
+			}
+			lf, err := lockfile.Load(".")
+			if err != nil {
+				return cli.Exit(fmt.Sprintf("Error: Failed to load %s: %v", lockfile.LockfileName, err), 1)
+			}
+
+			declared := proj.AllDependencies()
+			orphans := orphanedEntries(declared, lf)
+			if len(orphans) == 0 {
+				_, _ = fmt.Fprintf(stdout, "Nothing to prune; every entry in %s is declared in %s.\n", lockfile.LockfileName, config.ProjectTomlName)
+				return nil
+			}
+
+			// A file still claimed by a declared dependency is never deleted, even if a stale
+			// entry records the same path.
+			claimed := make(map[string]bool, len(declared))
+			for _, dep := range declared {
+				claimed[dep.Path] = true
+			}
+
+			if dryRun {
+				for _, name := range orphans {
+					path := lf.Package[name].Path
+					_, _ = fmt.Fprintf(stdout, "Would prune %s (%s)\n", name, path)
+					if deleteFiles && !claimed[path] {
+						_, _ = fmt.Fprintf(stdout, "Would delete %s\n", path)
+					}
+				}
+				return nil
+			}
+
+			pruned := make(map[string]lockfile.PackageEntry, len(orphans))
+			for _, name := range orphans {
+				pruned[name] = lf.Package[name]
+				delete(lf.Package, name)
+			}
+			if err := lockfile.Save(".", lf); err != nil {
+				return cli.Exit(fmt.Sprintf("Error: Failed to update %s: %v", lockfile.LockfileName, err), 1)
+			}
+
+			cleanupWarnings := false
+			for _, name := range orphans {
+				entry := pruned[name]
+				_, _ = fmt.Fprintf(stdout, "Pruned %s (%s)\n", name, entry.Path)
+				if !deleteFiles {
+					continue
+				}
+				if claimed[entry.Path] {
+					_, _ = fmt.Fprintf(errWriter, "Note: Kept %s; it is still the path of a dependency in %s.\n", entry.Path, config.ProjectTomlName)
+					continue
+				}
+				deleted, warned := deleteDependencyFile(project.NativePath(entry.Path), lockfile.LockfileName, errWriter)
+				cleanupWarnings = cleanupWarnings || warned
+				if deleted {
+					_, _ = fmt.Fprintf(stdout, "Deleted %s\n", entry.Path)
+				}
+			}
+			_, _ = fmt.Fprintf(stdout, "Pruned %d orphaned entr(ies) from %s.\n", len(orphans), lockfile.LockfileName)
+			if !deleteFiles {
+				_, _ = fmt.Fprintln(errWriter, "Note: Files of pruned entries were left in place; rerun with --files to delete them.")
+			}
+
+			if cleanupWarnings {
+				return cli.Exit(fmt.Sprintf("Warning: %s was pruned, but some files could not be deleted; see warnings above.", lockfile.LockfileName), exitCleanupWarnings)
+			}
+			return nil
+		},
+	}
+}
@@ -0,0 +1,149 @@
+package remove
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/urfave/cli/v2"
+
+	"github.com/nightconcept/almandine-go/internal/core/lockfile"
+)
+
+func runPruneCommand(t *testing.T, args ...string) (string, string, error) {
+	t.Helper()
+	var stdout, stderr bytes.Buffer
+	app := &cli.App{
+		Name:           "almd-test-prune",
+		Commands:       []*cli.Command{PruneCommand()},
+		Writer:         &stdout,
+		ErrWriter:      &stderr,
+		ExitErrHandler: func(context *cli.Context, err error) {},
+	}
+	err := app.Run(append([]string{"almd-test-prune", "prune"}, args...))
+	return stdout.String(), stderr.String(), err
+}
+
+func TestPruneCommand(t *testing.T) {
+	originalWd, err := os.Getwd()
+	require.NoError(t, err)
+	defer func() {
+		require.NoError(t, os.Chdir(originalWd))
+	}()
+
+	projectToml := `
+[package]
+name = "test-project-prune"
+version = "0.1.0"
+
+[dependencies]
+kept = { source = "github:user/repo/kept.lua@main", path = "libs/kept.lua" }
+
+[dev-dependencies]
+devkept = { source = "github:user/repo/devkept.lua@main", path = "test/devkept.lua" }
+`
+	lockToml := `
+api_version = "1"
+
+[package.kept]
+source = "https://raw.githubusercontent.com/user/repo/main/kept.lua"
+path = "libs/kept.lua"
+hash = "sha256:111"
+
+[package.devkept]
+source = "https://raw.githubusercontent.com/user/repo/main/devkept.lua"
+path = "test/devkept.lua"
+hash = "sha256:222"
+group = "dev"
+
+[package.gone]
+source = "https://raw.githubusercontent.com/user/repo/main/gone.lua"
+path = "vendor/deep/gone.lua"
+hash = "sha256:333"
+
+[package.stale]
+source = "https://raw.githubusercontent.com/user/repo/main/kept.lua"
+path = "libs/kept.lua"
+hash = "sha256:444"
+`
+	depFiles := map[string]string{
+		"libs/kept.lua":        "-- kept",
+		"test/devkept.lua":     "-- devkept",
+		"vendor/deep/gone.lua": "-- gone",
+	}
+
+	t.Run("dry run changes nothing", func(t *testing.T) {
+		tempDir := setupRemoveTestEnvironment(t, projectToml, lockToml, depFiles)
+		require.NoError(t, os.Chdir(tempDir))
+
+		stdout, _, err := runPruneCommand(t, "--files", "--dry-run")
+		require.NoError(t, err)
+		assert.Contains(t, stdout, "Would prune gone (vendor/deep/gone.lua)\nWould delete vendor/deep/gone.lua\n")
+		assert.Contains(t, stdout, "Would prune stale (libs/kept.lua)\n")
+		assert.NotContains(t, stdout, "Would delete libs/kept.lua", "a path still declared is never deleted")
+
+		lf, err := lockfile.Load(tempDir)
+		require.NoError(t, err)
+		assert.Len(t, lf.Package, 4)
+		assert.FileExists(t, filepath.Join(tempDir, "vendor", "deep", "gone.lua"))
+	})
+
+	t.Run("prunes entries but keeps files by default", func(t *testing.T) {
+		tempDir := setupRemoveTestEnvironment(t, projectToml, lockToml, depFiles)
+		require.NoError(t, os.Chdir(tempDir))
+
+		stdout, stderr, err := runPruneCommand(t)
+		require.NoError(t, err)
+		assert.Contains(t, stdout, "Pruned gone (vendor/deep/gone.lua)")
+		assert.Contains(t, stdout, "Pruned 2 orphaned entr(ies) from almd-lock.toml.")
+		assert.Contains(t, stderr, "rerun with --files")
+
+		lf, err := lockfile.Load(tempDir)
+		require.NoError(t, err)
+		assert.Contains(t, lf.Package, "kept")
+		assert.Contains(t, lf.Package, "devkept", "dev dependencies are declared too")
+		assert.NotContains(t, lf.Package, "gone")
+		assert.NotContains(t, lf.Package, "stale")
+		assert.FileExists(t, filepath.Join(tempDir, "vendor", "deep", "gone.lua"))
+	})
+
+	t.Run("files deletes orphaned files and empty directories", func(t *testing.T) {
+		tempDir := setupRemoveTestEnvironment(t, projectToml, lockToml, depFiles)
+		require.NoError(t, os.Chdir(tempDir))
+
+		stdout, stderr, err := runPruneCommand(t, "--files")
+		require.NoError(t, err)
+		assert.Contains(t, stdout, "Deleted vendor/deep/gone.lua")
+		assert.Contains(t, stderr, "Note: Kept libs/kept.lua")
+
+		_, statErr := os.Stat(filepath.Join(tempDir, "vendor"))
+		assert.True(t, os.IsNotExist(statErr), "directories left empty are removed")
+		assert.FileExists(t, filepath.Join(tempDir, "libs", "kept.lua"))
+	})
+
+	t.Run("files leaves untracked files in managed directories alone", func(t *testing.T) {
+		files := map[string]string{"vendor/deep/notes.txt": "mine"}
+		for p, content := range depFiles {
+			files[p] = content
+		}
+		tempDir := setupRemoveTestEnvironment(t, projectToml, lockToml, files)
+		require.NoError(t, os.Chdir(tempDir))
+
+		_, _, err := runPruneCommand(t, "--files")
+		require.NoError(t, err)
+		assert.NoFileExists(t, filepath.Join(tempDir, "vendor", "deep", "gone.lua"))
+		assert.FileExists(t, filepath.Join(tempDir, "vendor", "deep", "notes.txt"), "only locked paths are deleted")
+	})
+
+	t.Run("nothing to prune", func(t *testing.T) {
+		tempDir := setupRemoveTestEnvironment(t, projectToml, "api_version = \"1\"\n", nil)
+		require.NoError(t, os.Chdir(tempDir))
+
+		stdout, _, err := runPruneCommand(t, "--files")
+		require.NoError(t, err)
+		assert.Contains(t, stdout, "Nothing to prune")
+	})
+}
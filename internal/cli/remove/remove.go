@@ -4,17 +4,31 @@ import (
 	"fmt"
 	"io"
 	"os"
+	"path"
 	"path/filepath"
+	"sort"
 	"strings"
 	"time"
 
 	"github.com/fatih/color"
+	"github.com/nightconcept/almandine-go/internal/cli/output"
+	"github.com/nightconcept/almandine-go/internal/cli/prompt"
 	"github.com/nightconcept/almandine-go/internal/core/config"
 	"github.com/nightconcept/almandine-go/internal/core/lockfile"
+	"github.com/nightconcept/almandine-go/internal/core/project"
+	"github.com/nightconcept/almandine-go/internal/core/requires"
 	"github.com/nightconcept/almandine-go/internal/core/source" // Changed from project to source
 	"github.com/urfave/cli/v2"
 )
 
+// Exit codes returned by the remove command. A genuine failure, such as no argument naming a
+// declared dependency, exits with code 1 before anything is modified.
+const (
+	// exitCleanupWarnings means project.toml was updated but a side effect (deleting a file,
+	// pruning a directory, updating the lockfile or the requires file) reported a problem.
+	exitCleanupWarnings = 2
+)
+
 // isDirEmpty checks if a directory is empty.
 // It returns true if the directory has no entries, false otherwise.
 // An error is returned if the directory cannot be read.
@@ -26,19 +40,173 @@ func isDirEmpty(path string) (bool, error) {
 	return len(entries) == 0, nil
 }
 
+// isGlobPattern reports whether arg contains any of the metacharacters understood by path.Match.
+func isGlobPattern(arg string) bool {
+	return strings.ContainsAny(arg, "*?[")
+}
+
+// selectDependencies resolves the names and glob patterns given on the command line against
+// the dependencies declared in project.toml. The returned names are sorted and de-duplicated.
+// Each name that is not declared, or pattern that matches nothing, is reported in missing as a
+// message ready to be prefixed with "Error: " or "Warning: ". An invalid pattern is returned as
+// a cli.ExitCoder ready to be returned from the command action.
+func selectDependencies(args []string, deps map[string]project.Dependency) (names []string, missing []string, err error) {
+	selected := make(map[string]struct{})
+	for _, arg := range args {
+		if !isGlobPattern(arg) {
+			if _, ok := deps[arg]; !ok {
+				missing = append(missing, fmt.Sprintf("Dependency '%s' not found in %s.", arg, config.ProjectTomlName))
+				continue
+			}
+			selected[arg] = struct{}{}
+			continue
+		}
+
+		if _, err := path.Match(arg, ""); err != nil {
+			return nil, nil, cli.Exit(fmt.Sprintf("Error: Invalid pattern '%s': %v", arg, err), 1)
+		}
+		matched := false
+		for name := range deps {
+			if ok, _ := path.Match(arg, name); ok {
+				selected[name] = struct{}{}
+				matched = true
+			}
+		}
+		if !matched {
+			missing = append(missing, fmt.Sprintf("No dependencies in %s match pattern '%s'.", config.ProjectTomlName, arg))
+		}
+	}
+
+	names = make([]string, 0, len(selected))
+	for name := range selected {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names, missing, nil
+}
+
+// sortedDependencyNames returns every dependency name in project.toml in sorted order.
+func sortedDependencyNames(deps map[string]project.Dependency) []string {
+	names := make([]string, 0, len(deps))
+	for name := range deps {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// dependencyVersion extracts a displayable ref from a canonical source string.
+func dependencyVersion(dependencySource string) string {
+	parsedInfo, parseErr := source.ParseSourceURL(dependencySource)
+	if parseErr == nil && parsedInfo != nil && parsedInfo.Ref != "" && !strings.HasPrefix(parsedInfo.Ref, "error:") {
+		return parsedInfo.Ref
+	}
+	return "unknown"
+}
+
+// deleteDependencyFile removes the file at dependencyPath and then walks up its parent
+// directories, removing each one that has become empty, stopping at the project root.
+// It reports whether the file itself was deleted and whether any warning was written to
+// errWriter. A file that is already absent is not a warning. updated names the file that was
+// already changed (e.g. project.toml) and is kept despite a failed deletion.
+func deleteDependencyFile(dependencyPath, updated string, errWriter io.Writer) (deleted bool, warned bool) {
+	if err := os.Remove(dependencyPath); err != nil {
+		if !os.IsNotExist(err) {
+			// Keep the manifest or lockfile change, but report error for file deletion
+			_, _ = fmt.Fprintf(errWriter, "Warning: Failed to delete dependency file '%s': %v. %s updated.\n", dependencyPath, err, updated)
+			return false, true
+		}
+		return false, false
+	}
+
+	// Attempt to clean up empty parent directories
+	currentDir := filepath.Dir(dependencyPath)
+	projectRootAbs, errAbs := filepath.Abs(".")
+	if errAbs != nil {
+		_, _ = fmt.Fprintf(errWriter, "Warning: Could not determine project root absolute path: %v. Skipping directory cleanup.\n", errAbs)
+		return true, true
+	}
+	for {
+		absCurrentDir, errLoopAbs := filepath.Abs(currentDir)
+		if errLoopAbs != nil {
+			_, _ = fmt.Fprintf(errWriter, "Warning: Could not get absolute path for '%s': %v. Stopping directory cleanup.\n", currentDir, errLoopAbs)
+			return true, true
+		}
+		if absCurrentDir == projectRootAbs || filepath.Dir(absCurrentDir) == absCurrentDir || currentDir == "." {
+			break
+		}
+		empty, errEmpty := isDirEmpty(currentDir)
+		if errEmpty != nil {
+			_, _ = fmt.Fprintf(errWriter, "Warning: Could not check if directory '%s' is empty: %v. Stopping directory cleanup.\n", currentDir, errEmpty)
+			return true, true
+		}
+		if !empty {
+			break
+		}
+		if errRemoveDir := os.Remove(currentDir); errRemoveDir != nil {
+			_, _ = fmt.Fprintf(errWriter, "Warning: Failed to remove empty directory '%s': %v. Stopping directory cleanup.\n", currentDir, errRemoveDir)
+			return true, true
+		}
+		currentDir = filepath.Dir(currentDir)
+	}
+	return true, false
+}
+
 // RemoveCommand defines the structure for the 'remove' CLI command.
 func RemoveCommand() *cli.Command {
+	return newRemoveCommand(time.Now)
+}
+
+// newRemoveCommand creates the 'remove' command, which reads the time from now.
+func newRemoveCommand(now func() time.Time) *cli.Command {
 	return &cli.Command{
 		Name:      "remove",
-		Usage:     "Remove a dependency from the project",
-		ArgsUsage: "DEPENDENCY",
+		Usage:     "Remove one or more dependencies from the project",
+		ArgsUsage: "DEPENDENCY|PATTERN...",
+		Description: "Exit codes:\n" +
+			"   0  all dependencies and their files were removed cleanly\n" +
+			"   1  none of the given dependencies were found, or another error occurred; nothing\n" +
+			"      was changed (names that are not found are skipped with a warning otherwise)\n" +
+			"   2  project.toml was updated but cleanup (files, directories, lockfile or\n" +
+			"      requires file) reported warnings",
+		Flags: []cli.Flag{
+			&cli.BoolFlag{
+				Name:  "all",
+				Usage: "Remove every dependency declared in project.toml",
+			},
+			&cli.BoolFlag{
+				Name:    "yes",
+				Aliases: []string{"y"},
+				Usage:   "Skip the confirmation prompt when using --all",
+			},
+			&cli.BoolFlag{
+				Name:  "dry-run",
+				Usage: "Show which dependencies would be removed without changing anything",
+			},
+			&cli.StringFlag{
+				Name:  "generate-requires",
+				Usage: "Regenerate this file requiring every remaining production dependency (overrides [almd] generate_requires)",
+			},
+			output.QuietFlag(),
+		},
 		Action: func(c *cli.Context) error {
-			startTime := time.Now()
-			if !c.Args().Present() {
+			startTime := now()
+			removeAll := c.Bool("all")
+			if removeAll && c.Args().Present() {
+				return cli.Exit("Error: --all cannot be combined with dependency names or patterns.", 1)
+			}
+			if !removeAll && !c.Args().Present() {
 				return fmt.Errorf("dependency name is required")
 			}
 
-			depName := c.Args().First()
+			// The pnpm-style summary goes to stdout; warnings and notes go to stderr.
+			stdout, errWriter := output.Stdout(c), c.App.ErrWriter
+
+			release, lockErr := lockfile.Acquire(".")
+			if lockErr != nil {
+				return cli.Exit(fmt.Sprintf("Error: %v", lockErr), 1)
+			}
+			defer release()
 
 			// Load project.toml from the current directory
 			proj, err := config.LoadProjectToml(".")
@@ -46,131 +214,135 @@ func RemoveCommand() *cli.Command {
 				return cli.Exit(fmt.Sprintf("Error: Failed to load %s: %v", config.ProjectTomlName, err), 1)
 			}
 
-			if len(proj.Dependencies) == 0 {
+			// Dependencies of both groups can be removed by name.
+			declaredDeps := proj.AllDependencies()
+			if len(declaredDeps) == 0 {
 				return cli.Exit(fmt.Sprintf("Error: No dependencies found in %s.", config.ProjectTomlName), 1)
 			}
 
-			dep, ok := proj.Dependencies[depName]
-			if !ok {
-				return cli.Exit(fmt.Sprintf("Error: Dependency '%s' not found in %s.", depName, config.ProjectTomlName), 1)
+			var depNames []string
+			if removeAll {
+				depNames = sortedDependencyNames(declaredDeps)
+			} else {
+				var missing []string
+				depNames, missing, err = selectDependencies(c.Args().Slice(), declaredDeps)
+				if err != nil {
+					return err
+				}
+				// Names that are not declared are skipped; the command only fails when none of
+				// the arguments matched anything.
+				if len(depNames) == 0 && len(missing) == 1 {
+					return cli.Exit("Error: "+missing[0], 1)
+				}
+				for _, msg := range missing {
+					_, _ = fmt.Fprintf(errWriter, "Warning: %s Skipping.\n", msg)
+				}
+				if len(depNames) == 0 {
+					return cli.Exit(fmt.Sprintf("Error: None of the given dependencies were found in %s.", config.ProjectTomlName), 1)
+				}
+			}
+
+			if c.Bool("dry-run") {
+				for _, depName := range depNames {
+					_, _ = fmt.Fprintf(stdout, "Would remove %s (%s)\n", depName, declaredDeps[depName].Path)
+				}
+				return nil
+			}
+
+			if removeAll && !c.Bool("yes") {
+				if !prompt.Confirm(c.App.Reader, errWriter, fmt.Sprintf("Remove all %d dependencies?", len(depNames))) {
+					_, _ = fmt.Fprintln(errWriter, "Remove cancelled.")
+					return nil
+				}
 			}
 
-			dependencyPath := dep.Path
-			dependencySource := dep.Source // Store source for version display
-			// Remove the dependency from the manifest
-			delete(proj.Dependencies, depName)
+			removedDeps := make(map[string]project.Dependency, len(depNames))
+			for _, depName := range depNames {
+				removedDeps[depName] = declaredDeps[depName]
+				// Remove the dependency from the manifest
+				proj.RemoveDependency(depName)
+			}
 
 			// Save the updated manifest
 			if err := config.WriteProjectToml(".", proj); err != nil {
 				return cli.Exit(fmt.Sprintf("Error: Failed to update %s: %v", config.ProjectTomlName, err), 1)
 			}
-			// fmt.Printf("Successfully removed dependency '%s' from %s.\n", depName, config.ProjectTomlName) // Silenced
 
-			// Delete the dependency file
-			fileDeleted := false
-			if err := os.Remove(dependencyPath); err != nil {
-				if !os.IsNotExist(err) {
-					// Keep manifest change, but report error for file deletion
-					_, _ = fmt.Fprintf(c.App.ErrWriter, "Warning: Failed to delete dependency file '%s': %v. Manifest updated.\n", dependencyPath, err)
-				}
-				// fmt.Printf("Warning: Dependency file '%s' not found for deletion, but manifest updated.\n", dependencyPath) // Silenced
-			} else {
-				// fmt.Printf("Successfully deleted dependency file '%s'.\n", dependencyPath) // Silenced
-				fileDeleted = true
-				// Attempt to clean up empty parent directories
-				currentDir := filepath.Dir(dependencyPath)
-				projectRootAbs, errAbs := filepath.Abs(".")
-				if errAbs != nil {
-					_, _ = fmt.Fprintf(c.App.ErrWriter, "Warning: Could not determine project root absolute path: %v. Skipping directory cleanup.\n", errAbs)
-				} else {
-					for {
-						absCurrentDir, errLoopAbs := filepath.Abs(currentDir)
-						if errLoopAbs != nil {
-							_, _ = fmt.Fprintf(c.App.ErrWriter, "Warning: Could not get absolute path for '%s': %v. Stopping directory cleanup.\n", currentDir, errLoopAbs)
-							break
-						}
-						if absCurrentDir == projectRootAbs || filepath.Dir(absCurrentDir) == absCurrentDir || currentDir == "." {
-							break
-						}
-						empty, errEmpty := isDirEmpty(currentDir)
-						if errEmpty != nil {
-							_, _ = fmt.Fprintf(c.App.ErrWriter, "Warning: Could not check if directory '%s' is empty: %v. Stopping directory cleanup.\n", currentDir, errEmpty)
-							break
-						}
-						if !empty {
-							break
-						}
-						// fmt.Printf("Info: Directory '%s' is empty, attempting to remove.\n", currentDir) // Silenced
-						if errRemoveDir := os.Remove(currentDir); errRemoveDir != nil {
-							_, _ = fmt.Fprintf(c.App.ErrWriter, "Warning: Failed to remove empty directory '%s': %v. Stopping directory cleanup.\n", currentDir, errRemoveDir)
-							break
-						}
-						// fmt.Printf("Successfully removed empty directory '%s'.\n", currentDir) // Silenced
-						currentDir = filepath.Dir(currentDir)
-					}
-				}
+			// Any warning from here on means the manifest changed but cleanup was incomplete.
+			cleanupWarnings := false
+
+			// Delete the dependency files
+			filesDeleted := make(map[string]bool, len(depNames))
+			for _, depName := range depNames {
+				deleted, warned := deleteDependencyFile(project.NativePath(removedDeps[depName].Path), config.ProjectTomlName, errWriter)
+				filesDeleted[depName] = deleted
+				cleanupWarnings = cleanupWarnings || warned
 			}
 
 			// Update lockfile
 			lf, errLock := lockfile.Load(".")
-			lockfileUpdated := false
+			lockfileUpdated := make(map[string]bool, len(depNames))
 			if errLock != nil {
-				_, _ = fmt.Fprintf(c.App.ErrWriter, "Warning: Failed to load %s: %v. Manifest and file processed.\n", lockfile.LockfileName, errLock)
-			} else {
-				if lf.Package != nil {
+				_, _ = fmt.Fprintf(errWriter, "Warning: Failed to load %s: %v. Manifest and file processed.\n", lockfile.LockfileName, errLock)
+				cleanupWarnings = true
+			} else if lf.Package != nil {
+				var inLock []string
+				for _, depName := range depNames {
 					if _, depInLock := lf.Package[depName]; depInLock {
 						delete(lf.Package, depName)
-						if errSaveLock := lockfile.Save(".", lf); errSaveLock != nil {
-							_, _ = fmt.Fprintf(c.App.ErrWriter, "Warning: Failed to update %s: %v. Manifest and file processed.\n", lockfile.LockfileName, errSaveLock)
-						} else {
-							// fmt.Printf("Successfully removed dependency '%s' from %s.\n", depName, lockfile.LockfileName) // Silenced
-							lockfileUpdated = true
+						inLock = append(inLock, depName)
+					}
+				}
+				if len(inLock) > 0 {
+					if errSaveLock := lockfile.Save(".", lf); errSaveLock != nil {
+						_, _ = fmt.Fprintf(errWriter, "Warning: Failed to update %s: %v. Manifest and file processed.\n", lockfile.LockfileName, errSaveLock)
+						cleanupWarnings = true
+					} else {
+						for _, depName := range inLock {
+							lockfileUpdated[depName] = true
 						}
 					}
-					// else {
-					// fmt.Printf("Info: Dependency '%s' not found in %s. No changes made to lockfile.\n", depName, lockfile.LockfileName) // Silenced
-					// }
 				}
-				// else {
-				// fmt.Printf("Info: No 'package' section found in %s. No changes made to lockfile.\n", lockfile.LockfileName) // Silenced
-				// }
+			}
+
+			// Keep the generated requires file, if any, in sync with the remaining dependencies
+			requiresPath, requiresTemplate := proj.RequiresSettings()
+			if c.IsSet("generate-requires") {
+				requiresPath = c.String("generate-requires")
+			}
+			if requiresPath != "" {
+				if errGen := requires.Generate(".", requiresPath, requiresTemplate, proj.Dependencies); errGen != nil {
+					_, _ = fmt.Fprintf(errWriter, "Warning: Failed to regenerate %s: %v. Manifest and files processed.\n", requiresPath, errGen)
+					cleanupWarnings = true
+				}
 			}
 
 			// pnpm-style output
 			// For remove, pnpm doesn't show "Packages: -1" but rather "Progress: ... removed 1" or similar.
 			// We'll simplify to match the example's structure.
-			fmt.Println("Progress: resolved 0, reused 0, downloaded 0, removed 1, done") // Simplified
-			fmt.Println()
-			_, _ = color.New(color.FgWhite, color.Bold).Println("dependencies:")
-
-			// Try to get version from the original source string in project.toml
-			// This is a simplification; a more robust way would be to parse the canonical source string.
-			versionStr := "unknown"
-			// Use source.ParseSourceURL which is designed for this
-			parsedInfo, parseErr := source.ParseSourceURL(dependencySource)
-			if parseErr == nil && parsedInfo != nil && parsedInfo.Ref != "" && !strings.HasPrefix(parsedInfo.Ref, "error:") {
-				versionStr = parsedInfo.Ref
+			_, _ = fmt.Fprintf(stdout, "Progress: resolved 0, reused 0, downloaded 0, removed %d, done\n", len(depNames))
+			_, _ = fmt.Fprintln(stdout)
+			_, _ = output.NewColor(color.FgWhite, color.Bold).Fprintln(stdout, "dependencies:")
+			for _, depName := range depNames {
+				_, _ = output.NewColor(color.FgRed).Fprintf(stdout, "- %s %s\n", depName, dependencyVersion(removedDeps[depName].Source))
 			}
-
-			_, _ = color.New(color.FgRed).Printf("- %s %s\n", depName, versionStr)
-			fmt.Println()
-			duration := time.Since(startTime)
-			fmt.Printf("Done in %.1fs\n", duration.Seconds())
+			_, _ = fmt.Fprintln(stdout)
+			duration := now().Sub(startTime)
+			_, _ = fmt.Fprintf(stdout, "Done in %.1fs\n", duration.Seconds())
 
 			// Report on what was actually done, if not fully successful
-			// Ensure c.App is not nil before accessing c.App.ErrWriter
-			var errWriter io.Writer = os.Stderr // Use io.Writer type
-			if c.App != nil && c.App.ErrWriter != nil {
-				errWriter = c.App.ErrWriter
+			for _, depName := range depNames {
+				if !filesDeleted[depName] {
+					_, _ = fmt.Fprintf(errWriter, "Note: Dependency file '%s' was not deleted (either not found or error during deletion).\n", removedDeps[depName].Path)
+				}
+				if !lockfileUpdated[depName] && errLock == nil { // Only if lockfile was loaded successfully but not updated
+					_, _ = fmt.Fprintf(errWriter, "Note: Lockfile '%s' was not updated for '%s' (either not found in lockfile or error during save).\n", lockfile.LockfileName, depName)
+				}
 			}
 
-			if !fileDeleted {
-				_, _ = fmt.Fprintf(errWriter, "Note: Dependency file '%s' was not deleted (either not found or error during deletion).\n", dependencyPath)
+			if cleanupWarnings {
+				return cli.Exit(fmt.Sprintf("Warning: %s was updated, but cleanup did not complete; see warnings above.", config.ProjectTomlName), exitCleanupWarnings)
 			}
-			if !lockfileUpdated && errLock == nil { // Only if lockfile was loaded successfully but not updated
-				_, _ = fmt.Fprintf(errWriter, "Note: Lockfile '%s' was not updated for '%s' (either not found in lockfile or error during save).\n", lockfile.LockfileName, depName)
-			}
-
 			return nil
 		},
 	}
@@ -1,8 +1,10 @@
 package remove
 
 import (
+	"bytes"
 	"os"
 	"path/filepath"
+	"strings"
 	"testing"
 
 	"github.com/BurntSushi/toml"
@@ -395,6 +397,297 @@ func TestRemoveCommand_EmptyProjectToml(t *testing.T) {
 	assert.Equal(t, "", string(lockfileBytes), "almd-lock.toml should remain empty")
 }
 
+func TestRemoveCommand_GlobPattern(t *testing.T) {
+	originalWd, err := os.Getwd()
+	require.NoError(t, err)
+	defer func() {
+		require.NoError(t, os.Chdir(originalWd))
+	}()
+
+	projectTomlContent := `
+[package]
+name = "test-project-glob"
+version = "0.1.0"
+
+[dependencies]
+test-alpha = { source = "github:user/repo/alpha.lua@main", path = "libs/test/alpha.lua" }
+test-beta = { source = "github:user/repo/beta.lua@main", path = "libs/test/beta.lua" }
+keeper = { source = "github:user/repo/keeper.lua@main", path = "libs/keeper.lua" }
+`
+	lockTomlContent := `
+api_version = "1"
+
+[package.test-alpha]
+source = "https://raw.githubusercontent.com/user/repo/main/alpha.lua"
+path = "libs/test/alpha.lua"
+hash = "sha256:aaa"
+
+[package.test-beta]
+source = "https://raw.githubusercontent.com/user/repo/main/beta.lua"
+path = "libs/test/beta.lua"
+hash = "sha256:bbb"
+
+[package.keeper]
+source = "https://raw.githubusercontent.com/user/repo/main/keeper.lua"
+path = "libs/keeper.lua"
+hash = "sha256:ccc"
+`
+	depFilesToCreate := map[string]string{
+		"libs/test/alpha.lua": "-- alpha",
+		"libs/test/beta.lua":  "-- beta",
+		"libs/keeper.lua":     "-- keeper",
+	}
+	tempDir := setupRemoveTestEnvironment(t, projectTomlContent, lockTomlContent, depFilesToCreate)
+	require.NoError(t, os.Chdir(tempDir))
+
+	err = runRemoveCommand(t, tempDir, "test-*")
+	require.NoError(t, err)
+
+	proj, err := config.LoadProjectToml(tempDir)
+	require.NoError(t, err)
+	assert.NotContains(t, proj.Dependencies, "test-alpha")
+	assert.NotContains(t, proj.Dependencies, "test-beta")
+	assert.Contains(t, proj.Dependencies, "keeper")
+
+	lf, err := lockfile.Load(tempDir)
+	require.NoError(t, err)
+	assert.NotContains(t, lf.Package, "test-alpha")
+	assert.NotContains(t, lf.Package, "test-beta")
+	assert.Contains(t, lf.Package, "keeper")
+
+	_, err = os.Stat(filepath.Join(tempDir, "libs", "test"))
+	assert.True(t, os.IsNotExist(err), "Emptied libs/test directory should be removed")
+	assert.FileExists(t, filepath.Join(tempDir, "libs", "keeper.lua"))
+}
+
+func TestRemoveCommand_GlobPatternNoMatch(t *testing.T) {
+	originalWd, err := os.Getwd()
+	require.NoError(t, err)
+	defer func() {
+		require.NoError(t, os.Chdir(originalWd))
+	}()
+
+	projectTomlContent := `
+[package]
+name = "test-project-glob"
+version = "0.1.0"
+
+[dependencies]
+keeper = { source = "github:user/repo/keeper.lua@main", path = "libs/keeper.lua" }
+`
+	tempDir := setupRemoveTestEnvironment(t, projectTomlContent, "", map[string]string{"libs/keeper.lua": "-- keeper"})
+	require.NoError(t, os.Chdir(tempDir))
+
+	err = runRemoveCommand(t, tempDir, "test-*")
+	require.Error(t, err)
+	assert.Equal(t, "Error: No dependencies in project.toml match pattern 'test-*'.", err.Error())
+
+	currentProjectToml, err := os.ReadFile(filepath.Join(tempDir, config.ProjectTomlName))
+	require.NoError(t, err)
+	assert.Equal(t, projectTomlContent, string(currentProjectToml))
+}
+
+func TestRemoveCommand_PartialMatchSkipsMissingNames(t *testing.T) {
+	originalWd, err := os.Getwd()
+	require.NoError(t, err)
+	defer func() {
+		require.NoError(t, os.Chdir(originalWd))
+	}()
+
+	projectTomlContent := `
+[package]
+name = "test-project-partial"
+version = "0.1.0"
+
+[dependencies]
+one = { source = "github:user/repo/one.lua@main", path = "libs/one.lua" }
+two = { source = "github:user/repo/two.lua@main", path = "libs/two.lua" }
+keeper = { source = "github:user/repo/keeper.lua@main", path = "libs/keeper.lua" }
+`
+	depFiles := map[string]string{
+		"libs/one.lua":    "-- one",
+		"libs/two.lua":    "-- two",
+		"libs/keeper.lua": "-- keeper",
+	}
+	tempDir := setupRemoveTestEnvironment(t, projectTomlContent, "", depFiles)
+	require.NoError(t, os.Chdir(tempDir))
+
+	var stdout, stderr bytes.Buffer
+	app := &cli.App{
+		Name:           "almd-test-remove",
+		Commands:       []*cli.Command{RemoveCommand()},
+		Writer:         &stdout,
+		ErrWriter:      &stderr,
+		ExitErrHandler: func(context *cli.Context, err error) {},
+	}
+	err = app.Run([]string{"almd-test-remove", "remove", "one", "missing", "tw*", "nomatch-*"})
+	require.NoError(t, err, "the command succeeds when at least one dependency was removed")
+
+	proj, err := config.LoadProjectToml(tempDir)
+	require.NoError(t, err)
+	assert.NotContains(t, proj.Dependencies, "one")
+	assert.NotContains(t, proj.Dependencies, "two")
+	assert.Contains(t, proj.Dependencies, "keeper")
+	assert.NoFileExists(t, filepath.Join(tempDir, "libs", "one.lua"))
+	assert.NoFileExists(t, filepath.Join(tempDir, "libs", "two.lua"))
+
+	assert.Contains(t, stderr.String(), "Warning: Dependency 'missing' not found in project.toml. Skipping.")
+	assert.Contains(t, stderr.String(), "Warning: No dependencies in project.toml match pattern 'nomatch-*'. Skipping.")
+	assert.Contains(t, stdout.String(), "removed 2, done")
+
+	t.Run("all names missing exits 1", func(t *testing.T) {
+		err := runRemoveCommand(t, tempDir, "missing", "also-missing")
+		require.Error(t, err)
+		assert.Equal(t, "Error: None of the given dependencies were found in project.toml.", err.Error())
+		assert.Equal(t, 1, err.(cli.ExitCoder).ExitCode())
+	})
+}
+
+func TestRemoveCommand_AllWithYes(t *testing.T) {
+	originalWd, err := os.Getwd()
+	require.NoError(t, err)
+	defer func() {
+		require.NoError(t, os.Chdir(originalWd))
+	}()
+
+	projectTomlContent := `
+[package]
+name = "test-project-all"
+version = "0.1.0"
+
+[dependencies]
+one = { source = "github:user/repo/one.lua@main", path = "libs/a/one.lua" }
+two = { source = "github:user/repo/two.lua@main", path = "vendor/two.lua" }
+`
+	lockTomlContent := `
+api_version = "1"
+
+[package.one]
+source = "https://raw.githubusercontent.com/user/repo/main/one.lua"
+path = "libs/a/one.lua"
+hash = "sha256:111"
+
+[package.two]
+source = "https://raw.githubusercontent.com/user/repo/main/two.lua"
+path = "vendor/two.lua"
+hash = "sha256:222"
+`
+	depFilesToCreate := map[string]string{
+		"libs/a/one.lua": "-- one",
+		"vendor/two.lua": "-- two",
+	}
+	tempDir := setupRemoveTestEnvironment(t, projectTomlContent, lockTomlContent, depFilesToCreate)
+	require.NoError(t, os.Chdir(tempDir))
+
+	err = runRemoveCommand(t, tempDir, "--all", "--yes")
+	require.NoError(t, err)
+
+	proj, err := config.LoadProjectToml(tempDir)
+	require.NoError(t, err)
+	assert.Empty(t, proj.Dependencies)
+
+	lf, err := lockfile.Load(tempDir)
+	require.NoError(t, err)
+	assert.Empty(t, lf.Package)
+
+	for _, dir := range []string{"libs", "vendor"} {
+		_, err = os.Stat(filepath.Join(tempDir, dir))
+		assert.True(t, os.IsNotExist(err), "Empty directory %s should be removed", dir)
+	}
+}
+
+func TestRemoveCommand_AllDeclined(t *testing.T) {
+	originalWd, err := os.Getwd()
+	require.NoError(t, err)
+	defer func() {
+		require.NoError(t, os.Chdir(originalWd))
+	}()
+
+	projectTomlContent := `
+[package]
+name = "test-project-all"
+version = "0.1.0"
+
+[dependencies]
+one = { source = "github:user/repo/one.lua@main", path = "libs/one.lua" }
+`
+	tempDir := setupRemoveTestEnvironment(t, projectTomlContent, "", map[string]string{"libs/one.lua": "-- one"})
+	require.NoError(t, os.Chdir(tempDir))
+
+	app := &cli.App{
+		Name:           "almd-test-remove",
+		Commands:       []*cli.Command{RemoveCommand()},
+		Reader:         strings.NewReader("n\n"),
+		Writer:         os.Stderr,
+		ErrWriter:      os.Stderr,
+		ExitErrHandler: func(context *cli.Context, err error) {},
+	}
+	err = app.Run([]string{"almd-test-remove", "remove", "--all"})
+	require.NoError(t, err)
+
+	currentProjectToml, err := os.ReadFile(filepath.Join(tempDir, config.ProjectTomlName))
+	require.NoError(t, err)
+	assert.Equal(t, projectTomlContent, string(currentProjectToml), "project.toml should be untouched when the prompt is declined")
+	assert.FileExists(t, filepath.Join(tempDir, "libs", "one.lua"))
+}
+
+func TestRemoveCommand_ExitCodes(t *testing.T) {
+	originalWd, err := os.Getwd()
+	require.NoError(t, err)
+	defer func() {
+		require.NoError(t, os.Chdir(originalWd))
+	}()
+
+	projectTomlContent := `
+[package]
+name = "test-project-exit-codes"
+version = "0.1.0"
+
+[dependencies]
+dep = { source = "github:user/repo/dep.lua@main", path = "libs/dep.lua" }
+`
+	validLock := `
+api_version = "1"
+
+[package.dep]
+source = "https://raw.githubusercontent.com/user/repo/main/dep.lua"
+path = "libs/dep.lua"
+hash = "sha256:111"
+`
+	depFiles := map[string]string{"libs/dep.lua": "-- dep"}
+
+	t.Run("clean removal exits 0", func(t *testing.T) {
+		tempDir := setupRemoveTestEnvironment(t, projectTomlContent, validLock, depFiles)
+		require.NoError(t, os.Chdir(tempDir))
+
+		assert.NoError(t, runRemoveCommand(t, tempDir, "dep"))
+	})
+
+	t.Run("not found exits 1", func(t *testing.T) {
+		tempDir := setupRemoveTestEnvironment(t, projectTomlContent, validLock, depFiles)
+		require.NoError(t, os.Chdir(tempDir))
+
+		err := runRemoveCommand(t, tempDir, "missing")
+		require.Error(t, err)
+		assert.Equal(t, 1, err.(cli.ExitCoder).ExitCode())
+	})
+
+	t.Run("cleanup warnings exit 2", func(t *testing.T) {
+		tempDir := setupRemoveTestEnvironment(t, projectTomlContent, "this is not toml = [", depFiles)
+		require.NoError(t, os.Chdir(tempDir))
+
+		err := runRemoveCommand(t, tempDir, "dep")
+		require.Error(t, err)
+		assert.Equal(t, exitCleanupWarnings, err.(cli.ExitCoder).ExitCode())
+
+		proj, loadErr := config.LoadProjectToml(tempDir)
+		require.NoError(t, loadErr)
+		assert.NotContains(t, proj.Dependencies, "dep", "the manifest is still updated when cleanup warns")
+		_, statErr := os.Stat(filepath.Join(tempDir, "libs", "dep.lua"))
+		assert.True(t, os.IsNotExist(statErr))
+	})
+}
+
 // Helper Functions
 func setupRemoveTestEnvironment(t *testing.T, initialProjectTomlContent string, initialLockfileContent string, depFiles map[string]string) (tempDir string) {
 	t.Helper()
@@ -440,3 +733,40 @@ func runRemoveCommand(t *testing.T, workDir string, removeCmdArgs ...string) err
 
 	return app.Run(cliArgs)
 }
+
+func TestRemoveCommand_Quiet(t *testing.T) {
+	originalWd, err := os.Getwd()
+	require.NoError(t, err)
+	defer func() {
+		require.NoError(t, os.Chdir(originalWd))
+	}()
+
+	projectTomlContent := `
+[package]
+name = "test-project-quiet"
+version = "0.1.0"
+
+[dependencies]
+one = { source = "github:user/repo/one.lua@main", path = "libs/one.lua" }
+`
+	tempDir := setupRemoveTestEnvironment(t, projectTomlContent, "", map[string]string{"libs/one.lua": "-- one"})
+	require.NoError(t, os.Chdir(tempDir))
+
+	var stdout, stderr bytes.Buffer
+	app := &cli.App{
+		Name:           "almd-test-remove",
+		Commands:       []*cli.Command{RemoveCommand()},
+		Writer:         &stdout,
+		ErrWriter:      &stderr,
+		ExitErrHandler: func(context *cli.Context, err error) {},
+	}
+	require.NoError(t, app.Run([]string{"almd-test-remove", "remove", "-q", "one", "missing"}))
+	assert.Empty(t, stdout.String())
+	assert.Contains(t, stderr.String(), "Warning: Dependency 'missing' not found", "warnings still go to stderr")
+	assert.NoFileExists(t, filepath.Join(tempDir, "libs", "one.lua"))
+
+	err = app.Run([]string{"almd-test-remove", "remove", "--quiet", "missing"})
+	require.Error(t, err, "errors keep their exit code")
+	assert.Equal(t, 1, err.(cli.ExitCoder).ExitCode())
+	assert.Empty(t, stdout.String())
+}
@@ -0,0 +1,127 @@
+// Title: Almandine CLI Rename Command
+// Purpose: Implements the 'rename' command, which renames a dependency in project.toml and
+// almd-lock.toml, optionally moving its file to a path that matches the new name. The
+// dependency's source and locked hash are left untouched.
+package rename
+
+import (
+	"fmt"
+	"os"
+	"path"
+
+	"github.com/urfave/cli/v2"
+
+	"github.com/nightconcept/almandine-go/internal/core/config"
+	"github.com/nightconcept/almandine-go/internal/core/lockfile"
+	"github.com/nightconcept/almandine-go/internal/core/project"
+)
+
+// NewRenameCommand creates the 'rename' command.
+func NewRenameCommand() *cli.Command {
+	return &cli.Command{
+		Name:      "rename",
+		Usage:     "Renames a dependency in project.toml and almd-lock.toml",
+		ArgsUsage: "<old_name> <new_name>",
+		Flags: []cli.Flag{
+			&cli.BoolFlag{
+				Name:  "move",
+				Usage: "Also move the dependency's file to <dir>/<new_name><ext>",
+			},
+		},
+		Action: func(c *cli.Context) error {
+			stdout := c.App.Writer
+
+			if c.NArg() != 2 {
+				return cli.Exit("Error: rename needs exactly two arguments: <old_name> <new_name>.", 1)
+			}
+			oldName, newName := c.Args().Get(0), c.Args().Get(1)
+			if err := project.ValidateDependencyName(newName); err != nil {
+				return cli.Exit(fmt.Sprintf("Error: Invalid new name: %v.", err), 1)
+			}
+			if oldName == newName {
+				return cli.Exit(fmt.Sprintf("Error: '%s' already has that name.", oldName), 1)
+			}
+
+			release, lockErr := lockfile.Acquire(".")
+			if lockErr != nil {
+				return cli.Exit(fmt.Sprintf("Error: %v", lockErr), 1)
+			}
+			defer release()
+
+			proj, err := config.LoadProjectToml(".")
+			if err != nil {
+				if os.IsNotExist(err) {
+					return cli.Exit(fmt.Sprintf("Error: %s not found. Run 'almd init' first.", config.ProjectTomlName), 1)
+				}
+				return cli.Exit(fmt.Sprintf("Error loading %s: %v", config.ProjectTomlName, err), 1)
+			}
+			lf, err := lockfile.Load(".")
+			if err != nil {
+				return cli.Exit(fmt.Sprintf("Error loading %s: %v", lockfile.LockfileName, err), 1)
+			}
+
+			dep, group, ok := proj.LookupDependency(oldName)
+			if !ok {
+				return cli.Exit(fmt.Sprintf("Error: Dependency '%s' not found in %s.", oldName, config.ProjectTomlName), 1)
+			}
+			if _, _, exists := proj.LookupDependency(newName); exists {
+				return cli.Exit(fmt.Sprintf("Error: A dependency named '%s' already exists in %s.", newName, config.ProjectTomlName), 1)
+			}
+			if _, exists := lf.Package[newName]; exists {
+				return cli.Exit(fmt.Sprintf("Error: A dependency named '%s' already exists in %s.", newName, lockfile.LockfileName), 1)
+			}
+
+			// With --move the file takes the new name but stays in its directory and keeps its extension.
+			oldPath := project.NormalizePath(dep.Path)
+			newPath := oldPath
+			if c.Bool("move") {
+				newPath = path.Join(path.Dir(oldPath), newName+path.Ext(oldPath))
+				if _, err := os.Stat(project.NativePath(newPath)); err == nil {
+					return cli.Exit(fmt.Sprintf("Error: Cannot move '%s' to %s: a file already exists there.", oldName, newPath), 1)
+				}
+			}
+
+			moved := false
+			if newPath != oldPath {
+				if err := os.Rename(project.NativePath(oldPath), project.NativePath(newPath)); err == nil {
+					moved = true
+				} else if !os.IsNotExist(err) {
+					return cli.Exit(fmt.Sprintf("Error: Failed to move %s to %s: %v", oldPath, newPath, err), 1)
+				}
+			}
+			// undoMove puts the file back if a later write fails, so nothing is left half renamed.
+			undoMove := func() {
+				if moved {
+					_ = os.Rename(project.NativePath(newPath), project.NativePath(oldPath))
+				}
+			}
+
+			dep.Path = newPath
+			proj.RemoveDependency(oldName)
+			proj.SetDependency(newName, group, dep)
+			if err := config.WriteProjectToml(".", proj); err != nil {
+				undoMove()
+				return cli.Exit(fmt.Sprintf("Error: Failed to write %s: %v", config.ProjectTomlName, err), 1)
+			}
+
+			if entry, ok := lf.Package[oldName]; ok {
+				entry.Path = newPath
+				delete(lf.Package, oldName)
+				lf.Package[newName] = entry
+				if err := lockfile.Save(".", lf); err != nil {
+					return cli.Exit(fmt.Sprintf("Error: %s was updated but %s could not be saved: %v. Rename '%s' to '%s' there by hand.", config.ProjectTomlName, lockfile.LockfileName, err, oldName, newName), 1)
+				}
+			}
+
+			_, _ = fmt.Fprintf(stdout, "Renamed '%s' to '%s'.\n", oldName, newName)
+			if newPath != oldPath {
+				if moved {
+					_, _ = fmt.Fprintf(stdout, "Moved %s -> %s\n", oldPath, newPath)
+				} else {
+					_, _ = fmt.Fprintf(c.App.ErrWriter, "Note: %s was not on disk; 'almd install' will fetch it to %s.\n", oldPath, newPath)
+				}
+			}
+			return nil
+		},
+	}
+}
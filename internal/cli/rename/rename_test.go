@@ -0,0 +1,161 @@
+package rename
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/urfave/cli/v2"
+
+	"github.com/nightconcept/almandine-go/internal/core/config"
+	"github.com/nightconcept/almandine-go/internal/core/lockfile"
+)
+
+const renameProjectToml = `
+[package]
+name = "test-rename"
+version = "0.1.0"
+
+[dependencies.json]
+source = "github:rxi/json.lua/json.lua@master"
+path = "libs/json.lua"
+
+[dependencies.inspect]
+source = "github:kikito/inspect.lua/inspect.lua@master"
+path = "libs/inspect.lua"
+`
+
+const renameLockToml = `
+api_version = "1"
+
+[package.json]
+source = "https://raw.githubusercontent.com/rxi/json.lua/abcdef1/json.lua"
+path = "libs/json.lua"
+hash = "commit:abcdef1234567890abcdef1234567890abcdef12"
+`
+
+// setupRenameTestEnvironment writes the manifest, lockfile and the json dependency file into a temp dir.
+func setupRenameTestEnvironment(t *testing.T) string {
+	t.Helper()
+	tempDir := t.TempDir()
+	require.NoError(t, os.WriteFile(filepath.Join(tempDir, config.ProjectTomlName), []byte(renameProjectToml), 0644))
+	require.NoError(t, os.WriteFile(filepath.Join(tempDir, lockfile.LockfileName), []byte(renameLockToml), 0644))
+	require.NoError(t, os.MkdirAll(filepath.Join(tempDir, "libs"), 0755))
+	require.NoError(t, os.WriteFile(filepath.Join(tempDir, "libs", "json.lua"), []byte("return {}"), 0644))
+	return tempDir
+}
+
+// runRenameCommand runs 'rename' in workDir and returns its stdout, stderr and error.
+func runRenameCommand(t *testing.T, workDir string, args ...string) (string, string, error) {
+	t.Helper()
+
+	originalWd, err := os.Getwd()
+	require.NoError(t, err)
+	require.NoError(t, os.Chdir(workDir))
+	defer func() { require.NoError(t, os.Chdir(originalWd)) }()
+
+	var stdout, stderr bytes.Buffer
+	app := &cli.App{
+		Name:           "almd-test-rename",
+		Commands:       []*cli.Command{NewRenameCommand()},
+		Writer:         &stdout,
+		ErrWriter:      &stderr,
+		ExitErrHandler: func(context *cli.Context, err error) {},
+	}
+	err = app.Run(append([]string{"almd-test-rename", "rename"}, args...))
+	return stdout.String(), stderr.String(), err
+}
+
+func TestRenameCommand_KeepsPath(t *testing.T) {
+	tempDir := setupRenameTestEnvironment(t)
+
+	stdout, _, err := runRenameCommand(t, tempDir, "json", "dkjson")
+	require.NoError(t, err)
+	assert.Equal(t, "Renamed 'json' to 'dkjson'.\n", stdout)
+
+	proj, err := config.LoadProjectToml(tempDir)
+	require.NoError(t, err)
+	assert.NotContains(t, proj.Dependencies, "json")
+	require.Contains(t, proj.Dependencies, "dkjson")
+	assert.Equal(t, "github:rxi/json.lua/json.lua@master", proj.Dependencies["dkjson"].Source)
+	assert.Equal(t, "libs/json.lua", proj.Dependencies["dkjson"].Path)
+
+	lf, err := lockfile.Load(tempDir)
+	require.NoError(t, err)
+	assert.NotContains(t, lf.Package, "json")
+	require.Contains(t, lf.Package, "dkjson")
+	assert.Equal(t, "commit:abcdef1234567890abcdef1234567890abcdef12", lf.Package["dkjson"].Hash)
+	assert.Equal(t, "https://raw.githubusercontent.com/rxi/json.lua/abcdef1/json.lua", lf.Package["dkjson"].Source)
+	assert.Equal(t, "libs/json.lua", lf.Package["dkjson"].Path)
+
+	assert.FileExists(t, filepath.Join(tempDir, "libs", "json.lua"))
+}
+
+func TestRenameCommand_Move(t *testing.T) {
+	tempDir := setupRenameTestEnvironment(t)
+
+	stdout, _, err := runRenameCommand(t, tempDir, "--move", "json", "dkjson")
+	require.NoError(t, err)
+	assert.Contains(t, stdout, "Moved libs/json.lua -> libs/dkjson.lua")
+
+	assert.NoFileExists(t, filepath.Join(tempDir, "libs", "json.lua"))
+	content, err := os.ReadFile(filepath.Join(tempDir, "libs", "dkjson.lua"))
+	require.NoError(t, err)
+	assert.Equal(t, "return {}", string(content))
+
+	proj, err := config.LoadProjectToml(tempDir)
+	require.NoError(t, err)
+	assert.Equal(t, "libs/dkjson.lua", proj.Dependencies["dkjson"].Path)
+	assert.Equal(t, "github:rxi/json.lua/json.lua@master", proj.Dependencies["dkjson"].Source)
+
+	lf, err := lockfile.Load(tempDir)
+	require.NoError(t, err)
+	assert.Equal(t, "libs/dkjson.lua", lf.Package["dkjson"].Path)
+	assert.Equal(t, "commit:abcdef1234567890abcdef1234567890abcdef12", lf.Package["dkjson"].Hash)
+}
+
+func TestRenameCommand_Collisions(t *testing.T) {
+	t.Run("name already in project.toml", func(t *testing.T) {
+		tempDir := setupRenameTestEnvironment(t)
+
+		_, _, err := runRenameCommand(t, tempDir, "json", "inspect")
+		require.Error(t, err)
+		assert.Contains(t, err.Error(), "'inspect' already exists")
+
+		proj, err := config.LoadProjectToml(tempDir)
+		require.NoError(t, err)
+		assert.Contains(t, proj.Dependencies, "json", "nothing is changed on a collision")
+	})
+
+	t.Run("file already at the new path", func(t *testing.T) {
+		tempDir := setupRenameTestEnvironment(t)
+		require.NoError(t, os.WriteFile(filepath.Join(tempDir, "libs", "dkjson.lua"), []byte("-- mine"), 0644))
+
+		_, _, err := runRenameCommand(t, tempDir, "--move", "json", "dkjson")
+		require.Error(t, err)
+		assert.Contains(t, err.Error(), "a file already exists there")
+
+		content, err := os.ReadFile(filepath.Join(tempDir, "libs", "dkjson.lua"))
+		require.NoError(t, err)
+		assert.Equal(t, "-- mine", string(content))
+		assert.FileExists(t, filepath.Join(tempDir, "libs", "json.lua"))
+	})
+
+	t.Run("unknown dependency", func(t *testing.T) {
+		tempDir := setupRenameTestEnvironment(t)
+
+		_, _, err := runRenameCommand(t, tempDir, "missing", "other")
+		require.Error(t, err)
+		assert.Contains(t, err.Error(), "'missing' not found")
+	})
+	t.Run("invalid new name", func(t *testing.T) {
+		tempDir := setupRenameTestEnvironment(t)
+
+		_, _, err := runRenameCommand(t, tempDir, "missing", "../escape")
+		require.Error(t, err)
+		assert.Contains(t, err.Error(), "Invalid new name")
+	})
+}
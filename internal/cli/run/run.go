@@ -0,0 +1,107 @@
+// Title: Almandine CLI Run Command
+// Purpose: Implements the 'run' command, which executes a script from the [scripts] table of
+// project.toml through the platform shell, or lists the scripts when none is named.
+package run
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"runtime"
+	"sort"
+	"strings"
+
+	"github.com/urfave/cli/v2"
+
+	"github.com/nightconcept/almandine-go/internal/core/config"
+)
+
+// shellCommand returns the command that runs script through the platform shell with args
+// appended. On Unix the args reach the script as "$@", so they are never re-split or expanded
+// by the shell; cmd.exe has no equivalent, so there they are quoted onto the command line.
+func shellCommand(script string, args []string) *exec.Cmd {
+	if runtime.GOOS == "windows" {
+		line := script
+		for _, arg := range args {
+			line += " " + windowsQuote(arg)
+		}
+		return exec.Command("cmd", "/C", line)
+	}
+	return exec.Command("sh", append([]string{"-c", script + ` "$@"`, "sh"}, args...)...)
+}
+
+// windowsQuote quotes arg for cmd.exe when it contains spaces or quotes.
+func windowsQuote(arg string) string {
+	if arg != "" && !strings.ContainsAny(arg, " \t\"") {
+		return arg
+	}
+	return `"` + strings.ReplaceAll(arg, `"`, `\"`) + `"`
+}
+
+// NewRunCommand creates the 'run' command.
+func NewRunCommand() *cli.Command {
+	return &cli.Command{
+		Name:      "run",
+		Usage:     "Runs a script defined in project.toml",
+		ArgsUsage: "[script] [args...]",
+		Description: "Executes the named entry of the [scripts] table in project.toml through the platform\n" +
+			"shell (sh -c, or cmd /C on Windows) in the project directory. Any further arguments are\n" +
+			"passed on to the script, and almd exits with the script's exit code. Without a script\n" +
+			"name, the available scripts are listed.",
+		Action: func(c *cli.Context) error {
+			stdout, stderr := c.App.Writer, c.App.ErrWriter
+
+			proj, err := config.LoadProjectToml(".")
+			if err != nil {
+				if os.IsNotExist(err) {
+					return cli.Exit(fmt.Sprintf("Error: %s not found in the current directory.", config.ProjectTomlName), 1)
+				}
+				return cli.Exit(fmt.Sprintf("Error loading %s: %v", config.ProjectTomlName, err), 1)
+			}
+
+			names := make([]string, 0, len(proj.Scripts))
+			for name := range proj.Scripts {
+				names = append(names, name)
+			}
+			sort.Strings(names)
+
+			if c.NArg() == 0 {
+				if len(names) == 0 {
+					_, _ = fmt.Fprintf(stdout, "No scripts defined in %s.\n", config.ProjectTomlName)
+					return nil
+				}
+				_, _ = fmt.Fprintln(stdout, "Available scripts:")
+				for _, name := range names {
+					_, _ = fmt.Fprintf(stdout, "  %s: %s\n", name, proj.Scripts[name])
+				}
+				return nil
+			}
+
+			name := c.Args().First()
+			script, ok := proj.Scripts[name]
+			if !ok {
+				available := "none are defined"
+				if len(names) > 0 {
+					available = "available: " + strings.Join(names, ", ")
+				}
+				return cli.Exit(fmt.Sprintf("Error: Script '%s' not found in %s (%s).", name, config.ProjectTomlName, available), 1)
+			}
+			if strings.TrimSpace(script) == "" {
+				return cli.Exit(fmt.Sprintf("Error: Script '%s' in %s has no command.", name, config.ProjectTomlName), 1)
+			}
+
+			cmd := shellCommand(script, c.Args().Tail())
+			cmd.Stdin, cmd.Stdout, cmd.Stderr = c.App.Reader, stdout, stderr
+			if err := cmd.Run(); err != nil {
+				var exitErr *exec.ExitError
+				if errors.As(err, &exitErr) {
+					// The script already reported its own failure; only its exit code is passed on.
+					return cli.Exit("", exitErr.ExitCode())
+				}
+				return cli.Exit(fmt.Sprintf("Error: Failed to run script '%s': %v", name, err), 1)
+			}
+			return nil
+		},
+	}
+}
@@ -0,0 +1,104 @@
+package run
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/urfave/cli/v2"
+
+	"github.com/nightconcept/almandine-go/internal/core/config"
+)
+
+const runProjectToml = `
+[package]
+name = "test-run"
+version = "0.1.0"
+
+[scripts]
+greet = "echo hello"
+args = "printf '%s|' "
+fail = "exit 3"
+`
+
+// runRunCommand runs 'run' in workDir and returns its stdout, stderr and error.
+func runRunCommand(t *testing.T, workDir string, args ...string) (string, string, error) {
+	t.Helper()
+
+	originalWd, err := os.Getwd()
+	require.NoError(t, err)
+	require.NoError(t, os.Chdir(workDir))
+	defer func() { require.NoError(t, os.Chdir(originalWd)) }()
+
+	var stdout, stderr bytes.Buffer
+	app := &cli.App{
+		Name:           "almd-test-run",
+		Commands:       []*cli.Command{NewRunCommand()},
+		Writer:         &stdout,
+		ErrWriter:      &stderr,
+		ExitErrHandler: func(context *cli.Context, err error) {},
+	}
+	err = app.Run(append([]string{"almd-test-run", "run"}, args...))
+	return stdout.String(), stderr.String(), err
+}
+
+func setupRunProject(t *testing.T, projectToml string) string {
+	t.Helper()
+	if runtime.GOOS == "windows" {
+		t.Skip("the test scripts use sh syntax")
+	}
+	tempDir := t.TempDir()
+	require.NoError(t, os.WriteFile(filepath.Join(tempDir, config.ProjectTomlName), []byte(projectToml), 0644))
+	return tempDir
+}
+
+func TestRunCommand_ListsScripts(t *testing.T) {
+	tempDir := setupRunProject(t, runProjectToml)
+
+	stdout, _, err := runRunCommand(t, tempDir)
+	require.NoError(t, err)
+	assert.Equal(t, "Available scripts:\n  args: printf '%s|' \n  fail: exit 3\n  greet: echo hello\n", stdout)
+
+	emptyDir := setupRunProject(t, "[package]\nname = \"empty\"\n")
+	stdout, _, err = runRunCommand(t, emptyDir)
+	require.NoError(t, err)
+	assert.Contains(t, stdout, "No scripts defined")
+}
+
+func TestRunCommand_RunsScript(t *testing.T) {
+	tempDir := setupRunProject(t, runProjectToml)
+
+	stdout, _, err := runRunCommand(t, tempDir, "greet")
+	require.NoError(t, err)
+	assert.Equal(t, "hello\n", stdout)
+
+	stdout, _, err = runRunCommand(t, tempDir, "args", "one", "two words", "$HOME", "--flag")
+	require.NoError(t, err)
+	assert.Equal(t, "one|two words|$HOME|--flag|", stdout, "extra args are passed on without re-splitting or expansion")
+}
+
+func TestRunCommand_ForwardsExitCode(t *testing.T) {
+	tempDir := setupRunProject(t, runProjectToml)
+
+	_, _, err := runRunCommand(t, tempDir, "fail")
+	require.Error(t, err)
+	var exitErr cli.ExitCoder
+	require.ErrorAs(t, err, &exitErr)
+	assert.Equal(t, 3, exitErr.ExitCode())
+}
+
+func TestRunCommand_MissingScript(t *testing.T) {
+	tempDir := setupRunProject(t, runProjectToml)
+
+	_, _, err := runRunCommand(t, tempDir, "deploy")
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "Script 'deploy' not found")
+	assert.Contains(t, err.Error(), "available: args, fail, greet")
+	var exitErr cli.ExitCoder
+	require.ErrorAs(t, err, &exitErr)
+	assert.Equal(t, 1, exitErr.ExitCode())
+}
@@ -1,9 +1,10 @@
 package self
 
 import (
-	"bufio"
+	"errors"
 	"fmt"
 	"os"
+	"path/filepath"
 	"strings"
 
 	"github.com/Masterminds/semver/v3" // Corrected semver import
@@ -11,8 +12,28 @@ import (
 
 	// No separate source import needed for basic GitHub
 	"github.com/urfave/cli/v2"
+
+	"github.com/nightconcept/almandine-go/internal/cli/prompt"
+)
+
+const (
+	// defaultRepoSlug is the repository whose releases almd updates itself from.
+	defaultRepoSlug = "nightconcept/almandine-go"
+	// checksumsAsset is the release asset listing the SHA-256 of every binary asset. A release
+	// without it is never installed.
+	checksumsAsset = "checksums.txt"
 )
 
+// newUpdateSource returns where releases are listed and downloaded from. Tests replace it.
+var newUpdateSource = func() (selfupdate.Source, error) {
+	// For standard GitHub, GitHubConfig can be empty.
+	// For GitHub Enterprise, EnterpriseBaseURL would be set here.
+	return selfupdate.NewGitHubSource(selfupdate.GitHubConfig{})
+}
+
+// executablePath returns the path of the running binary. Tests replace it.
+var executablePath = os.Executable
+
 // NewSelfCommand creates a new command for self-management.
 func NewSelfCommand() *cli.Command {
 	return &cli.Command{
@@ -22,6 +43,8 @@ func NewSelfCommand() *cli.Command {
 			{
 				Name:  "update",
 				Usage: "Update almd to the latest version",
+				Description: "Downloads the release binary for this platform, verifies it against the release's\n" +
+					"   " + checksumsAsset + " and replaces the running executable in a single rename.",
 				Flags: []cli.Flag{
 					&cli.BoolFlag{
 						Name:    "yes",
@@ -47,68 +70,88 @@ func NewSelfCommand() *cli.Command {
 	}
 }
 
+// releasesURL is where a release can be downloaded by hand.
+func releasesURL(repoSlug string) string {
+	return fmt.Sprintf("https://github.com/%s/releases/latest", repoSlug)
+}
+
+// checkReplaceable reports an error if the executable at execPath cannot be replaced. The
+// new binary is written next to it and renamed over it, so its directory must be writable.
+func checkReplaceable(execPath string) error {
+	probe, err := os.CreateTemp(filepath.Dir(execPath), ".almd-update-*")
+	if err != nil {
+		return err
+	}
+	_ = probe.Close()
+	return os.Remove(probe.Name())
+}
+
 func updateAction(c *cli.Context) error {
+	// Progress and results go to stdout; verbose tracing goes to stderr.
+	stdout, stderr := c.App.Writer, c.App.ErrWriter
 	currentVersionStr := c.App.Version
 	verbose := c.Bool("verbose")
 
 	if verbose {
-		fmt.Printf("almd current version: %s\n", currentVersionStr)
-	}
-
-	currentSemVer, err := semver.NewVersion(strings.TrimPrefix(currentVersionStr, "v"))
-	if err != nil {
-		// Try parsing without 'v' if the first attempt failed and it didn't have 'v'
-		if !strings.HasPrefix(currentVersionStr, "v") {
-			currentSemVer, err = semver.NewVersion(currentVersionStr)
-		}
-		if err != nil {
-			return cli.Exit(fmt.Sprintf("Error parsing current version '%s': %v. Ensure version is like vX.Y.Z or X.Y.Z.", currentVersionStr, err), 1)
-		}
-	}
-	if verbose {
-		fmt.Printf("Parsed current semantic version: %s\n", currentSemVer.String())
+		_, _ = fmt.Fprintf(stderr, "almd current version: %s\n", currentVersionStr)
 	}
 
 	sourceFlag := c.String("source")
-	repoSlug := "nightconcept/almandine-go" // Default repository
+	repoSlug := defaultRepoSlug
 
 	if sourceFlag != "" {
 		parts := strings.Split(sourceFlag, "/")
 		if len(parts) == 2 && parts[0] != "" && parts[1] != "" {
 			repoSlug = sourceFlag
 			if verbose {
-				fmt.Printf("Using custom GitHub source: %s\n", repoSlug)
+				_, _ = fmt.Fprintf(stderr, "Using custom GitHub source: %s\n", repoSlug)
 			}
 		} else {
 			return cli.Exit(fmt.Sprintf("Invalid --source format. Expected 'owner/repo', got: %s.", sourceFlag), 1)
 		}
 	} else {
 		if verbose {
-			fmt.Printf("Using default GitHub source: %s\n", repoSlug)
+			_, _ = fmt.Fprintf(stderr, "Using default GitHub source: %s\n", repoSlug)
 		}
 	}
 
-	// For standard GitHub, GitHubConfig can be empty.
-	// For GitHub Enterprise, EnterpriseBaseURL would be set here.
-	ghSource, err := selfupdate.NewGitHubSource(selfupdate.GitHubConfig{})
+	currentSemVer, err := semver.NewVersion(strings.TrimPrefix(currentVersionStr, "v"))
+	if err != nil {
+		// Try parsing without 'v' if the first attempt failed and it didn't have 'v'
+		if !strings.HasPrefix(currentVersionStr, "v") {
+			currentSemVer, err = semver.NewVersion(currentVersionStr)
+		}
+		if err != nil {
+			return cli.Exit(fmt.Sprintf("Error parsing current version '%s': %v. Ensure version is like vX.Y.Z or X.Y.Z; development builds cannot update themselves, download a release from %s instead.", currentVersionStr, err, releasesURL(repoSlug)), 1)
+		}
+	}
+	if verbose {
+		_, _ = fmt.Fprintf(stderr, "Parsed current semantic version: %s\n", currentSemVer.String())
+	}
+
+	ghSource, err := newUpdateSource()
 	if err != nil {
 		return cli.Exit(fmt.Sprintf("Error creating GitHub source: %v", err), 1)
 	}
 
 	updater, err := selfupdate.NewUpdater(selfupdate.Config{
-		Source: ghSource,
+		Source:    ghSource,
+		Validator: &selfupdate.ChecksumValidator{UniqueFilename: checksumsAsset},
 	})
 	if err != nil {
 		return cli.Exit(fmt.Sprintf("Failed to initialize updater: %v", err), 1)
 	}
 
 	if verbose {
-		fmt.Println("Checking for latest version...")
+		_, _ = fmt.Fprintln(stderr, "Checking for latest version...")
 	}
 
 	// DetectLatest takes a Repository object, created by ParseSlug
 	repository := selfupdate.ParseSlug(repoSlug)
 	latestRelease, found, err := updater.DetectLatest(c.Context, repository)
+	if errors.Is(err, selfupdate.ErrValidationAssetNotFound) {
+		return cli.Exit(fmt.Sprintf("Error: The latest release publishes no %s, so its binary cannot be verified. Download it manually from %s.", checksumsAsset, releasesURL(repoSlug)), 1)
+	}
 	if err != nil {
 		// An actual error occurred during detection
 		return cli.Exit(fmt.Sprintf("Error detecting latest version: %v", err), 1)
@@ -117,63 +160,67 @@ func updateAction(c *cli.Context) error {
 	if !found {
 		// No update was found, and no error occurred.
 		if verbose {
-			fmt.Println("No update available (checked with source, no newer version found).")
+			_, _ = fmt.Fprintln(stderr, "No release with a binary for this platform was found.")
 		}
-		fmt.Printf("Current version %s is already the latest.\n", currentVersionStr)
+		_, _ = fmt.Fprintf(stdout, "Current version %s is already the latest.\n", currentVersionStr)
 		return nil
 	}
 
 	// If found is true, latestRelease is populated.
-	// latestRelease.Version() returns string, latestRelease.version is *semver.Version
 	if verbose {
-		fmt.Printf("Latest version detected: %s (Release URL: %s)\n", latestRelease.Version(), latestRelease.URL)
+		_, _ = fmt.Fprintf(stderr, "Latest version detected: %s (Release URL: %s)\n", latestRelease.Version(), latestRelease.URL)
 		if latestRelease.AssetURL != "" {
-			fmt.Printf("Asset URL: %s\n", latestRelease.AssetURL)
+			_, _ = fmt.Fprintf(stderr, "Asset URL: %s\n", latestRelease.AssetURL)
 		}
-		if latestRelease.ReleaseNotes != "" { // Accessing ReleaseNotes directly
-			fmt.Printf("Release Notes:\n%s\n", latestRelease.ReleaseNotes)
+		if latestRelease.ReleaseNotes != "" {
+			_, _ = fmt.Fprintf(stderr, "Release Notes:\n%s\n", latestRelease.ReleaseNotes)
 		}
 	}
 
-	// Compare using the internal *semver.Version objects
-	// The selfupdate.Release struct has methods like GreaterThan(string).
 	// currentSemVer is a *semver.Version, so we use its string representation for the comparison.
 	if !latestRelease.GreaterThan(currentSemVer.String()) {
-		fmt.Printf("Current version %s is already the latest or newer.\n", currentVersionStr)
+		_, _ = fmt.Fprintf(stdout, "Current version %s is already the latest or newer.\n", currentVersionStr)
 		return nil
 	}
 
-	fmt.Printf("New version available: %s (current: %s)\n", latestRelease.Version(), currentVersionStr)
+	_, _ = fmt.Fprintf(stdout, "New version available: %s (current: %s)\n", latestRelease.Version(), currentVersionStr)
 
 	if c.Bool("check") {
 		return nil
 	}
 
-	if !c.Bool("yes") {
-		fmt.Print("Do you want to update? (y/N): ")
-		reader := bufio.NewReader(os.Stdin)
-		input, _ := reader.ReadString('\n')
-		if strings.TrimSpace(strings.ToLower(input)) != "y" {
-			fmt.Println("Update cancelled.")
-			return nil
-		}
-	}
-
-	fmt.Printf("Updating to %s...\n", latestRelease.Version())
-	execPath, err := os.Executable()
+	execPath, err := executablePath()
 	if err != nil {
 		return cli.Exit(fmt.Sprintf("Could not get executable path: %v", err), 1)
 	}
+	// A symlinked install (e.g. from a package manager) is updated where the binary lives.
+	if resolved, resolveErr := filepath.EvalSymlinks(execPath); resolveErr == nil {
+		execPath = resolved
+	}
 	if verbose {
-		fmt.Printf("Current executable path: %s\n", execPath)
+		_, _ = fmt.Fprintf(stderr, "Current executable path: %s\n", execPath)
+	}
+	// Checked before asking or downloading, so the user is not prompted for an update that
+	// cannot be written.
+	if err := checkReplaceable(execPath); err != nil {
+		return cli.Exit(fmt.Sprintf("Error: Cannot replace %s: %v. Rerun with elevated permissions (e.g. 'sudo almd self update') or download %s manually from %s.", execPath, err, latestRelease.AssetName, latestRelease.URL), 1)
 	}
 
-	// The UpdateTo method expects a *Release object
-	err = updater.UpdateTo(c.Context, latestRelease, execPath)
-	if err != nil {
+	if !c.Bool("yes") && !prompt.Confirm(c.App.Reader, stderr, "Do you want to update?") {
+		_, _ = fmt.Fprintln(stdout, "Update cancelled.")
+		return nil
+	}
+
+	_, _ = fmt.Fprintf(stdout, "Updating to %s...\n", latestRelease.Version())
+	// UpdateTo verifies the download against the release checksums before the executable is
+	// replaced, and leaves it untouched on any failure.
+	if err := updater.UpdateTo(c.Context, latestRelease, execPath); err != nil {
+		if errors.Is(err, os.ErrPermission) {
+			return cli.Exit(fmt.Sprintf("Error: Cannot replace %s: %v. Rerun with elevated permissions (e.g. 'sudo almd self update') or download %s manually from %s.", execPath, err, latestRelease.AssetName, latestRelease.URL), 1)
+		}
 		return cli.Exit(fmt.Sprintf("Failed to update: %v", err), 1)
 	}
 
-	fmt.Printf("Successfully updated to version %s.\n", latestRelease.Version())
+	_, _ = fmt.Fprintf(stdout, "Successfully updated to version %s.\n", latestRelease.Version())
 	return nil
 }
@@ -0,0 +1,213 @@
+package self
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/creativeprojects/go-selfupdate"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/urfave/cli/v2"
+)
+
+type fakeAsset struct {
+	id      int64
+	name    string
+	content []byte
+}
+
+func (a fakeAsset) GetID() int64                  { return a.id }
+func (a fakeAsset) GetName() string               { return a.name }
+func (a fakeAsset) GetSize() int                  { return len(a.content) }
+func (a fakeAsset) GetBrowserDownloadURL() string { return "https://example.com/" + a.name }
+
+type fakeRelease struct {
+	tag    string
+	assets []fakeAsset
+}
+
+func (r fakeRelease) GetID() int64              { return 1 }
+func (r fakeRelease) GetTagName() string        { return r.tag }
+func (r fakeRelease) GetDraft() bool            { return false }
+func (r fakeRelease) GetPrerelease() bool       { return false }
+func (r fakeRelease) GetPublishedAt() time.Time { return time.Time{} }
+func (r fakeRelease) GetReleaseNotes() string   { return "" }
+func (r fakeRelease) GetName() string           { return r.tag }
+func (r fakeRelease) GetURL() string            { return "https://example.com/releases/" + r.tag }
+func (r fakeRelease) GetAssets() []selfupdate.SourceAsset {
+	assets := make([]selfupdate.SourceAsset, len(r.assets))
+	for i, a := range r.assets {
+		assets[i] = a
+	}
+	return assets
+}
+
+// fakeSource serves a single release from memory.
+type fakeSource struct {
+	release fakeRelease
+}
+
+func (s fakeSource) ListReleases(context.Context, selfupdate.Repository) ([]selfupdate.SourceRelease, error) {
+	return []selfupdate.SourceRelease{s.release}, nil
+}
+
+func (s fakeSource) DownloadReleaseAsset(_ context.Context, _ *selfupdate.Release, assetID int64) (io.ReadCloser, error) {
+	for _, a := range s.release.assets {
+		if a.id == assetID {
+			return io.NopCloser(bytes.NewReader(a.content)), nil
+		}
+	}
+	return nil, fmt.Errorf("asset %d not found", assetID)
+}
+
+// newRelease returns a v1.2.0 release whose platform binary holds binary. checksum is the
+// checksums.txt content; "" leaves the asset out.
+func newRelease(binary []byte, checksum string) fakeRelease {
+	assetName := fmt.Sprintf("almd_%s_%s", runtime.GOOS, runtime.GOARCH)
+	rel := fakeRelease{tag: "v1.2.0", assets: []fakeAsset{{id: 1, name: assetName, content: binary}}}
+	if checksum != "" {
+		rel.assets = append(rel.assets, fakeAsset{id: 2, name: checksumsAsset, content: []byte(checksum)})
+	}
+	return rel
+}
+
+func checksumLine(content []byte) string {
+	return fmt.Sprintf("%x  almd_%s_%s\n", sha256.Sum256(content), runtime.GOOS, runtime.GOARCH)
+}
+
+// runSelfUpdate runs 'almd self update' at version against rel, with the running executable
+// at execPath, and returns the command's stdout.
+func runSelfUpdate(t *testing.T, version string, rel fakeRelease, execPath string, args ...string) (string, error) {
+	t.Helper()
+	originalSource, originalExecutable := newUpdateSource, executablePath
+	newUpdateSource = func() (selfupdate.Source, error) { return fakeSource{release: rel}, nil }
+	executablePath = func() (string, error) { return execPath, nil }
+	t.Cleanup(func() { newUpdateSource, executablePath = originalSource, originalExecutable })
+
+	var stdout bytes.Buffer
+	app := &cli.App{
+		Name:           "almd-test-self",
+		Version:        version,
+		Commands:       []*cli.Command{NewSelfCommand()},
+		Writer:         &stdout,
+		ErrWriter:      io.Discard,
+		ExitErrHandler: func(*cli.Context, error) {},
+	}
+	err := app.Run(append([]string{"almd-test-self", "self", "update"}, args...))
+	return stdout.String(), err
+}
+
+func writeExecutable(t *testing.T) string {
+	t.Helper()
+	execPath := filepath.Join(t.TempDir(), "almd")
+	require.NoError(t, os.WriteFile(execPath, []byte("old binary"), 0755))
+	return execPath
+}
+
+func TestSelfUpdate_Check(t *testing.T) {
+	newBinary := []byte("new binary")
+	execPath := writeExecutable(t)
+
+	out, err := runSelfUpdate(t, "v1.0.0", newRelease(newBinary, checksumLine(newBinary)), execPath, "--check")
+	require.NoError(t, err)
+	assert.Contains(t, out, "New version available: 1.2.0 (current: v1.0.0)")
+	content, err := os.ReadFile(execPath)
+	require.NoError(t, err)
+	assert.Equal(t, "old binary", string(content), "--check never replaces the executable")
+
+	out, err = runSelfUpdate(t, "v1.2.0", newRelease(newBinary, checksumLine(newBinary)), execPath, "--check")
+	require.NoError(t, err)
+	assert.Contains(t, out, "is already the latest")
+}
+
+func TestSelfUpdate_ReplacesExecutable(t *testing.T) {
+	newBinary := []byte("new binary")
+	execPath := writeExecutable(t)
+
+	out, err := runSelfUpdate(t, "v1.0.0", newRelease(newBinary, checksumLine(newBinary)), execPath, "--yes")
+	require.NoError(t, err)
+	assert.Contains(t, out, "Successfully updated to version 1.2.0.")
+	content, err := os.ReadFile(execPath)
+	require.NoError(t, err)
+	assert.Equal(t, "new binary", string(content))
+}
+
+func TestSelfUpdate_PromptGoesToStderr(t *testing.T) {
+	newBinary := []byte("new binary")
+	execPath := writeExecutable(t)
+	originalSource, originalExecutable := newUpdateSource, executablePath
+	newUpdateSource = func() (selfupdate.Source, error) {
+		return fakeSource{release: newRelease(newBinary, checksumLine(newBinary))}, nil
+	}
+	executablePath = func() (string, error) { return execPath, nil }
+	t.Cleanup(func() { newUpdateSource, executablePath = originalSource, originalExecutable })
+
+	var stdout, stderr bytes.Buffer
+	app := &cli.App{
+		Name:           "almd-test-self",
+		Version:        "v1.0.0",
+		Commands:       []*cli.Command{NewSelfCommand()},
+		Reader:         strings.NewReader("n\n"),
+		Writer:         &stdout,
+		ErrWriter:      &stderr,
+		ExitErrHandler: func(*cli.Context, error) {},
+	}
+	require.NoError(t, app.Run([]string{"almd-test-self", "self", "update"}))
+	assert.Contains(t, stderr.String(), "Do you want to update?")
+	assert.NotContains(t, stdout.String(), "Do you want to update?")
+	assert.Contains(t, stdout.String(), "Update cancelled.")
+	content, err := os.ReadFile(execPath)
+	require.NoError(t, err)
+	assert.Equal(t, "old binary", string(content))
+}
+
+func TestSelfUpdate_ChecksumMismatch(t *testing.T) {
+	execPath := writeExecutable(t)
+
+	_, err := runSelfUpdate(t, "v1.0.0", newRelease([]byte("tampered binary"), checksumLine([]byte("new binary"))), execPath, "--yes")
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "Failed to update")
+	content, readErr := os.ReadFile(execPath)
+	require.NoError(t, readErr)
+	assert.Equal(t, "old binary", string(content), "a binary that fails verification is not installed")
+}
+
+func TestSelfUpdate_MissingChecksums(t *testing.T) {
+	execPath := writeExecutable(t)
+
+	_, err := runSelfUpdate(t, "v1.0.0", newRelease([]byte("new binary"), ""), execPath, "--yes")
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "publishes no checksums.txt")
+}
+
+func TestSelfUpdate_UnwritableDirectory(t *testing.T) {
+	assert.Error(t, checkReplaceable(filepath.Join(t.TempDir(), "missing", "almd")))
+	if os.Geteuid() == 0 {
+		t.Skip("root can write to any directory")
+	}
+	newBinary := []byte("new binary")
+	execPath := writeExecutable(t)
+	dir := filepath.Dir(execPath)
+	require.NoError(t, os.Chmod(dir, 0555))
+	t.Cleanup(func() { _ = os.Chmod(dir, 0755) })
+
+	_, err := runSelfUpdate(t, "v1.0.0", newRelease(newBinary, checksumLine(newBinary)), execPath, "--yes")
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "sudo almd self update")
+	assert.Contains(t, err.Error(), "https://example.com/releases/v1.2.0")
+}
+
+func TestSelfUpdate_DevelopmentBuild(t *testing.T) {
+	_, err := runSelfUpdate(t, "dev", newRelease(nil, ""), writeExecutable(t), "--check")
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "https://github.com/nightconcept/almandine-go/releases/latest")
+}
@@ -0,0 +1,341 @@
+// Title: Almandine CLI Update Command
+// Purpose: Implements the 'update' command, which moves GitHub dependencies pinned to a version
+// tag up to the newest compatible tag, rewriting their source in project.toml and refreshing
+// the file and almd-lock.toml. Unlike 'install', it changes the ref a dependency asks for.
+// Dependencies whose ref is a version range (e.g. ^1.2.0) keep it; only the locked tag moves.
+package update
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"runtime"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/Masterminds/semver/v3"
+	"github.com/urfave/cli/v2"
+
+	"github.com/nightconcept/almandine-go/internal/cli/output"
+	"github.com/nightconcept/almandine-go/internal/core/config"
+	"github.com/nightconcept/almandine-go/internal/core/downloader"
+	"github.com/nightconcept/almandine-go/internal/core/fsutil"
+	"github.com/nightconcept/almandine-go/internal/core/hasher"
+	"github.com/nightconcept/almandine-go/internal/core/lockfile"
+	"github.com/nightconcept/almandine-go/internal/core/project"
+	"github.com/nightconcept/almandine-go/internal/core/source"
+)
+
+// tagConstraint returns the range of versions ref may move to. A full version such as v1.2.0
+// may move to any later release with the same major version (caret semantics, so 0.x releases
+// stay within their minor version); a partial version such as v1, v1.* or v1.2.x may move
+// anywhere within what it leaves open. ok is false if ref is not a version.
+func tagConstraint(ref string) (constraint *semver.Constraints, ok bool) {
+	trimmed := strings.TrimPrefix(ref, "v")
+	if v, err := semver.StrictNewVersion(trimmed); err == nil {
+		if v.Prerelease() != "" {
+			return nil, false
+		}
+		c, err := semver.NewConstraint("^" + v.String())
+		return c, err == nil
+	}
+
+	var parts []int
+	for _, part := range strings.Split(trimmed, ".") {
+		if part == "*" || part == "x" || part == "X" {
+			break
+		}
+		n, err := strconv.Atoi(part)
+		if err != nil || n < 0 {
+			return nil, false
+		}
+		parts = append(parts, n)
+	}
+
+	var expr string
+	switch len(parts) {
+	case 1:
+		expr = fmt.Sprintf(">=%d.0.0, <%d.0.0", parts[0], parts[0]+1)
+	case 2:
+		expr = fmt.Sprintf(">=%d.%d.0, <%d.%d.0", parts[0], parts[1], parts[0], parts[1]+1)
+	default:
+		return nil, false
+	}
+	c, err := semver.NewConstraint(expr)
+	return c, err == nil
+}
+
+// newestMatchingTag returns the highest release tag satisfying constraint. Only tags written
+// like ref (with or without a leading "v") are considered, and pre-releases are ignored.
+func newestMatchingTag(ref string, constraint *semver.Constraints, tags []string) (string, bool) {
+	wantV := strings.HasPrefix(ref, "v")
+	var best *semver.Version
+	bestTag := ""
+	for _, tag := range tags {
+		if strings.HasPrefix(tag, "v") != wantV {
+			continue
+		}
+		v, err := semver.StrictNewVersion(strings.TrimPrefix(tag, "v"))
+		if err != nil || v.Prerelease() != "" || !constraint.Check(v) {
+			continue
+		}
+		if best == nil || v.GreaterThan(best) {
+			best, bestTag = v, tag
+		}
+	}
+	return bestTag, best != nil
+}
+
+// NewUpdateCommand creates the 'update' command.
+func NewUpdateCommand() *cli.Command {
+	return newUpdateCommand(runtime.GOOS, runtime.GOARCH)
+}
+
+// newUpdateCommand creates the 'update' command as it runs on goos/goarch, which are matched
+// against a dependency's os/arch filters. Tests pass other platforms.
+func newUpdateCommand(goos, goarch string) *cli.Command {
+	return &cli.Command{
+		Name:      "update",
+		Usage:     "Moves tag-pinned dependencies to the newest compatible tag and rewrites project.toml",
+		ArgsUsage: "[dependency_names...]",
+		Flags: []cli.Flag{
+			&cli.BoolFlag{
+				Name:  "verbose",
+				Usage: "Enable verbose output",
+			},
+			&cli.StringFlag{
+				Name:  "max-size",
+				Usage: "Largest file to download, e.g. 200MB (0 disables the limit)",
+				Value: "50MB",
+			},
+			output.QuietFlag(),
+		},
+		Action: func(c *cli.Context) error {
+			// Updates go to stdout; notes about skipped dependencies and errors go to stderr.
+			if err := output.CheckQuiet(c); err != nil {
+				return err
+			}
+			stdout, stderr := output.Stdout(c), c.App.ErrWriter
+			verbose := c.Bool("verbose")
+			ghConfig := source.DefaultConfig()
+			downloads := &downloader.Client{Progress: output.DownloadProgress(c)}
+			if verbose {
+				ghConfig.Verbose, downloads.Verbose = stderr, stderr
+			}
+			ghClient := source.NewClient(ghConfig)
+			maxSize, err := downloader.ParseSize(c.String("max-size"))
+			if err != nil {
+				return cli.Exit(fmt.Sprintf("Error: Invalid --max-size: %v", err), 1)
+			}
+
+			release, lockErr := lockfile.Acquire(".")
+			if lockErr != nil {
+				return cli.Exit(fmt.Sprintf("Error: %v", lockErr), 1)
+			}
+			defer release()
+
+			proj, err := config.LoadProjectToml(".")
+			if err != nil {
+				if os.IsNotExist(err) {
+					return cli.Exit(fmt.Sprintf("Error: %s not found. Run 'almd init' first.", config.ProjectTomlName), 1)
+				}
+				return cli.Exit(fmt.Sprintf("Error loading %s: %v", config.ProjectTomlName, err), 1)
+			}
+			lf, err := lockfile.Load(".")
+			if err != nil {
+				return cli.Exit(fmt.Sprintf("Error loading %s: %v", lockfile.LockfileName, err), 1)
+			}
+
+			requested := c.Args().Slice()
+			if len(requested) == 0 {
+				for name := range proj.AllDependencies() {
+					requested = append(requested, name)
+				}
+			}
+			sort.Strings(requested)
+			var names []string
+			for _, name := range requested {
+				dep, _, ok := proj.LookupDependency(name)
+				if !ok {
+					return cli.Exit(fmt.Sprintf("Error: Dependency '%s' not found in %s.", name, config.ProjectTomlName), 1)
+				}
+				if !dep.SupportsPlatform(goos, goarch) {
+					if c.Args().Present() {
+						_, _ = fmt.Fprintf(stderr, "Note: Skipping '%s'; it is not needed on %s/%s.\n", name, goos, goarch)
+					} else if verbose {
+						_, _ = fmt.Fprintf(stderr, "Skipping: %s (not needed on %s/%s)\n", name, goos, goarch)
+					}
+					continue
+				}
+				names = append(names, name)
+			}
+
+			updated, failed := 0, 0
+			// Parse every source and enforce project-level source policy ([almd] allowed_hosts)
+			// up front, so a rejected source fails the update before any network call is made.
+			policyHooks := []source.Hook{source.AllowedHostsHook(proj.AllowedHosts())}
+			parsedSources := make(map[string]*source.ParsedSourceInfo, len(names))
+			for _, name := range names {
+				dep, _, _ := proj.LookupDependency(name)
+				parsed, err := source.ParseSourceURL(dep.Source)
+				if err != nil {
+					_, _ = fmt.Fprintf(stderr, "Error: Cannot parse source '%s' for '%s': %v\n", dep.Source, name, err)
+					failed++
+					continue
+				}
+				if err := source.ApplyHooks(parsed, policyHooks...); err != nil {
+					return cli.Exit(fmt.Sprintf("Error: Source for dependency '%s' (%s) rejected by policy: %v", name, dep.Source, err), 1)
+				}
+				parsedSources[name] = parsed
+			}
+
+			for _, name := range names {
+				dep, group, _ := proj.LookupDependency(name)
+				parsed, ok := parsedSources[name]
+				if !ok {
+					continue
+				}
+				if parsed.Provider != "github" {
+					_, _ = fmt.Fprintf(stderr, "Note: Skipping '%s': only GitHub sources can be updated to a newer tag.\n", name)
+					continue
+				}
+				// A version range stays in project.toml; only the file and almd-lock.toml move to the
+				// newest matching tag. A plain tag ref is rewritten to the newer tag.
+				var newTag, newSource, fromTag string
+				var newInfo *source.ParsedSourceInfo
+				if parsed.VersionRange != "" {
+					if dep.ChecksumURL != "" {
+						_, _ = fmt.Fprintf(stderr, "Note: Skipping '%s': its checksum_url is tied to the current version; update it by hand.\n", name)
+						continue
+					}
+					resolved, err := ghClient.ResolveVersionRange(parsed)
+					if err != nil {
+						_, _ = fmt.Fprintf(stderr, "Error: Could not resolve version range '%s' for '%s': %v\n", parsed.VersionRange, name, err)
+						failed++
+						continue
+					}
+					fromTag = lf.Package[name].Tag
+					if resolved.Ref == fromTag {
+						_, _ = fmt.Fprintf(stdout, "'%s' is already at the newest tag matching %s (%s).\n", name, parsed.VersionRange, fromTag)
+						continue
+					}
+					if fromTag == "" {
+						fromTag = "(unlocked)"
+					}
+					newTag, newSource, newInfo = resolved.Ref, dep.Source, resolved
+				} else {
+					if source.IsImmutableRef(parsed.Provider, parsed.Ref) {
+						_, _ = fmt.Fprintf(stderr, "Note: Skipping '%s': it is pinned to commit %s, which is left unchanged.\n", name, parsed.Ref)
+						continue
+					}
+					constraint, ok := tagConstraint(parsed.Ref)
+					if !ok {
+						_, _ = fmt.Fprintf(stderr, "Note: Skipping '%s': '%s' is not a version tag; 'almd install' follows branches.\n", name, parsed.Ref)
+						continue
+					}
+					if dep.ChecksumURL != "" {
+						_, _ = fmt.Fprintf(stderr, "Note: Skipping '%s': its checksum_url is tied to the current version; update it by hand.\n", name)
+						continue
+					}
+
+					tags, err := ghClient.ListTags(parsed.Owner, parsed.Repo)
+					if err != nil {
+						_, _ = fmt.Fprintf(stderr, "Error: Failed to list tags of %s/%s for '%s': %v\n", parsed.Owner, parsed.Repo, name, err)
+						failed++
+						continue
+					}
+					var found bool
+					newTag, found = newestMatchingTag(parsed.Ref, constraint, tags)
+					if !found {
+						_, _ = fmt.Fprintf(stderr, "Warning: No release tag of %s/%s matches '%s' for '%s'.\n", parsed.Owner, parsed.Repo, parsed.Ref, name)
+						continue
+					}
+					if newTag == parsed.Ref {
+						_, _ = fmt.Fprintf(stdout, "'%s' is already at the newest matching tag (%s).\n", name, newTag)
+						continue
+					}
+
+					newSource = fmt.Sprintf("github:%s/%s/%s@%s", parsed.Owner, parsed.Repo, parsed.PathInRepo, newTag)
+					newInfo, err = source.ParseSourceURL(newSource)
+					if err != nil {
+						_, _ = fmt.Fprintf(stderr, "Error: Cannot parse new source '%s' for '%s': %v\n", newSource, name, err)
+						failed++
+						continue
+					}
+					fromTag = parsed.Ref
+				}
+				// The new tag is a new source, so it is checked against policy again.
+				if err := source.ApplyHooks(newInfo, policyHooks...); err != nil {
+					_, _ = fmt.Fprintf(stderr, "Error: Source for '%s' at %s rejected by policy: %v\n", name, newTag, err)
+					failed++
+					continue
+				}
+
+				// Tags can be moved upstream, so the file is fetched and locked by commit.
+				sha, err := ghClient.GetLatestCommitSHAForFile(newInfo.Owner, newInfo.Repo, newInfo.PathInRepo, newTag)
+				if err != nil {
+					_, _ = fmt.Fprintf(stderr, "Error: Failed to resolve %s for '%s': %v\n", newTag, name, err)
+					failed++
+					continue
+				}
+				rawURL := newInfo.RawURLAt(sha)
+				if verbose {
+					_, _ = fmt.Fprintf(stderr, "Downloading '%s' from %s\n", name, rawURL)
+				}
+				content, err := downloads.DownloadFileWithLimit(rawURL, maxSize)
+				if err != nil {
+					_, _ = fmt.Fprintf(stderr, "Error: Failed to download '%s' from '%s': %v\n", name, rawURL, err)
+					failed++
+					continue
+				}
+				lockedHash, _ := lf.Package[name].ExpectedContentHash()
+				contentHash, err := hasher.Calculate(content, hasher.AlgorithmFor(lockedHash))
+				if err != nil {
+					_, _ = fmt.Fprintf(stderr, "Error: Failed to calculate content hash for '%s': %v\n", name, err)
+					failed++
+					continue
+				}
+				nativePath := project.NativePath(dep.Path)
+				if err := os.MkdirAll(filepath.Dir(nativePath), 0755); err != nil {
+					_, _ = fmt.Fprintf(stderr, "Error: Failed to create directory for '%s': %v\n", name, err)
+					failed++
+					continue
+				}
+				if err := fsutil.WriteFileAtomic(nativePath, content, 0644); err != nil {
+					_, _ = fmt.Fprintf(stderr, "Error: Failed to write file '%s' for '%s': %v\n", dep.Path, name, err)
+					failed++
+					continue
+				}
+
+				dep.Source = newSource
+				proj.SetDependency(name, group, dep)
+				hadBlob := lf.Package[name].Blob != ""
+				lf.AddOrUpdatePackage(name, rawURL, dep.Path, "commit:"+sha)
+				lf.SetContentHash(name, contentHash)
+				// Files added with --recursive keep a blob SHA, which the new content determines.
+				if hadBlob {
+					lf.SetBlob(name, hasher.GitBlobSHA(content))
+				}
+				if parsed.VersionRange != "" {
+					lf.SetTag(name, newTag)
+				}
+				updated++
+				_, _ = fmt.Fprintf(stdout, "Updated '%s': %s -> %s\n", name, fromTag, newTag)
+			}
+
+			if updated > 0 {
+				if err := config.WriteProjectToml(".", proj); err != nil {
+					return cli.Exit(fmt.Sprintf("Error: Failed to write %s: %v", config.ProjectTomlName, err), 1)
+				}
+				if err := lockfile.Save(".", lf); err != nil {
+					return cli.Exit(fmt.Sprintf("Error: %s was updated but %s could not be saved: %v. Run 'almd install' to re-lock.", config.ProjectTomlName, lockfile.LockfileName, err), 1)
+				}
+			}
+			if failed > 0 {
+				return cli.Exit(fmt.Sprintf("Error: %d dependenc(ies) could not be updated.", failed), 1)
+			}
+			return nil
+		},
+	}
+}
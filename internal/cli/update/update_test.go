@@ -0,0 +1,346 @@
+package update
+
+import (
+	"bytes"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/urfave/cli/v2"
+
+	"github.com/nightconcept/almandine-go/internal/core/config"
+	"github.com/nightconcept/almandine-go/internal/core/hasher"
+	"github.com/nightconcept/almandine-go/internal/core/lockfile"
+	"github.com/nightconcept/almandine-go/internal/core/source"
+)
+
+const (
+	v14SHA    = "1414141414141414141414141414141414141414"
+	v22SHA    = "2222222222222222222222222222222222222222"
+	pinnedSHA = "abcdefabcdefabcdefabcdefabcdefabcdefabcd"
+)
+
+const updateProjectToml = `
+[package]
+name = "test-update"
+version = "0.1.0"
+
+[dependencies.exact]
+source = "github:owner/repo/exact.lua@v1.2.0"
+path = "libs/exact.lua"
+
+[dependencies.wild]
+source = "github:owner/repo/wild.lua@v2.*"
+path = "libs/wild.lua"
+
+[dependencies.branch]
+source = "github:owner/repo/branch.lua@main"
+path = "libs/branch.lua"
+
+[dependencies.pinned]
+source = "github:owner/repo/pinned.lua@` + pinnedSHA + `"
+path = "libs/pinned.lua"
+`
+
+// startMockGitHub serves the tags and commits APIs and raw files for owner/repo.
+func startMockGitHub(t *testing.T) {
+	t.Helper()
+	mux := http.NewServeMux()
+	mux.HandleFunc("/repos/owner/repo/tags", func(w http.ResponseWriter, r *http.Request) {
+		_, _ = fmt.Fprint(w, `[{"name": "v3.0.0"}, {"name": "v2.2.0"}, {"name": "v2.3.0-rc.1"}, {"name": "v1.4.0"}, {"name": "1.9.0"}, {"name": "v1.2.0"}, {"name": "v1"}]`)
+	})
+	mux.HandleFunc("/repos/owner/repo/commits", func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Query().Get("sha") {
+		case "v1.4.0":
+			_, _ = fmt.Fprintf(w, `[{"sha": "%s"}]`, v14SHA)
+		case "v2.2.0":
+			_, _ = fmt.Fprintf(w, `[{"sha": "%s"}]`, v22SHA)
+		default:
+			t.Errorf("unexpected commit lookup for ref %s", r.URL.Query().Get("sha"))
+			http.NotFound(w, r)
+		}
+	})
+	mux.HandleFunc("/owner/repo/"+v14SHA+"/exact.lua", func(w http.ResponseWriter, r *http.Request) {
+		_, _ = fmt.Fprint(w, "-- exact v1.4.0")
+	})
+	mux.HandleFunc("/owner/repo/"+v22SHA+"/wild.lua", func(w http.ResponseWriter, r *http.Request) {
+		_, _ = fmt.Fprint(w, "-- wild v2.2.0")
+	})
+	server := httptest.NewServer(mux)
+	t.Cleanup(server.Close)
+
+	original := source.GithubAPIBaseURL
+	source.GithubAPIBaseURL = server.URL
+	source.SetTestModeBypassHostValidation(true)
+	t.Cleanup(func() {
+		source.GithubAPIBaseURL = original
+		source.SetTestModeBypassHostValidation(false)
+	})
+}
+
+// runUpdateCommand runs 'update' in workDir and returns its stdout, stderr and error.
+func runUpdateCommand(t *testing.T, workDir string, args ...string) (string, string, error) {
+	t.Helper()
+	return runCommand(t, NewUpdateCommand(), workDir, args...)
+}
+
+// runCommand runs cmd, an 'update' command, like runUpdateCommand.
+func runCommand(t *testing.T, cmd *cli.Command, workDir string, args ...string) (string, string, error) {
+	t.Helper()
+
+	originalWd, err := os.Getwd()
+	require.NoError(t, err)
+	require.NoError(t, os.Chdir(workDir))
+	defer func() { require.NoError(t, os.Chdir(originalWd)) }()
+
+	var stdout, stderr bytes.Buffer
+	app := &cli.App{
+		Name:           "almd-test-update",
+		Commands:       []*cli.Command{cmd},
+		Writer:         &stdout,
+		ErrWriter:      &stderr,
+		ExitErrHandler: func(context *cli.Context, err error) {},
+	}
+	err = app.Run(append([]string{"almd-test-update", "update"}, args...))
+	return stdout.String(), stderr.String(), err
+}
+
+func TestUpdateCommand_MovesTagsToNewestCompatible(t *testing.T) {
+	startMockGitHub(t)
+	tempDir := t.TempDir()
+	require.NoError(t, os.WriteFile(filepath.Join(tempDir, config.ProjectTomlName), []byte(updateProjectToml), 0644))
+
+	stdout, stderr, err := runUpdateCommand(t, tempDir)
+	require.NoError(t, err)
+	assert.Contains(t, stdout, "Updated 'exact': v1.2.0 -> v1.4.0", "v1.2.0 moves within major version 1 and ignores unprefixed tags")
+	assert.Contains(t, stdout, "Updated 'wild': v2.* -> v2.2.0", "pre-releases are ignored")
+	assert.Contains(t, stderr, "Skipping 'branch'")
+	assert.Contains(t, stderr, "Skipping 'pinned': it is pinned to commit")
+
+	proj, err := config.LoadProjectToml(tempDir)
+	require.NoError(t, err)
+	assert.Equal(t, "github:owner/repo/exact.lua@v1.4.0", proj.Dependencies["exact"].Source)
+	assert.Equal(t, "github:owner/repo/wild.lua@v2.2.0", proj.Dependencies["wild"].Source)
+	assert.Equal(t, "github:owner/repo/branch.lua@main", proj.Dependencies["branch"].Source)
+	assert.Equal(t, "github:owner/repo/pinned.lua@"+pinnedSHA, proj.Dependencies["pinned"].Source)
+
+	content, err := os.ReadFile(filepath.Join(tempDir, "libs", "exact.lua"))
+	require.NoError(t, err)
+	assert.Equal(t, "-- exact v1.4.0", string(content))
+
+	lf, err := lockfile.Load(tempDir)
+	require.NoError(t, err)
+	assert.Equal(t, "commit:"+v14SHA, lf.Package["exact"].Hash)
+	assert.Equal(t, source.GithubAPIBaseURL+"/owner/repo/"+v14SHA+"/exact.lua", lf.Package["exact"].Source)
+	contentHash, err := hasher.CalculateSHA256([]byte("-- exact v1.4.0"))
+	require.NoError(t, err)
+	assert.Equal(t, contentHash, lf.Package["exact"].ContentHash)
+	assert.Equal(t, "commit:"+v22SHA, lf.Package["wild"].Hash)
+	assert.NotContains(t, lf.Package, "pinned")
+
+	// A second run finds nothing newer and leaves the files alone.
+	stdout, _, err = runUpdateCommand(t, tempDir, "exact")
+	require.NoError(t, err)
+	assert.Contains(t, stdout, "'exact' is already at the newest matching tag (v1.4.0)")
+}
+
+func TestUpdateCommand_KeepsBlobSHA(t *testing.T) {
+	startMockGitHub(t)
+	tempDir := t.TempDir()
+	require.NoError(t, os.WriteFile(filepath.Join(tempDir, config.ProjectTomlName), []byte(updateProjectToml), 0644))
+	lockToml := `api_version = "1"
+
+[package.exact]
+source = "https://raw.githubusercontent.com/owner/repo/v1.2.0/exact.lua"
+path = "libs/exact.lua"
+hash = "commit:1212121212121212121212121212121212121212"
+blob = "0000000000000000000000000000000000000000"
+`
+	require.NoError(t, os.WriteFile(filepath.Join(tempDir, lockfile.LockfileName), []byte(lockToml), 0644))
+
+	_, _, err := runUpdateCommand(t, tempDir, "exact")
+	require.NoError(t, err)
+
+	lf, err := lockfile.Load(tempDir)
+	require.NoError(t, err)
+	assert.Equal(t, hasher.GitBlobSHA([]byte("-- exact v1.4.0")), lf.Package["exact"].Blob, "an entry added with --recursive keeps a blob SHA for its new content")
+}
+
+func TestUpdateCommand_DownloadOptions(t *testing.T) {
+	startMockGitHub(t)
+
+	t.Run("--max-size caps the download", func(t *testing.T) {
+		tempDir := t.TempDir()
+		require.NoError(t, os.WriteFile(filepath.Join(tempDir, config.ProjectTomlName), []byte(updateProjectToml), 0644))
+
+		_, stderr, err := runUpdateCommand(t, tempDir, "--max-size", "8B", "exact")
+		require.Error(t, err)
+		assert.Contains(t, stderr, "Failed to download 'exact'")
+		assert.NoFileExists(t, filepath.Join(tempDir, "libs", "exact.lua"))
+	})
+
+	t.Run("--quiet prints nothing to stdout", func(t *testing.T) {
+		tempDir := t.TempDir()
+		require.NoError(t, os.WriteFile(filepath.Join(tempDir, config.ProjectTomlName), []byte(updateProjectToml), 0644))
+
+		stdout, _, err := runUpdateCommand(t, tempDir, "--quiet", "exact")
+		require.NoError(t, err)
+		assert.Empty(t, stdout)
+		assert.FileExists(t, filepath.Join(tempDir, "libs", "exact.lua"))
+	})
+
+	t.Run("--quiet and --verbose conflict", func(t *testing.T) {
+		tempDir := t.TempDir()
+		require.NoError(t, os.WriteFile(filepath.Join(tempDir, config.ProjectTomlName), []byte(updateProjectToml), 0644))
+
+		_, _, err := runUpdateCommand(t, tempDir, "--quiet", "--verbose", "exact")
+		require.Error(t, err)
+		assert.Contains(t, err.Error(), "--quiet and --verbose cannot be used together")
+	})
+}
+
+func TestUpdateCommand_SkipsOtherPlatforms(t *testing.T) {
+	startMockGitHub(t)
+	onLinux := newUpdateCommand("linux", "amd64")
+
+	projectToml := `
+[package]
+name = "test-update-platform"
+version = "0.1.0"
+
+[dependencies.exact]
+source = "github:owner/repo/exact.lua@v1.2.0"
+path = "libs/exact.lua"
+os = ["windows"]
+`
+	tempDir := t.TempDir()
+	require.NoError(t, os.WriteFile(filepath.Join(tempDir, config.ProjectTomlName), []byte(projectToml), 0644))
+
+	stdout, _, err := runCommand(t, onLinux, tempDir)
+	require.NoError(t, err)
+	assert.Empty(t, stdout)
+
+	_, stderr, err := runCommand(t, onLinux, tempDir, "exact")
+	require.NoError(t, err)
+	assert.Contains(t, stderr, "Note: Skipping 'exact'; it is not needed on linux/amd64.")
+
+	proj, err := config.LoadProjectToml(tempDir)
+	require.NoError(t, err)
+	assert.Equal(t, "github:owner/repo/exact.lua@v1.2.0", proj.Dependencies["exact"].Source)
+	assert.NoFileExists(t, filepath.Join(tempDir, "libs", "exact.lua"))
+}
+
+func TestUpdateCommand_AllowedHostsPolicy(t *testing.T) {
+	var requests atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests.Add(1)
+		http.NotFound(w, r)
+	}))
+	t.Cleanup(server.Close)
+	original := source.GithubAPIBaseURL
+	source.GithubAPIBaseURL = server.URL
+	source.SetTestModeBypassHostValidation(true)
+	t.Cleanup(func() {
+		source.GithubAPIBaseURL = original
+		source.SetTestModeBypassHostValidation(false)
+	})
+
+	tempDir := t.TempDir()
+	projectToml := updateProjectToml + "\n[almd]\nallowed_hosts = [\"mirror.example.com\"]\n"
+	require.NoError(t, os.WriteFile(filepath.Join(tempDir, config.ProjectTomlName), []byte(projectToml), 0644))
+
+	_, _, err := runUpdateCommand(t, tempDir)
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "rejected by policy")
+	assert.Equal(t, int32(0), requests.Load(), "no tag, commit or file is requested from a blocked host")
+	proj, err := config.LoadProjectToml(tempDir)
+	require.NoError(t, err)
+	assert.Equal(t, "github:owner/repo/exact.lua@v1.2.0", proj.Dependencies["exact"].Source)
+}
+
+func TestUpdateCommand_RepoNamedLikeTag(t *testing.T) {
+	sha := "1111111111111111111111111111111111111111"
+	mux := http.NewServeMux()
+	mux.HandleFunc("/repos/owner/v1.1.0/tags", func(w http.ResponseWriter, r *http.Request) {
+		_, _ = fmt.Fprint(w, `[{"name": "v1.1.0"}, {"name": "v1.0.0"}]`)
+	})
+	mux.HandleFunc("/repos/owner/v1.1.0/commits", func(w http.ResponseWriter, r *http.Request) {
+		_, _ = fmt.Fprintf(w, `[{"sha": "%s"}]`, sha)
+	})
+	mux.HandleFunc("/owner/v1.1.0/"+sha+"/lib.lua", func(w http.ResponseWriter, r *http.Request) {
+		_, _ = fmt.Fprint(w, "-- lib v1.1.0")
+	})
+	server := httptest.NewServer(mux)
+	t.Cleanup(server.Close)
+	original := source.GithubAPIBaseURL
+	source.GithubAPIBaseURL = server.URL
+	source.SetTestModeBypassHostValidation(true)
+	t.Cleanup(func() {
+		source.GithubAPIBaseURL = original
+		source.SetTestModeBypassHostValidation(false)
+	})
+
+	tempDir := t.TempDir()
+	projectToml := `
+[package]
+name = "test-update"
+
+[dependencies.lib]
+source = "github:owner/v1.1.0/lib.lua@v1.0.0"
+path = "libs/lib.lua"
+`
+	require.NoError(t, os.WriteFile(filepath.Join(tempDir, config.ProjectTomlName), []byte(projectToml), 0644))
+
+	_, _, err := runUpdateCommand(t, tempDir)
+	require.NoError(t, err)
+
+	lf, err := lockfile.Load(tempDir)
+	require.NoError(t, err)
+	assert.Equal(t, server.URL+"/owner/v1.1.0/"+sha+"/lib.lua", lf.Package["lib"].Source, "the repository segment is not mistaken for the tag")
+}
+
+func TestUpdateCommand_UnknownDependency(t *testing.T) {
+	tempDir := t.TempDir()
+	require.NoError(t, os.WriteFile(filepath.Join(tempDir, config.ProjectTomlName), []byte(updateProjectToml), 0644))
+
+	_, _, err := runUpdateCommand(t, tempDir, "missing")
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "'missing' not found")
+}
+
+func TestTagConstraint(t *testing.T) {
+	tests := []struct {
+		ref     string
+		ok      bool
+		allowed []string
+		denied  []string
+	}{
+		{ref: "v1.2.0", ok: true, allowed: []string{"1.2.0", "1.9.3"}, denied: []string{"1.1.9", "2.0.0"}},
+		{ref: "0.3.1", ok: true, allowed: []string{"0.3.5"}, denied: []string{"0.4.0"}},
+		{ref: "v1", ok: true, allowed: []string{"1.0.0", "1.8.0"}, denied: []string{"2.0.0", "0.9.0"}},
+		{ref: "v0.*", ok: true, allowed: []string{"0.1.0", "0.9.9"}, denied: []string{"1.0.0"}},
+		{ref: "v1.2.x", ok: true, allowed: []string{"1.2.7"}, denied: []string{"1.3.0"}},
+		{ref: "main", ok: false},
+		{ref: "v1.2.0-beta.1", ok: false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.ref, func(t *testing.T) {
+			constraint, ok := tagConstraint(tt.ref)
+			require.Equal(t, tt.ok, ok)
+			for _, v := range tt.allowed {
+				_, matched := newestMatchingTag("", constraint, []string{v})
+				assert.True(t, matched, "%s should allow %s", tt.ref, v)
+			}
+			for _, v := range tt.denied {
+				_, matched := newestMatchingTag("", constraint, []string{v})
+				assert.False(t, matched, "%s should not allow %s", tt.ref, v)
+			}
+		})
+	}
+}
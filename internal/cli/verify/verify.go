@@ -0,0 +1,361 @@
+// Title: Almandine CLI Verify Command
+// Purpose: Implements the 'verify' command, which checks that the dependency files on disk
+// (or, with --remote, the content at their locked source URLs) still match the content hashes
+// recorded in almd-lock.toml. Lockfile entries no longer declared in project.toml are reported,
+// as are files in a managed directory (one holding a locked path) that no entry records.
+package verify
+
+import (
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"runtime"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/urfave/cli/v2"
+
+	"github.com/nightconcept/almandine-go/internal/core/config"
+	"github.com/nightconcept/almandine-go/internal/core/downloader"
+	"github.com/nightconcept/almandine-go/internal/core/hasher"
+	"github.com/nightconcept/almandine-go/internal/core/lockfile"
+	"github.com/nightconcept/almandine-go/internal/core/project"
+	"github.com/nightconcept/almandine-go/internal/core/source"
+)
+
+// status describes the outcome of verifying a single lockfile entry.
+type status int
+
+const (
+	statusOK status = iota
+	statusMismatch
+	statusMissing
+	// statusUnverifiable marks entries whose hash pins a commit rather than file content.
+	statusUnverifiable
+	// statusUnreachable marks entries whose locked source could not be downloaded (--remote).
+	statusUnreachable
+)
+
+// result is the verification outcome for one lockfile entry.
+type result struct {
+	Name   string
+	Path   string
+	Status status
+	Detail string
+}
+
+// notLockedDetail explains an entry recorded by 'almd add --no-download', which has no hash until
+// the file is installed.
+const notLockedDetail = "no hash locked yet (added with --no-download); run 'almd install'"
+
+// verifyEntry hashes the file recorded for entry and compares it against the stored hash.
+// Paths are resolved relative to projectRoot.
+func verifyEntry(projectRoot, name string, entry lockfile.PackageEntry) result {
+	res := result{Name: name, Path: entry.Path}
+
+	if entry.Hash == "" {
+		res.Status = statusMissing
+		res.Detail = notLockedDetail
+		return res
+	}
+	expected, ok := entry.ExpectedContentHash()
+	if !ok {
+		res.Status = statusUnverifiable
+		res.Detail = fmt.Sprintf("unverifiable by content: hash '%s' pins a source commit, not file content", entry.Hash)
+		return res
+	}
+
+	content, err := os.ReadFile(filepath.Join(projectRoot, entry.Path))
+	if err != nil {
+		res.Status = statusMissing
+		if os.IsNotExist(err) {
+			res.Detail = "file not found"
+		} else {
+			res.Detail = err.Error()
+		}
+		return res
+	}
+
+	actual, err := hasher.Calculate(content, hasher.AlgorithmFor(expected))
+	if err != nil {
+		res.Status = statusMismatch
+		res.Detail = fmt.Sprintf("failed to hash file: %v", err)
+		return res
+	}
+	if actual != expected {
+		res.Status = statusMismatch
+		res.Detail = fmt.Sprintf("expected %s, found %s", expected, actual)
+		return res
+	}
+	res.Status = statusOK
+	return res
+}
+
+// verifyRemoteEntry downloads entry's locked source URL and compares the hash of what is served
+// now against the stored hash, catching upstream content that changed behind an unchanged URL.
+// The URL must pass policyHooks and the response may not exceed maxSize bytes (0 for no limit).
+// Nothing is written to disk.
+func verifyRemoteEntry(name string, entry lockfile.PackageEntry, maxSize int64, policyHooks ...source.Hook) result {
+	res := result{Name: name, Path: entry.Path}
+
+	if entry.Hash == "" {
+		res.Status = statusMissing
+		res.Detail = notLockedDetail
+		return res
+	}
+	expected, ok := entry.ExpectedContentHash()
+	if !ok {
+		res.Status = statusUnverifiable
+		res.Detail = fmt.Sprintf("unverifiable by content: hash '%s' pins a source commit, not file content", entry.Hash)
+		return res
+	}
+
+	sourceURL, err := source.CheckURL(entry.Source, policyHooks...)
+	if err != nil {
+		res.Status = statusUnreachable
+		res.Detail = fmt.Sprintf("%s rejected by policy: %v", entry.Source, err)
+		return res
+	}
+	content, err := downloader.DownloadFileWithLimit(sourceURL, maxSize)
+	if err != nil {
+		res.Status = statusUnreachable
+		res.Detail = fmt.Sprintf("could not download %s: %v", entry.Source, err)
+		return res
+	}
+
+	actual, err := hasher.Calculate(content, hasher.AlgorithmFor(expected))
+	if err != nil {
+		res.Status = statusMismatch
+		res.Detail = fmt.Sprintf("failed to hash remote content: %v", err)
+		return res
+	}
+	if actual != expected {
+		res.Status = statusMismatch
+		res.Detail = fmt.Sprintf("%s now serves %s, expected %s", entry.Source, actual, expected)
+		return res
+	}
+	res.Status = statusOK
+	return res
+}
+
+// extraEntries returns the sorted names of lockfile entries that project.toml does not declare.
+// Without a readable project.toml there is nothing to compare against, so none are reported.
+func extraEntries(projectRoot string, entries map[string]lockfile.PackageEntry) []string {
+	proj, err := config.LoadProjectToml(projectRoot)
+	if err != nil {
+		return nil
+	}
+	var extras []string
+	for name := range entries {
+		if _, _, declared := proj.LookupDependency(name); !declared {
+			extras = append(extras, name)
+		}
+	}
+	sort.Strings(extras)
+	return extras
+}
+
+// untrackedFiles returns the sorted, slash-separated paths of files that sit in a managed
+// directory without being recorded in almd-lock.toml. A managed directory is one that directly
+// contains the Path of some lockfile entry; the project root itself and subdirectories are
+// not managed unless an entry lives directly in them. Hidden files (editor and VCS metadata,
+// almd's own temporary files) and the paths in ignore, such as the generated requires file,
+// are not reported.
+func untrackedFiles(projectRoot string, entries map[string]lockfile.PackageEntry, ignore map[string]bool) []string {
+	tracked := make(map[string]bool, len(entries))
+	managed := make(map[string]bool)
+	for _, entry := range entries {
+		p := project.NormalizePath(entry.Path)
+		tracked[p] = true
+		if dir := path.Dir(p); dir != "." && !strings.HasPrefix(dir, "../") && dir != ".." {
+			managed[dir] = true
+		}
+	}
+
+	var untracked []string
+	for dir := range managed {
+		dirEntries, err := os.ReadDir(filepath.Join(projectRoot, filepath.FromSlash(dir)))
+		if err != nil {
+			continue // A missing directory is already reported by its missing files.
+		}
+		for _, de := range dirEntries {
+			p := path.Join(dir, de.Name())
+			if de.IsDir() || strings.HasPrefix(de.Name(), ".") || tracked[p] || ignore[p] {
+				continue
+			}
+			untracked = append(untracked, p)
+		}
+	}
+	sort.Strings(untracked)
+	return untracked
+}
+
+// verifyAll verifies every entry using up to jobs concurrent workers and returns the results
+// sorted by dependency name, so the report is identical however the work was scheduled.
+func verifyAll(projectRoot string, entries map[string]lockfile.PackageEntry, jobs int) []result {
+	return verifyEach(entries, jobs, func(name string, entry lockfile.PackageEntry) result {
+		return verifyEntry(projectRoot, name, entry)
+	})
+}
+
+// verifyEach runs check on every entry using up to jobs concurrent workers and returns the
+// results sorted by dependency name.
+func verifyEach(entries map[string]lockfile.PackageEntry, jobs int, check func(name string, entry lockfile.PackageEntry) result) []result {
+	names := make([]string, 0, len(entries))
+	for name := range entries {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	if jobs < 1 {
+		jobs = 1
+	}
+	if jobs > len(names) {
+		jobs = len(names)
+	}
+
+	// Each worker writes only to the slots of the indices it receives, so no locking is needed.
+	results := make([]result, len(names))
+	indices := make(chan int)
+	var wg sync.WaitGroup
+	for w := 0; w < jobs; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range indices {
+				results[i] = check(names[i], entries[names[i]])
+			}
+		}()
+	}
+	for i := range names {
+		indices <- i
+	}
+	close(indices)
+	wg.Wait()
+	return results
+}
+
+// NewVerifyCommand creates the 'verify' command.
+func NewVerifyCommand() *cli.Command {
+	return &cli.Command{
+		Name:  "verify",
+		Usage: "Checks dependency files on disk against the hashes in almd-lock.toml",
+		Flags: []cli.Flag{
+			&cli.BoolFlag{
+				Name:    "strict",
+				Aliases: []string{"check-hash-only"},
+				Usage:   "Fail on entries pinned only by commit, which cannot be verified against file content",
+			},
+			&cli.IntFlag{
+				Name:    "jobs",
+				Aliases: []string{"j"},
+				Usage:   "Number of files to hash in parallel",
+				Value:   runtime.NumCPU(),
+			},
+			&cli.BoolFlag{
+				Name:  "remote",
+				Usage: "Re-download each locked source URL and check it still hashes to the locked value (read-only)",
+			},
+			&cli.StringFlag{
+				Name:  "max-size",
+				Usage: "Largest file to download with --remote, e.g. 200MB (0 disables the limit)",
+				Value: "50MB",
+			},
+		},
+		Action: func(c *cli.Context) error {
+			// The per-dependency report and summary go to stdout; warnings go to stderr.
+			stdout, stderr := c.App.Writer, c.App.ErrWriter
+			strict := c.Bool("strict")
+
+			lf, err := lockfile.Load(".")
+			if err != nil {
+				return cli.Exit(fmt.Sprintf("Error loading %s: %v", lockfile.LockfileName, err), 1)
+			}
+			if len(lf.Package) == 0 {
+				_, _ = fmt.Fprintf(stdout, "No dependencies in %s to verify.\n", lockfile.LockfileName)
+				return nil
+			}
+
+			var results []result
+			if c.Bool("remote") {
+				maxSize, err := downloader.ParseSize(c.String("max-size"))
+				if err != nil {
+					return cli.Exit(fmt.Sprintf("Error: Invalid --max-size: %v", err), 1)
+				}
+				// Locked URLs are downloaded as written, so they pass the same allowed_hosts
+				// policy as 'almd install'. Without a project.toml there is no policy to apply.
+				var policyHooks []source.Hook
+				proj, err := config.LoadProjectToml(".")
+				switch {
+				case err == nil:
+					policyHooks = append(policyHooks, source.AllowedHostsHook(proj.AllowedHosts()))
+				case !os.IsNotExist(err):
+					return cli.Exit(fmt.Sprintf("Error loading %s: %v", config.ProjectTomlName, err), 1)
+				}
+				results = verifyEach(lf.Package, c.Int("jobs"), func(name string, entry lockfile.PackageEntry) result {
+					return verifyRemoteEntry(name, entry, maxSize, policyHooks...)
+				})
+			} else {
+				results = verifyAll(".", lf.Package, c.Int("jobs"))
+			}
+
+			var verified, failed, skipped int
+			for _, res := range results {
+				switch res.Status {
+				case statusOK:
+					verified++
+					_, _ = fmt.Fprintf(stdout, "ok       %s (%s)\n", res.Name, res.Path)
+				case statusMismatch:
+					failed++
+					_, _ = fmt.Fprintf(stdout, "MISMATCH %s (%s): %s\n", res.Name, res.Path, res.Detail)
+				case statusMissing:
+					failed++
+					_, _ = fmt.Fprintf(stdout, "MISSING  %s (%s): %s\n", res.Name, res.Path, res.Detail)
+				case statusUnreachable:
+					failed++
+					_, _ = fmt.Fprintf(stdout, "ERROR    %s (%s): %s\n", res.Name, res.Path, res.Detail)
+				case statusUnverifiable:
+					if strict {
+						failed++
+						_, _ = fmt.Fprintf(stdout, "FAILED   %s (%s): %s\n", res.Name, res.Path, res.Detail)
+					} else {
+						skipped++
+						_, _ = fmt.Fprintf(stderr, "Warning: Skipping '%s': %s. Use --strict to treat this as a failure.\n", res.Name, res.Detail)
+					}
+				}
+			}
+
+			// Extra entries are stale bookkeeping rather than tampering, so they do not fail verification.
+			extras := extraEntries(".", lf.Package)
+			for _, name := range extras {
+				_, _ = fmt.Fprintf(stdout, "EXTRA    %s (%s): not declared in %s\n", name, lf.Package[name].Path, config.ProjectTomlName)
+			}
+
+			// Files almd does not track are never touched, only pointed out, so that generated or
+			// hand-written companions of a vendored file are not mistaken for dependencies.
+			if !c.Bool("remote") {
+				ignore := make(map[string]bool)
+				if proj, loadErr := config.LoadProjectToml("."); loadErr == nil {
+					if requiresPath, _ := proj.RequiresSettings(); requiresPath != "" {
+						ignore[project.NormalizePath(requiresPath)] = true
+					}
+				}
+				for _, p := range untrackedFiles(".", lf.Package, ignore) {
+					_, _ = fmt.Fprintf(stderr, "Warning: %s is in a directory of managed dependencies but is not tracked in %s; almd leaves it alone.\n", p, lockfile.LockfileName)
+				}
+			}
+
+			summary := fmt.Sprintf("Verified %d, failed %d, skipped %d", verified, failed, skipped)
+			if len(extras) > 0 {
+				summary += fmt.Sprintf(", extra %d", len(extras))
+			}
+			_, _ = fmt.Fprintf(stdout, "%s.\n", summary)
+			if failed > 0 {
+				return cli.Exit(fmt.Sprintf("Error: %d dependenc(ies) failed verification.", failed), 1)
+			}
+			return nil
+		},
+	}
+}
@@ -0,0 +1,419 @@
+package verify
+
+import (
+	"bytes"
+	"crypto/rand"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/urfave/cli/v2"
+
+	"github.com/nightconcept/almandine-go/internal/core/config"
+	"github.com/nightconcept/almandine-go/internal/core/hasher"
+	"github.com/nightconcept/almandine-go/internal/core/lockfile"
+)
+
+// setupVerifyTestEnvironment writes lockToml and the given dependency files into a temp dir.
+func setupVerifyTestEnvironment(t *testing.T, lockToml string, files map[string]string) string {
+	t.Helper()
+	tempDir := t.TempDir()
+	require.NoError(t, os.WriteFile(filepath.Join(tempDir, lockfile.LockfileName), []byte(lockToml), 0644))
+	for relPath, content := range files {
+		absPath := filepath.Join(tempDir, relPath)
+		require.NoError(t, os.MkdirAll(filepath.Dir(absPath), 0755))
+		require.NoError(t, os.WriteFile(absPath, []byte(content), 0644))
+	}
+	return tempDir
+}
+
+// runVerifyCommand runs 'verify' in workDir and returns its stdout, stderr and error.
+func runVerifyCommand(t *testing.T, workDir string, args ...string) (string, string, error) {
+	t.Helper()
+
+	originalWd, err := os.Getwd()
+	require.NoError(t, err)
+	require.NoError(t, os.Chdir(workDir))
+	defer func() { require.NoError(t, os.Chdir(originalWd)) }()
+
+	var stdout, stderr bytes.Buffer
+	app := &cli.App{
+		Name:           "almd-test-verify",
+		Commands:       []*cli.Command{NewVerifyCommand()},
+		Writer:         &stdout,
+		ErrWriter:      &stderr,
+		ExitErrHandler: func(context *cli.Context, err error) {},
+	}
+	err = app.Run(append([]string{"almd-test-verify", "verify"}, args...))
+	return stdout.String(), stderr.String(), err
+}
+
+func sha256Of(t *testing.T, content string) string {
+	t.Helper()
+	hash, err := hasher.CalculateSHA256([]byte(content))
+	require.NoError(t, err)
+	return hash
+}
+
+func TestVerifyCommand_MatchingFile(t *testing.T) {
+	content := "return {}\n"
+	tempDir := setupVerifyTestEnvironment(t, `
+api_version = "1"
+
+[package.good]
+source = "https://example.com/good.lua"
+path = "libs/good.lua"
+hash = "`+sha256Of(t, content)+`"
+`, map[string]string{"libs/good.lua": content})
+
+	stdout, _, err := runVerifyCommand(t, tempDir)
+	require.NoError(t, err)
+	assert.Contains(t, stdout, "ok       good (libs/good.lua)")
+	assert.Contains(t, stdout, "Verified 1, failed 0, skipped 0.")
+}
+
+func TestVerifyCommand_MismatchingFile(t *testing.T) {
+	tempDir := setupVerifyTestEnvironment(t, `
+api_version = "1"
+
+[package.tampered]
+source = "https://example.com/tampered.lua"
+path = "libs/tampered.lua"
+hash = "`+sha256Of(t, "original content")+`"
+`, map[string]string{"libs/tampered.lua": "modified content"})
+
+	stdout, _, err := runVerifyCommand(t, tempDir)
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "failed verification")
+	assert.Contains(t, stdout, "MISMATCH tampered (libs/tampered.lua)")
+}
+
+func TestVerifyCommand_SHA512Entry(t *testing.T) {
+	content := "return {}\n"
+	sha512Hash, err := hasher.Calculate([]byte(content), hasher.SHA512)
+	require.NoError(t, err)
+	tempDir := setupVerifyTestEnvironment(t, `
+api_version = "1"
+
+[package.good]
+source = "https://example.com/good.lua"
+path = "libs/good.lua"
+hash = "`+sha512Hash+`"
+
+[package.tampered]
+source = "https://example.com/tampered.lua"
+path = "libs/tampered.lua"
+hash = "`+sha512Hash+`"
+`, map[string]string{"libs/good.lua": content, "libs/tampered.lua": "modified content"})
+
+	stdout, _, err := runVerifyCommand(t, tempDir)
+	require.Error(t, err)
+	assert.Contains(t, stdout, "ok       good (libs/good.lua)")
+	assert.Contains(t, stdout, "MISMATCH tampered (libs/tampered.lua)")
+	assert.Contains(t, stdout, "found sha512:", "the file is hashed with the locked algorithm")
+}
+
+func TestVerifyCommand_MissingFile(t *testing.T) {
+	tempDir := setupVerifyTestEnvironment(t, `
+api_version = "1"
+
+[package.gone]
+source = "https://example.com/gone.lua"
+path = "libs/gone.lua"
+hash = "`+sha256Of(t, "x")+`"
+`, nil)
+
+	stdout, _, err := runVerifyCommand(t, tempDir)
+	require.Error(t, err)
+	assert.Contains(t, stdout, "MISSING  gone (libs/gone.lua)")
+}
+
+func TestVerifyCommand_EntryWithoutHash(t *testing.T) {
+	// As written by 'almd add --no-download', even once the file has been supplied by hand.
+	tempDir := setupVerifyTestEnvironment(t, `
+api_version = "1"
+
+[package.pending]
+source = "https://example.com/pending.lua"
+path = "libs/pending.lua"
+hash = ""
+`, map[string]string{"libs/pending.lua": "return {}\n"})
+
+	stdout, _, err := runVerifyCommand(t, tempDir)
+	require.Error(t, err)
+	assert.Contains(t, stdout, "MISSING  pending (libs/pending.lua): no hash locked yet (added with --no-download); run 'almd install'")
+}
+
+func TestVerifyCommand_CommitOnlyEntry(t *testing.T) {
+	lockToml := `
+api_version = "1"
+
+[package.pinned]
+source = "https://raw.githubusercontent.com/owner/repo/abcdef1/pinned.lua"
+path = "libs/pinned.lua"
+hash = "commit:abcdef1234567890abcdef1234567890abcdef12"
+`
+	files := map[string]string{"libs/pinned.lua": "return {}"}
+
+	t.Run("default skips with warning", func(t *testing.T) {
+		tempDir := setupVerifyTestEnvironment(t, lockToml, files)
+
+		stdout, stderr, err := runVerifyCommand(t, tempDir)
+		require.NoError(t, err)
+		assert.Contains(t, stderr, "Warning: Skipping 'pinned': unverifiable by content")
+		assert.Contains(t, stdout, "Verified 0, failed 0, skipped 1.")
+	})
+
+	t.Run("strict fails", func(t *testing.T) {
+		tempDir := setupVerifyTestEnvironment(t, lockToml, files)
+
+		stdout, _, err := runVerifyCommand(t, tempDir, "--strict")
+		require.Error(t, err)
+		assert.Contains(t, stdout, "FAILED   pinned (libs/pinned.lua)")
+		assert.Contains(t, stdout, "Verified 0, failed 1, skipped 0.")
+	})
+}
+
+func TestVerifyCommand_CommitEntryWithContentHash(t *testing.T) {
+	tempDir := setupVerifyTestEnvironment(t, `
+api_version = "1"
+
+[package.pinned]
+source = "https://raw.githubusercontent.com/owner/repo/abcdef1/pinned.lua"
+path = "libs/pinned.lua"
+hash = "commit:abcdef1234567890abcdef1234567890abcdef12"
+content_hash = "`+sha256Of(t, "return {}")+`"
+`, map[string]string{"libs/pinned.lua": "return { tampered = true }"})
+
+	stdout, _, err := runVerifyCommand(t, tempDir)
+	require.Error(t, err, "the content hash makes commit-pinned files verifiable")
+	assert.Contains(t, stdout, "MISMATCH pinned (libs/pinned.lua)")
+	assert.Contains(t, stdout, "Verified 0, failed 1, skipped 0.")
+}
+
+func TestVerifyCommand_ExtraLockfileEntries(t *testing.T) {
+	content := "return {}\n"
+	tempDir := setupVerifyTestEnvironment(t, `
+api_version = "1"
+
+[package.kept]
+source = "https://example.com/kept.lua"
+path = "libs/kept.lua"
+hash = "`+sha256Of(t, content)+`"
+
+[package.orphan]
+source = "https://example.com/orphan.lua"
+path = "libs/orphan.lua"
+hash = "`+sha256Of(t, content)+`"
+`, map[string]string{"libs/kept.lua": content, "libs/orphan.lua": content})
+	require.NoError(t, os.WriteFile(filepath.Join(tempDir, config.ProjectTomlName), []byte(`
+[dependencies.kept]
+source = "https://example.com/kept.lua"
+path = "libs/kept.lua"
+`), 0644))
+
+	stdout, _, err := runVerifyCommand(t, tempDir)
+	require.NoError(t, err, "extra entries are reported but do not fail verification")
+	assert.Contains(t, stdout, "EXTRA    orphan (libs/orphan.lua): not declared in project.toml")
+	assert.NotContains(t, stdout, "EXTRA    kept")
+	assert.Contains(t, stdout, "Verified 2, failed 0, skipped 0, extra 1.")
+}
+
+func TestVerifyCommand_UntrackedFiles(t *testing.T) {
+	content := "return {}\n"
+	tempDir := setupVerifyTestEnvironment(t, `
+api_version = "1"
+
+[package.json]
+source = "https://example.com/json.lua"
+path = "libs/json.lua"
+hash = "`+sha256Of(t, content)+`"
+`, map[string]string{
+		"libs/json.lua":          content,
+		"libs/json_types.lua":    "-- generated next to the vendored file",
+		"libs/.gitkeep":          "",
+		"libs/sub/helper.lua":    "-- not in a managed directory",
+		"other/notes.lua":        "-- not in a managed directory",
+		"libs/init.lua":          "-- generated requires file",
+		"project-local-file.lua": "-- the project root is never managed",
+	})
+	require.NoError(t, os.WriteFile(filepath.Join(tempDir, config.ProjectTomlName), []byte(`
+[package]
+name = "verify-untracked"
+version = "0.1.0"
+
+[almd]
+generate_requires = "libs/init.lua"
+
+[dependencies]
+json = { source = "https://example.com/json.lua", path = "libs/json.lua" }
+`), 0644))
+
+	stdout, stderr, err := runVerifyCommand(t, tempDir)
+	require.NoError(t, err, "untracked files only warn")
+	assert.Contains(t, stdout, "ok       json (libs/json.lua)")
+	assert.Contains(t, stderr, "Warning: libs/json_types.lua is in a directory of managed dependencies but is not tracked")
+	for _, quiet := range []string{".gitkeep", "helper.lua", "notes.lua", "init.lua", "project-local-file.lua"} {
+		assert.NotContains(t, stderr, quiet)
+	}
+	assert.FileExists(t, filepath.Join(tempDir, "libs", "json_types.lua"), "verify never deletes anything")
+}
+
+func TestVerifyCommand_Remote(t *testing.T) {
+	served := map[string]string{
+		"/stable.lua":  "return 'stable'",
+		"/mutated.lua": "return 'mutated upstream'",
+	}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, ok := served[r.URL.Path]
+		if !ok {
+			http.NotFound(w, r)
+			return
+		}
+		_, _ = w.Write([]byte(body))
+	}))
+	defer server.Close()
+
+	lockToml := fmt.Sprintf(`
+api_version = "1"
+
+[package.stable]
+source = "%[1]s/stable.lua"
+path = "libs/stable.lua"
+hash = "%[2]s"
+
+[package.mutated]
+source = "%[1]s/mutated.lua"
+path = "libs/mutated.lua"
+hash = "%[3]s"
+
+[package.vanished]
+source = "%[1]s/vanished.lua"
+path = "libs/vanished.lua"
+hash = "%[4]s"
+`, server.URL, sha256Of(t, "return 'stable'"), sha256Of(t, "return 'original'"), sha256Of(t, "return 'vanished'"))
+	// The local copy of mutated.lua still matches the lockfile; only upstream changed.
+	files := map[string]string{
+		"libs/stable.lua":   "return 'stable'",
+		"libs/mutated.lua":  "return 'original'",
+		"libs/vanished.lua": "return 'vanished'",
+	}
+	tempDir := setupVerifyTestEnvironment(t, lockToml, files)
+	lockBefore, err := os.ReadFile(filepath.Join(tempDir, lockfile.LockfileName))
+	require.NoError(t, err)
+
+	stdout, _, err := runVerifyCommand(t, tempDir, "--remote")
+	require.Error(t, err)
+	assert.Contains(t, stdout, "ok       stable (libs/stable.lua)")
+	assert.Contains(t, stdout, "MISMATCH mutated (libs/mutated.lua): "+server.URL+"/mutated.lua now serves "+sha256Of(t, "return 'mutated upstream'"))
+	assert.Contains(t, stdout, "ERROR    vanished (libs/vanished.lua): could not download")
+	assert.Contains(t, stdout, "Verified 1, failed 2, skipped 0.")
+
+	// --remote is read-only: neither the lockfile nor the local files change.
+	lockAfter, err := os.ReadFile(filepath.Join(tempDir, lockfile.LockfileName))
+	require.NoError(t, err)
+	assert.Equal(t, string(lockBefore), string(lockAfter))
+	local, err := os.ReadFile(filepath.Join(tempDir, "libs", "mutated.lua"))
+	require.NoError(t, err)
+	assert.Equal(t, "return 'original'", string(local))
+
+	_, _, err = runVerifyCommand(t, tempDir)
+	assert.NoError(t, err, "the local files still match the lockfile")
+}
+
+func TestVerifyCommand_RemotePolicyAndSizeLimit(t *testing.T) {
+	var requests atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests.Add(1)
+		_, _ = w.Write([]byte("return 'large enough'"))
+	}))
+	defer server.Close()
+
+	lockToml := fmt.Sprintf(`
+api_version = "1"
+
+[package.lib]
+source = "%s/lib.lua"
+path = "libs/lib.lua"
+hash = "%s"
+`, server.URL, sha256Of(t, "return 'large enough'"))
+
+	t.Run("a host outside allowed_hosts is not contacted", func(t *testing.T) {
+		tempDir := setupVerifyTestEnvironment(t, lockToml, map[string]string{
+			config.ProjectTomlName: "[package]\nname = \"test-verify\"\n\n[almd]\nallowed_hosts = [\"mirror.example.com\"]\n",
+		})
+		requests.Store(0)
+
+		stdout, _, err := runVerifyCommand(t, tempDir, "--remote")
+		require.Error(t, err)
+		assert.Contains(t, stdout, "ERROR    lib (libs/lib.lua): "+server.URL+"/lib.lua rejected by policy")
+		assert.Zero(t, requests.Load())
+	})
+
+	t.Run("--max-size caps the download", func(t *testing.T) {
+		tempDir := setupVerifyTestEnvironment(t, lockToml, nil)
+
+		stdout, _, err := runVerifyCommand(t, tempDir, "--remote", "--max-size", "8B")
+		require.Error(t, err)
+		assert.Contains(t, stdout, "ERROR    lib (libs/lib.lua): could not download")
+
+		_, _, err = runVerifyCommand(t, tempDir, "--remote")
+		assert.NoError(t, err)
+	})
+}
+
+// writeSyntheticProject creates count files of size bytes under root and returns lockfile
+// entries for them. Every third entry records a wrong hash so the report has failures.
+func writeSyntheticProject(tb testing.TB, root string, count, size int) map[string]lockfile.PackageEntry {
+	tb.Helper()
+	entries := make(map[string]lockfile.PackageEntry, count)
+	buf := make([]byte, size)
+	for i := 0; i < count; i++ {
+		_, err := rand.Read(buf)
+		require.NoError(tb, err)
+		relPath := fmt.Sprintf("libs/dep%03d.lua", i)
+		absPath := filepath.Join(root, relPath)
+		require.NoError(tb, os.MkdirAll(filepath.Dir(absPath), 0755))
+		require.NoError(tb, os.WriteFile(absPath, buf, 0644))
+
+		hash, err := hasher.CalculateSHA256(buf)
+		require.NoError(tb, err)
+		if i%3 == 0 {
+			hash = "sha256:0000"
+		}
+		entries[fmt.Sprintf("dep%03d", i)] = lockfile.PackageEntry{Path: relPath, Hash: hash}
+	}
+	return entries
+}
+
+func TestVerifyAll_DeterministicOrder(t *testing.T) {
+	root := t.TempDir()
+	entries := writeSyntheticProject(t, root, 40, 256)
+
+	serial := verifyAll(root, entries, 1)
+	require.Len(t, serial, 40)
+	for i := 1; i < len(serial); i++ {
+		assert.Less(t, serial[i-1].Name, serial[i].Name, "results must be sorted by dependency name")
+	}
+	for run := 0; run < 5; run++ {
+		assert.Equal(t, serial, verifyAll(root, entries, 8), "parallel results must match the serial report")
+	}
+}
+
+func benchmarkVerifyAll(b *testing.B, jobs int) {
+	root := b.TempDir()
+	entries := writeSyntheticProject(b, root, 64, 1<<20)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		verifyAll(root, entries, jobs)
+	}
+}
+
+func BenchmarkVerifyAll_Serial(b *testing.B)   { benchmarkVerifyAll(b, 1) }
+func BenchmarkVerifyAll_Parallel(b *testing.B) { benchmarkVerifyAll(b, 8) }
@@ -0,0 +1,73 @@
+// Title: Almandine CLI Version Command
+// Purpose: Implements the 'version' command, which reports the build details of the running
+// almd binary, either as a line of text or, with --json, as a JSON object for tooling.
+package versioncmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"runtime"
+
+	"github.com/urfave/cli/v2"
+)
+
+// BuildInfo holds the values embedded at build time through -ldflags in main.
+type BuildInfo struct {
+	Version string
+	Commit  string
+	Date    string
+}
+
+// report is the JSON document printed by 'almd version --json'.
+type report struct {
+	Version   string `json:"version"`
+	Commit    string `json:"commit,omitempty"`
+	Date      string `json:"date,omitempty"`
+	GoVersion string `json:"go_version"`
+	OS        string `json:"os"`
+	Arch      string `json:"arch"`
+}
+
+// NewVersionCommand creates the 'version' command for a binary built with build.
+func NewVersionCommand(build BuildInfo) *cli.Command {
+	return &cli.Command{
+		Name:  "version",
+		Usage: "Prints almd's version and build details",
+		Flags: []cli.Flag{
+			&cli.BoolFlag{
+				Name:  "json",
+				Usage: "Print the build details as a JSON object",
+			},
+		},
+		Action: func(c *cli.Context) error {
+			stdout := c.App.Writer
+			r := report{
+				Version:   build.Version,
+				Commit:    build.Commit,
+				Date:      build.Date,
+				GoVersion: runtime.Version(),
+				OS:        runtime.GOOS,
+				Arch:      runtime.GOARCH,
+			}
+
+			if c.Bool("json") {
+				data, err := json.MarshalIndent(r, "", "  ")
+				if err != nil {
+					return cli.Exit(fmt.Sprintf("Error encoding version information: %v", err), 1)
+				}
+				_, _ = fmt.Fprintln(stdout, string(data))
+				return nil
+			}
+
+			_, _ = fmt.Fprintf(stdout, "almd %s", r.Version)
+			if r.Commit != "" {
+				_, _ = fmt.Fprintf(stdout, " (commit %s)", r.Commit)
+			}
+			if r.Date != "" {
+				_, _ = fmt.Fprintf(stdout, " built %s", r.Date)
+			}
+			_, _ = fmt.Fprintf(stdout, " %s %s/%s\n", r.GoVersion, r.OS, r.Arch)
+			return nil
+		},
+	}
+}
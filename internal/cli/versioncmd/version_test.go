@@ -0,0 +1,53 @@
+package versioncmd
+
+import (
+	"bytes"
+	"encoding/json"
+	"runtime"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/urfave/cli/v2"
+)
+
+// runVersionCommand runs 'version' for a binary built with build and returns its stdout.
+func runVersionCommand(t *testing.T, build BuildInfo, args ...string) string {
+	t.Helper()
+	var stdout bytes.Buffer
+	app := &cli.App{
+		Name:     "almd-test-version",
+		Commands: []*cli.Command{NewVersionCommand(build)},
+		Writer:   &stdout,
+	}
+	require.NoError(t, app.Run(append([]string{"almd-test-version", "version"}, args...)))
+	return stdout.String()
+}
+
+func TestVersionCommand_JSON(t *testing.T) {
+	build := BuildInfo{Version: "v1.2.3", Commit: "abc1234", Date: "2024-05-01T12:00:00Z"}
+
+	var got map[string]string
+	require.NoError(t, json.Unmarshal([]byte(runVersionCommand(t, build, "--json")), &got))
+	assert.Equal(t, map[string]string{
+		"version":    "v1.2.3",
+		"commit":     "abc1234",
+		"date":       "2024-05-01T12:00:00Z",
+		"go_version": runtime.Version(),
+		"os":         runtime.GOOS,
+		"arch":       runtime.GOARCH,
+	}, got)
+}
+
+func TestVersionCommand_JSONWithoutBuildVars(t *testing.T) {
+	var got map[string]string
+	require.NoError(t, json.Unmarshal([]byte(runVersionCommand(t, BuildInfo{Version: "dev"}, "--json")), &got))
+	assert.Equal(t, "dev", got["version"])
+	assert.NotContains(t, got, "commit", "fields not embedded at build time are omitted")
+	assert.NotContains(t, got, "date")
+}
+
+func TestVersionCommand_Text(t *testing.T) {
+	out := runVersionCommand(t, BuildInfo{Version: "v1.2.3", Commit: "abc1234"})
+	assert.Equal(t, "almd v1.2.3 (commit abc1234) "+runtime.Version()+" "+runtime.GOOS+"/"+runtime.GOARCH+"\n", out)
+}
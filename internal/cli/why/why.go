@@ -0,0 +1,132 @@
+// Title: Almandine CLI Why Command
+// Purpose: Implements the 'why' command, which explains where an installed dependency came from
+// by combining its project.toml and almd-lock.toml entries with the state of the file on disk.
+package why
+
+import (
+	"errors"
+	"fmt"
+	"os"
+
+	"github.com/urfave/cli/v2"
+
+	"github.com/nightconcept/almandine-go/internal/core/config"
+	"github.com/nightconcept/almandine-go/internal/core/hasher"
+	"github.com/nightconcept/almandine-go/internal/core/lockfile"
+	"github.com/nightconcept/almandine-go/internal/core/project"
+	"github.com/nightconcept/almandine-go/internal/core/source"
+)
+
+// findByPath returns the name of the dependency installed at p, looking at project.toml first
+// and then at almd-lock.toml.
+func findByPath(proj *project.Project, lf *lockfile.Lockfile, p string) (string, bool) {
+	target := project.NormalizePath(p)
+	for name, dep := range proj.AllDependencies() {
+		if project.NormalizePath(dep.Path) == target {
+			return name, true
+		}
+	}
+	for name, entry := range lf.Package {
+		if project.NormalizePath(entry.Path) == target {
+			return name, true
+		}
+	}
+	return "", false
+}
+
+// fileState describes the file at p against the hash almd-lock.toml expects for it.
+func fileState(p string, entry lockfile.PackageEntry, locked bool) string {
+	content, err := os.ReadFile(project.NativePath(p))
+	if errors.Is(err, os.ErrNotExist) {
+		return "missing"
+	}
+	if err != nil {
+		return fmt.Sprintf("unreadable (%v)", err)
+	}
+	expected, ok := entry.ExpectedContentHash()
+	if !locked || !ok {
+		return "present (no content hash to compare)"
+	}
+	if match, err := hasher.Matches(content, expected); err == nil && match {
+		return "present, matches " + lockfile.LockfileName
+	}
+	return "present, does NOT match " + lockfile.LockfileName
+}
+
+// NewWhyCommand creates the 'why' command.
+func NewWhyCommand() *cli.Command {
+	return &cli.Command{
+		Name:      "why",
+		Usage:     "Explains where an installed dependency comes from",
+		ArgsUsage: "<dependency_name|path>",
+		Description: "Looks up a dependency by name, or by the path it is installed at, in project.toml and\n" +
+			"almd-lock.toml and prints its source, download URL, locked hash, local path and whether\n" +
+			"the local file matches the lockfile. Exits with status 1 if nothing matches.",
+		Action: func(c *cli.Context) error {
+			// The explanation goes to stdout; notes about inconsistent entries go to stderr.
+			stdout, stderr := c.App.Writer, c.App.ErrWriter
+			if c.NArg() != 1 {
+				return cli.Exit("Error: Exactly one dependency name or path is required.", 1)
+			}
+			arg := c.Args().First()
+
+			proj, err := config.LoadProjectToml(".")
+			if err != nil {
+				if os.IsNotExist(err) {
+					return cli.Exit(fmt.Sprintf("Error: %s not found in the current directory.", config.ProjectTomlName), 1)
+				}
+				return cli.Exit(fmt.Sprintf("Error loading %s: %v", config.ProjectTomlName, err), 1)
+			}
+			lf, err := lockfile.Load(".")
+			if err != nil {
+				return cli.Exit(fmt.Sprintf("Error loading %s: %v", lockfile.LockfileName, err), 1)
+			}
+
+			name := arg
+			dep, group, declared := proj.LookupDependency(name)
+			entry, locked := lf.Package[name]
+			if !declared && !locked {
+				var found bool
+				if name, found = findByPath(proj, lf, arg); !found {
+					return cli.Exit(fmt.Sprintf("Error: No dependency named '%s' or installed at that path in %s or %s.", arg, config.ProjectTomlName, lockfile.LockfileName), 1)
+				}
+				dep, group, declared = proj.LookupDependency(name)
+				entry, locked = lf.Package[name]
+			}
+
+			sourceURL, localPath := dep.Source, dep.Path
+			if !declared {
+				_, _ = fmt.Fprintf(stderr, "Note: '%s' is in %s but not declared in %s.\n", name, lockfile.LockfileName, config.ProjectTomlName)
+				sourceURL, localPath, group = entry.Source, entry.Path, entry.Group
+			}
+			canonical, download := sourceURL, ""
+			if parsed, err := source.ParseSourceURL(sourceURL); err == nil {
+				canonical, download = parsed.CanonicalURL, parsed.RawURL
+			}
+			if locked {
+				// The lockfile records the exact URL the installed file was downloaded from.
+				download = entry.Source
+			}
+
+			_, _ = fmt.Fprintf(stdout, "%s\n", name)
+			_, _ = fmt.Fprintf(stdout, "  source:      %s\n", canonical)
+			if download != "" {
+				_, _ = fmt.Fprintf(stdout, "  download:    %s\n", download)
+			}
+			if locked {
+				_, _ = fmt.Fprintf(stdout, "  locked hash: %s\n", entry.Hash)
+			} else {
+				_, _ = fmt.Fprintf(stdout, "  locked hash: (not in %s)\n", lockfile.LockfileName)
+			}
+			_, _ = fmt.Fprintf(stdout, "  path:        %s\n", localPath)
+			if group != "" {
+				_, _ = fmt.Fprintf(stdout, "  group:       %s\n", group)
+			}
+			_, _ = fmt.Fprintf(stdout, "  file:        %s\n", fileState(localPath, entry, locked))
+			if declared && locked && project.NormalizePath(dep.Path) != project.NormalizePath(entry.Path) {
+				_, _ = fmt.Fprintf(stderr, "Note: %s locks '%s' at %s; run 'almd install' to bring it in line.\n", lockfile.LockfileName, name, entry.Path)
+			}
+			return nil
+		},
+	}
+}
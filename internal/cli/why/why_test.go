@@ -0,0 +1,110 @@
+package why
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/urfave/cli/v2"
+
+	"github.com/nightconcept/almandine-go/internal/core/config"
+	"github.com/nightconcept/almandine-go/internal/core/hasher"
+	"github.com/nightconcept/almandine-go/internal/core/lockfile"
+)
+
+const whyProjectToml = `
+[package]
+name = "test-why"
+version = "0.1.0"
+
+[dependencies.json]
+source = "github:rxi/json.lua/json.lua@master"
+path = "vendor/json.lua"
+
+[dependencies.lume]
+source = "github:rxi/lume/lume.lua@master"
+path = "vendor/lume.lua"
+`
+
+// runWhyCommand runs 'why' in workDir and returns its stdout, stderr and error.
+func runWhyCommand(t *testing.T, workDir string, args ...string) (string, string, error) {
+	t.Helper()
+
+	originalWd, err := os.Getwd()
+	require.NoError(t, err)
+	require.NoError(t, os.Chdir(workDir))
+	defer func() { require.NoError(t, os.Chdir(originalWd)) }()
+
+	var stdout, stderr bytes.Buffer
+	app := &cli.App{
+		Name:           "almd-test-why",
+		Commands:       []*cli.Command{NewWhyCommand()},
+		Writer:         &stdout,
+		ErrWriter:      &stderr,
+		ExitErrHandler: func(context *cli.Context, err error) {},
+	}
+	err = app.Run(append([]string{"almd-test-why", "why"}, args...))
+	return stdout.String(), stderr.String(), err
+}
+
+func setupWhyProject(t *testing.T) string {
+	t.Helper()
+	tempDir := t.TempDir()
+	require.NoError(t, os.WriteFile(filepath.Join(tempDir, config.ProjectTomlName), []byte(whyProjectToml), 0644))
+	require.NoError(t, os.MkdirAll(filepath.Join(tempDir, "vendor"), 0755))
+	require.NoError(t, os.WriteFile(filepath.Join(tempDir, "vendor", "json.lua"), []byte("return {}"), 0644))
+
+	contentHash, err := hasher.CalculateSHA256([]byte("return {}"))
+	require.NoError(t, err)
+	lf := lockfile.New()
+	lf.AddOrUpdatePackage("json", "https://raw.githubusercontent.com/rxi/json.lua/abc1234/json.lua", "vendor/json.lua", "commit:abc1234")
+	lf.SetContentHash("json", contentHash)
+	require.NoError(t, lockfile.Save(tempDir, lf))
+	return tempDir
+}
+
+func TestWhyCommand_ByName(t *testing.T) {
+	tempDir := setupWhyProject(t)
+
+	stdout, _, err := runWhyCommand(t, tempDir, "json")
+	require.NoError(t, err)
+	assert.Contains(t, stdout, "json\n")
+	assert.Contains(t, stdout, "source:      github:rxi/json.lua/json.lua@master")
+	assert.Contains(t, stdout, "download:    https://raw.githubusercontent.com/rxi/json.lua/abc1234/json.lua")
+	assert.Contains(t, stdout, "locked hash: commit:abc1234")
+	assert.Contains(t, stdout, "path:        vendor/json.lua")
+	assert.Contains(t, stdout, "file:        present, matches almd-lock.toml")
+
+	require.NoError(t, os.WriteFile(filepath.Join(tempDir, "vendor", "json.lua"), []byte("return 'edited'"), 0644))
+	stdout, _, err = runWhyCommand(t, tempDir, "json")
+	require.NoError(t, err)
+	assert.Contains(t, stdout, "file:        present, does NOT match almd-lock.toml")
+}
+
+func TestWhyCommand_ByPath(t *testing.T) {
+	tempDir := setupWhyProject(t)
+
+	stdout, _, err := runWhyCommand(t, tempDir, "vendor/json.lua")
+	require.NoError(t, err)
+	assert.Contains(t, stdout, "json\n")
+
+	stdout, _, err = runWhyCommand(t, tempDir, "./vendor/lume.lua")
+	require.NoError(t, err)
+	assert.Contains(t, stdout, "lume\n")
+	assert.Contains(t, stdout, "locked hash: (not in almd-lock.toml)")
+	assert.Contains(t, stdout, "file:        missing")
+}
+
+func TestWhyCommand_NotFound(t *testing.T) {
+	tempDir := setupWhyProject(t)
+
+	_, _, err := runWhyCommand(t, tempDir, "vendor/unknown.lua")
+	require.Error(t, err)
+	var exitErr cli.ExitCoder
+	require.ErrorAs(t, err, &exitErr)
+	assert.Equal(t, 1, exitErr.ExitCode())
+	assert.Contains(t, err.Error(), "No dependency named 'vendor/unknown.lua'")
+}
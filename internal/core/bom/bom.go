@@ -0,0 +1,40 @@
+// Package bom handles byte order marks that Windows editors put at the start of text files.
+// almd's TOML files must be UTF-8; a UTF-8 BOM is harmless and dropped, while UTF-16 and
+// UTF-32 content is rejected with an error that says how to fix the file.
+package bom
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+)
+
+// ErrUnsupportedEncoding is returned (wrapped) by Strip for files that are not UTF-8.
+var ErrUnsupportedEncoding = errors.New("file is not UTF-8")
+
+var utf8BOM = []byte{0xEF, 0xBB, 0xBF}
+
+// Order matters: the UTF-32 LE mark begins with the UTF-16 LE one.
+var unsupportedBOMs = []struct {
+	mark     []byte
+	encoding string
+}{
+	{[]byte{0x00, 0x00, 0xFE, 0xFF}, "UTF-32 (big-endian)"},
+	{[]byte{0xFF, 0xFE, 0x00, 0x00}, "UTF-32 (little-endian)"},
+	{[]byte{0xFE, 0xFF}, "UTF-16 (big-endian)"},
+	{[]byte{0xFF, 0xFE}, "UTF-16 (little-endian)"},
+}
+
+// Strip returns data without a leading UTF-8 byte order mark. If data starts with a UTF-16 or
+// UTF-32 byte order mark it returns an error naming the file and the encoding found.
+func Strip(name string, data []byte) ([]byte, error) {
+	if rest, ok := bytes.CutPrefix(data, utf8BOM); ok {
+		return rest, nil
+	}
+	for _, b := range unsupportedBOMs {
+		if bytes.HasPrefix(data, b.mark) {
+			return nil, fmt.Errorf("%w: %s is saved as %s; save it as UTF-8 (without BOM)", ErrUnsupportedEncoding, name, b.encoding)
+		}
+	}
+	return data, nil
+}
@@ -0,0 +1,40 @@
+package bom_test
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/nightconcept/almandine-go/internal/core/bom"
+)
+
+func TestStrip(t *testing.T) {
+	tests := []struct {
+		name     string
+		data     []byte
+		want     string
+		encoding string // non-empty when an error naming this encoding is expected
+	}{
+		{name: "plain UTF-8", data: []byte("a = 1\n"), want: "a = 1\n"},
+		{name: "UTF-8 BOM", data: append([]byte{0xEF, 0xBB, 0xBF}, "a = 1\n"...), want: "a = 1\n"},
+		{name: "empty", data: nil, want: ""},
+		{name: "UTF-16 LE", data: []byte{0xFF, 0xFE, 'a', 0x00}, encoding: "UTF-16 (little-endian)"},
+		{name: "UTF-16 BE", data: []byte{0xFE, 0xFF, 0x00, 'a'}, encoding: "UTF-16 (big-endian)"},
+		{name: "UTF-32 LE", data: []byte{0xFF, 0xFE, 0x00, 0x00, 'a', 0x00, 0x00, 0x00}, encoding: "UTF-32 (little-endian)"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := bom.Strip("project.toml", tt.data)
+			if tt.encoding != "" {
+				require.Error(t, err)
+				assert.True(t, errors.Is(err, bom.ErrUnsupportedEncoding))
+				assert.Contains(t, err.Error(), "project.toml is saved as "+tt.encoding+"; save it as UTF-8")
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tt.want, string(got))
+		})
+	}
+}
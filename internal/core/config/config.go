@@ -2,16 +2,28 @@ package config
 
 import (
 	"bytes"
+	"errors"
+	"fmt"
 	"os"
 	"path/filepath"
 
 	"github.com/BurntSushi/toml"
+	"github.com/nightconcept/almandine-go/internal/core/bom"
+	"github.com/nightconcept/almandine-go/internal/core/fsutil"
 	"github.com/nightconcept/almandine-go/internal/core/project" // Corrected module path
 )
 
 const ProjectTomlName = "project.toml"
 const LockfileName = "almd-lock.toml"
 
+// ManifestVersion is the newest project.toml schema version this almd understands.
+// It plays the same role for the manifest as lockfile.APIVersion does for the lockfile.
+const ManifestVersion = 1
+
+// ErrManifestTooNew is returned (wrapped) by LoadProjectToml when project.toml declares a
+// [package] manifest_version newer than ManifestVersion.
+var ErrManifestTooNew = errors.New("project.toml requires a newer almd")
+
 // LoadProjectToml reads the project.toml file from the given dirPath and unmarshals it.
 func LoadProjectToml(dirPath string) (*project.Project, error) {
 	fullPath := filepath.Join(dirPath, ProjectTomlName)
@@ -19,32 +31,51 @@ func LoadProjectToml(dirPath string) (*project.Project, error) {
 	if err != nil {
 		return nil, err
 	}
+	// Windows editors may save a byte order mark, which the TOML decoder cannot parse.
+	if data, err = bom.Strip(ProjectTomlName, data); err != nil {
+		return nil, err
+	}
 
 	var proj project.Project
 	if err := toml.Unmarshal(data, &proj); err != nil {
 		return nil, err
 	}
+	if proj.Package != nil && proj.Package.ManifestVersion > ManifestVersion {
+		return nil, fmt.Errorf("%w (manifest_version %d; this almd supports up to %d)", ErrManifestTooNew, proj.Package.ManifestVersion, ManifestVersion)
+	}
+	// almd-lock.toml keys entries by name, so a name may only be declared once.
+	for name := range proj.DevDependencies {
+		if _, ok := proj.Dependencies[name]; ok {
+			return nil, fmt.Errorf("dependency '%s' is declared in both [dependencies] and [dev-dependencies]", name)
+		}
+	}
 	return &proj, nil
 }
 
 // WriteProjectToml marshals the Project data and writes it to the specified dirPath.
-// It will overwrite the file if it already exists.
+// An existing file is edited in place so its comments and layout survive; if that is not
+// possible it is overwritten.
 func WriteProjectToml(dirPath string, data *project.Project) error {
 	buf := new(bytes.Buffer)
 	if err := toml.NewEncoder(buf).Encode(data); err != nil {
 		return err
 	}
+	content := buf.Bytes()
 
-	// Write the TOML content to the file, overwriting if it exists.
-	// Create the file if it doesn't exist, with default permissions (0666 before umask).
-	// O_TRUNC ensures that if the file exists, its content is truncated.
 	fullPath := filepath.Join(dirPath, ProjectTomlName)
-	file, err := os.OpenFile(fullPath, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0644)
-	if err != nil {
-		return err
+	if existing, err := os.ReadFile(fullPath); err == nil {
+		if existing, err = bom.Strip(ProjectTomlName, existing); err == nil {
+			if merged, ok := mergeIntoDocument(existing, content); ok {
+				content = merged
+			}
+		}
 	}
-	defer func() { _ = file.Close() }()
 
-	_, err = file.Write(buf.Bytes())
-	return err
+	// The file is replaced atomically, so an interrupted write leaves the previous manifest
+	// intact. An existing file keeps its permissions.
+	perm := os.FileMode(0644)
+	if fi, err := os.Stat(fullPath); err == nil {
+		perm = fi.Mode().Perm()
+	}
+	return fsutil.WriteFileAtomic(fullPath, content, perm)
 }
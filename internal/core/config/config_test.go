@@ -1,6 +1,8 @@
 package config
 
 import (
+	"errors"
+	"fmt"
 	"os"
 	"path/filepath"
 	"testing"
@@ -8,6 +10,7 @@ import (
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 
+	"github.com/nightconcept/almandine-go/internal/core/bom"
 	"github.com/nightconcept/almandine-go/internal/core/project"
 )
 
@@ -68,6 +71,113 @@ version = "0.1.0"
 	// but we expect an error.
 }
 
+func TestLoadProjectToml_ManifestVersion(t *testing.T) {
+	writeManifest := func(t *testing.T, versionLine string) string {
+		t.Helper()
+		tempDir := t.TempDir()
+		content := fmt.Sprintf("[package]\nname = \"versioned\"\nversion = \"0.1.0\"\n%s\n", versionLine)
+		require.NoError(t, os.WriteFile(filepath.Join(tempDir, ProjectTomlName), []byte(content), 0644))
+		return tempDir
+	}
+
+	t.Run("recognized version", func(t *testing.T) {
+		proj, err := LoadProjectToml(writeManifest(t, fmt.Sprintf("manifest_version = %d", ManifestVersion)))
+		require.NoError(t, err)
+		assert.Equal(t, ManifestVersion, proj.Package.ManifestVersion)
+	})
+
+	t.Run("future version rejected", func(t *testing.T) {
+		_, err := LoadProjectToml(writeManifest(t, fmt.Sprintf("manifest_version = %d", ManifestVersion+1)))
+		require.Error(t, err)
+		assert.True(t, errors.Is(err, ErrManifestTooNew))
+		assert.Contains(t, err.Error(), "project.toml requires a newer almd")
+		assert.False(t, os.IsNotExist(err))
+	})
+
+	t.Run("absent version accepted", func(t *testing.T) {
+		proj, err := LoadProjectToml(writeManifest(t, ""))
+		require.NoError(t, err)
+		assert.Zero(t, proj.Package.ManifestVersion)
+	})
+}
+
+func TestLoadProjectToml_WithoutPackageTable(t *testing.T) {
+	tempDir := t.TempDir()
+	content := `
+[dependencies.json]
+source = "github:rxi/json.lua/json.lua@master"
+path = "libs/json.lua"
+`
+	require.NoError(t, os.WriteFile(filepath.Join(tempDir, ProjectTomlName), []byte(content), 0644))
+
+	// [package] is optional: the manifest loads and its dependencies are usable.
+	proj, err := LoadProjectToml(tempDir)
+	require.NoError(t, err)
+	assert.Nil(t, proj.Package)
+	assert.Equal(t, "", proj.PackageName())
+	assert.Equal(t, "", proj.PackageVersion())
+	assert.Equal(t, "libs/json.lua", proj.Dependencies["json"].Path)
+
+	// Writing it back must not invent an empty [package] table.
+	require.NoError(t, WriteProjectToml(tempDir, proj))
+	written, err := os.ReadFile(filepath.Join(tempDir, ProjectTomlName))
+	require.NoError(t, err)
+	assert.NotContains(t, string(written), "[package]")
+}
+
+func TestLoadProjectToml_DevDependencies(t *testing.T) {
+	tempDir := t.TempDir()
+	content := `
+[dependencies.json]
+source = "github:rxi/json.lua/json.lua@master"
+path = "libs/json.lua"
+
+[dev-dependencies.luaunit]
+source = "github:bluebird75/luaunit/luaunit.lua@master"
+path = "libs/luaunit.lua"
+`
+	require.NoError(t, os.WriteFile(filepath.Join(tempDir, ProjectTomlName), []byte(content), 0644))
+
+	proj, err := LoadProjectToml(tempDir)
+	require.NoError(t, err)
+	assert.Equal(t, "libs/luaunit.lua", proj.DevDependencies["luaunit"].Path)
+	assert.NotContains(t, proj.Dependencies, "luaunit")
+
+	duplicate := content + `
+[dev-dependencies.json]
+source = "github:rxi/json.lua/json.lua@master"
+path = "libs/json-dev.lua"
+`
+	require.NoError(t, os.WriteFile(filepath.Join(tempDir, ProjectTomlName), []byte(duplicate), 0644))
+	_, err = LoadProjectToml(tempDir)
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "'json' is declared in both [dependencies] and [dev-dependencies]")
+}
+
+func TestLoadProjectToml_ByteOrderMark(t *testing.T) {
+	t.Run("UTF-8 BOM is ignored", func(t *testing.T) {
+		tempDir := t.TempDir()
+		content := append([]byte{0xEF, 0xBB, 0xBF}, "[package]\nname = \"bom-project\"\nversion = \"0.1.0\"\n"...)
+		require.NoError(t, os.WriteFile(filepath.Join(tempDir, ProjectTomlName), content, 0644))
+
+		proj, err := LoadProjectToml(tempDir)
+		require.NoError(t, err)
+		assert.Equal(t, "bom-project", proj.PackageName())
+	})
+
+	t.Run("UTF-16 is rejected with a clear error", func(t *testing.T) {
+		tempDir := t.TempDir()
+		content := []byte{0xFF, 0xFE, '[', 0x00, 'p', 0x00}
+		require.NoError(t, os.WriteFile(filepath.Join(tempDir, ProjectTomlName), content, 0644))
+
+		_, err := LoadProjectToml(tempDir)
+		require.Error(t, err)
+		assert.True(t, errors.Is(err, bom.ErrUnsupportedEncoding))
+		assert.Contains(t, err.Error(), "project.toml is saved as UTF-16 (little-endian); save it as UTF-8 (without BOM)")
+		assert.False(t, os.IsNotExist(err))
+	})
+}
+
 func TestWriteProjectToml_NewFile(t *testing.T) {
 	tempDir := t.TempDir()
 	projData := &project.Project{
@@ -133,3 +243,107 @@ version = "0.0.1"
 	assert.Nil(t, loadedProj.Scripts)      // Ensure old fields are gone
 	assert.Nil(t, loadedProj.Dependencies) // Ensure old fields are gone
 }
+
+func TestProjectToml_DefaultDependencyDirRoundTrip(t *testing.T) {
+	tempDir := t.TempDir()
+	initialTomlContent := `
+[package]
+name = "deps-dir-project"
+version = "0.1.0"
+
+[almd]
+default_dependency_dir = "deps/"
+`
+	require.NoError(t, os.WriteFile(filepath.Join(tempDir, ProjectTomlName), []byte(initialTomlContent), 0644))
+
+	loadedProj, err := LoadProjectToml(tempDir)
+	require.NoError(t, err)
+	assert.Equal(t, "deps/", loadedProj.DefaultDependencyDir())
+
+	require.NoError(t, WriteProjectToml(tempDir, loadedProj))
+	written, err := os.ReadFile(filepath.Join(tempDir, ProjectTomlName))
+	require.NoError(t, err)
+	assert.Contains(t, string(written), `default_dependency_dir = "deps/"`)
+	reloaded, err := LoadProjectToml(tempDir)
+	require.NoError(t, err)
+	assert.Equal(t, "deps/", reloaded.DefaultDependencyDir())
+
+	// Without the setting nothing is written, and the accessor reports it as unset.
+	reloaded.Almd = nil
+	require.NoError(t, WriteProjectToml(tempDir, reloaded))
+	written, err = os.ReadFile(filepath.Join(tempDir, ProjectTomlName))
+	require.NoError(t, err)
+	assert.NotContains(t, string(written), "default_dependency_dir")
+	reloaded, err = LoadProjectToml(tempDir)
+	require.NoError(t, err)
+	assert.Empty(t, reloaded.DefaultDependencyDir())
+}
+
+func TestWriteProjectToml_PreservesComments(t *testing.T) {
+	tempDir := t.TempDir()
+	initialTomlContent := `# My project manifest
+[package]
+name = "commented" # the published name
+version = "0.1.0"
+
+# Handy scripts
+[scripts]
+# Run the game
+run = "love ."
+test = "busted" # needs busted installed
+
+[dependencies]
+# JSON support
+json = { source = "github:rxi/json.lua/json.lua@abc1234", path = "src/lib/json.lua" }
+old = { source = "github:owner/old/old.lua@main", path = "src/lib/old.lua" }
+`
+	projectFilePath := filepath.Join(tempDir, ProjectTomlName)
+	require.NoError(t, os.WriteFile(projectFilePath, []byte(initialTomlContent), 0644))
+
+	proj, err := LoadProjectToml(tempDir)
+	require.NoError(t, err)
+	proj.Package.Version = "0.2.0"
+	delete(proj.Dependencies, "old")
+	proj.Dependencies["lume"] = project.Dependency{Source: "github:rxi/lume/lume.lua@def5678", Path: "src/lib/lume.lua"}
+	require.NoError(t, WriteProjectToml(tempDir, proj))
+
+	written, err := os.ReadFile(projectFilePath)
+	require.NoError(t, err)
+	assert.Equal(t, `# My project manifest
+[package]
+name = "commented" # the published name
+version = "0.2.0"
+
+# Handy scripts
+[scripts]
+# Run the game
+run = "love ."
+test = "busted" # needs busted installed
+
+[dependencies]
+# JSON support
+json = { source = "github:rxi/json.lua/json.lua@abc1234", path = "src/lib/json.lua" }
+lume = { source = "github:rxi/lume/lume.lua@def5678", path = "src/lib/lume.lua" }
+`, string(written))
+}
+
+func TestWriteProjectToml_PreservesCommentsWithSubtables(t *testing.T) {
+	tempDir := t.TempDir()
+	initialTomlContent := "[package]\r\nname = \"subtables\"\r\nversion = \"0.1.0\"\r\n\r\n[dependencies.json]\r\n# pinned on purpose\r\nsource = \"github:rxi/json.lua/json.lua@abc1234\"\r\npath = \"src/lib/json.lua\"\r\n\r\n# Tool settings\r\n[almd]\r\nmax_commit_jump = 50\r\n"
+	projectFilePath := filepath.Join(tempDir, ProjectTomlName)
+	require.NoError(t, os.WriteFile(projectFilePath, []byte(initialTomlContent), 0644))
+
+	proj, err := LoadProjectToml(tempDir)
+	require.NoError(t, err)
+	proj.Dependencies["lume"] = project.Dependency{Source: "github:rxi/lume/lume.lua@def5678", Path: "src/lib/lume.lua"}
+	proj.Scripts = map[string]string{"run": "lua src/main.lua"}
+	require.NoError(t, WriteProjectToml(tempDir, proj))
+
+	written, err := os.ReadFile(projectFilePath)
+	require.NoError(t, err)
+	assert.Equal(t, "[package]\r\nname = \"subtables\"\r\nversion = \"0.1.0\"\r\n\r\n[dependencies.json]\r\n# pinned on purpose\r\nsource = \"github:rxi/json.lua/json.lua@abc1234\"\r\npath = \"src/lib/json.lua\"\r\n\r\n[dependencies.lume]\r\nsource = \"github:rxi/lume/lume.lua@def5678\"\r\npath = \"src/lib/lume.lua\"\r\n\r\n# Tool settings\r\n[almd]\r\nmax_commit_jump = 50\r\n\r\n[scripts]\r\nrun = \"lua src/main.lua\"\r\n", string(written))
+
+	reloaded, err := LoadProjectToml(tempDir)
+	require.NoError(t, err)
+	assert.Equal(t, proj, reloaded)
+}
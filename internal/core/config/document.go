@@ -0,0 +1,521 @@
+package config
+
+import (
+	"bytes"
+	"reflect"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/BurntSushi/toml"
+)
+
+var bareKeyPattern = regexp.MustCompile(`^[A-Za-z0-9_-]+$`)
+
+// docItem is one statement or one trivia line (blank line or comment) of a table block.
+type docItem struct {
+	path  []string // full key path of a statement; nil for trivia
+	key   string   // the key as written, for rewriting a statement in place
+	lines []string
+}
+
+// docBlock is a table header and everything up to the next header. The block before the
+// first header has a nil header.
+type docBlock struct {
+	header     []string
+	headerLine string
+	items      []docItem
+
+	keep     bool
+	out      []string
+	insertAt int    // index in out just after the last statement (or the header)
+	indent   string // indentation for statements added to this block
+	added    []string
+	appended []string // new table blocks placed after this block's statements
+}
+
+type documentEditor struct {
+	oldData, newData map[string]any
+	order            map[string]int
+	eol              string
+	blocks           []*docBlock
+	emitted          map[string]bool
+	tail             []string
+}
+
+func joinPath(path []string) string { return strings.Join(path, "\x00") }
+
+func childPath(path []string, key string) []string {
+	return append(append([]string(nil), path...), key)
+}
+
+func hasPrefix(path, prefix []string) bool {
+	if len(path) < len(prefix) {
+		return false
+	}
+	for i := range prefix {
+		if path[i] != prefix[i] {
+			return false
+		}
+	}
+	return true
+}
+
+func lookupPath(data map[string]any, path []string) (any, bool) {
+	var v any = data
+	for _, key := range path {
+		m, ok := v.(map[string]any)
+		if !ok {
+			return nil, false
+		}
+		if v, ok = m[key]; !ok {
+			return nil, false
+		}
+	}
+	return v, true
+}
+
+// mergeIntoDocument returns old, an existing project.toml, edited to hold the data in encoded,
+// the encoder output for the project being written. The edit works on whole statements: a key
+// whose value did not change keeps its original lines and comments, a changed value is
+// rewritten in place, removed keys and tables are dropped, and new keys are appended to the
+// table they belong to. The result must decode to the same data as encoded; ok is false if it
+// does not, or if old uses syntax this editor does not handle (arrays of tables).
+func mergeIntoDocument(old, encoded []byte) (merged []byte, ok bool) {
+	e := &documentEditor{eol: "\n", order: make(map[string]int), emitted: make(map[string]bool)}
+	if _, err := toml.Decode(string(old), &e.oldData); err != nil {
+		return nil, false
+	}
+	md, err := toml.Decode(string(encoded), &e.newData)
+	if err != nil {
+		return nil, false
+	}
+	for i, key := range md.Keys() {
+		if _, seen := e.order[joinPath(key)]; !seen {
+			e.order[joinPath(key)] = i
+		}
+	}
+
+	text := string(old)
+	if strings.Contains(text, "\r\n") {
+		e.eol = "\r\n"
+		text = strings.ReplaceAll(text, "\r\n", "\n")
+	}
+	if !e.parse(strings.Split(strings.TrimSuffix(text, "\n"), "\n")) {
+		return nil, false
+	}
+	e.editExisting()
+	if !e.addMissing(nil, e.newData) {
+		return nil, false
+	}
+
+	var lines []string
+	for _, b := range e.blocks {
+		if !b.keep {
+			continue
+		}
+		lines = append(lines, b.out[:b.insertAt]...)
+		lines = append(lines, b.added...)
+		lines = append(lines, b.appended...)
+		lines = append(lines, b.out[b.insertAt:]...)
+	}
+	lines = append(lines, e.tail...)
+	// Leading blank lines can only come from new tables added to an empty document.
+	for len(lines) > 0 && strings.TrimSpace(lines[0]) == "" {
+		lines = lines[1:]
+	}
+	result := []byte(strings.Join(lines, e.eol) + e.eol)
+
+	var check map[string]any
+	if _, err := toml.Decode(string(result), &check); err != nil || !reflect.DeepEqual(check, e.newData) {
+		return nil, false
+	}
+	return result, true
+}
+
+// parse splits lines into table blocks and statements.
+func (e *documentEditor) parse(lines []string) bool {
+	current := &docBlock{}
+	e.blocks = append(e.blocks, current)
+	for i := 0; i < len(lines); i++ {
+		line := lines[i]
+		trimmed := strings.TrimSpace(line)
+		switch {
+		case trimmed == "" || strings.HasPrefix(trimmed, "#"):
+			current.items = append(current.items, docItem{lines: []string{line}})
+		case strings.HasPrefix(trimmed, "[["):
+			return false
+		case strings.HasPrefix(trimmed, "["):
+			path, rest, ok := parseKeyPath(trimmed[1:])
+			if !ok || !strings.HasPrefix(rest, "]") {
+				return false
+			}
+			current = &docBlock{header: path, headerLine: line}
+			e.blocks = append(e.blocks, current)
+		default:
+			path, rest, ok := parseKeyPath(trimmed)
+			if !ok || !strings.HasPrefix(rest, "=") {
+				return false
+			}
+			end, ok := statementEnd(lines, i)
+			if !ok {
+				return false
+			}
+			key := strings.TrimSpace(strings.TrimSuffix(trimmed, rest))
+			fullPath := append(append([]string(nil), current.header...), path...)
+			current.items = append(current.items, docItem{path: fullPath, key: key, lines: lines[i : end+1]})
+			i = end
+		}
+	}
+	return true
+}
+
+// parseKeyPath reads a possibly dotted, possibly quoted key from the start of s and returns
+// its parts and the text after it.
+func parseKeyPath(s string) (path []string, rest string, ok bool) {
+	for {
+		s = strings.TrimLeft(s, " \t")
+		switch {
+		case strings.HasPrefix(s, `"`):
+			end := 1
+			for end < len(s) && s[end] != '"' {
+				if s[end] == '\\' {
+					end++
+				}
+				end++
+			}
+			if end >= len(s) {
+				return nil, "", false
+			}
+			part, err := strconv.Unquote(s[:end+1])
+			if err != nil {
+				return nil, "", false
+			}
+			path, s = append(path, part), s[end+1:]
+		case strings.HasPrefix(s, "'"):
+			end := strings.IndexByte(s[1:], '\'')
+			if end < 0 {
+				return nil, "", false
+			}
+			path, s = append(path, s[1:end+1]), s[end+2:]
+		default:
+			end := 0
+			for end < len(s) && bareKeyPattern.MatchString(s[end:end+1]) {
+				end++
+			}
+			if end == 0 {
+				return nil, "", false
+			}
+			path, s = append(path, s[:end]), s[end:]
+		}
+		s = strings.TrimLeft(s, " \t")
+		if !strings.HasPrefix(s, ".") {
+			return path, s, true
+		}
+		s = s[1:]
+	}
+}
+
+// statementEnd returns the index of the last line of the key/value statement that starts at
+// lines[start], following multi-line strings, arrays and inline tables.
+func statementEnd(lines []string, start int) (int, bool) {
+	depth := 0
+	multiline := ""
+	for i := start; i < len(lines); i++ {
+		line := lines[i]
+	scan:
+		for j := 0; j < len(line); j++ {
+			if multiline != "" {
+				if multiline == `"""` && line[j] == '\\' {
+					j++
+				} else if strings.HasPrefix(line[j:], multiline) {
+					j += len(multiline) - 1
+					multiline = ""
+				}
+				continue
+			}
+			switch c := line[j]; {
+			case c == '#':
+				break scan
+			case strings.HasPrefix(line[j:], `"""`), strings.HasPrefix(line[j:], "'''"):
+				multiline = line[j : j+3]
+				j += 2
+			case c == '"':
+				for j++; j < len(line) && line[j] != '"'; j++ {
+					if line[j] == '\\' {
+						j++
+					}
+				}
+				if j >= len(line) {
+					return 0, false
+				}
+			case c == '\'':
+				end := strings.IndexByte(line[j+1:], '\'')
+				if end < 0 {
+					return 0, false
+				}
+				j += end + 1
+			case c == '[' || c == '{':
+				depth++
+			case c == ']' || c == '}':
+				depth--
+			}
+		}
+		if multiline == "" && depth <= 0 {
+			return i, true
+		}
+	}
+	return 0, false
+}
+
+func leadingSpace(line string) string {
+	return line[:len(line)-len(strings.TrimLeft(line, " \t"))]
+}
+
+// editExisting keeps, rewrites or drops every existing block and statement.
+func (e *documentEditor) editExisting() {
+	for _, b := range e.blocks {
+		if b.header != nil {
+			v, ok := lookupPath(e.newData, b.header)
+			if _, isTable := v.(map[string]any); !ok || !isTable {
+				continue
+			}
+			b.out = append(b.out, b.headerLine)
+			b.indent = leadingSpace(b.headerLine)
+		}
+		b.keep = true
+		b.insertAt = len(b.out)
+		for _, item := range b.items {
+			if item.path == nil {
+				b.out = append(b.out, item.lines...)
+				continue
+			}
+			newValue, ok := lookupPath(e.newData, item.path)
+			if !ok {
+				continue
+			}
+			e.emitted[joinPath(item.path)] = true
+			b.indent = leadingSpace(item.lines[0])
+			oldValue, _ := lookupPath(e.oldData, item.path)
+			if reflect.DeepEqual(oldValue, newValue) {
+				b.out = append(b.out, item.lines...)
+			} else if formatted, ok := e.formatValue(item.path, newValue); ok {
+				b.out = append(b.out, b.indent+item.key+" = "+formatted)
+			}
+			b.insertAt = len(b.out)
+		}
+	}
+}
+
+// keptHeader returns the kept block whose header is exactly path.
+func (e *documentEditor) keptHeader(path []string) *docBlock {
+	for _, b := range e.blocks {
+		if b.keep && b.header != nil && len(b.header) == len(path) && hasPrefix(b.header, path) {
+			return b
+		}
+	}
+	return nil
+}
+
+// lastKeptUnder returns the last kept block whose header lies strictly below path.
+func (e *documentEditor) lastKeptUnder(path []string) *docBlock {
+	var last *docBlock
+	for _, b := range e.blocks {
+		if b.keep && b.header != nil && len(b.header) > len(path) && hasPrefix(b.header, path) {
+			last = b
+		}
+	}
+	return last
+}
+
+// defined reports whether the document already spells out part of the table at path, through
+// its header, a header below it, or a dotted key below it.
+func (e *documentEditor) defined(path []string) bool {
+	if e.keptHeader(path) != nil || e.lastKeptUnder(path) != nil {
+		return true
+	}
+	for _, b := range e.blocks {
+		for _, item := range b.items {
+			if item.path != nil && e.emitted[joinPath(item.path)] && len(item.path) > len(path) && hasPrefix(item.path, path) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func (e *documentEditor) orderedKeys(path []string, m map[string]any) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	rank := func(k string) int {
+		if i, ok := e.order[joinPath(childPath(path, k))]; ok {
+			return i
+		}
+		return len(e.order)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		if rank(keys[i]) != rank(keys[j]) {
+			return rank(keys[i]) < rank(keys[j])
+		}
+		return keys[i] < keys[j]
+	})
+	return keys
+}
+
+// addMissing appends every key of the table at path that the edited document does not hold yet.
+func (e *documentEditor) addMissing(path []string, table map[string]any) bool {
+	var missing []string
+	for _, key := range e.orderedKeys(path, table) {
+		keyPath := childPath(path, key)
+		if e.emitted[joinPath(keyPath)] {
+			continue
+		}
+		if sub, isTable := table[key].(map[string]any); isTable && e.defined(keyPath) {
+			if !e.addMissing(keyPath, sub) {
+				return false
+			}
+			continue
+		}
+		missing = append(missing, key)
+	}
+	if len(missing) == 0 {
+		return true
+	}
+
+	var block *docBlock
+	if path == nil {
+		block = e.blocks[0]
+	} else {
+		block = e.keptHeader(path)
+	}
+	if block == nil {
+		// The table only exists through its subtables so far. New subtables join them; plain
+		// values need a header for the table itself. A table spelled with dotted keys is left
+		// to the caller.
+		after := e.lastKeptUnder(path)
+		if after == nil {
+			return false
+		}
+		var values []string
+		for _, key := range missing {
+			keyPath := childPath(path, key)
+			sub, isTable := table[key].(map[string]any)
+			if !isTable {
+				values = append(values, key)
+				continue
+			}
+			lines, ok := e.formatTable(keyPath, sub, e.orderedKeys(keyPath, sub), leadingSpace(after.headerLine), after.indent)
+			if !ok {
+				return false
+			}
+			after.appended = append(after.appended, append([]string{""}, lines...)...)
+		}
+		if len(values) > 0 {
+			lines, ok := e.formatTable(path, table, values, "", "")
+			if !ok {
+				return false
+			}
+			after.appended = append(after.appended, append([]string{""}, lines...)...)
+		}
+		return true
+	}
+
+	// New subtables follow the style already used for their siblings; the top level always
+	// gets table headers.
+	sibling := e.lastKeptUnder(path)
+	for _, key := range missing {
+		keyPath := childPath(path, key)
+		sub, isTable := table[key].(map[string]any)
+		if !isTable || (path != nil && sibling == nil) {
+			formatted, ok := e.formatValue(keyPath, table[key])
+			if !ok {
+				return false
+			}
+			block.added = append(block.added, block.indent+formatKey(key)+" = "+formatted)
+			continue
+		}
+		headerIndent, keyIndent := "", ""
+		if sibling != nil && path != nil {
+			headerIndent, keyIndent = leadingSpace(sibling.headerLine), sibling.indent
+		}
+		lines, ok := e.formatTable(keyPath, sub, e.orderedKeys(keyPath, sub), headerIndent, keyIndent)
+		if !ok {
+			return false
+		}
+		lines = append([]string{""}, lines...)
+		if path == nil {
+			e.tail = append(e.tail, lines...)
+		} else {
+			sibling.appended = append(sibling.appended, lines...)
+		}
+	}
+	return true
+}
+
+// formatTable renders the given keys of table as a [path] block, nested tables as subtables.
+func (e *documentEditor) formatTable(path []string, table map[string]any, keys []string, headerIndent, keyIndent string) ([]string, bool) {
+	header := make([]string, len(path))
+	for i, part := range path {
+		header[i] = formatKey(part)
+	}
+	lines := []string{headerIndent + "[" + strings.Join(header, ".") + "]"}
+	var subtables []string
+	for _, key := range keys {
+		if _, isTable := table[key].(map[string]any); isTable {
+			subtables = append(subtables, key)
+			continue
+		}
+		formatted, ok := e.formatValue(childPath(path, key), table[key])
+		if !ok {
+			return nil, false
+		}
+		lines = append(lines, keyIndent+formatKey(key)+" = "+formatted)
+	}
+	for _, key := range subtables {
+		keyPath := childPath(path, key)
+		sub := table[key].(map[string]any)
+		subLines, ok := e.formatTable(keyPath, sub, e.orderedKeys(keyPath, sub), headerIndent, keyIndent)
+		if !ok {
+			return nil, false
+		}
+		lines = append(append(lines, ""), subLines...)
+	}
+	return lines, true
+}
+
+// formatValue renders v as it appears after "key = ", with tables written inline.
+func (e *documentEditor) formatValue(path []string, v any) (string, bool) {
+	if table, isTable := v.(map[string]any); isTable {
+		if len(table) == 0 {
+			return "{}", true
+		}
+		parts := make([]string, 0, len(table))
+		for _, key := range e.orderedKeys(path, table) {
+			formatted, ok := e.formatValue(childPath(path, key), table[key])
+			if !ok {
+				return "", false
+			}
+			parts = append(parts, formatKey(key)+" = "+formatted)
+		}
+		return "{ " + strings.Join(parts, ", ") + " }", true
+	}
+	buf := new(bytes.Buffer)
+	if err := toml.NewEncoder(buf).Encode(map[string]any{"v": v}); err != nil {
+		return "", false
+	}
+	formatted, ok := strings.CutPrefix(strings.TrimRight(buf.String(), "\n"), "v = ")
+	if !ok || strings.Contains(formatted, "\n") {
+		return "", false
+	}
+	return formatted, true
+}
+
+func formatKey(key string) string {
+	if bareKeyPattern.MatchString(key) {
+		return key
+	}
+	return strconv.Quote(key)
+}
@@ -0,0 +1,150 @@
+// Package contentcache implements a content-addressable store of dependency files shared by
+// every project on the machine. Blobs are keyed by their sha256 hash, and immutable sources
+// (files pinned to a commit, see CommitKey) are indexed to the hash of their content, so a
+// file vendored by many projects or installed many times is downloaded and stored once.
+package contentcache
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/nightconcept/almandine-go/internal/core/fsutil"
+	"github.com/nightconcept/almandine-go/internal/core/hasher"
+)
+
+// DirEnv names the environment variable that overrides the cache location.
+const DirEnv = "ALMD_CACHE_DIR"
+
+// Store is a content cache rooted at Dir.
+type Store struct {
+	Dir string
+}
+
+// DefaultDir returns $ALMD_CACHE_DIR, or the content cache under the user cache directory.
+func DefaultDir() (string, error) {
+	if dir := os.Getenv(DirEnv); dir != "" {
+		return dir, nil
+	}
+	base, err := os.UserCacheDir()
+	if err != nil {
+		return "", fmt.Errorf("failed to determine user cache directory: %w", err)
+	}
+	return filepath.Join(base, "almd", "content"), nil
+}
+
+// blobPath returns where the blob for hash ("sha256:<hex>") is stored.
+func (s Store) blobPath(hash string) (string, error) {
+	hexHash, ok := strings.CutPrefix(hash, "sha256:")
+	if !ok || len(hexHash) < 2 {
+		return "", fmt.Errorf("unsupported content hash '%s'", hash)
+	}
+	return filepath.Join(s.Dir, "sha256", hexHash[:2], hexHash), nil
+}
+
+// CommitKey returns the index key for pathInRepo at commit sha of owner/repo on provider.
+func CommitKey(provider, owner, repo, sha, pathInRepo string) string {
+	return strings.Join([]string{provider, owner, repo, sha, pathInRepo}, "/")
+}
+
+// indexPath returns the index file recording which content hash key refers to.
+func (s Store) indexPath(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return filepath.Join(s.Dir, "index", hex.EncodeToString(sum[:]))
+}
+
+// Put stores content and returns its hash and blob path. Storing content that is already
+// cached is a no-op. Blobs are read-only, since projects may hold hardlinks to them.
+func (s Store) Put(content []byte) (hash, path string, err error) {
+	hash, err = hasher.CalculateSHA256(content)
+	if err != nil {
+		return "", "", err
+	}
+	path, err = s.blobPath(hash)
+	if err != nil {
+		return "", "", err
+	}
+	if _, err := s.Load(hash); err == nil {
+		return hash, path, nil
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return "", "", fmt.Errorf("failed to create cache directory: %w", err)
+	}
+	_ = os.Remove(path) // Drop a corrupt blob, which may be read-only
+	if err := fsutil.WriteFileAtomic(path, content, 0444); err != nil {
+		return "", "", err
+	}
+	return hash, path, nil
+}
+
+// Load returns the path of the cached blob for hash after checking that its content still
+// hashes to hash. A blob that fails the check is treated as missing.
+func (s Store) Load(hash string) (string, error) {
+	path, err := s.blobPath(hash)
+	if err != nil {
+		return "", err
+	}
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return "", err
+	}
+	actual, err := hasher.CalculateSHA256(content)
+	if err != nil {
+		return "", err
+	}
+	if actual != hash {
+		return "", fmt.Errorf("cached blob %s is corrupt: content hashes to %s", path, actual)
+	}
+	return path, nil
+}
+
+// Record notes that key refers to the content with hash. Only call it for immutable keys.
+func (s Store) Record(key, hash string) error {
+	path := s.indexPath(key)
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return fmt.Errorf("failed to create cache directory: %w", err)
+	}
+	return fsutil.WriteFileAtomic(path, []byte(hash+"\n"), 0644)
+}
+
+// Lookup returns the content hash recorded for key.
+func (s Store) Lookup(key string) (string, bool) {
+	data, err := os.ReadFile(s.indexPath(key))
+	if err != nil {
+		return "", false
+	}
+	hash := strings.TrimSpace(string(data))
+	return hash, hash != ""
+}
+
+// Link places the blob at blobPath at target, replacing any existing file. It hardlinks when
+// the cache and target share a filesystem and copies otherwise; linked reports which happened.
+func Link(blobPath, target string) (linked bool, err error) {
+	// Link under a temporary name next to target, then rename it over target, so target is
+	// never missing or partially written.
+	tmp, err := os.CreateTemp(filepath.Dir(target), ".almd-*.tmp")
+	if err != nil {
+		return false, fmt.Errorf("failed to create temporary file for %s: %w", target, err)
+	}
+	tmpPath := tmp.Name()
+	_ = tmp.Close()
+	_ = os.Remove(tmpPath)
+
+	if err := os.Link(blobPath, tmpPath); err == nil {
+		if err := os.Rename(tmpPath, target); err != nil {
+			_ = os.Remove(tmpPath)
+			return false, fmt.Errorf("failed to move link into place at %s: %w", target, err)
+		}
+		return true, nil
+	}
+
+	// Hardlinks are unavailable (another filesystem, or unsupported); fall back to a copy.
+	content, err := os.ReadFile(blobPath)
+	if err != nil {
+		return false, err
+	}
+	return false, fsutil.WriteFileAtomic(target, content, 0644)
+}
@@ -0,0 +1,86 @@
+package contentcache_test
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/nightconcept/almandine-go/internal/core/contentcache"
+)
+
+func TestStore_PutAndLoad(t *testing.T) {
+	store := contentcache.Store{Dir: t.TempDir()}
+
+	hash, blobPath, err := store.Put([]byte("return {}"))
+	require.NoError(t, err)
+	assert.Contains(t, hash, "sha256:")
+
+	loaded, err := store.Load(hash)
+	require.NoError(t, err)
+	assert.Equal(t, blobPath, loaded)
+
+	again, againPath, err := store.Put([]byte("return {}"))
+	require.NoError(t, err)
+	assert.Equal(t, hash, again, "identical content is stored once")
+	assert.Equal(t, blobPath, againPath)
+}
+
+func TestStore_LoadRejectsCorruptBlob(t *testing.T) {
+	store := contentcache.Store{Dir: t.TempDir()}
+	hash, blobPath, err := store.Put([]byte("return 'original'"))
+	require.NoError(t, err)
+
+	require.NoError(t, os.Chmod(blobPath, 0644))
+	require.NoError(t, os.WriteFile(blobPath, []byte("return 'tampered'"), 0644))
+
+	_, err = store.Load(hash)
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "corrupt")
+
+	// Putting the real content again repairs the blob.
+	_, _, err = store.Put([]byte("return 'original'"))
+	require.NoError(t, err)
+	_, err = store.Load(hash)
+	assert.NoError(t, err)
+}
+
+func TestStore_Index(t *testing.T) {
+	store := contentcache.Store{Dir: t.TempDir()}
+	key := contentcache.CommitKey("github", "owner", "repo", "0123456", "src/lib.lua")
+	assert.Equal(t, "github/owner/repo/0123456/src/lib.lua", key)
+
+	_, ok := store.Lookup(key)
+	assert.False(t, ok)
+
+	require.NoError(t, store.Record(key, "sha256:abcd"))
+	hash, ok := store.Lookup(key)
+	assert.True(t, ok)
+	assert.Equal(t, "sha256:abcd", hash)
+}
+
+func TestLink_Hardlinks(t *testing.T) {
+	store := contentcache.Store{Dir: t.TempDir()}
+	_, blobPath, err := store.Put([]byte("return 'shared'"))
+	require.NoError(t, err)
+
+	target := filepath.Join(t.TempDir(), "lib.lua")
+	require.NoError(t, os.WriteFile(target, []byte("old"), 0644))
+
+	linked, err := contentcache.Link(blobPath, target)
+	require.NoError(t, err)
+	content, err := os.ReadFile(target)
+	require.NoError(t, err)
+	assert.Equal(t, "return 'shared'", string(content), "the existing file is replaced")
+	if !linked {
+		t.Skip("hardlinks are not available between temporary directories here")
+	}
+
+	blobInfo, err := os.Stat(blobPath)
+	require.NoError(t, err)
+	targetInfo, err := os.Stat(target)
+	require.NoError(t, err)
+	assert.True(t, os.SameFile(blobInfo, targetInfo))
+}
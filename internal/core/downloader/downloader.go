@@ -2,29 +2,408 @@
 package downloader
 
 import (
+	"errors"
 	"fmt"
 	"io"
+	"net"
 	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/nightconcept/almandine-go/internal/core/ghauth"
+	"github.com/nightconcept/almandine-go/internal/core/hasher"
+	"github.com/nightconcept/almandine-go/internal/core/httpclient"
 )
 
+// DefaultMaxSize is the largest response DownloadFile will buffer. Single-file dependencies
+// are small; anything beyond this is almost certainly a misconfigured or hostile source.
+const DefaultMaxSize int64 = 50 << 20 // 50 MiB
+
+// ErrTooLarge is returned when a response exceeds the configured maximum size.
+var ErrTooLarge = errors.New("file exceeds max size")
+
+// StatusError is returned when the server answers with a status other than 200 OK, so callers
+// can react to specific codes such as 404.
+type StatusError struct {
+	URL        string
+	StatusCode int
+}
+
+func (e *StatusError) Error() string {
+	return fmt.Sprintf("failed to download from %s: received status code %d", e.URL, e.StatusCode)
+}
+
+// ErrNotModified is returned by DownloadFileIfModified when the server answers 304 Not Modified:
+// the file still matches the validators that were sent.
+var ErrNotModified = errors.New("not modified")
+
+// Validators are the HTTP cache validators a server sent with a file. Sending them back on a
+// later request lets the server answer 304 Not Modified instead of sending the file again.
+type Validators struct {
+	ETag         string
+	LastModified string
+}
+
+// IsZero reports whether no validator is set.
+func (v Validators) IsZero() bool {
+	return v.ETag == "" && v.LastModified == ""
+}
+
+// MaxRetries is how many times a download is retried after a connection error, a 5xx response
+// or 429 Too Many Requests before giving up. Other failures, such as 404, are never retried.
+// Tests set it to 0 to fail on the first attempt.
+var MaxRetries = 3
+
+// RetryBackoff is the wait before the first retry of a connection error or 5xx response; each
+// further retry waits twice as long. A 429 response waits as long as its Retry-After asks.
+var RetryBackoff = 500 * time.Millisecond
+
+// MaxRetryAfter caps how long a single Retry-After header can make a download wait.
+const MaxRetryAfter = 30 * time.Second
+
+// defaultRetryAfter is the wait used when a 429 response carries no usable Retry-After header.
+const defaultRetryAfter = 2 * time.Second
+
+// ProgressFunc is called as a response body is read, with the bytes read so far and the total
+// from Content-Length, or -1 when the server did not send one. Once the body has been read in
+// full it is called a final time with total equal to read.
+type ProgressFunc func(read, total int64)
+
+// Client downloads files, reporting to the writers and hooks a command gives it. The zero Client
+// reports nothing; the package-level functions use one. A Client is never modified by its
+// methods, so one may serve concurrent downloads.
+type Client struct {
+	// Verbose receives progress notes such as rate-limit retries. Commands point it at stderr
+	// when --verbose is set; nil discards the notes.
+	Verbose io.Writer
+	// Progress, when set, is asked for a ProgressFunc at the start of every download that has
+	// none of its own; it may return nil to skip one. Commands point it at a terminal indicator
+	// when stdout is a TTY; nil reports nothing.
+	Progress func(url string) ProgressFunc
+}
+
 // DownloadFile fetches the content from the given URL.
 // It returns the content as a byte slice or an error if the download fails
-// or if the HTTP status code is not 200 OK.
+// or if the HTTP status code is not 200 OK. Responses larger than DefaultMaxSize are rejected.
 func DownloadFile(url string) ([]byte, error) {
-	resp, err := http.Get(url)
-	if err != nil {
-		return nil, fmt.Errorf("failed to perform GET request to %s: %w", url, err)
+	return (&Client{}).DownloadFile(url)
+}
+
+// DownloadFileWithLimit is DownloadFile with an explicit cap on the response size in bytes.
+// The body is read through a limited reader, so an oversized response is aborted without
+// being buffered in full. A maxSize of zero or less disables the cap.
+func DownloadFileWithLimit(url string, maxSize int64) ([]byte, error) {
+	return (&Client{}).DownloadFileWithLimit(url, maxSize)
+}
+
+// DownloadFileWithProgress is DownloadFileWithLimit reporting its progress to progress.
+func DownloadFileWithProgress(url string, maxSize int64, progress ProgressFunc) ([]byte, error) {
+	return (&Client{}).DownloadFileWithProgress(url, maxSize, progress)
+}
+
+// DownloadFileIfModified is DownloadFileWithLimit as a conditional request. The validators of an
+// earlier download are sent as If-None-Match and If-Modified-Since; if the server answers 304 Not
+// Modified, ErrNotModified is returned and nothing is downloaded. Otherwise the content is
+// returned with the validators of the new response, which may be empty.
+func DownloadFileIfModified(url string, maxSize int64, since Validators) ([]byte, Validators, error) {
+	return (&Client{}).DownloadFileIfModified(url, maxSize, since)
+}
+
+// DownloadFile is the package-level DownloadFile reporting through c.
+func (c *Client) DownloadFile(url string) ([]byte, error) {
+	return c.DownloadFileWithLimit(url, DefaultMaxSize)
+}
+
+// DownloadFileWithLimit is the package-level DownloadFileWithLimit reporting through c.
+func (c *Client) DownloadFileWithLimit(url string, maxSize int64) ([]byte, error) {
+	body, _, err := c.download(url, maxSize, Validators{}, nil)
+	return body, err
+}
+
+// DownloadFileWithProgress is the package-level DownloadFileWithProgress. Its progress is
+// reported to progress instead of c.Progress.
+func (c *Client) DownloadFileWithProgress(url string, maxSize int64, progress ProgressFunc) ([]byte, error) {
+	if progress == nil {
+		progress = func(int64, int64) {}
+	}
+	body, _, err := c.download(url, maxSize, Validators{}, progress)
+	return body, err
+}
+
+// DownloadFileIfModified is the package-level DownloadFileIfModified reporting through c.
+func (c *Client) DownloadFileIfModified(url string, maxSize int64, since Validators) ([]byte, Validators, error) {
+	return c.download(url, maxSize, since, nil)
+}
+
+// download implements the download methods. A nil progress falls back to c.Progress.
+func (c *Client) download(url string, maxSize int64, since Validators, progress ProgressFunc) ([]byte, Validators, error) {
+	var resp *http.Response
+	for attempt := 0; ; attempt++ {
+		var err error
+		resp, err = get(url, since)
+		delay, reason, retry := retryDelay(resp, err, attempt)
+		if !retry || attempt >= MaxRetries {
+			if err != nil {
+				return nil, Validators{}, err
+			}
+			break
+		}
+		if resp != nil {
+			_ = resp.Body.Close()
+		}
+		if c.Verbose != nil {
+			if resp != nil && resp.StatusCode == http.StatusTooManyRequests {
+				_, _ = fmt.Fprintf(c.Verbose, "  %s rate limited the download (429), retrying after %d seconds...\n", url, int(delay.Round(time.Second)/time.Second))
+			} else {
+				_, _ = fmt.Fprintf(c.Verbose, "  Download from %s failed (%s), retrying in %s...\n", url, reason, delay)
+			}
+		}
+		time.Sleep(delay)
 	}
 	defer func() { _ = resp.Body.Close() }()
 
+	if resp.StatusCode == http.StatusTooManyRequests {
+		return nil, Validators{}, fmt.Errorf("failed to download from %s: rate limited (status code 429 Too Many Requests) after %d retries", url, MaxRetries)
+	}
+	if resp.StatusCode == http.StatusNotModified && !since.IsZero() {
+		return nil, since, ErrNotModified
+	}
 	if resp.StatusCode != http.StatusOK {
-		return nil, fmt.Errorf("failed to download from %s: received status code %d", url, resp.StatusCode)
+		return nil, Validators{}, &StatusError{URL: url, StatusCode: resp.StatusCode}
+	}
+
+	validators := Validators{ETag: resp.Header.Get("ETag"), LastModified: resp.Header.Get("Last-Modified")}
+
+	if progress == nil && c.Progress != nil {
+		progress = c.Progress(url)
+	}
+	var reader io.Reader = resp.Body
+	if progress != nil {
+		counter := &progressReader{r: resp.Body, total: resp.ContentLength, report: progress}
+		defer counter.finish()
+		reader = counter
+	}
+
+	if maxSize <= 0 {
+		body, err := io.ReadAll(reader)
+		if err != nil {
+			return nil, Validators{}, fmt.Errorf("failed to read response body from %s: %w", url, err)
+		}
+		return body, validators, nil
 	}
 
-	body, err := io.ReadAll(resp.Body)
+	if resp.ContentLength > maxSize {
+		return nil, Validators{}, fmt.Errorf("%w of %d bytes: %s reports %d bytes", ErrTooLarge, maxSize, url, resp.ContentLength)
+	}
+	// Read one byte past the cap so that a body of exactly maxSize bytes is still accepted.
+	body, err := io.ReadAll(io.LimitReader(reader, maxSize+1))
 	if err != nil {
-		return nil, fmt.Errorf("failed to read response body from %s: %w", url, err)
+		return nil, Validators{}, fmt.Errorf("failed to read response body from %s: %w", url, err)
+	}
+	if int64(len(body)) > maxSize {
+		return nil, Validators{}, fmt.Errorf("%w of %d bytes: %s", ErrTooLarge, maxSize, url)
 	}
 
-	return body, nil
+	return body, validators, nil
+}
+
+// progressReader reports the bytes read through it to a ProgressFunc.
+type progressReader struct {
+	r      io.Reader
+	read   int64
+	total  int64
+	report ProgressFunc
+	done   bool
+}
+
+func (p *progressReader) Read(b []byte) (int, error) {
+	n, err := p.r.Read(b)
+	if n > 0 {
+		p.read += int64(n)
+		p.report(p.read, p.total)
+	}
+	if err == io.EOF {
+		p.done = true
+	}
+	return n, err
+}
+
+// finish makes the final report once the body was read to the end; a failed or aborted read
+// gets none.
+func (p *progressReader) finish() {
+	if p.done {
+		p.report(p.read, p.read)
+	}
+}
+
+// largeDownload is the size below which TerminalProgress draws nothing, so small files do not
+// flash an indicator.
+const largeDownload int64 = 1 << 20 // 1 MiB
+
+// progressStep is how many bytes TerminalProgress lets pass between redraws.
+const progressStep int64 = 64 << 10 // 64 KiB
+
+// TerminalProgress returns a ProgressFunc that draws a single self-updating line on w, such as
+// "Downloading big.lua: 1.5 MiB / 4.0 MiB (37%)", and clears it when the download completes.
+// Without a Content-Length only the byte count is shown. Downloads smaller than 1 MiB draw
+// nothing. w should be a terminal; the line is redrawn with carriage returns.
+func TerminalProgress(w io.Writer, label string) ProgressFunc {
+	drawn := false
+	var lastDrawn int64
+	return func(read, total int64) {
+		if read == total {
+			if drawn {
+				_, _ = fmt.Fprint(w, "\r\033[K")
+			}
+			return
+		}
+		if total < largeDownload && read < largeDownload {
+			return
+		}
+		if drawn && read-lastDrawn < progressStep {
+			return
+		}
+		drawn, lastDrawn = true, read
+		if total > 0 {
+			_, _ = fmt.Fprintf(w, "\r\033[KDownloading %s: %s / %s (%d%%)", label, FormatSize(read), FormatSize(total), read*100/total)
+		} else {
+			_, _ = fmt.Fprintf(w, "\r\033[KDownloading %s: %s", label, FormatSize(read))
+		}
+	}
+}
+
+// get issues a single GET request for url, authenticating to GitHub hosts when a token is
+// available and making the request conditional on any validators given.
+func get(url string, since Validators) (*http.Response, error) {
+	req, err := http.NewRequest(http.MethodGet, url, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create request for %s: %w", url, err)
+	}
+	if since.ETag != "" {
+		req.Header.Set("If-None-Match", since.ETag)
+	}
+	if since.LastModified != "" {
+		req.Header.Set("If-Modified-Since", since.LastModified)
+	}
+	// Private repositories need the token for raw content too; other hosts never see it.
+	if ghauth.IsGitHubHost(req.URL.Hostname()) {
+		if token := ghauth.Token(); token != "" {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+	}
+
+	resp, err := httpclient.New().Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to perform GET request to %s: %w", url, err)
+	}
+	return resp, nil
+}
+
+// retryDelay reports whether a download attempt that returned resp or err is worth retrying,
+// how long to wait first and why. Connection errors and 5xx responses back off exponentially;
+// 429 Too Many Requests waits as long as its Retry-After header asks.
+func retryDelay(resp *http.Response, err error, attempt int) (time.Duration, string, bool) {
+	switch {
+	case err != nil:
+		return RetryBackoff << attempt, err.Error(), isTransient(err)
+	case resp.StatusCode == http.StatusTooManyRequests:
+		return retryAfter(resp.Header.Get("Retry-After")), "status code 429", true
+	case resp.StatusCode >= http.StatusInternalServerError:
+		return RetryBackoff << attempt, fmt.Sprintf("status code %d", resp.StatusCode), true
+	}
+	return 0, "", false
+}
+
+// isTransient reports whether a request error may go away on its own, such as a refused or
+// reset connection or a timeout. A host that does not resolve, or a malformed URL, will not.
+func isTransient(err error) bool {
+	// A client timeout arrives as a *url.Error rather than a *net.OpError.
+	var netErr net.Error
+	if errors.As(err, &netErr) && netErr.Timeout() {
+		return true
+	}
+	var dnsErr *net.DNSError
+	if errors.As(err, &dnsErr) {
+		return !dnsErr.IsNotFound
+	}
+	var opErr *net.OpError
+	return errors.As(err, &opErr) || errors.Is(err, io.ErrUnexpectedEOF) || errors.Is(err, io.EOF)
+}
+
+// retryAfter interprets a Retry-After header, given either in seconds or as an HTTP date, and
+// clamps the result to MaxRetryAfter. Missing or malformed values fall back to defaultRetryAfter.
+func retryAfter(header string) time.Duration {
+	header = strings.TrimSpace(header)
+	delay := defaultRetryAfter
+	if seconds, err := strconv.Atoi(header); err == nil && seconds >= 0 {
+		delay = time.Duration(seconds) * time.Second
+	} else if at, err := http.ParseTime(header); err == nil {
+		delay = time.Until(at)
+		if delay < 0 {
+			delay = 0
+		}
+	}
+	if delay > MaxRetryAfter {
+		delay = MaxRetryAfter
+	}
+	return delay
+}
+
+// maxChecksumFileSize bounds checksum file downloads; real ones are a few hundred bytes.
+const maxChecksumFileSize int64 = 1 << 20
+
+// FetchChecksum downloads the checksum file at url and returns the published hash for filename
+// in the format "sha256:<hex_hash>".
+func FetchChecksum(url, filename string) (string, error) {
+	return (&Client{}).FetchChecksum(url, filename)
+}
+
+// FetchChecksum is the package-level FetchChecksum reporting through c.
+func (c *Client) FetchChecksum(url, filename string) (string, error) {
+	data, err := c.DownloadFileWithLimit(url, maxChecksumFileSize)
+	if err != nil {
+		return "", err
+	}
+	hash, err := hasher.ParseChecksumFile(data, filename)
+	if err != nil {
+		return "", fmt.Errorf("failed to parse checksum file %s: %w", url, err)
+	}
+	return hash, nil
+}
+
+// FormatSize formats a byte count with a binary unit, such as "512 B", "1.5 KiB" or "4.0 MiB".
+func FormatSize(n int64) string {
+	const unit = 1 << 10
+	if n < unit {
+		return fmt.Sprintf("%d B", n)
+	}
+	value, exp := float64(n)/unit, 0
+	for value >= unit && exp < 2 {
+		value /= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f %ciB", value, "KMG"[exp])
+}
+
+// ParseSize parses a byte count such as "1048576", "512KB", "50MB" or "1GB".
+// Suffixes are binary multiples and case-insensitive.
+func ParseSize(s string) (int64, error) {
+	trimmed := strings.ToUpper(strings.TrimSpace(s))
+	multiplier := int64(1)
+	for _, unit := range []struct {
+		suffix string
+		factor int64
+	}{{"GB", 1 << 30}, {"MB", 1 << 20}, {"KB", 1 << 10}, {"B", 1}} {
+		if strings.HasSuffix(trimmed, unit.suffix) {
+			trimmed = strings.TrimSpace(strings.TrimSuffix(trimmed, unit.suffix))
+			multiplier = unit.factor
+			break
+		}
+	}
+	n, err := strconv.ParseInt(trimmed, 10, 64)
+	if err != nil || n < 0 {
+		return 0, fmt.Errorf("invalid size '%s': expected a non-negative number of bytes, optionally with a KB, MB or GB suffix", s)
+	}
+	return n * multiplier, nil
 }
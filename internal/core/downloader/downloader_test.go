@@ -2,15 +2,21 @@
 package downloader_test
 
 import (
+	"bytes"
+	"errors"
 	"fmt"
 	"net/http"
 	"net/http/httptest"
+	"strings"
+	"sync/atomic"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 
 	"github.com/nightconcept/almandine-go/internal/core/downloader"
+	"github.com/nightconcept/almandine-go/internal/core/httpclient"
 )
 
 func TestDownloadFile_Success(t *testing.T) {
@@ -39,10 +45,14 @@ func TestDownloadFile_HTTPErrorNotFound(t *testing.T) {
 	require.Error(t, err, "DownloadFile should have returned an error for 404")
 	assert.Contains(t, err.Error(), "failed to download from", "Error message mismatch")
 	assert.Contains(t, err.Error(), "received status code 404", "Error message mismatch for status code")
+	var statusErr *downloader.StatusError
+	require.True(t, errors.As(err, &statusErr), "status failures are reported as *StatusError")
+	assert.Equal(t, http.StatusNotFound, statusErr.StatusCode)
 }
 
 func TestDownloadFile_HTTPErrorInternalServer(t *testing.T) {
-	t.Parallel()
+	downloader.MaxRetries = 0
+	defer func() { downloader.MaxRetries = 3 }()
 	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		w.WriteHeader(http.StatusInternalServerError)
 	}))
@@ -69,6 +79,42 @@ func TestDownloadFile_NetworkError_InvalidURL(t *testing.T) {
 	assert.Contains(t, err.Error(), fmt.Sprintf("failed to perform GET request to %s", invalidURL), "Error message mismatch for network error")
 }
 
+func TestDownloadFile_TimesOutOnHungServer(t *testing.T) {
+	t.Setenv(httpclient.TimeoutEnv, "50ms")
+	downloader.MaxRetries = 0
+	defer func() { downloader.MaxRetries = 3 }()
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-r.Context().Done() // Never answer; the client has to give up.
+	}))
+	defer server.Close()
+
+	start := time.Now()
+	_, err := downloader.DownloadFile(server.URL)
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "no response from server for 50ms")
+	assert.Less(t, time.Since(start), 5*time.Second)
+}
+
+func TestDownloadFile_RetriesAfterTimeout(t *testing.T) {
+	t.Setenv(httpclient.TimeoutEnv, "50ms")
+	downloader.RetryBackoff = time.Millisecond
+	defer func() { downloader.RetryBackoff = 500 * time.Millisecond }()
+	var requests atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if requests.Add(1) == 1 {
+			<-r.Context().Done() // Hang once; the retry is answered.
+			return
+		}
+		_, _ = w.Write([]byte("answered"))
+	}))
+	defer server.Close()
+
+	content, err := downloader.DownloadFile(server.URL)
+	require.NoError(t, err)
+	assert.Equal(t, "answered", string(content))
+	assert.Equal(t, int32(2), requests.Load())
+}
+
 func TestDownloadFile_ReadBodyError(t *testing.T) {
 	t.Parallel()
 	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
@@ -97,3 +143,313 @@ func TestDownloadFile_ReadBodyError(t *testing.T) {
 	// We check for our wrapper message.
 	assert.Contains(t, err.Error(), fmt.Sprintf("failed to read response body from %s", server.URL), "Error message mismatch for read body error")
 }
+
+func TestDownloadFileWithLimit_StreamingBodyOverCap(t *testing.T) {
+	t.Parallel()
+	const maxSize = 1024
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// Stream in chunks without a Content-Length so only the limited reader can catch it.
+		flusher, ok := w.(http.Flusher)
+		require.True(t, ok)
+		chunk := []byte(strings.Repeat("x", 256))
+		for i := 0; i < 64; i++ {
+			if _, err := w.Write(chunk); err != nil {
+				return // The client hung up after hitting the cap.
+			}
+			flusher.Flush()
+		}
+	}))
+	defer server.Close()
+
+	_, err := downloader.DownloadFileWithLimit(server.URL, maxSize)
+	require.Error(t, err)
+	assert.ErrorIs(t, err, downloader.ErrTooLarge)
+	assert.Contains(t, err.Error(), "file exceeds max size of 1024 bytes")
+}
+
+func TestDownloadFileWithLimit_DeclaredLengthOverCap(t *testing.T) {
+	t.Parallel()
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte(strings.Repeat("y", 2048)))
+	}))
+	defer server.Close()
+
+	_, err := downloader.DownloadFileWithLimit(server.URL, 1024)
+	assert.ErrorIs(t, err, downloader.ErrTooLarge)
+}
+
+func TestDownloadFileWithLimit_ExactlyAtCapAndUnlimited(t *testing.T) {
+	t.Parallel()
+	body := strings.Repeat("z", 1024)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte(body))
+	}))
+	defer server.Close()
+
+	content, err := downloader.DownloadFileWithLimit(server.URL, 1024)
+	require.NoError(t, err)
+	assert.Equal(t, body, string(content))
+
+	content, err = downloader.DownloadFileWithLimit(server.URL, 0)
+	require.NoError(t, err)
+	assert.Equal(t, body, string(content))
+}
+
+func TestDownloadFile_TooManyRequestsRetriesAfterDelay(t *testing.T) {
+	var requests atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if requests.Add(1) == 1 {
+			w.Header().Set("Retry-After", "1")
+			w.WriteHeader(http.StatusTooManyRequests)
+			return
+		}
+		_, _ = w.Write([]byte("after the wait"))
+	}))
+	defer server.Close()
+
+	var log bytes.Buffer
+	client := &downloader.Client{Verbose: &log}
+
+	start := time.Now()
+	content, err := client.DownloadFile(server.URL)
+	require.NoError(t, err)
+	assert.Equal(t, "after the wait", string(content))
+	assert.Equal(t, int32(2), requests.Load())
+	assert.GreaterOrEqual(t, time.Since(start), time.Second, "the Retry-After delay must be honored")
+	assert.Contains(t, log.String(), "rate limited the download (429), retrying after 1 seconds")
+}
+
+func TestDownloadFile_TooManyRequestsExhaustsRetries(t *testing.T) {
+	var requests atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests.Add(1)
+		w.Header().Set("Retry-After", "0")
+		w.WriteHeader(http.StatusTooManyRequests)
+	}))
+	defer server.Close()
+
+	_, err := downloader.DownloadFile(server.URL)
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "429 Too Many Requests")
+	assert.Equal(t, int32(downloader.MaxRetries+1), requests.Load())
+}
+
+func TestDownloadFile_RetriesTransientFailures(t *testing.T) {
+	downloader.RetryBackoff = time.Millisecond
+	defer func() { downloader.RetryBackoff = 500 * time.Millisecond }()
+
+	t.Run("5xx then success", func(t *testing.T) {
+		var requests atomic.Int32
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if requests.Add(1) < 3 {
+				w.WriteHeader(http.StatusBadGateway)
+				return
+			}
+			_, _ = w.Write([]byte("third time lucky"))
+		}))
+		defer server.Close()
+
+		var log bytes.Buffer
+		client := &downloader.Client{Verbose: &log}
+
+		content, err := client.DownloadFile(server.URL)
+		require.NoError(t, err)
+		assert.Equal(t, "third time lucky", string(content))
+		assert.Equal(t, int32(3), requests.Load())
+		assert.Contains(t, log.String(), "failed (status code 502), retrying in 1ms")
+	})
+
+	t.Run("5xx exhausts retries", func(t *testing.T) {
+		var requests atomic.Int32
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			requests.Add(1)
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}))
+		defer server.Close()
+
+		_, err := downloader.DownloadFile(server.URL)
+		var statusErr *downloader.StatusError
+		require.True(t, errors.As(err, &statusErr))
+		assert.Equal(t, http.StatusServiceUnavailable, statusErr.StatusCode)
+		assert.Equal(t, int32(downloader.MaxRetries+1), requests.Load())
+	})
+
+	t.Run("4xx is not retried", func(t *testing.T) {
+		var requests atomic.Int32
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			requests.Add(1)
+			w.WriteHeader(http.StatusNotFound)
+		}))
+		defer server.Close()
+
+		_, err := downloader.DownloadFile(server.URL)
+		require.Error(t, err)
+		assert.Equal(t, int32(1), requests.Load())
+	})
+
+	t.Run("connection refused", func(t *testing.T) {
+		server := httptest.NewServer(http.NotFoundHandler())
+		url := server.URL
+		server.Close()
+
+		var log bytes.Buffer
+		client := &downloader.Client{Verbose: &log}
+
+		_, err := client.DownloadFile(url)
+		require.Error(t, err)
+		assert.Equal(t, downloader.MaxRetries, strings.Count(log.String(), "retrying in"))
+	})
+
+	t.Run("retries disabled", func(t *testing.T) {
+		downloader.MaxRetries = 0
+		defer func() { downloader.MaxRetries = 3 }()
+		var requests atomic.Int32
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			requests.Add(1)
+			w.WriteHeader(http.StatusInternalServerError)
+		}))
+		defer server.Close()
+
+		_, err := downloader.DownloadFile(server.URL)
+		require.Error(t, err)
+		assert.Equal(t, int32(1), requests.Load())
+	})
+}
+
+func TestDownloadFileIfModified(t *testing.T) {
+	t.Parallel()
+	const etag = `"v1"`
+	const lastModified = "Wed, 21 Oct 2015 07:28:00 GMT"
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("If-None-Match") == etag {
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+		w.Header().Set("ETag", etag)
+		w.Header().Set("Last-Modified", lastModified)
+		_, _ = w.Write([]byte("return 1"))
+	}))
+	defer server.Close()
+
+	content, validators, err := downloader.DownloadFileIfModified(server.URL, downloader.DefaultMaxSize, downloader.Validators{})
+	require.NoError(t, err)
+	assert.Equal(t, []byte("return 1"), content)
+	assert.Equal(t, downloader.Validators{ETag: etag, LastModified: lastModified}, validators)
+
+	content, validators, err = downloader.DownloadFileIfModified(server.URL, downloader.DefaultMaxSize, validators)
+	assert.ErrorIs(t, err, downloader.ErrNotModified)
+	assert.Nil(t, content)
+	assert.Equal(t, etag, validators.ETag, "the validators sent are still current")
+
+	content, _, err = downloader.DownloadFileIfModified(server.URL, downloader.DefaultMaxSize, downloader.Validators{ETag: `"old"`})
+	require.NoError(t, err, "a stale ETag gets the file")
+	assert.Equal(t, []byte("return 1"), content)
+}
+
+func TestDownloadFileWithProgress(t *testing.T) {
+	t.Parallel()
+	content := bytes.Repeat([]byte("x"), 100<<10)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/sized" {
+			w.Header().Set("Content-Length", fmt.Sprint(len(content)))
+		}
+		// Flushing before the body forces chunked encoding when no length was set.
+		w.(http.Flusher).Flush()
+		_, _ = w.Write(content)
+	}))
+	defer server.Close()
+
+	for path, wantTotal := range map[string]int64{"/sized": int64(len(content)), "/chunked": -1} {
+		var calls int
+		var lastRead, firstTotal, lastTotal int64
+		firstTotal = -2
+		body, err := downloader.DownloadFileWithProgress(server.URL+path, downloader.DefaultMaxSize, func(read, total int64) {
+			calls++
+			if firstTotal == -2 {
+				firstTotal = total
+			}
+			assert.GreaterOrEqual(t, read, lastRead, "progress never goes backwards")
+			lastRead, lastTotal = read, total
+		})
+		require.NoError(t, err)
+		assert.Len(t, body, len(content))
+		assert.Greater(t, calls, 1, path)
+		assert.Equal(t, wantTotal, firstTotal, "%s: total comes from Content-Length when sent", path)
+		assert.Equal(t, int64(len(content)), lastRead, path)
+		assert.Equal(t, lastRead, lastTotal, "%s: the final report has total equal to read", path)
+	}
+}
+
+func TestClient_ProgressHook(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte("hooked"))
+	}))
+	defer server.Close()
+
+	var asked []string
+	var final [2]int64
+	client := &downloader.Client{Progress: func(url string) downloader.ProgressFunc {
+		asked = append(asked, url)
+		return func(read, total int64) { final = [2]int64{read, total} }
+	}}
+	content, err := client.DownloadFile(server.URL)
+	require.NoError(t, err)
+	assert.Equal(t, "hooked", string(content))
+	assert.Equal(t, []string{server.URL}, asked)
+	assert.Equal(t, [2]int64{6, 6}, final)
+
+	_, err = downloader.DownloadFile(server.URL)
+	require.NoError(t, err)
+	assert.Len(t, asked, 1, "the hook belongs to its client only")
+}
+
+func TestTerminalProgress(t *testing.T) {
+	t.Parallel()
+	var out bytes.Buffer
+	progress := downloader.TerminalProgress(&out, "small.lua")
+	progress(512, 1024)
+	progress(1024, 1024)
+	assert.Empty(t, out.String(), "small downloads draw nothing")
+
+	progress = downloader.TerminalProgress(&out, "big.lua")
+	progress(1<<20, 4<<20)
+	assert.Contains(t, out.String(), "Downloading big.lua: 1.0 MiB / 4.0 MiB (25%)")
+	progress(4<<20, 4<<20)
+	assert.True(t, strings.HasSuffix(out.String(), "\r\033[K"), "the line is cleared when the download completes")
+
+	out.Reset()
+	progress = downloader.TerminalProgress(&out, "stream.lua")
+	progress(2<<20, -1)
+	assert.Contains(t, out.String(), "Downloading stream.lua: 2.0 MiB")
+	assert.NotContains(t, out.String(), "%", "no percentage without a Content-Length")
+}
+
+func TestFormatSize(t *testing.T) {
+	t.Parallel()
+	assert.Equal(t, "512 B", downloader.FormatSize(512))
+	assert.Equal(t, "1.5 KiB", downloader.FormatSize(1536))
+	assert.Equal(t, "4.0 MiB", downloader.FormatSize(4<<20))
+	assert.Equal(t, "2.0 GiB", downloader.FormatSize(2<<30))
+}
+
+func TestParseSize(t *testing.T) {
+	t.Parallel()
+	cases := map[string]int64{
+		"1048576": 1 << 20,
+		"512KB":   512 << 10,
+		"50MB":    50 << 20,
+		"50mb":    50 << 20,
+		"1 GB":    1 << 30,
+		"100B":    100,
+		"0":       0,
+	}
+	for in, want := range cases {
+		got, err := downloader.ParseSize(in)
+		require.NoError(t, err, "ParseSize(%q)", in)
+		assert.Equal(t, want, got, "ParseSize(%q)", in)
+	}
+	for _, in := range []string{"", "MB", "-5MB", "ten"} {
+		_, err := downloader.ParseSize(in)
+		assert.Error(t, err, "ParseSize(%q)", in)
+	}
+}
@@ -0,0 +1,158 @@
+// Package fsutil provides filesystem helpers shared by the commands that write dependency files.
+package fsutil
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"syscall"
+)
+
+// TempDirEnv names the environment variable that overrides where WriteFileAtomic stages
+// temporary files. When unset, temporary files are created next to the target.
+const TempDirEnv = "ALMD_TMPDIR"
+
+// rename is os.Rename, replaceable in tests to simulate a cross-filesystem move.
+var rename = os.Rename
+
+// stagingDir returns the directory WriteFileAtomic should create its temporary file in.
+func stagingDir(target string) string {
+	if dir := os.Getenv(TempDirEnv); dir != "" {
+		return dir
+	}
+	return filepath.Dir(target)
+}
+
+// WriteFileAtomic writes data to target so that readers never observe a partially written
+// file. The data is written to a temporary file in the staging directory ($ALMD_TMPDIR, or
+// the target's own directory) and renamed over target. If the temporary file cannot be
+// created there, os.TempDir is used instead. When the staging directory is on a different
+// filesystem, so the rename fails with EXDEV, the content is copied to a second temporary
+// file next to target and renamed from there; only if that directory refuses new files is it
+// copied over target directly. On any error target keeps its previous content.
+func WriteFileAtomic(target string, data []byte, perm os.FileMode) error {
+	tmp, err := os.CreateTemp(stagingDir(target), ".almd-*.tmp")
+	if err != nil {
+		if tmp, err = os.CreateTemp(os.TempDir(), ".almd-*.tmp"); err != nil {
+			return fmt.Errorf("failed to create temporary file for %s: %w", target, err)
+		}
+	}
+	tmpPath := tmp.Name()
+	defer func() { _ = os.Remove(tmpPath) }() // No-op once renamed into place
+
+	if _, err := tmp.Write(data); err != nil {
+		_ = tmp.Close()
+		return fmt.Errorf("failed to write temporary file for %s: %w", target, err)
+	}
+	if err := tmp.Sync(); err != nil {
+		_ = tmp.Close()
+		return fmt.Errorf("failed to flush temporary file for %s: %w", target, err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("failed to close temporary file for %s: %w", target, err)
+	}
+	if err := os.Chmod(tmpPath, perm); err != nil {
+		return fmt.Errorf("failed to set permissions on temporary file for %s: %w", target, err)
+	}
+
+	if err := rename(tmpPath, target); err != nil {
+		if !errors.Is(err, syscall.EXDEV) {
+			return fmt.Errorf("failed to move temporary file into place at %s: %w", target, err)
+		}
+		if err := copyIntoPlace(tmpPath, target, perm); err != nil {
+			return fmt.Errorf("failed to copy temporary file across filesystems to %s: %w", target, err)
+		}
+	}
+	return nil
+}
+
+// copyIntoPlace copies src to a temporary file beside target and renames it over target, so
+// a copy across filesystems is as atomic as a rename. If no file can be created beside
+// target, src is copied over target directly.
+func copyIntoPlace(src, target string, perm os.FileMode) error {
+	tmp, err := os.CreateTemp(filepath.Dir(target), ".almd-*.tmp")
+	if err != nil {
+		return copyFile(src, target, perm)
+	}
+	tmpPath := tmp.Name()
+	_ = tmp.Close()
+	defer func() { _ = os.Remove(tmpPath) }() // No-op once renamed into place
+
+	if err := copyFile(src, tmpPath, perm); err != nil {
+		return err
+	}
+	if err := os.Chmod(tmpPath, perm); err != nil {
+		return err
+	}
+	return rename(tmpPath, target)
+}
+
+// copyFile copies src to dst, creating or truncating dst with perm.
+func copyFile(src, dst string, perm os.FileMode) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = in.Close() }()
+
+	out, err := os.OpenFile(dst, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, perm)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(out, in); err != nil {
+		_ = out.Close()
+		return err
+	}
+	return out.Close()
+}
+
+// CheckWithinRoot returns an error unless dir, a path relative to root, stays inside root.
+// Absolute paths and ".." elements that climb out of root are refused. So is a dir whose
+// existing part resolves outside root through a symlink, or ends in a dangling symlink,
+// since creating directories below it would then write elsewhere. Symlinks that stay
+// inside root are allowed.
+func CheckWithinRoot(root, dir string) error {
+	if filepath.IsAbs(dir) || filepath.VolumeName(dir) != "" {
+		return fmt.Errorf("'%s' is an absolute path; it must be relative to the project root", dir)
+	}
+	if !isInside(filepath.Clean(dir)) {
+		return fmt.Errorf("'%s' leads outside the project root", dir)
+	}
+
+	absRoot, err := filepath.Abs(root)
+	if err != nil {
+		return fmt.Errorf("failed to resolve project root: %w", err)
+	}
+	realRoot, err := filepath.EvalSymlinks(absRoot)
+	if err != nil {
+		return fmt.Errorf("failed to resolve project root: %w", err)
+	}
+	// Whatever does not exist yet is created inside the deepest directory that does.
+	current := filepath.Join(absRoot, dir)
+	for {
+		resolved, evalErr := filepath.EvalSymlinks(current)
+		if evalErr == nil {
+			rel, relErr := filepath.Rel(realRoot, resolved)
+			if relErr != nil || !isInside(rel) {
+				return fmt.Errorf("'%s' leads outside the project root through a symlink (it resolves to %s)", dir, resolved)
+			}
+			return nil
+		}
+		if !errors.Is(evalErr, fs.ErrNotExist) {
+			return fmt.Errorf("failed to resolve '%s': %w", dir, evalErr)
+		}
+		if _, lstatErr := os.Lstat(current); lstatErr == nil {
+			return fmt.Errorf("'%s' goes through a dangling symlink at %s", dir, current)
+		}
+		current = filepath.Dir(current)
+	}
+}
+
+// isInside reports whether the clean relative path rel does not climb above its base.
+func isInside(rel string) bool {
+	return rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator))
+}
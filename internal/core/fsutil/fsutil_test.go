@@ -0,0 +1,141 @@
+package fsutil
+
+import (
+	"os"
+	"path/filepath"
+	"syscall"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestWriteFileAtomic_NextToTarget(t *testing.T) {
+	t.Setenv(TempDirEnv, "")
+	dir := t.TempDir()
+	target := filepath.Join(dir, "dep.lua")
+	require.NoError(t, os.WriteFile(target, []byte("old"), 0644))
+
+	require.NoError(t, WriteFileAtomic(target, []byte("new"), 0644))
+
+	content, err := os.ReadFile(target)
+	require.NoError(t, err)
+	assert.Equal(t, "new", string(content))
+	entries, err := os.ReadDir(dir)
+	require.NoError(t, err)
+	assert.Len(t, entries, 1, "no temporary files should be left behind")
+}
+
+func TestWriteFileAtomic_ConfiguredTempDir(t *testing.T) {
+	stageDir := t.TempDir()
+	t.Setenv(TempDirEnv, stageDir)
+	target := filepath.Join(t.TempDir(), "dep.lua")
+
+	require.NoError(t, WriteFileAtomic(target, []byte("content"), 0644))
+
+	content, err := os.ReadFile(target)
+	require.NoError(t, err)
+	assert.Equal(t, "content", string(content))
+	entries, err := os.ReadDir(stageDir)
+	require.NoError(t, err)
+	assert.Empty(t, entries, "the staged file should have been moved out of ALMD_TMPDIR")
+}
+
+func TestWriteFileAtomic_CrossFilesystemFallsBackToCopy(t *testing.T) {
+	stageDir := t.TempDir()
+	t.Setenv(TempDirEnv, stageDir)
+	targetDir := t.TempDir()
+	target := filepath.Join(targetDir, "dep.lua")
+
+	// Only moves out of the staging directory cross a filesystem boundary.
+	original := rename
+	var renamedFrom []string
+	rename = func(oldpath, newpath string) error {
+		renamedFrom = append(renamedFrom, filepath.Dir(oldpath))
+		if filepath.Dir(oldpath) == stageDir {
+			return &os.LinkError{Op: "rename", Old: oldpath, New: newpath, Err: syscall.EXDEV}
+		}
+		return original(oldpath, newpath)
+	}
+	defer func() { rename = original }()
+
+	require.NoError(t, WriteFileAtomic(target, []byte("copied"), 0644))
+
+	content, err := os.ReadFile(target)
+	require.NoError(t, err)
+	assert.Equal(t, "copied", string(content))
+	assert.Equal(t, []string{stageDir, targetDir}, renamedFrom, "the copy is staged beside the target and renamed into place")
+	entries, err := os.ReadDir(stageDir)
+	require.NoError(t, err)
+	assert.Empty(t, entries, "the staged file should be removed after copying")
+	entries, err = os.ReadDir(targetDir)
+	require.NoError(t, err)
+	assert.Len(t, entries, 1, "no temporary files should be left beside the target")
+}
+
+func TestWriteFileAtomic_OtherRenameErrorsFail(t *testing.T) {
+	t.Setenv(TempDirEnv, "")
+	target := filepath.Join(t.TempDir(), "dep.lua")
+
+	original := rename
+	rename = func(oldpath, newpath string) error {
+		return &os.LinkError{Op: "rename", Old: oldpath, New: newpath, Err: syscall.EACCES}
+	}
+	defer func() { rename = original }()
+
+	err := WriteFileAtomic(target, []byte("x"), 0644)
+	require.Error(t, err)
+	_, statErr := os.Stat(target)
+	assert.True(t, os.IsNotExist(statErr))
+}
+
+func TestWriteFileAtomic_FailedWriteKeepsPreviousContent(t *testing.T) {
+	t.Setenv(TempDirEnv, "")
+	dir := t.TempDir()
+	target := filepath.Join(dir, "dep.lua")
+	require.NoError(t, os.WriteFile(target, []byte("complete old content"), 0644))
+
+	// The new content is fully staged, then the move into place fails, as it would if the
+	// process were interrupted before the rename.
+	original := rename
+	rename = func(oldpath, newpath string) error {
+		return &os.LinkError{Op: "rename", Old: oldpath, New: newpath, Err: syscall.EIO}
+	}
+	defer func() { rename = original }()
+
+	require.Error(t, WriteFileAtomic(target, []byte("new"), 0644))
+
+	content, err := os.ReadFile(target)
+	require.NoError(t, err)
+	assert.Equal(t, "complete old content", string(content), "the target is never partially overwritten")
+	entries, err := os.ReadDir(dir)
+	require.NoError(t, err)
+	assert.Len(t, entries, 1, "the staged file is removed after a failure")
+}
+
+func TestCheckWithinRoot(t *testing.T) {
+	root := t.TempDir()
+	outside := t.TempDir()
+	require.NoError(t, os.MkdirAll(filepath.Join(root, "libs", "nested"), 0755))
+	require.NoError(t, os.Symlink(outside, filepath.Join(root, "escape")))
+	require.NoError(t, os.Symlink(filepath.Join(root, "libs"), filepath.Join(root, "alias")))
+	require.NoError(t, os.Symlink(filepath.Join(root, "missing"), filepath.Join(root, "dangling")))
+
+	for _, dir := range []string{".", "libs", "libs/nested", "libs/new/deeper", "libs/../vendor", "alias/nested", "alias/new"} {
+		assert.NoError(t, CheckWithinRoot(root, dir), dir)
+	}
+
+	for dir, want := range map[string]string{
+		"..":                "leads outside the project root",
+		"../outside":        "leads outside the project root",
+		"libs/../../x":      "leads outside the project root",
+		outside:             "is an absolute path",
+		"escape":            "through a symlink",
+		"escape/new/deeper": "through a symlink",
+		"dangling/sub":      "dangling symlink",
+	} {
+		err := CheckWithinRoot(root, dir)
+		require.Error(t, err, dir)
+		assert.Contains(t, err.Error(), want, dir)
+	}
+}
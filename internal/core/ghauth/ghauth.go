@@ -0,0 +1,122 @@
+// Package ghauth discovers a GitHub token from the environment or from tools the developer is
+// already logged in with, so that private repositories and the authenticated API rate limit
+// work without extra setup.
+package ghauth
+
+import (
+	"context"
+	"os"
+	"os/exec"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/nightconcept/almandine-go/internal/core/githost"
+)
+
+const (
+	// TokenEnv is checked first and takes precedence over every other source.
+	TokenEnv = "ALMD_GITHUB_TOKEN"
+	// FallbackTokenEnv is the conventional variable set by CI systems such as GitHub Actions.
+	FallbackTokenEnv = "GITHUB_TOKEN"
+	// NoCredentialHelperEnv, when set to a value other than "" or "0", stops discovery from
+	// running the gh CLI or git's credential helper.
+	NoCredentialHelperEnv = "ALMD_NO_CREDENTIAL_HELPER"
+)
+
+// helperTimeout bounds each external helper so that a misbehaving one cannot stall almd.
+const helperTimeout = 5 * time.Second
+
+// Resolver walks the token discovery chain. Its fields are the chain's only side effects,
+// so tests can substitute each source.
+type Resolver struct {
+	// Getenv looks up an environment variable.
+	Getenv func(key string) string
+	// Run executes name with args, feeding it stdin, and returns its standard output.
+	Run func(name string, args []string, stdin string) (string, error)
+}
+
+// NewResolver returns a Resolver backed by the process environment and real commands.
+func NewResolver() Resolver {
+	return Resolver{Getenv: os.Getenv, Run: runCommand}
+}
+
+// Token returns the first token found in the chain ALMD_GITHUB_TOKEN, GITHUB_TOKEN,
+// `gh auth token`, `git credential fill` for github.com (or the host configured in githost),
+// along with the name of the source it came from. Both are empty when no source yields a token.
+func (r Resolver) Token() (token, origin string) {
+	for _, key := range []string{TokenEnv, FallbackTokenEnv} {
+		if value := strings.TrimSpace(r.Getenv(key)); value != "" {
+			return value, key
+		}
+	}
+	if optOut := r.Getenv(NoCredentialHelperEnv); optOut != "" && optOut != "0" {
+		return "", ""
+	}
+
+	host := githost.Hostname()
+	if out, err := r.Run("gh", []string{"auth", "token", "--hostname", host}, ""); err == nil {
+		if value := strings.TrimSpace(out); value != "" {
+			return value, "gh auth token"
+		}
+	}
+	if out, err := r.Run("git", []string{"credential", "fill"}, "protocol=https\nhost="+host+"\n\n"); err == nil {
+		if value := credentialPassword(out); value != "" {
+			return value, "git credential fill"
+		}
+	}
+	return "", ""
+}
+
+// credentialPassword extracts the password attribute from `git credential fill` output.
+func credentialPassword(out string) string {
+	for _, line := range strings.Split(out, "\n") {
+		if value, ok := strings.CutPrefix(strings.TrimRight(line, "\r"), "password="); ok {
+			return value
+		}
+	}
+	return ""
+}
+
+// runCommand runs an external helper without ever letting it prompt for input.
+func runCommand(name string, args []string, stdin string) (string, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), helperTimeout)
+	defer cancel()
+	cmd := exec.CommandContext(ctx, name, args...)
+	cmd.Stdin = strings.NewReader(stdin)
+	cmd.Env = append(os.Environ(), "GIT_TERMINAL_PROMPT=0", "GCM_INTERACTIVE=never")
+	out, err := cmd.Output()
+	return string(out), err
+}
+
+var (
+	tokenOnce   sync.Once
+	cachedToken string
+)
+
+// Token returns the token discovered by NewResolver. The chain runs external commands, so it
+// is walked once per process and the result reused.
+func Token() string {
+	tokenOnce.Do(func() {
+		cachedToken, _ = NewResolver().Token()
+	})
+	return cachedToken
+}
+
+// IsGitHubHost reports whether host belongs to GitHub, or to the self-hosted instance
+// configured in githost, and so may be sent the token. Tokens are never attached to requests
+// for any other host, nor to a self-hosted instance set only in project.toml (see
+// githost.Trusted).
+func IsGitHubHost(host string) bool {
+	if !githost.Trusted() {
+		return false
+	}
+	if !githost.IsDefault() {
+		return strings.EqualFold(host, githost.Hostname())
+	}
+	switch strings.ToLower(host) {
+	case "github.com", "api.github.com", "raw.githubusercontent.com":
+		return true
+	}
+	return false
+}
@@ -0,0 +1,132 @@
+package ghauth
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/nightconcept/almandine-go/internal/core/githost"
+)
+
+// fakeResolver builds a Resolver from a fixed environment and canned command outputs keyed by
+// command name. Commands without an entry fail, as if the tool were not installed. Every
+// command run is recorded in calls.
+func fakeResolver(env map[string]string, outputs map[string]string, calls *[]string) Resolver {
+	return Resolver{
+		Getenv: func(key string) string { return env[key] },
+		Run: func(name string, args []string, stdin string) (string, error) {
+			*calls = append(*calls, name)
+			out, ok := outputs[name]
+			if !ok {
+				return "", errors.New("executable file not found")
+			}
+			return out, nil
+		},
+	}
+}
+
+func TestResolverToken_Chain(t *testing.T) {
+	t.Parallel()
+	gitOutput := "protocol=https\nhost=github.com\nusername=dev\npassword=git-token\n"
+
+	cases := []struct {
+		name       string
+		env        map[string]string
+		outputs    map[string]string
+		wantToken  string
+		wantOrigin string
+		wantCalls  []string
+	}{
+		{
+			name:       "ALMD_GITHUB_TOKEN wins",
+			env:        map[string]string{TokenEnv: "almd-token", FallbackTokenEnv: "ci-token"},
+			outputs:    map[string]string{"gh": "gh-token\n"},
+			wantToken:  "almd-token",
+			wantOrigin: TokenEnv,
+		},
+		{
+			name:       "GITHUB_TOKEN is next",
+			env:        map[string]string{FallbackTokenEnv: "ci-token"},
+			outputs:    map[string]string{"gh": "gh-token\n"},
+			wantToken:  "ci-token",
+			wantOrigin: FallbackTokenEnv,
+		},
+		{
+			name:       "gh auth token is next",
+			outputs:    map[string]string{"gh": "gh-token\n", "git": gitOutput},
+			wantToken:  "gh-token",
+			wantOrigin: "gh auth token",
+			wantCalls:  []string{"gh"},
+		},
+		{
+			name:       "git credential fill is last",
+			outputs:    map[string]string{"git": gitOutput},
+			wantToken:  "git-token",
+			wantOrigin: "git credential fill",
+			wantCalls:  []string{"gh", "git"},
+		},
+		{
+			name:      "empty gh output falls through to git",
+			outputs:   map[string]string{"gh": "\n", "git": "protocol=https\nhost=github.com\n"},
+			wantCalls: []string{"gh", "git"},
+		},
+		{
+			name:      "helpers can be disabled",
+			env:       map[string]string{NoCredentialHelperEnv: "1"},
+			outputs:   map[string]string{"gh": "gh-token\n", "git": gitOutput},
+			wantCalls: nil,
+		},
+		{
+			name:       "opt-out does not affect environment tokens",
+			env:        map[string]string{NoCredentialHelperEnv: "1", FallbackTokenEnv: "ci-token"},
+			wantToken:  "ci-token",
+			wantOrigin: FallbackTokenEnv,
+		},
+		{
+			name:       "zero does not opt out",
+			env:        map[string]string{NoCredentialHelperEnv: "0"},
+			outputs:    map[string]string{"gh": "gh-token"},
+			wantToken:  "gh-token",
+			wantOrigin: "gh auth token",
+			wantCalls:  []string{"gh"},
+		},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+			var calls []string
+			token, origin := fakeResolver(tc.env, tc.outputs, &calls).Token()
+			assert.Equal(t, tc.wantToken, token)
+			assert.Equal(t, tc.wantOrigin, origin)
+			assert.Equal(t, tc.wantCalls, calls)
+		})
+	}
+}
+
+func TestIsGitHubHost(t *testing.T) {
+	t.Parallel()
+	assert.True(t, IsGitHubHost("raw.githubusercontent.com"))
+	assert.True(t, IsGitHubHost("API.GitHub.com"))
+	assert.False(t, IsGitHubHost("gitlab.com"))
+	assert.False(t, IsGitHubHost("github.com.evil.example"))
+}
+
+func TestIsGitHubHost_SelfHosted(t *testing.T) {
+	t.Setenv(githost.HostEnv, "git.internal.example.com")
+	assert.True(t, IsGitHubHost("git.internal.example.com"))
+	assert.False(t, IsGitHubHost("github.com"), "a github.com token is not sent once another host is configured")
+}
+
+func TestIsGitHubHost_ProjectHostNotTrusted(t *testing.T) {
+	t.Setenv(githost.HostEnv, "")
+	t.Setenv(githost.APIURLEnv, "")
+	githost.Configure("attacker.example", "")
+	defer githost.Configure("", "")
+
+	assert.False(t, IsGitHubHost("attacker.example"), "a host from project.toml alone is never sent the token")
+	assert.False(t, IsGitHubHost("github.com"))
+
+	t.Setenv(githost.HostEnv, "attacker.example")
+	assert.True(t, IsGitHubHost("attacker.example"), "a host chosen in the environment is trusted")
+}
@@ -0,0 +1,155 @@
+// Package githost holds the GitHub-compatible host that github: sources and GitHub URLs refer
+// to. It is github.com unless a self-hosted GitHub Enterprise or Gitea instance is configured,
+// by ALMD_GITHUB_HOST or the [almd] github_host setting, which then serves raw files and the
+// commits API under the same path conventions.
+package githost
+
+import (
+	"fmt"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+)
+
+const (
+	// HostEnv overrides the host configured in project.toml.
+	HostEnv = "ALMD_GITHUB_HOST"
+	// APIURLEnv overrides the API endpoint of a configured host, which otherwise defaults to
+	// <host>/api/v3 as on GitHub Enterprise (Gitea serves it at <host>/api/v1).
+	APIURLEnv = "ALMD_GITHUB_API_URL"
+
+	// DefaultHost is used when no host is configured.
+	DefaultHost = "github.com"
+	// DefaultRawHost serves raw file content for DefaultHost.
+	DefaultRawHost = "raw.githubusercontent.com"
+	// DefaultAPIBaseURL is the public GitHub REST API endpoint.
+	DefaultAPIBaseURL = "https://api.github.com"
+)
+
+var (
+	mu                        sync.Mutex
+	configuredHost, configAPI string
+)
+
+// Configure sets the host and API endpoint read from project.toml. Either may be empty, and
+// the environment variables take precedence over both.
+func Configure(host, apiURL string) {
+	mu.Lock()
+	defer mu.Unlock()
+	configuredHost, configAPI = host, apiURL
+}
+
+// settings returns the configured host and API endpoint, environment first.
+func settings() (host, apiURL string) {
+	mu.Lock()
+	host, apiURL = configuredHost, configAPI
+	mu.Unlock()
+	if env := strings.TrimSpace(os.Getenv(HostEnv)); env != "" {
+		host = env
+	}
+	if env := strings.TrimSpace(os.Getenv(APIURLEnv)); env != "" {
+		apiURL = env
+	}
+	return host, apiURL
+}
+
+// Trusted reports whether credentials may be sent to the host and API endpoint in use: they
+// are github.com's own or were set in the environment. A project.toml comes with whatever
+// repository was cloned, so a host or endpoint configured only there is never trusted.
+func Trusted() bool {
+	mu.Lock()
+	host, apiURL := configuredHost, configAPI
+	mu.Unlock()
+	if host != "" && !strings.EqualFold(host, DefaultHost) && strings.TrimSpace(os.Getenv(HostEnv)) == "" {
+		return false
+	}
+	return apiURL == "" || strings.TrimSpace(os.Getenv(APIURLEnv)) != ""
+}
+
+// baseURL returns the scheme and host of a configured host, which may be given bare
+// ("git.example.com") or as a URL ("http://localhost:3000"). It is nil when the default host
+// is in use.
+func baseURL() (*url.URL, error) {
+	host, _ := settings()
+	if host == "" || strings.EqualFold(host, DefaultHost) {
+		return nil, nil
+	}
+	if !strings.Contains(host, "://") {
+		host = "https://" + host
+	}
+	u, err := url.Parse(strings.TrimRight(host, "/"))
+	if err != nil || u.Host == "" {
+		return nil, fmt.Errorf("invalid GitHub host '%s'", host)
+	}
+	return &url.URL{Scheme: u.Scheme, Host: u.Host}, nil
+}
+
+// IsDefault reports whether github.com is in use.
+func IsDefault() bool {
+	u, err := baseURL()
+	return u == nil && err == nil
+}
+
+// Validate reports an error if the configured host cannot be parsed.
+func Validate() error {
+	_, err := baseURL()
+	return err
+}
+
+// Host returns the host (with port, if any) that github: sources refer to.
+func Host() string {
+	if u, err := baseURL(); err == nil && u != nil {
+		return u.Host
+	}
+	return DefaultHost
+}
+
+// Hostname returns Host without any port.
+func Hostname() string {
+	if u, err := baseURL(); err == nil && u != nil {
+		return u.Hostname()
+	}
+	return DefaultHost
+}
+
+// Matches reports whether host, as found in a URL, serves GitHub pages or raw content: github.com
+// and raw.githubusercontent.com by default, otherwise only the configured host.
+func Matches(host string) bool {
+	u, err := baseURL()
+	switch {
+	case err != nil:
+		return false
+	case u == nil:
+		return strings.EqualFold(host, DefaultHost) || strings.EqualFold(host, DefaultRawHost)
+	default:
+		return strings.EqualFold(host, u.Host)
+	}
+}
+
+// IsRawHost reports whether host serves nothing but raw content, laid out as
+// /<owner>/<repo>/<ref>/<path>. Only raw.githubusercontent.com does; self-hosted instances
+// serve raw files from /<owner>/<repo>/raw/<ref>/<path> on the main host.
+func IsRawHost(host string) bool {
+	return IsDefault() && strings.EqualFold(host, DefaultRawHost)
+}
+
+// RawURL returns where the file at pathInRepo is downloaded from at ref.
+func RawURL(owner, repo, ref, pathInRepo string) string {
+	if u, err := baseURL(); err == nil && u != nil {
+		return fmt.Sprintf("%s://%s/%s/%s/raw/%s/%s", u.Scheme, u.Host, owner, repo, ref, pathInRepo)
+	}
+	return fmt.Sprintf("https://%s/%s/%s/%s/%s", DefaultRawHost, owner, repo, ref, pathInRepo)
+}
+
+// APIBaseURL returns the REST API endpoint for the host, without a trailing slash.
+func APIBaseURL() string {
+	_, apiURL := settings()
+	if apiURL != "" {
+		return strings.TrimRight(apiURL, "/")
+	}
+	if u, err := baseURL(); err == nil && u != nil {
+		return fmt.Sprintf("%s://%s/api/v3", u.Scheme, u.Host)
+	}
+	return DefaultAPIBaseURL
+}
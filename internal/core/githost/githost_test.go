@@ -0,0 +1,84 @@
+// Package githost_test contains tests for the githost package.
+package githost_test
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/nightconcept/almandine-go/internal/core/githost"
+)
+
+func TestDefaultHost(t *testing.T) {
+	t.Setenv(githost.HostEnv, "")
+	t.Setenv(githost.APIURLEnv, "")
+
+	assert.True(t, githost.IsDefault())
+	assert.Equal(t, "github.com", githost.Host())
+	assert.True(t, githost.Matches("GitHub.com"))
+	assert.True(t, githost.Matches("raw.githubusercontent.com"))
+	assert.True(t, githost.IsRawHost("raw.githubusercontent.com"))
+	assert.Equal(t, "https://raw.githubusercontent.com/o/r/main/lib.lua", githost.RawURL("o", "r", "main", "lib.lua"))
+	assert.Equal(t, githost.DefaultAPIBaseURL, githost.APIBaseURL())
+}
+
+func TestSelfHostedHost(t *testing.T) {
+	t.Setenv(githost.HostEnv, "git.internal.example.com")
+	t.Setenv(githost.APIURLEnv, "")
+
+	assert.False(t, githost.IsDefault())
+	assert.NoError(t, githost.Validate())
+	assert.Equal(t, "git.internal.example.com", githost.Host())
+	assert.True(t, githost.Matches("git.internal.example.com"))
+	assert.False(t, githost.Matches("github.com"), "github.com is an ordinary web host once another host is configured")
+	assert.False(t, githost.IsRawHost("raw.githubusercontent.com"))
+	assert.Equal(t, "https://git.internal.example.com/o/r/raw/main/lib.lua", githost.RawURL("o", "r", "main", "lib.lua"))
+	assert.Equal(t, "https://git.internal.example.com/api/v3", githost.APIBaseURL())
+
+	t.Setenv(githost.APIURLEnv, "https://git.internal.example.com/api/v1/")
+	assert.Equal(t, "https://git.internal.example.com/api/v1", githost.APIBaseURL())
+}
+
+func TestHostWithSchemeAndPort(t *testing.T) {
+	t.Setenv(githost.HostEnv, "http://localhost:3000/")
+	t.Setenv(githost.APIURLEnv, "")
+
+	assert.Equal(t, "localhost:3000", githost.Host())
+	assert.Equal(t, "localhost", githost.Hostname())
+	assert.Equal(t, "http://localhost:3000/o/r/raw/v1/lib.lua", githost.RawURL("o", "r", "v1", "lib.lua"))
+	assert.Equal(t, "http://localhost:3000/api/v3", githost.APIBaseURL())
+}
+
+func TestConfigure_EnvironmentTakesPrecedence(t *testing.T) {
+	t.Setenv(githost.HostEnv, "")
+	t.Setenv(githost.APIURLEnv, "")
+	githost.Configure("ghe.example.com", "")
+	defer githost.Configure("", "")
+
+	assert.Equal(t, "ghe.example.com", githost.Host())
+	t.Setenv(githost.HostEnv, "git.internal.example.com")
+	assert.Equal(t, "git.internal.example.com", githost.Host())
+}
+
+func TestTrusted(t *testing.T) {
+	t.Setenv(githost.HostEnv, "")
+	t.Setenv(githost.APIURLEnv, "")
+	defer githost.Configure("", "")
+
+	assert.True(t, githost.Trusted(), "github.com is trusted")
+	githost.Configure("attacker.example", "")
+	assert.False(t, githost.Trusted(), "a host set only in project.toml is not trusted")
+	t.Setenv(githost.HostEnv, "attacker.example")
+	assert.True(t, githost.Trusted())
+
+	t.Setenv(githost.HostEnv, "")
+	githost.Configure("", "https://attacker.example/api")
+	assert.False(t, githost.Trusted(), "neither is an API endpoint set only in project.toml")
+	t.Setenv(githost.APIURLEnv, "https://api.example.com")
+	assert.True(t, githost.Trusted())
+}
+
+func TestValidate_InvalidHost(t *testing.T) {
+	t.Setenv(githost.HostEnv, "https://")
+	assert.ErrorContains(t, githost.Validate(), "invalid GitHub host")
+}
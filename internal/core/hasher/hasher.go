@@ -1,20 +1,140 @@
 package hasher
 
 import (
+	"crypto/sha1"
 	"crypto/sha256"
+	"crypto/sha512"
 	"encoding/hex"
 	"fmt"
+	"hash"
+	"path"
+	"strings"
 )
 
+// Supported hash algorithms. A content hash is written as "<algorithm>:<hex_hash>".
+const (
+	SHA256 = "sha256"
+	SHA512 = "sha512"
+)
+
+// DefaultAlgorithm is the algorithm used for content hashes that have no earlier hash to match.
+// Hashes already recorded in almd-lock.toml keep their own algorithm when re-verified, so
+// changing the default does not invalidate existing lockfiles.
+var DefaultAlgorithm = SHA256
+
+// newHash returns a fresh hash.Hash for algo.
+func newHash(algo string) (hash.Hash, error) {
+	switch algo {
+	case SHA256:
+		return sha256.New(), nil
+	case SHA512:
+		return sha512.New(), nil
+	default:
+		return nil, fmt.Errorf("unsupported hash algorithm '%s'", algo)
+	}
+}
+
+// Calculate computes the algo hash of the given content and returns it in the format
+// "<algo>:<hex_hash>".
+func Calculate(content []byte, algo string) (string, error) {
+	hasher, err := newHash(algo)
+	if err != nil {
+		return "", err
+	}
+	_, err = hasher.Write(content) // Capture potential error from Write, though rare for byte slices
+	if err != nil {
+		return "", fmt.Errorf("failed to write content to hasher: %w", err)
+	}
+	hashString := hex.EncodeToString(hasher.Sum(nil))
+	return fmt.Sprintf("%s:%s", algo, hashString), nil
+}
+
 // CalculateSHA256 computes the SHA256 hash of the given content
 // and returns it in the format "sha256:<hex_hash>".
 func CalculateSHA256(content []byte) (string, error) {
-	hasher := sha256.New()
-	_, err := hasher.Write(content) // Capture potential error from Write, though rare for byte slices
+	return Calculate(content, SHA256)
+}
+
+// GitBlobSHA returns the SHA git assigns to content as a blob object, the hex SHA-1 of
+// "blob <size>\x00" followed by content. It matches the sha GitHub's trees API lists for a file.
+func GitBlobSHA(content []byte) string {
+	h := sha1.New()
+	_, _ = fmt.Fprintf(h, "blob %d\x00", len(content))
+	_, _ = h.Write(content)
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// Algorithm returns the algorithm prefix of a content hash such as "sha512:<hex_hash>", and
+// false when the hash does not start with a supported algorithm.
+func Algorithm(contentHash string) (string, bool) {
+	algo, _, found := strings.Cut(contentHash, ":")
+	if !found {
+		return "", false
+	}
+	if _, err := newHash(algo); err != nil {
+		return "", false
+	}
+	return algo, true
+}
+
+// AlgorithmFor returns the algorithm of previous when it is a content hash, and
+// DefaultAlgorithm otherwise. Re-hashing with it keeps a recorded hash comparable.
+func AlgorithmFor(previous string) string {
+	if algo, ok := Algorithm(previous); ok {
+		return algo
+	}
+	return DefaultAlgorithm
+}
+
+// Matches reports whether content hashes to expected, using expected's own algorithm.
+func Matches(content []byte, expected string) (bool, error) {
+	algo, ok := Algorithm(expected)
+	if !ok {
+		return false, fmt.Errorf("'%s' is not a supported content hash", expected)
+	}
+	actual, err := Calculate(content, algo)
 	if err != nil {
-		return "", fmt.Errorf("failed to write content to hasher: %w", err)
+		return false, err
+	}
+	return actual == expected, nil
+}
+
+// ParseChecksumFile extracts a SHA256 hash from the contents of a published checksum file and
+// returns it in the format "sha256:<hex_hash>". Both a bare hash and sha256sum output
+// ("<hash>  <filename>", one file per line) are accepted; for multi-line files the line naming
+// filename is used.
+func ParseChecksumFile(data []byte, filename string) (string, error) {
+	var entries [][]string
+	for _, line := range strings.Split(string(data), "\n") {
+		fields := strings.Fields(line)
+		if len(fields) == 0 || strings.HasPrefix(fields[0], "#") {
+			continue
+		}
+		entries = append(entries, fields)
+	}
+
+	var hexHash string
+	switch {
+	case len(entries) == 0:
+		return "", fmt.Errorf("checksum file is empty")
+	case len(entries) == 1:
+		hexHash = entries[0][0]
+	default:
+		for _, fields := range entries {
+			// sha256sum marks binary-mode entries with a leading '*' on the filename.
+			if len(fields) > 1 && path.Base(strings.TrimPrefix(fields[1], "*")) == filename {
+				hexHash = fields[0]
+				break
+			}
+		}
+		if hexHash == "" {
+			return "", fmt.Errorf("checksum file has no entry for '%s'", filename)
+		}
+	}
+
+	decoded, err := hex.DecodeString(hexHash)
+	if err != nil || len(decoded) != sha256.Size {
+		return "", fmt.Errorf("'%s' is not a SHA256 hash", hexHash)
 	}
-	hashBytes := hasher.Sum(nil)
-	hashString := hex.EncodeToString(hashBytes)
-	return fmt.Sprintf("sha256:%s", hashString), nil
+	return fmt.Sprintf("sha256:%s", strings.ToLower(hexHash)), nil
 }
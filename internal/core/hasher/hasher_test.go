@@ -52,3 +52,76 @@ func TestCalculateSHA256_DifferentContent(t *testing.T) {
 
 	assert.NotEqual(t, actualHash1, actualHash2, "Hashes for different content should not be the same")
 }
+
+func TestCalculate_Algorithms(t *testing.T) {
+	t.Parallel()
+	content := []byte("Hello, Almandine!")
+
+	sha256Hash, err := hasher.Calculate(content, hasher.SHA256)
+	require.NoError(t, err)
+	assert.Equal(t, "sha256:94115f449b029dd58934f8f40187377d739c16b9e26231fb8478b57774674d27", sha256Hash)
+
+	sha512Hash, err := hasher.Calculate(content, hasher.SHA512)
+	require.NoError(t, err)
+	assert.Equal(t, "sha512:b4ddd66162db0c914c34180a8b02d48c00bb074fe4eb511dec9b4aebc2ec2183bb657c0d45e6125fb3cb7e103b8027543eb8ee8e441d6d62dbbb4a32b38106f2", sha512Hash)
+
+	_, err = hasher.Calculate(content, "md5")
+	assert.ErrorContains(t, err, "unsupported hash algorithm 'md5'")
+}
+
+func TestAlgorithmAndMatches(t *testing.T) {
+	t.Parallel()
+	content := []byte("Hello, Almandine!")
+	sha512Hash, err := hasher.Calculate(content, hasher.SHA512)
+	require.NoError(t, err)
+
+	algo, ok := hasher.Algorithm(sha512Hash)
+	assert.True(t, ok)
+	assert.Equal(t, hasher.SHA512, algo)
+	_, ok = hasher.Algorithm("commit:abc123")
+	assert.False(t, ok, "a commit hash has no content algorithm")
+
+	assert.Equal(t, hasher.SHA512, hasher.AlgorithmFor(sha512Hash), "a recorded hash keeps its algorithm")
+	assert.Equal(t, hasher.DefaultAlgorithm, hasher.AlgorithmFor("commit:abc123"))
+
+	match, err := hasher.Matches(content, sha512Hash)
+	require.NoError(t, err)
+	assert.True(t, match)
+	match, err = hasher.Matches([]byte("other"), sha512Hash)
+	require.NoError(t, err)
+	assert.False(t, match)
+	_, err = hasher.Matches(content, "commit:abc123")
+	assert.Error(t, err)
+}
+
+func TestParseChecksumFile(t *testing.T) {
+	t.Parallel()
+	const emptyHash = "e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855"
+	const otherHash = "94115f449b029dd58934f8f40187377d739c16b9e26231fb8478b57774674d27"
+
+	cases := map[string]string{
+		"bare hash":              emptyHash + "\n",
+		"sha256sum single entry": emptyHash + "  lib.lua\n",
+		"uppercase hex":          "E3B0C44298FC1C149AFBF4C8996FB92427AE41E4649B934CA495991B7852B855",
+		"multi-file picks name":  otherHash + "  other.lua\n" + emptyHash + " *dist/lib.lua\n",
+	}
+	for name, data := range cases {
+		got, err := hasher.ParseChecksumFile([]byte(data), "lib.lua")
+		require.NoError(t, err, name)
+		assert.Equal(t, "sha256:"+emptyHash, got, name)
+	}
+
+	_, err := hasher.ParseChecksumFile([]byte(otherHash+"  a.lua\n"+otherHash+"  b.lua\n"), "lib.lua")
+	assert.ErrorContains(t, err, "no entry for 'lib.lua'")
+	_, err = hasher.ParseChecksumFile([]byte("deadbeef  lib.lua\n"), "lib.lua")
+	assert.ErrorContains(t, err, "is not a SHA256 hash")
+	_, err = hasher.ParseChecksumFile([]byte("\n\n"), "lib.lua")
+	assert.ErrorContains(t, err, "empty")
+}
+
+func TestGitBlobSHA(t *testing.T) {
+	t.Parallel()
+	// Values from 'git hash-object'.
+	assert.Equal(t, "e69de29bb2d1d6434b8b29ae775ad8c2e48c5391", hasher.GitBlobSHA(nil))
+	assert.Equal(t, "ce013625030ba8dba906f756967f9e9ca394464a", hasher.GitBlobSHA([]byte("hello\n")))
+}
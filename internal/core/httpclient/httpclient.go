@@ -0,0 +1,123 @@
+// Package httpclient builds the HTTP client shared by the downloader and the GitHub API client,
+// so that a server which stops responding cannot make almd wait forever.
+package httpclient
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// TimeoutEnv overrides DefaultTimeout. It takes a Go duration ("45s", "2m") or a number of
+// seconds; "0" disables the timeout.
+const TimeoutEnv = "ALMD_HTTP_TIMEOUT"
+
+// DefaultTimeout bounds how long a request may go without hearing from the server, unless
+// TimeoutEnv is set. Tests lower it to make slow servers fail quickly.
+var DefaultTimeout = 30 * time.Second
+
+// Timeout returns the request timeout in effect: TimeoutEnv if it holds a valid, non-negative
+// value, and DefaultTimeout otherwise. Zero means no timeout.
+func Timeout() time.Duration {
+	env := strings.TrimSpace(os.Getenv(TimeoutEnv))
+	if env == "" {
+		return DefaultTimeout
+	}
+	if seconds, err := strconv.ParseFloat(env, 64); err == nil {
+		if seconds < 0 {
+			return DefaultTimeout
+		}
+		return time.Duration(seconds * float64(time.Second))
+	}
+	if d, err := time.ParseDuration(env); err == nil && d >= 0 {
+		return d
+	}
+	return DefaultTimeout
+}
+
+// New returns a client that gives up once the server has been silent for Timeout: while
+// connecting, waiting for the response headers, or between reads of the response body. A large
+// download that keeps arriving is never cut off, however long it takes. Clients share
+// http.DefaultTransport, so creating one per request still reuses connections.
+func New() *http.Client {
+	timeout := Timeout()
+	if timeout == 0 {
+		return &http.Client{}
+	}
+	return &http.Client{Transport: &idleTimeoutTransport{base: http.DefaultTransport, timeout: timeout}}
+}
+
+// timeoutError reports a server that stopped responding. Its Timeout method lets callers treat
+// it like any other network timeout.
+type timeoutError struct {
+	timeout time.Duration
+}
+
+func (e *timeoutError) Error() string {
+	return fmt.Sprintf("timeout: no response from server for %s", e.timeout)
+}
+
+func (e *timeoutError) Timeout() bool   { return true }
+func (e *timeoutError) Temporary() bool { return true }
+
+// idleTimeoutTransport cancels a request once it has waited timeout for the server, restarting
+// the wait each time part of the response body arrives.
+type idleTimeoutTransport struct {
+	base    http.RoundTripper
+	timeout time.Duration
+}
+
+func (t *idleTimeoutTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	ctx, cancel := context.WithCancel(req.Context())
+	body := &idleTimeoutBody{timeout: t.timeout, cancel: cancel}
+	body.timer = time.AfterFunc(t.timeout, body.expire)
+
+	resp, err := t.base.RoundTrip(req.WithContext(ctx))
+	if err != nil {
+		body.timer.Stop()
+		cancel()
+		if body.expired.Load() {
+			return nil, &timeoutError{timeout: t.timeout}
+		}
+		return nil, err
+	}
+	body.ReadCloser = resp.Body
+	resp.Body = body
+	return resp, nil
+}
+
+// idleTimeoutBody restarts its transport's timer on every read and reports a timeout, rather than
+// the bare cancellation, once the timer has fired.
+type idleTimeoutBody struct {
+	io.ReadCloser
+	timeout time.Duration
+	timer   *time.Timer
+	cancel  context.CancelFunc
+	expired atomic.Bool
+}
+
+func (b *idleTimeoutBody) expire() {
+	b.expired.Store(true)
+	b.cancel()
+}
+
+func (b *idleTimeoutBody) Read(p []byte) (int, error) {
+	n, err := b.ReadCloser.Read(p)
+	if b.expired.Load() {
+		return n, &timeoutError{timeout: b.timeout}
+	}
+	b.timer.Reset(b.timeout)
+	return n, err
+}
+
+func (b *idleTimeoutBody) Close() error {
+	b.timer.Stop()
+	b.cancel()
+	return b.ReadCloser.Close()
+}
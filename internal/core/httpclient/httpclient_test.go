@@ -0,0 +1,96 @@
+package httpclient
+
+import (
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestTimeout(t *testing.T) {
+	tests := map[string]time.Duration{
+		"":        DefaultTimeout,
+		"45s":     45 * time.Second,
+		"2m":      2 * time.Minute,
+		"10":      10 * time.Second,
+		"0.5":     500 * time.Millisecond,
+		"0":       0,
+		" 5s ":    5 * time.Second,
+		"-1":      DefaultTimeout,
+		"-5s":     DefaultTimeout,
+		"forever": DefaultTimeout,
+	}
+	for env, want := range tests {
+		t.Run(env, func(t *testing.T) {
+			t.Setenv(TimeoutEnv, env)
+			assert.Equal(t, want, Timeout())
+		})
+	}
+}
+
+func TestTimeout_DefaultCanBeChanged(t *testing.T) {
+	t.Setenv(TimeoutEnv, "")
+	original := DefaultTimeout
+	DefaultTimeout = time.Second
+	defer func() { DefaultTimeout = original }()
+
+	assert.Equal(t, time.Second, Timeout())
+}
+
+func TestNew_IdleTimeout(t *testing.T) {
+	t.Setenv(TimeoutEnv, "100ms")
+
+	t.Run("a slow download that keeps arriving completes", func(t *testing.T) {
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			for i := 0; i < 5; i++ {
+				_, _ = w.Write([]byte("chunk "))
+				w.(http.Flusher).Flush()
+				time.Sleep(40 * time.Millisecond)
+			}
+		}))
+		defer server.Close()
+
+		resp, err := New().Get(server.URL)
+		require.NoError(t, err)
+		defer resp.Body.Close()
+		body, err := io.ReadAll(resp.Body)
+		require.NoError(t, err, "200ms in total is longer than the timeout, but no gap is")
+		assert.Equal(t, strings.Repeat("chunk ", 5), string(body))
+	})
+
+	t.Run("a body that stalls times out", func(t *testing.T) {
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			_, _ = w.Write([]byte("partial"))
+			w.(http.Flusher).Flush()
+			<-r.Context().Done()
+		}))
+		defer server.Close()
+
+		resp, err := New().Get(server.URL)
+		require.NoError(t, err)
+		defer resp.Body.Close()
+		_, err = io.ReadAll(resp.Body)
+		var netErr net.Error
+		require.True(t, errors.As(err, &netErr), "got %v", err)
+		assert.True(t, netErr.Timeout())
+	})
+
+	t.Run("missing response headers time out", func(t *testing.T) {
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			<-r.Context().Done()
+		}))
+		defer server.Close()
+
+		_, err := New().Get(server.URL)
+		var netErr net.Error
+		require.True(t, errors.As(err, &netErr), "got %v", err)
+		assert.True(t, netErr.Timeout())
+	})
+}
@@ -0,0 +1,25 @@
+package lockfile
+
+import "github.com/BurntSushi/toml"
+
+// SetRenamedFieldMigration makes Load upgrade lockfiles at api_version from to APIVersion by
+// copying each package's oldField into Source, as a schema change renaming that field would,
+// until the returned function is called.
+func SetRenamedFieldMigration(from, oldField string) (restore func()) {
+	original := migrations
+	migrations = []migration{{from: from, to: APIVersion, apply: func(lf *Lockfile, md toml.MetaData, packages map[string]toml.Primitive) error {
+		for name, table := range packages {
+			var fields map[string]interface{}
+			if err := md.PrimitiveDecode(table, &fields); err != nil {
+				return err
+			}
+			if old, ok := fields[oldField].(string); ok {
+				entry := lf.Package[name]
+				entry.Source = old
+				lf.Package[name] = entry
+			}
+		}
+		return nil
+	}}}
+	return func() { migrations = original }
+}
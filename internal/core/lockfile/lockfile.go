@@ -1,11 +1,17 @@
 package lockfile
 
 import (
+	"bytes"
 	"fmt"
 	"os"
 	"path/filepath"
+	"strings"
 
 	"github.com/BurntSushi/toml"
+
+	"github.com/nightconcept/almandine-go/internal/core/bom"
+	"github.com/nightconcept/almandine-go/internal/core/fsutil"
+	"github.com/nightconcept/almandine-go/internal/core/hasher"
 )
 
 const LockfileName = "almd-lock.toml"
@@ -17,17 +23,73 @@ const APIVersion = "1"
 //
 //	source = "exact raw download URL"
 //	path = "relative/path/to/file.ext"
-//	hash = "sha256:<hash_value>" or "commit:<commit_hash>"
+//	hash = "sha256:<hash_value>", "sha512:<hash_value>" or "commit:<commit_hash>"
+//	content_hash = "sha256:<hash_value>" or "sha512:<hash_value>" (optional)
+//	requested = "source exactly as given to almd add" (optional)
+//	tag = "v1.4.2", the tag a version range resolved to (optional)
+//	group = "dev" for dependencies from [dev-dependencies] (optional)
+//	etag = "ETag the server sent with the file" (optional)
+//	last_modified = "Last-Modified the server sent with the file" (optional)
+//	blob = "git blob SHA of the file" (optional)
 type PackageEntry struct {
 	Source string `toml:"source"`
 	Path   string `toml:"path"`
 	Hash   string `toml:"hash"`
+	// ContentHash is the content hash of the bytes written to Path. It is recorded when Hash pins a
+	// commit rather than content, so the file on disk can still be checked for tampering.
+	ContentHash string `toml:"content_hash,omitempty"`
+	// Requested is metadata only: the source argument as the user typed it into 'almd add',
+	// kept to help trace how Source was derived. It plays no part in installs.
+	Requested string `toml:"requested,omitempty"`
+	// Tag is the release tag picked for a source whose ref is a version range (e.g. ^1.2.0);
+	// project.toml keeps the range while Source and Hash pin the tag's commit.
+	Tag string `toml:"tag,omitempty"`
+	// Group is "dev" for a dependency declared in [dev-dependencies] and empty otherwise, so
+	// the lockfile alone tells which files a production install leaves out.
+	Group string `toml:"group,omitempty"`
+	// ETag and LastModified are the HTTP cache validators sent with the file at Source. Installs
+	// send them back so an unchanged file is answered with 304 Not Modified instead of its content.
+	ETag         string `toml:"etag,omitempty"`
+	LastModified string `toml:"last_modified,omitempty"`
+	// Blob is the git blob SHA of the file, recorded for files added from a repository
+	// directory with 'almd add --recursive', whose listing gives each file's blob SHA.
+	Blob string `toml:"blob,omitempty"`
+}
+
+// ExpectedContentHash returns the content hash the file at Path should have: Hash itself, or
+// for entries pinned by commit, the ContentHash recorded alongside it. Any algorithm the
+// hasher package supports is accepted.
+func (e PackageEntry) ExpectedContentHash() (string, bool) {
+	if _, ok := hasher.Algorithm(e.Hash); ok {
+		return e.Hash, true
+	}
+	if _, ok := hasher.Algorithm(e.ContentHash); ok {
+		return e.ContentHash, true
+	}
+	return "", false
+}
+
+// CheckContent reports an error if content hashing to contentHash cannot be what the entry
+// locked under integrityHash. Only a "commit:" hash equal to the entry's own, with a recorded
+// ContentHash, is checked: the same commit must always yield the same bytes, so a difference
+// means the download was corrupted or the upstream history was rewritten.
+func (e PackageEntry) CheckContent(integrityHash, contentHash string) error {
+	if !strings.HasPrefix(integrityHash, "commit:") || integrityHash != e.Hash || e.ContentHash == "" {
+		return nil
+	}
+	if contentHash != e.ContentHash {
+		return fmt.Errorf("content for %s hashes to %s, but %s recorded %s for the same commit", strings.TrimPrefix(integrityHash, "commit:"), contentHash, LockfileName, e.ContentHash)
+	}
+	return nil
 }
 
 // Lockfile represents the structure of the almd-lock.toml file.
 type Lockfile struct {
 	ApiVersion string                  `toml:"api_version"`
 	Package    map[string]PackageEntry `toml:"package"`
+
+	// migrated is set by Load when it upgraded the file from an older api_version.
+	migrated bool
 }
 
 // New creates a new Lockfile instance with default values.
@@ -39,55 +101,157 @@ func New() *Lockfile {
 }
 
 // Load loads the lockfile from the given project root path.
-// If the lockfile doesn't exist, it returns a new Lockfile instance.
+// If the lockfile doesn't exist, it returns a new Lockfile instance. A lockfile written with an
+// older api_version is migrated to APIVersion (see Migrated); an unknown one is an error.
 func Load(projectRoot string) (*Lockfile, error) {
 	lockfilePath := filepath.Join(projectRoot, LockfileName)
 	lf := New()
 
-	if _, err := os.Stat(lockfilePath); os.IsNotExist(err) {
+	data, err := os.ReadFile(lockfilePath)
+	if os.IsNotExist(err) {
 		return lf, nil // Return a new lockfile if it doesn't exist
 	} else if err != nil {
-		return nil, fmt.Errorf("failed to stat lockfile %s: %w", lockfilePath, err)
+		return nil, fmt.Errorf("failed to read lockfile %s: %w", lockfilePath, err)
+	}
+	if data, err = bom.Strip(LockfileName, data); err != nil {
+		return nil, err
 	}
 
-	if _, err := toml.DecodeFile(lockfilePath, &lf); err != nil {
+	// The file is parsed once; package tables stay undecoded until the entries are filled in, so
+	// a migration can still read fields the current schema no longer has.
+	var raw struct {
+		ApiVersion string                    `toml:"api_version"`
+		Package    map[string]toml.Primitive `toml:"package"`
+	}
+	md, err := toml.Decode(string(data), &raw)
+	if err != nil {
 		return nil, fmt.Errorf("failed to decode lockfile %s: %w", lockfilePath, err)
 	}
-	// Ensure API version is present, even if file was empty or had it missing
-	if lf.ApiVersion == "" {
-		lf.ApiVersion = APIVersion
+	// A missing api_version means the current one.
+	if raw.ApiVersion != "" {
+		lf.ApiVersion = raw.ApiVersion
 	}
-	// Ensure Packages map is initialized
-	if lf.Package == nil {
-		lf.Package = make(map[string]PackageEntry)
+	for name, table := range raw.Package {
+		var entry PackageEntry
+		if err := md.PrimitiveDecode(table, &entry); err != nil {
+			return nil, fmt.Errorf("failed to decode lockfile %s: package %q: %w", lockfilePath, name, err)
+		}
+		lf.Package[name] = entry
+	}
+	if err := migrate(lf, md, raw.Package); err != nil {
+		return nil, fmt.Errorf("lockfile %s: %w", lockfilePath, err)
 	}
 	return lf, nil
 }
 
-// Save saves the lockfile to the given project root path.
+// Save saves the lockfile to the given project root path. The file is replaced atomically, so
+// an interrupted save leaves the previous lockfile intact.
 func Save(projectRoot string, lf *Lockfile) error {
 	lockfilePath := filepath.Join(projectRoot, LockfileName)
-	file, err := os.Create(lockfilePath)
-	if err != nil {
-		return fmt.Errorf("failed to create/truncate lockfile %s: %w", lockfilePath, err)
-	}
-	defer func() { _ = file.Close() }()
-
-	encoder := toml.NewEncoder(file)
-	if err := encoder.Encode(lf); err != nil {
+	buf := new(bytes.Buffer)
+	if err := toml.NewEncoder(buf).Encode(lf); err != nil {
 		return fmt.Errorf("failed to encode lockfile %s: %w", lockfilePath, err)
 	}
+	if err := fsutil.WriteFileAtomic(lockfilePath, buf.Bytes(), 0644); err != nil {
+		return fmt.Errorf("failed to write lockfile %s: %w", lockfilePath, err)
+	}
 	return nil
 }
 
 // AddOrUpdatePackage adds or updates a package entry in the lockfile.
+// The Requested and Group fields of an existing entry are preserved; its ContentHash, Tag,
+// cache validators and Blob are cleared, since they describe the previous file. Use
+// SetContentHash, SetTag, SetValidators and SetBlob to record the new ones.
 func (lf *Lockfile) AddOrUpdatePackage(name, rawURL, relativePath, integrityHash string) {
 	if lf.Package == nil {
 		lf.Package = make(map[string]PackageEntry)
 	}
 	lf.Package[name] = PackageEntry{
-		Source: rawURL,
-		Path:   relativePath,
-		Hash:   integrityHash,
+		Source:    rawURL,
+		Path:      relativePath,
+		Hash:      integrityHash,
+		Requested: lf.Package[name].Requested,
+		Group:     lf.Package[name].Group,
+	}
+}
+
+// SetGroup records the dependency group ("dev" or "") of an existing entry. It is a no-op if
+// name is not in the lockfile.
+func (lf *Lockfile) SetGroup(name, group string) {
+	entry, ok := lf.Package[name]
+	if !ok {
+		return
+	}
+	entry.Group = group
+	lf.Package[name] = entry
+}
+
+// SetPath records the path an existing entry is installed at. It is a no-op if name is not in
+// the lockfile.
+func (lf *Lockfile) SetPath(name, relativePath string) {
+	entry, ok := lf.Package[name]
+	if !ok {
+		return
+	}
+	entry.Path = relativePath
+	lf.Package[name] = entry
+}
+
+// SetContentHash records the content hash of the file written for an existing entry. A hash
+// equal to the entry's Hash is not stored twice. It is a no-op if name is not in the lockfile.
+func (lf *Lockfile) SetContentHash(name, contentHash string) {
+	entry, ok := lf.Package[name]
+	if !ok {
+		return
+	}
+	if contentHash == entry.Hash {
+		contentHash = ""
+	}
+	entry.ContentHash = contentHash
+	lf.Package[name] = entry
+}
+
+// SetTag records the tag a version range resolved to for an existing entry. It is a no-op if
+// name is not in the lockfile.
+func (lf *Lockfile) SetTag(name, tag string) {
+	entry, ok := lf.Package[name]
+	if !ok {
+		return
+	}
+	entry.Tag = tag
+	lf.Package[name] = entry
+}
+
+// SetValidators records the HTTP cache validators sent with the file of an existing entry.
+// It is a no-op if name is not in the lockfile.
+func (lf *Lockfile) SetValidators(name, etag, lastModified string) {
+	entry, ok := lf.Package[name]
+	if !ok {
+		return
+	}
+	entry.ETag = etag
+	entry.LastModified = lastModified
+	lf.Package[name] = entry
+}
+
+// SetBlob records the git blob SHA of the file written for an existing entry. It is a no-op
+// if name is not in the lockfile.
+func (lf *Lockfile) SetBlob(name, blobSHA string) {
+	entry, ok := lf.Package[name]
+	if !ok {
+		return
+	}
+	entry.Blob = blobSHA
+	lf.Package[name] = entry
+}
+
+// SetRequested records the source as originally requested by the user for an existing entry.
+// It is a no-op if name is not in the lockfile.
+func (lf *Lockfile) SetRequested(name, requested string) {
+	entry, ok := lf.Package[name]
+	if !ok {
+		return
 	}
+	entry.Requested = requested
+	lf.Package[name] = entry
 }
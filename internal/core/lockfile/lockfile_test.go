@@ -4,6 +4,7 @@ package lockfile_test
 import (
 	"os"
 	"path/filepath"
+	"strings"
 	"testing"
 
 	"github.com/stretchr/testify/assert"
@@ -71,6 +72,30 @@ func TestLoadLockfile_InvalidToml(t *testing.T) {
 	assert.Contains(t, err.Error(), "failed to decode lockfile", "Error message mismatch")
 }
 
+func TestLoadLockfile_ByteOrderMark(t *testing.T) {
+	lockToml := "api_version = \"1\"\n\n[package.json]\nsource = \"https://example.com/json.lua\"\npath = \"libs/json.lua\"\nhash = \"sha256:abcd\"\n"
+
+	t.Run("UTF-8 BOM is ignored", func(t *testing.T) {
+		tempDir := t.TempDir()
+		content := append([]byte{0xEF, 0xBB, 0xBF}, lockToml...)
+		require.NoError(t, os.WriteFile(filepath.Join(tempDir, lockfile.LockfileName), content, 0644))
+
+		lf, err := lockfile.Load(tempDir)
+		require.NoError(t, err)
+		assert.Equal(t, "sha256:abcd", lf.Package["json"].Hash)
+	})
+
+	t.Run("UTF-16 is rejected with a clear error", func(t *testing.T) {
+		tempDir := t.TempDir()
+		content := []byte{0xFE, 0xFF, 0x00, 'a'}
+		require.NoError(t, os.WriteFile(filepath.Join(tempDir, lockfile.LockfileName), content, 0644))
+
+		_, err := lockfile.Load(tempDir)
+		require.Error(t, err)
+		assert.Contains(t, err.Error(), "almd-lock.toml is saved as UTF-16 (big-endian); save it as UTF-8 (without BOM)")
+	})
+}
+
 func TestLoadLockfile_EmptyFile(t *testing.T) {
 	t.Parallel()
 	tempDir := t.TempDir()
@@ -188,3 +213,261 @@ func TestAddOrUpdatePackage_NilMap(t *testing.T) {
 	require.Contains(t, lf.Package, "libC")
 	assert.Equal(t, "urlC", lf.Package["libC"].Source)
 }
+
+func TestSetRequested_PreservedAcrossUpdates(t *testing.T) {
+	t.Parallel()
+	lf := lockfile.New()
+
+	lf.SetRequested("missing", "ignored") // no entry yet: no-op
+	assert.NotContains(t, lf.Package, "missing")
+
+	lf.AddOrUpdatePackage("libA", "urlA", "pathA", "hashA")
+	lf.SetRequested("libA", "github:owner/repo/libA.lua@main")
+	assert.Equal(t, "github:owner/repo/libA.lua@main", lf.Package["libA"].Requested)
+
+	lf.AddOrUpdatePackage("libA", "urlA2", "pathA", "hashA2")
+	assert.Equal(t, "github:owner/repo/libA.lua@main", lf.Package["libA"].Requested, "updates must keep the requested source")
+}
+
+func TestSaveLockfile_RequestedOmittedWhenEmpty(t *testing.T) {
+	t.Parallel()
+	tempDir := t.TempDir()
+	lf := lockfile.New()
+	lf.AddOrUpdatePackage("plain", "url", "path", "hash")
+	lf.AddOrUpdatePackage("traced", "url", "path", "hash")
+	lf.SetRequested("traced", "https://example.com/x.lua")
+
+	require.NoError(t, lockfile.Save(tempDir, lf))
+	content, err := os.ReadFile(filepath.Join(tempDir, lockfile.LockfileName))
+	require.NoError(t, err)
+	assert.Equal(t, 1, strings.Count(string(content), "requested ="), "only entries with a requested source should write the field")
+
+	loaded, err := lockfile.Load(tempDir)
+	require.NoError(t, err)
+	assert.Equal(t, "https://example.com/x.lua", loaded.Package["traced"].Requested)
+	assert.Empty(t, loaded.Package["plain"].Requested)
+}
+
+func TestSetContentHash(t *testing.T) {
+	t.Parallel()
+	tempDir := t.TempDir()
+	lf := lockfile.New()
+
+	lf.SetContentHash("missing", "sha256:aa") // no entry yet: no-op
+	assert.NotContains(t, lf.Package, "missing")
+
+	lf.AddOrUpdatePackage("pinned", "url", "path", "commit:abc123")
+	lf.SetContentHash("pinned", "sha256:aa")
+	lf.AddOrUpdatePackage("hashed", "url", "path", "sha256:bb")
+	lf.SetContentHash("hashed", "sha256:bb")
+	assert.Empty(t, lf.Package["hashed"].ContentHash, "a content hash equal to hash is not repeated")
+
+	require.NoError(t, lockfile.Save(tempDir, lf))
+	content, err := os.ReadFile(filepath.Join(tempDir, lockfile.LockfileName))
+	require.NoError(t, err)
+	assert.Equal(t, 1, strings.Count(string(content), "content_hash ="), "content_hash is omitted when empty")
+
+	loaded, err := lockfile.Load(tempDir)
+	require.NoError(t, err)
+	assert.Equal(t, "sha256:aa", loaded.Package["pinned"].ContentHash)
+
+	loaded.AddOrUpdatePackage("pinned", "url2", "path", "commit:def456")
+	assert.Empty(t, loaded.Package["pinned"].ContentHash, "updating an entry drops the previous file's content hash")
+}
+
+func TestExpectedContentHash(t *testing.T) {
+	t.Parallel()
+	cases := map[string]struct {
+		entry    lockfile.PackageEntry
+		expected string
+	}{
+		"sha256 hash":           {lockfile.PackageEntry{Hash: "sha256:aa"}, "sha256:aa"},
+		"sha512 hash":           {lockfile.PackageEntry{Hash: "sha512:bb"}, "sha512:bb"},
+		"commit with content":   {lockfile.PackageEntry{Hash: "commit:abc123", ContentHash: "sha512:cc"}, "sha512:cc"},
+		"commit only":           {lockfile.PackageEntry{Hash: "commit:abc123"}, ""},
+		"unsupported algorithm": {lockfile.PackageEntry{Hash: "md5:dd"}, ""},
+	}
+	for name, tc := range cases {
+		got, ok := tc.entry.ExpectedContentHash()
+		assert.Equal(t, tc.expected != "", ok, name)
+		assert.Equal(t, tc.expected, got, name)
+	}
+}
+
+func TestSetTag(t *testing.T) {
+	t.Parallel()
+	tempDir := t.TempDir()
+	lf := lockfile.New()
+
+	lf.SetTag("missing", "v1.0.0") // no entry yet: no-op
+	assert.NotContains(t, lf.Package, "missing")
+
+	lf.AddOrUpdatePackage("ranged", "url", "path", "commit:abc123")
+	lf.SetTag("ranged", "v1.4.2")
+	lf.AddOrUpdatePackage("branch", "url", "path", "commit:def456")
+
+	require.NoError(t, lockfile.Save(tempDir, lf))
+	content, err := os.ReadFile(filepath.Join(tempDir, lockfile.LockfileName))
+	require.NoError(t, err)
+	assert.Equal(t, 1, strings.Count(string(content), "tag ="), "tag is omitted when empty")
+
+	loaded, err := lockfile.Load(tempDir)
+	require.NoError(t, err)
+	assert.Equal(t, "v1.4.2", loaded.Package["ranged"].Tag)
+
+	loaded.AddOrUpdatePackage("ranged", "url2", "path", "commit:fff000")
+	assert.Empty(t, loaded.Package["ranged"].Tag, "updating an entry drops the previous tag")
+}
+
+func TestSetGroup(t *testing.T) {
+	t.Parallel()
+	lf := lockfile.New()
+
+	lf.SetGroup("missing", "dev") // no entry yet: no-op
+	assert.NotContains(t, lf.Package, "missing")
+
+	lf.AddOrUpdatePackage("helper", "url", "path", "commit:abc123")
+	lf.SetGroup("helper", "dev")
+	lf.AddOrUpdatePackage("helper", "url2", "path", "commit:def456")
+	assert.Equal(t, "dev", lf.Package["helper"].Group, "updating an entry keeps its group")
+}
+
+func TestSetValidators(t *testing.T) {
+	t.Parallel()
+	tempDir := t.TempDir()
+	lf := lockfile.New()
+
+	lf.SetValidators("missing", `"abc"`, "") // no entry yet: no-op
+	assert.NotContains(t, lf.Package, "missing")
+
+	lf.AddOrUpdatePackage("plain", "url", "path", "sha256:aa")
+	lf.AddOrUpdatePackage("cached", "url", "path", "sha256:bb")
+	lf.SetValidators("cached", `"abc"`, "Wed, 21 Oct 2015 07:28:00 GMT")
+
+	require.NoError(t, lockfile.Save(tempDir, lf))
+	content, err := os.ReadFile(filepath.Join(tempDir, lockfile.LockfileName))
+	require.NoError(t, err)
+	assert.Equal(t, 1, strings.Count(string(content), "etag ="), "etag is omitted when empty")
+	assert.Equal(t, 1, strings.Count(string(content), "last_modified ="), "last_modified is omitted when empty")
+
+	loaded, err := lockfile.Load(tempDir)
+	require.NoError(t, err)
+	assert.Equal(t, `"abc"`, loaded.Package["cached"].ETag)
+	assert.Equal(t, "Wed, 21 Oct 2015 07:28:00 GMT", loaded.Package["cached"].LastModified)
+	assert.Empty(t, loaded.Package["plain"].ETag)
+
+	loaded.AddOrUpdatePackage("cached", "url2", "path", "sha256:cc")
+	assert.Empty(t, loaded.Package["cached"].ETag, "updating an entry drops the previous file's validators")
+	assert.Empty(t, loaded.Package["cached"].LastModified)
+}
+
+func TestSetBlob(t *testing.T) {
+	t.Parallel()
+	tempDir := t.TempDir()
+	lf := lockfile.New()
+
+	lf.SetBlob("missing", "b1") // no entry yet: no-op
+	assert.NotContains(t, lf.Package, "missing")
+
+	lf.AddOrUpdatePackage("plain", "url", "path", "sha256:aa")
+	lf.AddOrUpdatePackage("listed", "url", "path", "commit:abc")
+	lf.SetBlob("listed", "ce013625030ba8dba906f756967f9e9ca394464a")
+
+	require.NoError(t, lockfile.Save(tempDir, lf))
+	content, err := os.ReadFile(filepath.Join(tempDir, lockfile.LockfileName))
+	require.NoError(t, err)
+	assert.Equal(t, 1, strings.Count(string(content), "blob ="), "blob is omitted when empty")
+
+	loaded, err := lockfile.Load(tempDir)
+	require.NoError(t, err)
+	assert.Equal(t, "ce013625030ba8dba906f756967f9e9ca394464a", loaded.Package["listed"].Blob)
+
+	loaded.AddOrUpdatePackage("listed", "url2", "path", "commit:def")
+	assert.Empty(t, loaded.Package["listed"].Blob, "updating an entry drops the previous file's blob SHA")
+}
+
+func TestPackageEntry_CheckContent(t *testing.T) {
+	t.Parallel()
+	entry := lockfile.PackageEntry{Hash: "commit:abc123", ContentHash: "sha256:aa"}
+
+	assert.NoError(t, entry.CheckContent("commit:abc123", "sha256:aa"))
+	assert.NoError(t, entry.CheckContent("commit:def456", "sha256:bb"), "another commit may have other content")
+	assert.NoError(t, lockfile.PackageEntry{Hash: "commit:abc123"}.CheckContent("commit:abc123", "sha256:bb"), "nothing to compare without a content hash")
+
+	err := entry.CheckContent("commit:abc123", "sha256:bb")
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "sha256:bb")
+	assert.Contains(t, err.Error(), "sha256:aa")
+}
+
+func TestLoadLockfile_MigratesOlderApiVersion(t *testing.T) {
+	restore := lockfile.SetRenamedFieldMigration("0", "url")
+	defer restore()
+	tempDir := t.TempDir()
+	content := `
+api_version = "0"
+
+[package.mylib]
+  url = "http://example.com/mylib.lua"
+  path = "libs/mylib.lua"
+  hash = "sha256:abcdef123456"
+`
+	require.NoError(t, os.WriteFile(filepath.Join(tempDir, lockfile.LockfileName), []byte(content), 0600))
+
+	lf, err := lockfile.Load(tempDir)
+	require.NoError(t, err)
+	assert.True(t, lf.Migrated())
+	assert.Equal(t, lockfile.APIVersion, lf.ApiVersion)
+	assert.Equal(t, lockfile.PackageEntry{
+		Source: "http://example.com/mylib.lua",
+		Path:   "libs/mylib.lua",
+		Hash:   "sha256:abcdef123456",
+	}, lf.Package["mylib"])
+
+	require.NoError(t, lockfile.Save(tempDir, lf))
+	saved, err := os.ReadFile(filepath.Join(tempDir, lockfile.LockfileName))
+	require.NoError(t, err)
+	assert.Contains(t, string(saved), `api_version = "`+lockfile.APIVersion+`"`)
+	assert.Contains(t, string(saved), `source = "http://example.com/mylib.lua"`)
+	assert.NotContains(t, string(saved), "url =")
+
+	reloaded, err := lockfile.Load(tempDir)
+	require.NoError(t, err)
+	assert.False(t, reloaded.Migrated(), "a lockfile already at the current version needs no migration")
+}
+
+func TestLoadLockfile_UnknownApiVersion(t *testing.T) {
+	t.Parallel()
+	tests := []struct {
+		version string
+		want    string
+	}{
+		{version: "0", want: "older than any version this almd can upgrade"},
+		{version: "2", want: "written by a newer almd"},
+		{version: "banana", want: "this almd reads version " + lockfile.APIVersion},
+	}
+	for _, tt := range tests {
+		tempDir := t.TempDir()
+		content := "api_version = \"" + tt.version + "\"\n"
+		require.NoError(t, os.WriteFile(filepath.Join(tempDir, lockfile.LockfileName), []byte(content), 0600))
+
+		lf, err := lockfile.Load(tempDir)
+		require.Error(t, err, tt.version)
+		assert.Nil(t, lf)
+		assert.Contains(t, err.Error(), `unsupported api_version "`+tt.version+`"`)
+		assert.Contains(t, err.Error(), tt.want)
+	}
+}
+
+func TestLoadLockfile_CurrentApiVersion(t *testing.T) {
+	t.Parallel()
+	for _, content := range []string{"api_version = \"1\"\n", ""} {
+		tempDir := t.TempDir()
+		require.NoError(t, os.WriteFile(filepath.Join(tempDir, lockfile.LockfileName), []byte(content), 0600))
+
+		lf, err := lockfile.Load(tempDir)
+		require.NoError(t, err, content)
+		assert.Equal(t, lockfile.APIVersion, lf.ApiVersion, "a missing api_version is taken to be the current one")
+		assert.False(t, lf.Migrated())
+	}
+}
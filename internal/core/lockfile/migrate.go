@@ -0,0 +1,70 @@
+package lockfile
+
+import (
+	"fmt"
+	"strconv"
+
+	"github.com/BurntSushi/toml"
+)
+
+// migration upgrades a lockfile from one api_version to the next. Fields that were renamed or
+// restructured no longer decode into PackageEntry, so apply also receives each package table
+// undecoded and can read old values from it with md.PrimitiveDecode.
+type migration struct {
+	from, to string
+	apply    func(lf *Lockfile, md toml.MetaData, packages map[string]toml.Primitive) error
+}
+
+// migrations lists, oldest first, the steps that bring an older lockfile up to APIVersion.
+// There are none yet, since "1" is the first version; when APIVersion is bumped, append a step
+// from the previous version so existing lockfiles keep loading.
+var migrations []migration
+
+// migrate brings lf up to APIVersion one step at a time and records whether anything changed,
+// so the next Save writes the current format. A version with no path to APIVersion is an
+// error rather than being read with the wrong schema.
+func migrate(lf *Lockfile, md toml.MetaData, packages map[string]toml.Primitive) error {
+	for lf.ApiVersion != APIVersion {
+		step, ok := migrationFrom(lf.ApiVersion)
+		if !ok {
+			return unsupportedVersion(lf.ApiVersion)
+		}
+		if err := step.apply(lf, md, packages); err != nil {
+			return fmt.Errorf("failed to migrate from api_version %q to %q: %w", step.from, step.to, err)
+		}
+		lf.ApiVersion = step.to
+		lf.migrated = true
+	}
+	return nil
+}
+
+// migrationFrom returns the step that upgrades a lockfile at version.
+func migrationFrom(version string) (migration, bool) {
+	for _, step := range migrations {
+		if step.from == version {
+			return step, true
+		}
+	}
+	return migration{}, false
+}
+
+// unsupportedVersion explains why a lockfile at version cannot be read: it comes from a newer
+// almd, is older than any version this almd can upgrade, or is not a version at all.
+func unsupportedVersion(version string) error {
+	n, err := strconv.Atoi(version)
+	current, _ := strconv.Atoi(APIVersion)
+	switch {
+	case err != nil:
+		return fmt.Errorf("unsupported api_version %q: this almd reads version %s", version, APIVersion)
+	case n > current:
+		return fmt.Errorf("unsupported api_version %q: it was written by a newer almd, which this one (version %s) cannot read; upgrade almd", version, APIVersion)
+	default:
+		return fmt.Errorf("unsupported api_version %q: it is older than any version this almd can upgrade to %s; delete %s and run 'almd install' to recreate it", version, APIVersion, LockfileName)
+	}
+}
+
+// Migrated reports whether Load upgraded the lockfile from an older api_version. Commands that
+// otherwise save only when an entry changes should save it anyway, to write the new format.
+func (lf *Lockfile) Migrated() bool {
+	return lf.migrated
+}
@@ -0,0 +1,63 @@
+package lockfile
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ProcessLockName is the sentinel file that marks a project as being changed by an almd
+// process. It only exists while a command holds the lock.
+const ProcessLockName = ".almd-lock.lock"
+
+// ProcessLockTimeout bounds how long Acquire waits for another process to release the lock.
+var ProcessLockTimeout = 30 * time.Second
+
+// processLockPoll is how often Acquire retries while the lock is held.
+const processLockPoll = 100 * time.Millisecond
+
+// ErrLocked is returned by Acquire when another almd process kept the lock past the timeout.
+var ErrLocked = errors.New("another almd process is running in this project")
+
+// Acquire takes the advisory lock that serializes almd processes changing the project at
+// projectRoot, so their load-modify-save sequences of almd-lock.toml cannot interleave. Every
+// command that rewrites almd-lock.toml (add, remove, prune, rename, install, update and lock)
+// takes it before loading the lockfile, so concurrent processes in one project wait for each
+// other instead of racing, and one never saves over changes the other just made. It waits up
+// to ProcessLockTimeout for a running process to finish. The returned release function must
+// be called on every exit path, typically with defer; calling it more than once is harmless.
+func Acquire(projectRoot string) (release func(), err error) {
+	lockPath := filepath.Join(projectRoot, ProcessLockName)
+	deadline := time.Now().Add(ProcessLockTimeout)
+	for {
+		file, err := os.OpenFile(lockPath, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0644)
+		if err == nil {
+			_, _ = fmt.Fprintf(file, "%d\n", os.Getpid())
+			_ = file.Close()
+			released := false
+			return func() {
+				if !released {
+					released = true
+					_ = os.Remove(lockPath)
+				}
+			}, nil
+		}
+		if !os.IsExist(err) {
+			return nil, fmt.Errorf("failed to create %s: %w", lockPath, err)
+		}
+		if !time.Now().Before(deadline) {
+			holder := "another almd process"
+			if data, readErr := os.ReadFile(lockPath); readErr == nil {
+				if pid, convErr := strconv.Atoi(strings.TrimSpace(string(data))); convErr == nil {
+					holder = fmt.Sprintf("process %d", pid)
+				}
+			}
+			return nil, fmt.Errorf("%w: %s still holds %s after %s; wait for it to finish, or delete the file if no almd process is running", ErrLocked, holder, lockPath, ProcessLockTimeout)
+		}
+		time.Sleep(processLockPoll)
+	}
+}
@@ -0,0 +1,78 @@
+package lockfile_test
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/nightconcept/almandine-go/internal/core/lockfile"
+)
+
+// setLockTimeout shortens ProcessLockTimeout for one test. Tests using it must not run in parallel.
+func setLockTimeout(t *testing.T, timeout time.Duration) {
+	t.Helper()
+	original := lockfile.ProcessLockTimeout
+	lockfile.ProcessLockTimeout = timeout
+	t.Cleanup(func() { lockfile.ProcessLockTimeout = original })
+}
+
+func TestAcquire_TimesOutWhileHeld(t *testing.T) {
+	setLockTimeout(t, 200*time.Millisecond)
+	dir := t.TempDir()
+
+	release, err := lockfile.Acquire(dir)
+	require.NoError(t, err)
+	lockPath := filepath.Join(dir, lockfile.ProcessLockName)
+	assert.FileExists(t, lockPath)
+
+	_, err = lockfile.Acquire(dir)
+	require.ErrorIs(t, err, lockfile.ErrLocked)
+	assert.Contains(t, err.Error(), fmt.Sprintf("process %d", os.Getpid()))
+	assert.Contains(t, err.Error(), lockPath)
+
+	release()
+	release() // a second release is harmless
+	assert.NoFileExists(t, lockPath)
+
+	release, err = lockfile.Acquire(dir)
+	require.NoError(t, err, "the lock can be taken again once released")
+	release()
+}
+
+func TestAcquire_Serializes(t *testing.T) {
+	setLockTimeout(t, 5*time.Second)
+	dir := t.TempDir()
+
+	var mu sync.Mutex
+	active, maxActive := 0, 0
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			release, err := lockfile.Acquire(dir)
+			if !assert.NoError(t, err) {
+				return
+			}
+			defer release()
+			mu.Lock()
+			active++
+			if active > maxActive {
+				maxActive = active
+			}
+			mu.Unlock()
+			time.Sleep(20 * time.Millisecond)
+			mu.Lock()
+			active--
+			mu.Unlock()
+		}()
+	}
+	wg.Wait()
+	assert.Equal(t, 1, maxActive, "only one holder at a time")
+}
@@ -1,10 +1,114 @@
 package project
 
+import (
+	"fmt"
+	"path"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
 // Project represents the overall structure of the project.toml file.
 type Project struct {
 	Package      *PackageInfo          `toml:"package"`
 	Scripts      map[string]string     `toml:"scripts,omitempty"`
 	Dependencies map[string]Dependency `toml:"dependencies,omitempty"`
+	// DevDependencies are needed only to develop the project, such as test helpers. They are
+	// installed by default and skipped by 'almd install --production'.
+	DevDependencies map[string]Dependency `toml:"dev-dependencies,omitempty"`
+	Almd            *AlmdConfig           `toml:"almd,omitempty"`
+}
+
+// GroupDev is the group of dependencies declared in [dev-dependencies]. Dependencies in
+// [dependencies] have no group ("").
+const GroupDev = "dev"
+
+// LookupDependency finds name in [dependencies] or [dev-dependencies] and returns the
+// dependency with its group.
+func (p *Project) LookupDependency(name string) (dep Dependency, group string, ok bool) {
+	if dep, ok := p.Dependencies[name]; ok {
+		return dep, "", true
+	}
+	if dep, ok := p.DevDependencies[name]; ok {
+		return dep, GroupDev, true
+	}
+	return Dependency{}, "", false
+}
+
+// AllDependencies returns the dependencies of both groups in a single map. Names are unique
+// across groups, since almd-lock.toml keys its entries by name alone.
+func (p *Project) AllDependencies() map[string]Dependency {
+	all := make(map[string]Dependency, len(p.Dependencies)+len(p.DevDependencies))
+	for name, dep := range p.DevDependencies {
+		all[name] = dep
+	}
+	for name, dep := range p.Dependencies {
+		all[name] = dep
+	}
+	return all
+}
+
+// SetDependency stores dep under name in the table of group, removing it from the other table.
+func (p *Project) SetDependency(name, group string, dep Dependency) {
+	table, other := &p.Dependencies, &p.DevDependencies
+	if group == GroupDev {
+		table, other = other, table
+	}
+	if *table == nil {
+		*table = make(map[string]Dependency)
+	}
+	(*table)[name] = dep
+	delete(*other, name)
+}
+
+// RemoveDependency deletes name from whichever table declares it.
+func (p *Project) RemoveDependency(name string) {
+	delete(p.Dependencies, name)
+	delete(p.DevDependencies, name)
+}
+
+// PackageName returns the [package] name, or "" when the table or the name is absent.
+// Package metadata is optional; a manifest may hold nothing but dependencies.
+func (p *Project) PackageName() string {
+	if p.Package == nil {
+		return ""
+	}
+	return p.Package.Name
+}
+
+// PackageVersion returns the [package] version, or "" when the table or the version is absent.
+func (p *Project) PackageVersion() string {
+	if p.Package == nil {
+		return ""
+	}
+	return p.Package.Version
+}
+
+// AlmdConfig holds tool-level settings from the optional [almd] table of project.toml.
+type AlmdConfig struct {
+	// AllowedHosts restricts which hosts dependencies may be downloaded from.
+	// When empty, every host is allowed.
+	AllowedHosts []string `toml:"allowed_hosts,omitempty"`
+	// MaxCommitJump is how many upstream commits a branch-pinned dependency may move during
+	// install before the user is asked to confirm. Zero disables the prompt.
+	MaxCommitJump *int `toml:"max_commit_jump,omitempty"`
+	// GenerateRequires is a project-relative file that install and remove regenerate with a
+	// require statement for every dependency in [dependencies]. Dev-dependencies are left out.
+	GenerateRequires string `toml:"generate_requires,omitempty"`
+	// RequiresTemplate is a project-relative text/template file used in place of the
+	// built-in Lua template when generating GenerateRequires.
+	RequiresTemplate string `toml:"requires_template,omitempty"`
+	// Registry is a local path or http(s) URL of a registry file used by 'almd add' to expand
+	// bare names such as "json" into full sources.
+	Registry string `toml:"registry,omitempty"`
+	// DefaultDependencyDir is the project-relative directory 'almd add' saves files into when
+	// no --directory is given.
+	DefaultDependencyDir string `toml:"default_dependency_dir,omitempty"`
+	// GithubHost is a self-hosted GitHub Enterprise or Gitea host (e.g. git.example.com) that
+	// github: sources and GitHub URLs refer to instead of github.com.
+	GithubHost string `toml:"github_host,omitempty"`
+	// GithubAPIURL is the API endpoint of GithubHost, when it is not <host>/api/v3.
+	GithubAPIURL string `toml:"github_api_url,omitempty"`
 }
 
 // PackageInfo holds metadata for the project.
@@ -13,25 +117,39 @@ type PackageInfo struct {
 	Version     string `toml:"version"`
 	License     string `toml:"license,omitempty"`
 	Description string `toml:"description,omitempty"`
+	// ManifestVersion is the project.toml schema version. Zero (absent) means the current schema.
+	ManifestVersion int `toml:"manifest_version,omitzero"`
 }
 
 // Dependency represents a single dependency in the project.toml file.
 type Dependency struct {
 	Source string `toml:"source"`
 	Path   string `toml:"path"`
+	// ChecksumURL points at an upstream-published SHA256 checksum file for the dependency.
+	// When set, downloads are verified against it and its hash is recorded in the lockfile.
+	ChecksumURL string `toml:"checksum_url,omitempty"`
+	// OS and Arch restrict the dependency to the listed GOOS and GOARCH values.
+	// An empty list matches every platform.
+	OS   []string `toml:"os,omitempty"`
+	Arch []string `toml:"arch,omitempty"`
 }
 
-// LockFile represents the structure of the almd-lock.toml file.
-type LockFile struct {
-	APIVersion string                       `toml:"api_version"`
-	Package    map[string]LockPackageDetail `toml:"package"`
+// SupportsPlatform reports whether the dependency is needed on the given GOOS and GOARCH.
+func (d Dependency) SupportsPlatform(goos, goarch string) bool {
+	return matchesAny(d.OS, goos) && matchesAny(d.Arch, goarch)
 }
 
-// LockPackageDetail represents a single package entry in the almd-lock.toml file.
-type LockPackageDetail struct {
-	Source string `toml:"source"` // The exact raw download URL
-	Path   string `toml:"path"`   // Relative path to the downloaded file
-	Hash   string `toml:"hash"`   // Integrity hash (e.g., "sha256:<hash>" or "commit:<hash>")
+// matchesAny reports whether value is in allowed, treating an empty list as allowing everything.
+func matchesAny(allowed []string, value string) bool {
+	if len(allowed) == 0 {
+		return true
+	}
+	for _, a := range allowed {
+		if a == value {
+			return true
+		}
+	}
+	return false
 }
 
 // NewProject creates and returns a new Project instance with initialized maps.
@@ -42,3 +160,86 @@ func NewProject() *Project {
 		Dependencies: make(map[string]Dependency),
 	}
 }
+
+// AllowedHosts returns the download host allowlist configured in the [almd] table, if any.
+func (p *Project) AllowedHosts() []string {
+	if p == nil || p.Almd == nil {
+		return nil
+	}
+	return p.Almd.AllowedHosts
+}
+
+// RequiresSettings returns the generated requires file and its template configured in the
+// [almd] table. Either may be empty.
+func (p *Project) RequiresSettings() (outputPath, templatePath string) {
+	if p == nil || p.Almd == nil {
+		return "", ""
+	}
+	return p.Almd.GenerateRequires, p.Almd.RequiresTemplate
+}
+
+// RegistryLocation returns the registry configured in the [almd] table, if any.
+func (p *Project) RegistryLocation() string {
+	if p == nil || p.Almd == nil {
+		return ""
+	}
+	return p.Almd.Registry
+}
+
+// DefaultDependencyDir returns the directory for new dependencies configured in the [almd]
+// table, if any.
+func (p *Project) DefaultDependencyDir() string {
+	if p == nil || p.Almd == nil {
+		return ""
+	}
+	return p.Almd.DefaultDependencyDir
+}
+
+// GithubHostSettings returns the GitHub host and API endpoint configured in the [almd] table.
+// Either may be empty.
+func (p *Project) GithubHostSettings() (host, apiURL string) {
+	if p == nil || p.Almd == nil {
+		return "", ""
+	}
+	return p.Almd.GithubHost, p.Almd.GithubAPIURL
+}
+
+// invalidNameChars matches runs of characters not allowed in a dependency name.
+var invalidNameChars = regexp.MustCompile(`[^A-Za-z0-9_.-]+`)
+
+// ValidateDependencyName reports an error if name cannot be used as a key in [dependencies]
+// and almd-lock.toml. Names may contain only letters, digits, '_', '.' and '-', and cannot be
+// "." or "..", so they never act as path separators or need quoting.
+func ValidateDependencyName(name string) error {
+	switch {
+	case name == "":
+		return fmt.Errorf("dependency name is empty")
+	case name == "." || name == "..":
+		return fmt.Errorf("dependency name '%s' is not allowed", name)
+	case invalidNameChars.MatchString(name):
+		return fmt.Errorf("dependency name '%s' may contain only letters, digits, '_', '.' and '-'", name)
+	}
+	return nil
+}
+
+// NormalizeDependencyName turns an inferred name, such as a file name, into a valid dependency
+// name by replacing each run of disallowed characters with '-' and trimming '-' from the ends.
+// The result may still be invalid, for example empty; check it with ValidateDependencyName.
+func NormalizeDependencyName(name string) string {
+	return strings.Trim(invalidNameChars.ReplaceAllString(name, "-"), "-")
+}
+
+// NormalizePath returns a project-relative dependency path in the slash-separated form stored
+// in project.toml and almd-lock.toml. Backslashes are treated as separators regardless of the
+// running OS, so manifests written on Windows keep working elsewhere.
+func NormalizePath(p string) string {
+	if p == "" {
+		return ""
+	}
+	return path.Clean(strings.ReplaceAll(p, "\\", "/"))
+}
+
+// NativePath converts a stored dependency path into a path for the running OS.
+func NativePath(p string) string {
+	return filepath.FromSlash(NormalizePath(p))
+}
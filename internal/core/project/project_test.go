@@ -2,6 +2,7 @@
 package project_test
 
 import (
+	"path/filepath"
 	"testing"
 
 	"github.com/stretchr/testify/assert"
@@ -27,3 +28,91 @@ func TestNewProject(t *testing.T) {
 	assert.Equal(t, "", p.Package.License, "Package.License should be empty initially")
 	assert.Equal(t, "", p.Package.Description, "Package.Description should be empty initially")
 }
+
+func TestNormalizePath(t *testing.T) {
+	t.Parallel()
+	cases := map[string]string{
+		"libs/dep.lua":             "libs/dep.lua",
+		"libs/sub/deeper/dep.lua":  "libs/sub/deeper/dep.lua",
+		`libs\sub\dep.lua`:         "libs/sub/dep.lua",
+		`libs\mixed/style\dep.lua`: "libs/mixed/style/dep.lua",
+		"./libs//dep.lua":          "libs/dep.lua",
+		"":                         "",
+	}
+	for in, want := range cases {
+		assert.Equal(t, want, project.NormalizePath(in), "NormalizePath(%q)", in)
+	}
+}
+
+func TestValidateDependencyName(t *testing.T) {
+	t.Parallel()
+	for _, name := range []string{"json", "lume.min", "my_lib-2"} {
+		assert.NoError(t, project.ValidateDependencyName(name), name)
+	}
+	for _, name := range []string{"", ".", "..", "my lib", "libs/json", `libs\json`, "a=b", "ünicode"} {
+		assert.Error(t, project.ValidateDependencyName(name), name)
+	}
+}
+
+func TestNormalizeDependencyName(t *testing.T) {
+	t.Parallel()
+	cases := map[string]string{
+		"json":         "json",
+		"my lib (v2)":  "my-lib-v2",
+		"a%20b":        "a-20b",
+		"  spaced  ":   "spaced",
+		"lume.min":     "lume.min",
+		"!!!":          "",
+		"ünicode_name": "nicode_name",
+	}
+	for in, want := range cases {
+		assert.Equal(t, want, project.NormalizeDependencyName(in), "NormalizeDependencyName(%q)", in)
+	}
+}
+
+func TestNativePath(t *testing.T) {
+	t.Parallel()
+	assert.Equal(t, filepath.Join("libs", "sub", "dep.lua"), project.NativePath("libs/sub/dep.lua"))
+	assert.Equal(t, filepath.Join("libs", "sub", "dep.lua"), project.NativePath(`libs\sub\dep.lua`))
+}
+
+func TestDependency_SupportsPlatform(t *testing.T) {
+	t.Parallel()
+	unconstrained := project.Dependency{Source: "github:o/r/a.lua@main", Path: "libs/a.lua"}
+	assert.True(t, unconstrained.SupportsPlatform("windows", "386"))
+
+	unixOnly := project.Dependency{OS: []string{"linux", "darwin"}}
+	assert.True(t, unixOnly.SupportsPlatform("linux", "amd64"))
+	assert.False(t, unixOnly.SupportsPlatform("windows", "amd64"))
+
+	armLinux := project.Dependency{OS: []string{"linux"}, Arch: []string{"arm64"}}
+	assert.True(t, armLinux.SupportsPlatform("linux", "arm64"))
+	assert.False(t, armLinux.SupportsPlatform("linux", "amd64"))
+	assert.False(t, armLinux.SupportsPlatform("darwin", "arm64"))
+}
+
+func TestProject_DependencyGroups(t *testing.T) {
+	t.Parallel()
+	p := &project.Project{}
+	p.SetDependency("json", "", project.Dependency{Source: "github:o/r/json.lua@main", Path: "libs/json.lua"})
+	p.SetDependency("busted", project.GroupDev, project.Dependency{Source: "github:o/r/busted.lua@main", Path: "libs/busted.lua"})
+
+	dep, group, ok := p.LookupDependency("busted")
+	assert.True(t, ok)
+	assert.Equal(t, project.GroupDev, group)
+	assert.Equal(t, "libs/busted.lua", dep.Path)
+	_, group, ok = p.LookupDependency("json")
+	assert.True(t, ok)
+	assert.Equal(t, "", group)
+	_, _, ok = p.LookupDependency("missing")
+	assert.False(t, ok)
+	assert.Len(t, p.AllDependencies(), 2)
+
+	// Setting a dependency in the other group moves it.
+	p.SetDependency("json", project.GroupDev, project.Dependency{Path: "libs/json.lua"})
+	assert.NotContains(t, p.Dependencies, "json")
+	assert.Contains(t, p.DevDependencies, "json")
+
+	p.RemoveDependency("json")
+	assert.Len(t, p.AllDependencies(), 1)
+}
@@ -0,0 +1,102 @@
+// Package registry resolves short dependency names to full source URLs using a registry file:
+// a TOML document mapping each name to a source, for example
+//
+//	json = "github:rxi/json.lua/json.lua@master"
+//	inspect = "https://raw.githubusercontent.com/kikito/inspect.lua/master/inspect.lua"
+//
+// A registry may be a local file or an http(s) URL. Remote registries are cached on disk.
+package registry
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/BurntSushi/toml"
+
+	"github.com/nightconcept/almandine-go/internal/core/downloader"
+)
+
+// CacheTTL is how long a cached copy of a remote registry is used before it is re-fetched.
+const CacheTTL = time.Hour
+
+// Registry maps short dependency names to source URLs.
+type Registry map[string]string
+
+// Parse decodes a registry document.
+func Parse(data []byte) (Registry, error) {
+	reg := make(Registry)
+	if err := toml.Unmarshal(data, &reg); err != nil {
+		return nil, fmt.Errorf("failed to decode registry: %w", err)
+	}
+	return reg, nil
+}
+
+// Lookup returns the source registered for name.
+func (r Registry) Lookup(name string) (string, bool) {
+	src, ok := r[name]
+	return src, ok && src != ""
+}
+
+// IsRemote reports whether location refers to an http(s) registry rather than a local file.
+func IsRemote(location string) bool {
+	return strings.HasPrefix(location, "http://") || strings.HasPrefix(location, "https://")
+}
+
+// DefaultCacheDir returns the directory remote registries are cached in, under the user cache directory.
+func DefaultCacheDir() (string, error) {
+	base, err := os.UserCacheDir()
+	if err != nil {
+		return "", fmt.Errorf("failed to determine user cache directory: %w", err)
+	}
+	return filepath.Join(base, "almd", "registries"), nil
+}
+
+// cachePath returns the file a remote registry at url is cached in.
+func cachePath(cacheDir, url string) string {
+	sum := sha256.Sum256([]byte(url))
+	return filepath.Join(cacheDir, hex.EncodeToString(sum[:8])+".toml")
+}
+
+// Load reads the registry at location. Local paths are read directly. Remote registries are
+// served from cacheDir while the cached copy is younger than CacheTTL; otherwise they are
+// downloaded and the cache refreshed. If a download fails, a stale cached copy is used instead.
+func Load(location, cacheDir string) (Registry, error) {
+	if !IsRemote(location) {
+		data, err := os.ReadFile(location)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read registry %s: %w", location, err)
+		}
+		return Parse(data)
+	}
+
+	cached := cachePath(cacheDir, location)
+	if info, err := os.Stat(cached); err == nil && time.Since(info.ModTime()) < CacheTTL {
+		if data, err := os.ReadFile(cached); err == nil {
+			return Parse(data)
+		}
+	}
+
+	data, downloadErr := downloader.DownloadFile(location)
+	if downloadErr != nil {
+		stale, err := os.ReadFile(cached)
+		if err != nil {
+			return nil, fmt.Errorf("failed to fetch registry: %w", downloadErr)
+		}
+		return Parse(stale)
+	}
+
+	reg, err := Parse(data)
+	if err != nil {
+		return nil, err
+	}
+	// A cache write failure only costs a re-download next time.
+	if err := os.MkdirAll(cacheDir, 0755); err == nil {
+		_ = os.WriteFile(cached, data, 0644)
+	}
+	return reg, nil
+}
@@ -0,0 +1,92 @@
+package registry_test
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/nightconcept/almandine-go/internal/core/downloader"
+	"github.com/nightconcept/almandine-go/internal/core/registry"
+)
+
+func TestLoad_LocalFile(t *testing.T) {
+	t.Parallel()
+	path := filepath.Join(t.TempDir(), "registry.toml")
+	require.NoError(t, os.WriteFile(path, []byte(`json = "github:rxi/json.lua/json.lua@master"`), 0644))
+
+	reg, err := registry.Load(path, t.TempDir())
+	require.NoError(t, err)
+
+	src, ok := reg.Lookup("json")
+	assert.True(t, ok)
+	assert.Equal(t, "github:rxi/json.lua/json.lua@master", src)
+	_, ok = reg.Lookup("missing")
+	assert.False(t, ok)
+}
+
+func TestLoad_InvalidToml(t *testing.T) {
+	t.Parallel()
+	path := filepath.Join(t.TempDir(), "registry.toml")
+	require.NoError(t, os.WriteFile(path, []byte(`json = `), 0644))
+
+	_, err := registry.Load(path, t.TempDir())
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "failed to decode registry")
+}
+
+func TestLoad_RemoteIsCached(t *testing.T) {
+	t.Parallel()
+	var requests int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&requests, 1)
+		_, _ = fmt.Fprint(w, `json = "https://example.com/json.lua"`)
+	}))
+	defer server.Close()
+	cacheDir := t.TempDir()
+
+	for i := 0; i < 2; i++ {
+		reg, err := registry.Load(server.URL+"/registry.toml", cacheDir)
+		require.NoError(t, err)
+		src, ok := reg.Lookup("json")
+		require.True(t, ok)
+		assert.Equal(t, "https://example.com/json.lua", src)
+	}
+	assert.Equal(t, int32(1), atomic.LoadInt32(&requests), "second load should be served from the cache")
+}
+
+func TestLoad_RemoteFallsBackToStaleCache(t *testing.T) {
+	// The re-fetch below fails with a refused connection; do not wait for it to be retried.
+	downloader.MaxRetries = 0
+	defer func() { downloader.MaxRetries = 3 }()
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = fmt.Fprint(w, `json = "https://example.com/json.lua"`)
+	}))
+	url := server.URL + "/registry.toml"
+	cacheDir := t.TempDir()
+
+	_, err := registry.Load(url, cacheDir)
+	require.NoError(t, err)
+	server.Close()
+
+	// Age the cached copy past the TTL so a re-fetch is attempted and fails.
+	entries, err := os.ReadDir(cacheDir)
+	require.NoError(t, err)
+	require.Len(t, entries, 1)
+	stale := filepath.Join(cacheDir, entries[0].Name())
+	old := registry.CacheTTL * 2
+	info, err := os.Stat(stale)
+	require.NoError(t, err)
+	require.NoError(t, os.Chtimes(stale, info.ModTime().Add(-old), info.ModTime().Add(-old)))
+
+	reg, err := registry.Load(url, cacheDir)
+	require.NoError(t, err)
+	_, ok := reg.Lookup("json")
+	assert.True(t, ok)
+}
@@ -0,0 +1,101 @@
+// Package requires generates a source file that loads every project dependency, such as a
+// Lua module that calls require for each installed library.
+package requires
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"text/template"
+
+	"github.com/nightconcept/almandine-go/internal/core/fsutil"
+	"github.com/nightconcept/almandine-go/internal/core/project"
+)
+
+// DefaultTemplate renders a Lua module returning a table of every dependency, keyed by name.
+const DefaultTemplate = `-- Code generated by almd. DO NOT EDIT.
+local deps = {}
+{{range .Dependencies}}deps[{{printf "%q" .Name}}] = require({{printf "%q" .Module}})
+{{end}}return deps
+`
+
+// Entry is the per-dependency data available to a template.
+type Entry struct {
+	// Name is the dependency name from project.toml.
+	Name string
+	// Path is the dependency file path relative to the project root, with forward slashes.
+	Path string
+	// Module is Path without its extension and with slashes replaced by dots, as used by Lua's require.
+	Module string
+}
+
+// Data is the value a template is executed with. Dependencies are sorted by name.
+type Data struct {
+	Dependencies []Entry
+}
+
+// moduleName converts a project-relative file path into a dotted Lua module name.
+func moduleName(depPath string) string {
+	slashed := filepath.ToSlash(filepath.Clean(depPath))
+	slashed = strings.TrimSuffix(slashed, filepath.Ext(slashed))
+	return strings.ReplaceAll(strings.TrimPrefix(slashed, "./"), "/", ".")
+}
+
+// NewData builds template data for deps.
+func NewData(deps map[string]project.Dependency) Data {
+	names := make([]string, 0, len(deps))
+	for name := range deps {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	data := Data{Dependencies: make([]Entry, 0, len(names))}
+	for _, name := range names {
+		depPath := filepath.ToSlash(deps[name].Path)
+		data.Dependencies = append(data.Dependencies, Entry{Name: name, Path: depPath, Module: moduleName(depPath)})
+	}
+	return data
+}
+
+// Render executes templateText against deps and returns the result.
+func Render(templateText string, deps map[string]project.Dependency) ([]byte, error) {
+	tmpl, err := template.New("requires").Parse(templateText)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse requires template: %w", err)
+	}
+	var buf bytes.Buffer
+	if err := tmpl.Execute(&buf, NewData(deps)); err != nil {
+		return nil, fmt.Errorf("failed to render requires template: %w", err)
+	}
+	return buf.Bytes(), nil
+}
+
+// Generate renders deps into outputPath. If templatePath is empty DefaultTemplate is used;
+// otherwise the template is read from that file. Both paths are relative to projectRoot.
+func Generate(projectRoot, outputPath, templatePath string, deps map[string]project.Dependency) error {
+	templateText := DefaultTemplate
+	if templatePath != "" {
+		raw, err := os.ReadFile(filepath.Join(projectRoot, templatePath))
+		if err != nil {
+			return fmt.Errorf("failed to read requires template %s: %w", templatePath, err)
+		}
+		templateText = string(raw)
+	}
+
+	content, err := Render(templateText, deps)
+	if err != nil {
+		return err
+	}
+
+	fullPath := filepath.Join(projectRoot, outputPath)
+	if err := os.MkdirAll(filepath.Dir(fullPath), 0755); err != nil {
+		return fmt.Errorf("failed to create directory for %s: %w", outputPath, err)
+	}
+	if err := fsutil.WriteFileAtomic(fullPath, content, 0644); err != nil {
+		return fmt.Errorf("failed to write %s: %w", outputPath, err)
+	}
+	return nil
+}
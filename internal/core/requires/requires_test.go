@@ -0,0 +1,52 @@
+package requires_test
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/nightconcept/almandine-go/internal/core/project"
+	"github.com/nightconcept/almandine-go/internal/core/requires"
+)
+
+func TestRender_DefaultTemplate(t *testing.T) {
+	t.Parallel()
+	deps := map[string]project.Dependency{
+		"json":    {Source: "github:rxi/json.lua/json.lua@master", Path: "src/lib/json.lua"},
+		"inspect": {Source: "github:kikito/inspect.lua/inspect.lua@master", Path: "./vendor/inspect.lua"},
+	}
+
+	out, err := requires.Render(requires.DefaultTemplate, deps)
+	require.NoError(t, err)
+
+	expected := `-- Code generated by almd. DO NOT EDIT.
+local deps = {}
+deps["inspect"] = require("vendor.inspect")
+deps["json"] = require("src.lib.json")
+return deps
+`
+	assert.Equal(t, expected, string(out))
+}
+
+func TestGenerate_CustomTemplate(t *testing.T) {
+	t.Parallel()
+	tempDir := t.TempDir()
+	require.NoError(t, os.WriteFile(filepath.Join(tempDir, "deps.tmpl"), []byte("{{range .Dependencies}}{{.Name}}={{.Path}}\n{{end}}"), 0644))
+
+	deps := map[string]project.Dependency{"a": {Path: "lib/a.lua"}}
+	require.NoError(t, requires.Generate(tempDir, "gen/deps.txt", "deps.tmpl", deps))
+
+	content, err := os.ReadFile(filepath.Join(tempDir, "gen", "deps.txt"))
+	require.NoError(t, err)
+	assert.Equal(t, "a=lib/a.lua\n", string(content))
+}
+
+func TestRender_InvalidTemplate(t *testing.T) {
+	t.Parallel()
+	_, err := requires.Render("{{range}", nil)
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "failed to parse requires template")
+}
@@ -2,26 +2,119 @@ package source
 
 import (
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
 	"sync" // Added import for sync
 	"time"
+
+	"github.com/nightconcept/almandine-go/internal/core/ghauth"
+	"github.com/nightconcept/almandine-go/internal/core/githost"
+	"github.com/nightconcept/almandine-go/internal/core/httpclient"
 )
 
-// GithubAPIBaseURL allows overriding for tests. It is an exported variable.
-var GithubAPIBaseURL = "https://api.github.com"
+// DefaultGithubAPIBaseURL is the public GitHub REST API endpoint.
+const DefaultGithubAPIBaseURL = githost.DefaultAPIBaseURL
+
+// GithubAPIBaseURL is the base URL used by the package-level API functions and by the CLI.
+//
+// Deprecated: mutating this global forces tests that touch it to run serially. Create an
+// isolated client with NewClient(Config{APIBaseURL: ...}) instead.
+var GithubAPIBaseURL = DefaultGithubAPIBaseURL
+
+// GithubAPIBaseURLMutex guards GithubAPIBaseURL.
+//
+// Deprecated: only needed by code that mutates GithubAPIBaseURL; use NewClient instead.
 var GithubAPIBaseURLMutex sync.Mutex // Mutex for GithubAPIBaseURL (Exported)
 
+// Config holds the settings a Client uses to reach the GitHub API.
+type Config struct {
+	// APIBaseURL is the API endpoint, without a trailing slash. Defaults to DefaultGithubAPIBaseURL.
+	APIBaseURL string
+	// HTTPClient performs the requests. Defaults to httpclient.New, which gives up on a server
+	// silent for 30 seconds unless ALMD_HTTP_TIMEOUT says otherwise.
+	HTTPClient *http.Client
+	// Token, when set, is sent as a bearer token with every request.
+	Token string
+	// Verbose, when set, receives the remaining rate-limit quota after every request.
+	Verbose io.Writer
+}
+
+// Client talks to the GitHub API using its own, immutable Config. Clients are safe for
+// concurrent use, and separate clients share no state.
+type Client struct {
+	cfg Config
+}
+
+// NewClient returns a Client for cfg, filling in defaults for any zero-valued fields.
+func NewClient(cfg Config) *Client {
+	if cfg.APIBaseURL == "" {
+		cfg.APIBaseURL = DefaultGithubAPIBaseURL
+	}
+	if cfg.HTTPClient == nil {
+		cfg.HTTPClient = httpclient.New()
+	}
+	return &Client{cfg: cfg}
+}
+
+// DefaultClient returns a Client for DefaultConfig. The CLI commands use it so that the
+// package-level GithubAPIBaseURL remains the single default for the binary.
+func DefaultClient() *Client {
+	return NewClient(DefaultConfig())
+}
+
+// DefaultConfig returns the Config of a DefaultClient: the package-level GithubAPIBaseURL and
+// the token found by ghauth.Token. While GithubAPIBaseURL is left at its default, the API of the
+// host configured in githost is used, and the token is only sent to it when githost.Trusted.
+// Commands set Verbose on the result to report rate-limit quota under --verbose.
+func DefaultConfig() Config {
+	GithubAPIBaseURLMutex.Lock()
+	baseURL := GithubAPIBaseURL
+	GithubAPIBaseURLMutex.Unlock()
+	token := ghauth.Token()
+	if baseURL == DefaultGithubAPIBaseURL {
+		baseURL = githost.APIBaseURL()
+		if !githost.Trusted() {
+			token = ""
+		}
+	}
+	return Config{APIBaseURL: baseURL, Token: token}
+}
+
+// APIBaseURL returns the API endpoint this client sends requests to.
+func (c *Client) APIBaseURL() string {
+	return c.cfg.APIBaseURL
+}
+
 // GitHubCommitInfo minimal structure to parse the commit SHA.
 type GitHubCommitInfo struct {
 	SHA    string `json:"sha"`
 	Commit struct {
+		Message   string `json:"message"`
 		Committer struct {
 			Date time.Time `json:"date"`
 		} `json:"committer"`
 	} `json:"commit"`
-	// We only need the SHA, but including date for potential future use/sorting.
+	// The SHA is what resolution needs; message and date are shown by 'almd info'.
+}
+
+// RepositoryInfo holds the subset of GitHub's repository API response that almd uses.
+type RepositoryInfo struct {
+	FullName        string `json:"full_name"`
+	Description     string `json:"description"`
+	HTMLURL         string `json:"html_url"`
+	DefaultBranch   string `json:"default_branch"`
+	StargazersCount int    `json:"stargazers_count"`
+}
+
+// GetLatestCommitSHAForFile fetches the latest commit SHA for a file using DefaultClient.
+func GetLatestCommitSHAForFile(owner, repo, pathInRepo, ref string) (string, error) {
+	return DefaultClient().GetLatestCommitSHAForFile(owner, repo, pathInRepo, ref)
 }
 
 // GetLatestCommitSHAForFile fetches the latest commit SHA for a specific file on a given branch/ref from GitHub.
@@ -29,53 +122,323 @@ type GitHubCommitInfo struct {
 // repo: repository name
 // pathInRepo: path to the file within the repository
 // ref: branch name, tag name, or commit SHA
-func GetLatestCommitSHAForFile(owner, repo, pathInRepo, ref string) (string, error) {
+func (c *Client) GetLatestCommitSHAForFile(owner, repo, pathInRepo, ref string) (string, error) {
+	commit, err := c.GetLatestCommitForFile(owner, repo, pathInRepo, ref)
+	if err != nil {
+		return "", err
+	}
+	return commit.SHA, nil
+}
+
+// GetLatestCommitForFile fetches the most recent commit touching pathInRepo at ref,
+// including its message and committer date.
+func (c *Client) GetLatestCommitForFile(owner, repo, pathInRepo, ref string) (*GitHubCommitInfo, error) {
 	// Construct the API URL
 	// See: https://docs.github.com/en/rest/commits/commits#list-commits
 	// We ask for commits for a specific file on a specific branch/ref. The first result is the latest.
-	GithubAPIBaseURLMutex.Lock()
-	currentGithubAPIBaseURL := GithubAPIBaseURL
-	GithubAPIBaseURLMutex.Unlock()
-	apiURL := fmt.Sprintf("%s/repos/%s/%s/commits?path=%s&sha=%s&per_page=1", currentGithubAPIBaseURL, owner, repo, pathInRepo, ref)
+	apiURL := fmt.Sprintf("%s/repos/%s/%s/commits?path=%s&sha=%s&per_page=1", c.cfg.APIBaseURL, owner, repo, pathInRepo, ref)
+
+	body, err := c.get(apiURL)
+	if err != nil {
+		return nil, err
+	}
+
+	var commits []GitHubCommitInfo
+	if err := json.Unmarshal(body, &commits); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal GitHub API response (%s): %w. Body: %s", apiURL, err, string(body))
+	}
+
+	if len(commits) == 0 {
+		// This can happen if the path is incorrect for the given ref, or the ref itself doesn't exist.
+		// Or if the ref *is* a commit SHA, and the file wasn't modified in that specific commit (the API returns history).
+		// If ref is already a SHA, we should ideally use it directly. This function assumes ref might be a branch.
+		// If no commits are returned for a file on a branch, it implies the file might not exist on that branch or path is wrong.
+		return nil, fmt.Errorf("no commits found for path '%s' at ref '%s' in repo '%s/%s'. The file might not exist at this path/ref, or the ref might be a specific commit SHA where this file was not modified", pathInRepo, ref, owner, repo)
+	}
+
+	return &commits[0], nil
+}
+
+// GetRepository fetches metadata such as the description and default branch of a repository.
+func (c *Client) GetRepository(owner, repo string) (*RepositoryInfo, error) {
+	// See: https://docs.github.com/en/rest/repos/repos#get-a-repository
+	apiURL := fmt.Sprintf("%s/repos/%s/%s", c.cfg.APIBaseURL, owner, repo)
+	body, err := c.get(apiURL)
+	if err != nil {
+		return nil, err
+	}
+	var info RepositoryInfo
+	if err := json.Unmarshal(body, &info); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal GitHub API response (%s): %w. Body: %s", apiURL, err, string(body))
+	}
+	return &info, nil
+}
+
+// GetDefaultBranch returns the name of a repository's default branch using the default client.
+func GetDefaultBranch(owner, repo string) (string, error) {
+	return DefaultClient().GetDefaultBranch(owner, repo)
+}
+
+// GetDefaultBranch returns the name of a repository's default branch.
+func (c *Client) GetDefaultBranch(owner, repo string) (string, error) {
+	info, err := c.GetRepository(owner, repo)
+	if err != nil {
+		return "", err
+	}
+	if info.DefaultBranch == "" {
+		return "", fmt.Errorf("GitHub API did not report a default branch for %s/%s", owner, repo)
+	}
+	return info.DefaultBranch, nil
+}
+
+// tagsPerPage is the page size requested from the tags API; maxTagPages bounds how many pages
+// ListTags reads, so a repository with thousands of tags cannot exhaust the rate limit.
+const (
+	tagsPerPage = 100
+	maxTagPages = 10
+)
 
-	httpClient := &http.Client{Timeout: 10 * time.Second}
+// ListTags returns the tag names of a repository using the default client.
+func ListTags(owner, repo string) ([]string, error) {
+	return DefaultClient().ListTags(owner, repo)
+}
+
+// ListTags returns the tag names of a repository, newest first as GitHub orders them.
+func (c *Client) ListTags(owner, repo string) ([]string, error) {
+	// See: https://docs.github.com/en/rest/repos/repos#list-repository-tags
+	var names []string
+	for page := 1; page <= maxTagPages; page++ {
+		apiURL := fmt.Sprintf("%s/repos/%s/%s/tags?per_page=%d&page=%d", c.cfg.APIBaseURL, owner, repo, tagsPerPage, page)
+		body, err := c.get(apiURL)
+		if err != nil {
+			return nil, err
+		}
+		var tags []struct {
+			Name string `json:"name"`
+		}
+		if err := json.Unmarshal(body, &tags); err != nil {
+			return nil, fmt.Errorf("failed to unmarshal GitHub API response (%s): %w. Body: %s", apiURL, err, string(body))
+		}
+		for _, tag := range tags {
+			names = append(names, tag.Name)
+		}
+		if len(tags) < tagsPerPage {
+			break
+		}
+	}
+	return names, nil
+}
+
+// TreeEntry is a file listed by GitHub's git trees API. Path is relative to the repository
+// root and SHA is the file's git blob SHA.
+type TreeEntry struct {
+	Path string `json:"path"`
+	Mode string `json:"mode"`
+	Type string `json:"type"`
+	SHA  string `json:"sha"`
+	Size int64  `json:"size"`
+}
+
+// symlinkMode is the git file mode of a symbolic link; its blob holds the link target.
+const symlinkMode = "120000"
+
+// ListFiles returns the files under a repository directory using DefaultClient.
+func ListFiles(owner, repo, ref, dir string) ([]TreeEntry, error) {
+	return DefaultClient().ListFiles(owner, repo, ref, dir)
+}
+
+// ListFiles returns every file below dir in a repository at ref, in path order, from the
+// recursive git tree of the ref. Symbolic links and submodules are left out. It is an error if
+// GitHub truncated the listing or no file lies below dir.
+func (c *Client) ListFiles(owner, repo, ref, dir string) ([]TreeEntry, error) {
+	// See: https://docs.github.com/en/rest/git/trees#get-a-tree
+	apiURL := fmt.Sprintf("%s/repos/%s/%s/git/trees/%s?recursive=1", c.cfg.APIBaseURL, owner, repo, url.PathEscape(ref))
+
+	body, err := c.get(apiURL)
+	if err != nil {
+		return nil, err
+	}
+
+	var tree struct {
+		Tree      []TreeEntry `json:"tree"`
+		Truncated bool        `json:"truncated"`
+	}
+	if err := json.Unmarshal(body, &tree); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal GitHub API response (%s): %w. Body: %s", apiURL, err, string(body))
+	}
+	if tree.Truncated {
+		return nil, fmt.Errorf("GitHub truncated the file listing of %s/%s at ref '%s'; the repository is too large to list (%s)", owner, repo, ref, apiURL)
+	}
+
+	prefix := strings.Trim(dir, "/") + "/"
+	var files []TreeEntry
+	for _, entry := range tree.Tree {
+		if entry.Type == "blob" && entry.Mode != symlinkMode && strings.HasPrefix(entry.Path, prefix) {
+			files = append(files, entry)
+		}
+	}
+	if len(files) == 0 {
+		return nil, fmt.Errorf("no files found under '%s' at ref '%s' in repo '%s/%s'", dir, ref, owner, repo)
+	}
+	sort.Slice(files, func(i, j int) bool { return files[i].Path < files[j].Path })
+	return files, nil
+}
+
+// get performs a GET request against the GitHub API and returns the body of a 200 response.
+func (c *Client) get(apiURL string) ([]byte, error) {
 	req, err := http.NewRequest("GET", apiURL, nil)
 	if err != nil {
-		return "", fmt.Errorf("failed to create request to GitHub API: %w", err)
+		return nil, fmt.Errorf("failed to create request to GitHub API: %w", err)
 	}
 	// GitHub API recommends setting an Accept header.
 	req.Header.Set("Accept", "application/vnd.github.v3+json")
-	// Consider adding a User-Agent header for more robust requests.
-	// req.Header.Set("User-Agent", "almandine-go-cli")
+	if c.cfg.Token != "" {
+		req.Header.Set("Authorization", "Bearer "+c.cfg.Token)
+	}
 
-	resp, err := httpClient.Do(req)
+	resp, err := c.cfg.HTTPClient.Do(req)
 	if err != nil {
-		return "", fmt.Errorf("failed to call GitHub API (%s): %w", apiURL, err)
+		return nil, fmt.Errorf("failed to call GitHub API (%s): %w", apiURL, err)
 	}
 	defer func() { _ = resp.Body.Close() }()
+	reset := rateLimitReset(resp.Header)
+	if remaining := resp.Header.Get("X-RateLimit-Remaining"); c.cfg.Verbose != nil && remaining != "" {
+		note := fmt.Sprintf("  GitHub API rate limit: %s of %s requests left", remaining, resp.Header.Get("X-RateLimit-Limit"))
+		if !reset.IsZero() {
+			note += fmt.Sprintf(", resets at %s", reset.Local().Format(time.Kitchen))
+		}
+		_, _ = fmt.Fprintln(c.cfg.Verbose, note)
+	}
 
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read response body from GitHub API (%s): %w", apiURL, err)
+	}
 	if resp.StatusCode != http.StatusOK {
-		bodyBytes, _ := io.ReadAll(resp.Body)
-		return "", fmt.Errorf("GitHub API request failed with status %s (%s): %s", resp.Status, apiURL, string(bodyBytes))
+		apiErr := &APIError{StatusCode: resp.StatusCode, Status: resp.Status, URL: apiURL, Body: string(body), Authenticated: c.cfg.Token != ""}
+		// GitHub signals an exhausted rate limit with 403 (or 429) and X-RateLimit-Remaining: 0.
+		if (resp.StatusCode == http.StatusForbidden || resp.StatusCode == http.StatusTooManyRequests) && resp.Header.Get("X-RateLimit-Remaining") == "0" {
+			apiErr.RateLimited = true
+			apiErr.RateLimitReset = reset
+		}
+		return nil, apiErr
 	}
+	return body, nil
+}
 
-	body, err := io.ReadAll(resp.Body)
+// rateLimitReset returns the time given by an X-RateLimit-Reset header, or the zero time if
+// there is none.
+func rateLimitReset(header http.Header) time.Time {
+	if reset, err := strconv.ParseInt(header.Get("X-RateLimit-Reset"), 10, 64); err == nil {
+		return time.Unix(reset, 0)
+	}
+	return time.Time{}
+}
+
+// APIError is returned for a GitHub API response other than 200 OK.
+type APIError struct {
+	StatusCode     int
+	Status         string
+	URL            string
+	Body           string
+	Authenticated  bool      // A token was sent with the request
+	RateLimited    bool      // The response reported an exhausted rate limit
+	RateLimitReset time.Time // When the rate limit resets, if GitHub said
+}
+
+func (e *APIError) Error() string {
+	switch {
+	case e.RateLimited:
+		msg := fmt.Sprintf("GitHub API rate limit exceeded (%s)", e.URL)
+		if !e.RateLimitReset.IsZero() {
+			msg += fmt.Sprintf("; it resets at %s", e.RateLimitReset.Local().Format(time.Kitchen))
+			if wait := time.Until(e.RateLimitReset).Round(time.Minute); wait > 0 {
+				msg += fmt.Sprintf(" (in %s)", strings.TrimSuffix(wait.String(), "0s"))
+			}
+		}
+		if !e.Authenticated {
+			msg += fmt.Sprintf(". Set %s or %s to a GitHub token for a higher limit", ghauth.TokenEnv, ghauth.FallbackTokenEnv)
+		}
+		return msg
+	case e.StatusCode == http.StatusUnauthorized && e.Authenticated:
+		return fmt.Sprintf("GitHub API rejected the token: bad credentials (%s). Check %s or %s", e.URL, ghauth.TokenEnv, ghauth.FallbackTokenEnv)
+	case e.StatusCode == http.StatusUnauthorized, e.StatusCode == http.StatusForbidden && !e.Authenticated:
+		return fmt.Sprintf("GitHub API request needs authentication, status %s (%s). Set %s or %s to a GitHub token: %s", e.Status, e.URL, ghauth.TokenEnv, ghauth.FallbackTokenEnv, e.Body)
+	case e.StatusCode == http.StatusForbidden:
+		return fmt.Sprintf("GitHub API denied access, status %s (%s); the token may lack access to this repository: %s", e.Status, e.URL, e.Body)
+	}
+	return fmt.Sprintf("GitHub API request failed with status %s (%s): %s", e.Status, e.URL, e.Body)
+}
+
+// IsBranch reports whether ref names a branch of the repository. A ref that is not a branch
+// may be a tag or a commit.
+func (c *Client) IsBranch(owner, repo, ref string) (bool, error) {
+	// See: https://docs.github.com/en/rest/branches/branches#get-a-branch
+	_, err := c.get(fmt.Sprintf("%s/repos/%s/%s/branches/%s", c.cfg.APIBaseURL, owner, repo, url.PathEscape(ref)))
+	var apiErr *APIError
+	if errors.As(err, &apiErr) && apiErr.StatusCode == http.StatusNotFound {
+		return false, nil
+	}
 	if err != nil {
-		return "", fmt.Errorf("failed to read response body from GitHub API (%s): %w", apiURL, err)
+		return false, err
 	}
+	return true, nil
+}
 
-	var commits []GitHubCommitInfo
-	if err := json.Unmarshal(body, &commits); err != nil {
+// ExpandCommitSHA resolves an abbreviated commit SHA using DefaultClient.
+func ExpandCommitSHA(owner, repo, sha string) (string, error) {
+	return DefaultClient().ExpandCommitSHA(owner, repo, sha)
+}
+
+// ExpandCommitSHA resolves an abbreviated commit SHA in a GitHub repository to the full
+// 40-character SHA of the commit it names.
+func (c *Client) ExpandCommitSHA(owner, repo, sha string) (string, error) {
+	// See: https://docs.github.com/en/rest/commits/commits#get-a-commit
+	apiURL := fmt.Sprintf("%s/repos/%s/%s/commits/%s", c.cfg.APIBaseURL, owner, repo, url.PathEscape(sha))
+
+	body, err := c.get(apiURL)
+	if err != nil {
+		return "", err
+	}
+
+	var commit GitHubCommitInfo
+	if err := json.Unmarshal(body, &commit); err != nil {
 		return "", fmt.Errorf("failed to unmarshal GitHub API response (%s): %w. Body: %s", apiURL, err, string(body))
 	}
+	if len(commit.SHA) != 40 || !strings.HasPrefix(commit.SHA, sha) {
+		return "", fmt.Errorf("GitHub API returned commit '%s' for '%s' (%s)", commit.SHA, sha, apiURL)
+	}
+	return commit.SHA, nil
+}
 
-	if len(commits) == 0 {
-		// This can happen if the path is incorrect for the given ref, or the ref itself doesn't exist.
-		// Or if the ref *is* a commit SHA, and the file wasn't modified in that specific commit (the API returns history).
-		// If ref is already a SHA, we should ideally use it directly. This function assumes ref might be a branch.
-		// If no commits are returned for a file on a branch, it implies the file might not exist on that branch or path is wrong.
-		return "", fmt.Errorf("no commits found for path '%s' at ref '%s' in repo '%s/%s'. The file might not exist at this path/ref, or the ref might be a specific commit SHA where this file was not modified", pathInRepo, ref, owner, repo)
+// CommitComparison holds the subset of GitHub's compare API response that almd uses.
+// Status is one of "ahead", "behind", "identical" or "diverged", describing head relative to base.
+type CommitComparison struct {
+	Status       string `json:"status"`
+	AheadBy      int    `json:"ahead_by"`
+	BehindBy     int    `json:"behind_by"`
+	TotalCommits int    `json:"total_commits"`
+}
+
+// CompareCommits compares two commits (or refs) using DefaultClient.
+func CompareCommits(owner, repo, base, head string) (*CommitComparison, error) {
+	return DefaultClient().CompareCommits(owner, repo, base, head)
+}
+
+// CompareCommits compares two commits (or refs) in a GitHub repository using the compare API.
+// base is typically the previously locked commit and head the newly resolved one.
+func (c *Client) CompareCommits(owner, repo, base, head string) (*CommitComparison, error) {
+	// See: https://docs.github.com/en/rest/commits/commits#compare-two-commits
+	apiURL := fmt.Sprintf("%s/repos/%s/%s/compare/%s...%s", c.cfg.APIBaseURL, owner, repo, base, head)
+
+	body, err := c.get(apiURL)
+	if err != nil {
+		return nil, err
 	}
 
-	return commits[0].SHA, nil
+	var comparison CommitComparison
+	if err := json.Unmarshal(body, &comparison); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal GitHub API response (%s): %w. Body: %s", apiURL, err, string(body))
+	}
+	return &comparison, nil
 }
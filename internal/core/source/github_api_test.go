@@ -2,25 +2,32 @@
 package source_test
 
 import (
+	"bytes"
 	"encoding/json"
 	"fmt"
 	"net/http"
 	"net/http/httptest"
-	"sync" // Added for mutex
 	"testing"
 	"time"
 
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 
+	"github.com/nightconcept/almandine-go/internal/core/httpclient"
 	"github.com/nightconcept/almandine-go/internal/core/source"
 )
 
-var githubAPITestMutex sync.Mutex // Mutex to serialize tests modifying global source state
+// newTestClient starts a mock GitHub API server for handler and returns a Client pointed at it.
+// Each test gets its own server and client, so these tests can run in parallel.
+func newTestClient(t *testing.T, handler http.HandlerFunc) *source.Client {
+	t.Helper()
+	server := httptest.NewServer(handler)
+	t.Cleanup(server.Close)
+	return source.NewClient(source.Config{APIBaseURL: server.URL})
+}
 
 func TestGetLatestCommitSHAForFile_Success(t *testing.T) {
-	githubAPITestMutex.Lock()
-	defer githubAPITestMutex.Unlock()
+	t.Parallel()
 
 	expectedSHA := "abcdef1234567890"
 	mockResponse := []source.GitHubCommitInfo{
@@ -30,7 +37,7 @@ func TestGetLatestCommitSHAForFile_Success(t *testing.T) {
 	responseBody, err := json.Marshal(mockResponse)
 	require.NoError(t, err)
 
-	_, cleanup := setupSourceTest(t, func(w http.ResponseWriter, r *http.Request) {
+	client := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
 		assert.Equal(t, "/repos/owner/repo/commits", r.URL.Path, "Request path mismatch")
 		assert.Equal(t, "path/to/file.txt", r.URL.Query().Get("path"), "Query param 'path' mismatch")
 		assert.Equal(t, "main", r.URL.Query().Get("sha"), "Query param 'sha' mismatch")
@@ -39,68 +46,60 @@ func TestGetLatestCommitSHAForFile_Success(t *testing.T) {
 		w.WriteHeader(http.StatusOK)
 		_, _ = w.Write(responseBody)
 	})
-	defer cleanup()
 
-	sha, err := source.GetLatestCommitSHAForFile("owner", "repo", "path/to/file.txt", "main")
+	sha, err := client.GetLatestCommitSHAForFile("owner", "repo", "path/to/file.txt", "main")
 	require.NoError(t, err)
 	assert.Equal(t, expectedSHA, sha)
 }
 
 func TestGetLatestCommitSHAForFile_EmptyResponse(t *testing.T) {
-	githubAPITestMutex.Lock()
-	defer githubAPITestMutex.Unlock()
+	t.Parallel()
 
 	mockResponse := []source.GitHubCommitInfo{} // Empty array
 	responseBody, err := json.Marshal(mockResponse)
 	require.NoError(t, err)
 
-	_, cleanup := setupSourceTest(t, func(w http.ResponseWriter, r *http.Request) {
+	client := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
 		w.Header().Set("Content-Type", "application/json")
 		w.WriteHeader(http.StatusOK)
 		_, _ = w.Write(responseBody)
 	})
-	defer cleanup()
 
-	_, err = source.GetLatestCommitSHAForFile("owner", "repo", "nonexistent/file.txt", "main")
+	_, err = client.GetLatestCommitSHAForFile("owner", "repo", "nonexistent/file.txt", "main")
 	require.Error(t, err)
 	assert.Contains(t, err.Error(), "no commits found for path")
 }
 
 func TestGetLatestCommitSHAForFile_GitHubAPIError(t *testing.T) {
-	githubAPITestMutex.Lock()
-	defer githubAPITestMutex.Unlock()
+	t.Parallel()
 
-	_, cleanup := setupSourceTest(t, func(w http.ResponseWriter, r *http.Request) {
+	client := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
 		w.Header().Set("Content-Type", "application/json")
 		w.WriteHeader(http.StatusNotFound) // Simulate a 404 from GitHub API
 		_, _ = w.Write([]byte(`{"message": "Not Found"}`))
 	})
-	defer cleanup()
 
-	_, err := source.GetLatestCommitSHAForFile("owner", "repo", "file.txt", "main")
+	_, err := client.GetLatestCommitSHAForFile("owner", "repo", "file.txt", "main")
 	require.Error(t, err)
 	assert.Contains(t, err.Error(), "GitHub API request failed with status 404 Not Found")
 }
 
 func TestGetLatestCommitSHAForFile_MalformedJSONResponse(t *testing.T) {
-	githubAPITestMutex.Lock()
-	defer githubAPITestMutex.Unlock()
+	t.Parallel()
 
-	_, cleanup := setupSourceTest(t, func(w http.ResponseWriter, r *http.Request) {
+	client := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
 		w.Header().Set("Content-Type", "application/json")
 		w.WriteHeader(http.StatusOK)
 		_, _ = w.Write([]byte(`this is not valid json`))
 	})
-	defer cleanup()
 
-	_, err := source.GetLatestCommitSHAForFile("owner", "repo", "file.txt", "main")
+	_, err := client.GetLatestCommitSHAForFile("owner", "repo", "file.txt", "main")
 	require.Error(t, err)
 	assert.Contains(t, err.Error(), "failed to unmarshal GitHub API response")
 }
 
 func TestGetLatestCommitSHAForFile_NetworkError(t *testing.T) {
-	githubAPITestMutex.Lock()
-	defer githubAPITestMutex.Unlock()
+	t.Parallel()
 
 	// Setup a server that immediately closes the connection
 	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
@@ -116,49 +115,41 @@ func TestGetLatestCommitSHAForFile_NetworkError(t *testing.T) {
 		}
 		_ = conn.Close() // Close immediately to simulate network error
 	}))
-	// No defer server.Close() here as we close it within the test logic for this specific case.
-
-	source.GithubAPIBaseURLMutex.Lock()
-	originalAPIBaseURL := source.GithubAPIBaseURL
-	source.GithubAPIBaseURL = server.URL
-	source.GithubAPIBaseURLMutex.Unlock()
-	source.SetTestModeBypassHostValidation(true) // This function handles its own locking
+	client := source.NewClient(source.Config{APIBaseURL: server.URL})
 
 	// Immediately close the server to ensure the client fails to connect or send request
 	server.Close()
 
-	_, err := source.GetLatestCommitSHAForFile("owner", "repo", "file.txt", "main")
-
-	source.GithubAPIBaseURLMutex.Lock()
-	source.GithubAPIBaseURL = originalAPIBaseURL // Restore original
-	source.GithubAPIBaseURLMutex.Unlock()
-	source.SetTestModeBypassHostValidation(false) // This function handles its own locking
+	_, err := client.GetLatestCommitSHAForFile("owner", "repo", "file.txt", "main")
 
 	require.Error(t, err)
 	assert.Contains(t, err.Error(), "failed to call GitHub API") // Error from httpClient.Do(req)
 }
 
+func TestNewClient_DefaultHTTPClientTimesOut(t *testing.T) {
+	t.Setenv(httpclient.TimeoutEnv, "50ms")
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-r.Context().Done() // Never answer; the client has to give up.
+	}))
+	defer server.Close()
+	client := source.NewClient(source.Config{APIBaseURL: server.URL})
+
+	start := time.Now()
+	_, err := client.GetLatestCommitSHAForFile("owner", "repo", "file.txt", "main")
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "no response from server for 50ms")
+	assert.Less(t, time.Since(start), 5*time.Second)
+}
+
 // MockGitHubCommit is a helper to create GitHubCommitInfo for tests
 func MockGitHubCommit(sha string, date time.Time) source.GitHubCommitInfo {
-	return source.GitHubCommitInfo{
-		SHA: sha,
-		Commit: struct {
-			Committer struct {
-				Date time.Time `json:"date"`
-			} `json:"committer"`
-		}{
-			Committer: struct {
-				Date time.Time `json:"date"`
-			}{
-				Date: date,
-			},
-		},
-	}
+	info := source.GitHubCommitInfo{SHA: sha}
+	info.Commit.Committer.Date = date
+	return info
 }
 
 func TestGetLatestCommitSHAForFile_UsesCorrectURLParameters(t *testing.T) {
-	githubAPITestMutex.Lock()
-	defer githubAPITestMutex.Unlock()
+	t.Parallel()
 
 	owner, repo, pathInRepo, ref := "test-owner", "test-repo", "src/main.go", "develop"
 	expectedSHA := "commitsha123"
@@ -166,7 +157,7 @@ func TestGetLatestCommitSHAForFile_UsesCorrectURLParameters(t *testing.T) {
 	mockResponse := []source.GitHubCommitInfo{MockGitHubCommit(expectedSHA, time.Now())}
 	responseBody, _ := json.Marshal(mockResponse)
 
-	_, cleanup := setupSourceTest(t, func(w http.ResponseWriter, r *http.Request) {
+	client := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
 		expectedPath := fmt.Sprintf("/repos/%s/%s/commits", owner, repo)
 		assert.Equal(t, expectedPath, r.URL.Path)
 		assert.Equal(t, pathInRepo, r.URL.Query().Get("path"))
@@ -175,9 +166,338 @@ func TestGetLatestCommitSHAForFile_UsesCorrectURLParameters(t *testing.T) {
 		w.WriteHeader(http.StatusOK)
 		_, _ = w.Write(responseBody)
 	})
-	defer cleanup()
 
-	sha, err := source.GetLatestCommitSHAForFile(owner, repo, pathInRepo, ref)
+	sha, err := client.GetLatestCommitSHAForFile(owner, repo, pathInRepo, ref)
 	require.NoError(t, err)
 	assert.Equal(t, expectedSHA, sha)
 }
+
+func TestCompareCommits_Success(t *testing.T) {
+	t.Parallel()
+
+	client := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "/repos/owner/repo/compare/oldsha...newsha", r.URL.Path, "Request path mismatch")
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = fmt.Fprint(w, `{"status": "ahead", "ahead_by": 42, "behind_by": 0, "total_commits": 42}`)
+	})
+
+	comparison, err := client.CompareCommits("owner", "repo", "oldsha", "newsha")
+	require.NoError(t, err)
+	assert.Equal(t, "ahead", comparison.Status)
+	assert.Equal(t, 42, comparison.AheadBy)
+	assert.Equal(t, 0, comparison.BehindBy)
+}
+
+func TestCompareCommits_NotFound(t *testing.T) {
+	t.Parallel()
+
+	client := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, `{"message": "Not Found"}`, http.StatusNotFound)
+	})
+
+	_, err := client.CompareCommits("owner", "repo", "oldsha", "newsha")
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "404")
+}
+
+func TestNewClient_Defaults(t *testing.T) {
+	t.Parallel()
+
+	client := source.NewClient(source.Config{})
+	assert.Equal(t, source.DefaultGithubAPIBaseURL, client.APIBaseURL())
+}
+
+func TestNewClient_IsolatedConfiguration(t *testing.T) {
+	t.Parallel()
+
+	clientA := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+		_, _ = fmt.Fprint(w, `[{"sha": "shafromservera"}]`)
+	})
+	clientB := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+		_, _ = fmt.Fprint(w, `[{"sha": "shafromserverb"}]`)
+	})
+
+	shaA, err := clientA.GetLatestCommitSHAForFile("owner", "repo", "file.lua", "main")
+	require.NoError(t, err)
+	shaB, err := clientB.GetLatestCommitSHAForFile("owner", "repo", "file.lua", "main")
+	require.NoError(t, err)
+
+	assert.Equal(t, "shafromservera", shaA)
+	assert.Equal(t, "shafromserverb", shaB)
+}
+
+func TestGetLatestCommitSHAForFile_PackageLevelUsesDefaultClient(t *testing.T) {
+	sourceTestMutex.Lock()
+	defer sourceTestMutex.Unlock()
+
+	_, cleanup := setupSourceTest(t, func(w http.ResponseWriter, r *http.Request) {
+		_, _ = fmt.Fprint(w, `[{"sha": "globalsha"}]`)
+	})
+	defer cleanup()
+
+	sha, err := source.GetLatestCommitSHAForFile("owner", "repo", "file.lua", "main")
+	require.NoError(t, err)
+	assert.Equal(t, "globalsha", sha)
+}
+
+func TestGetLatestCommitForFile_IncludesMessageAndDate(t *testing.T) {
+	t.Parallel()
+
+	client := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+		_, _ = fmt.Fprint(w, `[{"sha": "abc123", "commit": {"message": "Fix decoding\n\nDetails", "committer": {"date": "2024-03-01T12:00:00Z"}}}]`)
+	})
+
+	commit, err := client.GetLatestCommitForFile("owner", "repo", "json.lua", "master")
+	require.NoError(t, err)
+	assert.Equal(t, "abc123", commit.SHA)
+	assert.Equal(t, "Fix decoding\n\nDetails", commit.Commit.Message)
+	assert.Equal(t, time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC), commit.Commit.Committer.Date)
+}
+
+func TestGetRepository(t *testing.T) {
+	t.Parallel()
+
+	client := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "/repos/rxi/json.lua", r.URL.Path)
+		_, _ = fmt.Fprint(w, `{"full_name": "rxi/json.lua", "description": "A lightweight JSON library for Lua", "default_branch": "master", "stargazers_count": 1900}`)
+	})
+
+	repo, err := client.GetRepository("rxi", "json.lua")
+	require.NoError(t, err)
+	assert.Equal(t, "rxi/json.lua", repo.FullName)
+	assert.Equal(t, "A lightweight JSON library for Lua", repo.Description)
+	assert.Equal(t, "master", repo.DefaultBranch)
+	assert.Equal(t, 1900, repo.StargazersCount)
+}
+
+func TestGetDefaultBranch(t *testing.T) {
+	t.Parallel()
+
+	client := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/repos/owner/empty" {
+			_, _ = fmt.Fprint(w, `{"full_name": "owner/empty"}`)
+			return
+		}
+		assert.Equal(t, "/repos/owner/repo", r.URL.Path)
+		_, _ = fmt.Fprint(w, `{"full_name": "owner/repo", "default_branch": "develop"}`)
+	})
+
+	branch, err := client.GetDefaultBranch("owner", "repo")
+	require.NoError(t, err)
+	assert.Equal(t, "develop", branch)
+
+	_, err = client.GetDefaultBranch("owner", "empty")
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "did not report a default branch")
+}
+
+func TestListTags_FollowsPages(t *testing.T) {
+	t.Parallel()
+
+	client := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "/repos/owner/repo/tags", r.URL.Path)
+		assert.Equal(t, "100", r.URL.Query().Get("per_page"))
+		if r.URL.Query().Get("page") == "2" {
+			_, _ = fmt.Fprint(w, `[{"name": "v0.1.0"}]`)
+			return
+		}
+		// A full first page means there may be more.
+		tags := make([]map[string]string, 100)
+		for i := range tags {
+			tags[i] = map[string]string{"name": fmt.Sprintf("v1.0.%d", 99-i)}
+		}
+		_ = json.NewEncoder(w).Encode(tags)
+	})
+
+	tags, err := client.ListTags("owner", "repo")
+	require.NoError(t, err)
+	require.Len(t, tags, 101)
+	assert.Equal(t, "v1.0.99", tags[0])
+	assert.Equal(t, "v0.1.0", tags[100])
+}
+
+func TestClient_SendsTokenWhenConfigured(t *testing.T) {
+	t.Parallel()
+
+	var gotAuth []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		gotAuth = append(gotAuth, r.Header.Get("Authorization"))
+		_, _ = fmt.Fprint(w, `[{"sha": "abc123"}]`)
+	}))
+	t.Cleanup(server.Close)
+
+	_, err := source.NewClient(source.Config{APIBaseURL: server.URL, Token: "secret"}).GetLatestCommitSHAForFile("owner", "repo", "file.lua", "main")
+	require.NoError(t, err)
+	_, err = source.NewClient(source.Config{APIBaseURL: server.URL}).GetLatestCommitSHAForFile("owner", "repo", "file.lua", "main")
+	require.NoError(t, err)
+
+	assert.Equal(t, []string{"Bearer secret", ""}, gotAuth)
+}
+
+func TestAPIError_DistinguishesCredentialsFromRateLimit(t *testing.T) {
+	t.Parallel()
+
+	run := func(token string, status int, remaining string) error {
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if remaining != "" {
+				w.Header().Set("X-RateLimit-Remaining", remaining)
+				w.Header().Set("X-RateLimit-Reset", "1700000000")
+			}
+			w.WriteHeader(status)
+			_, _ = fmt.Fprint(w, `{"message": "nope"}`)
+		}))
+		t.Cleanup(server.Close)
+		_, err := source.NewClient(source.Config{APIBaseURL: server.URL, Token: token}).GetLatestCommitSHAForFile("owner", "repo", "file.lua", "main")
+		require.Error(t, err)
+		return err
+	}
+
+	err := run("bad", http.StatusUnauthorized, "")
+	assert.Contains(t, err.Error(), "bad credentials")
+	assert.Contains(t, err.Error(), "ALMD_GITHUB_TOKEN")
+
+	err = run("", http.StatusForbidden, "0")
+	var apiErr *source.APIError
+	require.ErrorAs(t, err, &apiErr)
+	assert.True(t, apiErr.RateLimited)
+	assert.Equal(t, time.Unix(1700000000, 0), apiErr.RateLimitReset)
+	assert.Contains(t, err.Error(), "rate limit exceeded")
+	assert.Contains(t, err.Error(), "for a higher limit", "anonymous callers are told a token raises the limit")
+
+	err = run("secret", http.StatusForbidden, "0")
+	assert.Contains(t, err.Error(), "rate limit exceeded")
+	assert.NotContains(t, err.Error(), "for a higher limit")
+
+	err = run("secret", http.StatusForbidden, "4999")
+	assert.Contains(t, err.Error(), "denied access")
+	assert.NotContains(t, err.Error(), "rate limit")
+
+	err = run("", http.StatusInternalServerError, "")
+	assert.Contains(t, err.Error(), "GitHub API request failed with status 500 Internal Server Error")
+}
+
+func TestClient_VerboseRateLimitQuota(t *testing.T) {
+	t.Parallel()
+	reset := time.Now().Add(30 * time.Minute)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("X-RateLimit-Limit", "60")
+		w.Header().Set("X-RateLimit-Reset", fmt.Sprint(reset.Unix()))
+		if r.URL.Query().Get("sha") == "exhausted" {
+			w.Header().Set("X-RateLimit-Remaining", "0")
+			w.WriteHeader(http.StatusForbidden)
+			_, _ = fmt.Fprint(w, `{"message": "API rate limit exceeded"}`)
+			return
+		}
+		w.Header().Set("X-RateLimit-Remaining", "59")
+		_, _ = fmt.Fprint(w, `[{"sha": "abc"}]`)
+	}))
+	t.Cleanup(server.Close)
+
+	var verbose bytes.Buffer
+	client := source.NewClient(source.Config{APIBaseURL: server.URL, Verbose: &verbose})
+	_, err := client.GetLatestCommitSHAForFile("owner", "repo", "file.lua", "main")
+	require.NoError(t, err)
+	assert.Equal(t, fmt.Sprintf("  GitHub API rate limit: 59 of 60 requests left, resets at %s\n", time.Unix(reset.Unix(), 0).Local().Format(time.Kitchen)), verbose.String())
+
+	_, err = client.GetLatestCommitSHAForFile("owner", "repo", "file.lua", "exhausted")
+	require.Error(t, err)
+	assert.Contains(t, verbose.String(), "0 of 60 requests left")
+	assert.Contains(t, err.Error(), "rate limit exceeded")
+	assert.Regexp(t, `resets at \S+ \(in (29|30)m\)`, err.Error())
+	assert.Contains(t, err.Error(), "ALMD_GITHUB_TOKEN")
+
+	_, err = source.NewClient(source.Config{APIBaseURL: server.URL}).GetLatestCommitSHAForFile("owner", "repo", "file.lua", "main")
+	require.NoError(t, err, "a client without Verbose reports nothing")
+}
+
+func TestIsBranch(t *testing.T) {
+	t.Parallel()
+
+	client := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/repos/owner/repo/branches/main":
+			_, _ = fmt.Fprint(w, `{"name": "main"}`)
+		case "/repos/owner/repo/branches/v1.0.0":
+			http.Error(w, `{"message": "Branch not found"}`, http.StatusNotFound)
+		default:
+			http.Error(w, `{"message": "Server Error"}`, http.StatusInternalServerError)
+		}
+	})
+
+	isBranch, err := client.IsBranch("owner", "repo", "main")
+	require.NoError(t, err)
+	assert.True(t, isBranch)
+
+	isBranch, err = client.IsBranch("owner", "repo", "v1.0.0")
+	require.NoError(t, err)
+	assert.False(t, isBranch)
+
+	_, err = client.IsBranch("owner", "repo", "broken")
+	var apiErr *source.APIError
+	require.ErrorAs(t, err, &apiErr)
+	assert.Equal(t, http.StatusInternalServerError, apiErr.StatusCode)
+}
+
+func TestExpandCommitSHA(t *testing.T) {
+	t.Parallel()
+
+	fullSHA := "abc1234def5678901234567890abcdef12345678"
+	client := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/repos/owner/repo/commits/abc1234":
+			_, _ = fmt.Fprintf(w, `{"sha": "%s"}`, fullSHA)
+		case "/repos/owner/repo/commits/fff0000":
+			_, _ = fmt.Fprintf(w, `{"sha": "%s"}`, fullSHA)
+		default:
+			http.Error(w, `{"message": "No commit found for SHA"}`, http.StatusUnprocessableEntity)
+		}
+	})
+
+	got, err := client.ExpandCommitSHA("owner", "repo", "abc1234")
+	require.NoError(t, err)
+	assert.Equal(t, fullSHA, got)
+
+	_, err = client.ExpandCommitSHA("owner", "repo", "fff0000")
+	require.Error(t, err, "a commit that does not start with the short SHA is rejected")
+
+	_, err = client.ExpandCommitSHA("owner", "repo", "0000000")
+	var apiErr *source.APIError
+	require.ErrorAs(t, err, &apiErr)
+	assert.Equal(t, http.StatusUnprocessableEntity, apiErr.StatusCode)
+}
+
+func TestListFiles(t *testing.T) {
+	t.Parallel()
+
+	truncated := false
+	client := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "/repos/owner/repo/git/trees/main", r.URL.Path)
+		assert.Equal(t, "1", r.URL.Query().Get("recursive"))
+		_, _ = fmt.Fprintf(w, `{"truncated": %t, "tree": [
+			{"path": "README.md", "mode": "100644", "type": "blob", "sha": "r1"},
+			{"path": "lib", "mode": "040000", "type": "tree", "sha": "t1"},
+			{"path": "lib/foo", "mode": "040000", "type": "tree", "sha": "t2"},
+			{"path": "lib/foo/util/str.lua", "mode": "100644", "type": "blob", "sha": "b2", "size": 7},
+			{"path": "lib/foo/init.lua", "mode": "100644", "type": "blob", "sha": "b1", "size": 5},
+			{"path": "lib/foo/link.lua", "mode": "120000", "type": "blob", "sha": "l1"},
+			{"path": "lib/foo/vendored", "mode": "160000", "type": "commit", "sha": "c1"},
+			{"path": "lib/foobar.lua", "mode": "100644", "type": "blob", "sha": "x1"}
+		]}`, truncated)
+	})
+
+	files, err := client.ListFiles("owner", "repo", "main", "lib/foo")
+	require.NoError(t, err)
+	assert.Equal(t, []source.TreeEntry{
+		{Path: "lib/foo/init.lua", Mode: "100644", Type: "blob", SHA: "b1", Size: 5},
+		{Path: "lib/foo/util/str.lua", Mode: "100644", Type: "blob", SHA: "b2", Size: 7},
+	}, files)
+
+	_, err = client.ListFiles("owner", "repo", "main", "docs")
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "no files found under 'docs'")
+
+	truncated = true
+	_, err = client.ListFiles("owner", "repo", "main", "lib/foo")
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "truncated")
+}
@@ -0,0 +1,68 @@
+package source
+
+import (
+	"errors"
+	"fmt"
+	"net/url"
+	"strings"
+)
+
+// ErrHostNotAllowed is returned (wrapped) by AllowedHostsHook when a source's download host
+// is not on the configured allowlist.
+var ErrHostNotAllowed = errors.New("host is not in allowed_hosts")
+
+// Hook is invoked on a parsed source after ParseSourceURL and before anything is downloaded
+// from it. A hook may rewrite fields of info (for example, pointing RawURL at an approved
+// mirror) or return an error to reject the source outright.
+type Hook func(info *ParsedSourceInfo) error
+
+// ApplyHooks runs each hook against info in order, stopping at the first error.
+func ApplyHooks(info *ParsedSourceInfo, hooks ...Hook) error {
+	for _, hook := range hooks {
+		if hook == nil {
+			continue
+		}
+		if err := hook(info); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// CheckURL runs hooks against a URL that is fetched exactly as written, such as a source locked
+// in almd-lock.toml or a published checksum file, and returns the URL to fetch, which a hook
+// may have rewritten.
+func CheckURL(rawURL string, hooks ...Hook) (string, error) {
+	if _, err := url.Parse(rawURL); err != nil {
+		return "", fmt.Errorf("failed to parse URL '%s': %w", rawURL, err)
+	}
+	info := &ParsedSourceInfo{RawURL: rawURL, CanonicalURL: rawURL}
+	if err := ApplyHooks(info, hooks...); err != nil {
+		return "", err
+	}
+	return info.RawURL, nil
+}
+
+// AllowedHostsHook returns a Hook that rejects any source whose RawURL host is not listed in
+// allowedHosts. Entries are compared case-insensitively and may either be a bare hostname
+// (matching any port) or a host:port pair. An empty allowlist permits every host.
+func AllowedHostsHook(allowedHosts []string) Hook {
+	return func(info *ParsedSourceInfo) error {
+		if len(allowedHosts) == 0 {
+			return nil
+		}
+		u, err := url.Parse(info.RawURL)
+		if err != nil {
+			return fmt.Errorf("failed to parse download URL '%s' for policy check: %w", info.RawURL, err)
+		}
+		hostname := strings.ToLower(u.Hostname())
+		hostWithPort := strings.ToLower(u.Host)
+		for _, allowed := range allowedHosts {
+			allowed = strings.ToLower(strings.TrimSpace(allowed))
+			if allowed == hostname || allowed == hostWithPort {
+				return nil
+			}
+		}
+		return fmt.Errorf("%w: '%s' (download URL %s)", ErrHostNotAllowed, u.Host, info.RawURL)
+	}
+}
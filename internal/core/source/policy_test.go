@@ -0,0 +1,73 @@
+// Package source_test contains tests for the source package, specifically source policy hooks.
+package source_test
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/nightconcept/almandine-go/internal/core/source"
+)
+
+func TestAllowedHostsHook_AllowedHostPasses(t *testing.T) {
+	info := &source.ParsedSourceInfo{RawURL: "https://raw.githubusercontent.com/owner/repo/main/lib.lua"}
+
+	err := source.ApplyHooks(info, source.AllowedHostsHook([]string{"mirror.example.com", "RAW.githubusercontent.com"}))
+	assert.NoError(t, err)
+}
+
+func TestAllowedHostsHook_HostWithPort(t *testing.T) {
+	info := &source.ParsedSourceInfo{RawURL: "http://127.0.0.1:8080/owner/repo/main/lib.lua"}
+
+	assert.NoError(t, source.ApplyHooks(info, source.AllowedHostsHook([]string{"127.0.0.1:8080"})))
+	assert.NoError(t, source.ApplyHooks(info, source.AllowedHostsHook([]string{"127.0.0.1"})))
+	assert.Error(t, source.ApplyHooks(info, source.AllowedHostsHook([]string{"127.0.0.1:9090"})))
+}
+
+func TestAllowedHostsHook_DisallowedHostBlocked(t *testing.T) {
+	info := &source.ParsedSourceInfo{RawURL: "https://raw.githubusercontent.com/owner/repo/main/lib.lua"}
+
+	err := source.ApplyHooks(info, source.AllowedHostsHook([]string{"mirror.example.com"}))
+	require.Error(t, err)
+	assert.True(t, errors.Is(err, source.ErrHostNotAllowed), "error should wrap ErrHostNotAllowed, got: %v", err)
+	assert.Contains(t, err.Error(), "raw.githubusercontent.com")
+}
+
+func TestAllowedHostsHook_EmptyAllowlistPermitsAll(t *testing.T) {
+	info := &source.ParsedSourceInfo{RawURL: "https://anything.example.org/lib.lua"}
+
+	assert.NoError(t, source.ApplyHooks(info, source.AllowedHostsHook(nil)))
+}
+
+func TestApplyHooks_RewriteThenValidate(t *testing.T) {
+	info := &source.ParsedSourceInfo{RawURL: "https://raw.githubusercontent.com/owner/repo/main/lib.lua"}
+	rewrite := func(i *source.ParsedSourceInfo) error {
+		i.RawURL = "https://mirror.example.com/owner/repo/main/lib.lua"
+		return nil
+	}
+
+	err := source.ApplyHooks(info, rewrite, source.AllowedHostsHook([]string{"mirror.example.com"}))
+	require.NoError(t, err)
+	assert.Equal(t, "https://mirror.example.com/owner/repo/main/lib.lua", info.RawURL)
+}
+
+func TestCheckURL(t *testing.T) {
+	allowed := source.AllowedHostsHook([]string{"mirror.example.com"})
+
+	checked, err := source.CheckURL("https://mirror.example.com/lib.lua.sha256", allowed)
+	require.NoError(t, err)
+	assert.Equal(t, "https://mirror.example.com/lib.lua.sha256", checked)
+
+	_, err = source.CheckURL("https://evil.example.org/lib.lua.sha256", allowed)
+	assert.True(t, errors.Is(err, source.ErrHostNotAllowed), "error should wrap ErrHostNotAllowed, got: %v", err)
+
+	rewrite := func(i *source.ParsedSourceInfo) error {
+		i.RawURL = "https://mirror.example.com/lib.lua.sha256"
+		return nil
+	}
+	checked, err = source.CheckURL("https://raw.githubusercontent.com/owner/repo/main/lib.lua.sha256", rewrite, allowed)
+	require.NoError(t, err)
+	assert.Equal(t, "https://mirror.example.com/lib.lua.sha256", checked, "the rewritten URL is the one to fetch")
+}
@@ -0,0 +1,88 @@
+package source
+
+import (
+	"regexp"
+	"sync"
+)
+
+// Provider captures the host-specific rules that resolution logic relies on, so install, add
+// and pin do not have to assume every source is a Git repository on GitHub.
+type Provider interface {
+	// IsImmutableRef reports whether ref already names a fixed revision (such as a Git commit
+	// SHA) that never needs to be resolved against the provider.
+	IsImmutableRef(ref string) bool
+}
+
+// gitCommitSHARegex matches abbreviated and full Git commit SHAs.
+var gitCommitSHARegex = regexp.MustCompile(`^[0-9a-f]{7,40}$`)
+
+// IsAbbreviatedCommitSHA reports whether ref is a GitHub commit SHA shorter than the full 40
+// characters. Such refs are immutable but ambiguous, so they are expanded before being locked.
+func IsAbbreviatedCommitSHA(provider, ref string) bool {
+	return provider == "github" && len(ref) < 40 && gitCommitSHARegex.MatchString(ref)
+}
+
+// gitHubProvider treats hex commit SHAs as immutable; branches and tags are not.
+type gitHubProvider struct{}
+
+func (gitHubProvider) IsImmutableRef(ref string) bool {
+	return gitCommitSHARegex.MatchString(ref)
+}
+
+// httpProvider serves plain files from a web server. A URL has no revisions, so nothing it
+// serves is immutable and installs compare content instead.
+type httpProvider struct{}
+
+func (httpProvider) IsImmutableRef(string) bool {
+	return false
+}
+
+// gitHubReleaseProvider serves assets attached to a release. A tag can be moved and an asset
+// replaced without any commit recording it, so no ref is immutable and installs compare content.
+type gitHubReleaseProvider struct{}
+
+func (gitHubReleaseProvider) IsImmutableRef(string) bool {
+	return false
+}
+
+var (
+	providersMu sync.RWMutex
+	providers   = map[string]Provider{
+		"github":         gitHubProvider{},
+		"github-release": gitHubReleaseProvider{},
+		"http":           httpProvider{},
+	}
+)
+
+// RegisterProvider makes p the provider for sources whose ParsedSourceInfo.Provider is name.
+// It returns a function that restores the previous registration.
+func RegisterProvider(name string, p Provider) (restore func()) {
+	providersMu.Lock()
+	defer providersMu.Unlock()
+	previous, existed := providers[name]
+	providers[name] = p
+	return func() {
+		providersMu.Lock()
+		defer providersMu.Unlock()
+		if existed {
+			providers[name] = previous
+		} else {
+			delete(providers, name)
+		}
+	}
+}
+
+// LookupProvider returns the provider registered under name.
+func LookupProvider(name string) (Provider, bool) {
+	providersMu.RLock()
+	defer providersMu.RUnlock()
+	p, ok := providers[name]
+	return p, ok
+}
+
+// IsImmutableRef reports whether ref is a fixed revision according to the named provider's own
+// rule. Refs from unknown providers are never considered immutable.
+func IsImmutableRef(provider, ref string) bool {
+	p, ok := LookupProvider(provider)
+	return ok && p.IsImmutableRef(ref)
+}
@@ -0,0 +1,45 @@
+package source_test
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/nightconcept/almandine-go/internal/core/source"
+)
+
+// revProvider stands in for a non-Git provider whose fixed revisions look like "rev-<n>".
+type revProvider struct{}
+
+func (revProvider) IsImmutableRef(ref string) bool {
+	return strings.HasPrefix(ref, "rev-")
+}
+
+func TestIsImmutableRef_GitHub(t *testing.T) {
+	assert.True(t, source.IsImmutableRef("github", "0123456789abcdef0123456789abcdef01234567"))
+	assert.True(t, source.IsImmutableRef("github", "abc1234"), "abbreviated SHAs are fixed revisions")
+	assert.False(t, source.IsImmutableRef("github", "main"))
+	assert.False(t, source.IsImmutableRef("github", "v1.0.0"))
+	assert.False(t, source.IsImmutableRef("github", "rev-42"))
+}
+
+func TestIsAbbreviatedCommitSHA(t *testing.T) {
+	assert.True(t, source.IsAbbreviatedCommitSHA("github", "abc1234"))
+	assert.False(t, source.IsAbbreviatedCommitSHA("github", "0123456789abcdef0123456789abcdef01234567"), "full SHAs need no expansion")
+	assert.False(t, source.IsAbbreviatedCommitSHA("github", "main"))
+	assert.False(t, source.IsAbbreviatedCommitSHA("http", "abc1234"))
+}
+
+func TestIsImmutableRef_ProviderDecides(t *testing.T) {
+	restore := source.RegisterProvider("hg", revProvider{})
+
+	assert.True(t, source.IsImmutableRef("hg", "rev-42"))
+	assert.False(t, source.IsImmutableRef("hg", "0123456789abcdef0123456789abcdef01234567"), "the Git SHA rule must not leak into other providers")
+	assert.False(t, source.IsImmutableRef("hg", "default"))
+
+	restore()
+	_, ok := source.LookupProvider("hg")
+	assert.False(t, ok, "restore removes a provider that was not registered before")
+	assert.False(t, source.IsImmutableRef("hg", "rev-42"), "unknown providers never report immutable refs")
+}
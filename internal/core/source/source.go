@@ -3,8 +3,11 @@ package source
 import (
 	"fmt"
 	"net/url"
+	"path"
 	"strings"
 	"sync" // Added import for sync
+
+	"github.com/nightconcept/almandine-go/internal/core/githost"
 )
 
 // testModeBypassHostValidation is an internal flag for testing to bypass hostname checks.
@@ -25,15 +28,48 @@ type ParsedSourceInfo struct {
 	RawURL            string // The raw URL to download the file content
 	CanonicalURL      string // The canonical representation (e.g., github:owner/repo/path/to/file@ref)
 	Ref               string // The commit hash, branch, or tag
-	Provider          string // e.g., "github"
+	Provider          string // "github", "github-release" for release assets, or "http" for files on a plain web server
 	Owner             string
 	Repo              string
-	PathInRepo        string
+	PathInRepo        string // For "http" sources, the URL path; for "github-release", the asset name
 	SuggestedFilename string
+	// VersionRange is set when the ref is a semver range such as ^1.2.0. Ref is then empty and
+	// RawURL only names the host and path until ResolveVersionRange picks a tag.
+	VersionRange string
+}
+
+// RawURLAt returns the download URL of the same GitHub file at ref instead of Ref. It is built
+// from Owner, Repo and PathInRepo, so an owner or repository named like the ref is left alone.
+// Other providers have no ref in their URL and keep RawURL.
+func (p *ParsedSourceInfo) RawURLAt(ref string) string {
+	if p.Provider != "github" {
+		return p.RawURL
+	}
+	TestModeBypassHostValidationMutex.Lock()
+	currentTestModeBypass := testModeBypassHostValidation
+	TestModeBypassHostValidationMutex.Unlock()
+	if currentTestModeBypass {
+		// Test mode serves raw content as /<owner>/<repo>/<ref>/<path> on the mock server.
+		if u, err := url.Parse(p.RawURL); err == nil {
+			return fmt.Sprintf("%s://%s/%s/%s/%s/%s", u.Scheme, u.Host, p.Owner, p.Repo, ref, p.PathInRepo)
+		}
+	}
+	return githost.RawURL(p.Owner, p.Repo, ref, p.PathInRepo)
+}
+
+// withVersionRange moves a ref that is a version range out of info.Ref, since it names no
+// particular tag or commit.
+func withVersionRange(info *ParsedSourceInfo) *ParsedSourceInfo {
+	if IsVersionRange(info.Ref) {
+		info.VersionRange, info.Ref = info.Ref, ""
+	}
+	return info
 }
 
 // ParseSourceURL analyzes the input source URL string and returns structured information.
-// It currently prioritizes GitHub URLs.
+// GitHub URLs and github: shorthands are understood in detail; any other http(s) URL is
+// treated as a plain file download. Both refer to github.com unless githost configures a
+// self-hosted instance.
 func ParseSourceURL(sourceURL string) (*ParsedSourceInfo, error) {
 	if strings.HasPrefix(sourceURL, "github:") {
 		// Handle github:owner/repo/path/to/file@ref format
@@ -79,10 +115,10 @@ func ParseSourceURL(sourceURL string) (*ParsedSourceInfo, error) {
 			GithubAPIBaseURLMutex.Unlock()
 			rawURL = fmt.Sprintf("%s/%s/%s/%s/%s", currentGithubAPIBaseURL, owner, repo, ref, pathInRepo)
 		} else {
-			rawURL = fmt.Sprintf("https://raw.githubusercontent.com/%s/%s/%s/%s", owner, repo, ref, pathInRepo)
+			rawURL = githost.RawURL(owner, repo, ref, pathInRepo)
 		}
 
-		return &ParsedSourceInfo{
+		return withVersionRange(&ParsedSourceInfo{
 			RawURL:            rawURL,
 			CanonicalURL:      sourceURL, // The input is already the canonical form for this type
 			Ref:               ref,
@@ -91,7 +127,7 @@ func ParseSourceURL(sourceURL string) (*ParsedSourceInfo, error) {
 			Repo:              repo,
 			PathInRepo:        pathInRepo,
 			SuggestedFilename: suggestedFilename,
-		}, nil
+		}), nil
 	}
 
 	// Existing logic for full URLs
@@ -139,12 +175,30 @@ func ParseSourceURL(sourceURL string) (*ParsedSourceInfo, error) {
 		}, nil
 	}
 
-	if strings.ToLower(u.Hostname()) == "github.com" || strings.ToLower(u.Hostname()) == "raw.githubusercontent.com" {
+	if githost.Matches(u.Host) {
 		return parseGitHubURL(u)
 	}
 
-	// Placeholder for other providers or generic git repositories
-	return nil, fmt.Errorf("unsupported source URL host: %s. Only GitHub URLs are currently supported", u.Hostname())
+	if (u.Scheme == "http" || u.Scheme == "https") && u.Host != "" {
+		return parseHTTPURL(sourceURL, u)
+	}
+	return nil, fmt.Errorf("unsupported source URL '%s': expected a github: source or an http(s) URL", sourceURL)
+}
+
+// parseHTTPURL handles plain files served over HTTP(S) by any web server. There is no ref to
+// resolve, so the URL is both the download and canonical location and is used verbatim.
+func parseHTTPURL(sourceURL string, u *url.URL) (*ParsedSourceInfo, error) {
+	filename := path.Base(u.Path)
+	if strings.HasSuffix(u.Path, "/") || filename == "." || filename == "/" {
+		return nil, fmt.Errorf("invalid source URL '%s': it does not name a file", sourceURL)
+	}
+	return &ParsedSourceInfo{
+		RawURL:            sourceURL,
+		CanonicalURL:      sourceURL,
+		Provider:          "http",
+		PathInRepo:        strings.TrimPrefix(u.Path, "/"),
+		SuggestedFilename: filename,
+	}, nil
 }
 
 // parseGitHubURL handles the specifics of parsing GitHub URLs.
@@ -157,7 +211,7 @@ func parseGitHubURL(u *url.URL) (*ParsedSourceInfo, error) {
 	// Example: https://raw.githubusercontent.com/owner/repo/main/path/to/file.go
 
 	pathParts := strings.Split(strings.Trim(u.Path, "/"), "/")
-	if strings.ToLower(u.Hostname()) == "raw.githubusercontent.com" {
+	if githost.IsRawHost(u.Host) {
 		// Format: /<owner>/<repo>/<ref>/<path_to_file>
 		if len(pathParts) < 4 {
 			return nil, fmt.Errorf("invalid GitHub raw content URL path: %s. Expected format: /<owner>/<repo>/<ref>/<path_to_file>", u.Path)
@@ -182,6 +236,11 @@ func parseGitHubURL(u *url.URL) (*ParsedSourceInfo, error) {
 		}, nil
 	}
 
+	// Release assets: /<owner>/<repo>/releases/download/<tag>/<asset>
+	if len(pathParts) >= 4 && pathParts[2] == "releases" && pathParts[3] == "download" {
+		return parseGitHubReleaseURL(u, pathParts)
+	}
+
 	// Regular github.com URL
 	if len(pathParts) < 2 {
 		return nil, fmt.Errorf("invalid GitHub URL path: %s. Expected at least /<owner>/<repo>", u.Path)
@@ -198,6 +257,15 @@ func parseGitHubURL(u *url.URL) (*ParsedSourceInfo, error) {
 	// Check for patterns like /blob/, /tree/, /raw/
 	// /<owner>/<repo>/blob/<ref>/<path_to_file>
 	// /<owner>/<repo>/raw/<ref>/<path_to_file> (less common for user input but possible)
+	// Gitea spells these /src/ and /raw/ with the kind of ref in between, as in
+	// /<owner>/<repo>/raw/branch/<ref>/<path_to_file>; the kind is dropped.
+	if !githost.IsDefault() && len(pathParts) >= 5 && (pathParts[2] == "src" || pathParts[2] == "raw") &&
+		(pathParts[3] == "branch" || pathParts[3] == "tag" || pathParts[3] == "commit") {
+		if pathParts[2] == "src" {
+			pathParts[2] = "blob"
+		}
+		pathParts = append(pathParts[:3], pathParts[4:]...)
+	}
 	if len(pathParts) >= 4 && (pathParts[2] == "blob" || pathParts[2] == "tree" || pathParts[2] == "raw") {
 		if len(pathParts) < 5 {
 			return nil, fmt.Errorf("incomplete GitHub URL path: %s. Expected /<owner>/<repo>/<type>/<ref>/<path_to_file>", u.Path)
@@ -211,7 +279,7 @@ func parseGitHubURL(u *url.URL) (*ParsedSourceInfo, error) {
 			return nil, fmt.Errorf("direct links to GitHub trees are not supported for adding single files: %s", u.String())
 		}
 		// Normalize to raw content URL
-		rawURL = fmt.Sprintf("https://raw.githubusercontent.com/%s/%s/%s/%s", owner, repo, ref, filePathInRepo)
+		rawURL = githost.RawURL(owner, repo, ref, filePathInRepo)
 
 	} else {
 		// Try to extract ref and path if a shorthand URL is given, e.g., github.com/owner/repo/file.txt@main
@@ -227,12 +295,9 @@ func parseGitHubURL(u *url.URL) (*ParsedSourceInfo, error) {
 		// Let's use a regex to find ref in the path for URLs like:
 		// github.com/user/repo/path/to/file.txt@v1.0.0
 		// github.com/user/repo/path/to/file.txt@commitsha
-		// github.com/user/repo/path/to/file.txt (implies default branch, but we need a way to get it)
-		// For now, we'll assume if no /blob/ or /raw/ and no @ in path, we might need to query default branch.
-		// Task 2.2 focuses on parsing, let's assume ref is extractable or default is handled later.
+		// github.com/user/repo/path/to/file.txt (implies the default branch, looked up via the API)
 
-		// Simplified: if not blob/raw/tree, the path from part 2 onwards is file path, ref needs to be found or assumed.
-		// For now, let's require a ref to be specified using '@' if not using blob/raw URLs.
+		// If not blob/raw/tree, the path from part 2 onwards is the file path, optionally followed by @ref.
 		// Example: github.com/owner/repo/some/file.go@main
 
 		potentialPathWithRef := strings.Join(pathParts[2:], "/")
@@ -247,16 +312,18 @@ func parseGitHubURL(u *url.URL) (*ParsedSourceInfo, error) {
 			} else {
 				filename = "default_filename" // Or error if path is empty
 			}
-		} else {
-			// No explicit ref in path, no blob/raw.
-			// This case is ambiguous without fetching default branch info from GitHub API.
-			// For now, we'll error or require a more specific URL.
-			// Let's assume default ref = "main" for now, can be enhanced.
-			// This part might need adjustment based on how we fetch default branch or if we enforce explicit refs always.
-			// For simplicity in this step, let's error if ref is not obvious.
-			return nil, fmt.Errorf("ambiguous GitHub URL: %s. Specify a branch/tag/commit via '@' (e.g., file.txt@main) or use a full /blob/ or /raw/ URL", u.String())
+		} else if potentialPathWithRef != "" {
+			// No explicit ref in path, no blob/raw: the file is taken from the repository's default
+			// branch, which only the GitHub API can tell us.
+			filePathInRepo = potentialPathWithRef
+			filename = pathParts[len(pathParts)-1]
+			defaultBranch, err := GetDefaultBranch(owner, repo)
+			if err != nil {
+				return nil, fmt.Errorf("ambiguous GitHub URL: %s. Could not look up the repository's default branch (%v); specify a branch/tag/commit via '@' (e.g., file.txt@main) or use a full /blob/ or /raw/ URL", u.String(), err)
+			}
+			ref = defaultBranch
 		}
-		rawURL = fmt.Sprintf("https://raw.githubusercontent.com/%s/%s/%s/%s", owner, repo, ref, filePathInRepo)
+		rawURL = githost.RawURL(owner, repo, ref, filePathInRepo)
 	}
 
 	if filePathInRepo == "" {
@@ -270,7 +337,7 @@ func parseGitHubURL(u *url.URL) (*ParsedSourceInfo, error) {
 
 	canonicalURL := fmt.Sprintf("github:%s/%s/%s@%s", owner, repo, filePathInRepo, ref)
 
-	return &ParsedSourceInfo{
+	return withVersionRange(&ParsedSourceInfo{
 		RawURL:            rawURL,
 		CanonicalURL:      canonicalURL,
 		Ref:               ref,
@@ -279,5 +346,24 @@ func parseGitHubURL(u *url.URL) (*ParsedSourceInfo, error) {
 		Repo:              repo,
 		PathInRepo:        filePathInRepo,
 		SuggestedFilename: filename,
+	}), nil
+}
+
+// parseGitHubReleaseURL handles files attached to a release, which live outside the repository
+// tree. The tag is the ref, but an asset has no commit or blob behind it, so installs lock it by
+// its content hash; the asset URL is both the download and canonical location.
+func parseGitHubReleaseURL(u *url.URL, pathParts []string) (*ParsedSourceInfo, error) {
+	if len(pathParts) != 6 || pathParts[4] == "" || pathParts[5] == "" {
+		return nil, fmt.Errorf("invalid GitHub release asset URL path: %s. Expected format: /<owner>/<repo>/releases/download/<tag>/<asset>", u.Path)
+	}
+	return &ParsedSourceInfo{
+		RawURL:            u.String(),
+		CanonicalURL:      u.String(),
+		Ref:               pathParts[4],
+		Provider:          "github-release",
+		Owner:             pathParts[0],
+		Repo:              pathParts[1],
+		PathInRepo:        pathParts[5],
+		SuggestedFilename: pathParts[5],
 	}, nil
 }
@@ -12,6 +12,7 @@ import (
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 
+	"github.com/nightconcept/almandine-go/internal/core/githost"
 	"github.com/nightconcept/almandine-go/internal/core/source"
 )
 
@@ -235,12 +236,6 @@ func TestParseSourceURL_FullGitHubURLs(t *testing.T) {
 			wantErr:     true,
 			errContains: "invalid GitHub raw content URL path",
 		},
-		{
-			name:        "ambiguous github.com url (no ref, no blob/raw)",
-			url:         "https://github.com/owner/repo/path/file.txt",
-			wantErr:     true,
-			errContains: "ambiguous GitHub URL",
-		},
 		{
 			name:        "incomplete github.com blob url",
 			url:         "https://github.com/owner/repo/blob/main", // missing filepath
@@ -266,6 +261,105 @@ func TestParseSourceURL_FullGitHubURLs(t *testing.T) {
 	}
 }
 
+func TestParseSourceURL_GitHubReleaseAsset(t *testing.T) {
+	sourceTestMutex.Lock()
+	defer sourceTestMutex.Unlock()
+
+	t.Run("release download url", func(t *testing.T) {
+		got, err := source.ParseSourceURL("https://github.com/owner/repo/releases/download/v1.2.0/lib.lua")
+		require.NoError(t, err)
+		assert.Equal(t, &source.ParsedSourceInfo{
+			RawURL:            "https://github.com/owner/repo/releases/download/v1.2.0/lib.lua",
+			CanonicalURL:      "https://github.com/owner/repo/releases/download/v1.2.0/lib.lua",
+			Ref:               "v1.2.0",
+			Provider:          "github-release",
+			Owner:             "owner",
+			Repo:              "repo",
+			PathInRepo:        "lib.lua",
+			SuggestedFilename: "lib.lua",
+		}, got)
+		assert.False(t, source.IsImmutableRef(got.Provider, got.Ref), "a release tag is locked by content")
+	})
+
+	t.Run("self-hosted release download url", func(t *testing.T) {
+		t.Setenv(githost.HostEnv, "git.internal.example.com")
+		got, err := source.ParseSourceURL("https://git.internal.example.com/team/lib/releases/download/v2.0.0/util.lua")
+		require.NoError(t, err)
+		assert.Equal(t, "github-release", got.Provider)
+		assert.Equal(t, "v2.0.0", got.Ref)
+		assert.Equal(t, "https://git.internal.example.com/team/lib/releases/download/v2.0.0/util.lua", got.RawURL)
+	})
+
+	tests := map[string]string{
+		"missing asset":  "https://github.com/owner/repo/releases/download/v1.2.0",
+		"missing tag":    "https://github.com/owner/repo/releases/download",
+		"nested asset":   "https://github.com/owner/repo/releases/download/v1.2.0/dir/lib.lua",
+		"empty tag part": "https://github.com/owner/repo/releases/download//lib.lua",
+	}
+	for name, url := range tests {
+		t.Run(name, func(t *testing.T) {
+			_, err := source.ParseSourceURL(url)
+			require.Error(t, err)
+			assert.Contains(t, err.Error(), "invalid GitHub release asset URL path")
+		})
+	}
+}
+
+// useMockGitHubAPI points the default GitHub API client at a server running handler, without
+// enabling test-mode URL parsing.
+func useMockGitHubAPI(t *testing.T, handler http.HandlerFunc) {
+	t.Helper()
+	server := httptest.NewServer(handler)
+	source.GithubAPIBaseURLMutex.Lock()
+	originalAPIBaseURL := source.GithubAPIBaseURL
+	source.GithubAPIBaseURL = server.URL
+	source.GithubAPIBaseURLMutex.Unlock()
+	t.Cleanup(func() {
+		server.Close()
+		source.GithubAPIBaseURLMutex.Lock()
+		source.GithubAPIBaseURL = originalAPIBaseURL
+		source.GithubAPIBaseURLMutex.Unlock()
+	})
+}
+
+func TestParseSourceURL_NoRefUsesDefaultBranch(t *testing.T) {
+	sourceTestMutex.Lock()
+	defer sourceTestMutex.Unlock()
+
+	useMockGitHubAPI(t, func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "/repos/owner/repo", r.URL.Path)
+		_, _ = fmt.Fprint(w, `{"full_name": "owner/repo", "default_branch": "trunk"}`)
+	})
+
+	got, err := source.ParseSourceURL("https://github.com/owner/repo/path/file.txt")
+	require.NoError(t, err)
+	assert.Equal(t, &source.ParsedSourceInfo{
+		RawURL:            "https://raw.githubusercontent.com/owner/repo/trunk/path/file.txt",
+		CanonicalURL:      "github:owner/repo/path/file.txt@trunk",
+		Ref:               "trunk",
+		Provider:          "github",
+		Owner:             "owner",
+		Repo:              "repo",
+		PathInRepo:        "path/file.txt",
+		SuggestedFilename: "file.txt",
+	}, got)
+}
+
+func TestParseSourceURL_NoRefDefaultBranchLookupFails(t *testing.T) {
+	sourceTestMutex.Lock()
+	defer sourceTestMutex.Unlock()
+
+	useMockGitHubAPI(t, func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, `{"message": "Not Found"}`, http.StatusNotFound)
+	})
+
+	_, err := source.ParseSourceURL("https://github.com/owner/missing/path/file.txt")
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "ambiguous GitHub URL")
+	assert.Contains(t, err.Error(), "default branch")
+	assert.Contains(t, err.Error(), "404 Not Found")
+}
+
 func TestParseSourceURL_WithTestModeBypass_FullMockURL(t *testing.T) {
 	sourceTestMutex.Lock()
 	defer sourceTestMutex.Unlock()
@@ -347,28 +441,45 @@ func TestParseSourceURL_NonGitHubURLs(t *testing.T) {
 	sourceTestMutex.Lock()
 	defer sourceTestMutex.Unlock()
 
+	t.Run("plain http url", func(t *testing.T) {
+		got, err := source.ParseSourceURL("http://example.com/libs/foo.lua")
+		require.NoError(t, err)
+		assert.Equal(t, &source.ParsedSourceInfo{
+			RawURL:            "http://example.com/libs/foo.lua",
+			CanonicalURL:      "http://example.com/libs/foo.lua",
+			Provider:          "http",
+			PathInRepo:        "libs/foo.lua",
+			SuggestedFilename: "foo.lua",
+		}, got)
+		assert.False(t, source.IsImmutableRef(got.Provider, got.Ref))
+	})
+
+	t.Run("other hosts are plain downloads too", func(t *testing.T) {
+		got, err := source.ParseSourceURL("https://gitlab.com/user/project/raw/main/file.lua?inline=false")
+		require.NoError(t, err)
+		assert.Equal(t, "http", got.Provider)
+		assert.Equal(t, "https://gitlab.com/user/project/raw/main/file.lua?inline=false", got.RawURL, "the URL is used verbatim")
+		assert.Equal(t, "file.lua", got.SuggestedFilename)
+	})
+
 	tests := []struct {
 		name        string
 		url         string
-		wantErr     bool
 		errContains string
 	}{
 		{
-			name:        "unsupported http url",
-			url:         "http://example.com/somefile.txt",
-			wantErr:     true,
-			errContains: "unsupported source URL host: example.com",
+			name:        "directory url",
+			url:         "https://example.com/libs/",
+			errContains: "does not name a file",
 		},
 		{
-			name:        "unsupported gitlab url",
-			url:         "https://gitlab.com/user/project/raw/main/file.lua",
-			wantErr:     true,
-			errContains: "unsupported source URL host: gitlab.com",
+			name:        "unsupported scheme",
+			url:         "ftp://example.com/foo.lua",
+			errContains: "unsupported source URL",
 		},
 		{
 			name:        "invalid url format",
 			url:         ":not_a_url",
-			wantErr:     true,
 			errContains: "failed to parse source URL",
 		},
 	}
@@ -378,9 +489,60 @@ func TestParseSourceURL_NonGitHubURLs(t *testing.T) {
 		t.Run(tt.name, func(t *testing.T) {
 			_, err := source.ParseSourceURL(tt.url)
 			require.Error(t, err)
-			if tt.errContains != "" {
-				assert.Contains(t, err.Error(), tt.errContains)
-			}
+			assert.Contains(t, err.Error(), tt.errContains)
+		})
+	}
+}
+
+func TestRawURLAt(t *testing.T) {
+	sourceTestMutex.Lock()
+	defer sourceTestMutex.Unlock()
+	sha := "0123456789abcdef0123456789abcdef01234567"
+
+	got, err := source.ParseSourceURL("github:foo/dev/dev/lib.lua@dev")
+	require.NoError(t, err)
+	assert.Equal(t, "https://raw.githubusercontent.com/foo/dev/"+sha+"/dev/lib.lua", got.RawURLAt(sha), "only the ref segment changes, not a repository or directory named like it")
+
+	plain, err := source.ParseSourceURL("https://example.com/dev/lib.lua")
+	require.NoError(t, err)
+	assert.Equal(t, "https://example.com/dev/lib.lua", plain.RawURLAt(sha))
+}
+
+func TestParseSourceURL_SelfHostedGitHost(t *testing.T) {
+	sourceTestMutex.Lock()
+	defer sourceTestMutex.Unlock()
+	t.Setenv(githost.HostEnv, "git.internal.example.com")
+
+	t.Run("shorthand downloads from the configured host", func(t *testing.T) {
+		got, err := source.ParseSourceURL("github:team/lib/src/util.lua@v1.0.0")
+		require.NoError(t, err)
+		assert.Equal(t, "https://git.internal.example.com/team/lib/raw/v1.0.0/src/util.lua", got.RawURL)
+		assert.Equal(t, "github", got.Provider)
+	})
+
+	urls := map[string]string{
+		"blob url":       "https://git.internal.example.com/team/lib/blob/v1.0.0/src/util.lua",
+		"raw url":        "https://git.internal.example.com/team/lib/raw/v1.0.0/src/util.lua",
+		"gitea raw url":  "https://git.internal.example.com/team/lib/raw/tag/v1.0.0/src/util.lua",
+		"gitea src url":  "https://git.internal.example.com/team/lib/src/branch/v1.0.0/src/util.lua",
+		"shorthand path": "https://git.internal.example.com/team/lib/src/util.lua@v1.0.0",
+	}
+	for name, url := range urls {
+		t.Run(name, func(t *testing.T) {
+			got, err := source.ParseSourceURL(url)
+			require.NoError(t, err)
+			assert.Equal(t, "github:team/lib/src/util.lua@v1.0.0", got.CanonicalURL)
+			assert.Equal(t, "https://git.internal.example.com/team/lib/raw/v1.0.0/src/util.lua", got.RawURL)
 		})
 	}
+
+	t.Run("github.com is a plain web host", func(t *testing.T) {
+		got, err := source.ParseSourceURL("https://raw.githubusercontent.com/owner/repo/main/file.lua")
+		require.NoError(t, err)
+		assert.Equal(t, "http", got.Provider)
+	})
+
+	t.Run("the API client follows the host", func(t *testing.T) {
+		assert.Equal(t, "https://git.internal.example.com/api/v3", source.DefaultClient().APIBaseURL())
+	})
 }
@@ -0,0 +1,69 @@
+package source
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/Masterminds/semver/v3"
+)
+
+// IsVersionRange reports whether ref is a semver range such as ^1.2.0, ~1.2, >=1.0 or *, rather
+// than a branch, tag or commit. Only refs starting with a range operator count, so a tag named
+// v1 or 1.2.x is still taken literally.
+func IsVersionRange(ref string) bool {
+	return ref != "" && strings.ContainsAny(ref[:1], "^~<>=*")
+}
+
+// HighestMatchingTag returns the highest release tag, with or without a leading "v", that
+// satisfies the version range. Pre-releases only match ranges that name one.
+func HighestMatchingTag(versionRange string, tags []string) (string, error) {
+	constraint, err := semver.NewConstraint(versionRange)
+	if err != nil {
+		return "", fmt.Errorf("invalid version range '%s': %w", versionRange, err)
+	}
+	var best *semver.Version
+	bestTag := ""
+	for _, tag := range tags {
+		v, err := semver.StrictNewVersion(strings.TrimPrefix(tag, "v"))
+		if err != nil || !constraint.Check(v) {
+			continue
+		}
+		if best == nil || v.GreaterThan(best) {
+			best, bestTag = v, tag
+		}
+	}
+	if best == nil {
+		return "", fmt.Errorf("no release tag matches '%s'", versionRange)
+	}
+	return bestTag, nil
+}
+
+// ResolveVersionRange resolves a source whose ref is a version range to the highest matching tag
+// of its repository, using the default client.
+func ResolveVersionRange(info *ParsedSourceInfo) (*ParsedSourceInfo, error) {
+	return DefaultClient().ResolveVersionRange(info)
+}
+
+// ResolveVersionRange returns info with Ref and RawURL pointing at the highest tag of the
+// repository that matches info.VersionRange. VersionRange is kept, so callers can still tell
+// the tag was chosen by a range. Sources without a range are returned unchanged.
+func (c *Client) ResolveVersionRange(info *ParsedSourceInfo) (*ParsedSourceInfo, error) {
+	if info.VersionRange == "" {
+		return info, nil
+	}
+	tags, err := c.ListTags(info.Owner, info.Repo)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list tags of %s/%s: %w", info.Owner, info.Repo, err)
+	}
+	tag, err := HighestMatchingTag(info.VersionRange, tags)
+	if err != nil {
+		return nil, fmt.Errorf("%s/%s: %w", info.Owner, info.Repo, err)
+	}
+	resolved, err := ParseSourceURL(fmt.Sprintf("github:%s/%s/%s@%s", info.Owner, info.Repo, info.PathInRepo, tag))
+	if err != nil {
+		return nil, err
+	}
+	resolved.CanonicalURL = info.CanonicalURL
+	resolved.VersionRange = info.VersionRange
+	return resolved, nil
+}
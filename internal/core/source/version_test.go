@@ -0,0 +1,82 @@
+package source_test
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/nightconcept/almandine-go/internal/core/source"
+)
+
+func TestIsVersionRange(t *testing.T) {
+	t.Parallel()
+	for _, ref := range []string{"^1.2.0", "~1.2", ">=1.0.0", "<2", "=1.2.3", "*"} {
+		assert.True(t, source.IsVersionRange(ref), ref)
+	}
+	for _, ref := range []string{"", "main", "v1.2.0", "1.2.x", "v1", "abc1234"} {
+		assert.False(t, source.IsVersionRange(ref), ref)
+	}
+}
+
+func TestParseSourceURL_VersionRange(t *testing.T) {
+	t.Parallel()
+	info, err := source.ParseSourceURL("github:owner/repo/lib.lua@^1.2.0")
+	require.NoError(t, err)
+	assert.Equal(t, "^1.2.0", info.VersionRange)
+	assert.Empty(t, info.Ref, "a range is not a ref")
+	assert.Equal(t, "github:owner/repo/lib.lua@^1.2.0", info.CanonicalURL)
+
+	info, err = source.ParseSourceURL("github:owner/repo/lib.lua@v1.2.0")
+	require.NoError(t, err)
+	assert.Equal(t, "v1.2.0", info.Ref)
+	assert.Empty(t, info.VersionRange)
+}
+
+func TestHighestMatchingTag(t *testing.T) {
+	t.Parallel()
+	tags := []string{"v2.0.0", "v1.10.0", "v1.2.3", "v1.2.0", "1.3.0", "v1.11.0-beta.1", "nightly"}
+
+	for versionRange, want := range map[string]string{
+		"^1.2.0":         "v1.10.0",
+		"~1.2.0":         "v1.2.3",
+		">=1.0.0, <1.3":  "v1.2.3",
+		"*":              "v2.0.0",
+		">=1.11.0-beta":  "v2.0.0",
+		"~1.11.0-beta.0": "v1.11.0-beta.1",
+	} {
+		tag, err := source.HighestMatchingTag(versionRange, tags)
+		require.NoError(t, err, versionRange)
+		assert.Equal(t, want, tag, versionRange)
+	}
+
+	_, err := source.HighestMatchingTag("^3.0.0", tags)
+	assert.ErrorContains(t, err, "no release tag matches '^3.0.0'")
+	_, err = source.HighestMatchingTag("^not-a-version", tags)
+	assert.ErrorContains(t, err, "invalid version range")
+}
+
+func TestResolveVersionRange(t *testing.T) {
+	client := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "/repos/owner/repo/tags", r.URL.Path)
+		_, _ = fmt.Fprint(w, `[{"name": "v2.0.0"}, {"name": "v1.4.2"}, {"name": "v1.2.0"}]`)
+	})
+
+	info, err := source.ParseSourceURL("github:owner/repo/src/lib.lua@^1.2.0")
+	require.NoError(t, err)
+	resolved, err := client.ResolveVersionRange(info)
+	require.NoError(t, err)
+	assert.Equal(t, "v1.4.2", resolved.Ref)
+	assert.Equal(t, "^1.2.0", resolved.VersionRange)
+	assert.True(t, strings.HasSuffix(resolved.RawURL, "/owner/repo/v1.4.2/src/lib.lua"), resolved.RawURL)
+	assert.Equal(t, "github:owner/repo/src/lib.lua@^1.2.0", resolved.CanonicalURL, "the canonical source keeps the range")
+
+	plain, err := source.ParseSourceURL("github:owner/repo/src/lib.lua@main")
+	require.NoError(t, err)
+	unchanged, err := client.ResolveVersionRange(plain)
+	require.NoError(t, err)
+	assert.Same(t, plain, unchanged)
+}
@@ -0,0 +1,101 @@
+// Package updatecache remembers the newest upstream commit last resolved for each dependency,
+// so 'almd list' can hint at available updates without asking the GitHub API itself. The cache
+// is refreshed as a side effect of commands that resolve refs anyway ('almd install' and
+// 'almd outdated') and lives in the project directory, next to almd-lock.toml.
+package updatecache
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/nightconcept/almandine-go/internal/core/fsutil"
+)
+
+// FileName is the cache file in the project root. It is local state and not meant to be
+// committed.
+const FileName = ".almd-updates.json"
+
+// MaxAge is how long a resolved commit is trusted. Older entries are ignored, so a hint never
+// rests on a lookup much older than this.
+var MaxAge = 24 * time.Hour
+
+// Entry is the newest commit resolved for one dependency.
+type Entry struct {
+	// Source is the project.toml source the commit was resolved for; a different source makes
+	// the entry meaningless.
+	Source    string    `json:"source"`
+	LatestSHA string    `json:"latest_sha"`
+	CheckedAt time.Time `json:"checked_at"`
+}
+
+// Cache maps dependency names to the newest commit resolved for them.
+type Cache struct {
+	Dependencies map[string]Entry `json:"dependencies"`
+}
+
+// New returns an empty cache.
+func New() *Cache {
+	return &Cache{Dependencies: make(map[string]Entry)}
+}
+
+// Load reads the cache from projectRoot. A missing file yields an empty cache.
+func Load(projectRoot string) (*Cache, error) {
+	c := New()
+	data, err := os.ReadFile(filepath.Join(projectRoot, FileName))
+	if os.IsNotExist(err) {
+		return c, nil
+	} else if err != nil {
+		return nil, fmt.Errorf("failed to read %s: %w", FileName, err)
+	}
+	if err := json.Unmarshal(data, c); err != nil {
+		return nil, fmt.Errorf("failed to decode %s: %w", FileName, err)
+	}
+	if c.Dependencies == nil {
+		c.Dependencies = make(map[string]Entry)
+	}
+	return c, nil
+}
+
+// Save writes the cache to projectRoot.
+func Save(projectRoot string, c *Cache) error {
+	data, err := json.MarshalIndent(c, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to encode %s: %w", FileName, err)
+	}
+	if err := fsutil.WriteFileAtomic(filepath.Join(projectRoot, FileName), append(data, '\n'), 0644); err != nil {
+		return fmt.Errorf("failed to write %s: %w", FileName, err)
+	}
+	return nil
+}
+
+// Record stores latestSHA as the newest commit of name's declared source, resolved at at.
+func (c *Cache) Record(name, declaredSource, latestSHA string, at time.Time) {
+	c.Dependencies[name] = Entry{Source: declaredSource, LatestSHA: latestSHA, CheckedAt: at.UTC()}
+}
+
+// Prune drops entries for dependencies not in keep.
+func (c *Cache) Prune(keep map[string]bool) {
+	for name := range c.Dependencies {
+		if !keep[name] {
+			delete(c.Dependencies, name)
+		}
+	}
+}
+
+// UpdateAvailable reports whether a fresh entry for name, resolved for declaredSource, names a
+// commit other than lockedSHA. A stale or missing entry, or an empty lockedSHA, reports false.
+// lockedSHA may be abbreviated.
+func (c *Cache) UpdateAvailable(name, declaredSource, lockedSHA string, now time.Time) bool {
+	entry, ok := c.Dependencies[name]
+	if !ok || lockedSHA == "" || entry.LatestSHA == "" || entry.Source != declaredSource {
+		return false
+	}
+	if age := now.Sub(entry.CheckedAt); age < 0 || age >= MaxAge {
+		return false
+	}
+	return !strings.HasPrefix(entry.LatestSHA, lockedSHA)
+}
@@ -0,0 +1,65 @@
+package updatecache
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const (
+	lockedSHA = "1111111111111111111111111111111111111111"
+	newerSHA  = "2222222222222222222222222222222222222222"
+	src       = "github:owner/repo/a.lua@main"
+)
+
+func TestLoadSave(t *testing.T) {
+	dir := t.TempDir()
+
+	c, err := Load(dir)
+	require.NoError(t, err)
+	assert.Empty(t, c.Dependencies, "a missing cache is empty")
+
+	at := time.Date(2026, 1, 2, 3, 4, 5, 0, time.UTC)
+	c.Record("a", src, newerSHA, at)
+	require.NoError(t, Save(dir, c))
+
+	loaded, err := Load(dir)
+	require.NoError(t, err)
+	assert.Equal(t, Entry{Source: src, LatestSHA: newerSHA, CheckedAt: at}, loaded.Dependencies["a"])
+
+	require.NoError(t, os.WriteFile(filepath.Join(dir, FileName), []byte("{not json"), 0644))
+	_, err = Load(dir)
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "failed to decode")
+}
+
+func TestUpdateAvailable(t *testing.T) {
+	now := time.Date(2026, 1, 2, 12, 0, 0, 0, time.UTC)
+	c := &Cache{Dependencies: map[string]Entry{}}
+	c.Record("moved", src, newerSHA, now.Add(-time.Hour))
+	c.Record("current", src, lockedSHA, now.Add(-time.Hour))
+	c.Record("stale", src, newerSHA, now.Add(-MaxAge))
+	c.Record("future", src, newerSHA, now.Add(time.Hour))
+
+	assert.True(t, c.UpdateAvailable("moved", src, lockedSHA, now))
+	assert.False(t, c.UpdateAvailable("moved", src, "", now), "nothing to compare without a locked commit")
+	assert.False(t, c.UpdateAvailable("moved", "github:owner/repo/a.lua@dev", lockedSHA, now), "resolved for another source")
+	assert.False(t, c.UpdateAvailable("current", src, lockedSHA[:7], now), "an abbreviated locked SHA matches")
+	assert.False(t, c.UpdateAvailable("stale", src, lockedSHA, now))
+	assert.False(t, c.UpdateAvailable("future", src, lockedSHA, now), "a clock that went backwards is not trusted")
+	assert.False(t, c.UpdateAvailable("missing", src, lockedSHA, now))
+}
+
+func TestPrune(t *testing.T) {
+	c := &Cache{Dependencies: map[string]Entry{}}
+	c.Record("a", src, newerSHA, time.Now())
+	c.Record("b", src, newerSHA, time.Now())
+
+	c.Prune(map[string]bool{"a": true})
+	assert.Contains(t, c.Dependencies, "a")
+	assert.NotContains(t, c.Dependencies, "b")
+}